@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/config"
+	commandv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/command/v1"
+)
+
+// burstCapture tracks the burst capture started by the latest burst_capture
+// command. It ends on its own once its duration has passed.
+type burstCapture struct {
+	mu       sync.Mutex
+	interval time.Duration
+	until    time.Time
+
+	started chan struct{} // Wakes the report loop when a burst starts
+}
+
+func newBurstCapture() *burstCapture {
+	return &burstCapture{started: make(chan struct{}, 1)}
+}
+
+// Start begins a burst capture, replacing any running one
+func (b *burstCapture) Start(interval, duration time.Duration) {
+	b.mu.Lock()
+	b.interval = interval
+	b.until = time.Now().Add(duration)
+	b.mu.Unlock()
+
+	select {
+	case b.started <- struct{}{}:
+	default:
+	}
+}
+
+// Interval returns the interval of the running burst capture, if any
+func (b *burstCapture) Interval() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.interval == 0 {
+		return 0, false
+	}
+	if time.Now().After(b.until) {
+		log.Printf("Burst capture ended")
+		b.interval = 0
+		return 0, false
+	}
+	return b.interval, true
+}
+
+// startBurstCapture runs a burst_capture command
+func startBurstCapture(bursts *burstCapture, burst *commandv1.BurstCaptureCommand, current *settings, cfg *config.Config) (string, error) {
+	if !cfg.Commands.BurstCapture {
+		return "", fmt.Errorf("burst capture is not enabled on this client")
+	}
+	interval := time.Duration(burst.IntervalSeconds) * time.Second
+	duration := time.Duration(burst.DurationSeconds) * time.Second
+	if interval <= 0 || duration <= 0 {
+		return "", fmt.Errorf("burst capture needs a positive interval and duration")
+	}
+	if reportInterval := current.Interval(); interval >= reportInterval {
+		return "", fmt.Errorf("burst interval %s is not shorter than the report interval %s", interval, reportInterval)
+	}
+	bursts.Start(interval, duration)
+	return fmt.Sprintf("reporting every %s for %s", interval, duration), nil
+}
+
+// reportLoop calls report every interval until ctx is done, and in between
+// while a burst capture runs, with burst set for the extra reports. A new
+// interval applies from the last report.
+func reportLoop(ctx context.Context, current *settings, bursts *burstCapture, report func(burst bool)) {
+	var lastReport time.Time
+	var nextBurst time.Time
+	for {
+		now := time.Now()
+		interval := current.Interval()
+		nextReport := lastReport.Add(interval)
+		burstInterval, bursting := bursts.Interval()
+		switch {
+		case !now.Before(nextReport):
+			report(false)
+			// Skip reports missed while one ran long rather than catching up
+			lastReport = nextReport
+			if lastReport.Add(interval).Before(now) {
+				lastReport = now
+			}
+			// A regular report stands in for the burst report due with it
+			nextBurst = now.Add(burstInterval)
+			continue
+		case bursting && !now.Before(nextBurst):
+			report(true)
+			nextBurst = now.Add(burstInterval)
+			continue
+		}
+
+		wait := time.Until(nextReport)
+		if bursting {
+			wait = min(wait, time.Until(nextBurst))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-bursts.started:
+			timer.Stop()
+			nextBurst = time.Time{}
+		case <-current.changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	"github.com/nickheyer/jacuzzi/pkg/client/config"
+	climon "github.com/nickheyer/jacuzzi/pkg/client/monitor"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	commandv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/command/v1"
+	"github.com/nickheyer/jacuzzi/pkg/systemlog"
+	"github.com/spf13/cobra"
+)
+
+// Capabilities reported to the server for the commands this client accepts
+const (
+	capabilityFanControl   = "fan_control"
+	capabilityLocalActions = "local_actions"
+	capabilityBurstCapture = "burst_capture"
+	capabilityRemoteConfig = "remote_config"
+	capabilityEventLog     = "event_log"
+)
+
+// maxActionOutput bounds the action output reported to the server
+const maxActionOutput = 4096
+
+// defaultEventID is the event ID of log_event commands that set none
+const defaultEventID = 100
+
+var fansCmd = &cobra.Command{
+	Use:   "fans",
+	Short: "List PWM fans that fan control commands can set",
+	Args:  cobra.NoArgs,
+	RunE:  listFans,
+}
+
+func listFans(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	fans, err := climon.NewFanControllerAt(hostEnvironment(cfg).SysPath()).GetFans()
+	if err != nil {
+		return fmt.Errorf("failed to get fans: %w", err)
+	}
+
+	type fanOutput struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		PWM    int    `json:"pwm"`
+		Manual bool   `json:"manual"`
+		RPM    int    `json:"rpm"`
+	}
+	output := make([]fanOutput, len(fans))
+	for i, fan := range fans {
+		output[i] = fanOutput{
+			ID:     fan.ID,
+			Name:   fan.Name,
+			PWM:    fan.PWM,
+			Manual: fan.Manual,
+			RPM:    fan.RPM,
+		}
+	}
+
+	return cli.Print(cmd, output, func(w io.Writer) error {
+		fmt.Fprintln(w, "ID\tNAME\tPWM\tMODE\tRPM")
+		for _, fan := range output {
+			mode := "auto"
+			if fan.Manual {
+				mode = "manual"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", fan.ID, fan.Name, fan.PWM, mode, fan.RPM)
+		}
+		return nil
+	})
+}
+
+// capabilities lists the commands this client has opted in to
+func capabilities(cfg *config.Config) []string {
+	var caps []string
+	if cfg.Commands.FanControl {
+		caps = append(caps, capabilityFanControl)
+	}
+	if len(cfg.Commands.LocalActions) > 0 {
+		caps = append(caps, capabilityLocalActions)
+	}
+	if cfg.Commands.BurstCapture {
+		caps = append(caps, capabilityBurstCapture)
+	}
+	if cfg.Commands.RemoteConfig {
+		caps = append(caps, capabilityRemoteConfig)
+	}
+	if cfg.Commands.EventLog {
+		caps = append(caps, capabilityEventLog)
+	}
+	return caps
+}
+
+// localActions lists the names of the configured local actions
+func localActions(cfg *config.Config) []string {
+	names := make([]string, 0, len(cfg.Commands.LocalActions))
+	for name := range cfg.Commands.LocalActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runCommands polls the server for commands and runs them, and keeps fans
+// that follow a curve in step with their sensors
+func runCommands(ctx context.Context, client jacuzziv1.CommandServiceClient, bursts *burstCapture, current *settings, clientID, sysPath string, cfg *config.Config) {
+	fans := climon.NewFanControllerAt(sysPath)
+	tempMonitor := climon.NewTemperatureMonitorAt(sysPath)
+
+	ticker := time.NewTicker(cfg.Commands.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := pollCommands(ctx, client, fans, bursts, current, clientID, cfg); err != nil {
+			log.Printf("Error polling commands: %v", err)
+		}
+
+		sensors, err := tempMonitor.GetTemperatures()
+		if err != nil {
+			log.Printf("Error reading temperatures for fan curves: %v", err)
+		} else if err := fans.Apply(sensors); err != nil {
+			log.Printf("Error applying fan curves: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollCommands runs the pending commands for this client and reports each
+// result
+func pollCommands(ctx context.Context, client jacuzziv1.CommandServiceClient, fans *climon.FanController, bursts *burstCapture, current *settings, clientID string, cfg *config.Config) error {
+	pollCtx, cancel := context.WithTimeout(ctx, cfg.Server.Timeout)
+	defer cancel()
+	resp, err := client.PollCommands(pollCtx, &commandv1.PollCommandsRequest{ClientId: clientID})
+	if err != nil {
+		return err
+	}
+
+	for _, command := range resp.Commands {
+		message, err := runCommand(command, fans, bursts, current, cfg)
+		if err != nil {
+			message = err.Error()
+			log.Printf("Command %s failed: %v", command.Id, err)
+		} else {
+			log.Printf("Command %s: %s", command.Id, message)
+		}
+
+		reportCtx, cancel := context.WithTimeout(ctx, cfg.Server.Timeout)
+		_, reportErr := client.ReportCommandResult(reportCtx, &commandv1.ReportCommandResultRequest{
+			ClientId:  clientID,
+			CommandId: command.Id,
+			Success:   err == nil,
+			Message:   message,
+		})
+		cancel()
+		if reportErr != nil {
+			log.Printf("Failed to report result of command %s: %v", command.Id, reportErr)
+		}
+	}
+	return nil
+}
+
+// runCommand runs one command, refusing kinds this client has not opted in to
+func runCommand(command *commandv1.Command, fans *climon.FanController, bursts *burstCapture, current *settings, cfg *config.Config) (string, error) {
+	switch action := command.Action.(type) {
+	case *commandv1.Command_SetFan:
+		if !cfg.Commands.FanControl {
+			return "", fmt.Errorf("fan control is not enabled on this client")
+		}
+		return setFan(fans, action.SetFan)
+	case *commandv1.Command_RunAction:
+		return runLocalAction(command, action.RunAction, cfg)
+	case *commandv1.Command_BurstCapture:
+		return startBurstCapture(bursts, action.BurstCapture, current, cfg)
+	case *commandv1.Command_ApplyConfig:
+		return current.Apply(action.ApplyConfig.Config, action.ApplyConfig.Revision)
+	case *commandv1.Command_LogEvent:
+		if !cfg.Commands.EventLog {
+			return "", fmt.Errorf("event log entries are not enabled on this client")
+		}
+		return logEvent(action.LogEvent)
+	default:
+		return "", fmt.Errorf("unsupported command")
+	}
+}
+
+func setFan(fans *climon.FanController, fan *commandv1.SetFanCommand) (string, error) {
+	switch {
+	case fan.Automatic:
+		if err := fans.SetAutomatic(fan.FanId); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("fan %s returned to automatic control", fan.FanId), nil
+	case len(fan.Curve) > 0:
+		points := make([]climon.CurvePoint, len(fan.Curve))
+		for i, point := range fan.Curve {
+			points[i] = climon.CurvePoint{TempCelsius: point.TemperatureCelsius, PWM: int(point.Pwm)}
+		}
+		if err := fans.SetCurve(fan.FanId, fan.SensorId, points); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("fan %s following sensor %s", fan.FanId, fan.SensorId), nil
+	default:
+		if err := fans.SetPWM(fan.FanId, int(fan.Pwm)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("fan %s set to pwm %d", fan.FanId, fan.Pwm), nil
+	}
+}
+
+// logEventLevels maps command levels to system log levels
+var logEventLevels = map[commandv1.LogEventCommand_Level]systemlog.Level{
+	commandv1.LogEventCommand_LEVEL_UNSPECIFIED: systemlog.Info,
+	commandv1.LogEventCommand_LEVEL_INFO:        systemlog.Info,
+	commandv1.LogEventCommand_LEVEL_WARNING:     systemlog.Warning,
+	commandv1.LogEventCommand_LEVEL_CRITICAL:    systemlog.Critical,
+}
+
+func logEvent(event *commandv1.LogEventCommand) (string, error) {
+	eventID := event.EventId
+	if eventID == 0 {
+		eventID = defaultEventID
+	}
+	err := systemlog.Write(systemlog.Entry{
+		Source:  event.Source,
+		Level:   logEventLevels[event.Level],
+		EventID: eventID,
+		Message: event.Message,
+		Fields:  event.Fields,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("event %d written to the system log", eventID), nil
+}
+
+// runLocalAction runs a configured local action and records it in the audit
+// log. Actions are looked up by name, so the server can only run what this
+// client's configuration allows.
+func runLocalAction(command *commandv1.Command, action *commandv1.RunActionCommand, cfg *config.Config) (string, error) {
+	script, ok := cfg.Commands.LocalActions[action.Name]
+	if !ok {
+		return "", fmt.Errorf("local action %q is not configured on this client", action.Name)
+	}
+	audit(cfg, "starting action %q for command %s (source %s, reason %q): %s", action.Name, command.Id, command.Source, action.Reason, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Commands.ActionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	cmd.Env = append(os.Environ(),
+		"JACUZZI_ACTION_REASON="+action.Reason,
+		"JACUZZI_COMMAND_ID="+command.Id,
+	)
+	output, err := cmd.CombinedOutput()
+	result := strings.TrimSpace(string(output))
+	if len(result) > maxActionOutput {
+		result = result[len(result)-maxActionOutput:]
+	}
+
+	if err != nil {
+		if result != "" {
+			err = fmt.Errorf("%v: %s", err, result)
+		}
+		audit(cfg, "action %q for command %s failed: %v", action.Name, command.Id, err)
+		return "", fmt.Errorf("action %q failed: %v", action.Name, err)
+	}
+	audit(cfg, "action %q for command %s succeeded: %s", action.Name, command.Id, result)
+	if result == "" {
+		result = fmt.Sprintf("action %q succeeded", action.Name)
+	}
+	return result, nil
+}
+
+// audit logs a local action event, appending it to the audit file when one
+// is configured
+func audit(cfg *config.Config, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("Audit: %s", message)
+	if cfg.Commands.AuditFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(cfg.Commands.AuditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to open audit file: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", time.Now().UTC().Format(time.RFC3339), message); err != nil {
+		log.Printf("Failed to write audit file: %v", err)
+	}
+}
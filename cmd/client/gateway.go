@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/config"
+	"github.com/nickheyer/jacuzzi/pkg/client/gateway"
+)
+
+// startGateway starts the configured inputs that accept readings from other
+// devices, returning the collector they record them in
+func startGateway(ctx context.Context, cfg *config.Config, hostname string) *gateway.Collector {
+	collector := gateway.NewCollector(cfg.Gateway.StaleAfter)
+
+	if cfg.Gateway.HTTPListen != "" {
+		go func() {
+			if err := collector.ServeHTTP(ctx, cfg.Gateway.HTTPListen, cfg.Gateway.HTTPToken); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		}()
+	}
+	if mqtt := cfg.Gateway.MQTT; mqtt.Broker != "" {
+		clientID := mqtt.ClientID
+		if clientID == "" {
+			clientID = "jacuzzi-gateway-" + hostname
+		}
+		go collector.ReadMQTT(ctx, gateway.MQTTConfig{
+			Broker:   mqtt.Broker,
+			Topic:    mqtt.Topic,
+			Username: mqtt.Username,
+			Password: mqtt.Password,
+			ClientID: clientID,
+		})
+	}
+	for _, device := range cfg.Gateway.SerialDevices {
+		go collector.ReadSerial(ctx, device, cfg.Gateway.BaudRate)
+	}
+
+	log.Printf("Gateway mode: forwarding readings from other devices, dropping sensors silent for %s", cfg.Gateway.StaleAfter)
+	return collector
+}
@@ -3,21 +3,38 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"runtime"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/cli"
 	"github.com/nickheyer/jacuzzi/pkg/client/config"
+	"github.com/nickheyer/jacuzzi/pkg/client/host"
+	"github.com/nickheyer/jacuzzi/pkg/client/httpconn"
+	"github.com/nickheyer/jacuzzi/pkg/client/identity"
 	climon "github.com/nickheyer/jacuzzi/pkg/client/monitor"
 	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
 	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/mdns"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// apiKeyHeader is the metadata key the server reads the client API key from
+const apiKeyHeader = "x-api-key"
+
 var (
 	cfgFile string
 	rootCmd = &cobra.Command{
@@ -35,26 +52,93 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.jacuzzi/client.yaml)")
 
 	// Server flags
-	rootCmd.Flags().String("server", "localhost:50051", "The server address")
+	rootCmd.Flags().String("server", "localhost:50051", `The server address, or "auto" to find it on the local network over mDNS`)
 	rootCmd.Flags().Duration("timeout", 10*time.Second, "Connection timeout")
+	rootCmd.Flags().String("transport", config.TransportGRPC, `How to reach the server: grpc, or http to use its JSON API, with --server its HTTP address or URL`)
 
 	// Client flags
-	rootCmd.Flags().String("client-id", "", "Client ID (defaults to hostname)")
+	rootCmd.Flags().String("client-id", "", "Client ID (defaults to a generated UUID kept in the identity file)")
+	rootCmd.Flags().String("identity-file", "", "File holding the generated client ID (default is $HOME/.jacuzzi/client_id)")
+	rootCmd.Flags().String("enrollment-token", "", "Enrollment token used to obtain a client ID and API key on first run")
+	rootCmd.Flags().String("api-key-file", "", "File holding the API key issued at enrollment (default is $HOME/.jacuzzi/api_key)")
 	rootCmd.Flags().Duration("interval", 30*time.Second, "Temperature reading interval")
+	rootCmd.Flags().Duration("sample-interval", 0, "Sample sensors this often and report each interval's range (0 samples once per report)")
 
 	// Monitoring flags
 	rootCmd.Flags().Bool("monitor-cpu", true, "Monitor CPU temperatures")
 	rootCmd.Flags().Bool("monitor-gpu", true, "Monitor GPU temperatures")
 	rootCmd.Flags().Bool("monitor-disk", true, "Monitor disk temperatures")
+	rootCmd.PersistentFlags().Bool("mock", false, "Report synthetic sensors instead of reading hwmon, for development")
+
+	// Container flags
+	rootCmd.PersistentFlags().String("host-root", "", "Where the host's root filesystem is mounted when running in a container")
+	rootCmd.PersistentFlags().String("sys-path", "", "Where the host's /sys is mounted (default is <host-root>/sys)")
+	rootCmd.Flags().String("node-name", "", "Kubernetes node name, for DaemonSet deployments")
 
 	// Bind flags to viper
 	viper.BindPFlag("server.address", rootCmd.Flags().Lookup("server"))
 	viper.BindPFlag("server.timeout", rootCmd.Flags().Lookup("timeout"))
+	viper.BindPFlag("server.transport", rootCmd.Flags().Lookup("transport"))
 	viper.BindPFlag("client.id", rootCmd.Flags().Lookup("client-id"))
+	viper.BindPFlag("client.identity_file", rootCmd.Flags().Lookup("identity-file"))
+	viper.BindPFlag("client.enrollment_token", rootCmd.Flags().Lookup("enrollment-token"))
+	viper.BindPFlag("client.api_key_file", rootCmd.Flags().Lookup("api-key-file"))
 	viper.BindPFlag("client.interval", rootCmd.Flags().Lookup("interval"))
+	viper.BindPFlag("client.sample_interval", rootCmd.Flags().Lookup("sample-interval"))
 	viper.BindPFlag("monitoring.cpu", rootCmd.Flags().Lookup("monitor-cpu"))
 	viper.BindPFlag("monitoring.gpu", rootCmd.Flags().Lookup("monitor-gpu"))
 	viper.BindPFlag("monitoring.disk", rootCmd.Flags().Lookup("monitor-disk"))
+	viper.BindPFlag("monitoring.mock.enabled", rootCmd.PersistentFlags().Lookup("mock"))
+	viper.BindPFlag("host.root", rootCmd.PersistentFlags().Lookup("host-root"))
+	viper.BindPFlag("host.sys_path", rootCmd.PersistentFlags().Lookup("sys-path"))
+	viper.BindPFlag("host.node_name", rootCmd.Flags().Lookup("node-name"))
+
+	cli.AddOutputFlag(rootCmd)
+	rootCmd.AddCommand(sensorsCmd)
+	rootCmd.AddCommand(fansCmd)
+	rootCmd.AddCommand(cli.NewCompletionCmd(rootCmd.Name()))
+}
+
+var sensorsCmd = &cobra.Command{
+	Use:   "sensors",
+	Short: "List temperature sensors detected on this machine",
+	Args:  cobra.NoArgs,
+	RunE:  listSensors,
+}
+
+func listSensors(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	sensors, err := withProbes(context.Background(), cfg, temperatureSource(cfg, hostEnvironment(cfg))).GetTemperatures()
+	if err != nil {
+		return fmt.Errorf("failed to get temperatures: %w", err)
+	}
+
+	type sensorOutput struct {
+		ID                 string  `json:"id"`
+		Type               string  `json:"type"`
+		Name               string  `json:"name"`
+		TemperatureCelsius float64 `json:"temperature_celsius"`
+	}
+	output := make([]sensorOutput, len(sensors))
+	for i, sensor := range sensors {
+		output[i] = sensorOutput{
+			ID:                 sensor.ID,
+			Type:               sensor.Type,
+			Name:               sensor.Name,
+			TemperatureCelsius: sensor.TempCelsius(),
+		}
+	}
+
+	return cli.Print(cmd, output, func(w io.Writer) error {
+		fmt.Fprintln(w, "ID\tTYPE\tNAME\tTEMP (°C)")
+		for _, sensor := range output {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\n", sensor.ID, sensor.Type, sensor.Name, sensor.TemperatureCelsius)
+		}
+		return nil
+	})
 }
 
 func initConfig() {
@@ -77,94 +161,341 @@ func runClient(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	// Set client ID
+	env := hostEnvironment(cfg)
+	hostname, err := env.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname: %w", err)
+	}
+	if labels := env.ReadLabels(context.Background()); len(labels) > 0 {
+		log.Printf("Reporting labels: %v", labels)
+	}
+
+	// Use the configured or previously saved client ID and API key
 	clientID := cfg.Client.ID
 	if clientID == "" {
-		hostname, err := os.Hostname()
+		clientID, err = identity.Load(cfg.Client.IdentityFile)
 		if err != nil {
-			return fmt.Errorf("failed to get hostname: %w", err)
+			return err
 		}
-		clientID = hostname
+	}
+	// A DaemonSet pod's identity file is lost with the pod, so the node it
+	// runs on decides its ID instead
+	if clientID == "" && cfg.Client.EnrollmentToken == "" {
+		clientID = env.NodeClientID()
+	}
+	apiKey, err := identity.ReadSecret(cfg.Client.APIKeyFile)
+	if err != nil {
+		return err
 	}
 
 	// Connect to server
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Timeout)
-	defer cancel()
-
-	conn, err := grpc.DialContext(ctx, cfg.Server.Address, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	conn, err := connect(cfg, &apiKey)
 	if err != nil {
-		return fmt.Errorf("failed to connect to server: %w", err)
+		return err
 	}
 	defer conn.Close()
 
+	// On first run, enroll with a token if one was provided, otherwise
+	// generate a persistent client ID
+	if clientID == "" && cfg.Client.EnrollmentToken != "" {
+		clientID, apiKey, err = enrollClient(jacuzziv1.NewClientServiceClient(conn), hostname, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	// Before IDs were persisted the hostname was the ID, so a first run offers
+	// it to the server in case this is an upgraded install
+	legacyID := ""
+	if clientID == "" {
+		clientID, err = identity.LoadOrCreate(cfg.Client.IdentityFile)
+		if err != nil {
+			return err
+		}
+		legacyID = hostname
+	}
+
+	clientID, err = registerClient(jacuzziv1.NewClientServiceClient(conn), clientID, legacyID, hostname, &apiKey, env, cfg)
+	if err != nil {
+		return err
+	}
+
 	client := jacuzziv1.NewTemperatureServiceClient(conn)
-	tempMonitor := climon.NewTemperatureMonitor()
+	tempMonitor := withProbes(context.Background(), cfg, temperatureSource(cfg, env))
 
-	log.Printf("Starting temperature monitoring client (ID: %s)", clientID)
-	log.Printf("Reporting to server: %s", cfg.Server.Address)
+	log.Printf("Starting temperature monitoring client (ID: %s, hostname: %s)", clientID, hostname)
+	log.Printf("Reporting to server: %s over %s", cfg.Server.Address, cfg.Server.Transport)
 	log.Printf("Update interval: %s", cfg.Client.Interval)
 	log.Printf("Monitoring: CPU=%v, GPU=%v, Disk=%v", cfg.Monitoring.CPU, cfg.Monitoring.GPU, cfg.Monitoring.Disk)
+	if cfg.Monitoring.Mock.Enabled {
+		log.Printf("Reporting %d mock sensors instead of hwmon", cfg.Monitoring.Mock.Sensors)
+	}
+	if env.InContainer() {
+		log.Printf("Running in a %s container, reading sensors from %s", env.Runtime, env.SysPath())
+		if !cfg.Monitoring.Mock.Enabled && !env.HasHwmon() {
+			log.Printf("Warning: no hwmon devices under %s; mount the host's /sys read-only and set --sys-path", env.SysPath())
+		}
+	}
+
+	// Forward readings from other devices along with this machine's own
+	if cfg.Gateway.Enabled() {
+		tempMonitor = climon.Combine(tempMonitor, startGateway(context.Background(), cfg, hostname))
+	}
 
-	// Main monitoring loop
-	ticker := time.NewTicker(cfg.Client.Interval)
-	defer ticker.Stop()
+	// Poll for commands only when some are accepted
+	bursts := newBurstCapture()
+	current := newSettings(cfg)
+	if caps := capabilities(cfg); len(caps) > 0 {
+		log.Printf("Accepting commands: %v, local actions: %v", caps, localActions(cfg))
+		go runCommands(context.Background(), jacuzziv1.NewCommandServiceClient(conn), bursts, current, clientID, env.SysPath(), cfg)
+	}
 
-	// Run immediately on start
-	if err := collectAndSendTemperatures(context.Background(), client, tempMonitor, clientID, cfg); err != nil {
-		log.Printf("Error sending temperatures: %v", err)
+	// Sample between reports when sampling more often than reporting
+	var sampler *climon.Sampler
+	if cfg.Client.SampleInterval > 0 {
+		log.Printf("Sampling every %s, reporting the range of each interval", cfg.Client.SampleInterval)
+		sampler = climon.NewSampler(tempMonitor)
+		go sampler.Run(context.Background(), cfg.Client.SampleInterval)
 	}
 
-	for range ticker.C {
-		if err := collectAndSendTemperatures(context.Background(), client, tempMonitor, clientID, cfg); err != nil {
+	// Main monitoring loop, reporting immediately on start
+	reportLoop(context.Background(), current, bursts, func(burst bool) {
+		if err := collectAndSendTemperatures(context.Background(), client, tempMonitor, sampler, current, burst, clientID, cfg); err != nil {
 			log.Printf("Error sending temperatures: %v", err)
 		}
-	}
+	})
 
 	return nil
 }
 
-func collectAndSendTemperatures(ctx context.Context, client jacuzziv1.TemperatureServiceClient, monitor *climon.TemperatureMonitor, clientID string, cfg *config.Config) error {
-	// Collect temperature readings
-	sensors, err := monitor.GetTemperatures()
+// serverConn is a connection to the server over either transport
+type serverConn interface {
+	grpc.ClientConnInterface
+	Close() error
+}
+
+// connect opens a connection to the server that sends the client's API key,
+// once it has one, with every call. A gRPC connection waits until the server
+// is reachable; the HTTP transport connects on each call.
+func connect(cfg *config.Config, apiKey *string) (serverConn, error) {
+	if cfg.Server.Transport == config.TransportHTTP {
+		return httpconn.New(cfg.Server.Address, apiKeyInterceptor(apiKey))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Timeout)
+	defer cancel()
+
+	// With the address "auto", the server is found over mDNS, and found again
+	// whenever the connection to it is lost
+	target := cfg.Server.Address
+	var resolvers []resolver.Builder
+	if target == config.AutoAddress {
+		target = mdns.Scheme + ":///"
+		resolvers = append(resolvers, mdns.NewResolverBuilder())
+	}
+	conn, err := grpc.DialContext(ctx, target,
+		grpc.WithResolvers(resolvers...),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.Server.KeepaliveTime,
+			Timeout:             cfg.Server.KeepaliveTimeout,
+			PermitWithoutStream: cfg.Server.KeepalivePermitWithoutStream,
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(cfg.Server.MaxRecvMsgSize),
+			grpc.MaxCallSendMsgSize(cfg.Server.MaxSendMsgSize),
+		),
+		grpc.WithUnaryInterceptor(apiKeyInterceptor(apiKey)),
+		grpc.WithBlock(),
+	)
 	if err != nil {
-		return fmt.Errorf("failed to get temperatures: %w", err)
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	return conn, nil
+}
+
+// hostEnvironment detects whether the client runs in a container and where
+// the host's filesystem is mounted
+func hostEnvironment(cfg *config.Config) *host.Environment {
+	return host.Detect(host.Config{
+		Root:         cfg.Host.Root,
+		SysPath:      cfg.Host.SysPath,
+		NodeName:     cfg.Host.NodeName,
+		PodName:      cfg.Host.PodName,
+		PodNamespace: cfg.Host.PodNamespace,
+		Cloud:        cfg.Host.Cloud,
+		MachineInfo:  cfg.Host.MachineInfo,
+	})
+}
+
+// temperatureSource returns the mock sensors when enabled, otherwise hwmon
+func temperatureSource(cfg *config.Config, env *host.Environment) climon.Source {
+	mock := cfg.Monitoring.Mock
+	if !mock.Enabled {
+		return climon.NewTemperatureMonitorAt(env.SysPath())
+	}
+	return climon.NewMockMonitor(climon.MockConfig{
+		Sensors:       mock.Sensors,
+		BaseCelsius:   mock.BaseCelsius,
+		Drift:         mock.Drift,
+		Noise:         mock.Noise,
+		SpikeInterval: mock.SpikeInterval,
+		SpikeDuration: mock.SpikeDuration,
+		SpikeCelsius:  mock.SpikeCelsius,
+	})
+}
+
+// enrollClient exchanges an enrollment token for a client ID and API key and
+// saves both so later runs reuse them
+func enrollClient(client jacuzziv1.ClientServiceClient, hostname string, cfg *config.Config) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Timeout)
+	defer cancel()
+
+	resp, err := client.EnrollClient(ctx, &clientv1.EnrollClientRequest{
+		Token:     cfg.Client.EnrollmentToken,
+		Hostname:  hostname,
+		MachineId: identity.MachineID(cfg.Host.Root),
+		Os:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to enroll client: %w", err)
+	}
+
+	if err := identity.WriteSecret(cfg.Client.APIKeyFile, resp.ApiKey); err != nil {
+		return "", "", err
+	}
+	if err := identity.WriteSecret(cfg.Client.IdentityFile, resp.ClientId); err != nil {
+		return "", "", err
 	}
 
-	if len(sensors) == 0 {
+	log.Printf("Enrolled as client %s", resp.ClientId)
+	return resp.ClientId, resp.ApiKey, nil
+}
+
+// apiKeyInterceptor attaches the client's API key, once it has one, to every call
+func apiKeyInterceptor(apiKey *string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if *apiKey != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, apiKeyHeader, *apiKey)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// registerClient announces the client's identity to the server. A server that
+// already has this ID from another machine rejects it, which usually means the
+// identity file was copied along with a disk image. It returns the ID the
+// server registered, which is legacyID when the server kept the client this
+// install had before upgrading; that ID is saved in place of the generated one.
+// An API key issued for polling commands is saved and used from then on.
+func registerClient(client jacuzziv1.ClientServiceClient, clientID, legacyID, hostname string, apiKey *string, env *host.Environment, cfg *config.Config) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Timeout)
+	defer cancel()
+
+	resp, err := client.RegisterClient(ctx, &clientv1.RegisterClientRequest{
+		ClientId:  clientID,
+		Hostname:  hostname,
+		MachineId: identity.MachineID(cfg.Host.Root),
+		Os:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+
+		Capabilities:   capabilities(cfg),
+		LocalActions:   localActions(cfg),
+		Metadata:       env.Metadata(),
+		LegacyClientId: legacyID,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unimplemented:
+			log.Printf("Server does not support client registration, continuing")
+			return clientID, nil
+		case codes.AlreadyExists:
+			return "", fmt.Errorf("%s; remove %s to generate a new client ID", status.Convert(err).Message(), cfg.Client.IdentityFile)
+		}
+		return "", fmt.Errorf("failed to register client: %w", err)
+	}
+
+	log.Println(resp.Message)
+	if resp.ApiKey != "" {
+		if err := identity.WriteSecret(cfg.Client.APIKeyFile, resp.ApiKey); err != nil {
+			return "", err
+		}
+		*apiKey = resp.ApiKey
+		log.Printf("Saved the API key issued for commands to %s", cfg.Client.APIKeyFile)
+	}
+	if registered := resp.GetClient().GetId(); registered != "" && registered != clientID {
+		if err := identity.WriteSecret(cfg.Client.IdentityFile, registered); err != nil {
+			return "", err
+		}
+		log.Printf("Keeping client ID %s from before the upgrade", registered)
+		return registered, nil
+	}
+	return clientID, nil
+}
+
+func collectAndSendTemperatures(ctx context.Context, client jacuzziv1.TemperatureServiceClient, monitor climon.Source, sampler *climon.Sampler, current *settings, burst bool, clientID string, cfg *config.Config) error {
+	// Collect temperature readings, summarizing the samples taken since the
+	// last report when sampling. Burst reports read the sensors directly so
+	// the regular reports keep summarizing their whole interval.
+	var windows []climon.Window
+	if sampler != nil && !burst {
+		var err error
+		windows, err = sampler.Flush()
+		if err != nil {
+			return fmt.Errorf("failed to get temperatures: %w", err)
+		}
+	} else {
+		sensors, err := monitor.GetTemperatures()
+		if err != nil {
+			return fmt.Errorf("failed to get temperatures: %w", err)
+		}
+		for _, sensor := range sensors {
+			celsius := sensor.TempCelsius()
+			windows = append(windows, climon.Window{Sensor: sensor, Min: celsius, Max: celsius, Avg: celsius, Samples: 1})
+		}
+	}
+
+	if len(windows) == 0 {
 		log.Println("No temperature sensors found")
 		return nil
 	}
 
 	// Filter sensors based on configuration
-	var filteredSensors []climon.TemperatureSensor
-	for _, sensor := range sensors {
-		include := false
-		switch sensor.Type {
-		case "CPU":
-			include = cfg.Monitoring.CPU
-		case "GPU":
-			include = cfg.Monitoring.GPU
-		case "DISK":
-			include = cfg.Monitoring.Disk
-		default:
-			// Include other sensor types by default
-			include = true
-		}
-		if include {
-			filteredSensors = append(filteredSensors, sensor)
-		}
-	}
-
-	if len(filteredSensors) == 0 {
+	var filtered []climon.Window
+	for _, window := range windows {
+		if current.Include(window.Sensor.Type) {
+			filtered = append(filtered, window)
+		}
+	}
+
+	if len(filtered) == 0 {
 		log.Println("No sensors to report after filtering")
 		return nil
 	}
 
+	if !burst {
+		current.CheckThresholds(filtered)
+
+		sensors := len(filtered)
+		var heartbeat bool
+		filtered, heartbeat = current.Prefilter(filtered, time.Now())
+		switch {
+		case heartbeat:
+			log.Printf("Sending heartbeat of %d sensors", len(filtered))
+		case len(filtered) < sensors:
+			log.Printf("Holding back %d sensors below their report thresholds", sensors-len(filtered))
+		}
+		if len(filtered) == 0 {
+			return nil
+		}
+	}
+
 	// Convert to protobuf format
-	readings := make([]*temperaturev1.TemperatureReading, len(filteredSensors))
+	readings := make([]*temperaturev1.TemperatureReading, len(filtered))
 	timestamp := timestamppb.Now()
 
-	for i, sensor := range filteredSensors {
+	for i, window := range filtered {
+		sensor := window.Sensor
 		readings[i] = &temperaturev1.TemperatureReading{
 			SensorId:           sensor.ID,
 			ClientId:           clientID,
@@ -172,25 +503,49 @@ func collectAndSendTemperatures(ctx context.Context, client jacuzziv1.Temperatur
 			Timestamp:          timestamp,
 			SensorType:         sensor.Type,
 			SensorName:         sensor.Name,
+			Burst:              burst,
+		}
+		if burst {
+			continue
+		}
+		if window.Samples > 1 {
+			readings[i].Summary = &temperaturev1.ReadingSummary{
+				MinCelsius:  window.Min,
+				MaxCelsius:  window.Max,
+				AvgCelsius:  window.Avg,
+				SampleCount: int32(window.Samples),
+			}
+			log.Printf("Sensor %s (%s): %.1f°C, %.1f-%.1f°C over %d samples", sensor.Name, sensor.Type, sensor.TempCelsius(), window.Min, window.Max, window.Samples)
+			continue
 		}
 		log.Printf("Sensor %s (%s): %.1f°C", sensor.Name, sensor.Type, sensor.TempCelsius())
 	}
 
-	// Send to server
-	req := &temperaturev1.SubmitTemperatureRequest{
-		Readings: readings,
+	// Send to server in batches
+	batchSize := cfg.Client.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(readings)
 	}
+	for start := 0; start < len(readings); start += batchSize {
+		end := min(start+batchSize, len(readings))
+		req := &temperaturev1.SubmitTemperatureRequest{
+			Readings: readings[start:end],
+			BatchId:  uuid.New().String(),
+		}
 
-	resp, err := client.SubmitTemperature(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to submit temperatures: %w", err)
-	}
+		resp, err := client.SubmitTemperature(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to submit temperatures: %w", err)
+		}
 
-	if !resp.Success {
-		return fmt.Errorf("server returned failure: %s", resp.Message)
+		if !resp.Success {
+			return fmt.Errorf("server returned failure: %s", resp.Message)
+		}
 	}
 
-	log.Printf("Successfully sent %d temperature readings", len(readings))
+	if !burst {
+		log.Printf("Successfully sent %d temperature readings", len(readings))
+	}
 	return nil
 }
 
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/config"
+	"github.com/nickheyer/jacuzzi/pkg/client/modbus"
+	climon "github.com/nickheyer/jacuzzi/pkg/client/monitor"
+	"github.com/nickheyer/jacuzzi/pkg/client/serial"
+)
+
+// withProbes adds the configured USB and serial thermometers and Modbus
+// devices to source, reading serial probes in the background until ctx is done
+func withProbes(ctx context.Context, cfg *config.Config, source climon.Source) climon.Source {
+	if len(cfg.Monitoring.Probes) == 0 && len(cfg.Monitoring.Modbus) == 0 {
+		return source
+	}
+	sources := []climon.Source{source}
+	for _, probe := range cfg.Monitoring.Probes {
+		sensor := climon.TemperatureSensor{ID: probe.ID, Type: probe.Type, Name: probe.Name}
+		switch probe.Kind {
+		case config.ProbeTEMPer:
+			sources = append(sources, climon.NewTEMPerProbe(probe.Device, probe.Format, sensor))
+		case config.ProbeSerial:
+			// The pattern was checked when the configuration was loaded
+			pattern := regexp.MustCompile(probe.Pattern)
+			serialProbe := climon.NewSerialProbe(probe.Device, probe.BaudRate, pattern, strings.EqualFold(probe.Unit, "F"), sensor)
+			go serialProbe.Run(ctx)
+			sources = append(sources, serialProbe)
+		}
+		log.Printf("Reading %s probe %s as sensor %s", probe.Kind, probe.Device, probe.ID)
+	}
+	for _, device := range cfg.Monitoring.Modbus {
+		sources = append(sources, modbusMonitor(device))
+	}
+	return climon.Combine(sources...)
+}
+
+// modbusMonitor polls the registers of a Modbus device
+func modbusMonitor(device config.ModbusConfig) *climon.ModbusMonitor {
+	var client *modbus.Client
+	if device.Device != "" {
+		client = modbus.NewRTU(device.Device, serial.Config{
+			Baud:     device.BaudRate,
+			Parity:   device.Parity[0],
+			StopBits: device.StopBits,
+		}, device.Timeout)
+	} else {
+		client = modbus.NewTCP(device.Address, device.Timeout)
+	}
+
+	registers := make([]climon.ModbusRegister, len(device.Registers))
+	for i, r := range device.Registers {
+		function := modbus.HoldingRegisters
+		if r.Table == "input" {
+			function = modbus.InputRegisters
+		}
+		registers[i] = climon.ModbusRegister{
+			Sensor:     climon.TemperatureSensor{ID: r.ID, Type: r.Type, Name: r.Name},
+			Unit:       byte(r.UnitID),
+			Function:   function,
+			Address:    uint16(r.Address),
+			DataType:   r.DataType,
+			WordSwap:   r.WordOrder == "little",
+			Scale:      r.Scale,
+			Offset:     r.Offset,
+			Fahrenheit: strings.EqualFold(r.Unit, "F"),
+		}
+	}
+	log.Printf("Polling %d Modbus registers from %s", len(registers), client)
+	return climon.NewModbusMonitor(client, registers)
+}
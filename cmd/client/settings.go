@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/config"
+	climon "github.com/nickheyer/jacuzzi/pkg/client/monitor"
+	profilev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/profile/v1"
+)
+
+// defaultHeartbeat is how often every sensor is submitted while report
+// thresholds hold readings back, unless the configuration sets it; below the
+// server's default offline and stale sensor timeouts
+const defaultHeartbeat = 4 * time.Minute
+
+// settings are the interval, sensor kinds and thresholds the client reports
+// with: those of its configuration file, with the configuration last applied
+// by an apply_config command laid over them
+type settings struct {
+	mu               sync.Mutex
+	local            *config.Config
+	interval         time.Duration
+	collect          map[string]bool    // By sensor type; other types are always reported
+	thresholds       map[string]float64 // By sensor type
+	reportThresholds map[string]float64 // By sensor type
+	heartbeat        time.Duration
+	revision         string
+	hot              map[string]bool // Sensors above their threshold at the last report
+
+	held          map[string]climon.Window // Windows held back by report thresholds, by sensor ID
+	lastHeartbeat time.Time
+
+	changed chan struct{} // Wakes the report loop when the interval changes
+}
+
+func newSettings(cfg *config.Config) *settings {
+	s := &settings{
+		local:   cfg,
+		hot:     make(map[string]bool),
+		held:    make(map[string]climon.Window),
+		changed: make(chan struct{}, 1),
+	}
+	s.set(&profilev1.AgentConfig{}, "")
+	return s
+}
+
+// set lays a configuration over the configuration file
+func (s *settings) set(pushed *profilev1.AgentConfig, revision string) {
+	s.interval = s.local.Client.Interval
+	if pushed.IntervalSeconds > 0 {
+		s.interval = time.Duration(pushed.IntervalSeconds) * time.Second
+	}
+
+	s.collect = map[string]bool{"CPU": s.local.Monitoring.CPU, "GPU": s.local.Monitoring.GPU, "DISK": s.local.Monitoring.Disk}
+	if len(pushed.Collectors) > 0 {
+		for sensorType := range s.collect {
+			s.collect[sensorType] = slices.Contains(pushed.Collectors, strings.ToLower(sensorType))
+		}
+	}
+
+	// Viper lowercases map keys, the server sends sensor types
+	s.thresholds = make(map[string]float64)
+	for sensorType, celsius := range s.local.Monitoring.Thresholds {
+		s.thresholds[strings.ToUpper(sensorType)] = celsius
+	}
+	for sensorType, celsius := range pushed.Thresholds {
+		s.thresholds[strings.ToUpper(sensorType)] = celsius
+	}
+	s.reportThresholds = make(map[string]float64)
+	for sensorType, celsius := range pushed.ReportThresholds {
+		s.reportThresholds[strings.ToUpper(sensorType)] = celsius
+	}
+	s.heartbeat = defaultHeartbeat
+	if pushed.HeartbeatSeconds > 0 {
+		s.heartbeat = time.Duration(pushed.HeartbeatSeconds) * time.Second
+	}
+	s.revision = revision
+}
+
+// Apply runs an apply_config command
+func (s *settings) Apply(command *profilev1.AgentConfig, revision string) (string, error) {
+	if !s.local.Commands.RemoteConfig {
+		return "", fmt.Errorf("remote configuration is not enabled on this client")
+	}
+	if command == nil {
+		command = &profilev1.AgentConfig{}
+	}
+	interval := time.Duration(command.IntervalSeconds) * time.Second
+	if interval > 0 && s.local.Client.SampleInterval > 0 && interval <= s.local.Client.SampleInterval {
+		return "", fmt.Errorf("interval %s is not longer than the sample interval %s", interval, s.local.Client.SampleInterval)
+	}
+
+	s.mu.Lock()
+	s.set(command, revision)
+	summary := s.summary()
+	s.mu.Unlock()
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+	if revision == "" {
+		return "returned to local configuration: " + summary, nil
+	}
+	return fmt.Sprintf("applied configuration %s: %s", revision, summary), nil
+}
+
+// summary describes the settings; s.mu must be held
+func (s *settings) summary() string {
+	var collected []string
+	for _, sensorType := range []string{"CPU", "GPU", "DISK"} {
+		if s.collect[sensorType] {
+			collected = append(collected, sensorType)
+		}
+	}
+	if len(collected) == 0 {
+		collected = append(collected, "none")
+	}
+	summary := fmt.Sprintf("interval %s, monitoring %s", s.interval, strings.Join(collected, ", "))
+	if len(s.thresholds) > 0 {
+		types := make([]string, 0, len(s.thresholds))
+		for sensorType := range s.thresholds {
+			types = append(types, sensorType)
+		}
+		sort.Strings(types)
+		for i, sensorType := range types {
+			types[i] = fmt.Sprintf("%s=%g°C", sensorType, s.thresholds[sensorType])
+		}
+		summary += ", thresholds " + strings.Join(types, ", ")
+	}
+	if len(s.reportThresholds) > 0 {
+		types := make([]string, 0, len(s.reportThresholds))
+		for sensorType := range s.reportThresholds {
+			types = append(types, sensorType)
+		}
+		sort.Strings(types)
+		for i, sensorType := range types {
+			types[i] = fmt.Sprintf("%s>=%g°C", sensorType, s.reportThresholds[sensorType])
+		}
+		summary += fmt.Sprintf(", reporting only %s with a heartbeat every %s", strings.Join(types, ", "), s.heartbeat)
+	}
+	return summary
+}
+
+// Interval returns the report interval
+func (s *settings) Interval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interval
+}
+
+// Include reports whether sensors of a type are reported
+func (s *settings) Include(sensorType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	include, ok := s.collect[sensorType]
+	return include || !ok
+}
+
+// CheckThresholds logs a warning when a sensor rises above the threshold for
+// its type, by the peak of its window, and again when it drops back
+func (s *settings) CheckThresholds(windows []climon.Window) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, window := range windows {
+		sensor := window.Sensor
+		threshold, ok := s.thresholds[sensor.Type]
+		hot := ok && window.Max > threshold
+		switch {
+		case hot && !s.hot[sensor.ID]:
+			log.Printf("Warning: sensor %s (%s) reached %.1f°C, above its %g°C threshold", sensor.Name, sensor.Type, window.Max, threshold)
+			s.hot[sensor.ID] = true
+		case !hot && s.hot[sensor.ID]:
+			log.Printf("Sensor %s (%s) back below its threshold at %.1f°C", sensor.Name, sensor.Type, window.Max)
+			delete(s.hot, sensor.ID)
+		}
+	}
+}
+
+// Prefilter returns the windows to submit and whether they are a heartbeat.
+// With report thresholds, sensors below the threshold for their type are held
+// back, their windows merged, until they reach it or a heartbeat is due, when
+// every sensor is submitted with a summary of the readings held back.
+func (s *settings) Prefilter(windows []climon.Window, now time.Time) ([]climon.Window, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	filtering := len(s.reportThresholds) > 0
+	heartbeat := filtering && now.Sub(s.lastHeartbeat) >= s.heartbeat
+
+	submit := make([]climon.Window, 0, len(windows))
+	for _, window := range windows {
+		id := window.Sensor.ID
+		if held, ok := s.held[id]; ok {
+			window = held.Merge(window)
+			delete(s.held, id)
+		}
+		threshold, ok := s.reportThresholds[window.Sensor.Type]
+		if !filtering || heartbeat || !ok || window.Max >= threshold {
+			submit = append(submit, window)
+			continue
+		}
+		s.held[id] = window
+	}
+	if heartbeat {
+		s.lastHeartbeat = now
+	}
+	return submit, heartbeat
+}
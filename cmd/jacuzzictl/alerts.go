@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var alertsCmd = &cobra.Command{
+	Use:   "alerts",
+	Short: "Manage alerts",
+}
+
+var alertsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List alert history",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		activeOnly, _ := cmd.Flags().GetBool("active")
+		clientID, _ := cmd.Flags().GetString("client")
+		ruleID, _ := cmd.Flags().GetString("rule")
+		severity, _ := cmd.Flags().GetString("severity")
+		limit, _ := cmd.Flags().GetInt32("limit")
+		offset, _ := cmd.Flags().GetInt32("offset")
+
+		var err error
+		req := &alertv1.GetAlertHistoryRequest{
+			ClientId:   clientID,
+			RuleId:     ruleID,
+			ActiveOnly: activeOnly,
+			Limit:      limit,
+			Offset:     offset,
+		}
+		if req.Severity, err = parseSeverity(severity); err != nil {
+			return err
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.alert.GetAlertHistory(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to list alerts: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tSEVERITY\tCLIENT\tSENSOR\tVALUE\tTRIGGERED\tACTIVE\tACKED\tMUTED\tFLAPPING")
+			for _, a := range resp.Alerts {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f\t%s\t%v\t%v\t%v\t%v\n", a.Id, a.Severity, a.ClientId, a.SensorId, a.Value, formatTime(a.TriggeredAt), a.IsActive, a.AcknowledgedAt != nil, a.Muted, a.Flapping)
+			}
+			if shown := int64(len(resp.Alerts)); shown > 0 && shown < resp.TotalCount {
+				fmt.Fprintf(w, "Showing %d-%d of %d alerts\n", int64(offset)+1, int64(offset)+shown, resp.TotalCount)
+			}
+			return nil
+		})
+	},
+}
+
+var alertsWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Stream alerts as they trigger, resolve and are acknowledged",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientID, _ := cmd.Flags().GetString("client")
+		ruleID, _ := cmd.Flags().GetString("rule")
+		types, _ := cmd.Flags().GetStringSlice("type")
+		minSeverity, _ := cmd.Flags().GetString("min-severity")
+
+		req := &alertv1.StreamAlertsRequest{ClientId: clientID, RuleId: ruleID}
+		for _, t := range types {
+			value, ok := alertv1.AlertEventType_value["ALERT_EVENT_TYPE_"+strings.ToUpper(t)]
+			if !ok || value == 0 {
+				return fmt.Errorf("invalid event type %q: want triggered, resolved or acknowledged", t)
+			}
+			req.Types = append(req.Types, alertv1.AlertEventType(value))
+		}
+		var err error
+		if req.MinSeverity, err = parseSeverity(minSeverity); err != nil {
+			return err
+		}
+
+		api, _, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		// The stream runs until interrupted, so it is not bound by --timeout
+		stream, err := api.alert.StreamAlerts(cmd.Context(), req)
+		if err != nil {
+			return fmt.Errorf("failed to stream alerts: %w", err)
+		}
+
+		for {
+			event, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to stream alerts: %w", err)
+			}
+			err = cli.PrintProto(cmd, event, func(w io.Writer) error {
+				a := event.Alert
+				eventType := strings.ToLower(strings.TrimPrefix(event.Type.String(), "ALERT_EVENT_TYPE_"))
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(event.OccurredAt), eventType, a.Severity, a.Id, a.ClientId, a.Message)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	},
+}
+
+var alertsExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export alert history as CSV, oldest first",
+	Long: `Export every alert matching the filters as CSV, with resolution and
+acknowledgement times and durations, to standard output or a file.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		activeOnly, _ := cmd.Flags().GetBool("active")
+		clientID, _ := cmd.Flags().GetString("client")
+		ruleID, _ := cmd.Flags().GetString("rule")
+		severity, _ := cmd.Flags().GetString("severity")
+		since, _ := cmd.Flags().GetDuration("since")
+		timezone, _ := cmd.Flags().GetString("timezone")
+		file, _ := cmd.Flags().GetString("file")
+
+		var err error
+		req := &alertv1.ExportAlertHistoryRequest{
+			ClientId:   clientID,
+			RuleId:     ruleID,
+			ActiveOnly: activeOnly,
+			Timezone:   timezone,
+		}
+		if req.Severity, err = parseSeverity(severity); err != nil {
+			return err
+		}
+		if since > 0 {
+			req.StartTime = timestamppb.New(time.Now().Add(-since))
+		}
+
+		api, _, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		// Long histories take a while, so the export is not bound by --timeout
+		stream, err := api.alert.ExportAlertHistory(cmd.Context(), req)
+		if err != nil {
+			return fmt.Errorf("failed to export alerts: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		var f *os.File
+		if file != "" {
+			if f, err = os.Create(file); err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to export alerts: %w", err)
+			}
+			if _, err := out.Write(chunk.Data); err != nil {
+				return err
+			}
+		}
+		if f != nil {
+			return f.Close()
+		}
+		return nil
+	},
+}
+
+var alertsAnalyticsCmd = &cobra.Command{
+	Use:   "analytics",
+	Short: "Show MTTR, the noisiest rules and clients, busiest hours and longest-running alerts",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientID, _ := cmd.Flags().GetString("client")
+		ruleID, _ := cmd.Flags().GetString("rule")
+		since, _ := cmd.Flags().GetDuration("since")
+		timezone, _ := cmd.Flags().GetString("timezone")
+		limit, _ := cmd.Flags().GetInt32("limit")
+
+		req := &alertv1.GetAlertAnalyticsRequest{
+			ClientId: clientID,
+			RuleId:   ruleID,
+			Timezone: timezone,
+			Limit:    limit,
+		}
+		if since > 0 {
+			req.StartTime = timestamppb.New(time.Now().Add(-since))
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.alert.GetAlertAnalytics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to get alert analytics: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Range:\t%s to %s\n", formatTime(resp.StartTime), formatTime(resp.EndTime))
+			fmt.Fprintf(w, "Alerts:\t%d (%d resolved, %d active)\n", resp.TotalCount, resp.ResolvedCount, resp.ActiveCount)
+			fmt.Fprintf(w, "MTTR:\t%s\n", formatSeconds(resp.MttrSeconds))
+			fmt.Fprintf(w, "MTTA:\t%s\n", formatSeconds(resp.MttaSeconds))
+
+			fmt.Fprintln(w, "\nRULE\tNAME\tALERTS\tPER DAY\tMTTR")
+			for _, f := range resp.Rules {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%.1f\t%s\n", f.Id, orDash(f.Name), f.Count, f.PerDay, formatSeconds(f.MttrSeconds))
+			}
+			fmt.Fprintln(w, "\nCLIENT\tHOSTNAME\tALERTS\tPER DAY\tMTTR")
+			for _, f := range resp.Clients {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%.1f\t%s\n", f.Id, orDash(f.Name), f.Count, f.PerDay, formatSeconds(f.MttrSeconds))
+			}
+			fmt.Fprintln(w, "\nHOUR\tALERTS")
+			for _, h := range resp.Hours {
+				fmt.Fprintf(w, "%02d:00\t%d\n", h.Hour, h.Count)
+			}
+			fmt.Fprintln(w, "\nLONGEST\tSEVERITY\tCLIENT\tSENSOR\tTRIGGERED\tACTIVE\tMESSAGE")
+			for _, l := range resp.Longest {
+				a := l.Alert
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%s\n", time.Duration(l.DurationSeconds)*time.Second, a.Severity, orDash(a.ClientId), orDash(a.SensorId), formatTime(a.TriggeredAt), a.IsActive, a.Message)
+			}
+			return nil
+		})
+	},
+}
+
+// formatSeconds formats a mean duration in seconds, or a dash for none
+func formatSeconds(seconds float64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// parseSeverity parses a severity flag of info, warning or critical; empty
+// for none
+func parseSeverity(severity string) (alertv1.Severity, error) {
+	if severity == "" {
+		return alertv1.Severity_SEVERITY_UNSPECIFIED, nil
+	}
+	value, ok := alertv1.Severity_value["SEVERITY_"+strings.ToUpper(severity)]
+	if !ok || value == 0 {
+		return 0, fmt.Errorf("invalid severity %q: want info, warning or critical", severity)
+	}
+	return alertv1.Severity(value), nil
+}
+
+var alertsMuteCmd = &cobra.Command{
+	Use:   "mute <duration>",
+	Short: "Mute notifications fleet-wide for a while, e.g. during a stress test",
+	Long: `Mute notifications fleet-wide for a duration such as 2h, at most 168h.
+
+Alerts are still raised and resolved while muted, and marked as muted, but
+skip their on_alert scripts, emergency actions, and client.offline webhooks.
+Notifications resume on their own when the duration ends.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		duration, err := time.ParseDuration(args[0])
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid duration %q", args[0])
+		}
+		reason, _ := cmd.Flags().GetString("reason")
+		return muteNotifications(cmd, duration, reason)
+	},
+}
+
+var alertsUnmuteCmd = &cobra.Command{
+	Use:   "unmute",
+	Short: "Unmute notifications",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return muteNotifications(cmd, 0, "")
+	},
+}
+
+func muteNotifications(cmd *cobra.Command, duration time.Duration, reason string) error {
+	api, ctx, cancel, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer api.Close()
+
+	resp, err := api.alert.MuteNotifications(ctx, &alertv1.MuteNotificationsRequest{
+		DurationSeconds: int64(duration / time.Second),
+		Reason:          reason,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mute notifications: %w", err)
+	}
+
+	return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+		if resp.MutedUntil == nil {
+			fmt.Fprintln(w, "Notifications unmuted")
+		} else {
+			fmt.Fprintf(w, "Notifications muted until %s\n", formatTime(resp.MutedUntil))
+		}
+		return nil
+	})
+}
+
+var alertsAckCmd = &cobra.Command{
+	Use:   "ack <alert-id>",
+	Short: "Acknowledge an alert",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		by, _ := cmd.Flags().GetString("by")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.alert.AcknowledgeAlert(ctx, &alertv1.AcknowledgeAlertRequest{
+			AlertId:        args[0],
+			AcknowledgedBy: by,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to acknowledge alert: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		})
+	},
+}
+
+var alertsContextCmd = &cobra.Command{
+	Use:   "context <alert-id>",
+	Short: "Show the readings kept with a threshold alert",
+	Long: `Show the readings kept with a threshold alert.
+
+Threshold alerts keep every reading of their client from the alerts.context_minutes
+setting before they triggered to as long after, so they remain after raw
+readings are pruned.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.alert.GetAlertContext(ctx, &alertv1.GetAlertContextRequest{AlertId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to get alert context: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			if resp.WindowStart == nil {
+				fmt.Fprintln(w, "The alert has no context")
+				return nil
+			}
+			state := "complete"
+			if !resp.Complete {
+				state = "capturing"
+			}
+			fmt.Fprintf(w, "Context %s to %s (%s)\n", formatTime(resp.WindowStart), formatTime(resp.WindowEnd), state)
+			fmt.Fprintln(w, "TIME\tSENSOR\tTYPE\tTEMP\tBURST")
+			for _, r := range resp.Readings {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%v\n", formatTime(r.Timestamp), r.SensorId, r.SensorType, r.TemperatureCelsius, r.Burst)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	alertsListCmd.Flags().Bool("active", false, "Only list active alerts")
+	alertsListCmd.Flags().String("client", "", "Filter by client ID")
+	alertsListCmd.Flags().String("rule", "", "Filter by rule ID")
+	alertsListCmd.Flags().String("severity", "", "Filter by severity: info, warning, critical")
+	alertsListCmd.Flags().Int32("limit", 100, "Maximum number of alerts to list")
+	alertsListCmd.Flags().Int32("offset", 0, "Number of newest matching alerts to skip")
+
+	alertsExportCmd.Flags().Bool("active", false, "Only export active alerts")
+	alertsExportCmd.Flags().String("client", "", "Filter by client ID")
+	alertsExportCmd.Flags().String("rule", "", "Filter by rule ID")
+	alertsExportCmd.Flags().String("severity", "", "Filter by severity: info, warning, critical")
+	alertsExportCmd.Flags().Duration("since", 0, "Only export alerts triggered this recently (0 for all)")
+	alertsExportCmd.Flags().String("timezone", "", "Timezone of timestamps, e.g. Europe/Amsterdam (default: the server's timezone setting)")
+	alertsExportCmd.Flags().StringP("file", "f", "", "File to write the CSV to instead of standard output")
+
+	alertsAnalyticsCmd.Flags().String("client", "", "Only count alerts of this client ID")
+	alertsAnalyticsCmd.Flags().String("rule", "", "Only count alerts of this rule ID")
+	alertsAnalyticsCmd.Flags().Duration("since", 7*24*time.Hour, "Range of alerts to analyze, up to now")
+	alertsAnalyticsCmd.Flags().String("timezone", "", "Timezone of busiest hours, e.g. Europe/Amsterdam (default: the server's timezone setting)")
+	alertsAnalyticsCmd.Flags().Int32("limit", 10, "Entries of each ranking")
+
+	alertsAckCmd.Flags().String("by", os.Getenv("USER"), "Name recorded as acknowledging the alert")
+
+	alertsWatchCmd.Flags().String("client", "", "Only stream alerts of this client ID")
+	alertsWatchCmd.Flags().String("rule", "", "Only stream alerts of this rule ID")
+	alertsWatchCmd.Flags().StringSlice("type", nil, "Only stream these events: triggered, resolved, acknowledged")
+	alertsWatchCmd.Flags().String("min-severity", "", "Only stream alerts of this severity or above: info, warning, critical")
+
+	alertsMuteCmd.Flags().String("reason", "", "Why notifications are muted, shown in the settings")
+
+	alertsCmd.AddCommand(alertsListCmd, alertsExportCmd, alertsAnalyticsCmd, alertsWatchCmd, alertsAckCmd, alertsContextCmd, alertsMuteCmd, alertsUnmuteCmd)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	"github.com/spf13/cobra"
+)
+
+var clientsCmd = &cobra.Command{
+	Use:   "clients",
+	Short: "Manage clients",
+}
+
+var clientsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List clients",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		onlineOnly, _ := cmd.Flags().GetBool("online-only")
+		limit, _ := cmd.Flags().GetInt32("limit")
+		statusName, _ := cmd.Flags().GetString("status")
+		site, _ := cmd.Flags().GetString("site")
+
+		clientStatus, err := parseClientStatus(statusName)
+		if err != nil {
+			return err
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.ListClients(ctx, &clientv1.ListClientsRequest{
+			OnlineOnly: onlineOnly,
+			Limit:      limit,
+			Status:     clientStatus,
+			Site:       site,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list clients: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tHOSTNAME\tIP\tOS/ARCH\tSITE\tSTATUS\tONLINE\tLAST SEEN")
+			for _, c := range resp.Clients {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s/%s\t%s\t%s\t%v\t%s\n", c.Id, c.Hostname, c.IpAddress, c.Os, c.Arch, c.GetLocation().GetSite(), formatClientStatus(c.Status), c.IsOnline, formatTime(c.LastSeen))
+			}
+			return nil
+		})
+	},
+}
+
+var clientsGetCmd = &cobra.Command{
+	Use:   "get <client-id>",
+	Short: "Show a client and its sensors",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.GetClient(ctx, &clientv1.GetClientRequest{ClientId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to get client: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			c := resp.Client
+			fmt.Fprintf(w, "ID:\t%s\n", c.Id)
+			fmt.Fprintf(w, "Hostname:\t%s\n", c.Hostname)
+			fmt.Fprintf(w, "IP:\t%s\n", c.IpAddress)
+			fmt.Fprintf(w, "OS/Arch:\t%s/%s\n", c.Os, c.Arch)
+			fmt.Fprintf(w, "Status:\t%s\n", formatClientStatus(c.Status))
+			fmt.Fprintf(w, "Online:\t%v\n", c.IsOnline)
+			fmt.Fprintf(w, "First seen:\t%s\n", formatTime(c.FirstSeen))
+			fmt.Fprintf(w, "Last seen:\t%s\n", formatTime(c.LastSeen))
+			fmt.Fprintf(w, "Clock skew:\t%dms\n", c.ClockSkewMs)
+			fmt.Fprintf(w, "Machine ID:\t%s\n", c.MachineId)
+			if location := formatLocation(c.Location); location != "" {
+				fmt.Fprintf(w, "Location:\t%s\n", location)
+			}
+			if c.IdentityConflictAt != nil {
+				fmt.Fprintf(w, "ID conflict:\t%s\n", formatTime(c.IdentityConflictAt))
+			}
+			if len(c.Capabilities) > 0 {
+				fmt.Fprintf(w, "Accepts:\t%s\n", strings.Join(c.Capabilities, ", "))
+			}
+			if len(c.LocalActions) > 0 {
+				fmt.Fprintf(w, "Local actions:\t%s\n", strings.Join(c.LocalActions, ", "))
+			}
+			fmt.Fprintln(w)
+
+			fmt.Fprintln(w, "SENSOR\tTYPE\tNAME\tTEMP (°C)\tLAST READING")
+			for _, sensor := range resp.Sensors {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%s\n", sensor.SensorId, sensor.SensorType, sensor.SensorName, sensor.CurrentTemperature, formatTime(sensor.LastReading))
+			}
+
+			// A single address is already shown above
+			if len(resp.Addresses) > 1 {
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "ADDRESS	FIRST SEEN	LAST SEEN")
+				for _, address := range resp.Addresses {
+					ip := address.IpAddress
+					if address.Current {
+						ip += " (current)"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", ip, formatTime(address.FirstSeen), formatTime(address.LastSeen))
+				}
+			}
+			return nil
+		})
+	},
+}
+
+var clientsApproveCmd = &cobra.Command{
+	Use:   "approve <client-id>",
+	Short: "Approve a pending client so its readings are stored",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setClientApproval(cmd, args[0], true)
+	},
+}
+
+var clientsRejectCmd = &cobra.Command{
+	Use:   "reject <client-id>",
+	Short: "Reject a client so its readings are dropped",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setClientApproval(cmd, args[0], false)
+	},
+}
+
+func setClientApproval(cmd *cobra.Command, clientID string, approved bool) error {
+	api, ctx, cancel, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer api.Close()
+
+	resp, err := api.client.SetClientApproval(ctx, &clientv1.SetClientApprovalRequest{
+		ClientId: clientID,
+		Approved: approved,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set client approval: %w", err)
+	}
+
+	return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+		fmt.Fprintln(w, resp.Message)
+		return nil
+	})
+}
+
+var clientsMergeCmd = &cobra.Command{
+	Use:   "merge <source-client-id> <target-client-id>",
+	Short: "Merge a client into another, moving its sensors, readings and alerts",
+	Long: `Merge a client into another, such as after a machine came back under a new
+hostname. The source client is deleted; its sensors, readings, alerts and
+events move to the target. Where both have a reading of a sensor at the same
+time, the target's is kept.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.MergeClients(ctx, &clientv1.MergeClientsRequest{
+			SourceClientId: args[0],
+			TargetClientId: args[1],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to merge clients: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			fmt.Fprintf(w, "Readings moved:\t%d (%d duplicates dropped)\n", resp.ReadingsMoved, resp.DuplicateReadings)
+			fmt.Fprintf(w, "Compressed hours moved:\t%d\n", resp.ChunksMoved)
+			fmt.Fprintf(w, "Sensors moved:\t%d\n", resp.SensorsMoved)
+			fmt.Fprintf(w, "Alerts moved:\t%d\n", resp.AlertsMoved)
+			return nil
+		})
+	},
+}
+
+var clientsRenameCmd = &cobra.Command{
+	Use:   "rename <client-id> <new-client-id>",
+	Short: "Change a client's ID, keeping its sensors, readings and alerts",
+	Long: `Change a client's ID, such as before its hostname changes. Its sensors,
+readings, rules, alerts and events keep their history under the new ID, which
+the agent must then report with.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.RenameClient(ctx, &clientv1.RenameClientRequest{
+			ClientId:    args[0],
+			NewClientId: args[1],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to rename client: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		})
+	},
+}
+
+var clientsLocateCmd = &cobra.Command{
+	Use:   "locate <client-id>",
+	Short: "Set where a client is installed",
+	Long: `Set where a client is installed. The flags replace the whole location,
+so any part left out is cleared; with no flags the location is removed.`,
+	Example: `  jacuzzictl clients locate web-01 --site ams1 --rack R12 --coordinates 52.37,4.90`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		location := &clientv1.Location{}
+		location.Site, _ = cmd.Flags().GetString("site")
+		location.Rack, _ = cmd.Flags().GetString("rack")
+		location.Description, _ = cmd.Flags().GetString("description")
+		if coordinates, _ := cmd.Flags().GetString("coordinates"); coordinates != "" {
+			var lat, long float64
+			if _, err := fmt.Sscanf(coordinates, "%g,%g", &lat, &long); err != nil {
+				return fmt.Errorf("invalid coordinates %q: must be latitude,longitude", coordinates)
+			}
+			location.Coordinates = &clientv1.Coordinates{Latitude: lat, Longitude: long}
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.SetClientLocation(ctx, &clientv1.SetClientLocationRequest{
+			ClientId: args[0],
+			Location: location,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set client location: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			if location := formatLocation(resp.Client.Location); location != "" {
+				fmt.Fprintf(w, "Client %s is at %s\n", resp.Client.Id, location)
+			} else {
+				fmt.Fprintf(w, "Client %s has no location\n", resp.Client.Id)
+			}
+			return nil
+		})
+	},
+}
+
+// formatLocation renders the parts of a location that are set
+func formatLocation(location *clientv1.Location) string {
+	var parts []string
+	if location.GetSite() != "" {
+		parts = append(parts, "site "+location.Site)
+	}
+	if location.GetRack() != "" {
+		parts = append(parts, "rack "+location.Rack)
+	}
+	if c := location.GetCoordinates(); c != nil {
+		parts = append(parts, fmt.Sprintf("%.5f,%.5f", c.Latitude, c.Longitude))
+	}
+	if location.GetDescription() != "" {
+		parts = append(parts, location.Description)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseClientStatus converts a status name such as "pending" to its enum value
+func parseClientStatus(name string) (clientv1.ClientStatus, error) {
+	if name == "" {
+		return clientv1.ClientStatus_CLIENT_STATUS_UNSPECIFIED, nil
+	}
+	value, ok := clientv1.ClientStatus_value["CLIENT_STATUS_"+strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown client status %q: must be pending, approved, or rejected", name)
+	}
+	return clientv1.ClientStatus(value), nil
+}
+
+// formatClientStatus renders a client status without its enum prefix
+func formatClientStatus(s clientv1.ClientStatus) string {
+	return strings.ToLower(strings.TrimPrefix(s.String(), "CLIENT_STATUS_"))
+}
+
+func init() {
+	clientsListCmd.Flags().Bool("online-only", false, "Only list online clients")
+	clientsListCmd.Flags().Int32("limit", 100, "Maximum number of clients to list")
+	clientsListCmd.Flags().String("status", "", "Only list clients with this status (pending, approved, rejected)")
+	clientsListCmd.Flags().String("site", "", "Only list clients at this site")
+
+	clientsLocateCmd.Flags().String("site", "", "Site the client is installed at")
+	clientsLocateCmd.Flags().String("rack", "", "Rack or room within the site")
+	clientsLocateCmd.Flags().String("coordinates", "", "Latitude and longitude in decimal degrees, e.g. 52.37,4.90")
+	clientsLocateCmd.Flags().String("description", "", "Free text location")
+
+	clientsCmd.AddCommand(clientsListCmd, clientsGetCmd, clientsApproveCmd, clientsRejectCmd, clientsMergeCmd, clientsRenameCmd, clientsLocateCmd)
+}
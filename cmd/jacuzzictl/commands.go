@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	commandv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/command/v1"
+	"github.com/spf13/cobra"
+)
+
+var commandsCmd = &cobra.Command{
+	Use:   "commands",
+	Short: "Send commands to clients that accept them",
+}
+
+var commandsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List commands sent to clients, newest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientID, _ := cmd.Flags().GetString("client")
+		limit, _ := cmd.Flags().GetInt32("limit")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.command.ListCommands(ctx, &commandv1.ListCommandsRequest{ClientId: clientID, Limit: limit})
+		if err != nil {
+			return fmt.Errorf("failed to list commands: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tCLIENT\tACTION\tSOURCE\tSTATUS\tRESULT\tCREATED")
+			for _, c := range resp.Commands {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Id, c.ClientId, formatCommandAction(c), c.Source, formatCommandStatus(c.Status), c.Result, formatTime(c.CreatedAt))
+			}
+			return nil
+		})
+	},
+}
+
+var commandsFanCmd = &cobra.Command{
+	Use:   "fan <client-id> <fan-id>",
+	Short: "Set a client's fan to a fixed duty cycle, a curve, or automatic control",
+	Long: `Set a client's fan to a fixed duty cycle, a curve, or automatic control.
+
+The client must run with commands.fan_control enabled; list its fans with
+"jacuzzi-client fans". A curve is a list of temperature:pwm points that the
+client follows using one of its sensors, for example:
+
+  jacuzzictl commands fan web-01 hwmon2_pwm1 --sensor hwmon1_1 --curve 40:60,70:160,85:255`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pwm, _ := cmd.Flags().GetInt32("pwm")
+		curveFlag, _ := cmd.Flags().GetString("curve")
+		sensorID, _ := cmd.Flags().GetString("sensor")
+		auto, _ := cmd.Flags().GetBool("auto")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+
+		setFan := &commandv1.SetFanCommand{
+			FanId:     args[1],
+			Pwm:       pwm,
+			SensorId:  sensorID,
+			Automatic: auto,
+		}
+		if curveFlag != "" {
+			curve, err := parseFanCurve(curveFlag)
+			if err != nil {
+				return err
+			}
+			setFan.Curve = curve
+		}
+		if !auto && curveFlag == "" && !cmd.Flags().Changed("pwm") {
+			return fmt.Errorf("one of --pwm, --curve, or --auto is required")
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.command.SendCommand(ctx, &commandv1.SendCommandRequest{
+			Command: &commandv1.Command{
+				ClientId: args[0],
+				Action:   &commandv1.Command_SetFan{SetFan: setFan},
+			},
+			TtlSeconds: int64(ttl / time.Second),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send command: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Command %s queued for %s; it runs when the client next polls\n", resp.Command.Id, resp.Command.ClientId)
+			return nil
+		})
+	},
+}
+
+var commandsActionCmd = &cobra.Command{
+	Use:   "action <client-id> <action>",
+	Short: "Run one of the local actions a client offers, such as a graceful shutdown",
+	Long: `Run one of the local actions a client offers, such as a graceful shutdown.
+
+Clients offer actions by name in commands.local_actions of their configuration;
+"jacuzzictl clients get" lists them. The client runs the command configured for
+the name and records it in its audit log.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reason, _ := cmd.Flags().GetString("reason")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.command.SendCommand(ctx, &commandv1.SendCommandRequest{
+			Command: &commandv1.Command{
+				ClientId: args[0],
+				Action: &commandv1.Command_RunAction{RunAction: &commandv1.RunActionCommand{
+					Name:   args[1],
+					Reason: reason,
+				}},
+			},
+			TtlSeconds: int64(ttl / time.Second),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send command: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Command %s queued for %s; it runs when the client next polls\n", resp.Command.Id, resp.Command.ClientId)
+			return nil
+		})
+	},
+}
+
+var commandsBurstCmd = &cobra.Command{
+	Use:   "burst <client-id>",
+	Short: "Have a client report at a high rate for a while, to troubleshoot a thermal event",
+	Long: `Have a client report at a high rate for a while, to troubleshoot a thermal event.
+
+The client must run with commands.burst_capture enabled. It keeps its regular
+reports and adds readings every --interval until --duration has passed, then
+returns to its regular interval on its own. The extra readings are tagged as
+burst readings and pruned after the data.burst_retention_hours setting.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		duration, _ := cmd.Flags().GetDuration("duration")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.command.SendCommand(ctx, &commandv1.SendCommandRequest{
+			Command: &commandv1.Command{
+				ClientId: args[0],
+				Action: &commandv1.Command_BurstCapture{BurstCapture: &commandv1.BurstCaptureCommand{
+					IntervalSeconds: int32(interval / time.Second),
+					DurationSeconds: int32(duration / time.Second),
+				}},
+			},
+			TtlSeconds: int64(ttl / time.Second),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send command: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Command %s queued for %s; it runs when the client next polls\n", resp.Command.Id, resp.Command.ClientId)
+			return nil
+		})
+	},
+}
+
+var commandsLogEventCmd = &cobra.Command{
+	Use:   "log-event <client-id> <message>",
+	Short: "Write an entry to a client's system log, to test log-based alerting",
+	Long: `Write an entry to a client's system log, to test log-based alerting.
+
+The client must run with commands.event_log enabled. It writes the entry to the
+Event Log on Windows, and to journald or syslog elsewhere, as event log alert
+actions do for triggered alerts.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source, _ := cmd.Flags().GetString("source")
+		levelName, _ := cmd.Flags().GetString("level")
+		eventID, _ := cmd.Flags().GetUint32("event-id")
+		fields, _ := cmd.Flags().GetStringToString("field")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		level, ok := commandv1.LogEventCommand_Level_value["LEVEL_"+strings.ToUpper(levelName)]
+		if !ok || level == 0 {
+			return fmt.Errorf("invalid level %q: want info, warning or critical", levelName)
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.command.SendCommand(ctx, &commandv1.SendCommandRequest{
+			Command: &commandv1.Command{
+				ClientId: args[0],
+				Action: &commandv1.Command_LogEvent{LogEvent: &commandv1.LogEventCommand{
+					Source:  source,
+					Level:   commandv1.LogEventCommand_Level(level),
+					EventId: eventID,
+					Message: args[1],
+					Fields:  fields,
+				}},
+			},
+			TtlSeconds: int64(ttl / time.Second),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send command: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Command %s queued for %s; it runs when the client next polls\n", resp.Command.Id, resp.Command.ClientId)
+			return nil
+		})
+	},
+}
+
+// parseFanCurve parses temperature:pwm points separated by commas
+func parseFanCurve(value string) ([]*commandv1.FanCurvePoint, error) {
+	var curve []*commandv1.FanCurvePoint
+	for _, part := range strings.Split(value, ",") {
+		tempStr, pwmStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid curve point %q: want temperature:pwm", part)
+		}
+		temp, err := strconv.ParseFloat(tempStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid curve temperature %q: %w", tempStr, err)
+		}
+		pwm, err := strconv.ParseInt(pwmStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid curve pwm %q: %w", pwmStr, err)
+		}
+		curve = append(curve, &commandv1.FanCurvePoint{TemperatureCelsius: temp, Pwm: int32(pwm)})
+	}
+	return curve, nil
+}
+
+func formatCommandAction(c *commandv1.Command) string {
+	switch action := c.Action.(type) {
+	case *commandv1.Command_SetFan:
+		fan := action.SetFan
+		switch {
+		case fan.Automatic:
+			return fmt.Sprintf("fan %s auto", fan.FanId)
+		case len(fan.Curve) > 0:
+			return fmt.Sprintf("fan %s curve on %s", fan.FanId, fan.SensorId)
+		default:
+			return fmt.Sprintf("fan %s pwm %d", fan.FanId, fan.Pwm)
+		}
+	case *commandv1.Command_RunAction:
+		return "action " + action.RunAction.Name
+	case *commandv1.Command_BurstCapture:
+		burst := action.BurstCapture
+		return fmt.Sprintf("burst every %ds for %ds", burst.IntervalSeconds, burst.DurationSeconds)
+	case *commandv1.Command_ApplyConfig:
+		return "apply config " + orDash(action.ApplyConfig.Revision)
+	case *commandv1.Command_LogEvent:
+		return fmt.Sprintf("log event %d", action.LogEvent.EventId)
+	default:
+		return "-"
+	}
+}
+
+func formatCommandStatus(s commandv1.CommandStatus) string {
+	return strings.ToLower(strings.TrimPrefix(s.String(), "COMMAND_STATUS_"))
+}
+
+func init() {
+	commandsListCmd.Flags().String("client", "", "Only list commands for this client ID")
+	commandsListCmd.Flags().Int32("limit", 100, "Maximum commands to list")
+	commandsFanCmd.Flags().Int32("pwm", 0, "Fixed duty cycle, 0-255")
+	commandsFanCmd.Flags().String("curve", "", "Curve as temperature:pwm points, e.g. 40:60,70:160,85:255")
+	commandsFanCmd.Flags().String("sensor", "", "Sensor ID the curve follows")
+	commandsFanCmd.Flags().Bool("auto", false, "Return the fan to automatic hardware control")
+	commandsFanCmd.Flags().Duration("ttl", 5*time.Minute, "How long the command waits for the client to poll")
+
+	commandsActionCmd.Flags().String("reason", "", "Why the action is run, passed to it as JACUZZI_ACTION_REASON")
+	commandsActionCmd.Flags().Duration("ttl", 5*time.Minute, "How long the command waits for the client to poll")
+
+	commandsBurstCmd.Flags().Duration("interval", time.Second, "How often the client reports during the burst, up to 1m")
+	commandsBurstCmd.Flags().Duration("duration", 10*time.Minute, "How long the burst lasts, up to 1h")
+	commandsBurstCmd.Flags().Duration("ttl", 5*time.Minute, "How long the command waits for the client to poll")
+
+	commandsLogEventCmd.Flags().String("source", "", "Event Log source or syslog tag; empty is jacuzzi")
+	commandsLogEventCmd.Flags().String("level", "info", "info, warning or critical")
+	commandsLogEventCmd.Flags().Uint32("event-id", 100, "Event Log event ID, 1-1000")
+	commandsLogEventCmd.Flags().StringToString("field", nil, "Field to add as key=value, e.g. ticket=OPS-12; repeatable")
+	commandsLogEventCmd.Flags().Duration("ttl", 5*time.Minute, "How long the command waits for the client to poll")
+
+	commandsCmd.AddCommand(commandsListCmd, commandsFanCmd, commandsActionCmd, commandsBurstCmd, commandsLogEventCmd)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	eventv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/event/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Show the activity log",
+}
+
+var eventsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List events, newest first",
+	Long: `List the activity log: client registrations, online and offline transitions,
+retired sensors, the alert lifecycle, settings changes and background job
+failures.
+
+--type takes types such as client.offline, or prefixes ending in "." such as
+alert. for every alert event, and may be repeated.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		types, _ := cmd.Flags().GetStringSlice("type")
+		clientID, _ := cmd.Flags().GetString("client")
+		since, _ := cmd.Flags().GetDuration("since")
+		limit, _ := cmd.Flags().GetInt32("limit")
+
+		req := &eventv1.ListEventsRequest{
+			Types:    types,
+			ClientId: clientID,
+			Limit:    limit,
+		}
+		if since > 0 {
+			req.StartTime = timestamppb.New(time.Now().Add(-since))
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.event.ListEvents(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to list events: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "TIME\tTYPE\tCLIENT\tSENSOR\tMESSAGE")
+			for _, e := range resp.Events {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", formatTime(e.Time), e.Type, e.ClientId, e.SensorId, e.Message)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	eventsListCmd.Flags().StringSlice("type", nil, "Only list events of this type or type prefix")
+	eventsListCmd.Flags().String("client", "", "Filter by client ID")
+	eventsListCmd.Flags().Duration("since", 0, "Only list events this recent (0 for all)")
+	eventsListCmd.Flags().Int32("limit", 100, "Maximum number of events to list")
+
+	eventsCmd.AddCommand(eventsListCmd)
+}
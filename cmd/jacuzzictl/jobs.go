@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	jobv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/job/v1"
+	"github.com/spf13/cobra"
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manage background jobs",
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List background jobs with their schedules and latest runs",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.job.ListJobs(ctx, &jobv1.ListJobsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list jobs: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "NAME\tSCHEDULE\tLAST RUN\tDURATION\tNEXT RUN\tRUNS\tFAILURES\tLAST ERROR")
+			for _, j := range resp.Jobs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", j.Name, j.Schedule, formatJobRun(j), time.Duration(j.LastDurationMs)*time.Millisecond,
+					formatTime(j.NextRunAt), j.RunCount, j.FailureCount, j.LastError)
+			}
+			return nil
+		})
+	},
+}
+
+var jobsRunCmd = &cobra.Command{
+	Use:   "run <name>",
+	Short: "Run a background job at once and wait for it to finish",
+	Long: `Run a background job at once and wait for it to finish, e.g. a rollup after
+a bulk import. Long jobs may need a longer --timeout; a job that outlasts it
+keeps running on the server.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.job.RunJobNow(ctx, &jobv1.RunJobNowRequest{Name: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to run job: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			duration := time.Duration(resp.Job.LastDurationMs) * time.Millisecond
+			if resp.Job.LastError != "" {
+				fmt.Fprintf(w, "Job %s failed after %s: %s\n", resp.Job.Name, duration, resp.Job.LastError)
+			} else {
+				fmt.Fprintf(w, "Job %s finished in %s\n", resp.Job.Name, duration)
+			}
+			return nil
+		})
+	},
+}
+
+var jobsMaintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Prune old data, roll up readings, and vacuum the database at once",
+	Long: `Run data maintenance at once: roll up new readings when rollups are enabled,
+delete readings and activity events older than the data.retention_days
+setting, then vacuum and analyze the database. Useful after lowering the
+retention period or a bulk import. Vacuuming a large database can outlast the
+default --timeout; --skip-vacuum leaves it out.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		skipVacuum, _ := cmd.Flags().GetBool("skip-vacuum")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.job.RunDataMaintenance(ctx, &jobv1.RunDataMaintenanceRequest{SkipVacuum: skipVacuum})
+		if err != nil {
+			return fmt.Errorf("failed to run data maintenance: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Rollup buckets updated:\t%d\n", resp.RollupBucketsUpdated)
+			fmt.Fprintf(w, "Readings deleted:\t%d (older than %d days)\n", resp.ReadingsDeleted, resp.RetentionDays)
+			fmt.Fprintf(w, "Burst readings deleted:\t%d (older than %d hours)\n", resp.BurstReadingsDeleted, resp.BurstRetentionHours)
+			fmt.Fprintf(w, "Events deleted:\t%d\n", resp.EventsDeleted)
+			fmt.Fprintf(w, "Vacuumed:\t%t\n", resp.Vacuumed)
+			fmt.Fprintf(w, "Duration:\t%s\n", time.Duration(resp.DurationMs)*time.Millisecond)
+			return nil
+		})
+	},
+}
+
+var jobsDBStatsCmd = &cobra.Command{
+	Use:   "db-stats",
+	Short: "Show the database's size, row counts and range of readings",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.job.GetDatabaseStats(ctx, &jobv1.GetDatabaseStatsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to get database stats: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Backend:\t%s\n", resp.Backend)
+			fmt.Fprintf(w, "Size:\t%s\n", formatBytes(resp.SizeBytes))
+			if resp.Backend == "sqlite" {
+				fmt.Fprintf(w, "Free:\t%s (auto_vacuum %s)\n", formatBytes(resp.FreeBytes), resp.AutoVacuum)
+			}
+			fmt.Fprintf(w, "Oldest reading:\t%s\n", formatTime(resp.OldestReading))
+			fmt.Fprintf(w, "Newest reading:\t%s\n", formatTime(resp.NewestReading))
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "TABLE\tROWS")
+			for _, t := range resp.Tables {
+				fmt.Fprintf(w, "%s\t%d\n", t.Name, t.Rows)
+			}
+			return nil
+		})
+	},
+}
+
+var jobsForecastCmd = &cobra.Command{
+	Use:   "forecast",
+	Short: "Project the database's growth and when it outgrows its disk or quota",
+	Long: `Project the database's growth from the readings ingested in the last day and
+the data.retention_days setting. Readings stop growing once retention prunes
+as many as are ingested; rollups are kept and keep growing. Warns when the
+database is projected to outgrow the database.size_quota config or, with
+SQLite, the free space on its disk within --days.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		days, _ := cmd.Flags().GetInt32("days")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.job.GetStorageForecast(ctx, &jobv1.GetStorageForecastRequest{Days: days})
+		if err != nil {
+			return fmt.Errorf("failed to forecast storage: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Used:\t%s\n", formatBytes(resp.UsedBytes))
+			fmt.Fprintf(w, "Ingest:\t%d readings/day, %d rollups/day, %s each\n", resp.ReadingsPerDay, resp.RollupsPerDay, formatBytes(resp.BytesPerRow))
+			fmt.Fprintf(w, "Growth:\t%s/day\n", formatBytes(resp.GrowthBytesPerDay))
+			fmt.Fprintf(w, "Retention full:\t%s (%d days)\n", formatTime(resp.SteadyAt), resp.RetentionDays)
+			fmt.Fprintf(w, "Projected:\t%s by %s\n", formatBytes(resp.ProjectedBytes), formatTime(resp.ProjectedAt))
+			if resp.CapacityBytes > 0 {
+				fmt.Fprintf(w, "Capacity:\t%s (%s)\n", formatBytes(resp.CapacityBytes), resp.CapacityLimit)
+				fmt.Fprintf(w, "Full:\t%s\n", formatTime(resp.FullAt))
+			}
+			if resp.Warning != "" {
+				fmt.Fprintf(w, "Warning:\t%s\n", resp.Warning)
+			}
+			return nil
+		})
+	},
+}
+
+// formatBytes shows a size in binary units, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatJobRun shows when a job last ran, marking a run in progress
+func formatJobRun(j *jobv1.Job) string {
+	if j.Running {
+		return "running"
+	}
+	return formatTime(j.LastRunAt)
+}
+
+func init() {
+	jobsMaintenanceCmd.Flags().Bool("skip-vacuum", false, "Skip vacuum and analyze")
+	jobsForecastCmd.Flags().Int32("days", 30, "How far ahead to project")
+
+	jobsCmd.AddCommand(jobsListCmd, jobsRunCmd, jobsMaintenanceCmd, jobsDBStatsCmd, jobsForecastCmd)
+}
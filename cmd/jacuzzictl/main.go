@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var rootCmd = &cobra.Command{
+	Use:          "jacuzzictl",
+	Short:        "Jacuzzi admin CLI",
+	Long:         `jacuzzictl manages a Jacuzzi server over its gRPC API.`,
+	SilenceUsage: true,
+}
+
+func init() {
+	// Connection flags
+	rootCmd.PersistentFlags().String("server", "localhost:50051", "The server address")
+	rootCmd.PersistentFlags().Duration("timeout", 10*time.Second, "Request timeout")
+	rootCmd.PersistentFlags().String("token", "", "Auth token, when the server sets server.auth_token")
+
+	// Bind flags to viper
+	viper.BindPFlag("server.address", rootCmd.PersistentFlags().Lookup("server"))
+	viper.BindPFlag("server.timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	viper.BindEnv("server.address", "JACUZZICTL_SERVER")
+	viper.BindEnv("server.timeout", "JACUZZICTL_TIMEOUT")
+	viper.BindPFlag("server.token", rootCmd.PersistentFlags().Lookup("token"))
+	viper.BindEnv("server.token", "JACUZZICTL_TOKEN")
+
+	cli.AddOutputFlag(rootCmd)
+
+	rootCmd.AddCommand(clientsCmd, sensorsCmd, tempsCmd, alertsCmd, rulesCmd, settingsCmd, tokensCmd, commandsCmd, powerCmd, eventsCmd, jobsCmd, profilesCmd, notificationsCmd)
+	rootCmd.AddCommand(cli.NewCompletionCmd(rootCmd.Name()))
+}
+
+// apiClients bundles the service clients for a single connection
+type apiClients struct {
+	conn         *grpc.ClientConn
+	temperature  jacuzziv1.TemperatureServiceClient
+	client       jacuzziv1.ClientServiceClient
+	alert        jacuzziv1.AlertServiceClient
+	settings     jacuzziv1.SettingsServiceClient
+	command      jacuzziv1.CommandServiceClient
+	power        jacuzziv1.PowerServiceClient
+	event        jacuzziv1.EventServiceClient
+	job          jacuzziv1.JobServiceClient
+	profile      jacuzziv1.ProfileServiceClient
+	notification jacuzziv1.NotificationServiceClient
+}
+
+func (c *apiClients) Close() error {
+	return c.conn.Close()
+}
+
+// connect dials the configured server and returns a context bounded by the
+// request timeout
+func connect(cmd *cobra.Command) (*apiClients, context.Context, context.CancelFunc, error) {
+	conn, err := grpc.NewClient(viper.GetString("server.address"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), viper.GetDuration("server.timeout"))
+	if token := viper.GetString("server.token"); token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	return &apiClients{
+		conn:         conn,
+		temperature:  jacuzziv1.NewTemperatureServiceClient(conn),
+		client:       jacuzziv1.NewClientServiceClient(conn),
+		alert:        jacuzziv1.NewAlertServiceClient(conn),
+		settings:     jacuzziv1.NewSettingsServiceClient(conn),
+		command:      jacuzziv1.NewCommandServiceClient(conn),
+		power:        jacuzziv1.NewPowerServiceClient(conn),
+		event:        jacuzziv1.NewEventServiceClient(conn),
+		job:          jacuzziv1.NewJobServiceClient(conn),
+		profile:      jacuzziv1.NewProfileServiceClient(conn),
+		notification: jacuzziv1.NewNotificationServiceClient(conn),
+	}, ctx, cancel, nil
+}
+
+func formatTime(ts *timestamppb.Timestamp) string {
+	if ts == nil || ts.AsTime().IsZero() {
+		return "-"
+	}
+	return ts.AsTime().Local().Format(time.DateTime)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	notificationv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/notification/v1"
+	"github.com/spf13/cobra"
+)
+
+var notificationsCmd = &cobra.Command{
+	Use:   "notifications",
+	Short: "Manage notification channels and the routes that send alerts to them",
+	Long: `Notification channels are destinations such as an email list, a Slack
+incoming webhook or an HTTP endpoint, configured once. Routes send the alerts
+they match, by severity, sensor type and client metadata, to channels.
+Routes are checked in position order, up to the first matching route that
+stops.`,
+}
+
+var channelsCmd = &cobra.Command{
+	Use:   "channels",
+	Short: "Manage notification channels",
+}
+
+var channelsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List notification channels",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.notification.ListNotificationChannels(ctx, &notificationv1.ListNotificationChannelsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list channels: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tNAME\tTYPE\tCONFIG\tENABLED\tRESOLVED\tQUIET HOURS\tDIGEST\tHELD\tLAST DELIVERY\tLAST STATUS")
+			for _, c := range resp.Channels {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%t\t%s\t%s\t%d\t%s\t%s\n", c.Id, c.Name, channelTypeName(c.Type), formatSelector(c.Config), c.Enabled, c.SendResolved, formatQuietHours(c), formatDigest(c), c.Held, formatTime(c.LastDeliveryAt), orDash(c.LastStatus))
+			}
+			return nil
+		})
+	},
+}
+
+var channelsCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a notification channel",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		channel := &notificationv1.NotificationChannel{Enabled: true}
+		if err := applyChannelFlags(cmd, channel); err != nil {
+			return err
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.notification.CreateNotificationChannel(ctx, &notificationv1.CreateNotificationChannelRequest{Channel: channel})
+		if err != nil {
+			return fmt.Errorf("failed to create channel: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printChannel(w, resp.Channel)
+			return nil
+		})
+	},
+}
+
+var channelsUpdateCmd = &cobra.Command{
+	Use:   "update <channel-id>",
+	Short: "Change the given fields of a notification channel",
+	Long: `Change the given fields of a notification channel. --config replaces the
+whole config, except secrets it leaves out, which are kept.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		list, err := api.notification.ListNotificationChannels(ctx, &notificationv1.ListNotificationChannelsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to get channel: %w", err)
+		}
+		var channel *notificationv1.NotificationChannel
+		for _, c := range list.Channels {
+			if c.Id == args[0] {
+				channel = c
+			}
+		}
+		if channel == nil {
+			return fmt.Errorf("channel %s not found", args[0])
+		}
+		if err := applyChannelFlags(cmd, channel); err != nil {
+			return err
+		}
+
+		resp, err := api.notification.UpdateNotificationChannel(ctx, &notificationv1.UpdateNotificationChannelRequest{Channel: channel})
+		if err != nil {
+			return fmt.Errorf("failed to update channel: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printChannel(w, resp.Channel)
+			return nil
+		})
+	},
+}
+
+var channelsDeleteCmd = &cobra.Command{
+	Use:   "delete <channel-id>",
+	Short: "Delete a notification channel no route uses",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.notification.DeleteNotificationChannel(ctx, &notificationv1.DeleteNotificationChannelRequest{Id: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to delete channel: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		})
+	},
+}
+
+var channelsTestCmd = &cobra.Command{
+	Use:   "test <channel-id>",
+	Short: "Send a test notification through a channel",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.notification.TestNotificationChannel(ctx, &notificationv1.TestNotificationChannelRequest{Id: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to test channel: %w", err)
+		}
+
+		if err := cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !resp.Success {
+			return fmt.Errorf("test notification failed")
+		}
+		return nil
+	},
+}
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Manage the routes that send alerts to notification channels",
+}
+
+var routesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List notification routes in the order they are checked",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.notification.ListNotificationRoutes(ctx, &notificationv1.ListNotificationRoutesRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list routes: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tNAME\tPOSITION\tSEVERITIES\tSENSOR TYPES\tSELECTOR\tCHANNELS\tSTOP\tENABLED")
+			for _, r := range resp.Routes {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%t\t%t\n", r.Id, r.Name, r.Position, formatSeverities(r.Severities), orDash(strings.Join(r.SensorTypes, ",")), formatSelector(r.MetadataSelector), strings.Join(r.ChannelIds, ","), r.Stop, r.Enabled)
+			}
+			return nil
+		})
+	},
+}
+
+var routesCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a notification route",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		route := &notificationv1.NotificationRoute{Enabled: true}
+		if err := applyRouteFlags(cmd, route); err != nil {
+			return err
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.notification.CreateNotificationRoute(ctx, &notificationv1.CreateNotificationRouteRequest{Route: route})
+		if err != nil {
+			return fmt.Errorf("failed to create route: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printRoute(w, resp.Route)
+			return nil
+		})
+	},
+}
+
+var routesUpdateCmd = &cobra.Command{
+	Use:   "update <route-id>",
+	Short: "Change the given fields of a notification route",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		list, err := api.notification.ListNotificationRoutes(ctx, &notificationv1.ListNotificationRoutesRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to get route: %w", err)
+		}
+		var route *notificationv1.NotificationRoute
+		for _, r := range list.Routes {
+			if r.Id == args[0] {
+				route = r
+			}
+		}
+		if route == nil {
+			return fmt.Errorf("route %s not found", args[0])
+		}
+		if err := applyRouteFlags(cmd, route); err != nil {
+			return err
+		}
+
+		resp, err := api.notification.UpdateNotificationRoute(ctx, &notificationv1.UpdateNotificationRouteRequest{Route: route})
+		if err != nil {
+			return fmt.Errorf("failed to update route: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printRoute(w, resp.Route)
+			return nil
+		})
+	},
+}
+
+var routesDeleteCmd = &cobra.Command{
+	Use:   "delete <route-id>",
+	Short: "Delete a notification route",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.notification.DeleteNotificationRoute(ctx, &notificationv1.DeleteNotificationRouteRequest{Id: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to delete route: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		})
+	},
+}
+
+// applyChannelFlags copies the channel flags that were given onto channel
+func applyChannelFlags(cmd *cobra.Command, channel *notificationv1.NotificationChannel) error {
+	flags := cmd.Flags()
+	if flags.Changed("name") {
+		channel.Name, _ = flags.GetString("name")
+	}
+	if flags.Changed("type") {
+		name, _ := flags.GetString("type")
+		value, ok := notificationv1.NotificationChannel_Type_value["TYPE_"+strings.ToUpper(name)]
+		if !ok || value == 0 {
+			return fmt.Errorf("invalid channel type %q: want email, slack or webhook", name)
+		}
+		channel.Type = notificationv1.NotificationChannel_Type(value)
+	}
+	if flags.Changed("config") {
+		channel.Config, _ = flags.GetStringToString("config")
+	}
+	if flags.Changed("enabled") {
+		channel.Enabled, _ = flags.GetBool("enabled")
+	}
+	if flags.Changed("send-resolved") {
+		channel.SendResolved, _ = flags.GetBool("send-resolved")
+	}
+	if flags.Changed("quiet-hours") {
+		channel.QuietHours, _ = flags.GetString("quiet-hours")
+	}
+	if flags.Changed("quiet-bypass") {
+		severity, err := bypassFlag(cmd, "quiet-bypass")
+		if err != nil {
+			return err
+		}
+		channel.QuietBypassSeverity = severity
+	}
+	if flags.Changed("digest-interval") {
+		interval, _ := flags.GetDuration("digest-interval")
+		if interval%time.Second != 0 {
+			return fmt.Errorf("--digest-interval must be whole seconds")
+		}
+		channel.DigestIntervalSeconds = int32(interval / time.Second)
+	}
+	if flags.Changed("digest-bypass") {
+		severity, err := bypassFlag(cmd, "digest-bypass")
+		if err != nil {
+			return err
+		}
+		channel.DigestBypassSeverity = severity
+	}
+	if flags.Changed("locale") {
+		channel.Locale, _ = flags.GetString("locale")
+	}
+	return nil
+}
+
+// bypassFlag parses a flag of the severity from which notifications are sent
+// at once; empty for none
+func bypassFlag(cmd *cobra.Command, name string) (alertv1.Severity, error) {
+	value, _ := cmd.Flags().GetString(name)
+	if value == "" {
+		return alertv1.Severity_SEVERITY_UNSPECIFIED, nil
+	}
+	severity, ok := alertv1.Severity_value["SEVERITY_"+strings.ToUpper(value)]
+	if !ok || severity == 0 {
+		return 0, fmt.Errorf("invalid --%s %q: want info, warning or critical", name, value)
+	}
+	return alertv1.Severity(severity), nil
+}
+
+// applyRouteFlags copies the route flags that were given onto route
+func applyRouteFlags(cmd *cobra.Command, route *notificationv1.NotificationRoute) error {
+	flags := cmd.Flags()
+	if flags.Changed("name") {
+		route.Name, _ = flags.GetString("name")
+	}
+	if flags.Changed("position") {
+		route.Position, _ = flags.GetInt32("position")
+	}
+	if flags.Changed("severity") {
+		names, _ := flags.GetStringSlice("severity")
+		route.Severities = nil
+		for _, name := range names {
+			value, ok := alertv1.Severity_value["SEVERITY_"+strings.ToUpper(name)]
+			if !ok || value == 0 {
+				return fmt.Errorf("invalid severity %q: want info, warning or critical", name)
+			}
+			route.Severities = append(route.Severities, alertv1.Severity(value))
+		}
+	}
+	if flags.Changed("sensor-type") {
+		route.SensorTypes, _ = flags.GetStringSlice("sensor-type")
+	}
+	if flags.Changed("selector") {
+		route.MetadataSelector, _ = flags.GetStringToString("selector")
+	}
+	if flags.Changed("channel") {
+		route.ChannelIds, _ = flags.GetStringSlice("channel")
+	}
+	if flags.Changed("stop") {
+		route.Stop, _ = flags.GetBool("stop")
+	}
+	if flags.Changed("enabled") {
+		route.Enabled, _ = flags.GetBool("enabled")
+	}
+	return nil
+}
+
+func printChannel(w io.Writer, c *notificationv1.NotificationChannel) {
+	fmt.Fprintf(w, "ID:\t%s\n", c.Id)
+	fmt.Fprintf(w, "Name:\t%s\n", c.Name)
+	fmt.Fprintf(w, "Type:\t%s\n", channelTypeName(c.Type))
+	fmt.Fprintf(w, "Config:\t%s\n", formatSelector(c.Config))
+	fmt.Fprintf(w, "Enabled:\t%t\n", c.Enabled)
+	fmt.Fprintf(w, "Send resolved:\t%t\n", c.SendResolved)
+	fmt.Fprintf(w, "Quiet hours:\t%s\n", formatQuietHours(c))
+	fmt.Fprintf(w, "Digest:\t%s\n", formatDigest(c))
+	fmt.Fprintf(w, "Locale:\t%s\n", orDash(c.Locale))
+}
+
+func printRoute(w io.Writer, r *notificationv1.NotificationRoute) {
+	fmt.Fprintf(w, "ID:\t%s\n", r.Id)
+	fmt.Fprintf(w, "Name:\t%s\n", r.Name)
+	fmt.Fprintf(w, "Position:\t%d\n", r.Position)
+	fmt.Fprintf(w, "Severities:\t%s\n", formatSeverities(r.Severities))
+	fmt.Fprintf(w, "Sensor types:\t%s\n", orDash(strings.Join(r.SensorTypes, ",")))
+	fmt.Fprintf(w, "Selector:\t%s\n", formatSelector(r.MetadataSelector))
+	fmt.Fprintf(w, "Channels:\t%s\n", strings.Join(r.ChannelIds, ","))
+	fmt.Fprintf(w, "Stop:\t%t\n", r.Stop)
+	fmt.Fprintf(w, "Enabled:\t%t\n", r.Enabled)
+}
+
+// channelTypeName is a channel type as given to --type, e.g. slack
+func channelTypeName(t notificationv1.NotificationChannel_Type) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "TYPE_"))
+}
+
+// formatQuietHours describes a channel's quiet hours, e.g.
+// "* 23,0-6 * * * (critical sent anyway)"
+func formatQuietHours(c *notificationv1.NotificationChannel) string {
+	if c.QuietHours == "" {
+		return "-"
+	}
+	return withBypass(c.QuietHours, c.QuietBypassSeverity)
+}
+
+// formatDigest describes how often a channel sends digests, e.g.
+// "every 1h0m0s (critical sent anyway)"
+func formatDigest(c *notificationv1.NotificationChannel) string {
+	if c.DigestIntervalSeconds == 0 {
+		return "-"
+	}
+	every := fmt.Sprintf("every %s", time.Duration(c.DigestIntervalSeconds)*time.Second)
+	return withBypass(every, c.DigestBypassSeverity)
+}
+
+func withBypass(description string, bypass alertv1.Severity) string {
+	if bypass == alertv1.Severity_SEVERITY_UNSPECIFIED {
+		return description
+	}
+	return fmt.Sprintf("%s (%s sent anyway)", description, formatSeverities([]alertv1.Severity{bypass}))
+}
+
+func formatSeverities(severities []alertv1.Severity) string {
+	if len(severities) == 0 {
+		return "any"
+	}
+	names := make([]string, len(severities))
+	for i, severity := range severities {
+		names[i] = strings.ToLower(strings.TrimPrefix(severity.String(), "SEVERITY_"))
+	}
+	return strings.Join(names, ",")
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{channelsCreateCmd, channelsUpdateCmd} {
+		cmd.Flags().String("name", "", "Channel name")
+		cmd.Flags().String("type", "", "Channel type: email, slack or webhook")
+		cmd.Flags().StringToString("config", nil, "Channel settings, e.g. recipients=ops@example.com for email, url=... for slack and webhook")
+		cmd.Flags().Bool("enabled", true, "Deliver notifications routed to the channel")
+		cmd.Flags().Bool("send-resolved", false, "Also notify when alerts resolve")
+		cmd.Flags().String("quiet-hours", "", `Minutes to hold notifications for a digest, as a cron expression in the general.timezone setting's zone, e.g. "* 23,0-6 * * *"`)
+		cmd.Flags().String("quiet-bypass", "", "Severity from which notifications are sent during quiet hours anyway, of info, warning and critical")
+		cmd.Flags().Duration("digest-interval", 0, "Batch notifications into a digest sent this long after the first, e.g. 1h; 0 sends each at once")
+		cmd.Flags().String("digest-bypass", "", "Severity from which notifications skip the digest, of info, warning and critical")
+		cmd.Flags().String("locale", "", "Language of the channel's notifications, en or de; empty for the general.locale setting")
+	}
+	channelsCreateCmd.MarkFlagRequired("name")
+	channelsCreateCmd.MarkFlagRequired("type")
+
+	for _, cmd := range []*cobra.Command{routesCreateCmd, routesUpdateCmd} {
+		cmd.Flags().String("name", "", "Route name")
+		cmd.Flags().Int32("position", 0, "Routes with lower positions are checked first")
+		cmd.Flags().StringSlice("severity", nil, "Only alerts of these severities, of info, warning and critical")
+		cmd.Flags().StringSlice("sensor-type", nil, "Only alerts of these sensor types, e.g. CPU,GPU")
+		cmd.Flags().StringToString("selector", nil, "Only alerts of clients with these metadata values, e.g. team=storage")
+		cmd.Flags().StringSlice("channel", nil, "IDs of the channels to send matching alerts to")
+		cmd.Flags().Bool("stop", false, "Check no later routes for alerts this route matches")
+		cmd.Flags().Bool("enabled", true, "Route alerts with this route")
+	}
+	routesCreateCmd.MarkFlagRequired("name")
+	routesCreateCmd.MarkFlagRequired("channel")
+
+	channelsCmd.AddCommand(channelsListCmd, channelsCreateCmd, channelsUpdateCmd, channelsDeleteCmd, channelsTestCmd)
+	routesCmd.AddCommand(routesListCmd, routesCreateCmd, routesUpdateCmd, routesDeleteCmd)
+	notificationsCmd.AddCommand(channelsCmd, routesCmd)
+}
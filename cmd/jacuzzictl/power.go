@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	powerv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/power/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var powerCmd = &cobra.Command{
+	Use:   "power",
+	Short: "Wake client machines or control their power through IPMI",
+	Long: `Wake client machines with Wake-on-LAN or power them on, off, or cycle them
+through their BMC with IPMI. The server must run with power.enabled; store a
+client's MAC address and BMC credentials with "jacuzzictl power set". BMC
+passwords are stored encrypted, so the server also needs power.secret_key.`,
+}
+
+var powerGetCmd = &cobra.Command{
+	Use:   "get <client-id>",
+	Short: "Show how the server reaches a client's machine",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.power.GetPowerConfig(ctx, &powerv1.GetPowerConfigRequest{ClientId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to get power config: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			c := resp.Config
+			fmt.Fprintf(w, "Client:\t%s\n", c.ClientId)
+			fmt.Fprintf(w, "MAC address:\t%s\n", orDash(c.MacAddress))
+			fmt.Fprintf(w, "Wake broadcast:\t%s\n", orDash(c.WakeBroadcast))
+			fmt.Fprintf(w, "BMC address:\t%s\n", orDash(c.BmcAddress))
+			fmt.Fprintf(w, "BMC username:\t%s\n", orDash(c.BmcUsername))
+			fmt.Fprintf(w, "BMC password:\t%s\n", formatBool(c.HasBmcPassword, "set", "not set"))
+			fmt.Fprintf(w, "BMC interface:\t%s\n", orDash(c.BmcInterface))
+			fmt.Fprintf(w, "Power actions:\t%s\n", formatBool(resp.PowerEnabled, "enabled", "disabled on server"))
+			return nil
+		})
+	},
+}
+
+var powerSetCmd = &cobra.Command{
+	Use:   "set <client-id>",
+	Short: "Set how the server reaches a client's machine",
+	Long: `Set how the server reaches a client's machine. Only the given flags change;
+pass an empty value to clear one. The BMC password is read from standard input
+with --bmc-password-stdin so it stays out of shell history, for example:
+
+  jacuzzictl power set node-07 --mac 3c:ec:ef:12:34:56 --bmc 10.0.9.7 --bmc-user admin
+  pass show bmc/node-07 | jacuzzictl power set node-07 --bmc-password-stdin`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		config := &powerv1.PowerConfig{ClientId: args[0]}
+		current, err := api.power.GetPowerConfig(ctx, &powerv1.GetPowerConfigRequest{ClientId: args[0]})
+		switch {
+		case err == nil:
+			config = current.Config
+		case status.Code(err) != codes.NotFound:
+			return fmt.Errorf("failed to get power config: %w", err)
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("mac") {
+			config.MacAddress, _ = flags.GetString("mac")
+		}
+		if flags.Changed("broadcast") {
+			config.WakeBroadcast, _ = flags.GetString("broadcast")
+		}
+		if flags.Changed("bmc") {
+			config.BmcAddress, _ = flags.GetString("bmc")
+		}
+		if flags.Changed("bmc-user") {
+			config.BmcUsername, _ = flags.GetString("bmc-user")
+		}
+		if flags.Changed("bmc-interface") {
+			config.BmcInterface, _ = flags.GetString("bmc-interface")
+		}
+		if passwordStdin, _ := flags.GetBool("bmc-password-stdin"); passwordStdin {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to read BMC password: %w", err)
+			}
+			config.BmcPassword = strings.TrimRight(line, "\r\n")
+			if config.BmcPassword == "" {
+				return fmt.Errorf("no BMC password on standard input")
+			}
+		}
+		clearPassword, _ := flags.GetBool("clear-bmc-password")
+
+		resp, err := api.power.SetPowerConfig(ctx, &powerv1.SetPowerConfigRequest{
+			Config:           config,
+			ClearBmcPassword: clearPassword,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set power config: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Power config of %s updated\n", resp.Config.ClientId)
+			return nil
+		})
+	},
+}
+
+var powerDeleteCmd = &cobra.Command{
+	Use:   "delete <client-id>",
+	Short: "Delete a client's power config, including its BMC credentials",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.power.DeletePowerConfig(ctx, &powerv1.DeletePowerConfigRequest{ClientId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to delete power config: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		})
+	},
+}
+
+// newPowerActionCmd returns a subcommand that takes one power action
+func newPowerActionCmd(use, short string, action powerv1.PowerAction) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <client-id>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel, err := connect(cmd)
+			if err != nil {
+				return err
+			}
+			defer cancel()
+			defer api.Close()
+
+			resp, err := api.power.PowerAction(ctx, &powerv1.PowerActionRequest{ClientId: args[0], Action: action})
+			if err != nil {
+				return fmt.Errorf("failed to %s %s: %w", use, args[0], err)
+			}
+
+			return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+				fmt.Fprintln(w, resp.Result)
+				return nil
+			})
+		},
+	}
+}
+
+func formatBool(value bool, yes, no string) string {
+	if value {
+		return yes
+	}
+	return no
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	powerSetCmd.Flags().String("mac", "", "MAC address for Wake-on-LAN")
+	powerSetCmd.Flags().String("broadcast", "", "Address (host[:port]) Wake-on-LAN packets are broadcast to; the server default when empty")
+	powerSetCmd.Flags().String("bmc", "", "BMC address (host[:port]) for IPMI")
+	powerSetCmd.Flags().String("bmc-user", "", "BMC username")
+	powerSetCmd.Flags().String("bmc-interface", "", "ipmitool interface: lan, lanplus (default) or open")
+	powerSetCmd.Flags().Bool("bmc-password-stdin", false, "Read the BMC password from standard input")
+	powerSetCmd.Flags().Bool("clear-bmc-password", false, "Remove the stored BMC password")
+
+	powerCmd.AddCommand(powerGetCmd, powerSetCmd, powerDeleteCmd,
+		newPowerActionCmd("wake", "Wake a client's machine with a Wake-on-LAN packet", powerv1.PowerAction_POWER_ACTION_WAKE),
+		newPowerActionCmd("on", "Power on a client's machine through IPMI", powerv1.PowerAction_POWER_ACTION_ON),
+		newPowerActionCmd("off", "Power off a client's machine through IPMI without shutting it down", powerv1.PowerAction_POWER_ACTION_OFF),
+		newPowerActionCmd("soft-off", "Ask a client's machine to shut down through IPMI", powerv1.PowerAction_POWER_ACTION_SOFT_OFF),
+		newPowerActionCmd("cycle", "Power cycle a client's machine through IPMI", powerv1.PowerAction_POWER_ACTION_CYCLE),
+		newPowerActionCmd("reset", "Hard reset a client's machine through IPMI", powerv1.PowerAction_POWER_ACTION_RESET),
+		newPowerActionCmd("status", "Show a client's chassis power status through IPMI", powerv1.PowerAction_POWER_ACTION_STATUS),
+	)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	profilev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/profile/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage configuration profiles sent to agents",
+	Long: `Configuration profiles set the report interval, monitored sensor kinds and
+temperature thresholds of the agents at a site or with metadata values.
+Agents on metered links can be given report thresholds, so they only submit
+sensors that reach them, plus a heartbeat of every sensor for presence and
+charts.
+Profiles that apply to a client are layered by priority, then the client's
+own override, and the result is queued for agents started with
+commands.remote_config enabled.`,
+}
+
+var profilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configuration profiles, highest priority first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.profile.ListConfigProfiles(ctx, &profilev1.ListConfigProfilesRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list profiles: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tNAME\tPRIORITY\tSITE\tSELECTOR\tCONFIG")
+			for _, p := range resp.Profiles {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", p.Id, p.Name, p.Priority, orDash(p.Site), formatSelector(p.MetadataSelector), formatAgentConfig(p.Config))
+			}
+			return nil
+		})
+	},
+}
+
+var profilesCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a configuration profile",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profile := &profilev1.ConfigProfile{Config: &profilev1.AgentConfig{}}
+		if err := applyProfileFlags(cmd, profile); err != nil {
+			return err
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.profile.CreateConfigProfile(ctx, &profilev1.CreateConfigProfileRequest{Profile: profile})
+		if err != nil {
+			return fmt.Errorf("failed to create profile: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printProfile(w, resp.Profile)
+			fmt.Fprintf(w, "Clients updated:\t%d\n", resp.ClientsUpdated)
+			return nil
+		})
+	},
+}
+
+var profilesUpdateCmd = &cobra.Command{
+	Use:   "update <profile-id>",
+	Short: "Change the given fields of a configuration profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		list, err := api.profile.ListConfigProfiles(ctx, &profilev1.ListConfigProfilesRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to get profile: %w", err)
+		}
+		var profile *profilev1.ConfigProfile
+		for _, p := range list.Profiles {
+			if p.Id == args[0] {
+				profile = p
+			}
+		}
+		if profile == nil {
+			return fmt.Errorf("profile %s not found", args[0])
+		}
+		if profile.Config == nil {
+			profile.Config = &profilev1.AgentConfig{}
+		}
+		if err := applyProfileFlags(cmd, profile); err != nil {
+			return err
+		}
+
+		resp, err := api.profile.UpdateConfigProfile(ctx, &profilev1.UpdateConfigProfileRequest{Profile: profile})
+		if err != nil {
+			return fmt.Errorf("failed to update profile: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printProfile(w, resp.Profile)
+			fmt.Fprintf(w, "Clients updated:\t%d\n", resp.ClientsUpdated)
+			return nil
+		})
+	},
+}
+
+var profilesDeleteCmd = &cobra.Command{
+	Use:   "delete <profile-id>",
+	Short: "Delete a configuration profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.profile.DeleteConfigProfile(ctx, &profilev1.DeleteConfigProfileRequest{Id: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to delete profile: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "%s (%d clients updated)\n", resp.Message, resp.ClientsUpdated)
+			return nil
+		})
+	},
+}
+
+var profilesShowCmd = &cobra.Command{
+	Use:   "show <client-id>",
+	Short: "Show the configuration a client is sent and where it comes from",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.profile.GetClientConfig(ctx, &profilev1.GetClientConfigRequest{ClientId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to get client configuration: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printClientConfig(w, resp.Config)
+			return nil
+		})
+	},
+}
+
+var profilesOverrideCmd = &cobra.Command{
+	Use:   "override <client-id>",
+	Short: "Set a client's own configuration, layered over its profiles",
+	Long: `Set a client's own configuration, layered over the profiles that apply to
+it. The flags given replace the whole override; --clear removes it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := &profilev1.AgentConfig{}
+		if clear, _ := cmd.Flags().GetBool("clear"); !clear {
+			if err := applyAgentConfigFlags(cmd, config); err != nil {
+				return err
+			}
+			if proto.Size(config) == 0 {
+				return fmt.Errorf("set --interval, --collectors, --threshold, --report-threshold or --heartbeat, or --clear to remove the override")
+			}
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.profile.SetClientConfigOverride(ctx, &profilev1.SetClientConfigOverrideRequest{ClientId: args[0], Config: config})
+		if err != nil {
+			return fmt.Errorf("failed to set client configuration: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			printClientConfig(w, resp.Config)
+			return nil
+		})
+	},
+}
+
+// applyProfileFlags copies the profile flags that were given onto profile
+func applyProfileFlags(cmd *cobra.Command, profile *profilev1.ConfigProfile) error {
+	flags := cmd.Flags()
+	if flags.Changed("name") {
+		profile.Name, _ = flags.GetString("name")
+	}
+	if flags.Changed("description") {
+		profile.Description, _ = flags.GetString("description")
+	}
+	if flags.Changed("site") {
+		profile.Site, _ = flags.GetString("site")
+	}
+	if flags.Changed("selector") {
+		profile.MetadataSelector, _ = flags.GetStringToString("selector")
+	}
+	if flags.Changed("priority") {
+		profile.Priority, _ = flags.GetInt32("priority")
+	}
+	return applyAgentConfigFlags(cmd, profile.Config)
+}
+
+// applyAgentConfigFlags copies the configuration flags that were given onto
+// config
+func applyAgentConfigFlags(cmd *cobra.Command, config *profilev1.AgentConfig) error {
+	flags := cmd.Flags()
+	if flags.Changed("interval") {
+		interval, _ := flags.GetDuration("interval")
+		if interval%time.Second != 0 {
+			return fmt.Errorf("--interval must be whole seconds")
+		}
+		config.IntervalSeconds = int32(interval / time.Second)
+	}
+	if flags.Changed("collectors") {
+		config.Collectors, _ = flags.GetStringSlice("collectors")
+	}
+	if flags.Changed("threshold") {
+		thresholds, err := thresholdFlag(cmd, "threshold")
+		if err != nil {
+			return err
+		}
+		config.Thresholds = thresholds
+	}
+	if flags.Changed("report-threshold") {
+		thresholds, err := thresholdFlag(cmd, "report-threshold")
+		if err != nil {
+			return err
+		}
+		config.ReportThresholds = thresholds
+	}
+	if flags.Changed("heartbeat") {
+		heartbeat, _ := flags.GetDuration("heartbeat")
+		if heartbeat%time.Second != 0 {
+			return fmt.Errorf("--heartbeat must be whole seconds")
+		}
+		config.HeartbeatSeconds = int32(heartbeat / time.Second)
+	}
+	return nil
+}
+
+// thresholdFlag parses a flag of °C by sensor type
+func thresholdFlag(cmd *cobra.Command, name string) (map[string]float64, error) {
+	values, _ := cmd.Flags().GetStringToString(name)
+	thresholds := make(map[string]float64, len(values))
+	for sensorType, value := range values {
+		celsius, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s %s=%s: %w", name, sensorType, value, err)
+		}
+		thresholds[sensorType] = celsius
+	}
+	return thresholds, nil
+}
+
+func printProfile(w io.Writer, p *profilev1.ConfigProfile) {
+	fmt.Fprintf(w, "ID:\t%s\n", p.Id)
+	fmt.Fprintf(w, "Name:\t%s\n", p.Name)
+	if p.Description != "" {
+		fmt.Fprintf(w, "Description:\t%s\n", p.Description)
+	}
+	fmt.Fprintf(w, "Priority:\t%d\n", p.Priority)
+	fmt.Fprintf(w, "Site:\t%s\n", orDash(p.Site))
+	fmt.Fprintf(w, "Selector:\t%s\n", formatSelector(p.MetadataSelector))
+	fmt.Fprintf(w, "Config:\t%s\n", formatAgentConfig(p.Config))
+}
+
+func printClientConfig(w io.Writer, c *profilev1.ClientConfig) {
+	fmt.Fprintf(w, "Client:\t%s\n", c.ClientId)
+	fmt.Fprintf(w, "Resolved:\t%s\n", formatAgentConfig(c.Resolved))
+	fmt.Fprintf(w, "Profiles:\t%s\n", orDash(strings.Join(c.ProfileIds, ", ")))
+	fmt.Fprintf(w, "Override:\t%s\n", formatAgentConfig(c.Override))
+	fmt.Fprintf(w, "Revision sent:\t%s\n", orDash(c.Revision))
+	if !c.AcceptsConfig {
+		fmt.Fprintln(w, "Note:\tthe agent does not accept remote configuration (commands.remote_config)")
+	}
+}
+
+func formatAgentConfig(config *profilev1.AgentConfig) string {
+	var parts []string
+	if config.GetIntervalSeconds() > 0 {
+		parts = append(parts, fmt.Sprintf("interval=%s", time.Duration(config.IntervalSeconds)*time.Second))
+	}
+	if len(config.GetCollectors()) > 0 {
+		parts = append(parts, "collectors="+strings.Join(config.Collectors, ","))
+	}
+	parts = append(parts, formatThresholds(config.GetThresholds(), "%s>%g°C")...)
+	if len(config.GetReportThresholds()) > 0 {
+		parts = append(parts, "report if "+strings.Join(formatThresholds(config.ReportThresholds, "%s>=%g°C"), ","))
+	}
+	if config.GetHeartbeatSeconds() > 0 {
+		parts = append(parts, fmt.Sprintf("heartbeat=%s", time.Duration(config.HeartbeatSeconds)*time.Second))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatThresholds formats thresholds in sensor type order
+func formatThresholds(thresholds map[string]float64, format string) []string {
+	types := make([]string, 0, len(thresholds))
+	for sensorType := range thresholds {
+		types = append(types, sensorType)
+	}
+	sort.Strings(types)
+	for i, sensorType := range types {
+		types[i] = fmt.Sprintf(format, sensorType, thresholds[sensorType])
+	}
+	return types
+}
+
+func formatSelector(selector map[string]string) string {
+	if len(selector) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(selector))
+	for key, value := range selector {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func addAgentConfigFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.Duration("interval", 0, "Report interval")
+	flags.StringSlice("collectors", nil, "Sensor kinds to report, of cpu, gpu and disk")
+	flags.StringToString("threshold", nil, "°C above which the agent warns, by sensor type, e.g. CPU=90,DISK=55")
+	flags.StringToString("report-threshold", nil, "°C a sensor must reach to be submitted between heartbeats, by sensor type, e.g. CPU=70")
+	flags.Duration("heartbeat", 0, "How often an agent with report thresholds submits every sensor (default 4m)")
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{profilesCreateCmd, profilesUpdateCmd} {
+		cmd.Flags().String("name", "", "Profile name")
+		cmd.Flags().String("description", "", "What the profile is for")
+		cmd.Flags().String("site", "", "Only apply to clients at this site")
+		cmd.Flags().StringToString("selector", nil, "Only apply to clients with these metadata values, e.g. role=storage")
+		cmd.Flags().Int32("priority", 0, "Profiles with higher priority are layered over lower ones")
+		addAgentConfigFlags(cmd)
+	}
+	profilesCreateCmd.MarkFlagRequired("name")
+	addAgentConfigFlags(profilesOverrideCmd)
+	profilesOverrideCmd.Flags().Bool("clear", false, "Remove the client's override")
+
+	profilesCmd.AddCommand(profilesListCmd, profilesCreateCmd, profilesUpdateCmd, profilesDeleteCmd, profilesShowCmd, profilesOverrideCmd)
+}
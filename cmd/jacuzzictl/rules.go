@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gopkg.in/yaml.v3"
+)
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Manage alert rules",
+}
+
+var rulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List alert rules",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.alert.ListAlertRules(ctx, &alertv1.ListAlertRulesRequest{Limit: 1000})
+		if err != nil {
+			return fmt.Errorf("failed to list alert rules: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tNAME\tSEVERITY\tTARGET\tCONDITION\tENABLED")
+			for _, r := range resp.Rules {
+				target := "all"
+				switch {
+				case r.SensorId != "":
+					target = "sensor=" + r.SensorId
+				case r.ClientId != "" && r.SensorType != "":
+					target = "client=" + r.ClientId + ",type=" + r.SensorType
+				case r.ClientId != "":
+					target = "client=" + r.ClientId
+				case r.SensorType != "":
+					target = "type=" + r.SensorType
+				}
+				if r.Site != "" {
+					target += ",site=" + r.Site
+				}
+				for _, key := range slices.Sorted(maps.Keys(r.MetadataSelector)) {
+					target += "," + key + "=" + r.MetadataSelector[key]
+				}
+				target = strings.TrimPrefix(target, "all,")
+				condition := fmt.Sprintf("%s %.1f for %ds", r.Condition.GetOperator(), r.Condition.GetThreshold(), r.Condition.GetDurationSeconds())
+				switch clear := r.Condition.GetClear().(type) {
+				case *alertv1.AlertCondition_ClearThreshold:
+					condition += fmt.Sprintf(" clear at %.1f", clear.ClearThreshold)
+				case *alertv1.AlertCondition_ClearPercent:
+					condition += fmt.Sprintf(" clear at %g%%", clear.ClearPercent)
+				}
+				if windows := len(r.Condition.GetWindows()); windows == 1 {
+					condition += " (+1 window)"
+				} else if windows > 1 {
+					condition += fmt.Sprintf(" (+%d windows)", windows)
+				}
+				switch r.Condition.GetType() {
+				case alertv1.AlertCondition_TYPE_STALE_SENSOR:
+					condition = fmt.Sprintf("sensor silent for %ds", r.Condition.GetDurationSeconds())
+				case alertv1.AlertCondition_TYPE_CLIENT_OFFLINE:
+					condition = fmt.Sprintf("client silent for %ds", r.Condition.GetDurationSeconds())
+				case alertv1.AlertCondition_TYPE_SENSOR_QUALITY:
+					condition = fmt.Sprintf("quality score below %g", r.Condition.GetThreshold())
+				case alertv1.AlertCondition_TYPE_EXTERNAL:
+					condition = "received from alert receiver"
+				case alertv1.AlertCondition_TYPE_THERMAL_BUDGET:
+					period := "day"
+					if r.Condition.GetPeriod() == temperaturev1.DutyCyclePeriod_DUTY_CYCLE_PERIOD_WEEK {
+						period = "week"
+					}
+					condition = fmt.Sprintf("above %.1f for over %ds a %s", r.Condition.GetThreshold(), r.Condition.GetDurationSeconds(), period)
+				case alertv1.AlertCondition_TYPE_AGGREGATE:
+					aggregate := strings.ToLower(strings.TrimPrefix(r.Condition.GetAggregate().String(), "AGGREGATE_"))
+					condition = aggregate + " " + condition
+				}
+				enabled := fmt.Sprint(r.Enabled)
+				if r.SnoozedUntil != nil {
+					enabled += " (snoozed until " + formatTime(r.SnoozedUntil) + ")"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Id, r.Name, r.Severity, target, condition, enabled)
+			}
+			return nil
+		})
+	},
+}
+
+var rulesApplyCmd = &cobra.Command{
+	Use:   "apply -f rules.yaml",
+	Short: "Create or update alert rules from a file",
+	Long: `Create or update alert rules from a YAML or JSON file.
+
+Rules are matched to existing rules by name; matching rules are updated and
+the rest are created. The file holds a list of rules under "rules", using the
+AlertRule field names from the API:
+
+  rules:
+    - name: CPU too hot
+      sensor_type: CPU
+      severity: SEVERITY_CRITICAL
+      condition:
+        operator: OPERATOR_GREATER_THAN
+        threshold: 85
+        duration_seconds: 60
+        # Resolve only once back below 80, so a value hovering around 85
+        # does not raise an alert on every crossing
+        clear_threshold: 80
+        # Allow more heat during the nightly batch jobs, in the
+        # general.timezone setting's zone
+        windows:
+          - name: nightly batch
+            schedule: "* 1-4 * * *"
+            threshold: 95
+      actions:
+        - type: ACTION_TYPE_LOG
+        # Write the alert to the server's journald or syslog, with its
+        # rule, client, sensor and value as fields
+        - type: ACTION_TYPE_SYSLOG
+          config:
+            facility: local0
+      enabled: true
+    - name: Rack 1 running hot
+      sensor_type: CPU
+      metadata_selector:
+        rack: rack-1
+      condition:
+        type: TYPE_AGGREGATE
+        aggregate: AGGREGATE_AVERAGE
+        operator: OPERATOR_GREATER_THAN
+        threshold: 75
+        duration_seconds: 600
+      enabled: true
+    # More than 2h above 90°C in a day, as tracked by the duty cycle job
+    - name: GPU heat budget
+      sensor_type: GPU
+      condition:
+        type: TYPE_THERMAL_BUDGET
+        threshold: 90
+        duration_seconds: 7200
+        period: DUTY_CYCLE_PERIOD_DAY
+      enabled: true
+    - name: Node down
+      severity: SEVERITY_CRITICAL
+      condition:
+        type: TYPE_CLIENT_OFFLINE
+        duration_seconds: 600
+      enabled: true`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _ := cmd.Flags().GetString("file")
+
+		rules, err := loadRules(file)
+		if err != nil {
+			return err
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		existing, err := api.alert.ListAlertRules(ctx, &alertv1.ListAlertRulesRequest{Limit: 1000})
+		if err != nil {
+			return fmt.Errorf("failed to list alert rules: %w", err)
+		}
+		idsByName := make(map[string]string, len(existing.Rules))
+		for _, r := range existing.Rules {
+			idsByName[r.Name] = r.Id
+		}
+
+		type appliedRule struct {
+			Name   string `json:"name"`
+			RuleID string `json:"rule_id"`
+			Action string `json:"action"`
+		}
+		var applied []appliedRule
+		for _, rule := range rules {
+			rule.Id = idsByName[rule.Name]
+			resp, err := api.alert.CreateAlertRule(ctx, &alertv1.CreateAlertRuleRequest{Rule: rule})
+			if err != nil {
+				return fmt.Errorf("failed to apply rule %q: %w", rule.Name, err)
+			}
+
+			action := "created"
+			if rule.Id != "" {
+				action = "updated"
+			}
+			applied = append(applied, appliedRule{Name: rule.Name, RuleID: resp.RuleId, Action: action})
+		}
+
+		return cli.Print(cmd, applied, func(w io.Writer) error {
+			fmt.Fprintln(w, "NAME\tRULE ID\tACTION")
+			for _, r := range applied {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, r.RuleID, r.Action)
+			}
+			return nil
+		})
+	},
+}
+
+var rulesDeleteCmd = &cobra.Command{
+	Use:   "delete <rule-id>",
+	Short: "Delete an alert rule",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.alert.DeleteAlertRule(ctx, &alertv1.DeleteAlertRuleRequest{RuleId: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to delete alert rule: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		})
+	},
+}
+
+var rulesSnoozeCmd = &cobra.Command{
+	Use:   "snooze <rule-id> <duration>",
+	Short: "Snooze an alert rule's notifications for a duration such as 30m, at most 168h",
+	Long: `Snooze an alert rule's notifications for a duration such as 30m, at most 168h.
+
+The rule keeps raising and resolving alerts, marked as muted, but they skip
+their notifications until the snooze ends on its own.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		duration, err := time.ParseDuration(args[1])
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid duration %q", args[1])
+		}
+		return snoozeRule(cmd, args[0], duration)
+	},
+}
+
+var rulesUnsnoozeCmd = &cobra.Command{
+	Use:   "unsnooze <rule-id>",
+	Short: "End an alert rule's snooze",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return snoozeRule(cmd, args[0], 0)
+	},
+}
+
+func snoozeRule(cmd *cobra.Command, ruleID string, duration time.Duration) error {
+	api, ctx, cancel, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer api.Close()
+
+	resp, err := api.alert.SnoozeAlertRule(ctx, &alertv1.SnoozeAlertRuleRequest{
+		RuleId:          ruleID,
+		DurationSeconds: int64(duration / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to snooze alert rule: %w", err)
+	}
+
+	return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+		if resp.SnoozedUntil == nil {
+			fmt.Fprintf(w, "Alert rule %s unsnoozed\n", ruleID)
+		} else {
+			fmt.Fprintf(w, "Alert rule %s snoozed until %s\n", ruleID, formatTime(resp.SnoozedUntil))
+		}
+		return nil
+	})
+}
+
+// loadRules reads alert rules from a YAML (or JSON) file
+func loadRules(path string) ([]*alertv1.AlertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var doc struct {
+		Rules []map[string]interface{} `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file: %w", err)
+	}
+
+	rules := make([]*alertv1.AlertRule, len(doc.Rules))
+	for i, raw := range doc.Rules {
+		// Round-trip through JSON so enum names and field names follow the API
+		ruleJSON, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert rule %d: %w", i+1, err)
+		}
+		rule := &alertv1.AlertRule{}
+		if err := protojson.Unmarshal(ruleJSON, rule); err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %w", i+1, err)
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("invalid rule %d: name is required", i+1)
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+func init() {
+	rulesApplyCmd.Flags().StringP("file", "f", "", "Rules file to apply")
+	rulesApplyCmd.MarkFlagRequired("file")
+
+	rulesCmd.AddCommand(rulesListCmd, rulesApplyCmd, rulesDeleteCmd, rulesSnoozeCmd, rulesUnsnoozeCmd)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/spf13/cobra"
+)
+
+var sensorsCmd = &cobra.Command{
+	Use:   "sensors",
+	Short: "Manage sensors",
+}
+
+var sensorsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List sensors with their latest reading",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientID, _ := cmd.Flags().GetString("client")
+		sensorType, _ := cmd.Flags().GetString("type")
+		staleOnly, _ := cmd.Flags().GetBool("stale-only")
+		includeRetired, _ := cmd.Flags().GetBool("include-retired")
+		qualityBelow, _ := cmd.Flags().GetFloat64("quality-below")
+		limit, _ := cmd.Flags().GetInt32("limit")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.ListSensors(ctx, &clientv1.ListSensorsRequest{
+			ClientId:       clientID,
+			SensorType:     sensorType,
+			StaleOnly:      staleOnly,
+			IncludeRetired: includeRetired,
+			QualityBelow:   qualityBelow,
+			Limit:          limit,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list sensors: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "CLIENT\tSENSOR\tTYPE\tNAME\tTEMP (°C)\tLAST READING\tSTALE\tQUALITY\tRETIRED")
+			for _, sensor := range resp.Sensors {
+				quality := "-"
+				if sensor.Quality != nil {
+					quality = fmt.Sprintf("%.1f", sensor.Quality.Score)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f\t%s\t%v\t%s\t%s\n", sensor.ClientId, sensor.SensorId, sensor.SensorType, sensor.SensorName,
+					sensor.CurrentTemperature, formatTime(sensor.LastReading), sensor.Stale, quality, formatTime(sensor.RetiredAt))
+			}
+			return nil
+		})
+	},
+}
+
+var sensorsRetireCmd = &cobra.Command{
+	Use:   "retire <client-id> <sensor-id>",
+	Short: "Retire a sensor, hiding it from current views until it reports again",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setSensorRetired(cmd, args[0], args[1], true)
+	},
+}
+
+var sensorsRestoreCmd = &cobra.Command{
+	Use:   "restore <client-id> <sensor-id>",
+	Short: "Restore a retired sensor",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setSensorRetired(cmd, args[0], args[1], false)
+	},
+}
+
+func setSensorRetired(cmd *cobra.Command, clientID, sensorID string, retired bool) error {
+	api, ctx, cancel, err := connect(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer api.Close()
+
+	resp, err := api.client.SetSensorRetired(ctx, &clientv1.SetSensorRetiredRequest{
+		ClientId: clientID,
+		SensorId: sensorID,
+		Retired:  retired,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update sensor: %w", err)
+	}
+
+	return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+		fmt.Fprintln(w, resp.Message)
+		return nil
+	})
+}
+
+var sensorsTypesCmd = &cobra.Command{
+	Use:   "types",
+	Short: "List the sensor types clients report",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientID, _ := cmd.Flags().GetString("client")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.temperature.ListSensorTypes(ctx, &temperaturev1.ListSensorTypesRequest{ClientId: clientID})
+		if err != nil {
+			return fmt.Errorf("failed to list sensor types: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			for _, sensorType := range resp.SensorTypes {
+				fmt.Fprintln(w, sensorType)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	sensorsListCmd.Flags().String("client", "", "Only list sensors of this client")
+	sensorsListCmd.Flags().String("type", "", "Only list sensors of this type")
+	sensorsListCmd.Flags().Bool("stale-only", false, "Only list sensors without a recent reading")
+	sensorsListCmd.Flags().Bool("include-retired", false, "Include retired sensors")
+	sensorsListCmd.Flags().Float64("quality-below", 0, "Only list sensors with a quality score below this")
+	sensorsListCmd.Flags().Int32("limit", 100, "Maximum number of sensors to list")
+
+	sensorsTypesCmd.Flags().String("client", "", "Only list the types of this client's sensors")
+
+	sensorsCmd.AddCommand(sensorsListCmd, sensorsTypesCmd, sensorsRetireCmd, sensorsRestoreCmd)
+}
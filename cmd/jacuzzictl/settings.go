@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	settingsv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/settings/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var settingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "Manage server settings",
+}
+
+var settingsGetCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Show settings, or a single setting such as email_settings.smtp_host",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.settings.GetSettings(ctx, &settingsv1.GetSettingsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to get settings: %w", err)
+		}
+
+		values, err := settingsToMap(resp.Settings)
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 0 {
+			return cli.PrintProto(cmd, resp.Settings, func(w io.Writer) error {
+				printSettings(w, "", values)
+				return nil
+			})
+		}
+
+		parent, field, err := lookupSetting(values, args[0])
+		if err != nil {
+			return err
+		}
+		return cli.Print(cmd, parent[field], func(w io.Writer) error {
+			out, _ := json.Marshal(parent[field])
+			fmt.Fprintln(w, string(out))
+			return nil
+		})
+	},
+}
+
+var settingsSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Update a single setting, e.g. retention_days 60",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.settings.GetSettings(ctx, &settingsv1.GetSettingsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to get settings: %w", err)
+		}
+
+		values, err := settingsToMap(resp.Settings)
+		if err != nil {
+			return err
+		}
+
+		parent, field, err := lookupSetting(values, args[0])
+		if err != nil {
+			return err
+		}
+
+		// Accept JSON values (numbers, booleans, lists) and fall back to a string
+		var value interface{}
+		if err := json.Unmarshal([]byte(args[1]), &value); err != nil {
+			value = args[1]
+		}
+		parent[field] = value
+
+		updated, err := json.Marshal(values)
+		if err != nil {
+			return fmt.Errorf("failed to encode settings: %w", err)
+		}
+		settings := &settingsv1.Settings{}
+		if err := protojson.Unmarshal(updated, settings); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", args[0], err)
+		}
+
+		updateResp, err := api.settings.UpdateSettings(ctx, &settingsv1.UpdateSettingsRequest{Settings: settings})
+		if err != nil {
+			return fmt.Errorf("failed to update settings: %w", err)
+		}
+
+		return cli.PrintProto(cmd, updateResp, func(w io.Writer) error {
+			fmt.Fprintln(w, updateResp.Message)
+			return nil
+		})
+	},
+}
+
+// settingsToMap converts settings to a map keyed by proto field names
+func settingsToMap(settings *settingsv1.Settings) (map[string]interface{}, error) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode settings: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode settings: %w", err)
+	}
+	return values, nil
+}
+
+// printSettings writes settings as dotted key/value rows in key order
+func printSettings(w io.Writer, prefix string, values map[string]interface{}) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if nested, ok := values[key].(map[string]interface{}); ok {
+			printSettings(w, prefix+key+".", nested)
+			continue
+		}
+		out, _ := json.Marshal(values[key])
+		fmt.Fprintf(w, "%s%s\t%s\n", prefix, key, out)
+	}
+}
+
+// lookupSetting resolves a dotted key to its parent map and field name
+func lookupSetting(values map[string]interface{}, key string) (map[string]interface{}, string, error) {
+	parts := strings.Split(key, ".")
+	parent := values
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := parent[part].(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("unknown setting: %s", key)
+		}
+		parent = child
+	}
+
+	field := parts[len(parts)-1]
+	if _, ok := parent[field]; !ok {
+		return nil, "", fmt.Errorf("unknown setting: %s", key)
+	}
+	return parent, field, nil
+}
+
+func init() {
+	settingsCmd.AddCommand(settingsGetCmd, settingsSetCmd)
+}
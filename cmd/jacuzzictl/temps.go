@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var tempsCmd = &cobra.Command{
+	Use:   "temps",
+	Short: "Query temperatures",
+}
+
+var tempsCurrentCmd = &cobra.Command{
+	Use:   "current [client-id...]",
+	Short: "Show the latest reading of each sensor, on the given clients or all of them",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		site, _ := cmd.Flags().GetString("site")
+		metadata, _ := cmd.Flags().GetStringToString("metadata")
+		sensorType, _ := cmd.Flags().GetString("type")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.temperature.GetCurrentTemperatures(ctx, &temperaturev1.GetCurrentTemperaturesRequest{
+			ClientIds:  args,
+			Site:       site,
+			Metadata:   metadata,
+			SensorType: sensorType,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get current temperatures: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			if len(args) == 1 {
+				fmt.Fprintln(w, "SENSOR\tTYPE\tNAME\tTEMP (°C)\tTIMESTAMP")
+				for _, r := range resp.Readings {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%s\n", r.SensorId, r.SensorType, r.SensorName, r.TemperatureCelsius, formatTime(r.Timestamp))
+				}
+				return nil
+			}
+			fmt.Fprintln(w, "CLIENT\tSENSOR\tTYPE\tNAME\tTEMP (°C)\tTIMESTAMP")
+			for _, r := range resp.Readings {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f\t%s\n", r.ClientId, r.SensorId, r.SensorType, r.SensorName, r.TemperatureCelsius, formatTime(r.Timestamp))
+			}
+			return nil
+		})
+	},
+}
+
+var tempsWatchCmd = &cobra.Command{
+	Use:   "watch [client-id]",
+	Short: "Stream readings as the server ingests them",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, _, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		req := &temperaturev1.StreamTemperaturesRequest{}
+		if len(args) == 1 {
+			req.ClientId = args[0]
+		}
+		req.SensorId, _ = cmd.Flags().GetString("sensor")
+
+		// The stream runs until interrupted, so it is not bound by --timeout
+		stream, err := api.temperature.StreamTemperatures(cmd.Context(), req)
+		if err != nil {
+			return fmt.Errorf("failed to stream temperatures: %w", err)
+		}
+
+		for {
+			r, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to stream temperatures: %w", err)
+			}
+			err = cli.PrintProto(cmd, r, func(w io.Writer) error {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\n", formatTime(r.Timestamp), r.ClientId, r.SensorId, r.TemperatureCelsius)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	},
+}
+
+var tempsStatsCmd = &cobra.Command{
+	Use:   "stats [client-id]",
+	Short: "Show min, max and average temperatures per sensor, or per site",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since, _ := cmd.Flags().GetDuration("since")
+		bySite, _ := cmd.Flags().GetBool("by-site")
+		intervalName, _ := cmd.Flags().GetString("interval")
+
+		interval, err := parseStatsInterval(intervalName)
+		if err != nil {
+			return err
+		}
+		req := &temperaturev1.GetTemperatureStatsRequest{GroupBySite: bySite, Interval: interval}
+		if len(args) == 1 {
+			req.ClientId = args[0]
+		}
+		req.SensorId, _ = cmd.Flags().GetString("sensor")
+		req.Site, _ = cmd.Flags().GetString("site")
+		req.Timezone, _ = cmd.Flags().GetString("timezone")
+		if since > 0 {
+			req.StartTime = timestamppb.New(time.Now().Add(-since))
+		}
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.temperature.GetTemperatureStats(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to get temperature stats: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			if interval != temperaturev1.StatsInterval_STATS_INTERVAL_UNSPECIFIED {
+				loc, err := time.LoadLocation(resp.Timezone)
+				if err != nil {
+					loc = time.Local
+				}
+				fmt.Fprintf(w, "PERIOD (%s)\tSENSOR\tMIN (°C)\tMAX (°C)\tAVG (°C)\tREADINGS\n", resp.Timezone)
+				for _, b := range resp.Buckets {
+					s := b.Stats
+					fmt.Fprintf(w, "%s\t%s\t%.1f\t%.1f\t%.1f\t%d\n", s.PeriodStart.AsTime().In(loc).Format("2006-01-02 15:04"), b.SensorId, s.MinTemperature, s.MaxTemperature, s.AvgTemperature, s.ReadingCount)
+				}
+				return nil
+			}
+			stats, heading := resp.SensorStats, "SENSOR"
+			if bySite {
+				stats, heading = resp.SiteStats, "SITE"
+			}
+			keys := make([]string, 0, len(stats))
+			for key := range stats {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			fmt.Fprintf(w, "%s\tMIN (°C)\tMAX (°C)\tAVG (°C)\tREADINGS\n", heading)
+			for _, key := range keys {
+				s := stats[key]
+				if key == "" {
+					key = "(none)"
+				}
+				fmt.Fprintf(w, "%s\t%.1f\t%.1f\t%.1f\t%d\n", key, s.MinTemperature, s.MaxTemperature, s.AvgTemperature, s.ReadingCount)
+			}
+			return nil
+		})
+	},
+}
+
+var tempsDutyCyclesCmd = &cobra.Command{
+	Use:   "duty-cycles [client-id]",
+	Short: "Show how long each sensor spent above tracked thresholds today or this week",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		periodName, _ := cmd.Flags().GetString("period")
+
+		value, ok := temperaturev1.DutyCyclePeriod_value["DUTY_CYCLE_PERIOD_"+strings.ToUpper(periodName)]
+		if !ok {
+			return fmt.Errorf("unknown period %q: must be day or week", periodName)
+		}
+		req := &temperaturev1.GetDutyCyclesRequest{Period: temperaturev1.DutyCyclePeriod(value)}
+		if len(args) == 1 {
+			req.ClientId = args[0]
+		}
+		req.SensorId, _ = cmd.Flags().GetString("sensor")
+		req.SensorType, _ = cmd.Flags().GetString("type")
+		req.Threshold, _ = cmd.Flags().GetFloat64("threshold")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.temperature.GetDutyCycles(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to get duty cycles: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "CLIENT\tSENSOR\tTYPE\tTHRESHOLD (°C)\tABOVE\tTRACKED\tABOVE (%%)\n")
+			for _, c := range resp.DutyCycles {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%s\t%s\t%.1f\n", c.ClientId, c.SensorId, c.SensorType, c.Threshold,
+					formatSeconds(float64(c.SecondsAbove)), formatSeconds(float64(c.SecondsTracked)), c.PercentAbove)
+			}
+			return nil
+		})
+	},
+}
+
+var tempsWearCmd = &cobra.Command{
+	Use:   "wear [client-id]",
+	Short: "Rank components by their hours above tracked thresholds, to guide preventive replacement",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := &temperaturev1.GetThermalWearRequest{}
+		if len(args) == 1 {
+			req.ClientId = args[0]
+		}
+		req.SensorType, _ = cmd.Flags().GetString("type")
+		req.Threshold, _ = cmd.Flags().GetFloat64("threshold")
+		req.IncludeRetired, _ = cmd.Flags().GetBool("include-retired")
+		req.Limit, _ = cmd.Flags().GetInt32("limit")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.temperature.GetThermalWear(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to get thermal wear: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "CLIENT\tSENSOR\tTYPE\tTHRESHOLD (°C)\tDAYS\tHOURS ABOVE\tABOVE (%)\tIN SERVICE (DAYS)\tEST. HOURS ABOVE")
+			for _, c := range resp.Components {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%d\t%.1f\t%.1f\t%d\t%.1f\n", c.ClientId, c.SensorId, c.SensorType, c.Threshold, c.DaysTracked,
+					float64(c.SecondsAbove)/3600, c.PercentAbove, c.SecondsInService/(24*60*60), float64(c.EstimatedSecondsAbove)/3600)
+			}
+			if shown := int32(len(resp.Components)); shown > 0 && shown < resp.TotalCount {
+				fmt.Fprintf(w, "Showing %d of %d components\n", shown, resp.TotalCount)
+			}
+			return nil
+		})
+	},
+}
+
+// parseStatsInterval converts an interval name such as "day" to its enum value
+func parseStatsInterval(name string) (temperaturev1.StatsInterval, error) {
+	if name == "" {
+		return temperaturev1.StatsInterval_STATS_INTERVAL_UNSPECIFIED, nil
+	}
+	value, ok := temperaturev1.StatsInterval_value["STATS_INTERVAL_"+strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown interval %q: must be hour, day, week, or month", name)
+	}
+	return temperaturev1.StatsInterval(value), nil
+}
+
+func init() {
+	tempsCurrentCmd.Flags().String("site", "", "Only include clients at this site")
+	tempsCurrentCmd.Flags().StringToString("metadata", nil, "Only include clients with these metadata values, e.g. role=storage")
+	tempsCurrentCmd.Flags().String("type", "", "Only include sensors of this type")
+	tempsStatsCmd.Flags().String("sensor", "", "Only include readings from this sensor ID")
+	tempsStatsCmd.Flags().String("site", "", "Only include clients at this site")
+	tempsStatsCmd.Flags().Duration("since", 24*time.Hour, "Only include readings this recent (0 for all)")
+	tempsStatsCmd.Flags().Bool("by-site", false, "Aggregate per site instead of per sensor")
+	tempsStatsCmd.Flags().String("interval", "", "Aggregate each sensor per hour, day, week or month")
+	tempsStatsCmd.Flags().String("timezone", "", "Timezone of interval boundaries, e.g. Europe/Amsterdam (default: the server's timezone setting)")
+	tempsWatchCmd.Flags().String("sensor", "", "Only stream readings from this sensor ID")
+	tempsDutyCyclesCmd.Flags().String("sensor", "", "Only include this sensor ID")
+	tempsDutyCyclesCmd.Flags().String("type", "", "Only include sensors of this type")
+	tempsDutyCyclesCmd.Flags().String("period", "day", "Sum the duty cycles of the current day or week")
+	tempsDutyCyclesCmd.Flags().Float64("threshold", 0, "Only include this threshold in °C (0 for all)")
+
+	tempsWearCmd.Flags().String("type", "", "Only include sensors of this type")
+	tempsWearCmd.Flags().Float64("threshold", 0, "Only include this threshold in °C (0 for all)")
+	tempsWearCmd.Flags().Bool("include-retired", false, "Include retired sensors")
+	tempsWearCmd.Flags().Int32("limit", 100, "Maximum number of components to list")
+
+	tempsCmd.AddCommand(tempsCurrentCmd, tempsStatsCmd, tempsWatchCmd, tempsDutyCyclesCmd, tempsWearCmd)
+}
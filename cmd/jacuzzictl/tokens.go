@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	"github.com/spf13/cobra"
+)
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Manage client enrollment tokens",
+}
+
+var tokensCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Mint an enrollment token; the token is only shown once",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		description, _ := cmd.Flags().GetString("description")
+		uses, _ := cmd.Flags().GetInt32("uses")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.CreateEnrollmentToken(ctx, &clientv1.CreateEnrollmentTokenRequest{
+			Description: description,
+			MaxUses:     uses,
+			TtlSeconds:  int64(ttl / time.Second),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create enrollment token: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintf(w, "Token:\t%s\n", resp.Token)
+			fmt.Fprintf(w, "ID:\t%s\n", resp.Info.Id)
+			fmt.Fprintf(w, "Uses:\t%s\n", formatTokenUses(resp.Info))
+			fmt.Fprintf(w, "Expires:\t%s\n", formatTime(resp.Info.ExpiresAt))
+			return nil
+		})
+	},
+}
+
+var tokensListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List enrollment tokens",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.ListEnrollmentTokens(ctx, &clientv1.ListEnrollmentTokensRequest{IncludeInactive: all})
+		if err != nil {
+			return fmt.Errorf("failed to list enrollment tokens: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, "ID\tDESCRIPTION\tUSES\tEXPIRES\tREVOKED\tCREATED")
+			for _, t := range resp.Tokens {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Id, t.Description, formatTokenUses(t), formatTime(t.ExpiresAt), formatTime(t.RevokedAt), formatTime(t.CreatedAt))
+			}
+			return nil
+		})
+	},
+}
+
+var tokensRevokeCmd = &cobra.Command{
+	Use:   "revoke <token-id>",
+	Short: "Revoke an enrollment token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, ctx, cancel, err := connect(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer api.Close()
+
+		resp, err := api.client.RevokeEnrollmentToken(ctx, &clientv1.RevokeEnrollmentTokenRequest{Id: args[0]})
+		if err != nil {
+			return fmt.Errorf("failed to revoke enrollment token: %w", err)
+		}
+
+		return cli.PrintProto(cmd, resp, func(w io.Writer) error {
+			fmt.Fprintln(w, resp.Message)
+			return nil
+		})
+	},
+}
+
+func formatTokenUses(t *clientv1.EnrollmentToken) string {
+	if t.MaxUses == 0 {
+		return fmt.Sprintf("%d/unlimited", t.Uses)
+	}
+	return fmt.Sprintf("%d/%d", t.Uses, t.MaxUses)
+}
+
+func init() {
+	tokensCreateCmd.Flags().String("description", "", "What the token is for, e.g. the rollout it belongs to")
+	tokensCreateCmd.Flags().Int32("uses", 1, "How many clients may enroll with the token (-1 for unlimited)")
+	tokensCreateCmd.Flags().Duration("ttl", 24*time.Hour, "How long the token stays valid (0 for no expiry)")
+	tokensListCmd.Flags().Bool("all", false, "Include revoked, expired, and used-up tokens")
+
+	tokensCmd.AddCommand(tokensCreateCmd, tokensListCmd, tokensRevokeCmd)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// sensorTypes are cycled through so simulated clients look like real ones
+var sensorTypes = []string{"CPU", "GPU", "DISK"}
+
+var rootCmd = &cobra.Command{
+	Use:   "loadgen",
+	Short: "Simulate many clients submitting temperature readings",
+	Long: `loadgen simulates N clients with M sensors each, every client submitting its
+readings once per interval, and reports throughput and SubmitTemperature
+latency. Point it at a running server, or use --in-process to benchmark the
+ingestion path against a fresh SQLite database without any network in between:
+
+  loadgen --server localhost:50051 --clients 200 --sensors 16 --interval 5s --duration 2m
+  loadgen --in-process --clients 50 --sensors 8 --interval 100ms --duration 30s
+
+Clients are named <prefix>-0001 and up, so a run against a real server can be
+cleaned up afterwards. The server must accept them: use open enrollment, or
+pass --enrollment-token when it runs in token mode.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         run,
+}
+
+func init() {
+	rootCmd.Flags().String("server", "localhost:50051", "The server address")
+	rootCmd.Flags().Bool("in-process", false, "Run the ingestion path in this process against a temporary SQLite database instead of a server")
+	rootCmd.Flags().Int("clients", 10, "Number of simulated clients")
+	rootCmd.Flags().Int("sensors", 8, "Sensors per client")
+	rootCmd.Flags().Duration("interval", time.Second, "How often each client submits its readings")
+	rootCmd.Flags().Duration("duration", 30*time.Second, "How long to generate load")
+	rootCmd.Flags().Int("batch-size", 0, "Readings per request; 0 sends each client's readings in one request")
+	rootCmd.Flags().String("prefix", "loadgen", "Prefix of simulated client IDs")
+	rootCmd.Flags().String("enrollment-token", "", "Enrollment token to enroll the simulated clients with, for servers in token mode")
+	rootCmd.Flags().Duration("timeout", 10*time.Second, "Timeout of each request")
+
+	cli.AddOutputFlag(rootCmd)
+}
+
+// options are the validated flags of a run
+type options struct {
+	clients         int
+	sensors         int
+	interval        time.Duration
+	duration        time.Duration
+	batchSize       int
+	prefix          string
+	enrollmentToken string
+	timeout         time.Duration
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	var opts options
+	opts.clients, _ = flags.GetInt("clients")
+	opts.sensors, _ = flags.GetInt("sensors")
+	opts.interval, _ = flags.GetDuration("interval")
+	opts.duration, _ = flags.GetDuration("duration")
+	opts.batchSize, _ = flags.GetInt("batch-size")
+	opts.prefix, _ = flags.GetString("prefix")
+	opts.enrollmentToken, _ = flags.GetString("enrollment-token")
+	opts.timeout, _ = flags.GetDuration("timeout")
+	if opts.clients < 1 || opts.sensors < 1 {
+		return fmt.Errorf("--clients and --sensors must be at least 1")
+	}
+	if opts.interval <= 0 || opts.duration <= 0 {
+		return fmt.Errorf("--interval and --duration must be positive")
+	}
+	if opts.batchSize <= 0 || opts.batchSize > opts.sensors {
+		opts.batchSize = opts.sensors
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var t target
+	var err error
+	if inProcess, _ := flags.GetBool("in-process"); inProcess {
+		if opts.enrollmentToken != "" {
+			return fmt.Errorf("--enrollment-token cannot be used with --in-process, which accepts every client")
+		}
+		t, err = newInProcessTarget()
+	} else {
+		server, _ := flags.GetString("server")
+		t, err = newRemoteTarget(ctx, server, opts.timeout)
+	}
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	clients, err := setupClients(ctx, t, opts)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Simulating %d clients with %d sensors each against %s, every %s for %s (%.0f readings/s)",
+		opts.clients, opts.sensors, t.Name(), opts.interval, opts.duration,
+		float64(opts.clients*opts.sensors)/opts.interval.Seconds())
+
+	stats := newStats()
+	runCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	go stats.Report(runCtx, 5*time.Second)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Run(runCtx, t, opts, stats)
+		}()
+	}
+	wg.Wait()
+
+	summary := stats.Summary(time.Since(start))
+	summary.Target = t.Name()
+	return cli.Print(cmd, summary, summary.Table)
+}
+
+// simClient is one simulated client
+type simClient struct {
+	id     string
+	apiKey string
+	temps  []float64 // Current temperature of each sensor
+}
+
+// setupClients enrolls or registers every simulated client
+func setupClients(ctx context.Context, t target, opts options) ([]*simClient, error) {
+	clients := make([]*simClient, opts.clients)
+	for i := range clients {
+		c := &simClient{id: fmt.Sprintf("%s-%04d", opts.prefix, i+1)}
+		for range opts.sensors {
+			c.temps = append(c.temps, 35+rand.Float64()*20)
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+		var err error
+		if opts.enrollmentToken != "" {
+			c.id, c.apiKey, err = t.Enroll(reqCtx, c.id, opts.enrollmentToken)
+		} else {
+			err = t.Register(reqCtx, c.id)
+		}
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up client %s: %w", c.id, err)
+		}
+		clients[i] = c
+	}
+	return clients, nil
+}
+
+// Run submits the client's readings every interval until ctx ends. Clients
+// start at random offsets within the first interval so their requests are
+// spread out rather than arriving in bursts.
+func (c *simClient) Run(ctx context.Context, t target, opts options, stats *stats) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(rand.N(opts.interval)):
+	}
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for {
+		c.submit(ctx, t, opts, stats)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// submit sends one round of readings, random-walking each sensor's temperature
+func (c *simClient) submit(ctx context.Context, t target, opts options, stats *stats) {
+	timestamp := timestamppb.Now()
+	readings := make([]*temperaturev1.TemperatureReading, len(c.temps))
+	for i := range c.temps {
+		c.temps[i] = min(max(c.temps[i]+rand.Float64()-0.5, 20), 95)
+		readings[i] = &temperaturev1.TemperatureReading{
+			SensorId:           fmt.Sprintf("sensor-%02d", i),
+			ClientId:           c.id,
+			TemperatureCelsius: c.temps[i],
+			Timestamp:          timestamp,
+			SensorType:         sensorTypes[i%len(sensorTypes)],
+			SensorName:         fmt.Sprintf("Simulated %s %d", sensorTypes[i%len(sensorTypes)], i),
+		}
+	}
+
+	for start := 0; start < len(readings); start += opts.batchSize {
+		if ctx.Err() != nil {
+			return
+		}
+		end := min(start+opts.batchSize, len(readings))
+		req := &temperaturev1.SubmitTemperatureRequest{
+			Readings: readings[start:end],
+			BatchId:  uuid.New().String(),
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+		began := time.Now()
+		resp, err := t.Submit(reqCtx, c.apiKey, req)
+		elapsed := time.Since(began)
+		cancel()
+		// Requests cut short by the end of the run are not counted
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		stats.Record(len(req.Readings), resp, err, elapsed)
+	}
+}
+
+// stats collects the results of every request
+type stats struct {
+	mu         sync.Mutex
+	latencies  []time.Duration
+	readings   int
+	accepted   int
+	duplicates int
+	rejected   int
+	unapproved int
+	throttled  int
+	errors     map[codes.Code]int
+	lastError  string
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[codes.Code]int)}
+}
+
+func (s *stats) Record(readings int, resp *temperaturev1.SubmitTemperatureResponse, err error, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, elapsed)
+	s.readings += readings
+	if err != nil {
+		s.errors[status.Code(err)]++
+		s.lastError = err.Error()
+		return
+	}
+	s.accepted += int(resp.AcceptedCount)
+	s.duplicates += int(resp.DuplicateCount)
+	s.rejected += int(resp.RejectedCount)
+	s.unapproved += int(resp.UnapprovedCount)
+	s.throttled += int(resp.ThrottledCount)
+}
+
+// Report logs progress every period until ctx ends
+func (s *stats) Report(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var lastRequests, lastAccepted int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		requests, accepted := len(s.latencies), s.accepted
+		failed := 0
+		for _, n := range s.errors {
+			failed += n
+		}
+		lastError := s.lastError
+		s.mu.Unlock()
+
+		log.Printf("%d requests (%.1f/s), %d readings stored (%.1f/s), %d failed",
+			requests, float64(requests-lastRequests)/period.Seconds(),
+			accepted, float64(accepted-lastAccepted)/period.Seconds(), failed)
+		if lastError != "" {
+			log.Printf("Last error: %s", lastError)
+		}
+		lastRequests, lastAccepted = requests, accepted
+	}
+}
+
+// summary is the outcome of a run
+type summary struct {
+	Target          string             `json:"target"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	Requests        int                `json:"requests"`
+	Failed          int                `json:"failed"`
+	Errors          map[string]int     `json:"errors"`
+	Readings        int                `json:"readings"`
+	Accepted        int                `json:"accepted"`
+	Duplicates      int                `json:"duplicates"`
+	Rejected        int                `json:"rejected"`
+	Unapproved      int                `json:"unapproved"`
+	Throttled       int                `json:"throttled"`
+	RequestsPerSec  float64            `json:"requests_per_second"`
+	ReadingsPerSec  float64            `json:"readings_per_second"`
+	LatencyMillis   map[string]float64 `json:"latency_ms"`
+}
+
+func (s *stats) Summary(elapsed time.Duration) *summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := &summary{
+		DurationSeconds: elapsed.Seconds(),
+		Requests:        len(s.latencies),
+		Errors:          make(map[string]int),
+		Readings:        s.readings,
+		Accepted:        s.accepted,
+		Duplicates:      s.duplicates,
+		Rejected:        s.rejected,
+		Unapproved:      s.unapproved,
+		Throttled:       s.throttled,
+		RequestsPerSec:  float64(len(s.latencies)) / elapsed.Seconds(),
+		ReadingsPerSec:  float64(s.accepted) / elapsed.Seconds(),
+		LatencyMillis:   make(map[string]float64),
+	}
+	for code, n := range s.errors {
+		out.Errors[code.String()] = n
+		out.Failed += n
+	}
+
+	latencies := slices.Clone(s.latencies)
+	slices.Sort(latencies)
+	for _, p := range []struct {
+		name string
+		q    float64
+	}{{"p50", 0.50}, {"p90", 0.90}, {"p99", 0.99}, {"max", 1}} {
+		out.LatencyMillis[p.name] = percentile(latencies, p.q).Seconds() * 1000
+	}
+	return out
+}
+
+// percentile returns the q quantile of sorted durations
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+func (s *summary) Table(w io.Writer) error {
+	fmt.Fprintf(w, "Target:\t%s\n", s.Target)
+	fmt.Fprintf(w, "Duration:\t%.1fs\n", s.DurationSeconds)
+	fmt.Fprintf(w, "Requests:\t%d (%.1f/s)\n", s.Requests, s.RequestsPerSec)
+	fmt.Fprintf(w, "Failed requests:\t%d\n", s.Failed)
+	names := make([]string, 0, len(s.Errors))
+	for code := range s.Errors {
+		names = append(names, code)
+	}
+	sort.Strings(names)
+	for _, code := range names {
+		fmt.Fprintf(w, "  %s:\t%d\n", code, s.Errors[code])
+	}
+	fmt.Fprintf(w, "Readings sent:\t%d\n", s.Readings)
+	fmt.Fprintf(w, "Readings stored:\t%d (%.1f/s)\n", s.Accepted, s.ReadingsPerSec)
+	fmt.Fprintf(w, "Duplicates:\t%d\n", s.Duplicates)
+	fmt.Fprintf(w, "Rejected:\t%d\n", s.Rejected)
+	fmt.Fprintf(w, "Unapproved:\t%d\n", s.Unapproved)
+	fmt.Fprintf(w, "Throttled:\t%d\n", s.Throttled)
+	fmt.Fprintf(w, "Latency:\tp50 %.2fms, p90 %.2fms, p99 %.2fms, max %.2fms\n",
+		s.LatencyMillis["p50"], s.LatencyMillis["p90"], s.LatencyMillis["p99"], s.LatencyMillis["max"])
+	return nil
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
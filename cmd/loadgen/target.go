@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/db"
+	"github.com/nickheyer/jacuzzi/pkg/server/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// apiKeyHeader is the metadata key the server reads the client API key from
+const apiKeyHeader = "x-api-key"
+
+// target is where simulated clients send their readings
+type target interface {
+	Name() string
+	// Register announces a client, as agents do on startup
+	Register(ctx context.Context, clientID string) error
+	// Enroll exchanges an enrollment token for a client ID and API key
+	Enroll(ctx context.Context, hostname, token string) (string, string, error)
+	Submit(ctx context.Context, apiKey string, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error)
+	Close() error
+}
+
+// remoteTarget is a server reached over gRPC
+type remoteTarget struct {
+	address     string
+	conn        *grpc.ClientConn
+	client      jacuzziv1.ClientServiceClient
+	temperature jacuzziv1.TemperatureServiceClient
+}
+
+func newRemoteTarget(ctx context.Context, address string, timeout time.Duration) (*remoteTarget, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	return &remoteTarget{
+		address:     address,
+		conn:        conn,
+		client:      jacuzziv1.NewClientServiceClient(conn),
+		temperature: jacuzziv1.NewTemperatureServiceClient(conn),
+	}, nil
+}
+
+func (t *remoteTarget) Name() string {
+	return t.address
+}
+
+func (t *remoteTarget) Register(ctx context.Context, clientID string) error {
+	_, err := t.client.RegisterClient(ctx, &clientv1.RegisterClientRequest{
+		ClientId:  clientID,
+		Hostname:  clientID,
+		MachineId: clientID,
+		Os:        "linux",
+		Arch:      "amd64",
+	})
+	return err
+}
+
+func (t *remoteTarget) Enroll(ctx context.Context, hostname, token string) (string, string, error) {
+	resp, err := t.client.EnrollClient(ctx, &clientv1.EnrollClientRequest{
+		Token:     token,
+		Hostname:  hostname,
+		MachineId: hostname,
+		Os:        "linux",
+		Arch:      "amd64",
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return resp.ClientId, resp.ApiKey, nil
+}
+
+func (t *remoteTarget) Submit(ctx context.Context, apiKey string, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error) {
+	if apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, apiKeyHeader, apiKey)
+	}
+	return t.temperature.SubmitTemperature(ctx, req)
+}
+
+func (t *remoteTarget) Close() error {
+	return t.conn.Close()
+}
+
+// inProcessTarget calls the ingestion services directly, against a SQLite
+// database in a temporary directory, to measure the ingestion path itself
+type inProcessTarget struct {
+	dir         string
+	database    *gorm.DB
+	client      *service.ClientService
+	temperature *service.TemperatureService
+}
+
+func newInProcessTarget() (*inProcessTarget, error) {
+	dir, err := os.MkdirTemp("", "jacuzzi-loadgen-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	database, err := db.Open(db.Config{Type: "sqlite", DBName: filepath.Join(dir, "loadgen.db")})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	// Logging every statement would dominate the measurement
+	database.Logger = logger.Default.LogMode(logger.Silent)
+	if err := db.RunMigrations(database); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return &inProcessTarget{
+		dir:      dir,
+		database: database,
+		client: service.NewClientService(database, service.ClientServiceConfig{
+			EnrollmentMode: service.EnrollmentOpen,
+		}),
+		temperature: service.NewTemperatureService(database, service.TemperatureServiceConfig{
+			EnrollmentMode: service.EnrollmentOpen,
+		}),
+	}, nil
+}
+
+func (t *inProcessTarget) Name() string {
+	return "in-process SQLite"
+}
+
+func (t *inProcessTarget) Register(ctx context.Context, clientID string) error {
+	_, err := t.client.RegisterClient(ctx, &clientv1.RegisterClientRequest{
+		ClientId:  clientID,
+		Hostname:  clientID,
+		MachineId: clientID,
+		Os:        "linux",
+		Arch:      "amd64",
+	})
+	return err
+}
+
+func (t *inProcessTarget) Enroll(ctx context.Context, hostname, token string) (string, string, error) {
+	return "", "", fmt.Errorf("enrollment is not supported in process")
+}
+
+func (t *inProcessTarget) Submit(ctx context.Context, apiKey string, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error) {
+	return t.temperature.SubmitTemperature(ctx, req)
+}
+
+func (t *inProcessTarget) Close() error {
+	if sqlDB, err := t.database.DB(); err == nil {
+		sqlDB.Close()
+	}
+	return os.RemoveAll(t.dir)
+}
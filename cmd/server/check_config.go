@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	"github.com/nickheyer/jacuzzi/pkg/server/config"
+	"github.com/nickheyer/jacuzzi/pkg/server/db"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const checkTimeout = 5 * time.Second
+
+var checkConfigCmd = &cobra.Command{
+	Use:   "check-config",
+	Short: "Validate configuration, database, SMTP and listen addresses without starting the server",
+	Args:  cobra.NoArgs,
+	RunE:  runCheckConfig,
+}
+
+func init() {
+	checkConfigCmd.Flags().Bool("probe-listen", false, "Also try binding the listen addresses, warning when one is already in use")
+}
+
+// checkResult is the outcome of a single check-config step
+type checkResult struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+const (
+	checkOK      = "ok"
+	checkSkipped = "skipped"
+	checkWarning = "warning"
+	checkFailed  = "failed"
+)
+
+func runCheckConfig(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+
+	var results []checkResult
+	add := func(check, status, detail string) {
+		results = append(results, checkResult{Check: check, Status: status, Detail: detail})
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		add("config", checkFailed, err.Error())
+	} else {
+		add("config", checkOK, configSource())
+
+		probe, _ := cmd.Flags().GetBool("probe-listen")
+		results = append(results, checkListen("grpc listen", cfg.GetServerAddress(), probe))
+		results = append(results, checkListen("http listen", cfg.GetHTTPAddress(), probe))
+
+		database, status, detail := checkDatabase(cfg)
+		add("database", status, detail)
+		if database != nil {
+			results = append(results, checkSMTP(database))
+			if sqlDB, err := database.DB(); err == nil {
+				sqlDB.Close()
+			}
+		} else {
+			add("smtp", checkSkipped, "database unavailable")
+		}
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Status == checkFailed {
+			failed++
+		}
+	}
+
+	if err := cli.Print(cmd, results, func(w io.Writer) error {
+		fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Check, r.Status, r.Detail)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// Helper function to describe where configuration was loaded from
+func configSource() string {
+	if used := viper.ConfigFileUsed(); used != "" {
+		return "loaded " + used
+	}
+	return "no config file, using defaults and environment"
+}
+
+// Helper function to validate a listen address without binding it. Binding
+// is only tried when probe is set, and failing to is a warning, as the
+// address is usually held by the server being checked
+func checkListen(name, addr string, probe bool) checkResult {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return checkResult{Check: name, Status: checkFailed, Detail: err.Error()}
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return checkResult{Check: name, Status: checkFailed, Detail: fmt.Sprintf("invalid port %q in %s", port, addr)}
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		defer cancel()
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return checkResult{Check: name, Status: checkFailed, Detail: err.Error()}
+		}
+	}
+
+	if probe {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return checkResult{Check: name, Status: checkWarning, Detail: err.Error()}
+		}
+		lis.Close()
+	}
+	return checkResult{Check: name, Status: checkOK, Detail: addr}
+}
+
+// Helper function to connect to the database without running migrations
+func checkDatabase(cfg *config.Config) (*gorm.DB, string, string) {
+	if cfg.Database.Type == "sqlite" {
+		if _, err := os.Stat(cfg.Database.Name); errors.Is(err, os.ErrNotExist) {
+			return nil, checkOK, fmt.Sprintf("%s does not exist yet and will be created on start", cfg.Database.Name)
+		}
+	}
+
+	database, err := db.Open(databaseConfig(cfg))
+	if err != nil {
+		return nil, checkFailed, err.Error()
+	}
+	database = database.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	sqlDB, err := database.DB()
+	if err != nil {
+		return nil, checkFailed, err.Error()
+	}
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, checkFailed, err.Error()
+	}
+
+	if cfg.Database.Type == "postgres" {
+		return database, checkOK, fmt.Sprintf("postgres %s:%d/%s", cfg.Database.Host, cfg.Database.Port, cfg.Database.Name)
+	}
+	return database, checkOK, fmt.Sprintf("%s %s", cfg.Database.Type, cfg.Database.Name)
+}
+
+// Helper function to check the SMTP server stored in settings is reachable
+func checkSMTP(database *gorm.DB) checkResult {
+	status, detail := smtpStatus(database)
+	return checkResult{Check: "smtp", Status: status, Detail: detail}
+}
+
+// Helper function to dial the configured SMTP server and inspect its greeting
+func smtpStatus(database *gorm.DB) (string, string) {
+	if !database.Migrator().HasTable(&models.Setting{}) {
+		return checkSkipped, "settings table not created yet"
+	}
+
+	var settings []models.Setting
+	if err := database.Where("category = ?", "email").Find(&settings).Error; err != nil {
+		return checkFailed, fmt.Sprintf("failed to load email settings: %v", err)
+	}
+	settingsMap := make(map[string]string)
+	for _, setting := range settings {
+		settingsMap[setting.Key] = setting.Value
+	}
+
+	host := settingsMap[models.SettingEmailSMTPHost]
+	if host == "" {
+		return checkSkipped, "no SMTP host configured"
+	}
+	port := settingsMap[models.SettingEmailSMTPPort]
+	if port == "" {
+		port = "587"
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return checkFailed, fmt.Sprintf("invalid SMTP port %q", port)
+	}
+	addr := net.JoinHostPort(host, port)
+
+	conn, err := net.DialTimeout("tcp", addr, checkTimeout)
+	if err != nil {
+		return checkFailed, err.Error()
+	}
+	conn.SetDeadline(time.Now().Add(checkTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return checkFailed, fmt.Sprintf("%s: %v", addr, err)
+	}
+	defer client.Close()
+
+	if settingsMap[models.SettingEmailUseTLS] == "true" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return checkFailed, fmt.Sprintf("%s does not offer STARTTLS but email.use_tls is enabled", addr)
+		}
+	}
+	client.Quit()
+
+	return checkOK, addr
+}
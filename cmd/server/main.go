@@ -8,18 +8,60 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/nickheyer/jacuzzi/pkg/cli"
 	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	"github.com/nickheyer/jacuzzi/pkg/mdns"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertmanager"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertreceiver"
+	"github.com/nickheyer/jacuzzi/pkg/server/bus"
+	"github.com/nickheyer/jacuzzi/pkg/server/chart"
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/coap"
+	"github.com/nickheyer/jacuzzi/pkg/server/clientip"
 	"github.com/nickheyer/jacuzzi/pkg/server/config"
 	"github.com/nickheyer/jacuzzi/pkg/server/db"
+	"github.com/nickheyer/jacuzzi/pkg/server/dutycycle"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
+	"github.com/nickheyer/jacuzzi/pkg/server/evaluator"
+	"github.com/nickheyer/jacuzzi/pkg/server/gateway"
+	"github.com/nickheyer/jacuzzi/pkg/server/graphql"
+	"github.com/nickheyer/jacuzzi/pkg/server/handoff"
+	"github.com/nickheyer/jacuzzi/pkg/server/health"
+	"github.com/nickheyer/jacuzzi/pkg/server/hypervisor"
+	"github.com/nickheyer/jacuzzi/pkg/server/interceptor"
+	"github.com/nickheyer/jacuzzi/pkg/server/jobs"
+	"github.com/nickheyer/jacuzzi/pkg/server/kube"
+	"github.com/nickheyer/jacuzzi/pkg/server/leader"
+	"github.com/nickheyer/jacuzzi/pkg/server/maintenance"
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+	"github.com/nickheyer/jacuzzi/pkg/server/notify"
+	"github.com/nickheyer/jacuzzi/pkg/server/power"
+	"github.com/nickheyer/jacuzzi/pkg/server/profiling"
+	"github.com/nickheyer/jacuzzi/pkg/server/quality"
+	"github.com/nickheyer/jacuzzi/pkg/server/querycache"
+	"github.com/nickheyer/jacuzzi/pkg/server/redfish"
+	"github.com/nickheyer/jacuzzi/pkg/server/report"
+	"github.com/nickheyer/jacuzzi/pkg/server/rollup"
+	"github.com/nickheyer/jacuzzi/pkg/server/scripting"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
 	"github.com/nickheyer/jacuzzi/pkg/server/service"
+	"github.com/nickheyer/jacuzzi/pkg/server/staleness"
+	"github.com/nickheyer/jacuzzi/pkg/server/statuspage"
 	ui "github.com/nickheyer/jacuzzi/pkg/server/ui/jacuzzi"
+	"github.com/nickheyer/jacuzzi/pkg/server/usage"
+	"github.com/nickheyer/jacuzzi/pkg/server/vsphere"
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -40,32 +82,36 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.jacuzzi/server.yaml)")
 
 	// Server flags
-	rootCmd.Flags().Int("port", 50051, "The server port")
-	rootCmd.Flags().String("host", "", "The server host")
-	rootCmd.Flags().Int("http-port", 8080, "The HTTP server port for UI")
-	rootCmd.Flags().String("http-host", "", "The HTTP server host for UI")
+	rootCmd.PersistentFlags().Int("port", 50051, "The server port")
+	rootCmd.PersistentFlags().String("host", "", "The server host")
+	rootCmd.PersistentFlags().Int("http-port", 8080, "The HTTP server port for UI")
+	rootCmd.PersistentFlags().String("http-host", "", "The HTTP server host for UI")
 
 	// Database flags
-	rootCmd.Flags().String("db-type", "sqlite", "Database type (sqlite or postgres)")
-	rootCmd.Flags().String("db-host", "localhost", "Database host")
-	rootCmd.Flags().Int("db-port", 5432, "Database port")
-	rootCmd.Flags().String("db-user", "jacuzzi", "Database user")
-	rootCmd.Flags().String("db-password", "", "Database password")
-	rootCmd.Flags().String("db-name", "data/db/jacuzzi.db", "Database name")
-	rootCmd.Flags().String("db-sslmode", "disable", "Database SSL mode")
+	rootCmd.PersistentFlags().String("db-type", "sqlite", "Database type (sqlite or postgres)")
+	rootCmd.PersistentFlags().String("db-host", "localhost", "Database host")
+	rootCmd.PersistentFlags().Int("db-port", 5432, "Database port")
+	rootCmd.PersistentFlags().String("db-user", "jacuzzi", "Database user")
+	rootCmd.PersistentFlags().String("db-password", "", "Database password")
+	rootCmd.PersistentFlags().String("db-name", "data/db/jacuzzi.db", "Database name")
+	rootCmd.PersistentFlags().String("db-sslmode", "disable", "Database SSL mode")
 
 	// Bind flags to viper
-	viper.BindPFlag("server.port", rootCmd.Flags().Lookup("port"))
-	viper.BindPFlag("server.host", rootCmd.Flags().Lookup("host"))
-	viper.BindPFlag("server.http_port", rootCmd.Flags().Lookup("http-port"))
-	viper.BindPFlag("server.http_host", rootCmd.Flags().Lookup("http-host"))
-	viper.BindPFlag("database.type", rootCmd.Flags().Lookup("db-type"))
-	viper.BindPFlag("database.host", rootCmd.Flags().Lookup("db-host"))
-	viper.BindPFlag("database.port", rootCmd.Flags().Lookup("db-port"))
-	viper.BindPFlag("database.user", rootCmd.Flags().Lookup("db-user"))
-	viper.BindPFlag("database.password", rootCmd.Flags().Lookup("db-password"))
-	viper.BindPFlag("database.name", rootCmd.Flags().Lookup("db-name"))
-	viper.BindPFlag("database.sslmode", rootCmd.Flags().Lookup("db-sslmode"))
+	viper.BindPFlag("server.port", rootCmd.PersistentFlags().Lookup("port"))
+	viper.BindPFlag("server.host", rootCmd.PersistentFlags().Lookup("host"))
+	viper.BindPFlag("server.http_port", rootCmd.PersistentFlags().Lookup("http-port"))
+	viper.BindPFlag("server.http_host", rootCmd.PersistentFlags().Lookup("http-host"))
+	viper.BindPFlag("database.type", rootCmd.PersistentFlags().Lookup("db-type"))
+	viper.BindPFlag("database.host", rootCmd.PersistentFlags().Lookup("db-host"))
+	viper.BindPFlag("database.port", rootCmd.PersistentFlags().Lookup("db-port"))
+	viper.BindPFlag("database.user", rootCmd.PersistentFlags().Lookup("db-user"))
+	viper.BindPFlag("database.password", rootCmd.PersistentFlags().Lookup("db-password"))
+	viper.BindPFlag("database.name", rootCmd.PersistentFlags().Lookup("db-name"))
+	viper.BindPFlag("database.sslmode", rootCmd.PersistentFlags().Lookup("db-sslmode"))
+
+	cli.AddOutputFlag(rootCmd)
+	rootCmd.AddCommand(checkConfigCmd, seedDemoCmd)
+	rootCmd.AddCommand(cli.NewCompletionCmd(rootCmd.Name()))
 }
 
 func initConfig() {
@@ -89,42 +135,474 @@ func runServer(cmd *cobra.Command, args []string) error {
 	}
 
 	// Initialize database
-	dbConfig := db.Config{
-		Type:     cfg.Database.Type,
-		Host:     cfg.Database.Host,
-		Port:     cfg.Database.Port,
-		User:     cfg.Database.User,
-		Password: cfg.Database.Password,
-		DBName:   cfg.Database.Name,
-		SSLMode:  cfg.Database.SSLMode,
+	database, err := db.NewDatabase(databaseConfig(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	database, err := db.NewDatabase(dbConfig)
+	// Count database errors from the start
+	var monitor *health.Monitor
+	if cfg.Health.Enabled {
+		diskPath := "."
+		if cfg.Database.Type == "sqlite" {
+			diskPath = filepath.Dir(cfg.Database.Name)
+		}
+		monitor, err = health.NewMonitor(database, health.Config{
+			ClientID: cfg.Health.ClientID,
+			Interval: cfg.Health.Interval,
+			DiskPath: diskPath,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize health monitoring: %w", err)
+		}
+	}
+
+	// Create the event hub shared by streaming subscribers on all replicas
+	transport, err := events.NewTransport(cfg.Events.Backend, cfg.Events.URL, cfg.Events.Prefix)
 	if err != nil {
-		return fmt.Errorf("failed to initialize database: %w", err)
+		return fmt.Errorf("failed to initialize events backend: %w", err)
+	}
+	hub := events.NewHub(transport)
+
+	// Create the cache of stats, chart and status page results, which
+	// readings published on the hub invalidate
+	cache := querycache.New(cfg.QueryCache.Size, cfg.QueryCache.TTL)
+
+	// Create the optional message bus integration
+	var bridge *bus.Bridge
+	if cfg.Bus.Backend != "" {
+		bridge, err = bus.NewBridge(bus.Config{
+			URL:            cfg.Bus.URL,
+			SubjectPrefix:  cfg.Bus.SubjectPrefix,
+			Format:         cfg.Bus.Format,
+			ConsumeSubject: cfg.Bus.ConsumeSubject,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize message bus: %w", err)
+		}
+	}
+
+	// Create the dispatcher that notifies webhooks of system events
+	webhooks := webhook.NewDispatcher(database, cfg.Webhooks.Timeout)
+
+	// Create the router that sends alerts to notification channels
+	notifications := notify.NewRouter(database, cfg.Notifications.Timeout)
+
+	// Create the engine that runs automation scripts, when enabled
+	var scripts *scripting.Engine
+	if cfg.Scripting.Enabled {
+		scripts = scripting.NewEngine(database, scripting.Config{
+			Timeout:  cfg.Scripting.Timeout,
+			MaxSteps: cfg.Scripting.MaxSteps,
+		})
+	}
+
+	// Resolve client addresses behind the trusted proxies
+	clientIP, err := clientip.NewResolver(cfg.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	// Create gRPC server and the JSON gateway that serves the same services
+	// Every RPC, through any transport, passes through the interceptor chain
+	var usageRecorder *usage.Recorder
+	if cfg.Usage.Enabled {
+		usageRecorder = usage.NewRecorder()
+	}
+	interceptors := interceptor.NewChain(interceptor.Config{
+		AuthToken:   cfg.Server.AuthToken,
+		RateLimit:   cfg.Server.RateLimit,
+		LogRequests: cfg.Server.LogRequests,
+		Timeout:     cfg.Server.RequestTimeout,
+		ClientIP:    clientIP,
+		Usage:       usageRecorder,
+	})
+	grpcServer := grpc.NewServer(grpcServerOptions(cfg, interceptors)...)
+	apiGateway := gateway.New(interceptors.Unary, interceptors.Stream)
+
+	// Create all services
+	tempService := service.NewTemperatureService(database, service.TemperatureServiceConfig{
+		MaxReadingsPerRequest: cfg.Ingest.MaxReadingsPerRequest,
+		BatchIDTTL:            cfg.Ingest.BatchIDTTL,
+		MaxClockSkew:          cfg.Ingest.MaxClockSkew,
+		ClockSkewAction:       cfg.Ingest.ClockSkewAction,
+		EnrollmentMode:        cfg.Enrollment.Mode,
+
+		QuotaReadingsPerMinute: cfg.Ingest.QuotaReadingsPerMinute,
+		QuotaMaxSensors:        cfg.Ingest.QuotaMaxSensors,
+		QuotaAction:            cfg.Ingest.QuotaAction,
+
+		Events:   hub,
+		Bus:      bridge,
+		Webhooks: webhooks,
+		Scripts:  scripts,
+		ClientIP: clientIP,
+		Cache:    cache,
+
+		RollupStats: cfg.Rollup.Enabled,
+		Validation:  readingValidation(cfg),
+	})
+
+	clientService := service.NewClientService(database, service.ClientServiceConfig{
+		EnrollmentMode: cfg.Enrollment.Mode,
+		OfflineAfter:   cfg.Clients.OfflineAfter,
+		Webhooks:       webhooks,
+		ClientIP:       clientIP,
+	})
+
+	alertService := service.NewAlertService(database, service.AlertServiceConfig{
+		Bus:    bridge,
+		Events: hub,
+	})
+
+	settingsService := service.NewSettingsService(database, service.SettingsServiceConfig{
+		Webhooks: webhooks,
+	})
+
+	dashboardService := service.NewDashboardService(database)
+
+	annotationService := service.NewAnnotationService(database)
+
+	eventService := service.NewEventService(database)
+
+	// Background jobs are registered below, once their integrations are set up
+	overrides := make(map[string]jobs.Schedule, len(cfg.Jobs.Schedules))
+	for name, text := range cfg.Jobs.Schedules {
+		if overrides[name], err = jobs.Parse(text); err != nil {
+			return fmt.Errorf("invalid jobs.schedules.%s: %w", name, err)
+		}
+	}
+	scheduler := jobs.NewScheduler(database, overrides)
+	// Rollups run on the scheduler and with on-demand data maintenance
+	var rollups *rollup.Worker
+	if cfg.Rollup.Enabled {
+		rollups = rollup.NewWorker(database)
 	}
+	jobService := service.NewJobService(database, scheduler, rollups, cfg.Database.SizeQuota)
+
+	webhookService := service.NewWebhookService(database)
+	notificationService := service.NewNotificationService(database, notifications)
+
+	commandService := service.NewCommandService(database)
+
+	profileService := service.NewProfileService(database)
+
+	scriptService := service.NewScriptService(database, service.ScriptServiceConfig{
+		Enabled: cfg.Scripting.Enabled,
+	})
 
-	// Create gRPC server
-	grpcServer := grpc.NewServer()
+	// Power actions are only taken when enabled; configs can be managed either way
+	var powerController *power.Controller
+	if cfg.Power.Enabled {
+		powerController = power.NewController(cfg.Power.IPMIToolPath, cfg.Power.WakeBroadcast)
+	}
+	var powerSecrets *power.Secrets
+	if cfg.Power.SecretKey != "" {
+		if powerSecrets, err = power.NewSecrets(cfg.Power.SecretKey); err != nil {
+			return fmt.Errorf("invalid power.secret_key: %w", err)
+		}
+	}
+	powerService := service.NewPowerService(database, service.PowerServiceConfig{
+		Controller: powerController,
+		Timeout:    cfg.Power.Timeout,
+		Secrets:    powerSecrets,
+	})
+	if err := powerService.EncryptStoredPasswords(context.Background()); err != nil {
+		return err
+	}
+
+	signingKey, err := report.LoadSigningKey(database, cfg.Reports.SigningKey)
+	if err != nil {
+		return err
+	}
+	reportSigner := report.NewSigner(signingKey)
+	reportService := service.NewReportService(database, service.ReportServiceConfig{
+		Signer:  reportSigner,
+		URLTTL:  cfg.Reports.URLTTL,
+		BaseURL: cfg.Reports.BaseURL,
+	})
 
 	// Register all services
-	tempService := service.NewTemperatureService(database)
-	jacuzziv1.RegisterTemperatureServiceServer(grpcServer, tempService)
-	
-	clientService := service.NewClientService(database)
-	jacuzziv1.RegisterClientServiceServer(grpcServer, clientService)
-	
-	alertService := service.NewAlertService(database)
-	jacuzziv1.RegisterAlertServiceServer(grpcServer, alertService)
-	
-	settingsService := service.NewSettingsService(database)
-	jacuzziv1.RegisterSettingsServiceServer(grpcServer, settingsService)
+	for _, registrar := range []grpc.ServiceRegistrar{grpcServer, apiGateway} {
+		jacuzziv1.RegisterTemperatureServiceServer(registrar, tempService)
+		jacuzziv1.RegisterClientServiceServer(registrar, clientService)
+		jacuzziv1.RegisterAlertServiceServer(registrar, alertService)
+		jacuzziv1.RegisterSettingsServiceServer(registrar, settingsService)
+		jacuzziv1.RegisterReportServiceServer(registrar, reportService)
+		jacuzziv1.RegisterDashboardServiceServer(registrar, dashboardService)
+		jacuzziv1.RegisterAnnotationServiceServer(registrar, annotationService)
+		jacuzziv1.RegisterWebhookServiceServer(registrar, webhookService)
+		jacuzziv1.RegisterNotificationServiceServer(registrar, notificationService)
+		jacuzziv1.RegisterScriptServiceServer(registrar, scriptService)
+		jacuzziv1.RegisterCommandServiceServer(registrar, commandService)
+		jacuzziv1.RegisterProfileServiceServer(registrar, profileService)
+		jacuzziv1.RegisterPowerServiceServer(registrar, powerService)
+		jacuzziv1.RegisterEventServiceServer(registrar, eventService)
+		jacuzziv1.RegisterJobServiceServer(registrar, jobService)
+	}
+	// Agents that cannot reach the gRPC port report readings over HTTP, with
+	// the same authentication and ingestion as SubmitTemperature
+	apiGateway.Route("/api/v1/readings", "jacuzzi.v1.TemperatureService/SubmitTemperature")
+
+	// Create the Kubernetes API client when the node integration is enabled
+	var kubeClient *kube.Client
+	if cfg.Kubernetes.Enabled {
+		kubeClient, err = kube.NewClient(kube.ClientConfig{
+			APIServer: cfg.Kubernetes.APIServer,
+			TokenFile: cfg.Kubernetes.TokenFile,
+			CAFile:    cfg.Kubernetes.CAFile,
+			Timeout:   cfg.Kubernetes.Timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize Kubernetes client: %w", err)
+		}
+	}
+
+	// Connect to the hypervisors hosting clients when enabled
+	var hypervisors []hypervisor.Provider
+	if cfg.Hypervisor.Enabled {
+		hypervisors, err = hypervisorProviders(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if rollups != nil {
+		scheduler.Register(jobs.Job{
+			Name:     "rollup",
+			Schedule: jobs.Every(cfg.Rollup.Interval),
+			Run: func(ctx context.Context) error {
+				_, err := rollups.RunOnce(ctx)
+				return err
+			},
+		})
+	}
+	if cfg.Compression.Enabled {
+		scheduler.Register(jobs.Job{
+			Name:     "compression",
+			Schedule: jobs.Every(cfg.Compression.Interval),
+			Run: func(ctx context.Context) error {
+				result, err := chunks.Compress(database.WithContext(ctx), time.Now().Add(-cfg.Compression.After))
+				if result.Readings > 0 {
+					log.Printf("Compressed %d readings into %d chunks", result.Readings, result.Chunks)
+				}
+				return err
+			},
+		})
+	}
+	scheduler.Register(jobs.Job{
+		Name:     "retention",
+		Schedule: jobs.Every(time.Hour),
+		Run: func(ctx context.Context) error {
+			_, err := maintenance.Run(ctx, database, nil, false)
+			return err
+		},
+	})
+	// Postgres's autovacuum already reuses freed space. Nightly, as the first
+	// run on a SQLite database created before incremental auto_vacuum
+	// rewrites the whole file.
+	if cfg.Database.Type == "sqlite" {
+		vacuumSchedule, err := jobs.Parse("30 3 * * *")
+		if err != nil {
+			return err
+		}
+		scheduler.Register(jobs.Job{
+			Name:     "vacuum",
+			Schedule: vacuumSchedule,
+			Run: func(ctx context.Context) error {
+				return maintenance.Vacuum(database.WithContext(ctx))
+			},
+		})
+	}
+	checker := staleness.NewChecker(database, staleness.Config{
+		StaleAfter:   cfg.Sensors.StaleAfter,
+		RetireAfter:  cfg.Sensors.RetireAfter,
+		OfflineAfter: cfg.Clients.OfflineAfter,
+		Webhooks:     webhooks,
+		Scripts:      scripts,
+		Events:       hub,
+		Notify:       notifications,
+	})
+	scheduler.Register(jobs.Job{
+		Name:     "sensor_check",
+		Schedule: jobs.Every(cfg.Sensors.StaleCheckInterval),
+		Run: func(ctx context.Context) error {
+			return checker.RunOnce(ctx, time.Now())
+		},
+	})
+	if cfg.Sensors.QualityInterval > 0 {
+		scorer := quality.NewScorer(database, quality.Config{
+			Window:     cfg.Sensors.QualityWindow,
+			NoiseLimit: cfg.Sensors.QualityNoiseLimit,
+		})
+		scheduler.Register(jobs.Job{
+			Name:     "sensor_quality",
+			Schedule: jobs.Every(cfg.Sensors.QualityInterval),
+			Run: func(ctx context.Context) error {
+				return scorer.RunOnce(ctx, time.Now())
+			},
+		})
+	}
+	if cfg.Sensors.DutyCycleInterval > 0 {
+		tracker := dutycycle.NewTracker(database, dutyCycleConfig(cfg, scripts, notifications, hub))
+		scheduler.Register(jobs.Job{
+			Name:     "duty_cycle",
+			Schedule: jobs.Every(cfg.Sensors.DutyCycleInterval),
+			Run: func(ctx context.Context) error {
+				return tracker.RunOnce(ctx, time.Now())
+			},
+		})
+	}
+	alerts := evaluator.NewEvaluator(database, evaluator.Config{
+		Scripts:  scripts,
+		Events:   hub,
+		Notify:   notifications,
+		Backfill: cfg.Rollup.AlertBackfill,
+	})
+	scheduler.Register(jobs.Job{
+		Name:     "alert_evaluation",
+		Schedule: evaluator.Schedule{},
+		Run:      alerts.Evaluate,
+	})
+	scheduler.Register(jobs.Job{
+		Name:     "notification_digest",
+		Schedule: jobs.Every(time.Minute),
+		Run:      notifications.Flush,
+	})
+	if kubeClient != nil {
+		syncer := kube.NewSyncer(database, kubeClient, kube.SyncConfig{
+			LabelSelector: cfg.Kubernetes.LabelSelector,
+			ImportLabels:  cfg.Kubernetes.ImportLabels,
+			SetCondition:  cfg.Kubernetes.SetCondition,
+			TaintEffect:   cfg.Kubernetes.TaintEffect,
+		})
+		scheduler.Register(jobs.Job{
+			Name:     "kubernetes_sync",
+			Schedule: jobs.Every(cfg.Kubernetes.Interval),
+			Run: func(ctx context.Context) error {
+				return syncer.RunOnce(ctx, time.Now())
+			},
+		})
+	}
+	if len(hypervisors) > 0 {
+		syncer := hypervisor.NewSyncer(database, hypervisors, hypervisor.SyncConfig{
+			RequestMigration: cfg.Hypervisor.RequestMigration,
+			Webhooks:         webhooks,
+		})
+		scheduler.Register(jobs.Job{
+			Name:     "hypervisor_sync",
+			Schedule: jobs.Every(cfg.Hypervisor.Interval),
+			Run:      syncer.RunOnce,
+		})
+	}
+	if cfg.Redfish.Enabled {
+		endpoints, err := redfishEndpoints(cfg)
+		if err != nil {
+			return err
+		}
+		poller := redfish.NewPoller(database, endpoints, tempService.SubmitTemperature)
+		scheduler.Register(jobs.Job{
+			Name:     "redfish_poll",
+			Schedule: jobs.Every(cfg.Redfish.Interval),
+			Run:      poller.RunOnce,
+		})
+	}
+	if cfg.VSphere.Enabled {
+		endpoints, err := vsphereEndpoints(cfg)
+		if err != nil {
+			return err
+		}
+		poller := vsphere.NewPoller(database, endpoints, tempService.SubmitTemperature)
+		scheduler.Register(jobs.Job{
+			Name:     "vsphere_poll",
+			Schedule: jobs.Every(cfg.VSphere.Interval),
+			Run:      poller.RunOnce,
+		})
+	}
+	if cfg.Alertmanager.Enabled {
+		forwarder, err := alertmanager.New(database, alertmanager.Config{
+			URLs:               cfg.Alertmanager.URLs,
+			Username:           cfg.Alertmanager.Username,
+			Password:           cfg.Alertmanager.Password,
+			BearerToken:        cfg.Alertmanager.BearerToken,
+			CAFile:             cfg.Alertmanager.CAFile,
+			InsecureSkipVerify: cfg.Alertmanager.InsecureSkipVerify,
+			Labels:             cfg.Alertmanager.Labels,
+			ExternalURL:        cfg.Alertmanager.ExternalURL,
+			Interval:           cfg.Alertmanager.Interval,
+		}, cfg.Alertmanager.Timeout)
+		if err != nil {
+			return fmt.Errorf("failed to initialize Alertmanager forwarding: %w", err)
+		}
+		scheduler.Register(jobs.Job{
+			Name:     "alertmanager_forward",
+			Schedule: jobs.Every(cfg.Alertmanager.Interval),
+			Run:      forwarder.RunOnce,
+		})
+	}
+
+	// Open the CoAP socket for microcontroller probes, when enabled
+	var coapServer *coap.Server
+	if cfg.CoAP.Enabled {
+		coapServer, err = coap.Listen(net.JoinHostPort(cfg.CoAP.Host, strconv.Itoa(cfg.CoAP.Port)), tempService.SubmitTemperature)
+		if err != nil {
+			return err
+		}
+		log.Printf("Accepting CoAP readings on udp %s", coapServer.Addr())
+	}
+
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	go hub.Run(workerCtx)
+	go cache.Run(workerCtx, hub)
+	// Every replica counts the RPCs it serves
+	go usageRecorder.Run(workerCtx, database, cfg.Usage.FlushInterval)
+	if bridge != nil {
+		go bridge.Run(workerCtx, tempService.SubmitTemperature)
+	}
+	// Every replica ingests readings from the probes that reach it
+	if coapServer != nil {
+		go coapServer.Run(workerCtx)
+	}
+	// Every replica runs scripts on the readings it stores
+	if scripts != nil {
+		go scripts.Run(workerCtx)
+	}
+	// Every replica reports its own health
+	if monitor != nil {
+		go monitor.Run(workerCtx, tempService.SubmitTemperature)
+	}
+
+	startWorkers := func(ctx context.Context) {
+		go scheduler.Run(ctx)
+	}
+
+	electorDone := make(chan struct{})
+	if cfg.HA.Enabled {
+		// Only the elected replica runs background workers
+		log.Printf("HA mode enabled; campaigning for leadership as %s", cfg.HA.InstanceID)
+		elector := leader.NewElector(database, "background-workers", cfg.HA.InstanceID, cfg.HA.LeaseTTL)
+		go func() {
+			elector.Run(workerCtx, startWorkers)
+			close(electorDone)
+		}()
+	} else {
+		startWorkers(workerCtx)
+		close(electorDone)
+	}
 
 	// Register reflection service for easier debugging
 	reflection.Register(grpcServer)
 
-	// Start listening
-	lis, err := net.Listen("tcp", cfg.GetServerAddress())
+	// Start listening, on the sockets of the previous process when it
+	// handed them over
+	upgrader, err := handoff.New(cfg.Server.PIDFile)
+	if err != nil {
+		return err
+	}
+	lis, err := upgrader.Listen("grpc", cfg.GetServerAddress())
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
@@ -132,6 +610,23 @@ func runServer(cmd *cobra.Command, args []string) error {
 	log.Printf("Starting Jacuzzi server on %s", cfg.GetServerAddress())
 	log.Printf("Database: %s (%s)", cfg.Database.Type, cfg.Database.Name)
 
+	// Let agents on the local network find the server with --server auto
+	if cfg.Server.MDNS {
+		ad := mdns.Advertisement{
+			Port: cfg.Server.Port,
+			Text: []string{fmt.Sprintf("http_port=%d", cfg.Server.HTTPPort)},
+		}
+		if ip := net.ParseIP(cfg.Server.Host).To4(); ip != nil {
+			ad.IPs = []net.IP{ip}
+		}
+		log.Printf("Advertising %s on port %d over mDNS", mdns.Service, cfg.Server.Port)
+		go func() {
+			if err := mdns.Advertise(workerCtx, ad); err != nil {
+				log.Printf("mDNS advertisement stopped: %v", err)
+			}
+		}()
+	}
+
 	// Create HTTP server for UI and gRPC-Web
 
 	// Wrap the gRPC server with gRPC-Web
@@ -141,8 +636,75 @@ func runServer(cmd *cobra.Command, args []string) error {
 			return true
 		}))
 
-	// Create a handler that serves both gRPC-Web and static files
+	// Create a handler that serves metrics, the JSON API, gRPC-Web, and static files
+	metricsHandler := interceptors.RequireAuth(metrics.Default.Handler())
+	openAPIHandler := apiGateway.OpenAPIHandler("Jacuzzi API", "v1")
+	swaggerHandler := gateway.SwaggerUIHandler()
+	uiHandler := ui.Handler()
+	var statusHandler, chartHandler http.Handler = statuspage.NewHandler(database, cache), chart.NewHandler(database, cache)
+	// Kiosk displays may not be able to send the token, so they can be let in
+	// explicitly
+	if !cfg.Server.PublicStatusPage {
+		statusHandler = interceptors.RequireAuth(statusHandler)
+		chartHandler = interceptors.RequireAuth(chartHandler)
+	}
+	reportHandler := report.NewHandler(database, reportSigner)
+	var graphqlHandler http.Handler
+	if cfg.GraphQL.Enabled {
+		h, err := graphql.NewHandler(database)
+		if err != nil {
+			return fmt.Errorf("failed to build GraphQL schema: %w", err)
+		}
+		graphqlHandler = interceptors.RequireAuth(h)
+		log.Printf("GraphQL endpoint enabled at %s", graphql.Path)
+	}
+	var receiverHandler http.Handler
+	if cfg.AlertReceiver.Enabled {
+		receiverHandler = alertreceiver.NewHandler(database, hub, cfg.AlertReceiver.Token)
+		if cfg.AlertReceiver.Token == "" {
+			receiverHandler = interceptors.RequireAuth(receiverHandler)
+		}
+		log.Printf("Alert receiver enabled at %s", alertreceiver.Path)
+	}
+	var debugHandler http.Handler
+	if cfg.Debug.Enabled {
+		debugHandler = interceptors.RequireAuth(profiling.NewHandler(cfg.Debug.DumpDir))
+		log.Printf("Debug endpoints enabled at %s", profiling.PathPrefix)
+	}
 	httpHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/metrics":
+			metricsHandler.ServeHTTP(w, r)
+			return
+		case r.URL.Path == statuspage.Path:
+			statusHandler.ServeHTTP(w, r)
+			return
+		case r.URL.Path == chart.Path:
+			chartHandler.ServeHTTP(w, r)
+			return
+		case strings.HasPrefix(r.URL.Path, report.PathPrefix):
+			reportHandler.ServeHTTP(w, r)
+			return
+		case r.URL.Path == "/openapi.json":
+			openAPIHandler.ServeHTTP(w, r)
+			return
+		case r.URL.Path == "/swagger" || r.URL.Path == "/swagger/":
+			swaggerHandler.ServeHTTP(w, r)
+			return
+		case strings.HasPrefix(r.URL.Path, gateway.PathPrefix):
+			apiGateway.ServeHTTP(w, r)
+			return
+		case graphqlHandler != nil && (r.URL.Path == graphql.Path || r.URL.Path == graphql.Path+"/schema"):
+			graphqlHandler.ServeHTTP(w, r)
+			return
+		case receiverHandler != nil && r.URL.Path == alertreceiver.Path:
+			receiverHandler.ServeHTTP(w, r)
+			return
+		case debugHandler != nil && strings.HasPrefix(r.URL.Path, profiling.PathPrefix):
+			debugHandler.ServeHTTP(w, r)
+			return
+		}
+
 		// Check if this is a gRPC-Web request
 		if grpcWebServer.IsGrpcWebRequest(r) {
 			grpcWebServer.ServeHTTP(w, r)
@@ -150,12 +712,11 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}
 
 		// Otherwise serve the embedded UI
-		fileSystem := ui.GetFileSystem()
-		http.FileServer(fileSystem).ServeHTTP(w, r)
+		uiHandler.ServeHTTP(w, r)
 	})
 
 	// Configure HTTP server
-	httpAddr := fmt.Sprintf("%s:%d", cfg.Server.HTTPHost, cfg.Server.HTTPPort)
+	httpAddr := cfg.GetHTTPAddress()
 	httpServer := &http.Server{
 		Addr:         httpAddr,
 		Handler:      httpHandler,
@@ -165,19 +726,42 @@ func runServer(cmd *cobra.Command, args []string) error {
 	}
 
 	// Start HTTP server
+	httpLis, err := upgrader.Listen("http", httpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for HTTP: %w", err)
+	}
 	go func() {
 		log.Printf("Starting HTTP server on %s", httpAddr)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := httpServer.Serve(httpLis); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("HTTP server failed: %v", err)
 		}
 	}()
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown, and upgrades, which start the executable
+	// again on the same sockets and shut down once it serves them
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
+		handoff.Notify(sigChan)
+		for sig := range sigChan {
+			if !handoff.IsUpgrade(sig) {
+				break
+			}
+			log.Println("Upgrading: starting new server process...")
+			if err := upgrader.Upgrade(context.Background()); err != nil {
+				log.Printf("Upgrade failed, still serving: %v", err)
+				continue
+			}
+			log.Println("New server process is serving; shutting down this one")
+			break
+		}
 		log.Println("Shutting down servers...")
+		stopWorkers()
+
+		hub.Close()
+
+		// Wait for the lease to be released so a standby can take over promptly
+		<-electorDone
 
 		// Shutdown HTTP server
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -188,8 +772,24 @@ func runServer(cmd *cobra.Command, args []string) error {
 
 		// Shutdown gRPC server
 		grpcServer.GracefulStop()
+
+		// Save the usage of the last requests
+		if err := usageRecorder.Flush(database); err != nil {
+			log.Printf("Failed to save request usage: %v", err)
+		}
+
+		// Deliver events raised by the last requests
+		webhooks.Close()
+		notifications.Close()
 	}()
 
+	if upgrader.Inherited() {
+		log.Println("Took over listeners from the previous server process")
+	}
+	if err := upgrader.Ready(); err != nil {
+		return err
+	}
+
 	// Start serving gRPC
 	if err := grpcServer.Serve(lis); err != nil {
 		return fmt.Errorf("failed to serve: %w", err)
@@ -198,6 +798,158 @@ func runServer(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// databaseConfig converts the server configuration to a database configuration
+func databaseConfig(cfg *config.Config) db.Config {
+	return db.Config{
+		Type:     cfg.Database.Type,
+		Host:     cfg.Database.Host,
+		Port:     cfg.Database.Port,
+		User:     cfg.Database.User,
+		Password: cfg.Database.Password,
+		DBName:   cfg.Database.Name,
+		SSLMode:  cfg.Database.SSLMode,
+
+		StatementTimeout: cfg.Database.StatementTimeout,
+	}
+}
+
+// readingValidation converts the ingest validation configuration, or returns
+// nil when validation is disabled
+func readingValidation(cfg *config.Config) *service.ReadingValidation {
+	vc := cfg.Ingest.Validation
+	if !vc.Enabled {
+		return nil
+	}
+	v := &service.ReadingValidation{
+		Ranges:       make(map[string]service.ReadingRange),
+		RejectValues: vc.RejectValues,
+		MaxSpike:     vc.MaxSpike,
+		SpikeWindow:  vc.SpikeWindow,
+	}
+	for name, r := range vc.Ranges {
+		if name == "default" {
+			v.DefaultRange = &service.ReadingRange{Min: r.Min, Max: r.Max}
+			continue
+		}
+		v.Ranges[sensortype.Normalize(name)] = service.ReadingRange{Min: r.Min, Max: r.Max}
+	}
+	return v
+}
+
+// dutyCycleConfig converts the duty cycle thresholds configured by sensor
+// type
+func dutyCycleConfig(cfg *config.Config, scripts *scripting.Engine, notifications *notify.Router, hub *events.Hub) dutycycle.Config {
+	c := dutycycle.Config{
+		Thresholds: make(map[string][]float64),
+		Scripts:    scripts,
+		Notify:     notifications,
+		Events:     hub,
+	}
+	for name, thresholds := range cfg.Sensors.DutyCycleThresholds {
+		if name == "default" {
+			c.DefaultThresholds = thresholds
+			continue
+		}
+		c.Thresholds[sensortype.Normalize(name)] = thresholds
+	}
+	return c
+}
+
+// hypervisorProviders connects to the configured Proxmox clusters and libvirt
+// hosts
+func hypervisorProviders(cfg *config.Config) ([]hypervisor.Provider, error) {
+	var providers []hypervisor.Provider
+	for _, pve := range cfg.Hypervisor.Proxmox {
+		provider, err := hypervisor.NewProxmox(hypervisor.ProxmoxConfig{
+			URL:                pve.URL,
+			TokenID:            pve.TokenID,
+			TokenSecret:        pve.TokenSecret,
+			CAFile:             pve.CAFile,
+			InsecureSkipVerify: pve.InsecureSkipVerify,
+		}, cfg.Hypervisor.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Proxmox integration: %w", err)
+		}
+		providers = append(providers, provider)
+	}
+	for _, uri := range cfg.Hypervisor.Libvirt {
+		provider, err := hypervisor.NewLibvirt(cfg.Hypervisor.VirshPath, uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize libvirt integration: %w", err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// redfishEndpoints sets up the configured BMCs' Redfish APIs
+func redfishEndpoints(cfg *config.Config) ([]*redfish.Endpoint, error) {
+	var endpoints []*redfish.Endpoint
+	for _, bmc := range cfg.Redfish.Endpoints {
+		endpoint, err := redfish.NewEndpoint(redfish.EndpointConfig{
+			Name:               bmc.Name,
+			URL:                bmc.URL,
+			Username:           bmc.Username,
+			Password:           bmc.Password,
+			CAFile:             bmc.CAFile,
+			InsecureSkipVerify: bmc.InsecureSkipVerify,
+		}, cfg.Redfish.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Redfish endpoint %s: %w", bmc.Name, err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// vsphereEndpoints sets up the configured vCenter Servers and ESXi hosts
+func vsphereEndpoints(cfg *config.Config) ([]*vsphere.Endpoint, error) {
+	var endpoints []*vsphere.Endpoint
+	for _, vc := range cfg.VSphere.Endpoints {
+		endpoint, err := vsphere.NewEndpoint(vsphere.EndpointConfig{
+			URL:                vc.URL,
+			Username:           vc.Username,
+			Password:           vc.Password,
+			CAFile:             vc.CAFile,
+			InsecureSkipVerify: vc.InsecureSkipVerify,
+		}, cfg.VSphere.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize vSphere endpoint %s: %w", vc.URL, err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// grpcServerOptions builds the gRPC server options from configuration
+func grpcServerOptions(cfg *config.Config, interceptors *interceptor.Chain) []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(interceptors.Unary),
+		grpc.StreamInterceptor(interceptors.Stream),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     cfg.Server.MaxConnectionIdle,
+			MaxConnectionAge:      cfg.Server.MaxConnectionAge,
+			MaxConnectionAgeGrace: cfg.Server.MaxConnectionAgeGrace,
+			Time:                  cfg.Server.KeepaliveTime,
+			Timeout:               cfg.Server.KeepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.Server.KeepaliveMinTime,
+			PermitWithoutStream: cfg.Server.KeepalivePermitWithoutStream,
+		}),
+	}
+	if cfg.Server.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(cfg.Server.MaxConcurrentStreams))
+	}
+	if cfg.Server.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.Server.MaxRecvMsgSize))
+	}
+	if cfg.Server.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(cfg.Server.MaxSendMsgSize))
+	}
+	return opts
+}
+
 func main() {
 	Execute()
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/cli"
+	"github.com/nickheyer/jacuzzi/pkg/server/config"
+	"github.com/nickheyer/jacuzzi/pkg/server/db"
+	"github.com/nickheyer/jacuzzi/pkg/server/demo"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var seedDemoCmd = &cobra.Command{
+	Use:   "seed-demo",
+	Short: "Fill the database with demo clients, temperature history and alerts",
+	Long: `Fill the configured database with fake clients and sensors, a week of
+temperature history following daily load patterns, sample alert rules, and the
+alerts they raised, so a fresh server shows a populated UI. Demo clients and
+rules have IDs starting with "demo-"; remove them with --remove, or replace
+them with fresh data with --reset. Start the server afterwards as usual.`,
+	Args: cobra.NoArgs,
+	RunE: runSeedDemo,
+}
+
+func init() {
+	seedDemoCmd.Flags().Int("clients", 6, "Number of demo clients")
+	seedDemoCmd.Flags().Duration("history", 7*24*time.Hour, "How far back the temperature history goes")
+	seedDemoCmd.Flags().Duration("interval", 5*time.Minute, "Time between readings of a sensor")
+	seedDemoCmd.Flags().Bool("reset", false, "Replace existing demo data")
+	seedDemoCmd.Flags().Bool("remove", false, "Remove the demo data and exit")
+}
+
+func runSeedDemo(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	flags := cmd.Flags()
+	clients, _ := flags.GetInt("clients")
+	history, _ := flags.GetDuration("history")
+	interval, _ := flags.GetDuration("interval")
+	reset, _ := flags.GetBool("reset")
+	remove, _ := flags.GetBool("remove")
+	if clients < 1 || history <= 0 || interval <= 0 {
+		return fmt.Errorf("--clients, --history and --interval must be positive")
+	}
+	if history/interval > 100000 {
+		return fmt.Errorf("--history of %s at an --interval of %s is more than 100000 readings per sensor", history, interval)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	database, err := db.NewDatabase(databaseConfig(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	if sqlDB, err := database.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	// Logging every batch of readings would bury the summary
+	database = database.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	ctx := cmd.Context()
+	exists, err := demo.Exists(ctx, database)
+	if err != nil {
+		return err
+	}
+	if remove || (exists && reset) {
+		if err := demo.Remove(ctx, database); err != nil {
+			return err
+		}
+		if remove {
+			fmt.Fprintln(cmd.OutOrStdout(), "Demo data removed")
+			return nil
+		}
+	} else if exists {
+		return fmt.Errorf("demo data already exists; pass --reset to replace it or --remove to delete it")
+	}
+
+	summary, err := demo.Seed(ctx, database, demo.Options{
+		Clients:  clients,
+		History:  history,
+		Interval: interval,
+		Seed:     uint64(time.Now().UnixNano()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to seed demo data: %w", err)
+	}
+
+	return cli.Print(cmd, summary, func(w io.Writer) error {
+		fmt.Fprintf(w, "Clients:\t%d\n", summary.Clients)
+		fmt.Fprintf(w, "Sensors:\t%d\n", summary.Sensors)
+		fmt.Fprintf(w, "Readings:\t%d\n", summary.Readings)
+		fmt.Fprintf(w, "Alert rules:\t%d\n", summary.Rules)
+		fmt.Fprintf(w, "Alerts:\t%d\n", summary.Alerts)
+		return nil
+	})
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// NewCompletionCmd returns a command that generates shell completion scripts
+// for the named root command it is added to
+func NewCompletionCmd(name string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion [bash|zsh|fish|powershell]",
+		Short: "Generate shell completion script",
+		Long: fmt.Sprintf(`Generate a shell completion script and write it to stdout.
+
+  bash:       source <(%[1]s completion bash)
+  zsh:        %[1]s completion zsh > "${fpath[1]}/_%[1]s"
+  fish:       %[1]s completion fish | source
+  powershell: %[1]s completion powershell | Out-String | Invoke-Expression`, name),
+		ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+		Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(os.Stdout, true)
+			case "zsh":
+				return root.GenZshCompletion(os.Stdout)
+			case "fish":
+				return root.GenFishCompletion(os.Stdout, true)
+			default:
+				return root.GenPowerShellCompletionWithDesc(os.Stdout)
+			}
+		},
+	}
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+var formats = []string{FormatTable, FormatJSON, FormatYAML}
+
+// AddOutputFlag registers the global --output flag on a root command
+func AddOutputFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("output", "o", FormatTable, "Output format (table, json, or yaml)")
+	cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(formats, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// OutputFormat returns the validated --output value for a command
+func OutputFormat(cmd *cobra.Command) (string, error) {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return FormatTable, nil
+	}
+	for _, f := range formats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("invalid output format %q: must be table, json, or yaml", format)
+}
+
+// PrintProto writes a proto message in the selected format. Table output is
+// delegated to the table function, which writes to a tab-aligned writer.
+func PrintProto(cmd *cobra.Command, msg proto.Message, table func(w io.Writer) error) error {
+	format, err := OutputFormat(cmd)
+	if err != nil {
+		return err
+	}
+	if format == FormatTable {
+		return printTable(table)
+	}
+
+	data, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return printEncoded(format, data)
+}
+
+// Print writes a plain Go value in the selected format, using its JSON
+// encoding for both json and yaml output
+func Print(cmd *cobra.Command, v interface{}, table func(w io.Writer) error) error {
+	format, err := OutputFormat(cmd)
+	if err != nil {
+		return err
+	}
+	if format == FormatTable {
+		return printTable(table)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return printEncoded(format, data)
+}
+
+func printTable(table func(w io.Writer) error) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if err := table(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// printEncoded writes JSON data as indented JSON or converts it to YAML
+func printEncoded(format string, data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("failed to decode output: %w", err)
+	}
+
+	if format == FormatYAML {
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
@@ -1,7 +1,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -11,22 +17,387 @@ type Config struct {
 	Server     ServerConfig     `mapstructure:"server"`
 	Client     ClientConfig     `mapstructure:"client"`
 	Monitoring MonitoringConfig `mapstructure:"monitoring"`
+	Commands   CommandsConfig   `mapstructure:"commands"`
+	Host       HostConfig       `mapstructure:"host"`
+	Gateway    GatewayConfig    `mapstructure:"gateway"`
 }
 
+// AutoAddress is the server address that finds the server over mDNS
+const AutoAddress = "auto"
+
+// Transports the client can reach the server over
+const (
+	TransportGRPC = "grpc"
+	// TransportHTTP calls the server's JSON API on its HTTP port, for networks
+	// whose proxies or firewalls block gRPC
+	TransportHTTP = "http"
+)
+
 type ServerConfig struct {
 	Address string        `mapstructure:"address"`
 	Timeout time.Duration `mapstructure:"timeout"`
+	// grpc, or http to use the JSON API, with Address the server's HTTP
+	// address or URL
+	Transport string `mapstructure:"transport"`
+
+	// Keepalive parameters
+	KeepaliveTime                time.Duration `mapstructure:"keepalive_time"`
+	KeepaliveTimeout             time.Duration `mapstructure:"keepalive_timeout"`
+	KeepalivePermitWithoutStream bool          `mapstructure:"keepalive_permit_without_stream"`
+
+	// Message size limits in bytes
+	MaxRecvMsgSize int `mapstructure:"max_recv_msg_size"`
+	MaxSendMsgSize int `mapstructure:"max_send_msg_size"`
 }
 
 type ClientConfig struct {
-	ID       string        `mapstructure:"id"`
+	// Explicit client ID; when empty a UUID is generated and kept in IdentityFile
+	ID           string `mapstructure:"id"`
+	IdentityFile string `mapstructure:"identity_file"`
+	// One-time token used to enroll on first run; the API key received is kept in APIKeyFile
+	EnrollmentToken string `mapstructure:"enrollment_token"`
+	APIKeyFile      string `mapstructure:"api_key_file"`
+
 	Interval time.Duration `mapstructure:"interval"`
+	// Sensors are sampled this often and each report carries the minimum,
+	// maximum and average since the previous one; 0 samples once per report
+	SampleInterval time.Duration `mapstructure:"sample_interval"`
+	// Maximum readings per SubmitTemperature call; larger sets are split
+	BatchSize int `mapstructure:"batch_size"`
 }
 
 type MonitoringConfig struct {
 	CPU  bool `mapstructure:"cpu"`
 	GPU  bool `mapstructure:"gpu"`
 	Disk bool `mapstructure:"disk"`
+
+	// °C above which a warning is logged for sensors of a type, by sensor type
+	// such as cpu
+	Thresholds map[string]float64 `mapstructure:"thresholds"`
+
+	Mock MockConfig `mapstructure:"mock"`
+
+	// USB and serial thermometers to report alongside hwmon
+	Probes []ProbeConfig `mapstructure:"probes"`
+
+	// Modbus devices, such as PLCs and industrial thermostats, to poll
+	// temperatures from
+	Modbus []ModbusConfig `mapstructure:"modbus"`
+}
+
+// Probe kinds
+const (
+	// ProbeTEMPer reads a TEMPer HID thermometer through /dev/hidraw*
+	ProbeTEMPer = "temper"
+	// ProbeSerial reads a device printing readings such as T=23.5 over a
+	// serial port
+	ProbeSerial = "serial"
+)
+
+// DefaultProbePattern matches serial lines such as T=23.5, Temp: 23.5 or
+// temperature=-4
+const DefaultProbePattern = `(?i)\bt(?:emp(?:erature)?)?\s*[=:]\s*(-?\d+(?:\.\d+)?)`
+
+// ProbeConfig is a USB or serial thermometer
+type ProbeConfig struct {
+	Kind   string `mapstructure:"kind"`
+	Device string `mapstructure:"device"`
+	// Defaults to <kind>_<device file name>, such as serial_ttyUSB0
+	ID   string `mapstructure:"id"`
+	Name string `mapstructure:"name"`
+	// Defaults to AMBIENT
+	Type string `mapstructure:"type"`
+
+	// TEMPer report format, v1 or gold; empty picks it from the USB ID
+	Format string `mapstructure:"format"`
+
+	// Serial line settings, and a regular expression whose first group
+	// captures the temperature, in °C unless unit is F
+	BaudRate int    `mapstructure:"baud_rate"`
+	Pattern  string `mapstructure:"pattern"`
+	Unit     string `mapstructure:"unit"`
+}
+
+// MockConfig replaces hwmon with synthetic sensors, for development on
+// machines without hwmon access such as containers or macOS
+type MockConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Sensors     int     `mapstructure:"sensors"`
+	BaseCelsius float64 `mapstructure:"base_celsius"`
+	// Amplitude of a slow ten-minute swing, and standard deviation of the noise
+	Drift float64 `mapstructure:"drift"`
+	Noise float64 `mapstructure:"noise"`
+	// Every spike_interval the sensors rise by spike_celsius for spike_duration;
+	// a zero interval disables spikes
+	SpikeInterval time.Duration `mapstructure:"spike_interval"`
+	SpikeDuration time.Duration `mapstructure:"spike_duration"`
+	SpikeCelsius  float64       `mapstructure:"spike_celsius"`
+}
+
+// CommandsConfig opts in to commands sent by the server. The client only polls
+// for commands when it accepts at least one kind.
+type CommandsConfig struct {
+	// Accept set_fan commands, which write fan duty cycles through hwmon
+	FanControl   bool          `mapstructure:"fan_control"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+
+	// Local actions run_action commands may run, by name, e.g.
+	// shutdown: "systemctl poweroff". Each is run with sh -c.
+	LocalActions  map[string]string `mapstructure:"local_actions"`
+	ActionTimeout time.Duration     `mapstructure:"action_timeout"`
+	// File every local action run is appended to; empty logs to stderr only
+	AuditFile string `mapstructure:"audit_file"`
+
+	// Accept burst_capture commands, which report at a high rate for a while
+	// alongside the regular reports
+	BurstCapture bool `mapstructure:"burst_capture"`
+
+	// Accept apply_config commands, which lay the configuration profiles the
+	// server assigns to this client over the interval, monitoring and
+	// thresholds set here until the client restarts
+	RemoteConfig bool `mapstructure:"remote_config"`
+
+	// Accept log_event commands, which write the server's alerts to the
+	// system log: the Event Log on Windows, journald or syslog elsewhere
+	EventLog bool `mapstructure:"event_log"`
+}
+
+// GatewayConfig lets the client forward readings from other devices, which
+// send them over HTTP, MQTT or serial lines, along with its own sensors
+type GatewayConfig struct {
+	// Address to accept readings POSTed to /readings on, such as
+	// 127.0.0.1:8090 or an address on a trusted LAN; empty disables the
+	// HTTP input
+	HTTPListen string `mapstructure:"http_listen"`
+	// Bearer token devices must send to the HTTP input; empty accepts
+	// readings from anyone who can reach http_listen
+	HTTPToken string            `mapstructure:"http_token"`
+	MQTT      GatewayMQTTConfig `mapstructure:"mqtt"`
+	// Serial devices printing one JSON payload per line
+	SerialDevices []string `mapstructure:"serial_devices"`
+	BaudRate      int      `mapstructure:"baud_rate"`
+	// Devices' sensors are no longer reported once they send nothing for this long
+	StaleAfter time.Duration `mapstructure:"stale_after"`
+}
+
+// GatewayMQTTConfig subscribes to readings published to a broker
+type GatewayMQTTConfig struct {
+	// tcp://host:1883 or tls://host:8883; empty disables the MQTT input
+	Broker   string `mapstructure:"broker"`
+	Topic    string `mapstructure:"topic"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// Defaults to jacuzzi-gateway-<hostname>
+	ClientID string `mapstructure:"client_id"`
+}
+
+// Enabled reports whether any gateway input is configured
+func (g GatewayConfig) Enabled() bool {
+	return g.HTTPListen != "" || g.MQTT.Broker != "" || len(g.SerialDevices) > 0
+}
+
+// validate checks the probe and fills in its defaults
+func (p *ProbeConfig) validate() error {
+	if p.Device == "" {
+		return errors.New("missing device")
+	}
+	switch p.Kind {
+	case ProbeTEMPer:
+		if p.Format != "" && p.Format != "v1" && p.Format != "gold" {
+			return fmt.Errorf("format %q must be v1 or gold", p.Format)
+		}
+	case ProbeSerial:
+		if p.BaudRate == 0 {
+			p.BaudRate = 9600
+		}
+		if p.Pattern == "" {
+			p.Pattern = DefaultProbePattern
+		}
+		pattern, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+		if pattern.NumSubexp() < 1 {
+			return fmt.Errorf("pattern %q must capture the temperature in a group", p.Pattern)
+		}
+		switch strings.ToUpper(p.Unit) {
+		case "", "C", "F":
+		default:
+			return fmt.Errorf("unit %q must be C or F", p.Unit)
+		}
+	default:
+		return fmt.Errorf("kind %q must be %s or %s", p.Kind, ProbeTEMPer, ProbeSerial)
+	}
+	if p.ID == "" {
+		p.ID = p.Kind + "_" + filepath.Base(p.Device)
+	}
+	if p.Name == "" {
+		p.Name = filepath.Base(p.Device)
+	}
+	if p.Type == "" {
+		p.Type = "AMBIENT"
+	}
+	return nil
+}
+
+// ModbusConfig polls temperatures from a Modbus TCP server at address, or
+// from devices on an RTU bus on the serial port at device
+type ModbusConfig struct {
+	// host:port; the port defaults to 502
+	Address string `mapstructure:"address"`
+	Device  string `mapstructure:"device"`
+	// RTU line settings; parity is N, E or O and defaults to E
+	BaudRate int    `mapstructure:"baud_rate"`
+	Parity   string `mapstructure:"parity"`
+	StopBits int    `mapstructure:"stop_bits"`
+	// Unit ID of the registers that set none, defaulting to 1
+	UnitID  int           `mapstructure:"unit_id"`
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	Registers []ModbusRegisterConfig `mapstructure:"registers"`
+}
+
+// ModbusRegisterConfig is a temperature held in one or two registers
+type ModbusRegisterConfig struct {
+	// Defaults to modbus_<host or device file name>_<unit ID>_<address>
+	ID   string `mapstructure:"id"`
+	Name string `mapstructure:"name"`
+	// Defaults to AMBIENT
+	Type   string `mapstructure:"type"`
+	UnitID int    `mapstructure:"unit_id"`
+	// holding or input; defaults to holding
+	Table   string `mapstructure:"table"`
+	Address int    `mapstructure:"address"`
+	// int16, uint16, int32, uint32 or float32; defaults to int16
+	DataType string `mapstructure:"data_type"`
+	// Two-register values take the high word first unless word_order is little
+	WordOrder string `mapstructure:"word_order"`
+	// The temperature is value*scale + offset, in °C unless unit is F. A zero
+	// scale is taken as 1.
+	Scale  float64 `mapstructure:"scale"`
+	Offset float64 `mapstructure:"offset"`
+	Unit   string  `mapstructure:"unit"`
+}
+
+// validate checks the device and its registers and fills in their defaults
+func (m *ModbusConfig) validate() error {
+	var endpoint string
+	switch {
+	case m.Address != "" && m.Device != "":
+		return errors.New("set address for Modbus TCP or device for Modbus RTU, not both")
+	case m.Address != "":
+		host, port, err := net.SplitHostPort(m.Address)
+		if err != nil {
+			host, port = m.Address, "502"
+		}
+		m.Address = net.JoinHostPort(host, port)
+		endpoint = host
+	case m.Device != "":
+		if m.BaudRate == 0 {
+			m.BaudRate = 9600
+		}
+		if m.Parity == "" {
+			m.Parity = "E"
+		}
+		m.Parity = strings.ToUpper(m.Parity)
+		if m.Parity != "N" && m.Parity != "E" && m.Parity != "O" {
+			return fmt.Errorf("parity %q must be N, E or O", m.Parity)
+		}
+		if m.StopBits == 0 {
+			m.StopBits = 1
+		}
+		if m.StopBits != 1 && m.StopBits != 2 {
+			return fmt.Errorf("stop_bits %d must be 1 or 2", m.StopBits)
+		}
+		endpoint = filepath.Base(m.Device)
+	default:
+		return errors.New("missing address or device")
+	}
+	if m.UnitID == 0 {
+		m.UnitID = 1
+	}
+	if m.UnitID < 0 || m.UnitID > 255 {
+		return fmt.Errorf("unit_id %d must be between 0 and 255", m.UnitID)
+	}
+	if m.Timeout == 0 {
+		m.Timeout = 2 * time.Second
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("timeout %s must be positive", m.Timeout)
+	}
+	if len(m.Registers) == 0 {
+		return errors.New("no registers configured")
+	}
+	for i := range m.Registers {
+		r := &m.Registers[i]
+		if r.UnitID == 0 {
+			r.UnitID = m.UnitID
+		}
+		if r.UnitID < 0 || r.UnitID > 255 {
+			return fmt.Errorf("registers[%d]: unit_id %d must be between 0 and 255", i, r.UnitID)
+		}
+		if r.Address < 0 || r.Address > 65535 {
+			return fmt.Errorf("registers[%d]: address %d must be between 0 and 65535", i, r.Address)
+		}
+		switch r.Table {
+		case "":
+			r.Table = "holding"
+		case "holding", "input":
+		default:
+			return fmt.Errorf("registers[%d]: table %q must be holding or input", i, r.Table)
+		}
+		switch r.DataType {
+		case "":
+			r.DataType = "int16"
+		case "int16", "uint16", "int32", "uint32", "float32":
+		default:
+			return fmt.Errorf("registers[%d]: data_type %q must be int16, uint16, int32, uint32 or float32", i, r.DataType)
+		}
+		if r.WordOrder != "" && r.WordOrder != "big" && r.WordOrder != "little" {
+			return fmt.Errorf("registers[%d]: word_order %q must be big or little", i, r.WordOrder)
+		}
+		if r.Scale == 0 {
+			r.Scale = 1
+		}
+		switch strings.ToUpper(r.Unit) {
+		case "", "C", "F":
+		default:
+			return fmt.Errorf("registers[%d]: unit %q must be C or F", i, r.Unit)
+		}
+		if r.ID == "" {
+			r.ID = fmt.Sprintf("modbus_%s_%d_%d", endpoint, r.UnitID, r.Address)
+		}
+		if r.Name == "" {
+			r.Name = r.ID
+		}
+		if r.Type == "" {
+			r.Type = "AMBIENT"
+		}
+	}
+	return nil
+}
+
+// HostConfig locates the host when the client runs in a container, which
+// sees its own filesystem and hostname unless the host's are mounted in
+type HostConfig struct {
+	// Where the host's root filesystem is mounted, e.g. /host; the machine ID
+	// and hostname are read from under it
+	Root string `mapstructure:"root"`
+	// Where the host's /sys is mounted; empty uses <root>/sys
+	SysPath string `mapstructure:"sys_path"`
+	// Kubernetes node, pod and namespace names, set from the downward API in a
+	// DaemonSet. A node name becomes the hostname and, unless client.id is
+	// set, derives a client ID that survives pod restarts.
+	NodeName     string `mapstructure:"node_name"`
+	PodName      string `mapstructure:"pod_name"`
+	PodNamespace string `mapstructure:"pod_namespace"`
+
+	// Report labels from the cloud instance metadata service (instance type,
+	// region, zone): auto, ec2, gce or hetzner; empty reads none
+	Cloud string `mapstructure:"cloud"`
+	// Report labels from /etc/machine-info and DMI (chassis, vendor, model,
+	// deployment, location)
+	MachineInfo bool `mapstructure:"machine_info"`
 }
 
 func Load() (*Config, error) {
@@ -40,11 +411,54 @@ func Load() (*Config, error) {
 	// Set defaults
 	viper.SetDefault("server.address", "localhost:50051")
 	viper.SetDefault("server.timeout", 10*time.Second)
+	viper.SetDefault("server.transport", TransportGRPC)
+	viper.SetDefault("server.keepalive_time", time.Minute)
+	viper.SetDefault("server.keepalive_timeout", 20*time.Second)
+	viper.SetDefault("server.keepalive_permit_without_stream", true)
+	viper.SetDefault("server.max_recv_msg_size", 16*1024*1024)
+	viper.SetDefault("server.max_send_msg_size", 16*1024*1024)
 	viper.SetDefault("client.id", "")
+	viper.SetDefault("client.identity_file", defaultCredentialFile("client_id"))
+	viper.SetDefault("client.enrollment_token", "")
+	viper.SetDefault("client.api_key_file", defaultCredentialFile("api_key"))
 	viper.SetDefault("client.interval", 30*time.Second)
+	viper.SetDefault("client.sample_interval", 0)
+	viper.SetDefault("client.batch_size", 1000)
 	viper.SetDefault("monitoring.cpu", true)
 	viper.SetDefault("monitoring.gpu", true)
 	viper.SetDefault("monitoring.disk", true)
+	viper.SetDefault("monitoring.mock.enabled", false)
+	viper.SetDefault("monitoring.mock.sensors", 6)
+	viper.SetDefault("monitoring.mock.base_celsius", 45.0)
+	viper.SetDefault("monitoring.mock.drift", 5.0)
+	viper.SetDefault("monitoring.mock.noise", 0.5)
+	viper.SetDefault("monitoring.mock.spike_interval", 10*time.Minute)
+	viper.SetDefault("monitoring.mock.spike_duration", 2*time.Minute)
+	viper.SetDefault("monitoring.mock.spike_celsius", 30.0)
+	viper.SetDefault("commands.fan_control", false)
+	viper.SetDefault("commands.poll_interval", 10*time.Second)
+	viper.SetDefault("commands.action_timeout", 5*time.Minute)
+	viper.SetDefault("commands.audit_file", "")
+	viper.SetDefault("commands.burst_capture", false)
+	viper.SetDefault("commands.remote_config", false)
+	viper.SetDefault("commands.event_log", false)
+	viper.SetDefault("host.root", "")
+	viper.SetDefault("host.sys_path", "")
+	viper.SetDefault("host.node_name", "")
+	viper.SetDefault("host.pod_name", "")
+	viper.SetDefault("host.pod_namespace", "")
+	viper.SetDefault("host.cloud", "")
+	viper.SetDefault("host.machine_info", false)
+	viper.SetDefault("gateway.http_listen", "")
+	viper.SetDefault("gateway.http_token", "")
+	viper.SetDefault("gateway.mqtt.broker", "")
+	viper.SetDefault("gateway.mqtt.topic", "jacuzzi/gateway/#")
+	viper.SetDefault("gateway.mqtt.username", "")
+	viper.SetDefault("gateway.mqtt.password", "")
+	viper.SetDefault("gateway.mqtt.client_id", "")
+	viper.SetDefault("gateway.serial_devices", []string{})
+	viper.SetDefault("gateway.baud_rate", 9600)
+	viper.SetDefault("gateway.stale_after", 5*time.Minute)
 
 	// Environment variables
 	viper.SetEnvPrefix("JACUZZI_CLIENT")
@@ -53,11 +467,54 @@ func Load() (*Config, error) {
 	// Bind specific environment variables
 	viper.BindEnv("server.address", "JACUZZI_CLIENT_SERVER_ADDRESS")
 	viper.BindEnv("server.timeout", "JACUZZI_CLIENT_SERVER_TIMEOUT")
+	viper.BindEnv("server.transport", "JACUZZI_CLIENT_SERVER_TRANSPORT")
+	viper.BindEnv("server.keepalive_time", "JACUZZI_CLIENT_SERVER_KEEPALIVE_TIME")
+	viper.BindEnv("server.keepalive_timeout", "JACUZZI_CLIENT_SERVER_KEEPALIVE_TIMEOUT")
+	viper.BindEnv("server.keepalive_permit_without_stream", "JACUZZI_CLIENT_SERVER_KEEPALIVE_PERMIT_WITHOUT_STREAM")
+	viper.BindEnv("server.max_recv_msg_size", "JACUZZI_CLIENT_SERVER_MAX_RECV_MSG_SIZE")
+	viper.BindEnv("server.max_send_msg_size", "JACUZZI_CLIENT_SERVER_MAX_SEND_MSG_SIZE")
 	viper.BindEnv("client.id", "JACUZZI_CLIENT_ID")
+	viper.BindEnv("client.identity_file", "JACUZZI_CLIENT_IDENTITY_FILE")
+	viper.BindEnv("client.enrollment_token", "JACUZZI_CLIENT_ENROLLMENT_TOKEN")
+	viper.BindEnv("client.api_key_file", "JACUZZI_CLIENT_API_KEY_FILE")
 	viper.BindEnv("client.interval", "JACUZZI_CLIENT_INTERVAL")
+	viper.BindEnv("client.sample_interval", "JACUZZI_CLIENT_SAMPLE_INTERVAL")
+	viper.BindEnv("client.batch_size", "JACUZZI_CLIENT_BATCH_SIZE")
 	viper.BindEnv("monitoring.cpu", "JACUZZI_CLIENT_MONITORING_CPU")
 	viper.BindEnv("monitoring.gpu", "JACUZZI_CLIENT_MONITORING_GPU")
 	viper.BindEnv("monitoring.disk", "JACUZZI_CLIENT_MONITORING_DISK")
+	viper.BindEnv("monitoring.mock.enabled", "JACUZZI_CLIENT_MONITORING_MOCK_ENABLED")
+	viper.BindEnv("monitoring.mock.sensors", "JACUZZI_CLIENT_MONITORING_MOCK_SENSORS")
+	viper.BindEnv("monitoring.mock.base_celsius", "JACUZZI_CLIENT_MONITORING_MOCK_BASE_CELSIUS")
+	viper.BindEnv("monitoring.mock.drift", "JACUZZI_CLIENT_MONITORING_MOCK_DRIFT")
+	viper.BindEnv("monitoring.mock.noise", "JACUZZI_CLIENT_MONITORING_MOCK_NOISE")
+	viper.BindEnv("monitoring.mock.spike_interval", "JACUZZI_CLIENT_MONITORING_MOCK_SPIKE_INTERVAL")
+	viper.BindEnv("monitoring.mock.spike_duration", "JACUZZI_CLIENT_MONITORING_MOCK_SPIKE_DURATION")
+	viper.BindEnv("monitoring.mock.spike_celsius", "JACUZZI_CLIENT_MONITORING_MOCK_SPIKE_CELSIUS")
+	viper.BindEnv("commands.fan_control", "JACUZZI_CLIENT_COMMANDS_FAN_CONTROL")
+	viper.BindEnv("commands.poll_interval", "JACUZZI_CLIENT_COMMANDS_POLL_INTERVAL")
+	viper.BindEnv("commands.action_timeout", "JACUZZI_CLIENT_COMMANDS_ACTION_TIMEOUT")
+	viper.BindEnv("commands.audit_file", "JACUZZI_CLIENT_COMMANDS_AUDIT_FILE")
+	viper.BindEnv("commands.burst_capture", "JACUZZI_CLIENT_COMMANDS_BURST_CAPTURE")
+	viper.BindEnv("commands.remote_config", "JACUZZI_CLIENT_COMMANDS_REMOTE_CONFIG")
+	viper.BindEnv("commands.event_log", "JACUZZI_CLIENT_COMMANDS_EVENT_LOG")
+	viper.BindEnv("host.root", "JACUZZI_CLIENT_HOST_ROOT")
+	viper.BindEnv("host.sys_path", "JACUZZI_CLIENT_HOST_SYS_PATH")
+	viper.BindEnv("host.node_name", "JACUZZI_CLIENT_HOST_NODE_NAME")
+	viper.BindEnv("host.pod_name", "JACUZZI_CLIENT_HOST_POD_NAME")
+	viper.BindEnv("host.pod_namespace", "JACUZZI_CLIENT_HOST_POD_NAMESPACE")
+	viper.BindEnv("host.cloud", "JACUZZI_CLIENT_HOST_CLOUD")
+	viper.BindEnv("host.machine_info", "JACUZZI_CLIENT_HOST_MACHINE_INFO")
+	viper.BindEnv("gateway.http_listen", "JACUZZI_CLIENT_GATEWAY_HTTP_LISTEN")
+	viper.BindEnv("gateway.http_token", "JACUZZI_CLIENT_GATEWAY_HTTP_TOKEN")
+	viper.BindEnv("gateway.mqtt.broker", "JACUZZI_CLIENT_GATEWAY_MQTT_BROKER")
+	viper.BindEnv("gateway.mqtt.topic", "JACUZZI_CLIENT_GATEWAY_MQTT_TOPIC")
+	viper.BindEnv("gateway.mqtt.username", "JACUZZI_CLIENT_GATEWAY_MQTT_USERNAME")
+	viper.BindEnv("gateway.mqtt.password", "JACUZZI_CLIENT_GATEWAY_MQTT_PASSWORD")
+	viper.BindEnv("gateway.mqtt.client_id", "JACUZZI_CLIENT_GATEWAY_MQTT_CLIENT_ID")
+	viper.BindEnv("gateway.serial_devices", "JACUZZI_CLIENT_GATEWAY_SERIAL_DEVICES")
+	viper.BindEnv("gateway.baud_rate", "JACUZZI_CLIENT_GATEWAY_BAUD_RATE")
+	viper.BindEnv("gateway.stale_after", "JACUZZI_CLIENT_GATEWAY_STALE_AFTER")
 
 	// Try to read config file
 	if err := viper.ReadInConfig(); err != nil {
@@ -71,6 +528,102 @@ func Load() (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
+	if config.Client.IdentityFile == "" {
+		config.Client.IdentityFile = defaultCredentialFile("client_id")
+	}
+	if config.Client.APIKeyFile == "" {
+		config.Client.APIKeyFile = defaultCredentialFile("api_key")
+	}
+	switch config.Server.Transport {
+	case TransportGRPC:
+	case TransportHTTP:
+		if config.Server.Address == AutoAddress {
+			return nil, fmt.Errorf("invalid server.address %q: finding the server over mDNS needs the grpc transport", AutoAddress)
+		}
+	default:
+		return nil, fmt.Errorf("invalid server.transport %q: must be %s or %s", config.Server.Transport, TransportGRPC, TransportHTTP)
+	}
+	if config.Client.SampleInterval < 0 || config.Client.SampleInterval > 0 && config.Client.SampleInterval >= config.Client.Interval {
+		return nil, fmt.Errorf("invalid client.sample_interval %s: must be shorter than client.interval %s", config.Client.SampleInterval, config.Client.Interval)
+	}
+	if config.Gateway.Enabled() && config.Gateway.StaleAfter <= 0 {
+		return nil, fmt.Errorf("invalid gateway.stale_after %s: must be positive", config.Gateway.StaleAfter)
+	}
+	if config.Commands.PollInterval <= 0 {
+		return nil, fmt.Errorf("invalid commands.poll_interval %s: must be positive", config.Commands.PollInterval)
+	}
+	if config.Commands.ActionTimeout <= 0 {
+		return nil, fmt.Errorf("invalid commands.action_timeout %s: must be positive", config.Commands.ActionTimeout)
+	}
+	if mock := config.Monitoring.Mock; mock.Enabled {
+		if mock.Sensors < 1 {
+			return nil, fmt.Errorf("invalid monitoring.mock.sensors %d: must be at least 1", mock.Sensors)
+		}
+		if mock.Noise < 0 || mock.Drift < 0 {
+			return nil, fmt.Errorf("invalid monitoring.mock noise %g or drift %g: must not be negative", mock.Noise, mock.Drift)
+		}
+		if mock.SpikeInterval > 0 && mock.SpikeDuration > mock.SpikeInterval {
+			return nil, fmt.Errorf("invalid monitoring.mock.spike_duration %s: must not exceed spike_interval %s", mock.SpikeDuration, mock.SpikeInterval)
+		}
+	}
+	ids := make(map[string]bool)
+	for i := range config.Monitoring.Probes {
+		probe := &config.Monitoring.Probes[i]
+		if err := probe.validate(); err != nil {
+			return nil, fmt.Errorf("invalid monitoring.probes[%d]: %w", i, err)
+		}
+		if ids[probe.ID] {
+			return nil, fmt.Errorf("invalid monitoring.probes[%d]: id %q is used by another probe", i, probe.ID)
+		}
+		ids[probe.ID] = true
+	}
+	for i := range config.Monitoring.Modbus {
+		device := &config.Monitoring.Modbus[i]
+		if err := device.validate(); err != nil {
+			return nil, fmt.Errorf("invalid monitoring.modbus[%d]: %w", i, err)
+		}
+		for _, r := range device.Registers {
+			if ids[r.ID] {
+				return nil, fmt.Errorf("invalid monitoring.modbus[%d]: sensor id %q is used by another sensor", i, r.ID)
+			}
+			ids[r.ID] = true
+		}
+	}
+	for name, path := range map[string]string{"host.root": config.Host.Root, "host.sys_path": config.Host.SysPath} {
+		if path != "" && !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("invalid %s %q: must be an absolute path", name, path)
+		}
+	}
+	switch config.Host.Cloud {
+	case "", "auto", "ec2", "gce", "hetzner":
+	default:
+		return nil, fmt.Errorf("invalid host.cloud %q: must be auto, ec2, gce or hetzner", config.Host.Cloud)
+	}
+	for sensorType, celsius := range config.Monitoring.Thresholds {
+		if celsius <= 0 {
+			return nil, fmt.Errorf("invalid monitoring.thresholds.%s %g: must be positive", sensorType, celsius)
+		}
+	}
+	for name, command := range config.Commands.LocalActions {
+		if command == "" {
+			return nil, fmt.Errorf("invalid commands.local_actions.%s: command is empty", name)
+		}
+	}
+	if config.Server.MaxRecvMsgSize <= 0 {
+		return nil, fmt.Errorf("invalid server.max_recv_msg_size %d: must be positive", config.Server.MaxRecvMsgSize)
+	}
+	if config.Server.MaxSendMsgSize <= 0 {
+		return nil, fmt.Errorf("invalid server.max_send_msg_size %d: must be positive", config.Server.MaxSendMsgSize)
+	}
 
 	return &config, nil
 }
+
+// defaultCredentialFile places client credentials next to the user config
+func defaultCredentialFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".jacuzzi", name)
+	}
+	return filepath.Join(home, ".jacuzzi", name)
+}
@@ -0,0 +1,153 @@
+// Package gateway lets an agent aggregate readings from other devices on its
+// network, such as a Raspberry Pi collecting a room full of probes. Devices
+// send readings over HTTP, MQTT or serial lines; the agent reports them with
+// its own sensors, under sensor IDs prefixed with the ID of the device.
+//
+// Every input takes the same JSON, one reading or several:
+//
+//	{"device": "probe-3", "sensor": "water", "celsius": 38.5}
+//	{"device": "probe-3", "readings": [{"sensor": "water", "celsius": 38.5, "type": "water", "name": "Tub water"}]}
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	climon "github.com/nickheyer/jacuzzi/pkg/client/monitor"
+)
+
+// maxSensors bounds the sub-device sensors kept, so a misbehaving device
+// cannot exhaust memory
+const maxSensors = 1000
+
+// Collector keeps the latest reading of every sub-device sensor and reports
+// those that are fresh as a monitor.Source
+type Collector struct {
+	staleAfter time.Duration
+
+	mu       sync.Mutex
+	readings map[string]entry // Keyed by sensor ID
+}
+
+type entry struct {
+	sensor   climon.TemperatureSensor
+	received time.Time
+}
+
+// Reading is a reading from a sub-device
+type Reading struct {
+	Sensor  string   `json:"sensor"`
+	Celsius *float64 `json:"celsius"`
+	Type    string   `json:"type,omitempty"`
+	Name    string   `json:"name,omitempty"`
+}
+
+// payload is the JSON every input takes
+type payload struct {
+	Device   string    `json:"device"`
+	Readings []Reading `json:"readings"`
+	Reading
+}
+
+var _ climon.Source = (*Collector)(nil)
+
+// NewCollector returns a collector that stops reporting a sensor once it has
+// sent nothing for staleAfter
+func NewCollector(staleAfter time.Duration) *Collector {
+	return &Collector{staleAfter: staleAfter, readings: make(map[string]entry)}
+}
+
+// GetTemperatures returns the latest reading of every sensor heard from
+// within the stale age, in sensor ID order
+func (c *Collector) GetTemperatures() ([]climon.TemperatureSensor, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	sensors := make([]climon.TemperatureSensor, 0, len(c.readings))
+	for id, e := range c.readings {
+		if now.Sub(e.received) > c.staleAfter {
+			delete(c.readings, id)
+			continue
+		}
+		sensors = append(sensors, e.sensor)
+	}
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].ID < sensors[j].ID })
+	return sensors, nil
+}
+
+// Add records readings from a device
+func (c *Collector) Add(device string, readings []Reading) error {
+	if err := validID("device", device); err != nil {
+		return err
+	}
+	sensors := make([]climon.TemperatureSensor, 0, len(readings))
+	for i, r := range readings {
+		if err := validID("sensor", r.Sensor); err != nil {
+			return fmt.Errorf("reading %d: %w", i+1, err)
+		}
+		if r.Celsius == nil || math.IsNaN(*r.Celsius) || math.IsInf(*r.Celsius, 0) {
+			return fmt.Errorf("reading %d: missing or invalid celsius", i+1)
+		}
+		name := r.Name
+		if name == "" {
+			name = r.Sensor
+		}
+		sensors = append(sensors, climon.TemperatureSensor{
+			ID:         device + "/" + r.Sensor,
+			Type:       r.Type,
+			Name:       device + " " + name,
+			TempMilliC: int64(math.Round(*r.Celsius * 1000)),
+		})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, sensor := range sensors {
+		if _, ok := c.readings[sensor.ID]; !ok && len(c.readings) >= maxSensors {
+			return fmt.Errorf("too many sensors; at most %d are kept", maxSensors)
+		}
+		c.readings[sensor.ID] = entry{sensor: sensor, received: now}
+	}
+	return nil
+}
+
+// AddJSON records the readings in a JSON payload. device is used when the
+// payload names none.
+func (c *Collector) AddJSON(data []byte, device string) error {
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if p.Device != "" {
+		device = p.Device
+	}
+	readings := p.Readings
+	if p.Sensor != "" || p.Celsius != nil {
+		readings = append(readings, p.Reading)
+	}
+	if len(readings) == 0 {
+		return errors.New("no readings provided")
+	}
+	return c.Add(device, readings)
+}
+
+// validID checks a device or sensor ID, which must be non-empty and may not
+// contain the separator of the combined sensor ID
+func validID(kind, id string) error {
+	switch {
+	case id == "":
+		return fmt.Errorf("missing %s", kind)
+	case len(id) > 64:
+		return fmt.Errorf("%s %q is longer than 64 characters", kind, id)
+	case strings.ContainsAny(id, "/ \t\r\n"):
+		return fmt.Errorf("%s %q may not contain slashes or whitespace", kind, id)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Path is where devices POST readings to the HTTP input
+const Path = "/readings"
+
+// maxBodySize bounds the JSON a device may POST
+const maxBodySize = 64 * 1024
+
+// Handler returns the HTTP input, which takes readings POSTed to Path. A
+// device may be named by the device query parameter instead of the payload.
+// When token is set, devices must send it as a bearer token.
+func (c *Collector) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+Path, func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			sent, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				http.Error(w, "missing or invalid gateway token", http.StatusUnauthorized)
+				return
+			}
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read body: %v", err), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := c.AddJSON(data, r.URL.Query().Get("device")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// ServeHTTP runs the HTTP input on address until ctx is done, requiring token
+// when it is set
+func (c *Collector) ServeHTTP(ctx context.Context, address, token string) error {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gateway readings: %w", err)
+	}
+	server := &http.Server{
+		Handler:      c.Handler(token),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Accepting gateway readings over HTTP at http://%s%s", lis.Addr(), Path)
+	if token == "" {
+		log.Printf("Warning: the gateway HTTP input takes readings from anyone who can reach %s; set gateway.http_token or listen on localhost or a trusted LAN", lis.Addr())
+	}
+	if err := server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("gateway HTTP input failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		auth   string
+		path   string
+		body   string
+		status int
+		sensor string
+	}{
+		{"no token configured", "", "", Path, `{"device": "probe-1", "sensor": "water", "celsius": 38}`, http.StatusNoContent, "probe-1/water"},
+		{"device query parameter", "", "", Path + "?device=probe-2", `{"sensor": "air", "celsius": 20}`, http.StatusNoContent, "probe-2/air"},
+		{"token sent", "s3cret", "Bearer s3cret", Path, `{"device": "probe-1", "sensor": "water", "celsius": 38}`, http.StatusNoContent, "probe-1/water"},
+		{"token missing", "s3cret", "", Path, `{"device": "probe-1", "sensor": "water", "celsius": 38}`, http.StatusUnauthorized, ""},
+		{"token wrong", "s3cret", "Bearer guess", Path, `{"device": "probe-1", "sensor": "water", "celsius": 38}`, http.StatusUnauthorized, ""},
+		{"token not bearer", "s3cret", "s3cret", Path, `{"device": "probe-1", "sensor": "water", "celsius": 38}`, http.StatusUnauthorized, ""},
+		{"invalid payload", "", "", Path, `{"device": "probe-1"}`, http.StatusBadRequest, ""},
+		{"body too large", "", "", Path, strings.Repeat(" ", maxBodySize+1), http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollector(time.Minute)
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			c.Handler(tt.token).ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			sensors, _ := c.GetTemperatures()
+			if tt.sensor == "" {
+				if len(sensors) != 0 {
+					t.Errorf("recorded %+v, want nothing", sensors)
+				}
+			} else if len(sensors) != 1 || sensors[0].ID != tt.sensor {
+				t.Errorf("got sensors %+v, want %s", sensors, tt.sensor)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mqttKeepalive   = 60 * time.Second
+	mqttDialTimeout = 10 * time.Second
+	// mqttMaxPacket bounds the packets read from the broker
+	mqttMaxPacket = maxBodySize + 1024
+)
+
+// MQTT control packet types, shifted into the high nibble of the first byte
+const (
+	mqttConnect     = 1 << 4
+	mqttConnack     = 2 << 4
+	mqttPublish     = 3 << 4
+	mqttPuback      = 4 << 4
+	mqttSubscribe   = 8<<4 | 2 // Subscribe requires flags 0010
+	mqttSuback      = 9 << 4
+	mqttPingreq     = 12 << 4
+	mqttDisconnect  = 14 << 4
+	mqttSubscribeID = 1
+)
+
+// MQTTConfig configures the MQTT input
+type MQTTConfig struct {
+	Broker   string // tcp://host:1883, or tls://host:8883
+	Topic    string // Topic filter to subscribe to, such as sensors/#
+	Username string
+	Password string
+	ClientID string
+}
+
+// ReadMQTT subscribes to readings published to an MQTT broker until ctx is
+// done, reconnecting when the connection fails. Messages are JSON payloads,
+// recorded under the last topic level when they name no device, or bare
+// numbers in °C published to <...>/<device>/<sensor>.
+func (c *Collector) ReadMQTT(ctx context.Context, cfg MQTTConfig) {
+	for {
+		if err := c.readMQTT(ctx, cfg); err != nil && ctx.Err() == nil {
+			log.Printf("Gateway MQTT input failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reopenDelay):
+		}
+	}
+}
+
+// mqttConn is a connection to an MQTT 3.1.1 broker, subscribed at QoS 0
+type mqttConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu sync.Mutex // Serializes writes
+}
+
+func (c *Collector) readMQTT(ctx context.Context, cfg MQTTConfig) error {
+	conn, err := dialMQTT(ctx, cfg.Broker)
+	if err != nil {
+		return err
+	}
+	m := &mqttConn{conn: conn, r: bufio.NewReader(conn)}
+	stop := context.AfterFunc(ctx, func() {
+		m.write(mqttDisconnect, nil)
+		conn.Close()
+	})
+	defer stop()
+	defer conn.Close()
+
+	if err := m.connect(cfg); err != nil {
+		return err
+	}
+	if err := m.subscribe(cfg.Topic); err != nil {
+		return err
+	}
+	log.Printf("Subscribed to gateway readings on %s at %s", cfg.Topic, cfg.Broker)
+
+	// Ping the broker so it keeps the connection open, and so a dead broker
+	// is noticed when the pings go unanswered
+	pingCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(mqttKeepalive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pingCtx.Done():
+				return
+			case <-ticker.C:
+				m.write(mqttPingreq, nil)
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(mqttKeepalive))
+		header, body, err := m.read()
+		if err != nil {
+			return err
+		}
+		if header&0xf0 != mqttPublish {
+			continue
+		}
+		topic, payload, err := m.publish(header, body)
+		if err != nil {
+			return err
+		}
+		if err := c.addMQTT(topic, payload); err != nil {
+			log.Printf("Ignoring message on %s: %v", topic, err)
+		}
+	}
+}
+
+// addMQTT records a message published to topic
+func (c *Collector) addMQTT(topic string, payload []byte) error {
+	levels := strings.Split(topic, "/")
+	text := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(text, "{") {
+		return c.AddJSON(payload, levels[len(levels)-1])
+	}
+	celsius, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return errors.New("payload is neither JSON nor a number")
+	}
+	if len(levels) < 2 {
+		return errors.New("numeric payloads need a topic ending in <device>/<sensor>")
+	}
+	return c.Add(levels[len(levels)-2], []Reading{{Sensor: levels[len(levels)-1], Celsius: &celsius}})
+}
+
+func dialMQTT(ctx context.Context, broker string) (net.Conn, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker %q: %w", broker, err)
+	}
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		return dialer.DialContext(ctx, "tcp", hostPort(u, "1883"))
+	case "tls", "ssl", "mqtts":
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname()}}
+		return tlsDialer.DialContext(ctx, "tcp", hostPort(u, "8883"))
+	}
+	return nil, fmt.Errorf("invalid MQTT broker %q: scheme must be tcp or tls", broker)
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func (m *mqttConn) connect(cfg MQTTConfig) error {
+	flags := byte(0x02) // Clean session
+	body := appendString(nil, "MQTT")
+	body = append(body, 4) // Protocol level 3.1.1
+	flagsAt := len(body)
+	body = append(body, 0)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepalive/time.Second))
+	body = appendString(body, cfg.ClientID)
+	if cfg.Username != "" {
+		flags |= 0x80
+		body = appendString(body, cfg.Username)
+		if cfg.Password != "" {
+			flags |= 0x40
+			body = appendString(body, cfg.Password)
+		}
+	}
+	body[flagsAt] = flags
+	if err := m.write(mqttConnect, body); err != nil {
+		return err
+	}
+
+	m.conn.SetReadDeadline(time.Now().Add(mqttDialTimeout))
+	header, reply, err := m.read()
+	if err != nil {
+		return err
+	}
+	if header != mqttConnack || len(reply) != 2 {
+		return errors.New("broker did not acknowledge the connection")
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("broker refused the connection: %s", connackReason(reply[1]))
+	}
+	return nil
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client ID rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+func (m *mqttConn) subscribe(topic string) error {
+	body := binary.BigEndian.AppendUint16(nil, mqttSubscribeID)
+	body = appendString(body, topic)
+	body = append(body, 0) // QoS 0
+	if err := m.write(mqttSubscribe, body); err != nil {
+		return err
+	}
+	for {
+		header, reply, err := m.read()
+		if err != nil {
+			return err
+		}
+		if header != mqttSuback {
+			continue
+		}
+		if len(reply) != 3 || reply[2] == 0x80 {
+			return fmt.Errorf("broker refused the subscription to %s", topic)
+		}
+		return nil
+	}
+}
+
+// publish decodes a PUBLISH packet, acknowledging it if the broker sent it
+// at QoS 1
+func (m *mqttConn) publish(header byte, body []byte) (string, []byte, error) {
+	if len(body) < 2 {
+		return "", nil, errors.New("malformed PUBLISH packet")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return "", nil, errors.New("malformed PUBLISH packet")
+	}
+	topic, rest := string(body[2:2+n]), body[2+n:]
+	if qos := header >> 1 & 0x3; qos > 0 {
+		if len(rest) < 2 {
+			return "", nil, errors.New("malformed PUBLISH packet")
+		}
+		if qos == 1 {
+			if err := m.write(mqttPuback, rest[:2]); err != nil {
+				return "", nil, err
+			}
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, nil
+}
+
+func (m *mqttConn) write(header byte, body []byte) error {
+	packet := []byte{header}
+	for n := len(body); ; {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conn.SetWriteDeadline(time.Now().Add(mqttDialTimeout))
+	_, err := m.conn.Write(packet)
+	return err
+}
+
+func (m *mqttConn) read() (byte, []byte, error) {
+	header, err := m.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+	}
+	if length > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(m.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
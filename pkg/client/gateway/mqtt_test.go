@@ -0,0 +1,297 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBroker accepts one connection and hands it to handle, returning the
+// broker URL and a channel closed once handle returns
+func fakeBroker(t *testing.T, handle func(m *mqttConn)) (string, <-chan struct{}) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := lis.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		handle(&mqttConn{conn: conn, r: bufio.NewReader(conn)})
+	}()
+	return "tcp://" + lis.Addr().String(), done
+}
+
+// expectPacket reads a packet on the broker side and checks it
+func expectPacket(t *testing.T, m *mqttConn, header byte, body []byte) bool {
+	t.Helper()
+	gotHeader, gotBody, err := m.read()
+	if err != nil {
+		t.Errorf("broker read: %v", err)
+		return false
+	}
+	if gotHeader != header || !bytes.Equal(gotBody, body) {
+		t.Errorf("broker got packet %#x % x, want %#x % x", gotHeader, gotBody, header, body)
+		return false
+	}
+	return true
+}
+
+func publishPacket(topic string, qos byte, packetID uint16, payload string) (byte, []byte) {
+	body := appendString(nil, topic)
+	if qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, packetID)
+	}
+	return mqttPublish | qos<<1, append(body, payload...)
+}
+
+func TestReadMQTT(t *testing.T) {
+	cfg := MQTTConfig{
+		Topic:    "jacuzzi/gateway/#",
+		Username: "user",
+		Password: "secret",
+		ClientID: "jacuzzi-gateway-test",
+	}
+	acked := make(chan struct{})
+	broker, done := fakeBroker(t, func(m *mqttConn) {
+		connect := appendString(nil, "MQTT")
+		connect = append(connect, 4, 0xc2, 0, 60)
+		connect = appendString(connect, cfg.ClientID)
+		connect = appendString(connect, cfg.Username)
+		connect = appendString(connect, cfg.Password)
+		if !expectPacket(t, m, mqttConnect, connect) {
+			return
+		}
+		m.write(mqttConnack, []byte{0, 0})
+
+		subscribe := appendString([]byte{0, mqttSubscribeID}, cfg.Topic)
+		if !expectPacket(t, m, mqttSubscribe, append(subscribe, 0)) {
+			return
+		}
+		m.write(mqttSuback, []byte{0, mqttSubscribeID, 0})
+
+		m.write(publishPacket("jacuzzi/gateway/probe-3", 0, 0, `{"sensor": "water", "celsius": 38.5}`))
+		m.write(publishPacket("jacuzzi/gateway/probe-4/air", 1, 7, "21.25"))
+		if !expectPacket(t, m, mqttPuback, []byte{0, 7}) {
+			return
+		}
+		close(acked)
+		expectPacket(t, m, mqttDisconnect, []byte{})
+	})
+	cfg.Broker = broker
+
+	c := NewCollector(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- c.readMQTT(ctx, cfg) }()
+
+	select {
+	case <-acked:
+	case <-done:
+		t.Fatal("broker stopped before the QoS 1 message was acknowledged")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the QoS 1 message to be acknowledged")
+	}
+	var sensors []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		got, _ := c.GetTemperatures()
+		sensors = sensors[:0]
+		for _, s := range got {
+			sensors = append(sensors, fmt.Sprintf("%s=%d", s.ID, s.TempMilliC))
+		}
+		if len(sensors) == 2 {
+			break
+		}
+	}
+	if want := []string{"probe-3/water=38500", "probe-4/air=21250"}; strings.Join(sensors, ",") != strings.Join(want, ",") {
+		t.Errorf("got sensors %v, want %v", sensors, want)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the client to disconnect")
+	}
+	<-result
+}
+
+func TestReadMQTTRefused(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func(m *mqttConn)
+		err   string
+	}{
+		{"bad credentials", func(m *mqttConn) {
+			m.read()
+			m.write(mqttConnack, []byte{0, 4})
+		}, "broker refused the connection: bad username or password"},
+		{"not a connack", func(m *mqttConn) {
+			m.read()
+			m.write(mqttSuback, []byte{0, 1, 0})
+		}, "broker did not acknowledge the connection"},
+		{"subscription refused", func(m *mqttConn) {
+			m.read()
+			m.write(mqttConnack, []byte{0, 0})
+			m.read()
+			m.write(mqttSuback, []byte{0, mqttSubscribeID, 0x80})
+		}, "broker refused the subscription to sensors/#"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker, done := fakeBroker(t, tt.reply)
+			err := NewCollector(time.Minute).readMQTT(context.Background(), MQTTConfig{Broker: broker, Topic: "sensors/#", ClientID: "test"})
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			<-done
+		})
+	}
+}
+
+func TestMQTTPacketFraming(t *testing.T) {
+	tests := []struct {
+		length int
+		prefix []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{mqttMaxPacket, nil},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		body := bytes.Repeat([]byte{0xab}, tt.length)
+		go func() {
+			(&mqttConn{conn: client}).write(mqttPublish, body)
+			client.Close()
+		}()
+		raw := new(bytes.Buffer)
+		raw.ReadFrom(server)
+		server.Close()
+
+		if raw.Len() == 0 || raw.Bytes()[0] != mqttPublish {
+			t.Fatalf("length %d: missing header in % x", tt.length, raw.Bytes()[:min(raw.Len(), 8)])
+		}
+		if tt.prefix != nil && !bytes.HasPrefix(raw.Bytes()[1:], tt.prefix) {
+			t.Errorf("length %d: encoded as % x, want % x", tt.length, raw.Bytes()[1:1+len(tt.prefix)], tt.prefix)
+		}
+		m := &mqttConn{r: bufio.NewReader(raw)}
+		header, got, err := m.read()
+		if err != nil {
+			t.Fatalf("length %d: read: %v", tt.length, err)
+		}
+		if header != mqttPublish || !bytes.Equal(got, body) {
+			t.Errorf("length %d: read back %#x with %d bytes", tt.length, header, len(got))
+		}
+	}
+}
+
+func TestMQTTReadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		err  string
+	}{
+		{"length too long", []byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}, "malformed packet length"},
+		{"packet too large", []byte{0x30, 0x80, 0x80, 0x10}, "packet of 262144 bytes is too large"},
+		{"truncated body", []byte{0x30, 0x05, 0x00}, "unexpected EOF"},
+		{"truncated length", []byte{0x30, 0x80}, "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mqttConn{r: bufio.NewReader(bytes.NewReader(tt.raw))}
+			_, _, err := m.read()
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	tests := []struct {
+		name    string
+		qos     byte
+		body    []byte
+		topic   string
+		payload string
+		err     string
+	}{
+		{"qos 0", 0, append(appendString(nil, "a/b"), "1.5"...), "a/b", "1.5", ""},
+		{"qos 2", 2, append(appendString(nil, "a/b"), 0, 9, '2'), "a/b", "2", ""},
+		{"empty payload", 0, appendString(nil, "a"), "a", "", ""},
+		{"no topic length", 0, []byte{0}, "", "", "malformed PUBLISH packet"},
+		{"topic past the end", 0, []byte{0, 5, 'a'}, "", "", "malformed PUBLISH packet"},
+		{"no packet ID", 1, appendString(nil, "a"), "", "", "malformed PUBLISH packet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, payload, err := (&mqttConn{}).publish(mqttPublish|tt.qos<<1, tt.body)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			if topic != tt.topic || string(payload) != tt.payload {
+				t.Errorf("got %q %q, want %q %q", topic, payload, tt.topic, tt.payload)
+			}
+		})
+	}
+}
+
+func TestAddMQTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		sensor  string
+		err     string
+	}{
+		{"json named by topic", "sensors/probe-1", `{"sensor": "water", "celsius": 38}`, "probe-1/water", ""},
+		{"json naming its device", "sensors/x", `{"device": "probe-2", "sensor": "air", "celsius": 20}`, "probe-2/air", ""},
+		{"number", "sensors/probe-3/water", " 37.5\n", "probe-3/water", ""},
+		{"number without device level", "water", "37.5", "", "numeric payloads need a topic ending in <device>/<sensor>"},
+		{"neither", "sensors/probe-3/water", "hot", "", "payload is neither JSON nor a number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollector(time.Minute)
+			err := c.addMQTT(tt.topic, []byte(tt.payload))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("addMQTT: %v", err)
+			}
+			sensors, _ := c.GetTemperatures()
+			if len(sensors) != 1 || sensors[0].ID != tt.sensor {
+				t.Errorf("got sensors %+v, want %s", sensors, tt.sensor)
+			}
+		})
+	}
+}
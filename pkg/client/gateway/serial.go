@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/serial"
+)
+
+// reopenDelay is how long the serial input waits to reopen a device that
+// failed or was unplugged
+const reopenDelay = 10 * time.Second
+
+// ReadSerial reads readings from a serial device until ctx is done, one JSON
+// payload per line, reopening it when it fails. Payloads that name no device
+// are recorded under the device's file name, such as ttyUSB0.
+func (c *Collector) ReadSerial(ctx context.Context, path string, baud int) {
+	device := filepath.Base(path)
+	for {
+		if err := c.readSerial(ctx, path, baud, device); err != nil && ctx.Err() == nil {
+			log.Printf("Gateway serial input %s failed: %v", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reopenDelay):
+		}
+	}
+}
+
+func (c *Collector) readSerial(ctx context.Context, path string, baud int, device string) error {
+	f, err := serial.Open(path, baud)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+	defer f.Close()
+
+	log.Printf("Reading gateway readings from %s at %d baud", path, baud)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 4096), maxBodySize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Boards often print banners and debug output between readings
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		if err := c.AddJSON([]byte(line), device); err != nil {
+			log.Printf("Ignoring line from %s: %v", path, err)
+		}
+	}
+	return scanner.Err()
+}
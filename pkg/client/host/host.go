@@ -0,0 +1,160 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// nodeNamespace scopes the client IDs derived from Kubernetes node names
+var nodeNamespace = uuid.MustParse("3f1c7a52-58c4-4a3e-9a57-8f0e6d2b7c11")
+
+// hostInits are PID 1 on a host; a container sharing the host PID namespace
+// sees one of them
+var hostInits = map[string]bool{"systemd": true, "init": true, "launchd": true}
+
+// Config locates the host from inside a container
+type Config struct {
+	Root         string // Where the host filesystem is mounted, e.g. /host; empty is /
+	SysPath      string // Host sysfs; empty is <Root>/sys
+	NodeName     string // Kubernetes node name, from the downward API
+	PodName      string
+	PodNamespace string
+
+	Cloud       string // Instance metadata to read labels from, of CloudProviders; empty for none
+	MachineInfo bool   // Read labels from /etc/machine-info and DMI
+}
+
+// Environment describes where the agent runs
+type Environment struct {
+	cfg Config
+
+	Runtime string // Container runtime, e.g. docker or kubernetes; empty on the host
+	HostPID bool   // Whether the agent sees the host's processes
+
+	labels map[string]string // From ReadLabels
+}
+
+// Detect inspects the agent's surroundings
+func Detect(cfg Config) *Environment {
+	env := &Environment{cfg: cfg, Runtime: containerRuntime()}
+	if env.Runtime == "" {
+		env.HostPID = true
+	} else if comm, err := os.ReadFile("/proc/1/comm"); err == nil {
+		env.HostPID = hostInits[strings.TrimSpace(string(comm))]
+	}
+	return env
+}
+
+// containerRuntime guesses the container runtime from marker files and the
+// process's cgroups, returning an empty string outside containers
+func containerRuntime() string {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	cgroup, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	for _, marker := range []struct{ text, runtime string }{
+		{"kubepods", "kubernetes"},
+		{"docker", "docker"},
+		{"libpod", "podman"},
+		{"containerd", "containerd"},
+		{"lxc", "lxc"},
+	} {
+		if strings.Contains(string(cgroup), marker.text) {
+			return marker.runtime
+		}
+	}
+	return ""
+}
+
+// InContainer reports whether the agent runs in a container
+func (e *Environment) InContainer() bool {
+	return e.Runtime != ""
+}
+
+// Path returns a host path, under the host root when one is mounted
+func (e *Environment) Path(path string) string {
+	if e.cfg.Root == "" {
+		return path
+	}
+	return filepath.Join(e.cfg.Root, path)
+}
+
+// SysPath is the host's sysfs, where hwmon and thermal zones are read
+func (e *Environment) SysPath() string {
+	if e.cfg.SysPath != "" {
+		return e.cfg.SysPath
+	}
+	return e.Path("/sys")
+}
+
+// HasHwmon reports whether any hwmon devices are visible under SysPath
+func (e *Environment) HasHwmon() bool {
+	devices, _ := filepath.Glob(filepath.Join(e.SysPath(), "class", "hwmon", "hwmon*"))
+	return len(devices) > 0
+}
+
+// Hostname is the Kubernetes node name when set, then the host's hostname
+// from the mounted root, then the container's own
+func (e *Environment) Hostname() (string, error) {
+	if e.cfg.NodeName != "" {
+		return e.cfg.NodeName, nil
+	}
+	if e.cfg.Root != "" {
+		if data, err := os.ReadFile(e.Path("/etc/hostname")); err == nil {
+			if name := strings.TrimSpace(string(data)); name != "" {
+				return name, nil
+			}
+		}
+	}
+	return os.Hostname()
+}
+
+// NodeClientID derives a stable client ID from the Kubernetes node name, so
+// DaemonSet pods keep their node's identity across restarts without a
+// persistent volume. Empty when no node name is set.
+func (e *Environment) NodeClientID() string {
+	if e.cfg.NodeName == "" {
+		return ""
+	}
+	return uuid.NewSHA1(nodeNamespace, []byte(e.cfg.NodeName)).String()
+}
+
+// Metadata describes the environment for the server, which merges it into
+// the client's metadata, along with the labels from ReadLabels
+func (e *Environment) Metadata() map[string]string {
+	metadata := make(map[string]string)
+	for key, value := range e.labels {
+		metadata[key] = value
+	}
+	if e.Runtime == "" {
+		return metadata
+	}
+	metadata["container.runtime"] = e.Runtime
+	if e.HostPID {
+		metadata["container.pid_namespace"] = "host"
+	} else {
+		metadata["container.pid_namespace"] = "container"
+	}
+	for key, value := range map[string]string{
+		"k8s.node":      e.cfg.NodeName,
+		"k8s.pod":       e.cfg.PodName,
+		"k8s.namespace": e.cfg.PodNamespace,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,287 @@
+package host
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cloud providers whose instance metadata can be read
+const (
+	CloudAuto    = "auto"
+	CloudEC2     = "ec2"
+	CloudGCE     = "gce"
+	CloudHetzner = "hetzner"
+)
+
+// CloudProviders lists the values Config.Cloud accepts besides empty
+var CloudProviders = []string{CloudAuto, CloudEC2, CloudGCE, CloudHetzner}
+
+// metadataEndpoint is the link-local instance metadata service all three
+// providers serve
+var metadataEndpoint = "http://169.254.169.254"
+
+// metadataTimeout bounds each metadata request, so a machine outside any
+// cloud does not hold up startup
+const metadataTimeout = 2 * time.Second
+
+// machineInfoKeys maps /etc/machine-info fields to metadata keys
+var machineInfoKeys = map[string]string{
+	"PRETTY_HOSTNAME": "machine.pretty_hostname",
+	"CHASSIS":         "machine.chassis",
+	"DEPLOYMENT":      "machine.deployment",
+	"LOCATION":        "machine.location",
+	"HARDWARE_VENDOR": "machine.vendor",
+	"HARDWARE_MODEL":  "machine.model",
+}
+
+// chassisTypes maps SMBIOS chassis type codes to the names machine-info uses,
+// as hostnamectl does
+var chassisTypes = map[string]string{
+	"3": "desktop", "4": "desktop", "6": "desktop", "7": "desktop", "13": "desktop", "35": "desktop", "36": "desktop",
+	"8": "laptop", "9": "laptop", "10": "laptop", "14": "laptop",
+	"11": "handset",
+	"17": "server", "23": "server", "28": "server", "29": "server",
+	"30": "tablet",
+	"31": "convertible", "32": "convertible",
+	"33": "embedded", "34": "embedded",
+}
+
+// ReadLabels looks up the labels enabled in Config, from the cloud instance
+// metadata service and /etc/machine-info with DMI as a fallback, and keeps
+// them for Metadata. Lookups that fail are logged and skipped.
+func (e *Environment) ReadLabels(ctx context.Context) map[string]string {
+	labels := make(map[string]string)
+	if e.cfg.MachineInfo {
+		for key, value := range e.machineInfo() {
+			labels[key] = value
+		}
+	}
+	if e.cfg.Cloud != "" {
+		cloud, err := e.cloudLabels(ctx)
+		if err != nil {
+			log.Printf("Failed to read cloud instance metadata: %v", err)
+		}
+		for key, value := range cloud {
+			labels[key] = value
+		}
+	}
+	e.labels = labels
+	return labels
+}
+
+// machineInfo reads /etc/machine-info, filling the chassis, vendor and model
+// from DMI when it does not set them
+func (e *Environment) machineInfo() map[string]string {
+	labels := make(map[string]string)
+	if file, err := os.Open(e.Path("/etc/machine-info")); err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			name, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if !ok || strings.HasPrefix(name, "#") {
+				continue
+			}
+			if key, known := machineInfoKeys[name]; known {
+				if value = strings.Trim(value, `"'`); value != "" {
+					labels[key] = value
+				}
+			}
+		}
+	}
+
+	if _, ok := labels["machine.chassis"]; !ok {
+		if chassis := chassisTypes[e.dmi("chassis_type")]; chassis != "" {
+			labels["machine.chassis"] = chassis
+		}
+	}
+	for key, file := range map[string]string{"machine.vendor": "sys_vendor", "machine.model": "product_name"} {
+		if _, ok := labels[key]; !ok {
+			if value := e.dmi(file); value != "" {
+				labels[key] = value
+			}
+		}
+	}
+	return labels
+}
+
+// dmi reads a file of /sys/class/dmi/id, empty when unreadable
+func (e *Environment) dmi(name string) string {
+	data, err := os.ReadFile(filepath.Join(e.SysPath(), "class", "dmi", "id", name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// cloudLabels reads the instance metadata of the configured provider. With
+// auto the provider is guessed from DMI, or each is tried in turn when DMI
+// is not readable.
+func (e *Environment) cloudLabels(ctx context.Context) (map[string]string, error) {
+	providers := []string{e.cfg.Cloud}
+	if e.cfg.Cloud == CloudAuto {
+		providers = e.guessCloud()
+		if providers == nil {
+			return nil, nil
+		}
+	}
+
+	var lastErr error
+	for _, provider := range providers {
+		var labels map[string]string
+		var err error
+		switch provider {
+		case CloudEC2:
+			labels, err = ec2Labels(ctx)
+		case CloudGCE:
+			labels, err = gceLabels(ctx)
+		case CloudHetzner:
+			labels, err = hetznerLabels(ctx)
+		default:
+			return nil, fmt.Errorf("unknown cloud provider %q", provider)
+		}
+		if err == nil {
+			labels["cloud.provider"] = provider
+			return labels, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", provider, err)
+		// The providers share an endpoint, so one that cannot be reached
+		// answers for all of them
+		var statusErr *statusError
+		if !errors.As(err, &statusErr) {
+			break
+		}
+	}
+	if e.cfg.Cloud == CloudAuto && len(providers) > 1 {
+		// Not in a cloud, or none we know
+		return nil, nil
+	}
+	return nil, lastErr
+}
+
+// guessCloud returns the providers to try for auto: the one DMI names, none
+// when DMI is readable and names none, or all of them when it is unreadable
+func (e *Environment) guessCloud() []string {
+	vendor := e.dmi("sys_vendor")
+	product := e.dmi("product_name")
+	switch {
+	case vendor == "" && product == "":
+		return []string{CloudEC2, CloudGCE, CloudHetzner}
+	case strings.Contains(vendor, "Amazon") || strings.HasPrefix(product, "Amazon EC2"):
+		return []string{CloudEC2}
+	case strings.Contains(vendor, "Google"):
+		return []string{CloudGCE}
+	case strings.Contains(vendor, "Hetzner"):
+		return []string{CloudHetzner}
+	}
+	return nil
+}
+
+func ec2Labels(ctx context.Context) (map[string]string, error) {
+	// IMDSv2 requires a session token; instances that still allow IMDSv1
+	// answer without one
+	header := http.Header{}
+	token, err := metadataRequest(ctx, http.MethodPut, "/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"60"}})
+	var statusErr *statusError
+	switch {
+	case err == nil:
+		header.Set("X-Aws-Ec2-Metadata-Token", token)
+	case !errors.As(err, &statusErr):
+		return nil, err
+	}
+	return readMetadata(ctx, header, "/latest/meta-data/instance-id", map[string]string{
+		"cloud.instance_type": "/latest/meta-data/instance-type",
+		"cloud.region":        "/latest/meta-data/placement/region",
+		"cloud.zone":          "/latest/meta-data/placement/availability-zone",
+	})
+}
+
+func gceLabels(ctx context.Context) (map[string]string, error) {
+	labels, err := readMetadata(ctx, http.Header{"Metadata-Flavor": {"Google"}}, "/computeMetadata/v1/instance/id", map[string]string{
+		"cloud.instance_type": "/computeMetadata/v1/instance/machine-type",
+		"cloud.zone":          "/computeMetadata/v1/instance/zone",
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Machine types and zones come as projects/<n>/machineTypes/<type> and
+	// projects/<n>/zones/<zone>
+	for _, key := range []string{"cloud.instance_type", "cloud.zone"} {
+		if value, ok := labels[key]; ok {
+			labels[key] = value[strings.LastIndex(value, "/")+1:]
+		}
+	}
+	if zone, ok := labels["cloud.zone"]; ok {
+		if i := strings.LastIndex(zone, "-"); i > 0 {
+			labels["cloud.region"] = zone[:i]
+		}
+	}
+	return labels, nil
+}
+
+func hetznerLabels(ctx context.Context) (map[string]string, error) {
+	return readMetadata(ctx, nil, "/hetzner/v1/metadata/instance-id", map[string]string{
+		"cloud.region": "/hetzner/v1/metadata/region",
+		"cloud.zone":   "/hetzner/v1/metadata/availability-zone",
+	})
+}
+
+// readMetadata fetches the instance ID, which must be readable, then the
+// other metadata paths by key, leaving out those a provider does not serve
+func readMetadata(ctx context.Context, header http.Header, idPath string, paths map[string]string) (map[string]string, error) {
+	id, err := metadataRequest(ctx, http.MethodGet, idPath, header)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{"cloud.instance_id": id}
+	for key, path := range paths {
+		if value, err := metadataRequest(ctx, http.MethodGet, path, header); err == nil && value != "" {
+			labels[key] = value
+		}
+	}
+	return labels, nil
+}
+
+// statusError is a metadata service answering with an error, as opposed to
+// not answering
+type statusError struct {
+	path   string
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s returned %s", e.path, e.status)
+}
+
+func metadataRequest(ctx context.Context, method, path string, header http.Header) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, metadataEndpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{path: path, status: resp.Status}
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,172 @@
+// Package httpconn calls the server's JSON API over HTTP in place of a gRPC
+// connection, for agents on networks whose proxies or firewalls block gRPC.
+// The generated service clients work over it unchanged.
+package httpconn
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	// Registers the error details failed calls carry, so their statuses decode
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ReadingsPath is where the server takes readings, in place of the
+// SubmitTemperature method's own path
+const ReadingsPath = "/api/v1/readings"
+
+// requestTimeout bounds calls whose context has no deadline, so a proxy that
+// stops responding cannot stall reporting
+const requestTimeout = time.Minute
+
+// maxResponseSize bounds response bodies, matching the server's default send
+// limit
+const maxResponseSize = 16 * 1024 * 1024
+
+// paths are the methods served somewhere other than /api/<method>
+var paths = map[string]string{
+	"/jacuzzi.v1.TemperatureService/SubmitTemperature": ReadingsPath,
+}
+
+var unmarshalOptions = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+// Conn calls unary methods as POST /api/<package.Service>/<Method> with the
+// JSON request body. Outgoing metadata, such as the client's API key, is sent
+// as request headers. Streaming methods are not supported.
+type Conn struct {
+	base        *url.URL
+	client      *http.Client
+	interceptor grpc.UnaryClientInterceptor
+}
+
+var _ grpc.ClientConnInterface = (*Conn)(nil)
+
+// New returns a connection to the server's HTTP address, as host:port or an
+// http or https URL. Proxies are taken from the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables. Calls go through the interceptor when it is
+// not nil, with a nil *grpc.ClientConn.
+func New(address string, interceptor grpc.UnaryClientInterceptor) (*Conn, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	base, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %q: %w", address, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q: must be an http or https URL", address)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	return &Conn{
+		base:        base,
+		client:      &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		interceptor: interceptor,
+	}, nil
+}
+
+// Invoke implements grpc.ClientConnInterface
+func (c *Conn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	if c.interceptor != nil {
+		return c.interceptor(ctx, method, args, reply, nil, c.invoke, opts...)
+	}
+	return c.invoke(ctx, method, args, reply, nil, opts...)
+}
+
+// NewStream implements grpc.ClientConnInterface
+func (c *Conn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "streaming method %s is not supported over HTTP", method)
+}
+
+// Close closes idle connections to the server
+func (c *Conn) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *Conn) invoke(ctx context.Context, method string, args, reply interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+	body, err := protojson.Marshal(args.(proto.Message))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode request: %v", err)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+
+	path, ok := paths[method]
+	if !ok {
+		path = "/api" + method
+	}
+	u := *c.base
+	u.Path += path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return status.Errorf(codes.Unavailable, "failed to call server: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Failed calls return their gRPC status; anything else came from
+		// something between the client and the server
+		var st spb.Status
+		if unmarshalOptions.Unmarshal(data, &st) == nil && st.Code != 0 {
+			return status.ErrorProto(&st)
+		}
+		return status.Errorf(httpCode(resp.StatusCode), "server returned %s", resp.Status)
+	}
+	if err := unmarshalOptions.Unmarshal(data, reply.(proto.Message)); err != nil {
+		return status.Errorf(codes.Internal, "invalid response: %v", err)
+	}
+	return nil
+}
+
+// httpCode maps the status of a response that carries no gRPC status
+func httpCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,85 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// machineIDPaths are checked in order for a stable host machine ID
+var machineIDPaths = []string{
+	"/etc/machine-id",
+	"/var/lib/dbus/machine-id",
+}
+
+// Load returns the client ID stored at path, or an empty string if none has
+// been saved yet. IDs are generated UUIDs, except for installs that kept
+// their hostname ID when upgrading.
+func Load(path string) (string, error) {
+	id, err := ReadSecret(path)
+	if err != nil || id == "" {
+		return "", err
+	}
+	if strings.ContainsAny(id, " \t\r\n") {
+		return "", fmt.Errorf("invalid client ID in %s", path)
+	}
+	return id, nil
+}
+
+// LoadOrCreate returns the client ID stored at path, generating and saving a
+// new UUID on first run so the ID survives restarts and hostname changes
+func LoadOrCreate(path string) (string, error) {
+	id, err := Load(path)
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	id = uuid.New().String()
+	if err := WriteSecret(path, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ReadSecret returns the trimmed contents of a credential file, or an empty
+// string if it does not exist
+func ReadSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WriteSecret saves a credential file readable only by the current user
+func WriteSecret(path, value string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, []byte(value+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to save %s: %w", path, err)
+	}
+	return nil
+}
+
+// MachineID returns the host's machine ID, or an empty string if unavailable.
+// root is where the host filesystem is mounted, or empty for /.
+func MachineID(root string) string {
+	for _, path := range machineIDPaths {
+		data, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id
+		}
+	}
+	return ""
+}
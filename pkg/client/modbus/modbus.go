@@ -0,0 +1,308 @@
+// Package modbus reads registers from Modbus devices, such as PLCs and
+// industrial thermostats, over Modbus TCP or RTU serial lines.
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/serial"
+)
+
+// Function codes of the register tables that can be read
+const (
+	HoldingRegisters byte = 3
+	InputRegisters   byte = 4
+)
+
+// Data types a value may be stored as, in one or two registers
+const (
+	Int16   = "int16"
+	Uint16  = "uint16"
+	Int32   = "int32"
+	Uint32  = "uint32"
+	Float32 = "float32"
+)
+
+// maxPDU is the largest protocol data unit the protocol allows
+const maxPDU = 253
+
+// conn is a TCP connection or serial port
+type conn interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+}
+
+// Client reads registers from the devices behind a TCP address or serial
+// port, opening it on first use and again after any failure
+type Client struct {
+	address string // host:port, for Modbus TCP
+	device  string // Serial port, for Modbus RTU
+	serial  serial.Config
+	timeout time.Duration
+
+	mu          sync.Mutex // Serializes requests
+	conn        conn
+	transaction uint16
+	last        time.Time // End of the last RTU exchange
+}
+
+// NewTCP returns a client for the Modbus TCP server at address
+func NewTCP(address string, timeout time.Duration) *Client {
+	return &Client{address: address, timeout: timeout}
+}
+
+// NewRTU returns a client for the Modbus RTU bus on the serial port at device
+func NewRTU(device string, cfg serial.Config, timeout time.Duration) *Client {
+	return &Client{device: device, serial: cfg, timeout: timeout}
+}
+
+// String names the TCP address or serial port
+func (c *Client) String() string {
+	if c.device != "" {
+		return c.device
+	}
+	return c.address
+}
+
+// Exception is an error the device answered with
+type Exception struct {
+	Code byte
+}
+
+func (e *Exception) Error() string {
+	switch e.Code {
+	case 1:
+		return "illegal function"
+	case 2:
+		return "illegal data address"
+	case 3:
+		return "illegal data value"
+	case 4:
+		return "server device failure"
+	case 6:
+		return "server device busy"
+	case 10:
+		return "gateway path unavailable"
+	case 11:
+		return "gateway target device failed to respond"
+	}
+	return fmt.Sprintf("exception %d", e.Code)
+}
+
+// ReadRegisters reads count registers from a table of the device with the
+// given unit ID, starting at address
+func (c *Client) ReadRegisters(unit, function byte, address, count uint16) ([]uint16, error) {
+	if count == 0 || count > 125 {
+		return nil, fmt.Errorf("invalid register count %d", count)
+	}
+	request := []byte{function}
+	request = binary.BigEndian.AppendUint16(request, address)
+	request = binary.BigEndian.AppendUint16(request, count)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	response, err := c.exchange(unit, request)
+	if err != nil {
+		if !errors.As(err, new(*Exception)) && c.conn != nil {
+			// The connection may be out of step with the device
+			c.conn.Close()
+			c.conn = nil
+		}
+		return nil, err
+	}
+	if response[0] != function || len(response) != 2+int(count)*2 || int(response[1]) != int(count)*2 {
+		return nil, errors.New("malformed response")
+	}
+	registers := make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(response[2+i*2:])
+	}
+	return registers, nil
+}
+
+// Close closes the connection, which is reopened by the next read
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// exchange sends a request PDU and returns the response PDU
+func (c *Client) exchange(unit byte, request []byte) ([]byte, error) {
+	if c.conn == nil {
+		var err error
+		if c.device != "" {
+			c.conn, err = serial.OpenConfig(c.device, c.serial)
+		} else {
+			c.conn, err = net.DialTimeout("tcp", c.address, c.timeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	var response []byte
+	var err error
+	if c.device != "" {
+		response, err = c.exchangeRTU(unit, request)
+	} else {
+		response, err = c.exchangeTCP(unit, request)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, fmt.Errorf("unit %d did not respond within %s", unit, c.timeout)
+		}
+		return nil, err
+	}
+	if len(response) == 2 && response[0] == request[0]|0x80 {
+		return nil, &Exception{Code: response[1]}
+	}
+	return response, nil
+}
+
+// exchangeTCP frames the request with an MBAP header
+func (c *Client) exchangeTCP(unit byte, request []byte) ([]byte, error) {
+	c.transaction++
+	frame := binary.BigEndian.AppendUint16(nil, c.transaction)
+	frame = binary.BigEndian.AppendUint16(frame, 0) // Protocol ID
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(request)+1))
+	frame = append(frame, unit)
+	frame = append(frame, request...)
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > maxPDU+1 {
+			return nil, errors.New("malformed MBAP header")
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(c.conn, pdu); err != nil {
+			return nil, err
+		}
+		// Skip responses to earlier requests that timed out
+		if binary.BigEndian.Uint16(header) != c.transaction {
+			continue
+		}
+		if header[6] != unit {
+			return nil, fmt.Errorf("response from unit %d, not %d", header[6], unit)
+		}
+		return pdu, nil
+	}
+}
+
+// exchangeRTU frames the request with the unit ID and a CRC, leaving the
+// silent interval between frames the bus needs
+func (c *Client) exchangeRTU(unit byte, request []byte) ([]byte, error) {
+	// 3.5 characters of 11 bits, or 1.75ms above 19200 baud
+	gap := 1750 * time.Microsecond
+	if c.serial.Baud <= 19200 {
+		gap = time.Duration(float64(time.Second) * 38.5 / float64(c.serial.Baud))
+	}
+	if wait := gap - time.Since(c.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() { c.last = time.Now() }()
+
+	frame := append([]byte{unit}, request...)
+	frame = binary.LittleEndian.AppendUint16(frame, crc16(frame))
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	// Unit ID, function, then the byte count or exception code
+	response := make([]byte, 3, 3+maxPDU)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, err
+	}
+	rest := 2 // CRC
+	if response[1]&0x80 == 0 {
+		rest += int(response[2])
+	}
+	response = response[:3+rest]
+	if _, err := io.ReadFull(c.conn, response[3:]); err != nil {
+		return nil, err
+	}
+	n := len(response) - 2
+	if crc16(response[:n]) != binary.LittleEndian.Uint16(response[n:]) {
+		return nil, errors.New("response failed its CRC check")
+	}
+	if response[0] != unit {
+		return nil, fmt.Errorf("response from unit %d, not %d", response[0], unit)
+	}
+	return response[1:n], nil
+}
+
+// crc16 is the Modbus CRC of data
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Registers returns how many registers a value of the data type takes
+func Registers(dataType string) uint16 {
+	switch dataType {
+	case Int32, Uint32, Float32:
+		return 2
+	}
+	return 1
+}
+
+// Decode converts registers to a value of the data type. Two-register values
+// take the high word first unless wordSwap is set.
+func Decode(registers []uint16, dataType string, wordSwap bool) (float64, error) {
+	if len(registers) != int(Registers(dataType)) {
+		return 0, fmt.Errorf("%s takes %d registers, got %d", dataType, Registers(dataType), len(registers))
+	}
+	var word uint32
+	if len(registers) == 2 {
+		hi, lo := registers[0], registers[1]
+		if wordSwap {
+			hi, lo = lo, hi
+		}
+		word = uint32(hi)<<16 | uint32(lo)
+	}
+	switch dataType {
+	case Int16:
+		return float64(int16(registers[0])), nil
+	case Uint16:
+		return float64(registers[0]), nil
+	case Int32:
+		return float64(int32(word)), nil
+	case Uint32:
+		return float64(word), nil
+	case Float32:
+		return float64(math.Float32frombits(word)), nil
+	}
+	return 0, fmt.Errorf("unsupported data type %q", dataType)
+}
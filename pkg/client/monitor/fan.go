@@ -0,0 +1,219 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pwm<N>_enable modes understood by most hwmon drivers
+const (
+	pwmModeManual    = "1"
+	pwmModeAutomatic = "2"
+)
+
+// fanIDPattern matches fan IDs, which name a pwm output of an hwmon device
+var fanIDPattern = regexp.MustCompile(`^(hwmon[0-9]+)_(pwm[0-9]+)$`)
+
+// Fan is a PWM fan output exposed through hwmon
+type Fan struct {
+	ID     string // e.g. hwmon2_pwm1
+	Name   string
+	PWM    int // Current duty cycle, 0-255
+	Manual bool
+	RPM    int // Speed of the matching fan input, 0 when not reported
+}
+
+// CurvePoint maps a temperature to a duty cycle
+type CurvePoint struct {
+	TempCelsius float64
+	PWM         int
+}
+
+type fanCurve struct {
+	sensorID string
+	points   []CurvePoint
+}
+
+// FanController sets fan duty cycles through hwmon, either to fixed values or
+// by following curves over sensor temperatures
+type FanController struct {
+	hwmonPath string
+
+	mu     sync.Mutex
+	curves map[string]fanCurve // By fan ID
+}
+
+func NewFanController() *FanController {
+	return NewFanControllerAt("/sys")
+}
+
+// NewFanControllerAt drives fans through the sysfs mounted at sysPath
+func NewFanControllerAt(sysPath string) *FanController {
+	return &FanController{
+		hwmonPath: filepath.Join(sysPath, "class", "hwmon"),
+		curves:    make(map[string]fanCurve),
+	}
+}
+
+// GetFans lists the PWM fan outputs on this machine
+func (c *FanController) GetFans() ([]Fan, error) {
+	pwmFiles, err := filepath.Glob(filepath.Join(c.hwmonPath, "hwmon*", "pwm[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var fans []Fan
+	for _, pwmFile := range pwmFiles {
+		base := filepath.Base(pwmFile)
+		if strings.Contains(base, "_") {
+			continue // pwm<N>_enable and similar
+		}
+		hwmonDir := filepath.Dir(pwmFile)
+		pwm, err := readInt(pwmFile)
+		if err != nil {
+			continue
+		}
+
+		deviceName := "Unknown"
+		if data, err := os.ReadFile(filepath.Join(hwmonDir, "name")); err == nil {
+			deviceName = strings.TrimSpace(string(data))
+		}
+		num := strings.TrimPrefix(base, "pwm")
+		fan := Fan{
+			ID:   fmt.Sprintf("%s_%s", filepath.Base(hwmonDir), base),
+			Name: fmt.Sprintf("%s_%s", deviceName, base),
+			PWM:  pwm,
+		}
+		if mode, err := os.ReadFile(pwmFile + "_enable"); err == nil {
+			fan.Manual = strings.TrimSpace(string(mode)) == pwmModeManual
+		}
+		if rpm, err := readInt(filepath.Join(hwmonDir, "fan"+num+"_input")); err == nil {
+			fan.RPM = rpm
+		}
+		fans = append(fans, fan)
+	}
+	return fans, nil
+}
+
+// SetPWM switches a fan to manual control at a fixed duty cycle, replacing
+// any curve it was following
+func (c *FanController) SetPWM(fanID string, pwm int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.curves, fanID)
+	return c.writePWM(fanID, pwm)
+}
+
+// SetCurve switches a fan to manual control following a curve over a
+// sensor's temperature. The curve takes effect on the next Apply.
+func (c *FanController) SetCurve(fanID, sensorID string, points []CurvePoint) error {
+	if len(points) == 0 {
+		return fmt.Errorf("fan curve has no points")
+	}
+	if _, err := c.pwmFile(fanID); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.curves[fanID] = fanCurve{sensorID: sensorID, points: points}
+	return nil
+}
+
+// SetAutomatic hands a fan back to hardware control
+func (c *FanController) SetAutomatic(fanID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.curves, fanID)
+	pwmFile, err := c.pwmFile(fanID)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pwmFile+"_enable", []byte(pwmModeAutomatic), 0644)
+}
+
+// Apply sets every fan following a curve from the current sensor
+// temperatures. Fans whose sensor is missing keep their last duty cycle.
+func (c *FanController) Apply(sensors []TemperatureSensor) error {
+	temps := make(map[string]float64, len(sensors))
+	for _, sensor := range sensors {
+		temps[sensor.ID] = sensor.TempCelsius()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []string
+	for fanID, curve := range c.curves {
+		temp, ok := temps[curve.sensorID]
+		if !ok {
+			continue
+		}
+		if err := c.writePWM(fanID, curvePWM(curve.points, temp)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to apply fan curves: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// curvePWM interpolates a duty cycle for a temperature, clamping to the
+// curve's end points
+func curvePWM(points []CurvePoint, temp float64) int {
+	if temp <= points[0].TempCelsius {
+		return points[0].PWM
+	}
+	for i := 1; i < len(points); i++ {
+		if temp <= points[i].TempCelsius {
+			lo, hi := points[i-1], points[i]
+			frac := (temp - lo.TempCelsius) / (hi.TempCelsius - lo.TempCelsius)
+			return lo.PWM + int(frac*float64(hi.PWM-lo.PWM)+0.5)
+		}
+	}
+	return points[len(points)-1].PWM
+}
+
+func (c *FanController) writePWM(fanID string, pwm int) error {
+	if pwm < 0 || pwm > 255 {
+		return fmt.Errorf("pwm %d is out of range 0-255", pwm)
+	}
+	pwmFile, err := c.pwmFile(fanID)
+	if err != nil {
+		return err
+	}
+	// Some drivers have no enable file and are always manual
+	if err := os.WriteFile(pwmFile+"_enable", []byte(pwmModeManual), 0644); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to switch fan %s to manual control: %w", fanID, err)
+	}
+	if err := os.WriteFile(pwmFile, []byte(strconv.Itoa(pwm)), 0644); err != nil {
+		return fmt.Errorf("failed to set fan %s: %w", fanID, err)
+	}
+	return nil
+}
+
+// pwmFile resolves a fan ID to its pwm file, rejecting IDs that do not name
+// an existing hwmon pwm output
+func (c *FanController) pwmFile(fanID string) (string, error) {
+	match := fanIDPattern.FindStringSubmatch(fanID)
+	if match == nil {
+		return "", fmt.Errorf("invalid fan ID %q", fanID)
+	}
+	pwmFile := filepath.Join(c.hwmonPath, match[1], match[2])
+	if _, err := os.Stat(pwmFile); err != nil {
+		return "", fmt.Errorf("fan %s not found", fanID)
+	}
+	return pwmFile, nil
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Source reads the current temperature of every sensor
+type Source interface {
+	GetTemperatures() ([]TemperatureSensor, error)
+}
+
+// mockTypes are cycled through so mock sensors cover every type filter
+var mockTypes = []string{"CPU", "GPU", "DISK"}
+
+// mockTypeOffsets shift each type from the base temperature, as real
+// machines run GPUs hotter and disks cooler than CPUs
+var mockTypeOffsets = map[string]float64{"CPU": 0, "GPU": 5, "DISK": -12}
+
+// mockDriftPeriod is the period of the slow swing every sensor follows
+const mockDriftPeriod = 10 * time.Minute
+
+// MockConfig shapes the synthetic readings of a MockMonitor
+type MockConfig struct {
+	Sensors       int           // Number of sensors, cycling through CPU, GPU and DISK
+	BaseCelsius   float64       // Temperature the sensors hover around
+	Drift         float64       // Amplitude of the slow swing, in °C
+	Noise         float64       // Standard deviation of the noise, in °C
+	SpikeInterval time.Duration // Time between spikes; 0 disables them
+	SpikeDuration time.Duration // How long a spike lasts
+	SpikeCelsius  float64       // Rise at the peak of a spike
+}
+
+// MockMonitor emits synthetic sensors, for developing and testing on
+// machines without hwmon, such as containers or macOS
+type MockMonitor struct {
+	cfg   MockConfig
+	start time.Time
+}
+
+func NewMockMonitor(cfg MockConfig) *MockMonitor {
+	return &MockMonitor{cfg: cfg, start: time.Now()}
+}
+
+func (m *MockMonitor) GetTemperatures() ([]TemperatureSensor, error) {
+	elapsed := time.Since(m.start)
+	spike := m.spike(elapsed)
+
+	sensors := make([]TemperatureSensor, m.cfg.Sensors)
+	for i := range sensors {
+		sensorType := mockTypes[i%len(mockTypes)]
+		// Sensors drift out of phase so they do not move in lockstep
+		phase := 2 * math.Pi * (elapsed.Seconds()/mockDriftPeriod.Seconds() + float64(i)/float64(m.cfg.Sensors))
+		celsius := m.cfg.BaseCelsius + mockTypeOffsets[sensorType] +
+			m.cfg.Drift*math.Sin(phase) +
+			m.cfg.Noise*rand.NormFloat64()
+		// Disks heat up slowly, so they feel only part of a spike
+		if sensorType == "DISK" {
+			celsius += spike / 4
+		} else {
+			celsius += spike
+		}
+
+		sensors[i] = TemperatureSensor{
+			ID:         fmt.Sprintf("mock_%d", i),
+			Type:       sensorType,
+			Name:       fmt.Sprintf("Mock %s %d", sensorType, i/len(mockTypes)),
+			TempMilliC: int64(math.Round(celsius * 1000)),
+		}
+	}
+	return sensors, nil
+}
+
+// spike returns the rise of the current spike, ramping up over its first
+// quarter and down over its last
+func (m *MockMonitor) spike(elapsed time.Duration) float64 {
+	if m.cfg.SpikeInterval <= 0 || m.cfg.SpikeDuration <= 0 {
+		return 0
+	}
+	into := elapsed % m.cfg.SpikeInterval
+	// The first spike starts one interval in, not at startup
+	if elapsed < m.cfg.SpikeInterval || into >= m.cfg.SpikeDuration {
+		return 0
+	}
+	ramp := m.cfg.SpikeDuration / 4
+	switch {
+	case ramp > 0 && into < ramp:
+		return m.cfg.SpikeCelsius * float64(into) / float64(ramp)
+	case ramp > 0 && into > m.cfg.SpikeDuration-ramp:
+		return m.cfg.SpikeCelsius * float64(m.cfg.SpikeDuration-into) / float64(ramp)
+	}
+	return m.cfg.SpikeCelsius
+}
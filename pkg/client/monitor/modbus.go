@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/modbus"
+)
+
+// ModbusRegister is a temperature held in one or two registers of a device
+type ModbusRegister struct {
+	Sensor   TemperatureSensor // ID, Type and Name of the reported sensor
+	Unit     byte
+	Function byte // modbus.HoldingRegisters or modbus.InputRegisters
+	Address  uint16
+	DataType string
+	WordSwap bool
+	// The temperature is value*Scale + Offset, in °F when Fahrenheit is set
+	Scale      float64
+	Offset     float64
+	Fahrenheit bool
+}
+
+// ModbusMonitor polls temperatures from registers of the devices behind a
+// Modbus client
+type ModbusMonitor struct {
+	client    *modbus.Client
+	registers []ModbusRegister
+
+	mu      sync.Mutex
+	failing map[string]bool // By sensor ID
+}
+
+// NewModbusMonitor returns a monitor reading registers through client
+func NewModbusMonitor(client *modbus.Client, registers []ModbusRegister) *ModbusMonitor {
+	return &ModbusMonitor{client: client, registers: registers, failing: make(map[string]bool)}
+}
+
+// GetTemperatures reads every register, leaving out those that fail unless
+// all do
+func (m *ModbusMonitor) GetTemperatures() ([]TemperatureSensor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sensors []TemperatureSensor
+	var errs []error
+	for _, r := range m.registers {
+		celsius, err := m.read(r)
+		if err != nil {
+			if !m.failing[r.Sensor.ID] {
+				log.Printf("Failed to read Modbus sensor %s from %s: %v", r.Sensor.ID, m.client, err)
+				m.failing[r.Sensor.ID] = true
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", r.Sensor.ID, err))
+			continue
+		}
+		if m.failing[r.Sensor.ID] {
+			log.Printf("Modbus sensor %s is readable again", r.Sensor.ID)
+			delete(m.failing, r.Sensor.ID)
+		}
+		sensor := r.Sensor
+		sensor.TempMilliC = int64(math.Round(celsius * 1000))
+		sensors = append(sensors, sensor)
+	}
+	if len(sensors) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return sensors, nil
+}
+
+func (m *ModbusMonitor) read(r ModbusRegister) (float64, error) {
+	registers, err := m.client.ReadRegisters(r.Unit, r.Function, r.Address, modbus.Registers(r.DataType))
+	if err != nil {
+		return 0, err
+	}
+	value, err := modbus.Decode(registers, r.DataType, r.WordSwap)
+	if err != nil {
+		return 0, err
+	}
+	value = value*r.Scale + r.Offset
+	if r.Fahrenheit {
+		value = (value - 32) * 5 / 9
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("register holds %g", value)
+	}
+	return value, nil
+}
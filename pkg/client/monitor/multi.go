@@ -0,0 +1,29 @@
+package monitor
+
+import "errors"
+
+// multiSource reads several sources as one
+type multiSource []Source
+
+// Combine returns a source reading the sensors of every source. A source
+// that fails is left out while any other succeeds.
+func Combine(sources ...Source) Source {
+	return multiSource(sources)
+}
+
+func (m multiSource) GetTemperatures() ([]TemperatureSensor, error) {
+	var sensors []TemperatureSensor
+	var errs []error
+	for _, source := range m {
+		s, err := source.GetTemperatures()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sensors = append(sensors, s...)
+	}
+	if len(errs) == len(m) && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return sensors, nil
+}
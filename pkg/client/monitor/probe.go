@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/client/serial"
+)
+
+const (
+	// probeReopenDelay is how long a serial probe waits to reopen a device
+	// that failed or was unplugged
+	probeReopenDelay = 10 * time.Second
+	// probeStaleAfter is how long a serial probe keeps reporting its last
+	// reading once the device goes quiet
+	probeStaleAfter = 5 * time.Minute
+)
+
+// SerialProbe reads a thermometer that prints readings as text lines over a
+// serial port, keeping the latest
+type SerialProbe struct {
+	device     string
+	baud       int
+	pattern    *regexp.Regexp
+	fahrenheit bool
+	sensor     TemperatureSensor
+
+	mu       sync.Mutex
+	celsius  float64
+	received time.Time
+}
+
+// NewSerialProbe returns a probe reporting the device at path as sensor. The
+// first group of pattern captures the temperature, in °F when fahrenheit is
+// set. Lines that do not match are ignored.
+func NewSerialProbe(path string, baud int, pattern *regexp.Regexp, fahrenheit bool, sensor TemperatureSensor) *SerialProbe {
+	return &SerialProbe{device: path, baud: baud, pattern: pattern, fahrenheit: fahrenheit, sensor: sensor}
+}
+
+// Run reads the device until ctx is done, reopening it when it fails
+func (p *SerialProbe) Run(ctx context.Context) {
+	for {
+		if err := p.read(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Serial probe %s failed: %v", p.device, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(probeReopenDelay):
+		}
+	}
+}
+
+func (p *SerialProbe) read(ctx context.Context) error {
+	f, err := serial.Open(p.device, p.baud)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := p.pattern.FindStringSubmatch(scanner.Text())
+		if len(match) < 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		if p.fahrenheit {
+			value = (value - 32) * 5 / 9
+		}
+		p.mu.Lock()
+		p.celsius, p.received = value, time.Now()
+		p.mu.Unlock()
+	}
+	return scanner.Err()
+}
+
+// GetTemperatures returns the latest reading, or none when the device has
+// printed nothing recently
+func (p *SerialProbe) GetTemperatures() ([]TemperatureSensor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.received.IsZero() || time.Since(p.received) > probeStaleAfter {
+		return nil, nil
+	}
+	sensor := p.sensor
+	sensor.TempMilliC = int64(math.Round(p.celsius * 1000))
+	return []TemperatureSensor{sensor}, nil
+}
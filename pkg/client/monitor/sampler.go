@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Window summarizes the samples of a sensor since the previous report
+type Window struct {
+	Sensor  TemperatureSensor // Last sample
+	Min     float64           // °C
+	Max     float64
+	Avg     float64
+	Samples int
+}
+
+// Sampler samples a source more often than readings are reported, so a
+// report carries the range of each sensor over its interval rather than a
+// single value
+type Sampler struct {
+	source Source
+
+	mu      sync.Mutex
+	order   []string // Sensor IDs in the order first sampled
+	windows map[string]*window
+}
+
+type window struct {
+	last     TemperatureSensor
+	min, max float64
+	sum      float64
+	samples  int
+}
+
+func NewSampler(source Source) *Sampler {
+	return &Sampler{source: source, windows: make(map[string]*window)}
+}
+
+// Run samples every interval until ctx is done
+func (s *Sampler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.sample(); err != nil {
+				log.Printf("Failed to sample temperatures: %v", err)
+			}
+		}
+	}
+}
+
+// Flush takes a final sample and returns the windows of every sensor sampled
+// since the previous flush, starting new ones
+func (s *Sampler) Flush() ([]Window, error) {
+	if err := s.sample(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	windows := make([]Window, 0, len(s.order))
+	for _, id := range s.order {
+		w := s.windows[id]
+		windows = append(windows, Window{
+			Sensor:  w.last,
+			Min:     w.min,
+			Max:     w.max,
+			Avg:     w.sum / float64(w.samples),
+			Samples: w.samples,
+		})
+	}
+	s.order = nil
+	s.windows = make(map[string]*window)
+	return windows, nil
+}
+
+func (s *Sampler) sample() error {
+	sensors, err := s.source.GetTemperatures()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sensor := range sensors {
+		celsius := sensor.TempCelsius()
+		w, ok := s.windows[sensor.ID]
+		if !ok {
+			w = &window{min: celsius, max: celsius}
+			s.windows[sensor.ID] = w
+			s.order = append(s.order, sensor.ID)
+		}
+		w.last = sensor
+		w.min = min(w.min, celsius)
+		w.max = max(w.max, celsius)
+		w.sum += celsius
+		w.samples++
+	}
+	return nil
+}
+
+// Merge returns the window spanning w and a later window of the same sensor
+func (w Window) Merge(later Window) Window {
+	samples := w.Samples + later.Samples
+	return Window{
+		Sensor:  later.Sensor,
+		Min:     min(w.Min, later.Min),
+		Max:     max(w.Max, later.Max),
+		Avg:     (w.Avg*float64(w.Samples) + later.Avg*float64(later.Samples)) / float64(samples),
+		Samples: samples,
+	}
+}
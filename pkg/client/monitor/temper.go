@@ -0,0 +1,107 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TEMPer report formats, which differ by firmware
+const (
+	// TEMPerV1 is the format of TEMPerV1.x sticks (USB ID 0c45:7401), which
+	// report 1/256ths of a degree
+	TEMPerV1 = "v1"
+	// TEMPerGold is the format of TEMPerGold and TEMPer1F sticks (USB ID
+	// 413d:2107), which report hundredths of a degree
+	TEMPerGold = "gold"
+)
+
+// temperQuery asks the stick for a reading, preceded by report number 0 as
+// hidraw expects for devices without numbered reports
+var temperQuery = []byte{0x00, 0x01, 0x80, 0x33, 0x01, 0x00, 0x00, 0x00, 0x00}
+
+// temperTimeout bounds a query, so an unplugged stick cannot stall reports
+const temperTimeout = 2 * time.Second
+
+// TEMPerProbe reads a TEMPer USB thermometer through its Linux hidraw device,
+// such as /dev/hidraw1. Sticks expose two HID interfaces; the second answers
+// queries.
+type TEMPerProbe struct {
+	device string
+	format string
+	sensor TemperatureSensor
+
+	mu      sync.Mutex // Serializes queries
+	failing bool
+}
+
+// NewTEMPerProbe returns a probe reporting the stick at device as sensor. An
+// empty format is read from the stick's USB ID.
+func NewTEMPerProbe(device, format string, sensor TemperatureSensor) *TEMPerProbe {
+	if format == "" {
+		format = temperFormat(device)
+	}
+	return &TEMPerProbe{device: device, format: format, sensor: sensor}
+}
+
+// temperFormat picks the report format from the USB vendor of a hidraw device
+func temperFormat(device string) string {
+	uevent, err := os.ReadFile(filepath.Join("/sys/class/hidraw", filepath.Base(device), "device", "uevent"))
+	if err == nil && strings.Contains(strings.ToUpper(string(uevent)), "HID_ID=0003:0000413D:") {
+		return TEMPerGold
+	}
+	return TEMPerV1
+}
+
+func (p *TEMPerProbe) GetTemperatures() ([]TemperatureSensor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	celsius, err := p.query()
+	if err != nil {
+		if !p.failing {
+			log.Printf("TEMPer %s stopped responding: %v", p.device, err)
+			p.failing = true
+		}
+		return nil, fmt.Errorf("TEMPer %s: %w", p.device, err)
+	}
+	if p.failing {
+		log.Printf("TEMPer %s is responding again", p.device)
+		p.failing = false
+	}
+	sensor := p.sensor
+	sensor.TempMilliC = int64(math.Round(celsius * 1000))
+	return []TemperatureSensor{sensor}, nil
+}
+
+func (p *TEMPerProbe) query() (float64, error) {
+	f, err := os.OpenFile(p.device, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := f.SetDeadline(time.Now().Add(temperTimeout)); err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(temperQuery); err != nil {
+		return 0, err
+	}
+	report := make([]byte, 8)
+	n, err := f.Read(report)
+	if err != nil {
+		return 0, err
+	}
+	if n < 4 {
+		return 0, fmt.Errorf("short report of %d bytes", n)
+	}
+	raw := float64(int16(binary.BigEndian.Uint16(report[2:4])))
+	if p.format == TEMPerGold {
+		return raw / 100, nil
+	}
+	return raw / 256, nil
+}
@@ -21,12 +21,20 @@ func (t *TemperatureSensor) TempCelsius() float64 {
 }
 
 type TemperatureMonitor struct {
-	hwmonPath string
+	hwmonPath   string
+	thermalPath string
 }
 
 func NewTemperatureMonitor() *TemperatureMonitor {
+	return NewTemperatureMonitorAt("/sys")
+}
+
+// NewTemperatureMonitorAt reads sensors from the sysfs mounted at sysPath,
+// such as the host's /sys mounted into a container
+func NewTemperatureMonitorAt(sysPath string) *TemperatureMonitor {
 	return &TemperatureMonitor{
-		hwmonPath: "/sys/class/hwmon",
+		hwmonPath:   filepath.Join(sysPath, "class", "hwmon"),
+		thermalPath: filepath.Join(sysPath, "class", "thermal"),
 	}
 }
 
@@ -126,7 +134,7 @@ func (m *TemperatureMonitor) readHwmonDevice(hwmonDir string) ([]TemperatureSens
 func (m *TemperatureMonitor) readThermalZones() ([]TemperatureSensor, error) {
 	var sensors []TemperatureSensor
 
-	thermalDirs, err := filepath.Glob("/sys/class/thermal/thermal_zone*")
+	thermalDirs, err := filepath.Glob(filepath.Join(m.thermalPath, "thermal_zone*"))
 	if err != nil {
 		return nil, err
 	}
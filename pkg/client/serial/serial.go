@@ -0,0 +1,47 @@
+// Package serial opens serial ports, such as USB serial adapters and boards
+// that print readings, as raw 8-bit lines at a given baud rate.
+package serial
+
+import (
+	"fmt"
+	"os"
+)
+
+// Config sets the line settings of a port
+type Config struct {
+	Baud     int
+	Parity   byte // 'N' (the default), 'E' or 'O'
+	StopBits int  // 1 (the default) or 2
+}
+
+// Open opens the serial device at path and sets it to raw 8N1 mode at baud
+func Open(path string, baud int) (*os.File, error) {
+	return OpenConfig(path, Config{Baud: baud})
+}
+
+// OpenConfig opens the serial device at path and sets it to raw mode with
+// the line settings of cfg. Reads honor the file's deadlines.
+func OpenConfig(path string, cfg Config) (*os.File, error) {
+	switch cfg.Parity {
+	case 0:
+		cfg.Parity = 'N'
+	case 'N', 'E', 'O':
+	default:
+		return nil, fmt.Errorf("unsupported parity %q", cfg.Parity)
+	}
+	if cfg.StopBits == 0 {
+		cfg.StopBits = 1
+	}
+	if cfg.StopBits != 1 && cfg.StopBits != 2 {
+		return nil, fmt.Errorf("unsupported stop bits %d", cfg.StopBits)
+	}
+	f, err := os.OpenFile(path, openFlags, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := configure(f, cfg); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to configure %s: %w", path, err)
+	}
+	return f, nil
+}
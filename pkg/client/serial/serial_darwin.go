@@ -0,0 +1,20 @@
+package serial
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func configure(f *os.File, cfg Config) error {
+	return control(f, func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TIOCGETA)
+		if err != nil {
+			return err
+		}
+		makeRaw(t, cfg)
+		t.Ispeed = uint64(cfg.Baud)
+		t.Ospeed = uint64(cfg.Baud)
+		return unix.IoctlSetTermios(fd, unix.TIOCSETA, t)
+	})
+}
@@ -0,0 +1,39 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var rates = map[int]uint32{
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+}
+
+func configure(f *os.File, cfg Config) error {
+	rate, ok := rates[cfg.Baud]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", cfg.Baud)
+	}
+	return control(f, func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+		makeRaw(t, cfg)
+		t.Cflag &^= unix.CBAUD
+		t.Cflag |= rate
+		t.Ispeed = rate
+		t.Ospeed = rate
+		return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+	})
+}
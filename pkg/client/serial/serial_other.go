@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package serial
+
+import (
+	"errors"
+	"os"
+)
+
+const openFlags = os.O_RDWR
+
+func configure(f *os.File, cfg Config) error {
+	return errors.New("serial ports are not supported on this platform")
+}
@@ -0,0 +1,48 @@
+//go:build linux || darwin
+
+package serial
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFlags keep the port from becoming the controlling terminal
+const openFlags = os.O_RDWR | unix.O_NOCTTY
+
+// makeRaw disables line editing, echo and translation, for 8 data bits with
+// the parity and stop bits of cfg, with reads returning as soon as a byte
+// arrives
+func makeRaw(t *unix.Termios, cfg Config) {
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL
+	switch cfg.Parity {
+	case 'E':
+		t.Cflag |= unix.PARENB
+	case 'O':
+		t.Cflag |= unix.PARENB | unix.PARODD
+	}
+	if cfg.StopBits == 2 {
+		t.Cflag |= unix.CSTOPB
+	}
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+}
+
+// control runs fn on the port's descriptor without f.Fd, which would switch
+// it to blocking mode and disable deadlines
+func control(f *os.File, fn func(fd int) error) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := raw.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnErr
+}
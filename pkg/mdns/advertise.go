@@ -0,0 +1,322 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// recordTTL is how long browsers may cache the records, in seconds
+	recordTTL = 120
+	// legacyTTL caps the records sent to one-shot resolvers, which do not
+	// see goodbyes, as RFC 6762 recommends
+	legacyTTL = 10
+)
+
+// Advertisement describes the service to advertise, under the host name
+type Advertisement struct {
+	Port int      // gRPC port
+	Text []string // key=value TXT entries
+	IPs  []net.IP // IPv4 addresses; empty uses those of every interface that is up
+}
+
+type advertiser struct {
+	instance dnsmessage.Name // <host>._jacuzzi._tcp.local.
+	host     dnsmessage.Name // <host>.local.
+	port     uint16
+	text     []string
+	ips      []net.IP
+}
+
+// Advertise answers mDNS queries for the service until ctx is done. It
+// announces the service when it starts and withdraws it when it stops.
+func Advertise(ctx context.Context, ad Advertisement) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname: %w", err)
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	ips := ad.IPs
+	if len(ips) == 0 {
+		if ips, err = interfaceIPs(); err != nil {
+			return err
+		}
+	}
+	if len(ad.Text) == 0 {
+		// A TXT record holds at least one string
+		ad.Text = []string{""}
+	}
+	a := &advertiser{
+		instance: mustName(hostname + "." + serviceName()),
+		host:     mustName(hostname + "." + domain),
+		port:     uint16(ad.Port),
+		text:     ad.Text,
+		ips:      ips,
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("failed to join mDNS group: %w", err)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	// Announce twice, a second apart, in case the first is lost
+	go func() {
+		for i := 0; i < 2; i++ {
+			a.send(conn, group, a.announcement(recordTTL))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("failed to read mDNS query: %w", err)
+		}
+		a.answer(conn, buf[:n], src)
+	}
+
+	// Withdraw the records so browsers drop them now rather than when they
+	// expire
+	a.send(conn, group, a.announcement(0))
+	return nil
+}
+
+// answer replies to a query that asks for any of the service's records
+func (a *advertiser) answer(conn *net.UDPConn, query []byte, src *net.UDPAddr) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil || header.Response {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+
+	// Queries from ports other than 5353 come from one-shot resolvers, which
+	// expect a unicast reply that repeats the query
+	legacy := src.Port != group.Port
+	ttl := uint32(recordTTL)
+	if legacy {
+		ttl = legacyTTL
+	}
+
+	var answers, additionals []record
+	unicast := legacy
+	for _, q := range questions {
+		found := a.records(q, ttl)
+		if len(found) == 0 {
+			continue
+		}
+		answers = append(answers, found...)
+		if q.Class&unicastResponse != 0 {
+			unicast = true
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	// Send what a browser needs to connect along with the PTR it asked for
+	for _, r := range answers {
+		if r.header.Type == dnsmessage.TypePTR && sameName(r.header.Name, serviceName()) {
+			additionals = append(additionals, a.srv(ttl), a.txt(ttl))
+			additionals = append(additionals, a.addresses(ttl)...)
+		}
+	}
+
+	msg := message{answers: answers, additionals: additionals}
+	if legacy {
+		msg.id = header.ID
+		msg.questions = questions
+	}
+	to := group
+	if unicast {
+		to = src
+	}
+	a.send(conn, to, msg)
+}
+
+// records returns the records answering a question
+func (a *advertiser) records(q dnsmessage.Question, ttl uint32) []record {
+	matches := func(t dnsmessage.Type) bool {
+		return q.Type == t || q.Type == dnsmessage.TypeALL
+	}
+	switch {
+	case sameName(q.Name, serviceName()) && matches(dnsmessage.TypePTR):
+		return []record{a.ptr(ttl)}
+	case sameName(q.Name, servicesName) && matches(dnsmessage.TypePTR):
+		return []record{{
+			header: dnsmessage.ResourceHeader{Name: mustName(servicesName), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+			body:   &dnsmessage.PTRResource{PTR: mustName(serviceName())},
+		}}
+	case sameName(q.Name, a.instance.String()):
+		var records []record
+		if matches(dnsmessage.TypeSRV) {
+			records = append(records, a.srv(ttl))
+		}
+		if matches(dnsmessage.TypeTXT) {
+			records = append(records, a.txt(ttl))
+		}
+		return records
+	case sameName(q.Name, a.host.String()) && matches(dnsmessage.TypeA):
+		return a.addresses(ttl)
+	}
+	return nil
+}
+
+// announcement lists every record, for announcements and goodbyes
+func (a *advertiser) announcement(ttl uint32) message {
+	additionals := append([]record{a.srv(ttl), a.txt(ttl)}, a.addresses(ttl)...)
+	return message{answers: []record{a.ptr(ttl)}, additionals: additionals}
+}
+
+func (a *advertiser) ptr(ttl uint32) record {
+	return record{
+		header: dnsmessage.ResourceHeader{Name: mustName(serviceName()), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		body:   &dnsmessage.PTRResource{PTR: a.instance},
+	}
+}
+
+func (a *advertiser) srv(ttl uint32) record {
+	return record{
+		header: dnsmessage.ResourceHeader{Name: a.instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl},
+		body:   &dnsmessage.SRVResource{Target: a.host, Port: a.port},
+	}
+}
+
+func (a *advertiser) txt(ttl uint32) record {
+	return record{
+		header: dnsmessage.ResourceHeader{Name: a.instance, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl},
+		body:   &dnsmessage.TXTResource{TXT: a.text},
+	}
+}
+
+func (a *advertiser) addresses(ttl uint32) []record {
+	records := make([]record, 0, len(a.ips))
+	for _, ip := range a.ips {
+		var addr [4]byte
+		copy(addr[:], ip.To4())
+		records = append(records, record{
+			header: dnsmessage.ResourceHeader{Name: a.host, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl},
+			body:   &dnsmessage.AResource{A: addr},
+		})
+	}
+	return records
+}
+
+func (a *advertiser) send(conn *net.UDPConn, to *net.UDPAddr, msg message) {
+	data, err := msg.pack()
+	if err != nil {
+		log.Printf("Failed to build mDNS response: %v", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(data, to); err != nil {
+		log.Printf("Failed to send mDNS response: %v", err)
+	}
+}
+
+// interfaceIPs returns the IPv4 addresses of the interfaces that are up and
+// can multicast, leaving out loopback
+func interfaceIPs() ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+	var ips []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				ips = append(ips, ipNet.IP.To4())
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no network interface with an IPv4 address to advertise")
+	}
+	return ips, nil
+}
+
+// record is a resource to send
+type record struct {
+	header dnsmessage.ResourceHeader
+	body   dnsmessage.ResourceBody
+}
+
+// message is an mDNS response
+type message struct {
+	id          uint16
+	questions   []dnsmessage.Question
+	answers     []record
+	additionals []record
+}
+
+func (m message) pack() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: m.id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range m.questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, r := range m.answers {
+		if err := addRecord(&b, r); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	for _, r := range m.additionals {
+		if err := addRecord(&b, r); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func addRecord(b *dnsmessage.Builder, r record) error {
+	switch body := r.body.(type) {
+	case *dnsmessage.PTRResource:
+		return b.PTRResource(r.header, *body)
+	case *dnsmessage.SRVResource:
+		return b.SRVResource(r.header, *body)
+	case *dnsmessage.TXTResource:
+		return b.TXTResource(r.header, *body)
+	case *dnsmessage.AResource:
+		return b.AResource(r.header, *body)
+	}
+	return fmt.Errorf("unsupported record type %s", r.header.Type)
+}
@@ -0,0 +1,131 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// queryInterval is how long Lookup waits for answers before asking again
+const queryInterval = time.Second
+
+// Lookup browses the local network for servers advertising the service until
+// one answers or ctx is done, and returns the address of the first to answer
+// as host:port
+func Lookup(ctx context.Context) (string, error) {
+	// A query from a port other than 5353 is answered directly, so browsing
+	// works alongside a responder such as Avahi holding that port
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return "", fmt.Errorf("failed to open mDNS socket: %w", err)
+	}
+	defer conn.Close()
+
+	id := uint16(rand.UintN(1 << 16))
+	query, err := browseQuery(id)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		if _, err := conn.WriteToUDP(query, group); err != nil {
+			return "", fmt.Errorf("failed to send mDNS query: %w", err)
+		}
+		deadline := time.Now().Add(queryInterval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				var netErr net.Error
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					return "", fmt.Errorf("failed to read mDNS response: %w", err)
+				}
+				break
+			}
+			if addr := serverAddress(buf[:n], id); addr != "" {
+				return addr, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("no server advertising %s found on the local network", Service)
+		}
+	}
+}
+
+// browseQuery asks for the instances of the service
+func browseQuery(id uint16) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	err := b.Question(dnsmessage.Question{Name: mustName(serviceName()), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	if err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// serverAddress returns the address of the first instance of the service in
+// a response, or empty when it names none or leaves out its SRV record
+func serverAddress(response []byte, id uint16) string {
+	var p dnsmessage.Parser
+	header, err := p.Start(response)
+	if err != nil || !header.Response || header.ID != id {
+		return ""
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return ""
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return ""
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return ""
+	}
+	additionals, _ := p.AllAdditionals()
+
+	var instances []string
+	srvs := make(map[string]dnsmessage.SRVResource)
+	ips := make(map[string]net.IP)
+	for _, r := range append(answers, additionals...) {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == strings.ToLower(serviceName()) && r.Header.TTL > 0 {
+				instances = append(instances, strings.ToLower(body.PTR.String()))
+			}
+		case *dnsmessage.SRVResource:
+			srvs[name] = *body
+		case *dnsmessage.AResource:
+			if _, ok := ips[name]; !ok {
+				ips[name] = net.IP(body.A[:])
+			}
+		}
+	}
+
+	for _, instance := range instances {
+		srv, ok := srvs[instance]
+		if !ok {
+			continue
+		}
+		host := strings.TrimSuffix(srv.Target.String(), ".")
+		if ip, ok := ips[strings.ToLower(srv.Target.String())]; ok {
+			host = ip.String()
+		}
+		return net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))
+	}
+	return ""
+}
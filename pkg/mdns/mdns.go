@@ -0,0 +1,53 @@
+// Package mdns lets agents find the server on the local network without
+// configuring its address: the server advertises itself as a DNS-SD service
+// over multicast DNS, and agents browse for it.
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Service is the DNS-SD service type the server advertises its gRPC port as
+const Service = "_jacuzzi._tcp"
+
+const (
+	domain = "local."
+
+	// servicesName lists the service types on the network, for browsers
+	// such as avahi-browse -a
+	servicesName = "_services._dns-sd._udp.local."
+
+	// cacheFlush marks records only this responder answers for
+	cacheFlush = 1 << 15
+	// unicastResponse is set in the class of questions that ask for a
+	// unicast answer
+	unicastResponse = 1 << 15
+
+	maxPacketSize = 9000
+)
+
+// group is the IPv4 mDNS multicast group
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// serviceName is the fully qualified name of Service
+func serviceName() string {
+	return Service + "." + domain
+}
+
+// mustName converts a name the package built, which is always valid
+func mustName(name string) dnsmessage.Name {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		panic(fmt.Sprintf("mdns: invalid name %q: %v", name, err))
+	}
+	return n
+}
+
+// sameName compares names case-insensitively, as DNS does
+func sameName(a dnsmessage.Name, b string) bool {
+	return strings.EqualFold(a.String(), b)
+}
@@ -0,0 +1,89 @@
+package mdns
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the gRPC target scheme that finds the server with Lookup, as in
+// mdns:///
+const Scheme = "mdns"
+
+const (
+	lookupTimeout = 5 * time.Second
+	retryDelay    = 10 * time.Second
+)
+
+type resolverBuilder struct{}
+
+// NewResolverBuilder returns a gRPC resolver for Scheme targets. It looks the
+// server up again whenever the connection fails, so agents follow a server
+// whose address changes.
+func NewResolverBuilder() resolver.Builder {
+	return resolverBuilder{}
+}
+
+func (resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+func (resolverBuilder) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &mdnsResolver{cc: cc, cancel: cancel, resolve: make(chan struct{}, 1)}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	go r.run(ctx)
+	return r, nil
+}
+
+type mdnsResolver struct {
+	cc      resolver.ClientConn
+	cancel  context.CancelFunc
+	resolve chan struct{}
+}
+
+func (r *mdnsResolver) run(ctx context.Context) {
+	var last string
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.resolve:
+		case <-retry:
+		}
+		retry = nil
+
+		lookupCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		addr, err := Lookup(lookupCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.cc.ReportError(err)
+			retry = time.After(retryDelay)
+			continue
+		}
+		if addr != last {
+			log.Printf("Found server at %s with mDNS", addr)
+			last = addr
+		}
+		if err := r.cc.UpdateState(resolver.State{Addresses: []resolver.Address{{Addr: addr}}}); err != nil {
+			retry = time.After(retryDelay)
+		}
+	}
+}
+
+func (r *mdnsResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolve <- struct{}{}:
+	default:
+	}
+}
+
+func (r *mdnsResolver) Close() {
+	r.cancel()
+}
@@ -0,0 +1,120 @@
+package activity
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Event types, named like the webhook events
+const (
+	ClientRegistered  = "client.registered"
+	ClientOnline      = "client.online"
+	ClientOffline     = "client.offline"
+	ClientMerged      = "client.merged"
+	ClientRenamed     = "client.renamed"
+	SensorRetired     = "sensor.retired"
+	AlertTriggered    = "alert.triggered"
+	AlertResolved     = "alert.resolved"
+	AlertAcknowledged = "alert.acknowledged"
+	SettingsChanged   = "settings.changed"
+	JobFailed         = "job.failed"
+	JobRecovered      = "job.recovered"
+)
+
+// Types lists every event type
+var Types = []string{
+	ClientRegistered,
+	ClientOnline,
+	ClientOffline,
+	ClientMerged,
+	ClientRenamed,
+	SensorRetired,
+	AlertTriggered,
+	AlertResolved,
+	AlertAcknowledged,
+	SettingsChanged,
+	JobFailed,
+	JobRecovered,
+}
+
+// Event is what happened, for Record
+type Event struct {
+	Type     string
+	ClientID string
+	SensorID string
+	RuleID   string
+	AlertID  string
+	Message  string
+	Details  map[string]string
+}
+
+// Record adds an event to the activity log. The log describes what happened
+// rather than being part of it, so a failure is logged instead of returned.
+func Record(db *gorm.DB, event Event) {
+	entry := &models.Event{
+		EventID:   uuid.New().String(),
+		Type:      event.Type,
+		ClientID:  event.ClientID,
+		SensorID:  event.SensorID,
+		RuleID:    event.RuleID,
+		AlertID:   event.AlertID,
+		Message:   event.Message,
+		CreatedAt: time.Now(),
+	}
+	if len(event.Details) > 0 {
+		details, err := json.Marshal(event.Details)
+		if err != nil {
+			log.Printf("Failed to encode details of %s event: %v", event.Type, err)
+		} else {
+			entry.Details = string(details)
+		}
+	}
+	if err := db.Create(entry).Error; err != nil {
+		log.Printf("Failed to record %s event: %v", event.Type, err)
+	}
+}
+
+// alertPrefixes mark messages of events after the trigger, which otherwise
+// repeat the values the alert triggered at
+var alertPrefixes = map[string]string{
+	AlertResolved:     "Resolved: ",
+	AlertAcknowledged: "Acknowledged: ",
+}
+
+// Alert records a change to an alert, noting whether its notifications were
+// skipped
+func Alert(db *gorm.DB, eventType string, alert *models.Alert, details map[string]string) {
+	if details == nil {
+		details = map[string]string{}
+	}
+	details["severity"] = alert.Severity
+	if alert.Muted {
+		details["muted"] = "true"
+	}
+	if alert.Flapping {
+		details["flapping"] = "true"
+	}
+	Record(db, Event{
+		Type:     eventType,
+		ClientID: alert.ClientID,
+		SensorID: alert.SensorID,
+		RuleID:   alert.RuleID,
+		AlertID:  alert.AlertID,
+		Message:  alertPrefixes[eventType] + alert.Message,
+		Details:  details,
+	})
+}
+
+// Client records a change to a client
+func Client(db *gorm.DB, eventType string, client *models.Client, message string) {
+	Record(db, Event{
+		Type:     eventType,
+		ClientID: client.ClientID,
+		Message:  message,
+	})
+}
@@ -0,0 +1,114 @@
+// Package alertcontext keeps the readings around a threshold alert with the
+// alert: every sensor of its client from the minutes before it triggered to
+// the minutes after, at full resolution, so post-mortems have them after raw
+// readings are pruned or only their rollups are left.
+package alertcontext
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ingestLag is how long after a context window ends its readings are
+// captured, so readings still in flight are included
+const ingestLag = time.Minute
+
+// maxReadings bounds the readings kept with one alert, which a burst capture
+// on a client with many sensors could otherwise make very large
+const maxReadings = 50000
+
+// Window sets the context of an alert about to be created: window before and
+// after it triggered. Alerts without a client and sensor, such as those of
+// aggregate rules, get no context.
+func Window(alert *models.Alert, window time.Duration) {
+	if window <= 0 || alert.ClientID == "" || alert.SensorID == "" {
+		return
+	}
+	start := alert.TriggeredAt.Add(-window)
+	end := alert.TriggeredAt.Add(window)
+	alert.ContextStart = &start
+	alert.ContextEnd = &end
+}
+
+// Capture copies the readings of a created alert's context stored so far,
+// those before it triggered
+func Capture(db *gorm.DB, alert *models.Alert) error {
+	if alert.ContextStart == nil || alert.ContextEnd == nil {
+		return nil
+	}
+	return capture(db, alert, alert.TriggeredAt)
+}
+
+// Complete captures the rest of the context of every alert whose window ended
+// by now, and returns how many were completed. An alert whose client was
+// deleted completes with the readings it had.
+func Complete(db *gorm.DB, now time.Time) (int, error) {
+	var alerts []models.Alert
+	err := db.Where("context_complete = ? AND context_end IS NOT NULL AND context_end <= ?", false, now.Add(-ingestLag).UTC()).
+		Find(&alerts).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find alerts awaiting context: %w", err)
+	}
+	for i := range alerts {
+		alert := &alerts[i]
+		if err := capture(db, alert, *alert.ContextEnd); err != nil {
+			return i, err
+		}
+		if err := db.Model(&models.Alert{}).Where("id = ?", alert.ID).Update("context_complete", true).Error; err != nil {
+			return i, fmt.Errorf("failed to complete context of alert %s: %w", alert.AlertID, err)
+		}
+		log.Printf("Captured context of alert %s", alert.AlertID)
+	}
+	return len(alerts), nil
+}
+
+// capture copies the readings of the alert's client from the start of its
+// context to until. Readings already copied are skipped, so the window can
+// be captured again as more readings arrive.
+func capture(db *gorm.DB, alert *models.Alert, until time.Time) error {
+	var copied int64
+	if err := db.Model(&models.AlertContextReading{}).Where("alert_id = ?", alert.AlertID).Count(&copied).Error; err != nil {
+		return fmt.Errorf("failed to count context of alert %s: %w", alert.AlertID, err)
+	}
+	if copied >= maxReadings {
+		return nil
+	}
+
+	var readings []models.TemperatureReading
+	err := db.Where("client_id = ? AND created_at >= ? AND created_at <= ?", alert.ClientID, alert.ContextStart.UTC(), until.UTC()).
+		Order("created_at, id").
+		Limit(maxReadings).
+		Find(&readings).Error
+	if err != nil {
+		return fmt.Errorf("failed to load context of alert %s: %w", alert.AlertID, err)
+	}
+	if len(readings) == 0 {
+		return nil
+	}
+
+	rows := make([]models.AlertContextReading, len(readings))
+	for i, reading := range readings {
+		rows[i] = models.AlertContextReading{
+			AlertID:            alert.AlertID,
+			SensorID:           reading.SensorID,
+			SensorType:         reading.SensorType,
+			SensorName:         reading.SensorName,
+			TemperatureCelsius: reading.TemperatureCelsius,
+			MinCelsius:         reading.MinCelsius,
+			MaxCelsius:         reading.MaxCelsius,
+			AvgCelsius:         reading.AvgCelsius,
+			SampleCount:        reading.SampleCount,
+			Burst:              reading.Burst,
+			ReadingAt:          reading.CreatedAt,
+		}
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500).Error; err != nil {
+		return fmt.Errorf("failed to save context of alert %s: %w", alert.AlertID, err)
+	}
+	return nil
+}
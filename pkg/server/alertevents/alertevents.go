@@ -0,0 +1,85 @@
+// Package alertevents announces alerts as they trigger, resolve and are
+// acknowledged: in the activity log, and on the events hub for StreamAlerts
+// subscribers on every server replica.
+package alertevents
+
+import (
+	"context"
+	"log"
+
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// eventTypes maps activity event types to the streamed ones
+var eventTypes = map[string]alertv1.AlertEventType{
+	activity.AlertTriggered:    alertv1.AlertEventType_ALERT_EVENT_TYPE_TRIGGERED,
+	activity.AlertResolved:     alertv1.AlertEventType_ALERT_EVENT_TYPE_RESOLVED,
+	activity.AlertAcknowledged: alertv1.AlertEventType_ALERT_EVENT_TYPE_ACKNOWLEDGED,
+}
+
+// Publish records a change to an alert in the activity log and publishes it
+// to alert subscribers; a nil hub only records it. The alert must be as it
+// is after the change.
+func Publish(db *gorm.DB, hub *events.Hub, eventType string, alert *models.Alert, details map[string]string) {
+	activity.Alert(db, eventType, alert, details)
+	if hub == nil {
+		return
+	}
+
+	data, err := proto.Marshal(&alertv1.AlertEvent{
+		Type:       eventTypes[eventType],
+		Alert:      ToProto(alert),
+		OccurredAt: timestamppb.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to encode %s event for subscribers: %v", eventType, err)
+		return
+	}
+	if err := hub.Publish(context.Background(), events.TopicAlerts, data); err != nil {
+		log.Printf("Failed to publish %s event to other replicas: %v", eventType, err)
+	}
+}
+
+// ToProto converts a stored alert
+func ToProto(alert *models.Alert) *alertv1.Alert {
+	protoAlert := &alertv1.Alert{
+		Id:          alert.AlertID,
+		RuleId:      alert.RuleID,
+		ClientId:    alert.ClientID,
+		SensorId:    alert.SensorID,
+		Value:       alert.Value,
+		TriggeredAt: timestamppb.New(alert.TriggeredAt),
+		IsActive:    alert.IsActive,
+		Message:     alert.Message,
+		Severity:    ParseSeverity(alert.Severity),
+		Muted:       alert.Muted,
+		Flapping:    alert.Flapping,
+	}
+	if alert.ResolvedAt != nil {
+		protoAlert.ResolvedAt = timestamppb.New(*alert.ResolvedAt)
+	}
+	if alert.AcknowledgedAt != nil {
+		protoAlert.AcknowledgedAt = timestamppb.New(*alert.AcknowledgedAt)
+		protoAlert.AcknowledgedBy = alert.AcknowledgedBy
+	}
+	return protoAlert
+}
+
+// ParseSeverity parses a stored severity string
+func ParseSeverity(severity string) alertv1.Severity {
+	switch severity {
+	case "SEVERITY_INFO":
+		return alertv1.Severity_SEVERITY_INFO
+	case "SEVERITY_WARNING":
+		return alertv1.Severity_SEVERITY_WARNING
+	case "SEVERITY_CRITICAL":
+		return alertv1.Severity_SEVERITY_CRITICAL
+	}
+	return alertv1.Severity_SEVERITY_UNSPECIFIED
+}
@@ -0,0 +1,128 @@
+// Package alertlog writes triggered alerts to logs for the log, syslog and
+// event log actions of their rules: the server's output, the server's own
+// system log, and the logs of the alerts' clients through log_event
+// commands, so log-based alerting pipelines pick up thermal events.
+package alertlog
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	commandv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/command/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/systemlog"
+	"gorm.io/gorm"
+)
+
+// Alert action types handled here
+const (
+	ActionTypeLog      = "ACTION_TYPE_LOG"
+	ActionTypeSyslog   = "ACTION_TYPE_SYSLOG"
+	ActionTypeEventLog = "ACTION_TYPE_EVENT_LOG"
+)
+
+// EventIDTriggered is the event ID of triggered alerts
+const EventIDTriggered = 100
+
+// EventLogTTL is how long a log_event command waits for its client to poll
+const EventLogTTL = 10 * time.Minute
+
+// levels maps alert severities to system log levels
+var levels = map[string]systemlog.Level{
+	"SEVERITY_INFO":     systemlog.Info,
+	"SEVERITY_WARNING":  systemlog.Warning,
+	"SEVERITY_CRITICAL": systemlog.Critical,
+}
+
+// Run writes a triggered alert to the server's output for each log action of
+// its rule and to the server's system log for each syslog action, and queues a log_event command on the alert's client for each
+// event log action. Clients that do not accept log_event commands are
+// skipped, since each client opts in.
+func Run(db *gorm.DB, rule models.AlertRule, alert *models.Alert) {
+	var client *models.Client
+	for _, action := range rule.Actions {
+		if action.Type == ActionTypeLog {
+			log.Printf("Alert %s of rule %s (%s): %s", alert.AlertID, rule.RuleID, alert.Severity, alert.Message)
+			continue
+		}
+		if action.Type != ActionTypeSyslog && action.Type != ActionTypeEventLog {
+			continue
+		}
+		var config map[string]string
+		json.Unmarshal([]byte(action.Config), &config)
+		entry := Entry(rule, alert)
+
+		if action.Type == ActionTypeSyslog {
+			entry.Source, entry.Facility = config["tag"], config["facility"]
+			if err := systemlog.Write(entry); err != nil {
+				log.Printf("Failed to write alert %s to the system log: %v", alert.AlertID, err)
+			}
+			continue
+		}
+
+		if alert.ClientID == "" {
+			continue
+		}
+		if client == nil {
+			client = &models.Client{}
+			if err := db.Where("client_id = ?", alert.ClientID).First(client).Error; err != nil {
+				log.Printf("Skipping event log actions of rule %s for alert %s: failed to get client %s: %v", rule.RuleID, alert.AlertID, alert.ClientID, err)
+				return
+			}
+		}
+		if !slices.Contains(commands.DecodeList(client.Capabilities), models.CapabilityEventLog) {
+			log.Printf("Skipping event log action of rule %s for alert %s: client %s does not accept log_event commands", rule.RuleID, alert.AlertID, alert.ClientID)
+			continue
+		}
+		command := &commandv1.Command{Action: &commandv1.Command_LogEvent{LogEvent: &commandv1.LogEventCommand{
+			Source:  config["source"],
+			Level:   commandLevel(entry.Level),
+			EventId: entry.EventID,
+			Message: entry.Message,
+			Fields:  entry.Fields,
+		}}}
+		if _, err := commands.Queue(db, alert.ClientID, models.CommandTypeLogEvent, command, EventLogTTL, models.CommandSourceRule+rule.RuleID); err != nil {
+			log.Printf("Failed to queue event log action of rule %s for alert %s: %v", rule.RuleID, alert.AlertID, err)
+		}
+	}
+}
+
+// Entry describes a triggered alert, with its message and, as fields, what
+// log pipelines match on
+func Entry(rule models.AlertRule, alert *models.Alert) systemlog.Entry {
+	fields := map[string]string{
+		"alert_id":     alert.AlertID,
+		"rule_id":      rule.RuleID,
+		"rule_name":    rule.Name,
+		"severity":     strings.ToLower(strings.TrimPrefix(alert.Severity, "SEVERITY_")),
+		"value":        strconv.FormatFloat(alert.Value, 'f', -1, 64),
+		"triggered_at": alert.TriggeredAt.UTC().Format(time.RFC3339),
+	}
+	if alert.ClientID != "" {
+		fields["client_id"] = alert.ClientID
+	}
+	if alert.SensorID != "" {
+		fields["sensor_id"] = alert.SensorID
+	}
+	return systemlog.Entry{
+		Level:   levels[alert.Severity],
+		EventID: EventIDTriggered,
+		Message: alert.Message,
+		Fields:  fields,
+	}
+}
+
+func commandLevel(level systemlog.Level) commandv1.LogEventCommand_Level {
+	switch level {
+	case systemlog.Critical:
+		return commandv1.LogEventCommand_LEVEL_CRITICAL
+	case systemlog.Warning:
+		return commandv1.LogEventCommand_LEVEL_WARNING
+	}
+	return commandv1.LogEventCommand_LEVEL_INFO
+}
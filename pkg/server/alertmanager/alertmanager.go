@@ -0,0 +1,259 @@
+// Package alertmanager forwards alerts to Prometheus Alertmanager through its
+// v2 API, so its routing, grouping, inhibition and silences apply to thermal
+// alerts too.
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Config is where alerts are sent and how they are labelled
+type Config struct {
+	// Alertmanager base URLs, e.g. http://alertmanager:9093; every replica of
+	// a cluster should be listed, since each alert goes to all of them
+	URLs []string
+	// Basic auth credentials, or a bearer token, for a proxy in front
+	Username    string
+	Password    string
+	BearerToken string
+	// CA bundle the certificates are checked against; empty uses the system
+	// roots
+	CAFile             string
+	InsecureSkipVerify bool
+	// Labels added to every alert, e.g. env: prod; an alert's own labels win
+	Labels map[string]string
+	// URL of the Jacuzzi UI, linked from each alert as its generator URL
+	ExternalURL string
+	// How often alerts are sent; active alerts end three intervals after
+	// each send, so Alertmanager resolves them if the server stops sending
+	Interval time.Duration
+}
+
+// labelName is the pattern of label names Alertmanager accepts
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidLabelName reports whether Alertmanager accepts a label name
+func ValidLabelName(name string) bool {
+	return labelName.MatchString(name)
+}
+
+// postableAlert is an alert in the v2 API
+type postableAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Forwarder periodically sends the active alerts to Alertmanager, along with
+// those resolved since the last send so they resolve there at once. Alerts
+// raised while muted or flapping are left out, as they are from every other
+// notification.
+type Forwarder struct {
+	db   *gorm.DB
+	cfg  Config
+	http *http.Client
+	// Alerts resolved since then are sent as resolved; zero until the first
+	// send that reached every URL
+	sent time.Time
+}
+
+func New(db *gorm.DB, cfg Config, timeout time.Duration) (*Forwarder, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("no alertmanager urls are configured")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	for i, u := range cfg.URLs {
+		cfg.URLs[i] = strings.TrimSuffix(u, "/")
+	}
+	cfg.ExternalURL = strings.TrimSuffix(cfg.ExternalURL, "/")
+	return &Forwarder{
+		db:  db,
+		cfg: cfg,
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+// RunOnce sends the active and newly resolved alerts to every Alertmanager
+func (f *Forwarder) RunOnce(ctx context.Context) error {
+	now := time.Now()
+	since := f.sent
+	if since.IsZero() {
+		// Alerts resolved while the server was down, or while another
+		// replica was leader, still resolve at once
+		since = now.Add(-3 * f.cfg.Interval)
+	}
+	db := f.db.WithContext(ctx)
+	var alerts []models.Alert
+	// Alerts received from Alertmanager would come back to it again
+	err := db.Where("muted = ? AND flapping = ? AND external_id = ?", false, false, "").
+		Where("is_active = ? OR resolved_at >= ?", true, since.UTC()).
+		Order("triggered_at").
+		Find(&alerts).Error
+	if err != nil {
+		return fmt.Errorf("failed to load alerts: %w", err)
+	}
+	if len(alerts) == 0 {
+		f.sent = now
+		return nil
+	}
+
+	postable, err := f.postable(db, alerts, now)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(postable)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, u := range f.cfg.URLs {
+		if err := f.post(ctx, u, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u, err))
+		}
+	}
+	if len(errs) > 0 {
+		// Resolved alerts are sent again next time
+		return errors.Join(errs...)
+	}
+	f.sent = now
+	log.Printf("Alertmanager: sent %d alerts to %d instances", len(postable), len(f.cfg.URLs))
+	return nil
+}
+
+// postable converts alerts, labelling them with their rules and clients
+func (f *Forwarder) postable(db *gorm.DB, alerts []models.Alert, now time.Time) ([]postableAlert, error) {
+	ruleIDs := make([]string, 0, len(alerts))
+	clientIDs := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		ruleIDs = append(ruleIDs, alert.RuleID)
+		clientIDs = append(clientIDs, alert.ClientID)
+	}
+	// Deleted rules still name their alerts
+	var rules []models.AlertRule
+	if err := db.Unscoped().Where("rule_id IN ?", ruleIDs).Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to load alert rules: %w", err)
+	}
+	ruleByID := make(map[string]*models.AlertRule, len(rules))
+	for i := range rules {
+		ruleByID[rules[i].RuleID] = &rules[i]
+	}
+	var clients []models.Client
+	if err := db.Where("client_id IN ?", clientIDs).Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	clientByID := make(map[string]*models.Client, len(clients))
+	for i := range clients {
+		clientByID[clients[i].ClientID] = &clients[i]
+	}
+
+	postable := make([]postableAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		labels := make(map[string]string, len(f.cfg.Labels)+8)
+		for name, value := range f.cfg.Labels {
+			labels[name] = value
+		}
+		set := func(name, value string) {
+			// Empty labels are the same as missing ones to Alertmanager
+			if value != "" {
+				labels[name] = value
+			}
+		}
+		annotations := map[string]string{
+			"summary":  alert.Message,
+			"value":    strconv.FormatFloat(alert.Value, 'f', -1, 64),
+			"alert_id": alert.AlertID,
+		}
+
+		set("alertname", "JacuzziAlert")
+		if rule := ruleByID[alert.RuleID]; rule != nil {
+			set("alertname", rule.Name)
+			if rule.Description != "" {
+				annotations["description"] = rule.Description
+			}
+		}
+		set("severity", strings.ToLower(strings.TrimPrefix(alert.Severity, "SEVERITY_")))
+		set("rule_id", alert.RuleID)
+		set("client_id", alert.ClientID)
+		set("sensor_id", alert.SensorID)
+		if client := clientByID[alert.ClientID]; client != nil {
+			set("hostname", client.Hostname)
+			set("site", client.Site)
+			set("rack", client.Rack)
+		}
+		if alert.AcknowledgedAt != nil {
+			annotations["acknowledged_by"] = alert.AcknowledgedBy
+		}
+
+		p := postableAlert{
+			Labels:      labels,
+			Annotations: annotations,
+			StartsAt:    alert.TriggeredAt.UTC(),
+			EndsAt:      now.Add(3 * f.cfg.Interval).UTC(),
+		}
+		if alert.ResolvedAt != nil {
+			p.EndsAt = alert.ResolvedAt.UTC()
+		}
+		if f.cfg.ExternalURL != "" {
+			p.GeneratorURL = f.cfg.ExternalURL + "/alerts"
+		}
+		postable = append(postable, p)
+	}
+	return postable, nil
+}
+
+func (f *Forwarder) post(ctx context.Context, baseURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/api/v2/alerts", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case f.cfg.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+f.cfg.BearerToken)
+	case f.cfg.Username != "":
+		req.SetBasicAuth(f.cfg.Username, f.cfg.Password)
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
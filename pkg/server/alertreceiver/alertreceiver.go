@@ -0,0 +1,364 @@
+// Package alertreceiver records alerts sent by Prometheus Alertmanager and
+// Grafana as Jacuzzi alerts of the clients they are about, so each machine
+// has one alert timeline whatever raised the alert.
+package alertreceiver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertevents"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/mute"
+	"gorm.io/gorm"
+)
+
+// Path is where alerts are received
+const Path = "/webhooks/alerts"
+
+// maxBody caps the size of a notification; Alertmanager sends a whole group
+// of alerts at once
+const maxBody = 4 << 20
+
+// hostLabels name the machine an alert is about, in the order they are
+// tried when there is no client_id label. Their values may carry a port, as
+// Prometheus' instance label does.
+var hostLabels = []string{"hostname", "instance", "host", "node", "nodename"}
+
+// Notification is the webhook body of Alertmanager, which Grafana's webhook
+// contact point sends too
+type Notification struct {
+	Receiver string  `json:"receiver"`
+	Status   string  `json:"status"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// Alert is an alert of a notification
+type Alert struct {
+	Status       string             `json:"status"` // firing or resolved
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	StartsAt     time.Time          `json:"startsAt"`
+	EndsAt       time.Time          `json:"endsAt"`
+	GeneratorURL string             `json:"generatorURL"`
+	Fingerprint  string             `json:"fingerprint"`
+	Values       map[string]float64 `json:"values"` // Grafana only, by query
+}
+
+// Result is the response to a notification
+type Result struct {
+	Recorded  int `json:"recorded"`  // Alerts that triggered
+	Resolved  int `json:"resolved"`  // Alerts that resolved
+	Unchanged int `json:"unchanged"` // Alerts already recorded, or resolved ones never recorded
+	Unmatched int `json:"unmatched"` // Alerts about no known client
+	Ignored   int `json:"ignored"`   // Firing alerts whose rule is disabled or deleted
+}
+
+// Handler receives notifications. Senders authenticate with the token as a
+// bearer token.
+type Handler struct {
+	db    *gorm.DB
+	hub   *events.Hub
+	token string
+}
+
+// NewHandler creates a handler publishing to hub, which may be nil; an empty
+// token accepts every sender, so the handler must then be wrapped with the
+// server's auth check
+func NewHandler(db *gorm.DB, hub *events.Hub, token string) *Handler {
+	return &Handler{db: db, hub: hub, token: token}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var notification Notification
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(&notification); err != nil {
+		http.Error(w, "invalid notification: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := h.Receive(&notification)
+	if err != nil {
+		log.Printf("Alert receiver: %v", err)
+		// Senders retry failed notifications
+		http.Error(w, "failed to record alerts", http.StatusInternalServerError)
+		return
+	}
+	if result.Unmatched > 0 {
+		log.Printf("Alert receiver: %d alerts from %s matched no client", result.Unmatched, sender(&notification))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Receive records the alerts of a notification
+func (h *Handler) Receive(notification *Notification) (*Result, error) {
+	var clients []models.Client
+	if err := h.db.Where("status = ?", models.ClientStatusApproved).Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+
+	result := &Result{}
+	now := time.Now()
+	for i := range notification.Alerts {
+		alert := &notification.Alerts[i]
+		client := matchClient(clients, alert.Labels)
+		if client == nil {
+			result.Unmatched++
+			continue
+		}
+		rule, err := h.ensureRule(alert.Labels["alertname"])
+		if err != nil {
+			return nil, err
+		}
+		if alert.Status != "resolved" && (!rule.Enabled || rule.DeletedAt.Valid) {
+			// Alerts already recorded still resolve
+			result.Ignored++
+			continue
+		}
+		fingerprint := alert.Fingerprint
+		if fingerprint == "" {
+			fingerprint = labelsFingerprint(alert.Labels)
+		}
+
+		var open models.Alert
+		err = h.db.Where("rule_id = ? AND client_id = ? AND external_id = ? AND is_active = ?", rule.RuleID, client.ClientID, fingerprint, true).
+			Limit(1).
+			Find(&open).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load alerts of rule %s: %w", rule.RuleID, err)
+		}
+
+		switch {
+		case alert.Status == "resolved" && open.ID != 0:
+			if err := h.resolve(&open, alert, now); err != nil {
+				return nil, err
+			}
+			result.Resolved++
+		case alert.Status != "resolved" && open.ID == 0:
+			if err := h.trigger(rule, client, fingerprint, alert, notification, now); err != nil {
+				return nil, err
+			}
+			result.Recorded++
+		default:
+			// Firing alerts are sent again every repeat interval
+			result.Unchanged++
+		}
+	}
+	return result, nil
+}
+
+func (h *Handler) trigger(rule *models.AlertRule, client *models.Client, fingerprint string, alert *Alert, notification *Notification, now time.Time) error {
+	triggeredAt := alert.StartsAt
+	if triggeredAt.IsZero() || triggeredAt.After(now) {
+		triggeredAt = now
+	}
+	severity := parseSeverity(alert.Labels["severity"])
+	if severity == "" {
+		severity = rule.Severity
+	}
+	message := rule.Name
+	if summary := firstAnnotation(alert.Annotations, "summary", "description", "message"); summary != "" {
+		message += ": " + summary
+	}
+	record := &models.Alert{
+		AlertID:     uuid.New().String(),
+		RuleID:      rule.RuleID,
+		ClientID:    client.ClientID,
+		SensorID:    alert.Labels["sensor_id"],
+		Value:       value(alert),
+		TriggeredAt: triggeredAt,
+		IsActive:    true,
+		Severity:    severity,
+		Message:     message,
+		Muted:       mute.Silenced(h.db, *rule, now),
+		ExternalID:  fingerprint,
+	}
+	if err := h.db.Create(record).Error; err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	log.Printf("Recorded alert %s from %s for client %s", record.AlertID, sender(notification), client.ClientID)
+	details := map[string]string{"source": sender(notification)}
+	if alert.GeneratorURL != "" {
+		details["generator_url"] = alert.GeneratorURL
+	}
+	alertevents.Publish(h.db, h.hub, activity.AlertTriggered, record, details)
+	return nil
+}
+
+func (h *Handler) resolve(open *models.Alert, alert *Alert, now time.Time) error {
+	resolvedAt := alert.EndsAt
+	if resolvedAt.IsZero() || resolvedAt.After(now) || resolvedAt.Before(open.TriggeredAt) {
+		resolvedAt = now
+	}
+	err := h.db.Model(&models.Alert{}).
+		Where("id = ?", open.ID).
+		Updates(map[string]interface{}{"is_active": false, "resolved_at": &resolvedAt}).Error
+	if err != nil {
+		return fmt.Errorf("failed to resolve alert %s: %w", open.AlertID, err)
+	}
+	log.Printf("Resolved received alert %s for client %s", open.AlertID, open.ClientID)
+	open.IsActive = false
+	open.ResolvedAt = &resolvedAt
+	alertevents.Publish(h.db, h.hub, activity.AlertResolved, open, nil)
+	return nil
+}
+
+// ensureRule returns the external rule of an alert name, deleted or not,
+// creating it the first time the name is received
+func (h *Handler) ensureRule(name string) (*models.AlertRule, error) {
+	if name == "" {
+		name = "External alert"
+	}
+	sum := sha256.Sum256([]byte(name))
+	rule := models.AlertRule{
+		RuleID:        "external-" + hex.EncodeToString(sum[:8]),
+		Name:          name,
+		Description:   "Alerts named " + name + " received from Alertmanager or Grafana",
+		ConditionType: models.ConditionTypeExternal,
+		Operator:      "OPERATOR_UNSPECIFIED",
+		Severity:      "SEVERITY_WARNING",
+		Enabled:       true,
+	}
+	// Deleted rules count as existing, so their alerts stay ignored
+	if err := h.db.Unscoped().Where("rule_id = ?", rule.RuleID).FirstOrCreate(&rule).Error; err != nil {
+		return nil, fmt.Errorf("failed to create rule for %s: %w", name, err)
+	}
+	return &rule, nil
+}
+
+// matchClient finds the client an alert's labels name, by client ID or by
+// the hostname or IP address of a host label
+func matchClient(clients []models.Client, labels map[string]string) *models.Client {
+	if id := labels["client_id"]; id != "" {
+		for i := range clients {
+			if clients[i].ClientID == id {
+				return &clients[i]
+			}
+		}
+	}
+	for _, label := range hostLabels {
+		host := labels[label]
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if host == "" {
+			continue
+		}
+		if client := matchHost(clients, host); client != nil {
+			return client
+		}
+	}
+	return nil
+}
+
+// matchHost finds a client by IP address, full hostname, or short hostname
+// when only one side is qualified
+func matchHost(clients []models.Client, host string) *models.Client {
+	short, _, _ := strings.Cut(host, ".")
+	isIP := net.ParseIP(host) != nil
+	var candidate *models.Client
+	for i := range clients {
+		client := &clients[i]
+		if isIP {
+			if client.IPAddress == host {
+				return client
+			}
+			continue
+		}
+		hostname := strings.ToLower(client.Hostname)
+		if hostname == host {
+			return client
+		}
+		clientShort, _, _ := strings.Cut(hostname, ".")
+		if candidate == nil && clientShort == short && (clientShort == hostname || short == host) {
+			candidate = client
+		}
+	}
+	return candidate
+}
+
+// parseSeverity maps the severity label's common values to a stored
+// severity, or "" for others
+func parseSeverity(label string) string {
+	switch strings.ToLower(label) {
+	case "critical", "crit", "page", "high", "error", "emergency":
+		return "SEVERITY_CRITICAL"
+	case "warning", "warn", "medium", "major", "minor":
+		return "SEVERITY_WARNING"
+	case "info", "information", "low", "none":
+		return "SEVERITY_INFO"
+	}
+	return ""
+}
+
+// value is the alert's value annotation, or the value of a Grafana alert
+// with a single query, or else 0
+func value(alert *Alert) float64 {
+	if v, err := strconv.ParseFloat(alert.Annotations["value"], 64); err == nil {
+		return v
+	}
+	if len(alert.Values) == 1 {
+		for _, v := range alert.Values {
+			return v
+		}
+	}
+	return 0
+}
+
+func firstAnnotation(annotations map[string]string, names ...string) string {
+	for _, name := range names {
+		if v := strings.TrimSpace(annotations[name]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// labelsFingerprint identifies an alert by its labels, for senders that
+// give no fingerprint
+func labelsFingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\xff%s\xff", key, labels[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+// sender names where a notification came from
+func sender(notification *Notification) string {
+	if notification.Receiver != "" {
+		return "receiver " + notification.Receiver
+	}
+	return "an external sender"
+}
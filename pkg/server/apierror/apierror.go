@@ -0,0 +1,161 @@
+// Package apierror turns server failures into errors safe to return to API
+// callers: a gRPC code, a stable reason and a message free of SQL or other
+// internals, which are logged instead.
+package apierror
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Domain of the ErrorInfo details attached to every error
+const Domain = "jacuzzi.v1"
+
+// Reasons in the ErrorInfo details, stable for clients to match on. Errors
+// the services raise themselves carry the reason of their code.
+var reasons = map[codes.Code]string{
+	codes.Canceled:           "CANCELED",
+	codes.Unknown:            "UNKNOWN",
+	codes.InvalidArgument:    "INVALID_ARGUMENT",
+	codes.DeadlineExceeded:   "DEADLINE_EXCEEDED",
+	codes.NotFound:           "NOT_FOUND",
+	codes.AlreadyExists:      "ALREADY_EXISTS",
+	codes.PermissionDenied:   "PERMISSION_DENIED",
+	codes.ResourceExhausted:  "RESOURCE_EXHAUSTED",
+	codes.FailedPrecondition: "FAILED_PRECONDITION",
+	codes.Aborted:            "ABORTED",
+	codes.OutOfRange:         "OUT_OF_RANGE",
+	codes.Unimplemented:      "UNIMPLEMENTED",
+	codes.Internal:           "INTERNAL",
+	codes.Unavailable:        "UNAVAILABLE",
+	codes.DataLoss:           "DATA_LOSS",
+	codes.Unauthenticated:    "UNAUTHENTICATED",
+}
+
+// Reasons of database failures, more specific than their codes
+const (
+	ReasonRecordNotFound  = "RECORD_NOT_FOUND"
+	ReasonDuplicate       = "DUPLICATE_RECORD"
+	ReasonForeignKey      = "REFERENCED_RECORD"
+	ReasonDatabaseTimeout = "DATABASE_TIMEOUT"
+)
+
+// Error is a failure safe to return to callers. It converts to a gRPC status
+// with ErrorInfo details, and its Error is only the safe message.
+type Error struct {
+	Code    codes.Code
+	Reason  string
+	Message string
+	ErrorID string // Set when the cause was logged, to find the log line
+	cause   error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// GRPCStatus lets status.FromError and gRPC servers use the error
+func (e *Error) GRPCStatus() *status.Status {
+	info := &errdetails.ErrorInfo{Reason: e.Reason, Domain: Domain}
+	if e.ErrorID != "" {
+		info.Metadata = map[string]string{"error_id": e.ErrorID}
+	}
+	st := status.New(e.Code, e.Message)
+	if withDetails, err := st.WithDetails(info); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// Wrap returns err as an Error whose message is message, e.g. "failed to list
+// events". Missing and duplicate records, foreign key violations and ended
+// contexts get codes matching their cause; status errors are kept as they
+// are; anything else is Internal, with err logged under an error ID that is
+// returned to the caller.
+func Wrap(err error, message string) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return &Error{Code: codes.NotFound, Reason: ReasonRecordNotFound, Message: message + ": not found", cause: err}
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return &Error{Code: codes.AlreadyExists, Reason: ReasonDuplicate, Message: message + ": already exists", cause: err}
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return &Error{Code: codes.FailedPrecondition, Reason: ReasonForeignKey, Message: message + ": a record it refers to is missing or still in use", cause: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: codes.DeadlineExceeded, Reason: ReasonDatabaseTimeout, Message: message + ": timed out", cause: err}
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: codes.Canceled, Reason: reasons[codes.Canceled], Message: message + ": canceled", cause: err}
+	}
+
+	id := newErrorID()
+	log.Printf("Error %s: %s: %v", id, message, err)
+	return &Error{
+		Code:    codes.Internal,
+		Reason:  reasons[codes.Internal],
+		Message: message + " (error ID " + id + ")",
+		ErrorID: id,
+		cause:   err,
+	}
+}
+
+// Normalize readies an RPC's error for its caller: errors that are not
+// statuses are hidden behind an internal error, and statuses without details
+// gain ErrorInfo with the reason of their code
+func Normalize(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		id := newErrorID()
+		log.Printf("Error %s: %v", id, err)
+		return &Error{
+			Code:    codes.Internal,
+			Reason:  reasons[codes.Internal],
+			Message: "internal server error (error ID " + id + ")",
+			ErrorID: id,
+			cause:   err,
+		}
+	}
+	if len(st.Details()) > 0 {
+		return err
+	}
+	reason, ok := reasons[st.Code()]
+	if !ok {
+		return err
+	}
+	withDetails, detailsErr := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: Domain})
+	if detailsErr != nil {
+		return err
+	}
+	return withDetails.Err()
+}
+
+// newErrorID returns a short random ID to match an error to its log line
+func newErrorID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
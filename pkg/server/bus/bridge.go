@@ -0,0 +1,203 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+	"github.com/nickheyer/jacuzzi/pkg/server/nats"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Serialization formats for bus messages
+const (
+	FormatProtobuf = "protobuf"
+	FormatJSON     = "json"
+)
+
+// Alert events, published to <prefix>.alerts.<event>
+const (
+	AlertAcknowledged = "acknowledged"
+)
+
+const (
+	queueSize      = 10000            // Outgoing messages buffered while the bus is slow or down
+	consumeBatch   = 500              // Maximum consumed readings per submission
+	consumeFlush   = time.Second      // Longest a consumed reading waits to be submitted
+	consumeGroup   = "jacuzzi-server" // Queue group so each reading is ingested by one replica
+	publishTimeout = 2 * time.Second
+)
+
+var droppedMessages = metrics.NewCounterVec(
+	"jacuzzi_bus_messages_dropped_total",
+	"Message bus messages that could not be published or ingested.",
+	"subject", "reason",
+)
+
+// Config configures the message bus integration
+type Config struct {
+	URL            string // nats://[user:password@]host:port
+	SubjectPrefix  string // Readings go to <prefix>.readings, alerts to <prefix>.alerts.<event>
+	Format         string // protobuf or json
+	ConsumeSubject string // Subject to ingest readings from; empty disables consuming
+}
+
+// SubmitFunc stores readings consumed from the bus
+type SubmitFunc func(ctx context.Context, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error)
+
+// Bridge publishes accepted readings and alert events to NATS and optionally
+// ingests readings published by other systems. Publishing is asynchronous so
+// a slow bus never delays ingestion; messages are dropped when the queue fills.
+type Bridge struct {
+	cfg      Config
+	client   *nats.Client
+	outgoing chan message
+	incoming chan *temperaturev1.TemperatureReading
+}
+
+type message struct {
+	subject string
+	msg     proto.Message
+}
+
+func NewBridge(cfg Config) (*Bridge, error) {
+	client, err := nats.NewClient(cfg.URL, "jacuzzi-server bus")
+	if err != nil {
+		return nil, err
+	}
+	return &Bridge{
+		cfg:      cfg,
+		client:   client,
+		outgoing: make(chan message, queueSize),
+		incoming: make(chan *temperaturev1.TemperatureReading, consumeBatch*2),
+	}, nil
+}
+
+// PublishReadings queues each reading for <prefix>.readings
+func (b *Bridge) PublishReadings(readings []*temperaturev1.TemperatureReading) {
+	subject := b.cfg.SubjectPrefix + ".readings"
+	for _, reading := range readings {
+		b.enqueue(subject, reading)
+	}
+}
+
+// PublishAlert queues an alert event for <prefix>.alerts.<event>
+func (b *Bridge) PublishAlert(event string, alert *alertv1.Alert) {
+	b.enqueue(b.cfg.SubjectPrefix+".alerts."+event, alert)
+}
+
+func (b *Bridge) enqueue(subject string, msg proto.Message) {
+	select {
+	case b.outgoing <- message{subject: subject, msg: msg}:
+	default:
+		droppedMessages.Inc(subject, "queue_full")
+	}
+}
+
+// Run connects to the bus and publishes queued messages until ctx is done.
+// Consumed readings are stored with submit.
+func (b *Bridge) Run(ctx context.Context, submit SubmitFunc) {
+	if b.cfg.ConsumeSubject != "" {
+		b.client.QueueSubscribe(b.cfg.ConsumeSubject, consumeGroup, b.receive)
+		go b.consume(ctx, submit)
+	}
+	go b.client.Run(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-b.outgoing:
+			data, err := b.encode(m.msg)
+			if err != nil {
+				log.Printf("Failed to encode message for %s: %v", m.subject, err)
+				continue
+			}
+			pubCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+			err = b.client.Publish(pubCtx, m.subject, data)
+			cancel()
+			if err != nil {
+				droppedMessages.Inc(m.subject, "publish_failed")
+			}
+		}
+	}
+}
+
+// receive decodes a consumed reading on the connection's read loop
+func (b *Bridge) receive(subject string, data []byte) {
+	reading := &temperaturev1.TemperatureReading{}
+	if err := b.decode(data, reading); err != nil {
+		droppedMessages.Inc(subject, "invalid")
+		return
+	}
+	select {
+	case b.incoming <- reading:
+	default:
+		droppedMessages.Inc(subject, "queue_full")
+	}
+}
+
+// consume submits consumed readings in batches
+func (b *Bridge) consume(ctx context.Context, submit SubmitFunc) {
+	ticker := time.NewTicker(consumeFlush)
+	defer ticker.Stop()
+
+	var batch []*temperaturev1.TemperatureReading
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// Submit each client separately so one rejected client does not
+		// fail readings from the others
+		byClient := make(map[string][]*temperaturev1.TemperatureReading)
+		for _, reading := range batch {
+			byClient[reading.ClientId] = append(byClient[reading.ClientId], reading)
+		}
+		for clientID, readings := range byClient {
+			req := &temperaturev1.SubmitTemperatureRequest{Readings: readings}
+			if _, err := submit(ctx, req); err != nil {
+				log.Printf("Failed to store %d readings for client %s from %s: %v", len(readings), clientID, b.cfg.ConsumeSubject, err)
+			}
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reading := <-b.incoming:
+			batch = append(batch, reading)
+			if len(batch) >= consumeBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (b *Bridge) encode(msg proto.Message) ([]byte, error) {
+	switch b.cfg.Format {
+	case FormatJSON:
+		return protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	case FormatProtobuf:
+		return proto.Marshal(msg)
+	}
+	return nil, fmt.Errorf("unknown format %q", b.cfg.Format)
+}
+
+func (b *Bridge) decode(data []byte, msg proto.Message) error {
+	switch b.cfg.Format {
+	case FormatJSON:
+		return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, msg)
+	case FormatProtobuf:
+		return proto.Unmarshal(data, msg)
+	}
+	return fmt.Errorf("unknown format %q", b.cfg.Format)
+}
@@ -0,0 +1,464 @@
+package chart
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Point is one value of a series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a named line on the chart. Points must be in time order.
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+// Theme is the color scheme of a chart
+type Theme string
+
+const (
+	ThemeLight Theme = "light"
+	ThemeDark  Theme = "dark"
+	// ThemeMono is pure black on white for e-ink displays; series are told
+	// apart by dash pattern instead of color
+	ThemeMono Theme = "mono"
+)
+
+// Options control how a chart is drawn
+type Options struct {
+	Width, Height int
+	Title         string
+	Unit          string // Appended to axis labels, e.g. "°C"
+	Start, End    time.Time
+	Location      *time.Location // For time axis labels; nil means UTC
+	Theme         Theme
+}
+
+type palette struct {
+	background color.Color
+	text       color.Color
+	grid       color.Color
+	series     []color.Color
+}
+
+var palettes = map[Theme]palette{
+	ThemeLight: {
+		background: color.White,
+		text:       color.RGBA{0x1f, 0x23, 0x28, 0xff},
+		grid:       color.RGBA{0xd0, 0xd7, 0xde, 0xff},
+		series: []color.Color{
+			color.RGBA{0x09, 0x69, 0xda, 0xff},
+			color.RGBA{0xcf, 0x22, 0x2e, 0xff},
+			color.RGBA{0x1a, 0x7f, 0x37, 0xff},
+			color.RGBA{0x82, 0x50, 0xdf, 0xff},
+			color.RGBA{0xbc, 0x4c, 0x00, 0xff},
+			color.RGBA{0x1b, 0x7c, 0x83, 0xff},
+			color.RGBA{0xbf, 0x39, 0x89, 0xff},
+			color.RGBA{0x9a, 0x67, 0x00, 0xff},
+		},
+	},
+	ThemeDark: {
+		background: color.RGBA{0x0d, 0x11, 0x17, 0xff},
+		text:       color.RGBA{0xe6, 0xed, 0xf3, 0xff},
+		grid:       color.RGBA{0x30, 0x36, 0x3d, 0xff},
+		series: []color.Color{
+			color.RGBA{0x58, 0xa6, 0xff, 0xff},
+			color.RGBA{0xff, 0x7b, 0x72, 0xff},
+			color.RGBA{0x3f, 0xb9, 0x50, 0xff},
+			color.RGBA{0xbc, 0x8c, 0xff, 0xff},
+			color.RGBA{0xff, 0xa6, 0x57, 0xff},
+			color.RGBA{0x39, 0xc5, 0xcf, 0xff},
+			color.RGBA{0xf7, 0x78, 0xba, 0xff},
+			color.RGBA{0xd2, 0x99, 0x22, 0xff},
+		},
+	},
+	ThemeMono: {
+		background: color.White,
+		text:       color.Black,
+		grid:       color.Black,
+		series:     []color.Color{color.Black},
+	},
+}
+
+// Dash patterns for mono charts, as on/off pixel runs; nil is solid
+var dashes = [][]int{nil, {8, 4}, {2, 3}, {8, 3, 2, 3}}
+
+const (
+	marginLeft   = 64
+	marginRight  = 16
+	marginTop    = 28
+	marginBottom = 28
+	charWidth    = 7 // basicfont.Face7x13
+	charHeight   = 13
+	maxGapFactor = 5 // A gap this many times the typical interval breaks the line
+)
+
+// ValidTheme reports whether t is a known theme
+func ValidTheme(t Theme) bool {
+	_, ok := palettes[t]
+	return ok
+}
+
+// Render draws the series as a line chart and writes it as PNG
+func Render(w io.Writer, series []Series, opts Options) error {
+	if opts.Width < marginLeft+marginRight+50 || opts.Height < marginTop+marginBottom+50 {
+		return fmt.Errorf("chart size %dx%d is too small", opts.Width, opts.Height)
+	}
+	if !opts.End.After(opts.Start) {
+		return fmt.Errorf("chart end must be after start")
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	pal, ok := palettes[opts.Theme]
+	if !ok {
+		pal = palettes[ThemeLight]
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(pal.background), image.Point{}, draw.Src)
+	c := &canvas{img: img, pal: pal, opts: opts}
+	c.plot = image.Rect(marginLeft, marginTop, opts.Width-marginRight, opts.Height-marginBottom)
+
+	c.text(marginLeft, marginTop-10, opts.Title, pal.text)
+
+	lo, hi, ok := valueRange(series)
+	if !ok {
+		c.frame()
+		msg := "No data"
+		c.text((c.plot.Min.X+c.plot.Max.X-len(msg)*charWidth)/2, (c.plot.Min.Y+c.plot.Max.Y)/2, msg, pal.text)
+		return c.encode(w)
+	}
+	c.lo, c.hi, c.yStep = niceRange(lo, hi, max(2, c.plot.Dy()/60))
+
+	c.grid()
+	for i, s := range series {
+		c.line(s.Points, i)
+	}
+	c.frame()
+	c.legend(series)
+	return c.encode(w)
+}
+
+type canvas struct {
+	img    *image.RGBA
+	pal    palette
+	opts   Options
+	plot   image.Rectangle
+	lo, hi float64
+	yStep  float64
+}
+
+func (c *canvas) x(t time.Time) int {
+	span := c.opts.End.Sub(c.opts.Start).Seconds()
+	return c.plot.Min.X + int(math.Round(t.Sub(c.opts.Start).Seconds()/span*float64(c.plot.Dx()-1)))
+}
+
+func (c *canvas) y(v float64) int {
+	return c.plot.Max.Y - 1 - int(math.Round((v-c.lo)/(c.hi-c.lo)*float64(c.plot.Dy()-1)))
+}
+
+// grid draws value and time gridlines with their labels
+func (c *canvas) grid() {
+	for v := c.lo; v <= c.hi+c.yStep/2; v += c.yStep {
+		y := c.y(v)
+		c.hline(c.plot.Min.X, c.plot.Max.X, y, c.pal.grid, 2)
+		label := formatValue(v, c.yStep) + c.opts.Unit
+		c.text(c.plot.Min.X-6-len([]rune(label))*charWidth, y+4, label, c.pal.text)
+	}
+
+	step, layout := timeStep(c.opts.End.Sub(c.opts.Start), c.plot.Dx()/90)
+	for t := firstTick(c.opts.Start.In(c.opts.Location), step); !t.After(c.opts.End); t = nextTick(t, step) {
+		x := c.x(t)
+		c.vline(x, c.plot.Min.Y, c.plot.Max.Y, c.pal.grid, 2)
+		label := t.Format(layout)
+		lx := min(max(x-len(label)*charWidth/2, 0), c.opts.Width-len(label)*charWidth)
+		c.text(lx, c.plot.Max.Y+charHeight+4, label, c.pal.text)
+	}
+}
+
+func (c *canvas) frame() {
+	r := c.plot
+	c.hline(r.Min.X, r.Max.X, r.Min.Y, c.pal.text, 0)
+	c.hline(r.Min.X, r.Max.X, r.Max.Y-1, c.pal.text, 0)
+	c.vline(r.Min.X, r.Min.Y, r.Max.Y, c.pal.text, 0)
+	c.vline(r.Max.X-1, r.Min.Y, r.Max.Y, c.pal.text, 0)
+}
+
+// line draws a series, breaking it across gaps in the data
+func (c *canvas) line(points []Point, index int) {
+	col := c.pal.series[index%len(c.pal.series)]
+	var dash []int
+	if c.opts.Theme == ThemeMono {
+		dash = dashes[index%len(dashes)]
+	}
+	maxGap := typicalInterval(points) * maxGapFactor
+
+	pen := 0 // Position along the dash pattern
+	for i, p := range points {
+		x, y := c.x(p.Time), c.y(p.Value)
+		if i == 0 || (maxGap > 0 && p.Time.Sub(points[i-1].Time) > maxGap) {
+			c.dot(x, y, col)
+			continue
+		}
+		pen = c.segment(c.x(points[i-1].Time), c.y(points[i-1].Value), x, y, col, dash, pen)
+	}
+}
+
+// segment draws a two-pixel-wide line with Bresenham's algorithm
+func (c *canvas) segment(x0, y0, x1, y1 int, col color.Color, dash []int, pen int) int {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := sign(x1-x0), sign(y1-y0)
+	err := dx + dy
+	for {
+		if on(dash, pen) {
+			c.dot(x0, y0, col)
+		}
+		pen++
+		if x0 == x1 && y0 == y1 {
+			return pen
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func (c *canvas) dot(x, y int, col color.Color) {
+	for _, d := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		if p := (image.Point{x + d[0], y + d[1]}); p.In(c.plot) {
+			c.img.Set(p.X, p.Y, col)
+		}
+	}
+}
+
+// hline and vline draw straight lines, dotted every dot pixels when dot > 0
+func (c *canvas) hline(x0, x1, y int, col color.Color, dot int) {
+	for x := x0; x < x1; x++ {
+		if dot == 0 || x%dot == 0 {
+			c.img.Set(x, y, col)
+		}
+	}
+}
+
+func (c *canvas) vline(x, y0, y1 int, col color.Color, dot int) {
+	for y := y0; y < y1; y++ {
+		if dot == 0 || y%dot == 0 {
+			c.img.Set(x, y, col)
+		}
+	}
+}
+
+// legend names the series in the top right when there is more than one
+func (c *canvas) legend(series []Series) {
+	if len(series) < 2 {
+		return
+	}
+	x := c.opts.Width - marginRight
+	for i := len(series) - 1; i >= 0; i-- {
+		name := series[i].Name
+		x -= len([]rune(name))*charWidth + 8
+		c.text(x, marginTop-10, name, c.pal.text)
+		col := c.pal.series[i%len(c.pal.series)]
+		var dash []int
+		if c.opts.Theme == ThemeMono {
+			dash = dashes[i%len(dashes)]
+		}
+		x -= 22
+		for dx := 0; dx < 18; dx++ {
+			if on(dash, dx) {
+				c.img.Set(x+dx, marginTop-14, col)
+				c.img.Set(x+dx, marginTop-13, col)
+			}
+		}
+		x -= 8
+	}
+}
+
+func (c *canvas) text(x, y int, s string, col color.Color) {
+	d := &font.Drawer{
+		Dst:  c.img,
+		Src:  image.NewUniform(col),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+func (c *canvas) encode(w io.Writer) error {
+	enc := &png.Encoder{CompressionLevel: png.BestCompression}
+	if c.opts.Theme != ThemeMono {
+		return enc.Encode(w, c.img)
+	}
+	// Threshold to two levels so e-ink panels get crisp, small images
+	gray := image.NewGray(c.img.Bounds())
+	for i := 0; i < len(c.img.Pix); i += 4 {
+		if c.img.Pix[i] >= 0x80 {
+			gray.Pix[i/4] = 0xff
+		}
+	}
+	return enc.Encode(w, gray)
+}
+
+func valueRange(series []Series) (lo, hi float64, ok bool) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, p := range s.Points {
+			lo, hi = math.Min(lo, p.Value), math.Max(hi, p.Value)
+			ok = true
+		}
+	}
+	return lo, hi, ok
+}
+
+// niceRange widens [lo, hi] to round tick values about ticks steps apart
+func niceRange(lo, hi float64, ticks int) (float64, float64, float64) {
+	if hi-lo < 1 {
+		mid := (lo + hi) / 2
+		lo, hi = mid-0.5, mid+0.5
+	}
+	raw := (hi - lo) / float64(ticks)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := mag * 10
+	for _, m := range []float64{1, 2, 5} {
+		if m*mag >= raw {
+			step = m * mag
+			break
+		}
+	}
+	return math.Floor(lo/step) * step, math.Ceil(hi/step) * step, step
+}
+
+func formatValue(v, step float64) string {
+	if step >= 1 {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+// Time axis steps with the label layout used for each
+var timeSteps = []struct {
+	step   time.Duration
+	layout string
+}{
+	{time.Minute, "15:04"},
+	{5 * time.Minute, "15:04"},
+	{15 * time.Minute, "15:04"},
+	{30 * time.Minute, "15:04"},
+	{time.Hour, "15:04"},
+	{3 * time.Hour, "15:04"},
+	{6 * time.Hour, "Jan 2 15h"},
+	{12 * time.Hour, "Jan 2 15h"},
+	{24 * time.Hour, "Jan 2"},
+	{2 * 24 * time.Hour, "Jan 2"},
+	{7 * 24 * time.Hour, "Jan 2"},
+	{14 * 24 * time.Hour, "Jan 2"},
+	{30 * 24 * time.Hour, "Jan 2006"},
+}
+
+// timeStep picks the smallest step that gives at most ticks labels
+func timeStep(span time.Duration, ticks int) (time.Duration, string) {
+	ticks = max(ticks, 2)
+	for _, s := range timeSteps {
+		if span/s.step <= time.Duration(ticks) {
+			return s.step, s.layout
+		}
+	}
+	last := timeSteps[len(timeSteps)-1]
+	return last.step, last.layout
+}
+
+// firstTick returns the first step boundary at or after t in t's location.
+// Day and longer steps align to local midnight.
+func firstTick(t time.Time, step time.Duration) time.Time {
+	if step < 24*time.Hour {
+		_, offset := t.Zone()
+		shift := time.Duration(offset) * time.Second
+		tick := t.Add(shift).Truncate(step).Add(-shift)
+		if tick.Before(t) {
+			tick = tick.Add(step)
+		}
+		return tick
+	}
+	tick := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if tick.Before(t) {
+		tick = tick.AddDate(0, 0, 1)
+	}
+	return tick
+}
+
+func nextTick(t time.Time, step time.Duration) time.Time {
+	if step < 24*time.Hour {
+		return t.Add(step)
+	}
+	return t.AddDate(0, 0, int(step/(24*time.Hour)))
+}
+
+// typicalInterval is the median spacing of points, used to detect gaps
+func typicalInterval(points []Point) time.Duration {
+	if len(points) < 3 {
+		return 0
+	}
+	gaps := make([]time.Duration, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		gaps[i-1] = points[i].Time.Sub(points[i-1].Time)
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps[len(gaps)/2]
+}
+
+func on(dash []int, pen int) bool {
+	if dash == nil {
+		return true
+	}
+	total := 0
+	for _, n := range dash {
+		total += n
+	}
+	pos := pen % total
+	for i, n := range dash {
+		if pos < n {
+			return i%2 == 0
+		}
+		pos -= n
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,410 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/querycache"
+	"gorm.io/gorm"
+)
+
+// Path is where charts are served
+const Path = "/chart.png"
+
+const (
+	defaultWidth  = 800
+	defaultHeight = 400
+	maxWidth      = 2000
+	maxHeight     = 1200
+	defaultRange  = 24 * time.Hour
+	maxRange      = 366 * 24 * time.Hour
+	maxSeries     = 8
+
+	// Ranges longer than this are drawn from hourly rollups when available
+	rollupThreshold = 48 * time.Hour
+	// Raw readings loaded per chart at most; longer ranges are truncated to
+	// the most recent readings
+	maxReadings = 100000
+)
+
+// Handler renders temperature charts as PNG for e-ink dashboards and chat
+// webhooks that cannot run the web UI:
+//
+//	GET /chart.png?client_id=<id>[&sensor_id=<id>...][&range=24h | &start=<RFC3339>[&end=<RFC3339>]]
+//	    [&width=800][&height=400][&theme=light|dark|mono][&unit=c|f][&title=...]
+//
+// Without sensor_id every sensor of the client that is not retired is drawn,
+// up to eight. The unit defaults to the display.temperature_unit setting.
+type Handler struct {
+	db    *gorm.DB
+	cache *querycache.Cache
+}
+
+// NewHandler creates a handler that keeps chart series in cache, which may
+// be nil
+func NewHandler(db *gorm.DB, cache *querycache.Cache) *Handler {
+	return &Handler{db: db, cache: cache}
+}
+
+// cachedSeries is what the handler caches per chart request
+type cachedSeries struct {
+	series []Series
+	title  string
+}
+
+type request struct {
+	clientID  string
+	sensorIDs []string
+	opts      Options
+	unit      string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := h.settings(r)
+	if err != nil {
+		log.Printf("Failed to load chart settings: %v", err)
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	req, err := parseRequest(r, time.Now(), settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The query alone selects the series, apart from the unit it may leave
+	// to the settings
+	key := r.URL.RawQuery + "\x00" + req.unit
+	cached, ok := h.cache.Get("chart", key)
+	if !ok {
+		series, title, err := h.load(r, req)
+		if err != nil {
+			log.Printf("Failed to load chart data: %v", err)
+			http.Error(w, "failed to load chart data", http.StatusInternalServerError)
+			return
+		}
+		cached = cachedSeries{series: series, title: title}
+		h.cache.Set("chart", key, req.clientID, cached)
+	}
+	series, title := cached.(cachedSeries).series, cached.(cachedSeries).title
+	if req.opts.Title == "" {
+		req.opts.Title = title
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, series, req.opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+func parseRequest(r *http.Request, now time.Time, settings map[string]string) (*request, error) {
+	q := r.URL.Query()
+	req := &request{
+		clientID:  q.Get("client_id"),
+		sensorIDs: q["sensor_id"],
+		opts: Options{
+			Width:  defaultWidth,
+			Height: defaultHeight,
+			Title:  q.Get("title"),
+			Theme:  Theme(q.Get("theme")),
+		},
+		unit: strings.ToLower(q.Get("unit")),
+	}
+	if req.clientID == "" {
+		return nil, fmt.Errorf("client_id is required")
+	}
+	if len(req.sensorIDs) > maxSeries {
+		return nil, fmt.Errorf("at most %d sensor_id values are allowed", maxSeries)
+	}
+
+	var err error
+	if req.opts.Width, err = intParam(q.Get("width"), defaultWidth, maxWidth); err != nil {
+		return nil, fmt.Errorf("invalid width: %v", err)
+	}
+	if req.opts.Height, err = intParam(q.Get("height"), defaultHeight, maxHeight); err != nil {
+		return nil, fmt.Errorf("invalid height: %v", err)
+	}
+	if req.opts.Theme == "" {
+		req.opts.Theme = ThemeLight
+	}
+	if !ValidTheme(req.opts.Theme) {
+		return nil, fmt.Errorf("invalid theme %q: must be light, dark, or mono", req.opts.Theme)
+	}
+
+	if req.unit == "" {
+		req.unit = "c"
+		if settings["display.temperature_unit"] == "fahrenheit" {
+			req.unit = "f"
+		}
+	}
+	switch req.unit {
+	case "c":
+		req.opts.Unit = "C"
+	case "f":
+		req.opts.Unit = "F"
+	default:
+		return nil, fmt.Errorf("invalid unit %q: must be c or f", req.unit)
+	}
+
+	req.opts.End = now
+	if end := q.Get("end"); end != "" {
+		if req.opts.End, err = time.Parse(time.RFC3339, end); err != nil {
+			return nil, fmt.Errorf("invalid end: %v", err)
+		}
+	}
+	switch start, rng := q.Get("start"), q.Get("range"); {
+	case start != "" && rng != "":
+		return nil, fmt.Errorf("start and range cannot both be set")
+	case start != "":
+		if req.opts.Start, err = time.Parse(time.RFC3339, start); err != nil {
+			return nil, fmt.Errorf("invalid start: %v", err)
+		}
+	case rng != "":
+		d, err := time.ParseDuration(rng)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid range %q", rng)
+		}
+		req.opts.Start = req.opts.End.Add(-d)
+	default:
+		req.opts.Start = req.opts.End.Add(-defaultRange)
+	}
+	if !req.opts.End.After(req.opts.Start) {
+		return nil, fmt.Errorf("start must be before end")
+	}
+	if req.opts.End.Sub(req.opts.Start) > maxRange {
+		return nil, fmt.Errorf("range must be at most %s", maxRange)
+	}
+
+	req.opts.Location = time.UTC
+	if loc, err := time.LoadLocation(settings["general.timezone"]); err == nil {
+		req.opts.Location = loc
+	}
+	return req, nil
+}
+
+func intParam(value string, def, maxValue int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > maxValue {
+		return 0, fmt.Errorf("must be between 1 and %d", maxValue)
+	}
+	return n, nil
+}
+
+// load fetches one series per sensor, averaged down to about one point per
+// horizontal pixel, and a default title
+func (h *Handler) load(r *http.Request, req *request) ([]Series, string, error) {
+	db := h.db.WithContext(r.Context())
+
+	var client models.Client
+	if err := db.Where("client_id = ?", req.clientID).Limit(1).Find(&client).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to query client: %w", err)
+	}
+	title := req.clientID
+	if client.Hostname != "" {
+		title = client.Hostname
+	}
+
+	sensorQuery := db.Where("client_id = ?", req.clientID).Order("sensor_id").Limit(maxSeries)
+	if len(req.sensorIDs) > 0 {
+		sensorQuery = sensorQuery.Where("sensor_id IN ?", req.sensorIDs)
+	} else {
+		sensorQuery = sensorQuery.Where("retired_at IS NULL")
+	}
+	var sensors []models.Sensor
+	if err := sensorQuery.Find(&sensors).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to query sensors: %w", err)
+	}
+	if len(sensors) == 1 {
+		title += " - " + sensorLabel(sensors[0])
+	}
+
+	points, err := h.points(db, req, sensors)
+	if err != nil {
+		return nil, "", err
+	}
+
+	convert := func(v float64) float64 { return v }
+	if req.unit == "f" {
+		convert = func(v float64) float64 { return v*9/5 + 32 }
+	}
+
+	buckets := req.opts.Width - marginLeft - marginRight
+	series := make([]Series, 0, len(sensors))
+	for _, sensor := range sensors {
+		s := Series{Name: sensorLabel(sensor)}
+		for _, p := range downsample(points[sensor.SensorID], req.opts.Start, req.opts.End, buckets) {
+			s.Points = append(s.Points, Point{Time: p.Time, Value: convert(p.Value)})
+		}
+		series = append(series, s)
+	}
+	return series, title, nil
+}
+
+// points loads readings keyed by sensor ID, from hourly rollups for long
+// ranges when the rollup worker has produced them
+func (h *Handler) points(db *gorm.DB, req *request, sensors []models.Sensor) (map[string][]Point, error) {
+	points := make(map[string][]Point)
+	if len(sensors) == 0 {
+		return points, nil
+	}
+	ids := make([]string, len(sensors))
+	for i, sensor := range sensors {
+		ids[i] = sensor.SensorID
+	}
+
+	if req.opts.End.Sub(req.opts.Start) > rollupThreshold {
+		var rollups []models.TemperatureRollup
+		err := db.Where("client_id = ? AND sensor_id IN ? AND bucket_seconds = ? AND bucket_start >= ? AND bucket_start <= ?",
+			req.clientID, ids, int64(time.Hour/time.Second), req.opts.Start.Add(-time.Hour), req.opts.End).
+			Order("bucket_start").
+			Find(&rollups).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to query rollups: %w", err)
+		}
+
+		// Readings stored before rollups were enabled have none, so fall back
+		// to raw readings unless the rollups reach back as far as they do
+		covered := len(rollups) > 0
+		if covered {
+			var first models.TemperatureReading
+			err := db.Select("created_at").
+				Where("client_id = ? AND sensor_id IN ? AND created_at >= ?", req.clientID, ids, req.opts.Start).
+				Order("created_at").
+				Limit(1).
+				Find(&first).Error
+			if err != nil {
+				return nil, fmt.Errorf("failed to query readings: %w", err)
+			}
+			var chunk models.ReadingChunk
+			err = db.Select("hour").
+				Where("client_id = ? AND sensor_id IN ? AND hour > ?", req.clientID, ids, req.opts.Start.Add(-time.Hour)).
+				Order("hour").
+				Limit(1).
+				Find(&chunk).Error
+			if err != nil {
+				return nil, fmt.Errorf("failed to query compressed readings: %w", err)
+			}
+			// Compressed readings are older than raw ones
+			if !chunk.Hour.IsZero() {
+				first.CreatedAt = chunk.Hour
+			}
+			covered = !rollups[0].BucketStart.After(first.CreatedAt)
+		}
+		if covered {
+			for _, rollup := range rollups {
+				mid := rollup.BucketStart.Add(time.Hour / 2)
+				points[rollup.SensorID] = append(points[rollup.SensorID], Point{Time: mid, Value: rollup.AvgTemp})
+			}
+			return points, nil
+		}
+	}
+
+	var readings []models.TemperatureReading
+	err := db.Select("sensor_id, temperature_celsius, created_at").
+		Where("client_id = ? AND sensor_id IN ? AND created_at >= ? AND created_at <= ?", req.clientID, ids, req.opts.Start, req.opts.End).
+		Order("created_at DESC").
+		Limit(maxReadings).
+		Find(&readings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query readings: %w", err)
+	}
+	filter := chunks.Filter{ClientID: req.clientID, SensorIDs: ids, Start: req.opts.Start, End: req.opts.End}
+	compressed, err := chunks.Readings(db, filter, maxReadings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compressed readings: %w", err)
+	}
+	if len(compressed) > 0 {
+		readings = append(readings, compressed...)
+		sort.SliceStable(readings, func(i, j int) bool { return readings[i].CreatedAt.After(readings[j].CreatedAt) })
+		if len(readings) > maxReadings {
+			readings = readings[:maxReadings]
+		}
+	}
+	for i := len(readings) - 1; i >= 0; i-- {
+		reading := readings[i]
+		points[reading.SensorID] = append(points[reading.SensorID], Point{Time: reading.CreatedAt, Value: reading.TemperatureCelsius})
+	}
+	return points, nil
+}
+
+// downsample averages points into at most n equal time buckets
+func downsample(points []Point, start, end time.Time, n int) []Point {
+	if len(points) <= n || n <= 0 {
+		return points
+	}
+	width := end.Sub(start) / time.Duration(n)
+	if width <= 0 {
+		return points
+	}
+
+	type bucket struct {
+		sum     float64
+		seconds float64 // Sum of offsets from start, for the mean time
+		count   int
+	}
+	buckets := make([]bucket, n)
+	for _, p := range points {
+		offset := p.Time.Sub(start)
+		b := &buckets[min(max(int(offset/width), 0), n-1)]
+		b.sum += p.Value
+		b.seconds += offset.Seconds()
+		b.count++
+	}
+
+	result := make([]Point, 0, n)
+	for _, b := range buckets {
+		if b.count == 0 {
+			continue
+		}
+		mean := time.Duration(b.seconds / float64(b.count) * float64(time.Second))
+		result = append(result, Point{Time: start.Add(mean), Value: b.sum / float64(b.count)})
+	}
+	return result
+}
+
+func (h *Handler) settings(r *http.Request) (map[string]string, error) {
+	var settings []models.Setting
+	err := h.db.WithContext(r.Context()).
+		Where("key IN ?", []string{"general.timezone", "display.temperature_unit"}).
+		Find(&settings).Error
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		values[setting.Key] = setting.Value
+	}
+	return values, nil
+}
+
+func sensorLabel(sensor models.Sensor) string {
+	if sensor.SensorName != "" {
+		return sensor.SensorName
+	}
+	return sensor.SensorID
+}
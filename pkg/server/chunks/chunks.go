@@ -0,0 +1,416 @@
+// Package chunks compresses old readings into one chunk per sensor per UTC
+// hour, and reads them back for the query endpoints. A chunk is a few bytes
+// per reading rather than a row each; decompressed readings keep millisecond
+// precision.
+package chunks
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Result summarizes a compression run
+type Result struct {
+	Chunks   int   // Chunks written
+	Readings int64 // Raw readings moved into them
+}
+
+// Compress moves the regular readings of every hour that ended by before into
+// chunks, merging them into the chunk of their hour if it already exists, as
+// it does for readings that arrived late. Burst readings are left to their
+// own retention, and the latest reading of each sensor stays raw so current
+// temperatures still find it.
+func Compress(db *gorm.DB, before time.Time) (Result, error) {
+	cutoff := before.UTC().Truncate(time.Hour)
+
+	var pairs []struct {
+		ClientID string
+		SensorID string
+	}
+	err := db.Model(&models.TemperatureReading{}).
+		Distinct("client_id", "sensor_id").
+		Where("burst = ? AND created_at < ?", false, cutoff).
+		Scan(&pairs).Error
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to find readings to compress: %w", err)
+	}
+
+	var result Result
+	for _, pair := range pairs {
+		limit := cutoff
+		var sensor models.Sensor
+		err := db.Where("client_id = ? AND sensor_id = ?", pair.ClientID, pair.SensorID).Limit(1).Find(&sensor).Error
+		if err != nil {
+			return result, fmt.Errorf("failed to load sensor %s: %w", pair.SensorID, err)
+		}
+		if sensor.LastReadingAt != nil && sensor.LastReadingAt.Before(limit) {
+			limit = sensor.LastReadingAt.UTC()
+		}
+
+		for {
+			var first []models.TemperatureReading
+			err := db.Where("client_id = ? AND sensor_id = ? AND burst = ? AND created_at < ?", pair.ClientID, pair.SensorID, false, limit).
+				Order("created_at").
+				Limit(1).
+				Find(&first).Error
+			if err != nil {
+				return result, fmt.Errorf("failed to find readings of sensor %s: %w", pair.SensorID, err)
+			}
+			if len(first) == 0 {
+				break
+			}
+			hour := first[0].CreatedAt.UTC().Truncate(time.Hour)
+			end := hour.Add(time.Hour)
+			if limit.Before(end) {
+				end = limit
+			}
+			compressed, err := compressHour(db, pair.ClientID, pair.SensorID, hour, end)
+			if err != nil {
+				return result, err
+			}
+			result.Chunks++
+			result.Readings += compressed
+		}
+	}
+	return result, nil
+}
+
+// compressHour merges the regular readings of a sensor from hour to end into
+// the chunk of the hour, and returns how many readings it moved
+func compressHour(db *gorm.DB, clientID, sensorID string, hour, end time.Time) (int64, error) {
+	var moved int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var readings []models.TemperatureReading
+		err := tx.Where("client_id = ? AND sensor_id = ? AND burst = ? AND created_at >= ? AND created_at < ?", clientID, sensorID, false, hour, end).
+			Order("created_at").
+			Find(&readings).Error
+		if err != nil {
+			return fmt.Errorf("failed to load readings of sensor %s: %w", sensorID, err)
+		}
+		if len(readings) == 0 {
+			return nil
+		}
+
+		var chunk models.ReadingChunk
+		err = tx.Where("client_id = ? AND sensor_id = ? AND hour = ?", clientID, sensorID, hour).Limit(1).Find(&chunk).Error
+		if err != nil {
+			return fmt.Errorf("failed to load chunk of sensor %s: %w", sensorID, err)
+		}
+		var points []Point
+		if chunk.ID != 0 {
+			if points, err = Decode(chunk.Data); err != nil {
+				return fmt.Errorf("failed to decode chunk of sensor %s at %s: %w", sensorID, hour.Format(time.RFC3339), err)
+			}
+		}
+
+		ids := make([]uint, len(readings))
+		for i := range readings {
+			ids[i] = readings[i].ID
+			points = append(points, pointOf(&readings[i]))
+		}
+		last := readings[len(readings)-1]
+		chunk.ClientID = clientID
+		chunk.SensorID = sensorID
+		chunk.Hour = hour
+		chunk.SensorType = last.SensorType
+		chunk.SensorName = last.SensorName
+		// A reading already in the chunk is replaced by the raw one
+		setPoints(&chunk, points)
+		if err := tx.Save(&chunk).Error; err != nil {
+			return fmt.Errorf("failed to save chunk of sensor %s: %w", sensorID, err)
+		}
+
+		if err := tx.Where("id IN ?", ids).Delete(&models.TemperatureReading{}).Error; err != nil {
+			return fmt.Errorf("failed to delete compressed readings of sensor %s: %w", sensorID, err)
+		}
+		moved = int64(len(ids))
+		return nil
+	})
+	return moved, err
+}
+
+// setPoints stores points in a chunk with its totals. Of points with the same
+// time, the last one given is kept.
+func setPoints(chunk *models.ReadingChunk, points []Point) {
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	merged := points[:0]
+	for _, p := range points {
+		if n := len(merged); n > 0 && merged[n-1].Time.Equal(p.Time) {
+			merged[n-1] = p
+			continue
+		}
+		merged = append(merged, p)
+	}
+
+	chunk.Count = int64(len(merged))
+	chunk.MinCelsius, chunk.MaxCelsius, chunk.SumCelsius = merged[0].min(), merged[0].max(), 0
+	for _, p := range merged {
+		chunk.MinCelsius = min(chunk.MinCelsius, p.min())
+		chunk.MaxCelsius = max(chunk.MaxCelsius, p.max())
+		chunk.SumCelsius += p.avg()
+	}
+	chunk.Data = Encode(merged)
+}
+
+// Reassign moves the chunks of one client to another. A chunk of an hour the
+// other client already has for the sensor is merged into that chunk, whose
+// readings win where both have one at the same time. It returns how many
+// chunks were moved or merged.
+func Reassign(tx *gorm.DB, fromClientID, toClientID string) (int64, error) {
+	var sources []models.ReadingChunk
+	if err := tx.Where("client_id = ?", fromClientID).Find(&sources).Error; err != nil {
+		return 0, fmt.Errorf("failed to load chunks of client %s: %w", fromClientID, err)
+	}
+
+	for i := range sources {
+		source := &sources[i]
+		var target models.ReadingChunk
+		err := tx.Where("client_id = ? AND sensor_id = ? AND hour = ?", toClientID, source.SensorID, source.Hour).Limit(1).Find(&target).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to load chunk of sensor %s: %w", source.SensorID, err)
+		}
+		if target.ID == 0 {
+			continue
+		}
+
+		points, err := Decode(source.Data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode chunk of sensor %s at %s: %w", source.SensorID, source.Hour.Format(time.RFC3339), err)
+		}
+		existing, err := Decode(target.Data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode chunk of sensor %s at %s: %w", target.SensorID, target.Hour.Format(time.RFC3339), err)
+		}
+		setPoints(&target, append(points, existing...))
+		if err := tx.Save(&target).Error; err != nil {
+			return 0, fmt.Errorf("failed to save chunk of sensor %s: %w", target.SensorID, err)
+		}
+		if err := tx.Delete(source).Error; err != nil {
+			return 0, fmt.Errorf("failed to delete merged chunk of sensor %s: %w", source.SensorID, err)
+		}
+	}
+
+	// The rest have no counterpart; updated_at changes so their hours are
+	// rolled up again under the new client
+	err := tx.Model(&models.ReadingChunk{}).Where("client_id = ?", fromClientID).Update("client_id", toClientID).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to move chunks of client %s: %w", fromClientID, err)
+	}
+	return int64(len(sources)), nil
+}
+
+// Filter selects the compressed readings a query covers. Zero fields do not
+// filter.
+type Filter struct {
+	ClientID   string
+	Site       string
+	SensorIDs  []string
+	SensorType string
+	Start      time.Time // Inclusive
+	End        time.Time // Inclusive
+	// Whole hours left out, such as those the rollups answer for; set
+	// when SkipEnd is
+	SkipStart time.Time
+	SkipEnd   time.Time // Exclusive
+}
+
+func (f Filter) query(db *gorm.DB) *gorm.DB {
+	query := db.Model(&models.ReadingChunk{})
+	if f.ClientID != "" {
+		query = query.Where("client_id = ?", f.ClientID)
+	}
+	if f.Site != "" {
+		query = query.Where("client_id IN (?)", db.Model(&models.Client{}).Select("client_id").Where("site = ?", f.Site))
+	}
+	if len(f.SensorIDs) > 0 {
+		query = query.Where("sensor_id IN ?", f.SensorIDs)
+	}
+	if f.SensorType != "" {
+		query = query.Where("sensor_type = ?", f.SensorType)
+	}
+	// Chunks are keyed by the start of their hour
+	if !f.Start.IsZero() {
+		query = query.Where("hour > ?", f.Start.UTC().Add(-time.Hour))
+	}
+	if !f.End.IsZero() {
+		query = query.Where("hour <= ?", f.End.UTC())
+	}
+	if !f.SkipEnd.IsZero() {
+		query = query.Where("NOT (hour >= ? AND hour < ?)", f.SkipStart.UTC(), f.SkipEnd.UTC())
+	}
+	return query
+}
+
+func (f Filter) contains(t time.Time) bool {
+	if !f.SkipEnd.IsZero() && !t.Before(f.SkipStart) && t.Before(f.SkipEnd) {
+		return false
+	}
+	return (f.Start.IsZero() || !t.Before(f.Start)) && (f.End.IsZero() || !t.After(f.End))
+}
+
+// Readings returns the compressed readings a filter matches, newest first.
+// A positive limit returns only that many of the newest.
+func Readings(db *gorm.DB, f Filter, limit int) ([]models.TemperatureReading, error) {
+	const batchSize = 100
+
+	var readings []models.TemperatureReading
+	for offset := 0; ; offset += batchSize {
+		var batch []models.ReadingChunk
+		if err := f.query(db).Order("hour DESC, id").Offset(offset).Limit(batchSize).Find(&batch).Error; err != nil {
+			return nil, fmt.Errorf("failed to load chunks: %w", err)
+		}
+		for i := range batch {
+			chunk := &batch[i]
+			points, err := Decode(chunk.Data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode chunk of sensor %s at %s: %w", chunk.SensorID, chunk.Hour.Format(time.RFC3339), err)
+			}
+			for _, p := range points {
+				if f.contains(p.Time) {
+					readings = append(readings, p.reading(chunk))
+				}
+			}
+		}
+		sort.SliceStable(readings, func(i, j int) bool { return readings[i].CreatedAt.After(readings[j].CreatedAt) })
+		if limit > 0 && len(readings) > limit {
+			readings = readings[:limit]
+		}
+		if len(batch) < batchSize {
+			break
+		}
+		// Chunks not loaded yet hold only readings before the end of the
+		// hour of the last one loaded
+		if limit > 0 && len(readings) == limit && !readings[limit-1].CreatedAt.Before(batch[len(batch)-1].Hour.Add(time.Hour)) {
+			break
+		}
+	}
+	return readings, nil
+}
+
+// Stats aggregates compressed readings, over their summaries where they have
+// one
+type Stats struct {
+	Min, Max float64
+	Sum      float64 // Of the averages
+	Count    int64
+}
+
+// Avg returns the average of the readings
+func (s *Stats) Avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+func (s *Stats) add(minC, maxC, sum float64, count int64) {
+	if s.Count == 0 {
+		s.Min, s.Max = minC, maxC
+	}
+	s.Min = min(s.Min, minC)
+	s.Max = max(s.Max, maxC)
+	s.Sum += sum
+	s.Count += count
+}
+
+// Aggregate returns the stats of the compressed readings a filter matches,
+// grouped by key. Readings key returns false for are left out. Chunks whose
+// whole hour is in range and in one group are aggregated without decoding
+// them.
+func Aggregate[K comparable](db *gorm.DB, f Filter, key func(clientID, sensorID string, t time.Time) (K, bool)) (map[K]*Stats, error) {
+	stats := make(map[K]*Stats)
+	group := func(k K) *Stats {
+		s, ok := stats[k]
+		if !ok {
+			s = &Stats{}
+			stats[k] = s
+		}
+		return s
+	}
+
+	var batch []models.ReadingChunk
+	err := f.query(db).FindInBatches(&batch, 100, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			chunk := &batch[i]
+			first := chunk.Hour
+			last := chunk.Hour.Add(time.Hour - time.Millisecond)
+			if f.contains(first) && f.contains(last) {
+				k, ok := key(chunk.ClientID, chunk.SensorID, first)
+				if lastKey, lastOK := key(chunk.ClientID, chunk.SensorID, last); ok && lastOK && k == lastKey {
+					group(k).add(chunk.MinCelsius, chunk.MaxCelsius, chunk.SumCelsius, chunk.Count)
+					continue
+				}
+			}
+
+			points, err := Decode(chunk.Data)
+			if err != nil {
+				return fmt.Errorf("failed to decode chunk of sensor %s at %s: %w", chunk.SensorID, chunk.Hour.Format(time.RFC3339), err)
+			}
+			for _, p := range points {
+				if !f.contains(p.Time) {
+					continue
+				}
+				if k, ok := key(chunk.ClientID, chunk.SensorID, p.Time); ok {
+					group(k).add(p.min(), p.max(), p.avg(), 1)
+				}
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate chunks: %w", err)
+	}
+	return stats, nil
+}
+
+func pointOf(r *models.TemperatureReading) Point {
+	p := Point{Time: r.CreatedAt.UTC(), Celsius: r.TemperatureCelsius}
+	if r.MinCelsius != nil && r.MaxCelsius != nil && r.AvgCelsius != nil {
+		p.Summary = &Summary{Min: *r.MinCelsius, Max: *r.MaxCelsius, Avg: *r.AvgCelsius, Samples: r.SampleCount}
+	}
+	return p
+}
+
+func (p Point) reading(chunk *models.ReadingChunk) models.TemperatureReading {
+	r := models.TemperatureReading{
+		ClientID:           chunk.ClientID,
+		SensorID:           chunk.SensorID,
+		SensorType:         chunk.SensorType,
+		SensorName:         chunk.SensorName,
+		TemperatureCelsius: p.Celsius,
+		SampleCount:        1,
+		CreatedAt:          p.Time,
+		UpdatedAt:          chunk.UpdatedAt,
+	}
+	if s := p.Summary; s != nil {
+		minC, maxC, avg := s.Min, s.Max, s.Avg
+		r.MinCelsius, r.MaxCelsius, r.AvgCelsius = &minC, &maxC, &avg
+		r.SampleCount = s.Samples
+	}
+	return r
+}
+
+func (p Point) min() float64 {
+	if p.Summary != nil {
+		return p.Summary.Min
+	}
+	return p.Celsius
+}
+
+func (p Point) max() float64 {
+	if p.Summary != nil {
+		return p.Summary.Max
+	}
+	return p.Celsius
+}
+
+func (p Point) avg() float64 {
+	if p.Summary != nil {
+		return p.Summary.Avg
+	}
+	return p.Celsius
+}
@@ -0,0 +1,199 @@
+package chunks
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Client{}, &models.Sensor{}, &models.TemperatureReading{}, &models.ReadingChunk{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// addReadings stores readings from base into a fourth hour for a few sensors,
+// some of them summarized, and a burst reading compression leaves alone
+func addReadings(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	var readings []models.TemperatureReading
+	for _, s := range []struct{ client, sensor string }{{"c1", "water"}, {"c1", "air"}, {"c2", "water"}} {
+		for i := 0; i < 26; i++ {
+			at := base.Add(time.Duration(i)*7*time.Minute + time.Duration(i*13)*time.Second + 250*time.Millisecond)
+			celsius := 30 + 10*math.Sin(float64(i)/3) + float64(len(s.sensor))
+			r := models.TemperatureReading{ClientID: s.client, SensorID: s.sensor, TemperatureCelsius: celsius, SampleCount: 1, CreatedAt: at, UpdatedAt: at}
+			if i%5 == 0 {
+				minC, maxC, avg := celsius-1.5, celsius+2.25, celsius+0.125
+				r.MinCelsius, r.MaxCelsius, r.AvgCelsius, r.SampleCount = &minC, &maxC, &avg, 12
+			}
+			readings = append(readings, r)
+		}
+	}
+	readings = append(readings, models.TemperatureReading{
+		ClientID: "c1", SensorID: "water", TemperatureCelsius: 95, SampleCount: 1, Burst: true,
+		CreatedAt: base.Add(90*time.Minute + time.Millisecond), UpdatedAt: base,
+	})
+	if err := db.Create(&readings).Error; err != nil {
+		t.Fatalf("create readings: %v", err)
+	}
+}
+
+// rawStats aggregates the regular raw readings a filter matches by sensor,
+// the way stats requests aggregate raw readings
+func rawStats(t *testing.T, db *gorm.DB, f Filter) map[string]*Stats {
+	t.Helper()
+	query := db.Model(&models.TemperatureReading{}).Where("burst = ?", false)
+	if f.ClientID != "" {
+		query = query.Where("client_id = ?", f.ClientID)
+	}
+	if len(f.SensorIDs) > 0 {
+		query = query.Where("sensor_id IN ?", f.SensorIDs)
+	}
+	if !f.Start.IsZero() {
+		query = query.Where("created_at >= ?", f.Start)
+	}
+	if !f.End.IsZero() {
+		query = query.Where("created_at <= ?", f.End)
+	}
+	if !f.SkipEnd.IsZero() {
+		query = query.Where("NOT (created_at >= ? AND created_at < ?)", f.SkipStart, f.SkipEnd)
+	}
+	var rows []struct {
+		SensorID string
+		MinTemp  float64
+		MaxTemp  float64
+		SumTemp  float64
+		Count    int64
+	}
+	err := query.Select(`sensor_id,
+		MIN(COALESCE(min_celsius, temperature_celsius)) as min_temp,
+		MAX(COALESCE(max_celsius, temperature_celsius)) as max_temp,
+		SUM(COALESCE(avg_celsius, temperature_celsius)) as sum_temp,
+		COUNT(*) as count`).
+		Group("sensor_id").
+		Scan(&rows).Error
+	if err != nil {
+		t.Fatalf("raw stats: %v", err)
+	}
+	stats := make(map[string]*Stats, len(rows))
+	for _, row := range rows {
+		stats[row.SensorID] = &Stats{Min: row.MinTemp, Max: row.MaxTemp, Sum: row.SumTemp, Count: row.Count}
+	}
+	return stats
+}
+
+func TestAggregateMatchesRawStats(t *testing.T) {
+	filters := []struct {
+		name   string
+		filter Filter
+	}{
+		{"everything", Filter{}},
+		{"whole hours", Filter{Start: base, End: base.Add(2*time.Hour - time.Millisecond)}},
+		{"start within an hour", Filter{Start: base.Add(40 * time.Minute)}},
+		{"end within an hour", Filter{End: base.Add(2*time.Hour + 20*time.Minute)}},
+		{"client and sensor", Filter{ClientID: "c1", SensorIDs: []string{"water"}, Start: base.Add(10 * time.Minute), End: base.Add(150 * time.Minute)}},
+		{"skipped hour", Filter{SkipStart: base.Add(time.Hour), SkipEnd: base.Add(2 * time.Hour)}},
+		{"skipped hour within range", Filter{Start: base.Add(30 * time.Minute), End: base.Add(170 * time.Minute), SkipStart: base.Add(time.Hour), SkipEnd: base.Add(2 * time.Hour)}},
+		{"nothing in range", Filter{Start: base.Add(24 * time.Hour)}},
+	}
+
+	db := testDB(t)
+	addReadings(t, db)
+	want := make([]map[string]*Stats, len(filters))
+	for i, tt := range filters {
+		want[i] = rawStats(t, db, tt.filter)
+	}
+
+	result, err := Compress(db, base.Add(4*time.Hour))
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	if result.Readings != 78 || result.Chunks != 12 {
+		t.Errorf("compressed %d readings into %d chunks, want 78 into 12", result.Readings, result.Chunks)
+	}
+	var left []models.TemperatureReading
+	if err := db.Find(&left).Error; err != nil {
+		t.Fatalf("find readings: %v", err)
+	}
+	if len(left) != 1 || !left[0].Burst {
+		t.Errorf("left %d readings raw, want only the burst reading", len(left))
+	}
+
+	for i, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Aggregate(db, tt.filter, func(_, sensorID string, _ time.Time) (string, bool) {
+				return sensorID, true
+			})
+			if err != nil {
+				t.Fatalf("Aggregate: %v", err)
+			}
+			if len(got) != len(want[i]) {
+				t.Fatalf("got stats of %d sensors, want %d", len(got), len(want[i]))
+			}
+			for sensor, w := range want[i] {
+				g, ok := got[sensor]
+				if !ok {
+					t.Fatalf("missing stats of %s", sensor)
+				}
+				if g.Min != w.Min || g.Max != w.Max || g.Count != w.Count || math.Abs(g.Sum-w.Sum) > 1e-9 {
+					t.Errorf("%s: got %+v, want %+v", sensor, *g, *w)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateGroups(t *testing.T) {
+	db := testDB(t)
+	addReadings(t, db)
+	// Half hours split every chunk between two groups, and the air sensor is
+	// left out
+	type half struct {
+		sensor string
+		start  time.Time
+	}
+	want := make(map[half]*Stats)
+	var readings []models.TemperatureReading
+	if err := db.Where("burst = ? AND sensor_id = ?", false, "water").Find(&readings).Error; err != nil {
+		t.Fatalf("find readings: %v", err)
+	}
+	for _, r := range readings {
+		k := half{r.SensorID, r.CreatedAt.UTC().Truncate(30 * time.Minute)}
+		if want[k] == nil {
+			want[k] = &Stats{}
+		}
+		p := pointOf(&r)
+		want[k].add(p.min(), p.max(), p.avg(), 1)
+	}
+
+	if _, err := Compress(db, base.Add(4*time.Hour)); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	got, err := Aggregate(db, Filter{}, func(_, sensorID string, at time.Time) (half, bool) {
+		return half{sensorID, at.Truncate(30 * time.Minute)}, sensorID != "air"
+	})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d groups, want %d", len(got), len(want))
+	}
+	for k, w := range want {
+		g := got[k]
+		if g == nil || g.Min != w.Min || g.Max != w.Max || g.Count != w.Count || math.Abs(g.Sum-w.Sum) > 1e-9 {
+			t.Errorf("%s at %s: got %+v, want %+v", k.sensor, k.start.Format(time.Kitchen), g, *w)
+		}
+	}
+}
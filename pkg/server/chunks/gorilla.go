@@ -0,0 +1,314 @@
+package chunks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// encodingVersion is the first byte of every encoded chunk
+const encodingVersion = 1
+
+// errCorrupt is returned for chunk data that does not decode
+var errCorrupt = errors.New("corrupt chunk data")
+
+// Point is a reading in a chunk. Times keep millisecond precision.
+type Point struct {
+	Time    time.Time
+	Celsius float64
+	Summary *Summary // Set when the agent summarized several samples
+}
+
+// Summary is the range of samples a point summarizes
+type Summary struct {
+	Min, Max, Avg float64
+	Samples       int32
+}
+
+// Encode packs points, oldest first, in the style of Facebook's Gorilla:
+// timestamps as deltas of deltas in variable-width buckets, and values XORed
+// with the previous value so repeated and slowly changing temperatures take
+// a few bits each
+func Encode(points []Point) []byte {
+	data := []byte{encodingVersion}
+	data = binary.AppendUvarint(data, uint64(len(points)))
+	if len(points) == 0 {
+		return data
+	}
+	data = binary.AppendVarint(data, points[0].Time.UnixMilli())
+
+	w := &bitWriter{buf: data}
+	var value, lows, highs, avgs xorState
+	var prevTime, prevDelta, prevSamples int64
+	for i, p := range points {
+		t := p.Time.UnixMilli()
+		if i > 0 {
+			delta := t - prevTime
+			w.writeSigned(delta - prevDelta)
+			prevDelta = delta
+		}
+		prevTime = t
+
+		value.write(w, p.Celsius)
+		w.writeBit(p.Summary != nil)
+		if p.Summary != nil {
+			lows.write(w, p.Summary.Min)
+			highs.write(w, p.Summary.Max)
+			avgs.write(w, p.Summary.Avg)
+			w.writeSigned(int64(p.Summary.Samples) - prevSamples)
+			prevSamples = int64(p.Summary.Samples)
+		}
+	}
+	return w.buf
+}
+
+// Decode unpacks points packed by Encode
+func Decode(data []byte) ([]Point, error) {
+	if len(data) == 0 || data[0] != encodingVersion {
+		return nil, fmt.Errorf("%w: unknown version", errCorrupt)
+	}
+	data = data[1:]
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	data = data[n:]
+	if count == 0 {
+		return nil, nil
+	}
+	first, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	// Every point takes at least three bits
+	if count > uint64(len(data)-n)*8/3+1 {
+		return nil, errCorrupt
+	}
+
+	r := &bitReader{buf: data[n:]}
+	points := make([]Point, 0, count)
+	var value, lows, highs, avgs xorState
+	t, delta, samples := first, int64(0), int64(0)
+	for i := uint64(0); i < count; i++ {
+		if i > 0 {
+			dod, err := r.readSigned()
+			if err != nil {
+				return nil, err
+			}
+			delta += dod
+			t += delta
+		}
+		p := Point{Time: time.UnixMilli(t).UTC()}
+
+		var err error
+		if p.Celsius, err = value.read(r); err != nil {
+			return nil, err
+		}
+		hasSummary, err := r.readBit()
+		if err != nil {
+			return nil, err
+		}
+		if hasSummary {
+			s := &Summary{}
+			if s.Min, err = lows.read(r); err != nil {
+				return nil, err
+			}
+			if s.Max, err = highs.read(r); err != nil {
+				return nil, err
+			}
+			if s.Avg, err = avgs.read(r); err != nil {
+				return nil, err
+			}
+			d, err := r.readSigned()
+			if err != nil {
+				return nil, err
+			}
+			samples += d
+			s.Samples = int32(samples)
+			p.Summary = s
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// xorState is the previous value of a series and the window of meaningful
+// bits its last XOR used
+type xorState struct {
+	started           bool
+	prev              uint64
+	leading, trailing int
+	window            bool // leading and trailing are set
+}
+
+func (s *xorState) write(w *bitWriter, v float64) {
+	value := math.Float64bits(v)
+	if !s.started {
+		w.writeBits(value, 64)
+		s.prev, s.started = value, true
+		return
+	}
+	xor := value ^ s.prev
+	s.prev = value
+	if xor == 0 {
+		w.writeBit(false)
+		return
+	}
+	w.writeBit(true)
+
+	leading := min(bits.LeadingZeros64(xor), 31) // Stored in 5 bits
+	trailing := bits.TrailingZeros64(xor)
+	if s.window && leading >= s.leading && trailing >= s.trailing {
+		// The meaningful bits fit the previous window
+		w.writeBit(false)
+		w.writeBits(xor>>s.trailing, 64-s.leading-s.trailing)
+		return
+	}
+	s.leading, s.trailing, s.window = leading, trailing, true
+	significant := 64 - leading - trailing
+	w.writeBit(true)
+	w.writeBits(uint64(leading), 5)
+	w.writeBits(uint64(significant-1), 6)
+	w.writeBits(xor>>trailing, significant)
+}
+
+func (s *xorState) read(r *bitReader) (float64, error) {
+	if !s.started {
+		value, err := r.readBits(64)
+		if err != nil {
+			return 0, err
+		}
+		s.prev, s.started = value, true
+		return math.Float64frombits(value), nil
+	}
+	changed, err := r.readBit()
+	if err != nil {
+		return 0, err
+	}
+	if !changed {
+		return math.Float64frombits(s.prev), nil
+	}
+
+	newWindow, err := r.readBit()
+	if err != nil {
+		return 0, err
+	}
+	if newWindow {
+		leading, err := r.readBits(5)
+		if err != nil {
+			return 0, err
+		}
+		significant, err := r.readBits(6)
+		if err != nil {
+			return 0, err
+		}
+		s.leading = int(leading)
+		s.trailing = 64 - s.leading - int(significant+1)
+		if s.trailing < 0 {
+			return 0, errCorrupt
+		}
+		s.window = true
+	} else if !s.window {
+		return 0, errCorrupt
+	}
+	meaningful, err := r.readBits(64 - s.leading - s.trailing)
+	if err != nil {
+		return 0, err
+	}
+	s.prev ^= meaningful << s.trailing
+	return math.Float64frombits(s.prev), nil
+}
+
+// Buckets of zigzag-encoded signed values: a prefix of ones ended by a zero,
+// then the value in the bucket's width. The last bucket needs no zero.
+var signedWidths = []int{0, 7, 9, 12, 32, 64}
+
+type bitWriter struct {
+	buf  []byte
+	free int // Unused low bits of the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+// writeBits writes the low n bits of v, most significant first
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v>>i&1 == 1)
+	}
+}
+
+func (w *bitWriter) writeSigned(v int64) {
+	zigzag := uint64(v<<1) ^ uint64(v>>63)
+	for i, width := range signedWidths {
+		last := i == len(signedWidths)-1
+		if !last && (width == 0 && zigzag != 0 || width > 0 && zigzag >= 1<<width) {
+			w.writeBit(true)
+			continue
+		}
+		if !last {
+			w.writeBit(false)
+		}
+		w.writeBits(zigzag, width)
+		return
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos int // Next bit
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, errCorrupt
+	}
+	bit := r.buf[r.pos/8]>>(7-r.pos%8)&1 == 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+func (r *bitReader) readSigned() (int64, error) {
+	width := signedWidths[len(signedWidths)-1]
+	for i := 0; i < len(signedWidths)-1; i++ {
+		more, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !more {
+			width = signedWidths[i]
+			break
+		}
+	}
+	zigzag, err := r.readBits(width)
+	if err != nil {
+		return 0, err
+	}
+	return int64(zigzag>>1) ^ -int64(zigzag&1), nil
+}
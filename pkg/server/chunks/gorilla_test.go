@@ -0,0 +1,168 @@
+package chunks
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2026, 3, 14, 15, 0, 0, 0, time.UTC)
+
+// at returns points at base plus the given milliseconds, with the given values
+func at(ms []int64, values ...float64) []Point {
+	points := make([]Point, len(ms))
+	for i := range ms {
+		points[i] = Point{Time: base.Add(time.Duration(ms[i]) * time.Millisecond), Celsius: values[i]}
+	}
+	return points
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	var walk []Point
+	celsius := 40.0
+	for i := 0; i < 1000; i++ {
+		celsius += math.Round(random.NormFloat64()*100) / 1000
+		walk = append(walk, Point{Time: base.Add(time.Duration(i*3000+random.Intn(50)) * time.Millisecond), Celsius: celsius})
+	}
+
+	tests := []struct {
+		name   string
+		points []Point
+	}{
+		{"empty", nil},
+		{"single sample", at([]int64{0}, 38.5)},
+		{"single summarized sample", []Point{{Time: base, Celsius: 38.5, Summary: &Summary{Min: 37, Max: 39.25, Avg: 38.1, Samples: 12}}}},
+		{"regular interval", at([]int64{0, 1000, 2000, 3000}, 20, 20.5, 21, 21.5)},
+		{"equal timestamps", at([]int64{5, 5, 5}, 1, 2, 3)},
+		{"irregular intervals", at([]int64{0, 1, 1000, 1001, 59000, 3599999}, 1, 2, 3, 4, 5, 6)},
+		{"large negative deltas", []Point{
+			{Time: base, Celsius: 1},
+			{Time: time.UnixMilli(0).UTC(), Celsius: 2},
+			{Time: time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC), Celsius: 3},
+			{Time: time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), Celsius: 4},
+		}},
+		{"deltas in every bucket", at([]int64{0, 63, 64, 320, 2400, 1 << 31, 1 << 40}, 1, 1, 1, 1, 1, 1, 1)},
+		{"non-finite values", at([]int64{0, 1, 2, 3, 4, 5}, math.NaN(), 20, math.Inf(1), math.Inf(-1), math.NaN(), 0)},
+		{"identical values", at([]int64{0, 1000, 2000, 3000, 4000}, 21.125, 21.125, 21.125, 21.125, 21.125)},
+		{"sign flips", at([]int64{0, 1, 2, 3, 4, 5}, 12.5, -12.5, 0, math.Copysign(0, -1), -0.001, 1e300)},
+		{"extremes", at([]int64{0, 1, 2, 3}, math.MaxFloat64, math.SmallestNonzeroFloat64, -math.MaxFloat64, 1)},
+		{"reused and new windows", at([]int64{0, 1, 2, 3, 4}, 1, 1.5, 1.25, 1024.000001, 1.5)},
+		{"mixed summaries", []Point{
+			{Time: base, Celsius: 30},
+			{Time: base.Add(time.Second), Celsius: 31, Summary: &Summary{Min: 29, Max: 33, Avg: 31, Samples: 60}},
+			{Time: base.Add(2 * time.Second), Celsius: 32},
+			{Time: base.Add(3 * time.Second), Celsius: 29, Summary: &Summary{Min: math.Inf(-1), Max: math.NaN(), Avg: 30, Samples: 1}},
+			{Time: base.Add(4 * time.Second), Celsius: 28, Summary: &Summary{Min: 28, Max: 28, Avg: 28, Samples: math.MaxInt32}},
+		}},
+		{"random walk", walk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := Encode(tt.points)
+			got, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if len(got) != len(tt.points) {
+				t.Fatalf("got %d points, want %d", len(got), len(tt.points))
+			}
+			for i, want := range tt.points {
+				if !samePoint(got[i], want) {
+					t.Fatalf("point %d is %s, want %s", i, describe(got[i]), describe(want))
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeCompresses(t *testing.T) {
+	points := make([]Point, 1200)
+	for i := range points {
+		points[i] = Point{Time: base.Add(time.Duration(i) * 3 * time.Second), Celsius: 38.5 + float64(i%4)*0.125}
+	}
+	// A regular series of a few distinct values takes a few bits a point,
+	// against 16 bytes for a time and a value
+	if size := len(Encode(points)); size > len(points)*2 {
+		t.Errorf("encoded %d points in %d bytes, want at most %d", len(points), size, len(points)*2)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := Encode(at([]int64{0, 1000, 2000}, 20, 21, 22))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown version", append([]byte{2}, valid[1:]...)},
+		{"missing count", []byte{encodingVersion}},
+		{"missing first time", []byte{encodingVersion, 3}},
+		{"count beyond the data", []byte{encodingVersion, 0xff, 0xff, 0x03, 0}},
+		{"truncated", valid[:len(valid)-2]},
+		{"truncated first value", valid[:4]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.data); !errors.Is(err, errCorrupt) {
+				t.Errorf("got error %v, want errCorrupt", err)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(Encode(nil))
+	f.Add(Encode(at([]int64{0, 1000, 2000}, 20, 21, 22)))
+	f.Add(Encode([]Point{{Time: base, Celsius: 1, Summary: &Summary{Min: 0, Max: 2, Avg: 1, Samples: 3}}}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Data either fails to decode or decodes to points that encode and
+		// decode to the same points
+		points, err := Decode(data)
+		if err != nil {
+			if !errors.Is(err, errCorrupt) {
+				t.Fatalf("got error %v, want errCorrupt", err)
+			}
+			return
+		}
+		again, err := Decode(Encode(points))
+		if err != nil {
+			t.Fatalf("decoding re-encoded points: %v", err)
+		}
+		if len(again) != len(points) {
+			t.Fatalf("got %d points back, want %d", len(again), len(points))
+		}
+		for i := range points {
+			if !samePoint(again[i], points[i]) {
+				t.Fatalf("point %d became %s, want %s", i, describe(again[i]), describe(points[i]))
+			}
+		}
+	})
+}
+
+// samePoint compares points by the bits of their values, so NaN and the sign
+// of zero count
+func samePoint(a, b Point) bool {
+	if !a.Time.Equal(b.Time) || !sameFloat(a.Celsius, b.Celsius) || (a.Summary == nil) != (b.Summary == nil) {
+		return false
+	}
+	if a.Summary == nil {
+		return true
+	}
+	return sameFloat(a.Summary.Min, b.Summary.Min) && sameFloat(a.Summary.Max, b.Summary.Max) &&
+		sameFloat(a.Summary.Avg, b.Summary.Avg) && a.Summary.Samples == b.Summary.Samples
+}
+
+func sameFloat(a, b float64) bool {
+	return math.Float64bits(a) == math.Float64bits(b)
+}
+
+func describe(p Point) string {
+	if p.Summary != nil {
+		return fmt.Sprintf("%s %g %+v", p.Time.Format(time.RFC3339Nano), p.Celsius, *p.Summary)
+	}
+	return fmt.Sprintf("%s %g", p.Time.Format(time.RFC3339Nano), p.Celsius)
+}
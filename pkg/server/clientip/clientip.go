@@ -0,0 +1,118 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Metadata keys proxies set to the address they received a request from. The
+// JSON gateway and gRPC-Web carry HTTP headers into metadata under the same
+// names.
+const (
+	ForwardedFor = "x-forwarded-for"
+	RealIP       = "x-real-ip"
+)
+
+// Resolver finds the address an RPC came from, trusting forwarding headers
+// only on requests from configured proxies, since anyone else can forge them.
+// A nil Resolver trusts no proxies.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver trusts the proxies at the given addresses or CIDR ranges
+func NewResolver(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", proxy)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q", proxy)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// FromContext returns the caller's IP address, or an empty string if unknown.
+// Behind trusted proxies it is the nearest untrusted hop of X-Forwarded-For,
+// or X-Real-IP when that is absent.
+func (r *Resolver) FromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || !r.trusts(ip) {
+		return normalize(ip, addr)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var hops []string
+	for _, value := range md.Get(ForwardedFor) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	// Proxies append, so the nearest hop is last; the first untrusted one is
+	// the furthest address that was not forged by the client
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		if !r.trusts(hop) || i == 0 {
+			return normalize(hop, "")
+		}
+	}
+	if values := md.Get(RealIP); len(values) > 0 {
+		if real := net.ParseIP(strings.TrimSpace(values[0])); real != nil {
+			return normalize(real, "")
+		}
+	}
+	return normalize(ip, addr)
+}
+
+func (r *Resolver) trusts(ip net.IP) bool {
+	if r == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize formats an IP address, unwrapping IPv4 addresses mapped into IPv6
+func normalize(ip net.IP, fallback string) string {
+	if ip == nil {
+		return fallback
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,284 @@
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxDepth bounds how deeply CBOR arrays and maps may nest
+const maxDepth = 8
+
+var errTruncated = errors.New("truncated CBOR data")
+
+// decodeCBOR decodes a single CBOR (RFC 8949) data item into the values
+// encoding/json produces: map[string]interface{}, []interface{}, string,
+// float64, bool and nil. Integers become float64, byte strings are rejected
+// and tags are ignored.
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%d bytes of trailing data after CBOR item", len(d.data)-d.pos)
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// breakCode ends indefinite-length items
+const breakCode = 0xff
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("CBOR nested too deeply")
+	}
+	if d.pos >= len(d.data) {
+		return nil, errTruncated
+	}
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	// Simple values and floats carry their value in the additional info
+	if major == 7 {
+		return d.simple(info)
+	}
+
+	if info == 31 {
+		switch major {
+		case 2, 3:
+			return d.indefiniteString(major)
+		case 4:
+			return d.array(-1, depth)
+		case 5:
+			return d.object(-1, depth)
+		}
+		return nil, fmt.Errorf("invalid indefinite length for CBOR major type %d", major)
+	}
+	arg, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		return float64(arg), nil
+	case 1:
+		return -1 - float64(arg), nil
+	case 2:
+		return nil, errors.New("CBOR byte strings are not supported")
+	case 3:
+		s, err := d.bytes(arg)
+		return string(s), err
+	case 4, 5:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 4 {
+			return d.array(n, depth)
+		}
+		return d.object(n, depth)
+	default: // 6, a tag on the item that follows
+		return d.value(depth + 1)
+	}
+}
+
+// argument reads the integer that follows an initial byte
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, fmt.Errorf("invalid CBOR additional info %d", info)
+	}
+	b, err := d.bytes(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var arg uint64
+	for _, c := range b {
+		arg = arg<<8 | uint64(c)
+	}
+	return arg, nil
+}
+
+// length converts the item count of an array or map, which could not
+// otherwise fit in an int, rejecting counts the remaining data cannot hold as
+// every item takes at least a byte
+func (d *cborDecoder) length(arg uint64) (int, error) {
+	if arg > uint64(len(d.data)-d.pos) {
+		return 0, errTruncated
+	}
+	return int(arg), nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null, undefined
+		return nil, nil
+	case 25:
+		b, err := d.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+}
+
+// halfFloat converts an IEEE 754 half-precision float
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
+
+// indefiniteString joins the definite-length chunks of an indefinite string
+func (d *cborDecoder) indefiniteString(major byte) (interface{}, error) {
+	if major == 2 {
+		return nil, errors.New("CBOR byte strings are not supported")
+	}
+	var s []byte
+	for {
+		if d.pos >= len(d.data) {
+			return nil, errTruncated
+		}
+		if d.data[d.pos] == breakCode {
+			d.pos++
+			return string(s), nil
+		}
+		initial := d.data[d.pos]
+		d.pos++
+		if initial>>5 != major || initial&0x1f == 31 {
+			return nil, errors.New("invalid chunk in indefinite-length CBOR string")
+		}
+		n, err := d.argument(initial & 0x1f)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+}
+
+// more reports whether an array or map of n items, -1 when indefinite, has
+// another item, consuming the break that ends an indefinite one
+func (d *cborDecoder) more(n, read int) (bool, error) {
+	if n >= 0 {
+		return read < n, nil
+	}
+	if d.pos >= len(d.data) {
+		return false, errTruncated
+	}
+	if d.data[d.pos] == breakCode {
+		d.pos++
+		return false, nil
+	}
+	return true, nil
+}
+
+func (d *cborDecoder) array(n, depth int) (interface{}, error) {
+	items := make([]interface{}, 0, max(n, 0))
+	for {
+		more, err := d.more(n, len(items))
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return items, nil
+		}
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (d *cborDecoder) object(n, depth int) (interface{}, error) {
+	m := make(map[string]interface{}, max(n, 0))
+	for read := 0; ; read++ {
+		more, err := d.more(n, read)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return m, nil
+		}
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, errors.New("CBOR map keys must be text strings")
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = value
+	}
+}
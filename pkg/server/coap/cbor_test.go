@@ -0,0 +1,168 @@
+package coap
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples from RFC 8949 appendix A
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{"zero", "00", 0.0},
+		{"small integer", "17", 23.0},
+		{"one byte integer", "18 64", 100.0},
+		{"two byte integer", "19 03e8", 1000.0},
+		{"four byte integer", "1a 000f4240", 1000000.0},
+		{"eight byte integer", "1b 000000e8d4a51000", 1000000000000.0},
+		{"negative integer", "38 63", -100.0},
+		{"half float", "f9 3e00", 1.5},
+		{"half float subnormal", "f9 0001", 5.960464477539063e-8},
+		{"half float negative", "f9 c400", -4.0},
+		{"half float infinity", "f9 7c00", math.Inf(1)},
+		{"single float", "fa 47c35000", 100000.0},
+		{"double float", "fb 3ff199999999999a", 1.1},
+		{"false", "f4", false},
+		{"true", "f5", true},
+		{"null", "f6", nil},
+		{"undefined", "f7", nil},
+		{"empty string", "60", ""},
+		{"string", "64 49455446", "IETF"},
+		{"unicode string", "62 c3bc", "ü"},
+		{"indefinite string", "7f 65 7374726561 64 6d696e67 ff", "streaming"},
+		{"empty array", "80", []interface{}{}},
+		{"array", "83 01 02 03", []interface{}{1.0, 2.0, 3.0}},
+		{"nested array", "83 01 82 02 03 82 04 05", []interface{}{1.0, []interface{}{2.0, 3.0}, []interface{}{4.0, 5.0}}},
+		{"indefinite array", "9f 01 82 02 03 ff", []interface{}{1.0, []interface{}{2.0, 3.0}}},
+		{"empty map", "a0", map[string]interface{}{}},
+		{"map", "a2 61 61 01 61 62 82 02 03", map[string]interface{}{"a": 1.0, "b": []interface{}{2.0, 3.0}}},
+		{"indefinite map", "bf 61 61 01 61 62 9f 02 ff ff", map[string]interface{}{"a": 1.0, "b": []interface{}{2.0}}},
+		{"tag", "c1 1a 514b67b0", 1363896240.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCBOR(mustHex(t, tt.data))
+			if err != nil {
+				t.Fatalf("decodeCBOR: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORNaN(t *testing.T) {
+	got, err := decodeCBOR(mustHex(t, "f9 7e00"))
+	if err != nil {
+		t.Fatalf("decodeCBOR: %v", err)
+	}
+	if f, ok := got.(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("got %#v, want NaN", got)
+	}
+}
+
+func TestDecodeCBORErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		message string
+	}{
+		{"empty", "", "truncated CBOR data"},
+		{"truncated argument", "19 03", "truncated CBOR data"},
+		{"truncated string", "64 4945", "truncated CBOR data"},
+		{"truncated array", "83 01 02", "truncated CBOR data"},
+		{"unterminated indefinite array", "9f 01", "truncated CBOR data"},
+		{"unterminated indefinite string", "7f 61 61", "truncated CBOR data"},
+		{"array longer than the data", "9a 7fffffff 00", "truncated CBOR data"},
+		{"array length past int32", "9b 0000000100000000 00", "truncated CBOR data"},
+		{"array length past int64", "9b 8000000000000000 00", "truncated CBOR data"},
+		{"map length past int64", "bb ffffffffffffffff 00", "truncated CBOR data"},
+		{"string length past int64", "7b 8000000000000000 00", "truncated CBOR data"},
+		{"chunk length past int64", "7f 7b 8000000000000000 ff", "truncated CBOR data"},
+		{"trailing data", "01 02", "1 bytes of trailing data after CBOR item"},
+		{"byte string", "41 00", "CBOR byte strings are not supported"},
+		{"indefinite byte string", "5f ff", "CBOR byte strings are not supported"},
+		{"reserved additional info", "1c", "invalid CBOR additional info 28"},
+		{"indefinite integer", "1f", "invalid indefinite length for CBOR major type 0"},
+		{"mixed string chunk", "7f 41 00 ff", "invalid chunk in indefinite-length CBOR string"},
+		{"nested string chunk", "7f 7f ff ff", "invalid chunk in indefinite-length CBOR string"},
+		{"integer key", "a1 01 02", "CBOR map keys must be text strings"},
+		{"unsupported simple value", "f0", "unsupported CBOR simple value 16"},
+		{"nested too deeply", strings.Repeat("81 ", maxDepth+1) + "00", "CBOR nested too deeply"},
+		{"tagged too deeply", strings.Repeat("c1 ", maxDepth+1) + "00", "CBOR nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCBOR(mustHex(t, tt.data))
+			if err == nil {
+				t.Fatal("decodeCBOR succeeded, want an error")
+			}
+			if err.Error() != tt.message {
+				t.Errorf("got error %q, want %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORTruncatedIsErrTruncated(t *testing.T) {
+	_, err := decodeCBOR(mustHex(t, "9b 8000000000000000"))
+	if !errors.Is(err, errTruncated) {
+		t.Errorf("got error %v, want errTruncated", err)
+	}
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, seed := range []string{
+		"a2 61 61 01 61 62 82 02 03",
+		"bf 61 61 01 61 62 9f 02 ff ff",
+		"7f 65 7374726561 64 6d696e67 ff",
+		"c1 fb 3ff199999999999a",
+		"9b 8000000000000000 00",
+		"f9 7e00",
+	} {
+		f.Add(mustHex(f, seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Any input either decodes to the values encoding/json produces or
+		// fails with an error
+		v, err := decodeCBOR(data)
+		if err != nil {
+			return
+		}
+		checkJSONValue(t, v)
+	})
+}
+
+func checkJSONValue(t *testing.T, v interface{}) {
+	t.Helper()
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+	case []interface{}:
+		for _, item := range v {
+			checkJSONValue(t, item)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			checkJSONValue(t, item)
+		}
+	default:
+		t.Fatalf("decoded unexpected %T", v)
+	}
+}
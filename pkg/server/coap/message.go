@@ -0,0 +1,231 @@
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Message types
+const (
+	typeConfirmable     = 0
+	typeNonConfirmable  = 1
+	typeAcknowledgement = 2
+	typeReset           = 3
+)
+
+// Codes, as class<<5 | detail
+const (
+	codeEmpty                 = 0x00
+	codePost                  = 0x02
+	codeChanged               = 0x44 // 2.04
+	codeBadRequest            = 0x80 // 4.00
+	codeUnauthorized          = 0x81 // 4.01
+	codeBadOption             = 0x82 // 4.02
+	codeForbidden             = 0x83 // 4.03
+	codeNotFound              = 0x84 // 4.04
+	codeMethodNotAllowed      = 0x85 // 4.05
+	codeRequestEntityTooLarge = 0x8d // 4.13
+	codeUnsupportedFormat     = 0x8f // 4.15
+	codeTooManyRequests       = 0x9d // 4.29, RFC 8516
+	codeInternalServerError   = 0xa0 // 5.00
+)
+
+// Options
+const (
+	optionURIPath       = 11
+	optionContentFormat = 12
+)
+
+// Content formats
+const (
+	formatText = 0
+	formatJSON = 50
+	formatCBOR = 60
+)
+
+const payloadMarker = 0xff
+
+// message is a CoAP (RFC 7252) message
+type message struct {
+	typ       byte
+	code      byte
+	messageID uint16
+	token     []byte
+	options   []option
+	payload   []byte
+}
+
+type option struct {
+	number int
+	value  []byte
+}
+
+// codeString formats a code as class.detail
+func codeString(code byte) string {
+	return fmt.Sprintf("%d.%02d", code>>5, code&0x1f)
+}
+
+// parseMessage decodes a datagram
+func parseMessage(data []byte) (*message, error) {
+	if len(data) < 4 {
+		return nil, errors.New("message shorter than the header")
+	}
+	if data[0]>>6 != 1 {
+		return nil, fmt.Errorf("unsupported version %d", data[0]>>6)
+	}
+	m := &message{
+		typ:       data[0] >> 4 & 0x3,
+		code:      data[1],
+		messageID: binary.BigEndian.Uint16(data[2:4]),
+	}
+	tokenLength := int(data[0] & 0xf)
+	if tokenLength > 8 || 4+tokenLength > len(data) {
+		return nil, errors.New("invalid token length")
+	}
+	m.token = data[4 : 4+tokenLength]
+
+	rest := data[4+tokenLength:]
+	number := 0
+	for len(rest) > 0 {
+		if rest[0] == payloadMarker {
+			if len(rest) == 1 {
+				return nil, errors.New("payload marker without a payload")
+			}
+			m.payload = rest[1:]
+			break
+		}
+		delta, length := int(rest[0]>>4), int(rest[0]&0xf)
+		rest = rest[1:]
+		var err error
+		if delta, rest, err = optionNibble(delta, rest); err != nil {
+			return nil, err
+		}
+		if length, rest, err = optionNibble(length, rest); err != nil {
+			return nil, err
+		}
+		if length > len(rest) {
+			return nil, errors.New("option longer than the message")
+		}
+		number += delta
+		m.options = append(m.options, option{number: number, value: rest[:length]})
+		rest = rest[length:]
+	}
+	return m, nil
+}
+
+// optionNibble reads the extended value an option delta or length nibble
+// calls for
+func optionNibble(nibble int, rest []byte) (int, []byte, error) {
+	switch nibble {
+	case 13:
+		if len(rest) < 1 {
+			return 0, nil, errors.New("truncated option")
+		}
+		return int(rest[0]) + 13, rest[1:], nil
+	case 14:
+		if len(rest) < 2 {
+			return 0, nil, errors.New("truncated option")
+		}
+		return int(binary.BigEndian.Uint16(rest)) + 269, rest[2:], nil
+	case 15:
+		return 0, nil, errors.New("reserved option nibble")
+	}
+	return nibble, rest, nil
+}
+
+// marshal encodes the message
+func (m *message) marshal() []byte {
+	b := make([]byte, 4, 4+len(m.token)+len(m.payload)+16)
+	b[0] = 1<<6 | m.typ<<4 | byte(len(m.token))
+	b[1] = m.code
+	binary.BigEndian.PutUint16(b[2:], m.messageID)
+	b = append(b, m.token...)
+
+	options := append([]option(nil), m.options...)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].number < options[j].number })
+	number := 0
+	for _, o := range options {
+		delta, deltaExt := optionExtension(o.number - number)
+		length, lengthExt := optionExtension(len(o.value))
+		b = append(b, byte(delta<<4|length))
+		b = append(b, deltaExt...)
+		b = append(b, lengthExt...)
+		b = append(b, o.value...)
+		number = o.number
+	}
+
+	if len(m.payload) > 0 {
+		b = append(b, payloadMarker)
+		b = append(b, m.payload...)
+	}
+	return b
+}
+
+// optionExtension splits an option delta or length into its nibble and
+// extended bytes
+func optionExtension(v int) (int, []byte) {
+	switch {
+	case v < 13:
+		return v, nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		return 14, binary.BigEndian.AppendUint16(nil, uint16(v-269))
+	}
+}
+
+// uintOption encodes an unsigned option value in as few bytes as possible
+func uintOption(number int, v uint32) option {
+	var value []byte
+	for ; v > 0; v >>= 8 {
+		value = append([]byte{byte(v)}, value...)
+	}
+	return option{number: number, value: value}
+}
+
+// uint returns the value of an unsigned option, and whether it is present
+func (m *message) uint(number int) (uint32, bool) {
+	for _, o := range m.options {
+		if o.number == number {
+			var v uint32
+			for _, c := range o.value {
+				v = v<<8 | uint32(c)
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// path joins the Uri-Path options
+func (m *message) path() string {
+	var path string
+	for _, o := range m.options {
+		if o.number == optionURIPath {
+			if path != "" {
+				path += "/"
+			}
+			path += string(o.value)
+		}
+	}
+	return path
+}
+
+// unknownCritical returns the first option with an odd number, which RFC 7252
+// makes critical, that is not understood
+func (m *message) unknownCritical() (int, bool) {
+	for _, o := range m.options {
+		switch o.number {
+		case optionURIPath, optionContentFormat:
+			continue
+		case 3, 7, 15: // Uri-Host, Uri-Port, Uri-Query are safe to ignore here
+			continue
+		}
+		if o.number%2 == 1 {
+			return o.number, true
+		}
+	}
+	return 0, false
+}
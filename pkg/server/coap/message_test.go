@@ -0,0 +1,184 @@
+package coap
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	data := mustHex(t, "52 02 1234 abcd b8 72656164696e6773 01 61 12 003c ff a0")
+	m, err := parseMessage(data)
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if m.typ != typeNonConfirmable || m.code != codePost || m.messageID != 0x1234 {
+		t.Errorf("got type %d code %s id %#x", m.typ, codeString(m.code), m.messageID)
+	}
+	if !bytes.Equal(m.token, []byte{0xab, 0xcd}) {
+		t.Errorf("got token %x, want abcd", m.token)
+	}
+	if path := m.path(); path != "readings/a" {
+		t.Errorf("got path %q, want readings/a", path)
+	}
+	if format, ok := m.uint(optionContentFormat); !ok || format != formatCBOR {
+		t.Errorf("got content format %d, %v, want %d", format, ok, formatCBOR)
+	}
+	if !bytes.Equal(m.payload, []byte{0xa0}) {
+		t.Errorf("got payload %x, want a0", m.payload)
+	}
+	if number, ok := m.unknownCritical(); ok {
+		t.Errorf("got unknown critical option %d", number)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		message string
+	}{
+		{"short header", "40 02 00", "message shorter than the header"},
+		{"version", "80 02 0000", "unsupported version 2"},
+		{"token too long", "49 02 0000 0102030405060708 09", "invalid token length"},
+		{"token past the end", "44 02 0000 0102", "invalid token length"},
+		{"marker without payload", "40 02 0000 ff", "payload marker without a payload"},
+		{"truncated delta", "40 02 0000 d0", "truncated option"},
+		{"truncated extended delta", "40 02 0000 e0 00", "truncated option"},
+		{"truncated length", "40 02 0000 0d", "truncated option"},
+		{"reserved delta", "40 02 0000 f0", "reserved option nibble"},
+		{"reserved length", "40 02 0000 0f", "reserved option nibble"},
+		{"option past the end", "40 02 0000 b3 6162", "option longer than the message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMessage(mustHex(t, tt.data))
+			if err == nil {
+				t.Fatal("parseMessage succeeded, want an error")
+			}
+			if err.Error() != tt.message {
+				t.Errorf("got error %q, want %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *message
+	}{
+		{"empty", &message{typ: typeAcknowledgement, code: codeEmpty, messageID: 7}},
+		{"request", &message{
+			typ:       typeConfirmable,
+			code:      codePost,
+			messageID: 0xbeef,
+			token:     []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			options: []option{
+				{number: optionURIPath, value: []byte("readings")},
+				uintOption(optionContentFormat, formatJSON),
+			},
+			payload: []byte(`{"a":1}`),
+		}},
+		{"unsorted options", &message{
+			typ:  typeNonConfirmable,
+			code: codeChanged,
+			options: []option{
+				uintOption(optionContentFormat, formatText),
+				{number: optionURIPath, value: []byte("a")},
+				{number: optionURIPath, value: []byte("b")},
+			},
+		}},
+		{"extended option deltas and lengths", &message{
+			typ:  typeConfirmable,
+			code: codeBadRequest,
+			options: []option{
+				{number: 20, value: []byte(strings.Repeat("x", 13))},
+				{number: 300, value: []byte(strings.Repeat("y", 269))},
+				{number: 2000, value: []byte(strings.Repeat("z", 1000))},
+			},
+			payload: []byte("bad"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMessage(tt.m.marshal())
+			if err != nil {
+				t.Fatalf("parseMessage: %v", err)
+			}
+			want := *tt.m
+			want.options = sortedOptions(tt.m.options)
+			if !sameMessage(got, &want) {
+				t.Errorf("got %+v, want %+v", got, &want)
+			}
+		})
+	}
+}
+
+func TestUintOption(t *testing.T) {
+	tests := []struct {
+		v    uint32
+		want []byte
+	}{
+		{0, nil},
+		{60, []byte{60}},
+		{0x1234, []byte{0x12, 0x34}},
+		{0x01000000, []byte{1, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		o := uintOption(optionContentFormat, tt.v)
+		if !bytes.Equal(o.value, tt.want) {
+			t.Errorf("uintOption(%d) = %x, want %x", tt.v, o.value, tt.want)
+		}
+		m := &message{options: []option{o}}
+		if v, ok := m.uint(optionContentFormat); !ok || v != tt.v {
+			t.Errorf("uint = %d, %v, want %d", v, ok, tt.v)
+		}
+	}
+}
+
+func FuzzParseMessage(f *testing.F) {
+	for _, seed := range []string{
+		"52 02 1234 abcd b8 72656164696e6773 01 61 12 003c ff a0",
+		"40 02 0000 d1 07 78",
+		"40 02 0000 e1 0000 78 ff 00",
+		"60 00 0001",
+	} {
+		f.Add(mustHex(f, seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// A message that parses encodes to one that parses the same
+		m, err := parseMessage(data)
+		if err != nil {
+			return
+		}
+		again, err := parseMessage(m.marshal())
+		if err != nil {
+			t.Fatalf("parsing the marshaled message: %v", err)
+		}
+		if !sameMessage(again, m) {
+			t.Fatalf("round trip changed %+v to %+v", m, again)
+		}
+	})
+}
+
+// sortedOptions returns options in the order marshal writes them
+func sortedOptions(options []option) []option {
+	sorted := append([]option(nil), options...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].number < sorted[j].number })
+	return sorted
+}
+
+func sameMessage(a, b *message) bool {
+	if a.typ != b.typ || a.code != b.code || a.messageID != b.messageID ||
+		!bytes.Equal(a.token, b.token) || !bytes.Equal(a.payload, b.payload) || len(a.options) != len(b.options) {
+		return false
+	}
+	for i := range a.options {
+		if a.options[i].number != b.options[i].number || !bytes.Equal(a.options[i].value, b.options[i].value) {
+			return false
+		}
+	}
+	return true
+}
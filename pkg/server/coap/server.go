@@ -0,0 +1,346 @@
+// Package coap ingests readings from microcontrollers, such as ESP8266 and
+// Arduino probes, over CoAP (RFC 7252) on UDP, so they can report without an
+// agent or gateway in between.
+//
+// Probes POST to the readings path with a CBOR (Content-Format 60) or JSON
+// (50) payload of short keys:
+//
+//	{"c": "probe-1", "k": "<api key>", "s": "tub", "t": 38.5}
+//	{"c": "probe-1", "r": [{"s": "water", "t": 38.5, "y": "water"}, {"s": "air", "t": 21}]}
+//
+// c is the client ID and k its API key, needed only for clients with one.
+// Each reading has a sensor ID s, a temperature in °C t, and optionally a
+// sensor type y and name n. Readings are timestamped when they arrive.
+package coap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Path is where probes POST readings
+const Path = "readings"
+
+const (
+	// maxMessageSize is the largest datagram read; RFC 7252 expects 1152
+	// bytes, and larger payloads would need blockwise transfer
+	maxMessageSize = 1280
+	// exchangeLifetime is how long a confirmable request may be retransmitted,
+	// and so how long its response is kept to answer duplicates
+	exchangeLifetime = 247 * time.Second
+	// maxReadings bounds the readings in one request
+	maxReadings = 64
+	// submitTimeout bounds storing one request's readings
+	submitTimeout = 10 * time.Second
+	// apiKeyHeader is the metadata key SubmitTemperature reads API keys from
+	apiKeyHeader = "x-api-key"
+)
+
+var requests = metrics.NewCounterVec(
+	"jacuzzi_coap_requests_total",
+	"CoAP requests received, by response code.",
+	"code",
+)
+
+// SubmitFunc stores readings received over CoAP
+type SubmitFunc func(ctx context.Context, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error)
+
+// Server answers CoAP requests on a UDP socket
+type Server struct {
+	conn   net.PacketConn
+	submit SubmitFunc
+
+	mu        sync.Mutex
+	responses map[string]response // Keyed by source address and message ID
+	pruned    time.Time
+	messageID uint16
+}
+
+// response is sent again for retransmissions of its request; data is nil
+// while the request is being handled
+type response struct {
+	data    []byte
+	expires time.Time
+}
+
+// Listen opens the UDP socket, as host:port
+func Listen(address string, submit SubmitFunc) (*Server, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for CoAP: %w", err)
+	}
+	return &Server{
+		conn:      conn,
+		submit:    submit,
+		responses: make(map[string]response),
+		pruned:    time.Now(),
+		messageID: uint16(rand.UintN(1 << 16)),
+	}, nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Run serves requests until ctx is done, then closes the socket. Each request
+// is handled in its own goroutine, so a slow database does not hold up others.
+func (s *Server) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.conn.Close()
+	}()
+
+	buf := make([]byte, maxMessageSize+1)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Failed to read CoAP request: %v", err)
+			continue
+		}
+		if n > maxMessageSize {
+			requests.Inc(codeString(codeRequestEntityTooLarge))
+			continue
+		}
+		data := append([]byte(nil), buf[:n]...)
+		go s.handle(ctx, data, addr)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, data []byte, addr net.Addr) {
+	req, err := parseMessage(data)
+	if err != nil {
+		// Malformed messages are silently ignored, as RFC 7252 asks
+		return
+	}
+	switch req.typ {
+	case typeConfirmable, typeNonConfirmable:
+	default:
+		return
+	}
+	// An empty confirmable message is a ping, answered with a reset
+	if req.code == codeEmpty {
+		if req.typ == typeConfirmable {
+			s.send(addr, (&message{typ: typeReset, messageID: req.messageID}).marshal())
+		}
+		return
+	}
+
+	// Answer retransmissions of a request with the response already sent,
+	// without storing its readings again, and ignore those that arrive while
+	// it is being handled
+	key := addr.String() + "/" + strconv.Itoa(int(req.messageID))
+	if data, ok := s.begin(key); ok {
+		if data != nil {
+			s.send(addr, data)
+		}
+		return
+	}
+
+	code, text := s.serve(ctx, req, addr)
+	requests.Inc(codeString(code))
+
+	resp := &message{code: code, token: req.token}
+	if text != "" {
+		resp.options = []option{uintOption(optionContentFormat, formatText)}
+		resp.payload = []byte(text)
+	}
+	if req.typ == typeConfirmable {
+		resp.typ = typeAcknowledgement
+		resp.messageID = req.messageID
+	} else {
+		resp.typ = typeNonConfirmable
+		resp.messageID = s.nextMessageID()
+	}
+	out := resp.marshal()
+	s.cache(key, out)
+	s.send(addr, out)
+}
+
+// serve handles a request, returning the response code and diagnostic text
+func (s *Server) serve(ctx context.Context, req *message, addr net.Addr) (byte, string) {
+	if number, ok := req.unknownCritical(); ok {
+		return codeBadOption, fmt.Sprintf("unsupported option %d", number)
+	}
+	if req.path() != Path {
+		return codeNotFound, ""
+	}
+	if req.code != codePost {
+		return codeMethodNotAllowed, ""
+	}
+
+	var payload interface{}
+	var err error
+	format, ok := req.uint(optionContentFormat)
+	if !ok {
+		// Tiny clients often leave out the format; JSON objects start with a brace
+		format = formatCBOR
+		if len(req.payload) > 0 && req.payload[0] == '{' {
+			format = formatJSON
+		}
+	}
+	switch format {
+	case formatCBOR:
+		payload, err = decodeCBOR(req.payload)
+	case formatJSON:
+		err = json.Unmarshal(req.payload, &payload)
+	default:
+		return codeUnsupportedFormat, "payload must be CBOR or JSON"
+	}
+	if err != nil {
+		return codeBadRequest, fmt.Sprintf("invalid payload: %v", err)
+	}
+	submitReq, apiKey, err := parsePayload(payload)
+	if err != nil {
+		return codeBadRequest, err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, submitTimeout)
+	defer cancel()
+	if apiKey != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(apiKeyHeader, apiKey))
+	}
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	resp, err := s.submit(ctx, submitReq)
+	if err != nil {
+		return statusCode(err), status.Convert(err).Message()
+	}
+	if !resp.Success {
+		return codeBadRequest, resp.Message
+	}
+	if resp.AcceptedCount == 0 && resp.UnapprovedCount > 0 {
+		return codeForbidden, "client is not approved"
+	}
+	return codeChanged, ""
+}
+
+// parsePayload converts a decoded payload to readings and the API key
+func parsePayload(payload interface{}) (*temperaturev1.SubmitTemperatureRequest, string, error) {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return nil, "", errors.New("payload must be a map")
+	}
+	clientID, _ := fields["c"].(string)
+	if clientID == "" {
+		return nil, "", errors.New("missing client ID c")
+	}
+	apiKey, _ := fields["k"].(string)
+
+	items := []interface{}{fields}
+	if r, ok := fields["r"]; ok {
+		if items, ok = r.([]interface{}); !ok {
+			return nil, "", errors.New("r must be an array of readings")
+		}
+	}
+	if len(items) == 0 {
+		return nil, "", errors.New("no readings provided")
+	}
+	if len(items) > maxReadings {
+		return nil, "", fmt.Errorf("too many readings: %d exceeds limit of %d", len(items), maxReadings)
+	}
+
+	req := &temperaturev1.SubmitTemperatureRequest{}
+	for i, item := range items {
+		r, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("reading %d must be a map", i+1)
+		}
+		sensorID, _ := r["s"].(string)
+		if sensorID == "" {
+			return nil, "", fmt.Errorf("reading %d: missing sensor ID s", i+1)
+		}
+		celsius, ok := r["t"].(float64)
+		if !ok {
+			return nil, "", fmt.Errorf("reading %d: missing temperature t", i+1)
+		}
+		sensorType, _ := r["y"].(string)
+		name, _ := r["n"].(string)
+		if name == "" {
+			name = sensorID
+		}
+		req.Readings = append(req.Readings, &temperaturev1.TemperatureReading{
+			ClientId:           clientID,
+			SensorId:           sensorID,
+			TemperatureCelsius: celsius,
+			SensorType:         sensorType,
+			SensorName:         name,
+		})
+	}
+	return req, apiKey, nil
+}
+
+// statusCode maps the status SubmitTemperature failed with
+func statusCode(err error) byte {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return codeBadRequest
+	case codes.Unauthenticated:
+		return codeUnauthorized
+	case codes.PermissionDenied:
+		return codeForbidden
+	case codes.ResourceExhausted:
+		return codeTooManyRequests
+	}
+	return codeInternalServerError
+}
+
+// begin returns the response to a request seen before, nil while it is being
+// handled, or records that handling it has begun
+func (s *Server) begin(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if r, ok := s.responses[key]; ok && now.Before(r.expires) {
+		return r.data, true
+	}
+
+	// Evict expired responses now and then rather than on every request
+	if now.Sub(s.pruned) > time.Minute {
+		for k, r := range s.responses {
+			if now.After(r.expires) {
+				delete(s.responses, k)
+			}
+		}
+		s.pruned = now
+	}
+	s.responses[key] = response{expires: now.Add(exchangeLifetime)}
+	return nil, false
+}
+
+// cache keeps a response for retransmissions
+func (s *Server) cache(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[key] = response{data: data, expires: time.Now().Add(exchangeLifetime)}
+}
+
+func (s *Server) nextMessageID() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messageID++
+	return s.messageID
+}
+
+func (s *Server) send(addr net.Addr, data []byte) {
+	if _, err := s.conn.WriteTo(data, addr); err != nil {
+		log.Printf("Failed to send CoAP response to %s: %v", addr, err)
+	}
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	commandv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/command/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gorm.io/gorm"
+)
+
+// ActionTypeEmergency is the alert action type that queues a local action on
+// the alert's client
+const ActionTypeEmergency = "ACTION_TYPE_EMERGENCY"
+
+// EmergencyTTL is how long an emergency action waits for its client to poll
+const EmergencyTTL = 10 * time.Minute
+
+// Limits on the local actions an agent offers
+const (
+	maxLocalActions    = 32
+	maxLocalActionName = 64
+)
+
+// knownCapabilities are the capabilities this server can use; others reported
+// by newer agents are ignored
+var knownCapabilities = []string{models.CapabilityFanControl, models.CapabilityLocalActions, models.CapabilityBurstCapture, models.CapabilityRemoteConfig, models.CapabilityEventLog}
+
+// EncodeCapabilities stores the known capabilities an agent reported
+func EncodeCapabilities(capabilities []string) string {
+	return encodeList(capabilities, func(c string) bool {
+		return slices.Contains(knownCapabilities, c)
+	})
+}
+
+// EncodeLocalActions stores the local actions an agent offers, dropping names
+// that are empty or too long
+func EncodeLocalActions(actions []string) string {
+	return encodeList(actions, func(a string) bool {
+		return a != "" && len(a) <= maxLocalActionName
+	})
+}
+
+func encodeList(values []string, keep func(string) bool) string {
+	var kept []string
+	for _, v := range values {
+		if keep(v) && !slices.Contains(kept, v) && len(kept) < maxLocalActions {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(kept)
+	return string(data)
+}
+
+// DecodeList reads a list stored by EncodeCapabilities or EncodeLocalActions
+func DecodeList(stored string) []string {
+	var values []string
+	if stored != "" {
+		json.Unmarshal([]byte(stored), &values)
+	}
+	return values
+}
+
+// Queue stores a command for an agent to pick up on its next poll. The
+// action must already be validated.
+func Queue(db *gorm.DB, clientID, commandType string, action *commandv1.Command, ttl time.Duration, source string) (*models.Command, error) {
+	// Only the action is stored; the other fields are tracked by the model
+	payload, err := protojson.Marshal(&commandv1.Command{Action: action.Action})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command: %w", err)
+	}
+	command := &models.Command{
+		CommandID: uuid.New().String(),
+		ClientID:  clientID,
+		Type:      commandType,
+		Source:    source,
+		Payload:   string(payload),
+		Status:    models.CommandStatusPending,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(command).Error; err != nil {
+		return nil, fmt.Errorf("failed to create command: %w", err)
+	}
+	log.Printf("Queued %s command %s for client %s (source %s): %s", commandType, command.CommandID, clientID, source, payload)
+	return command, nil
+}
+
+// RunEmergencyActions queues the emergency actions of a rule that fired on
+// the alert's client. Clients that do not offer an action are skipped, since
+// each client opts in to the actions it runs.
+func RunEmergencyActions(db *gorm.DB, rule models.AlertRule, alert *models.Alert) {
+	if rule.Severity != "SEVERITY_CRITICAL" {
+		return
+	}
+
+	var client *models.Client
+	for _, action := range rule.Actions {
+		if action.Type != ActionTypeEmergency {
+			continue
+		}
+		var config map[string]string
+		json.Unmarshal([]byte(action.Config), &config)
+		name := config["action"]
+
+		if client == nil {
+			client = &models.Client{}
+			if err := db.Where("client_id = ?", alert.ClientID).First(client).Error; err != nil {
+				log.Printf("Skipping emergency actions of rule %s for alert %s: failed to get client %s: %v", rule.RuleID, alert.AlertID, alert.ClientID, err)
+				return
+			}
+		}
+		if !slices.Contains(DecodeList(client.Capabilities), models.CapabilityLocalActions) ||
+			!slices.Contains(DecodeList(client.LocalActions), name) {
+			log.Printf("Skipping emergency action %q of rule %s for alert %s: client %s does not offer it", name, rule.RuleID, alert.AlertID, alert.ClientID)
+			continue
+		}
+
+		command := &commandv1.Command{Action: &commandv1.Command_RunAction{RunAction: &commandv1.RunActionCommand{
+			Name:   name,
+			Reason: alert.Message,
+		}}}
+		if _, err := Queue(db, alert.ClientID, models.CommandTypeRunAction, command, EmergencyTTL, models.CommandSourceRule+rule.RuleID); err != nil {
+			log.Printf("Failed to queue emergency action %q of rule %s for alert %s: %v", name, rule.RuleID, alert.AlertID, err)
+		}
+	}
+}
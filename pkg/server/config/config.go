@@ -2,15 +2,52 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/nickheyer/jacuzzi/pkg/server/alertmanager"
+	"github.com/nickheyer/jacuzzi/pkg/server/clientip"
+	"github.com/nickheyer/jacuzzi/pkg/server/jobs"
+	"github.com/nickheyer/jacuzzi/pkg/server/kube"
+	"github.com/nickheyer/jacuzzi/pkg/server/power"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
+	Server        ServerConfig        `mapstructure:"server"`
+	Database      DatabaseConfig      `mapstructure:"database"`
+	Ingest        IngestConfig        `mapstructure:"ingest"`
+	Rollup        RollupConfig        `mapstructure:"rollup"`
+	Compression   CompressionConfig   `mapstructure:"compression"`
+	QueryCache    QueryCacheConfig    `mapstructure:"query_cache"`
+	Usage         UsageConfig         `mapstructure:"usage"`
+	Sensors       SensorsConfig       `mapstructure:"sensors"`
+	Clients       ClientsConfig       `mapstructure:"clients"`
+	Enrollment    EnrollmentConfig    `mapstructure:"enrollment"`
+	HA            HAConfig            `mapstructure:"ha"`
+	Events        EventsConfig        `mapstructure:"events"`
+	Bus           BusConfig           `mapstructure:"bus"`
+	CoAP          CoAPConfig          `mapstructure:"coap"`
+	GraphQL       GraphQLConfig       `mapstructure:"graphql"`
+	Debug         DebugConfig         `mapstructure:"debug"`
+	Reports       ReportsConfig       `mapstructure:"reports"`
+	Webhooks      WebhooksConfig      `mapstructure:"webhooks"`
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+	Scripting     ScriptingConfig     `mapstructure:"scripting"`
+	Power         PowerConfig         `mapstructure:"power"`
+	Kubernetes    KubernetesConfig    `mapstructure:"kubernetes"`
+	Hypervisor    HypervisorConfig    `mapstructure:"hypervisor"`
+	Redfish       RedfishConfig       `mapstructure:"redfish"`
+	VSphere       VSphereConfig       `mapstructure:"vsphere"`
+	Alertmanager  AlertmanagerConfig  `mapstructure:"alertmanager"`
+	AlertReceiver AlertReceiverConfig `mapstructure:"alert_receiver"`
+	Jobs          JobsConfig          `mapstructure:"jobs"`
+	Health        HealthConfig        `mapstructure:"health"`
 }
 
 type ServerConfig struct {
@@ -18,6 +55,42 @@ type ServerConfig struct {
 	Host     string `mapstructure:"host"`
 	HTTPPort int    `mapstructure:"http_port"`
 	HTTPHost string `mapstructure:"http_host"`
+
+	// Keepalive and connection management
+	KeepaliveTime                time.Duration `mapstructure:"keepalive_time"`
+	KeepaliveTimeout             time.Duration `mapstructure:"keepalive_timeout"`
+	KeepaliveMinTime             time.Duration `mapstructure:"keepalive_min_time"`
+	KeepalivePermitWithoutStream bool          `mapstructure:"keepalive_permit_without_stream"`
+	MaxConnectionIdle            time.Duration `mapstructure:"max_connection_idle"`
+	MaxConnectionAge             time.Duration `mapstructure:"max_connection_age"`
+	MaxConnectionAgeGrace        time.Duration `mapstructure:"max_connection_age_grace"`
+	MaxConcurrentStreams         uint32        `mapstructure:"max_concurrent_streams"`
+
+	// Message size limits in bytes
+	MaxRecvMsgSize int `mapstructure:"max_recv_msg_size"`
+	MaxSendMsgSize int `mapstructure:"max_send_msg_size"`
+
+	// Bearer token required by every RPC except those agents make, which use
+	// their API keys; empty disables the check
+	AuthToken string `mapstructure:"auth_token"`
+	// Serve the status page and charts without the auth token, for kiosk
+	// displays that cannot send it
+	PublicStatusPage bool `mapstructure:"public_status_page"`
+	// Requests per minute allowed from each caller address; 0 is unlimited
+	RateLimit int `mapstructure:"rate_limit"`
+	// Log every RPC, not only those failing with server errors
+	LogRequests bool `mapstructure:"log_requests"`
+	// Deadline for each unary RPC, cancelling its database queries when it
+	// passes; 0 leaves RPCs bounded only by the caller's deadline
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+	// Proxies, by address or CIDR range, whose X-Forwarded-For and X-Real-IP
+	// headers are trusted for the caller's address
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Where the process serving the listeners writes its PID, including after
+	// an upgrade hands them to a new process; empty writes none
+	PIDFile string `mapstructure:"pid_file"`
+	// Advertise the gRPC port over mDNS, for agents started with --server auto
+	MDNS bool `mapstructure:"mdns"`
 }
 
 type DatabaseConfig struct {
@@ -28,6 +101,340 @@ type DatabaseConfig struct {
 	Password string `mapstructure:"password"`
 	Name     string `mapstructure:"name"`
 	SSLMode  string `mapstructure:"sslmode"`
+	// Postgres statement_timeout for every session; 0 keeps the server's
+	StatementTimeout time.Duration `mapstructure:"statement_timeout"`
+	// Bytes the database may use, checked by storage forecasts along with
+	// the free disk space under a SQLite database; 0 for no quota
+	SizeQuota int64 `mapstructure:"size_quota"`
+}
+
+type IngestConfig struct {
+	MaxReadingsPerRequest int           `mapstructure:"max_readings_per_request"`
+	BatchIDTTL            time.Duration `mapstructure:"batch_id_ttl"`
+	MaxClockSkew          time.Duration `mapstructure:"max_clock_skew"`
+	ClockSkewAction       string        `mapstructure:"clock_skew_action"`
+
+	// Per-client quotas; 0 disables a quota
+	QuotaReadingsPerMinute int    `mapstructure:"quota_readings_per_minute"`
+	QuotaMaxSensors        int    `mapstructure:"quota_max_sensors"`
+	QuotaAction            string `mapstructure:"quota_action"`
+
+	Validation ValidationConfig `mapstructure:"validation"`
+}
+
+// ValidationConfig quarantines implausible readings at ingest instead of
+// storing them
+type ValidationConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Plausible range in °C by sensor type, with "default" for types
+	// without their own
+	Ranges map[string]RangeConfig `mapstructure:"ranges"`
+	// Values sensors report for a failed read, such as 65.535 (65535 m°C)
+	RejectValues []float64 `mapstructure:"reject_values"`
+	// Largest change in °C from the sensor's previous reading within
+	// SpikeWindow; 0 disables spike rejection
+	MaxSpike    float64       `mapstructure:"max_spike"`
+	SpikeWindow time.Duration `mapstructure:"spike_window"`
+}
+
+type RangeConfig struct {
+	Min float64 `mapstructure:"min"`
+	Max float64 `mapstructure:"max"`
+}
+
+type RollupConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	// Check late readings against threshold rules and record their breaches
+	// as resolved alerts
+	AlertBackfill bool `mapstructure:"alert_backfill"`
+}
+
+// CompressionConfig moves readings older than After into compressed chunks
+// of one sensor-hour each, which query endpoints decompress transparently
+type CompressionConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	After    time.Duration `mapstructure:"after"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// QueryCacheConfig keeps the results of stats, chart and status page
+// requests for TTL, until readings arrive for the clients they cover
+type QueryCacheConfig struct {
+	// Results kept at most; 0 disables the cache
+	Size int           `mapstructure:"size"`
+	TTL  time.Duration `mapstructure:"ttl"`
+}
+
+// UsageConfig counts RPCs by method and caller for the usage stats API
+type UsageConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// How often each replica adds its counts to the database
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+type SensorsConfig struct {
+	// Sensors with no reading for this long, while their client still
+	// reports, are flagged stale; 0 disables the check
+	StaleAfter         time.Duration `mapstructure:"stale_after"`
+	StaleCheckInterval time.Duration `mapstructure:"stale_check_interval"`
+	// Sensors with no reading for this long are retired and hidden from
+	// current views until they report again; 0 disables retirement
+	RetireAfter time.Duration `mapstructure:"retire_after"`
+	// How often sensors' data quality is scored; 0 disables scoring
+	QualityInterval time.Duration `mapstructure:"quality_interval"`
+	// Readings each score covers
+	QualityWindow time.Duration `mapstructure:"quality_window"`
+	// Mean change between successive readings, in °C, above which a sensor
+	// is noisy; 0 disables the check
+	QualityNoiseLimit float64 `mapstructure:"quality_noise_limit"`
+	// How often the time sensors spend above thresholds is tracked, and
+	// thermal budget rules evaluated; 0 disables tracking
+	DutyCycleInterval time.Duration `mapstructure:"duty_cycle_interval"`
+	// Thresholds in °C tracked by sensor type, with "default" for types
+	// without their own, besides those of thermal budget rules
+	DutyCycleThresholds map[string][]float64 `mapstructure:"duty_cycle_thresholds"`
+}
+
+type ClientsConfig struct {
+	// Clients that have not reported for this long are marked offline, which
+	// notifies client.offline webhooks
+	OfflineAfter time.Duration `mapstructure:"offline_after"`
+}
+
+type EnrollmentConfig struct {
+	// open accepts new clients immediately, approval holds them as pending,
+	// and token only accepts clients enrolled with an enrollment token
+	Mode string `mapstructure:"mode"`
+}
+
+type HAConfig struct {
+	// When enabled, replicas sharing a database elect a leader through a lease
+	// and only the leader runs background workers; all replicas serve RPCs
+	Enabled    bool          `mapstructure:"enabled"`
+	LeaseTTL   time.Duration `mapstructure:"lease_ttl"`
+	InstanceID string        `mapstructure:"instance_id"`
+}
+
+type EventsConfig struct {
+	// local keeps streamed readings within one server; redis or nats share
+	// them so stream subscribers on any replica see readings from all of them
+	Backend string `mapstructure:"backend"`
+	URL     string `mapstructure:"url"`
+	Prefix  string `mapstructure:"prefix"`
+}
+
+type CoAPConfig struct {
+	// Ingest readings from microcontroller probes over CoAP on UDP
+	Enabled bool   `mapstructure:"enabled"`
+	Host    string `mapstructure:"host"`
+	Port    int    `mapstructure:"port"`
+}
+
+type BusConfig struct {
+	// Export readings and alert events to an external message bus, and
+	// optionally ingest readings from it. Only nats is supported.
+	Backend        string `mapstructure:"backend"`
+	URL            string `mapstructure:"url"`
+	SubjectPrefix  string `mapstructure:"subject_prefix"`
+	Format         string `mapstructure:"format"`
+	ConsumeSubject string `mapstructure:"consume_subject"`
+}
+
+type GraphQLConfig struct {
+	// Serve read-only GraphQL queries at /graphql on the HTTP port
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// DebugConfig serves pprof profiles, expvar and dump triggers under /debug/
+// on the HTTP port, behind the auth token
+type DebugConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Where goroutine and heap dumps are written
+	DumpDir string `mapstructure:"dump_dir"`
+}
+
+type ReportsConfig struct {
+	// How long report download links, and the stored reports, remain valid
+	URLTTL time.Duration `mapstructure:"url_ttl"`
+	// Key that signs download links; generated and stored in the database
+	// when empty, so every replica shares it
+	SigningKey string `mapstructure:"signing_key"`
+	// Prefixed to download links, e.g. https://jacuzzi.example.com; links are
+	// relative to the HTTP server when empty
+	BaseURL string `mapstructure:"base_url"`
+}
+
+type WebhooksConfig struct {
+	// How long a webhook endpoint has to respond to each delivery attempt
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type NotificationsConfig struct {
+	// How long a notification channel has to accept each delivery attempt
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type ScriptingConfig struct {
+	// Run enabled automation scripts on readings and alerts
+	Enabled bool `mapstructure:"enabled"`
+	// How long one script handler may run, including its queries and webhook
+	// calls
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Starlark execution steps one script handler may take; 0 is unlimited
+	MaxSteps uint64 `mapstructure:"max_steps"`
+}
+
+type PowerConfig struct {
+	// Serve the PowerService RPCs that wake machines with Wake-on-LAN and
+	// control their power through IPMI
+	Enabled bool `mapstructure:"enabled"`
+	// ipmitool binary used for IPMI power actions
+	IPMIToolPath string `mapstructure:"ipmitool_path"`
+	// How long one power action may take
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Default address (host[:port]) Wake-on-LAN packets are broadcast to;
+	// clients on other subnets can set their own
+	WakeBroadcast string `mapstructure:"wake_broadcast"`
+	// Key BMC passwords are encrypted with in the database; they cannot be
+	// set without one
+	SecretKey string `mapstructure:"secret_key"`
+}
+
+type KubernetesConfig struct {
+	// Periodically match clients to the cluster's nodes and copy node labels
+	// into client metadata
+	Enabled bool `mapstructure:"enabled"`
+	// API server URL; empty uses the in-cluster service address
+	APIServer string `mapstructure:"api_server"`
+	// Bearer token file and CA bundle used to reach the API server; in a
+	// cluster they default to the pod's service account
+	TokenFile string `mapstructure:"token_file"`
+	CAFile    string `mapstructure:"ca_file"`
+	// How often nodes are synced, and how long one API request may take
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// Only sync nodes matching this label selector; empty is all nodes
+	LabelSelector string `mapstructure:"label_selector"`
+	// Copy node labels into client metadata as k8s.label.<key>
+	ImportLabels bool `mapstructure:"import_labels"`
+	// Set the JacuzziThermalCritical node condition while the node's client
+	// has an active critical alert
+	SetCondition bool `mapstructure:"set_condition"`
+	// Taint nodes with jacuzzi.io/thermal-critical and this effect while their
+	// client has an active critical alert; empty disables tainting
+	TaintEffect string `mapstructure:"taint_effect"`
+}
+
+type HypervisorConfig struct {
+	// Periodically match clients to Proxmox and libvirt hosts and record each
+	// host's VM count and load in its client's metadata
+	Enabled bool `mapstructure:"enabled"`
+	// How often hosts are synced, and how long one API request may take
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// Proxmox VE clusters, reached through their REST API
+	Proxmox []ProxmoxConfig `mapstructure:"proxmox"`
+	// libvirt connection URIs, e.g. qemu+ssh://root@kvm1/system, read with virsh
+	Libvirt   []string `mapstructure:"libvirt"`
+	VirshPath string   `mapstructure:"virsh_path"`
+	// Notify hypervisor.migration_requested webhooks when a host with running
+	// VMs raises a critical alert
+	RequestMigration bool `mapstructure:"request_migration"`
+}
+
+type RedfishConfig struct {
+	// Periodically poll the thermal data of server BMCs and record each BMC's
+	// temperatures and fan speeds as readings of a client representing it
+	Enabled bool `mapstructure:"enabled"`
+	// How often BMCs are polled, and how long one API request may take
+	Interval  time.Duration           `mapstructure:"interval"`
+	Timeout   time.Duration           `mapstructure:"timeout"`
+	Endpoints []RedfishEndpointConfig `mapstructure:"endpoints"`
+}
+
+type RedfishEndpointConfig struct {
+	Name               string `mapstructure:"name"`
+	URL                string `mapstructure:"url"`
+	Username           string `mapstructure:"username"`
+	Password           string `mapstructure:"password"`
+	CAFile             string `mapstructure:"ca_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+type VSphereConfig struct {
+	// Periodically poll the hardware sensors of ESXi hosts through vCenter
+	// Servers or the hosts themselves and record each host's temperatures and
+	// fan speeds under a client representing it
+	Enabled bool `mapstructure:"enabled"`
+	// How often hosts are polled, and how long one API request may take
+	Interval  time.Duration           `mapstructure:"interval"`
+	Timeout   time.Duration           `mapstructure:"timeout"`
+	Endpoints []VSphereEndpointConfig `mapstructure:"endpoints"`
+}
+
+type VSphereEndpointConfig struct {
+	URL                string `mapstructure:"url"`
+	Username           string `mapstructure:"username"`
+	Password           string `mapstructure:"password"`
+	CAFile             string `mapstructure:"ca_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+type AlertmanagerConfig struct {
+	// Periodically send active and newly resolved alerts to Prometheus
+	// Alertmanager through its v2 API
+	Enabled bool `mapstructure:"enabled"`
+	// Base URLs of every Alertmanager replica, e.g. http://alertmanager:9093
+	URLs []string `mapstructure:"urls"`
+	// How often alerts are sent, and how long one request may take
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// Basic auth credentials, or a bearer token, for a proxy in front
+	Username           string `mapstructure:"username"`
+	Password           string `mapstructure:"password"`
+	BearerToken        string `mapstructure:"bearer_token"`
+	CAFile             string `mapstructure:"ca_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	// Labels added to every alert; the alert's own labels win
+	Labels map[string]string `mapstructure:"labels"`
+	// URL of the Jacuzzi UI, linked from each alert
+	ExternalURL string `mapstructure:"external_url"`
+}
+
+type AlertReceiverConfig struct {
+	// Accept Alertmanager and Grafana webhook notifications on the HTTP port
+	// and record their alerts under the clients they name
+	Enabled bool `mapstructure:"enabled"`
+	// Bearer token senders must present; empty requires the server's auth
+	// token instead
+	Token string `mapstructure:"token"`
+}
+
+type JobsConfig struct {
+	// Schedules of background jobs by name, replacing their intervals: a
+	// duration such as 5m, a cron expression in the general.timezone setting's
+	// timezone such as "0 3 * * *", or off to only run them on demand
+	Schedules map[string]string `mapstructure:"schedules"`
+}
+
+type HealthConfig struct {
+	// Record the server's free disk space, memory and database errors as
+	// readings of a client representing the server, so alert rules cover it
+	Enabled bool `mapstructure:"enabled"`
+	// How often each replica samples its health
+	Interval time.Duration `mapstructure:"interval"`
+	// Client the server reports as; defaults to jacuzzi-server-<hostname> so
+	// each replica reports separately
+	ClientID string `mapstructure:"client_id"`
+}
+
+type ProxmoxConfig struct {
+	URL                string `mapstructure:"url"`
+	TokenID            string `mapstructure:"token_id"`
+	TokenSecret        string `mapstructure:"token_secret"`
+	CAFile             string `mapstructure:"ca_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
 }
 
 func Load() (*Config, error) {
@@ -43,6 +450,24 @@ func Load() (*Config, error) {
 	viper.SetDefault("server.host", "")
 	viper.SetDefault("server.http_port", 8080)
 	viper.SetDefault("server.http_host", "")
+	viper.SetDefault("server.keepalive_time", 2*time.Minute)
+	viper.SetDefault("server.keepalive_timeout", 20*time.Second)
+	viper.SetDefault("server.keepalive_min_time", 30*time.Second)
+	viper.SetDefault("server.keepalive_permit_without_stream", true)
+	viper.SetDefault("server.max_connection_idle", 0)
+	viper.SetDefault("server.max_connection_age", 0)
+	viper.SetDefault("server.max_connection_age_grace", 0)
+	viper.SetDefault("server.max_concurrent_streams", 0)
+	viper.SetDefault("server.max_recv_msg_size", 16*1024*1024)
+	viper.SetDefault("server.max_send_msg_size", 16*1024*1024)
+	viper.SetDefault("server.auth_token", "")
+	viper.SetDefault("server.public_status_page", false)
+	viper.SetDefault("server.rate_limit", 0)
+	viper.SetDefault("server.log_requests", false)
+	viper.SetDefault("server.request_timeout", 30*time.Second)
+	viper.SetDefault("server.trusted_proxies", []string{})
+	viper.SetDefault("server.pid_file", "")
+	viper.SetDefault("server.mdns", false)
 	viper.SetDefault("database.type", "sqlite")
 	viper.SetDefault("database.host", "localhost")
 	viper.SetDefault("database.port", 5432)
@@ -50,6 +475,109 @@ func Load() (*Config, error) {
 	viper.SetDefault("database.password", "")
 	viper.SetDefault("database.name", "data/db/jacuzzi.db")
 	viper.SetDefault("database.sslmode", "disable")
+	viper.SetDefault("database.statement_timeout", 0)
+	viper.SetDefault("database.size_quota", 0)
+	viper.SetDefault("ingest.max_readings_per_request", 5000)
+	viper.SetDefault("ingest.batch_id_ttl", 10*time.Minute)
+	viper.SetDefault("ingest.max_clock_skew", 5*time.Minute)
+	viper.SetDefault("ingest.clock_skew_action", "accept")
+	viper.SetDefault("ingest.quota_readings_per_minute", 0)
+	viper.SetDefault("ingest.quota_max_sensors", 0)
+	viper.SetDefault("ingest.quota_action", "throttle")
+	viper.SetDefault("ingest.validation.enabled", true)
+	viper.SetDefault("ingest.validation.ranges", map[string]interface{}{
+		"default": map[string]interface{}{"min": -50.0, "max": 150.0},
+	})
+	viper.SetDefault("ingest.validation.reject_values", []float64{65.535})
+	viper.SetDefault("ingest.validation.max_spike", 0)
+	viper.SetDefault("ingest.validation.spike_window", 5*time.Minute)
+	viper.SetDefault("rollup.enabled", true)
+	viper.SetDefault("rollup.interval", time.Minute)
+	viper.SetDefault("rollup.alert_backfill", false)
+	viper.SetDefault("compression.enabled", false)
+	viper.SetDefault("compression.after", 7*24*time.Hour)
+	viper.SetDefault("compression.interval", time.Hour)
+	viper.SetDefault("query_cache.size", 500)
+	viper.SetDefault("query_cache.ttl", 30*time.Second)
+	viper.SetDefault("usage.enabled", true)
+	viper.SetDefault("usage.flush_interval", time.Minute)
+	viper.SetDefault("sensors.stale_after", 10*time.Minute)
+	viper.SetDefault("sensors.stale_check_interval", time.Minute)
+	viper.SetDefault("sensors.retire_after", 30*24*time.Hour)
+	viper.SetDefault("sensors.quality_interval", time.Hour)
+	viper.SetDefault("sensors.quality_window", 24*time.Hour)
+	viper.SetDefault("sensors.quality_noise_limit", 5.0)
+	viper.SetDefault("sensors.duty_cycle_interval", 5*time.Minute)
+	viper.SetDefault("sensors.duty_cycle_thresholds", map[string]interface{}{
+		"cpu":  []float64{80, 90},
+		"gpu":  []float64{80, 90},
+		"disk": []float64{50, 60},
+	})
+	viper.SetDefault("clients.offline_after", 5*time.Minute)
+	viper.SetDefault("enrollment.mode", "open")
+	viper.SetDefault("ha.enabled", false)
+	viper.SetDefault("ha.lease_ttl", 15*time.Second)
+	viper.SetDefault("ha.instance_id", "")
+	viper.SetDefault("events.backend", "local")
+	viper.SetDefault("events.url", "")
+	viper.SetDefault("events.prefix", "jacuzzi")
+	viper.SetDefault("bus.backend", "")
+	viper.SetDefault("bus.url", "")
+	viper.SetDefault("bus.subject_prefix", "jacuzzi.export")
+	viper.SetDefault("bus.format", "protobuf")
+	viper.SetDefault("bus.consume_subject", "")
+	viper.SetDefault("coap.enabled", false)
+	viper.SetDefault("coap.host", "")
+	viper.SetDefault("coap.port", 5683)
+	viper.SetDefault("graphql.enabled", false)
+	viper.SetDefault("debug.enabled", false)
+	viper.SetDefault("debug.dump_dir", "./dumps")
+	viper.SetDefault("reports.url_ttl", 24*time.Hour)
+	viper.SetDefault("reports.signing_key", "")
+	viper.SetDefault("reports.base_url", "")
+	viper.SetDefault("webhooks.timeout", 10*time.Second)
+	viper.SetDefault("notifications.timeout", 10*time.Second)
+	viper.SetDefault("scripting.enabled", false)
+	viper.SetDefault("scripting.timeout", 5*time.Second)
+	viper.SetDefault("scripting.max_steps", 1000000)
+	viper.SetDefault("power.enabled", false)
+	viper.SetDefault("power.ipmitool_path", "ipmitool")
+	viper.SetDefault("power.timeout", 30*time.Second)
+	viper.SetDefault("power.wake_broadcast", "255.255.255.255:9")
+	viper.SetDefault("power.secret_key", "")
+	viper.SetDefault("kubernetes.enabled", false)
+	viper.SetDefault("kubernetes.api_server", "")
+	viper.SetDefault("kubernetes.token_file", "")
+	viper.SetDefault("kubernetes.ca_file", "")
+	viper.SetDefault("kubernetes.interval", time.Minute)
+	viper.SetDefault("kubernetes.timeout", 10*time.Second)
+	viper.SetDefault("kubernetes.label_selector", "")
+	viper.SetDefault("kubernetes.import_labels", true)
+	viper.SetDefault("kubernetes.set_condition", false)
+	viper.SetDefault("kubernetes.taint_effect", "")
+	viper.SetDefault("hypervisor.enabled", false)
+	viper.SetDefault("hypervisor.interval", time.Minute)
+	viper.SetDefault("hypervisor.timeout", 10*time.Second)
+	viper.SetDefault("hypervisor.virsh_path", "virsh")
+	viper.SetDefault("hypervisor.request_migration", false)
+	viper.SetDefault("redfish.enabled", false)
+	viper.SetDefault("redfish.interval", time.Minute)
+	viper.SetDefault("redfish.timeout", 10*time.Second)
+	viper.SetDefault("vsphere.enabled", false)
+	viper.SetDefault("vsphere.interval", time.Minute)
+	viper.SetDefault("vsphere.timeout", 30*time.Second)
+	viper.SetDefault("alertmanager.enabled", false)
+	viper.SetDefault("alertmanager.urls", []string{})
+	viper.SetDefault("alertmanager.interval", time.Minute)
+	viper.SetDefault("alertmanager.timeout", 10*time.Second)
+	viper.SetDefault("alertmanager.labels", map[string]string{})
+	viper.SetDefault("alertmanager.external_url", "")
+	viper.SetDefault("alert_receiver.enabled", false)
+	viper.SetDefault("alert_receiver.token", "")
+	viper.SetDefault("jobs.schedules", map[string]string{})
+	viper.SetDefault("health.enabled", true)
+	viper.SetDefault("health.interval", time.Minute)
+	viper.SetDefault("health.client_id", "")
 
 	// Environment variables
 	viper.SetEnvPrefix("JACUZZI")
@@ -60,6 +588,24 @@ func Load() (*Config, error) {
 	viper.BindEnv("server.host", "JACUZZI_SERVER_HOST")
 	viper.BindEnv("server.http_port", "JACUZZI_SERVER_HTTP_PORT")
 	viper.BindEnv("server.http_host", "JACUZZI_SERVER_HTTP_HOST")
+	viper.BindEnv("server.keepalive_time", "JACUZZI_SERVER_KEEPALIVE_TIME")
+	viper.BindEnv("server.keepalive_timeout", "JACUZZI_SERVER_KEEPALIVE_TIMEOUT")
+	viper.BindEnv("server.keepalive_min_time", "JACUZZI_SERVER_KEEPALIVE_MIN_TIME")
+	viper.BindEnv("server.keepalive_permit_without_stream", "JACUZZI_SERVER_KEEPALIVE_PERMIT_WITHOUT_STREAM")
+	viper.BindEnv("server.max_connection_idle", "JACUZZI_SERVER_MAX_CONNECTION_IDLE")
+	viper.BindEnv("server.max_connection_age", "JACUZZI_SERVER_MAX_CONNECTION_AGE")
+	viper.BindEnv("server.max_connection_age_grace", "JACUZZI_SERVER_MAX_CONNECTION_AGE_GRACE")
+	viper.BindEnv("server.max_concurrent_streams", "JACUZZI_SERVER_MAX_CONCURRENT_STREAMS")
+	viper.BindEnv("server.max_recv_msg_size", "JACUZZI_SERVER_MAX_RECV_MSG_SIZE")
+	viper.BindEnv("server.max_send_msg_size", "JACUZZI_SERVER_MAX_SEND_MSG_SIZE")
+	viper.BindEnv("server.auth_token", "JACUZZI_SERVER_AUTH_TOKEN")
+	viper.BindEnv("server.public_status_page", "JACUZZI_SERVER_PUBLIC_STATUS_PAGE")
+	viper.BindEnv("server.rate_limit", "JACUZZI_SERVER_RATE_LIMIT")
+	viper.BindEnv("server.log_requests", "JACUZZI_SERVER_LOG_REQUESTS")
+	viper.BindEnv("server.request_timeout", "JACUZZI_SERVER_REQUEST_TIMEOUT")
+	viper.BindEnv("server.trusted_proxies", "JACUZZI_SERVER_TRUSTED_PROXIES")
+	viper.BindEnv("server.pid_file", "JACUZZI_SERVER_PID_FILE")
+	viper.BindEnv("server.mdns", "JACUZZI_SERVER_MDNS")
 	viper.BindEnv("database.type", "JACUZZI_DB_TYPE")
 	viper.BindEnv("database.host", "JACUZZI_DB_HOST")
 	viper.BindEnv("database.port", "JACUZZI_DB_PORT")
@@ -67,6 +613,98 @@ func Load() (*Config, error) {
 	viper.BindEnv("database.password", "JACUZZI_DB_PASSWORD")
 	viper.BindEnv("database.name", "JACUZZI_DB_NAME")
 	viper.BindEnv("database.sslmode", "JACUZZI_DB_SSLMODE")
+	viper.BindEnv("database.statement_timeout", "JACUZZI_DB_STATEMENT_TIMEOUT")
+	viper.BindEnv("database.size_quota", "JACUZZI_DB_SIZE_QUOTA")
+	viper.BindEnv("ingest.max_readings_per_request", "JACUZZI_INGEST_MAX_READINGS_PER_REQUEST")
+	viper.BindEnv("ingest.batch_id_ttl", "JACUZZI_INGEST_BATCH_ID_TTL")
+	viper.BindEnv("ingest.max_clock_skew", "JACUZZI_INGEST_MAX_CLOCK_SKEW")
+	viper.BindEnv("ingest.clock_skew_action", "JACUZZI_INGEST_CLOCK_SKEW_ACTION")
+	viper.BindEnv("ingest.quota_readings_per_minute", "JACUZZI_INGEST_QUOTA_READINGS_PER_MINUTE")
+	viper.BindEnv("ingest.quota_max_sensors", "JACUZZI_INGEST_QUOTA_MAX_SENSORS")
+	viper.BindEnv("ingest.quota_action", "JACUZZI_INGEST_QUOTA_ACTION")
+	viper.BindEnv("ingest.validation.enabled", "JACUZZI_INGEST_VALIDATION_ENABLED")
+	viper.BindEnv("ingest.validation.max_spike", "JACUZZI_INGEST_VALIDATION_MAX_SPIKE")
+	viper.BindEnv("ingest.validation.spike_window", "JACUZZI_INGEST_VALIDATION_SPIKE_WINDOW")
+	viper.BindEnv("rollup.enabled", "JACUZZI_ROLLUP_ENABLED")
+	viper.BindEnv("rollup.interval", "JACUZZI_ROLLUP_INTERVAL")
+	viper.BindEnv("rollup.alert_backfill", "JACUZZI_ROLLUP_ALERT_BACKFILL")
+	viper.BindEnv("compression.enabled", "JACUZZI_COMPRESSION_ENABLED")
+	viper.BindEnv("compression.after", "JACUZZI_COMPRESSION_AFTER")
+	viper.BindEnv("compression.interval", "JACUZZI_COMPRESSION_INTERVAL")
+	viper.BindEnv("query_cache.size", "JACUZZI_QUERY_CACHE_SIZE")
+	viper.BindEnv("query_cache.ttl", "JACUZZI_QUERY_CACHE_TTL")
+	viper.BindEnv("usage.enabled", "JACUZZI_USAGE_ENABLED")
+	viper.BindEnv("usage.flush_interval", "JACUZZI_USAGE_FLUSH_INTERVAL")
+	viper.BindEnv("sensors.stale_after", "JACUZZI_SENSORS_STALE_AFTER")
+	viper.BindEnv("sensors.stale_check_interval", "JACUZZI_SENSORS_STALE_CHECK_INTERVAL")
+	viper.BindEnv("sensors.retire_after", "JACUZZI_SENSORS_RETIRE_AFTER")
+	viper.BindEnv("sensors.quality_interval", "JACUZZI_SENSORS_QUALITY_INTERVAL")
+	viper.BindEnv("sensors.quality_window", "JACUZZI_SENSORS_QUALITY_WINDOW")
+	viper.BindEnv("sensors.quality_noise_limit", "JACUZZI_SENSORS_QUALITY_NOISE_LIMIT")
+	viper.BindEnv("sensors.duty_cycle_interval", "JACUZZI_SENSORS_DUTY_CYCLE_INTERVAL")
+	viper.BindEnv("clients.offline_after", "JACUZZI_CLIENTS_OFFLINE_AFTER")
+	viper.BindEnv("enrollment.mode", "JACUZZI_ENROLLMENT_MODE")
+	viper.BindEnv("ha.enabled", "JACUZZI_HA_ENABLED")
+	viper.BindEnv("ha.lease_ttl", "JACUZZI_HA_LEASE_TTL")
+	viper.BindEnv("ha.instance_id", "JACUZZI_HA_INSTANCE_ID")
+	viper.BindEnv("events.backend", "JACUZZI_EVENTS_BACKEND")
+	viper.BindEnv("events.url", "JACUZZI_EVENTS_URL")
+	viper.BindEnv("events.prefix", "JACUZZI_EVENTS_PREFIX")
+	viper.BindEnv("bus.backend", "JACUZZI_BUS_BACKEND")
+	viper.BindEnv("bus.url", "JACUZZI_BUS_URL")
+	viper.BindEnv("bus.subject_prefix", "JACUZZI_BUS_SUBJECT_PREFIX")
+	viper.BindEnv("bus.format", "JACUZZI_BUS_FORMAT")
+	viper.BindEnv("bus.consume_subject", "JACUZZI_BUS_CONSUME_SUBJECT")
+	viper.BindEnv("coap.enabled", "JACUZZI_COAP_ENABLED")
+	viper.BindEnv("coap.host", "JACUZZI_COAP_HOST")
+	viper.BindEnv("coap.port", "JACUZZI_COAP_PORT")
+	viper.BindEnv("graphql.enabled", "JACUZZI_GRAPHQL_ENABLED")
+	viper.BindEnv("debug.enabled", "JACUZZI_DEBUG_ENABLED")
+	viper.BindEnv("debug.dump_dir", "JACUZZI_DEBUG_DUMP_DIR")
+	viper.BindEnv("reports.url_ttl", "JACUZZI_REPORTS_URL_TTL")
+	viper.BindEnv("reports.signing_key", "JACUZZI_REPORTS_SIGNING_KEY")
+	viper.BindEnv("reports.base_url", "JACUZZI_REPORTS_BASE_URL")
+	viper.BindEnv("webhooks.timeout", "JACUZZI_WEBHOOKS_TIMEOUT")
+	viper.BindEnv("notifications.timeout", "JACUZZI_NOTIFICATIONS_TIMEOUT")
+	viper.BindEnv("scripting.enabled", "JACUZZI_SCRIPTING_ENABLED")
+	viper.BindEnv("scripting.timeout", "JACUZZI_SCRIPTING_TIMEOUT")
+	viper.BindEnv("scripting.max_steps", "JACUZZI_SCRIPTING_MAX_STEPS")
+	viper.BindEnv("power.enabled", "JACUZZI_POWER_ENABLED")
+	viper.BindEnv("power.ipmitool_path", "JACUZZI_POWER_IPMITOOL_PATH")
+	viper.BindEnv("power.timeout", "JACUZZI_POWER_TIMEOUT")
+	viper.BindEnv("power.wake_broadcast", "JACUZZI_POWER_WAKE_BROADCAST")
+	viper.BindEnv("power.secret_key", "JACUZZI_POWER_SECRET_KEY")
+	viper.BindEnv("kubernetes.enabled", "JACUZZI_KUBERNETES_ENABLED")
+	viper.BindEnv("kubernetes.api_server", "JACUZZI_KUBERNETES_API_SERVER")
+	viper.BindEnv("kubernetes.token_file", "JACUZZI_KUBERNETES_TOKEN_FILE")
+	viper.BindEnv("kubernetes.ca_file", "JACUZZI_KUBERNETES_CA_FILE")
+	viper.BindEnv("kubernetes.interval", "JACUZZI_KUBERNETES_INTERVAL")
+	viper.BindEnv("kubernetes.timeout", "JACUZZI_KUBERNETES_TIMEOUT")
+	viper.BindEnv("kubernetes.label_selector", "JACUZZI_KUBERNETES_LABEL_SELECTOR")
+	viper.BindEnv("kubernetes.import_labels", "JACUZZI_KUBERNETES_IMPORT_LABELS")
+	viper.BindEnv("kubernetes.set_condition", "JACUZZI_KUBERNETES_SET_CONDITION")
+	viper.BindEnv("kubernetes.taint_effect", "JACUZZI_KUBERNETES_TAINT_EFFECT")
+	viper.BindEnv("hypervisor.enabled", "JACUZZI_HYPERVISOR_ENABLED")
+	viper.BindEnv("hypervisor.interval", "JACUZZI_HYPERVISOR_INTERVAL")
+	viper.BindEnv("hypervisor.timeout", "JACUZZI_HYPERVISOR_TIMEOUT")
+	viper.BindEnv("hypervisor.virsh_path", "JACUZZI_HYPERVISOR_VIRSH_PATH")
+	viper.BindEnv("hypervisor.request_migration", "JACUZZI_HYPERVISOR_REQUEST_MIGRATION")
+	viper.BindEnv("redfish.enabled", "JACUZZI_REDFISH_ENABLED")
+	viper.BindEnv("redfish.interval", "JACUZZI_REDFISH_INTERVAL")
+	viper.BindEnv("redfish.timeout", "JACUZZI_REDFISH_TIMEOUT")
+	viper.BindEnv("vsphere.enabled", "JACUZZI_VSPHERE_ENABLED")
+	viper.BindEnv("vsphere.interval", "JACUZZI_VSPHERE_INTERVAL")
+	viper.BindEnv("vsphere.timeout", "JACUZZI_VSPHERE_TIMEOUT")
+	viper.BindEnv("alertmanager.enabled", "JACUZZI_ALERTMANAGER_ENABLED")
+	viper.BindEnv("alertmanager.interval", "JACUZZI_ALERTMANAGER_INTERVAL")
+	viper.BindEnv("alertmanager.timeout", "JACUZZI_ALERTMANAGER_TIMEOUT")
+	viper.BindEnv("alertmanager.bearer_token", "JACUZZI_ALERTMANAGER_BEARER_TOKEN")
+	viper.BindEnv("alertmanager.external_url", "JACUZZI_ALERTMANAGER_EXTERNAL_URL")
+	viper.BindEnv("alert_receiver.enabled", "JACUZZI_ALERT_RECEIVER_ENABLED")
+	viper.BindEnv("alert_receiver.token", "JACUZZI_ALERT_RECEIVER_TOKEN")
+	viper.BindEnv("health.enabled", "JACUZZI_HEALTH_ENABLED")
+	viper.BindEnv("health.interval", "JACUZZI_HEALTH_INTERVAL")
+	viper.BindEnv("health.client_id", "JACUZZI_HEALTH_CLIENT_ID")
 
 	// Try to read config file
 	if err := viper.ReadInConfig(); err != nil {
@@ -81,6 +719,267 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	if config.Server.RateLimit < 0 {
+		return nil, fmt.Errorf("invalid server.rate_limit %d: must not be negative", config.Server.RateLimit)
+	}
+	if config.Server.RequestTimeout < 0 {
+		return nil, fmt.Errorf("invalid server.request_timeout %s: must not be negative", config.Server.RequestTimeout)
+	}
+	if _, err := clientip.NewResolver(config.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid server.trusted_proxies: %w", err)
+	}
+	if config.Database.StatementTimeout < 0 {
+		return nil, fmt.Errorf("invalid database.statement_timeout %s: must not be negative", config.Database.StatementTimeout)
+	}
+	if config.Database.SizeQuota < 0 {
+		return nil, fmt.Errorf("invalid database.size_quota %d: must not be negative", config.Database.SizeQuota)
+	}
+
+	if config.Rollup.Enabled && config.Rollup.Interval <= 0 {
+		return nil, fmt.Errorf("invalid rollup.interval %s: must be positive", config.Rollup.Interval)
+	}
+	if config.Compression.Enabled && config.Compression.After < 2*time.Hour {
+		return nil, fmt.Errorf("invalid compression.after %s: must be at least 2h", config.Compression.After)
+	}
+	if config.Compression.Enabled && config.Compression.Interval <= 0 {
+		return nil, fmt.Errorf("invalid compression.interval %s: must be positive", config.Compression.Interval)
+	}
+	if config.QueryCache.Size < 0 {
+		return nil, fmt.Errorf("invalid query_cache.size %d: must not be negative", config.QueryCache.Size)
+	}
+	if config.QueryCache.Size > 0 && config.QueryCache.TTL <= 0 {
+		return nil, fmt.Errorf("invalid query_cache.ttl %s: must be positive", config.QueryCache.TTL)
+	}
+	if config.Debug.Enabled && config.Server.AuthToken == "" {
+		return nil, fmt.Errorf("debug.enabled requires server.auth_token, since the debug endpoints expose the server's memory")
+	}
+	if config.Usage.Enabled && config.Usage.FlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid usage.flush_interval %s: must be positive", config.Usage.FlushInterval)
+	}
+
+	switch config.Ingest.ClockSkewAction {
+	case "accept", "rewrite", "reject":
+	default:
+		return nil, fmt.Errorf("invalid ingest.clock_skew_action %q: must be accept, rewrite, or reject", config.Ingest.ClockSkewAction)
+	}
+
+	switch config.Ingest.QuotaAction {
+	case "throttle", "reject":
+	default:
+		return nil, fmt.Errorf("invalid ingest.quota_action %q: must be throttle or reject", config.Ingest.QuotaAction)
+	}
+
+	for name, r := range config.Ingest.Validation.Ranges {
+		if name != "default" && sensortype.Normalize(name) == sensortype.Other && !strings.EqualFold(name, sensortype.Other) {
+			return nil, fmt.Errorf("invalid ingest.validation.ranges key %q: must be a sensor type or default", name)
+		}
+		if r.Min >= r.Max {
+			return nil, fmt.Errorf("invalid ingest.validation.ranges.%s: min %g must be below max %g", name, r.Min, r.Max)
+		}
+	}
+	if config.Ingest.Validation.MaxSpike < 0 {
+		return nil, fmt.Errorf("invalid ingest.validation.max_spike %g: must not be negative", config.Ingest.Validation.MaxSpike)
+	}
+	if config.Ingest.Validation.MaxSpike > 0 && config.Ingest.Validation.SpikeWindow <= 0 {
+		return nil, fmt.Errorf("invalid ingest.validation.spike_window %s: must be positive", config.Ingest.Validation.SpikeWindow)
+	}
+
+	switch config.Enrollment.Mode {
+	case "open", "approval", "token":
+	default:
+		return nil, fmt.Errorf("invalid enrollment.mode %q: must be open, approval, or token", config.Enrollment.Mode)
+	}
+
+	switch config.Events.Backend {
+	case "local":
+	case "redis", "nats":
+		if config.Events.URL == "" {
+			return nil, fmt.Errorf("events.url is required for the %s events backend", config.Events.Backend)
+		}
+	default:
+		return nil, fmt.Errorf("invalid events.backend %q: must be local, redis, or nats", config.Events.Backend)
+	}
+
+	switch config.Bus.Backend {
+	case "":
+	case "nats":
+		if config.Bus.URL == "" {
+			return nil, fmt.Errorf("bus.url is required for the %s bus backend", config.Bus.Backend)
+		}
+		// Consuming the export subject would re-ingest every exported reading
+		if config.Bus.ConsumeSubject == config.Bus.SubjectPrefix+".readings" {
+			return nil, fmt.Errorf("bus.consume_subject must differ from the export subject %s.readings", config.Bus.SubjectPrefix)
+		}
+	default:
+		return nil, fmt.Errorf("invalid bus.backend %q: must be empty or nats", config.Bus.Backend)
+	}
+
+	switch config.Bus.Format {
+	case "protobuf", "json":
+	default:
+		return nil, fmt.Errorf("invalid bus.format %q: must be protobuf or json", config.Bus.Format)
+	}
+
+	if config.HA.Enabled && config.HA.LeaseTTL < 3*time.Second {
+		return nil, fmt.Errorf("invalid ha.lease_ttl %s: must be at least 3s", config.HA.LeaseTTL)
+	}
+
+	if config.Sensors.StaleAfter < 0 {
+		return nil, fmt.Errorf("invalid sensors.stale_after %s: must not be negative", config.Sensors.StaleAfter)
+	}
+	if config.Sensors.RetireAfter < 0 {
+		return nil, fmt.Errorf("invalid sensors.retire_after %s: must not be negative", config.Sensors.RetireAfter)
+	}
+	if config.Sensors.StaleCheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid sensors.stale_check_interval %s: must be positive", config.Sensors.StaleCheckInterval)
+	}
+	if config.Sensors.QualityInterval < 0 {
+		return nil, fmt.Errorf("invalid sensors.quality_interval %s: must not be negative", config.Sensors.QualityInterval)
+	}
+	if config.Sensors.QualityInterval > 0 && config.Sensors.QualityWindow < time.Hour {
+		return nil, fmt.Errorf("invalid sensors.quality_window %s: must be at least 1h", config.Sensors.QualityWindow)
+	}
+	if config.Sensors.QualityNoiseLimit < 0 {
+		return nil, fmt.Errorf("invalid sensors.quality_noise_limit %g: must not be negative", config.Sensors.QualityNoiseLimit)
+	}
+	if config.Sensors.DutyCycleInterval < 0 {
+		return nil, fmt.Errorf("invalid sensors.duty_cycle_interval %s: must not be negative", config.Sensors.DutyCycleInterval)
+	}
+	for name := range config.Sensors.DutyCycleThresholds {
+		if name != "default" && sensortype.Normalize(name) == sensortype.Other && !strings.EqualFold(name, sensortype.Other) {
+			return nil, fmt.Errorf("invalid sensors.duty_cycle_thresholds key %q: must be a sensor type or default", name)
+		}
+	}
+
+	if config.Clients.OfflineAfter < time.Minute {
+		return nil, fmt.Errorf("invalid clients.offline_after %s: must be at least 1m", config.Clients.OfflineAfter)
+	}
+
+	if config.Reports.URLTTL < time.Minute {
+		return nil, fmt.Errorf("invalid reports.url_ttl %s: must be at least 1m", config.Reports.URLTTL)
+	}
+
+	if config.Webhooks.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid webhooks.timeout %s: must be positive", config.Webhooks.Timeout)
+	}
+
+	if config.Notifications.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid notifications.timeout %s: must be positive", config.Notifications.Timeout)
+	}
+
+	if config.Scripting.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid scripting.timeout %s: must be positive", config.Scripting.Timeout)
+	}
+
+	if config.Power.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid power.timeout %s: must be positive", config.Power.Timeout)
+	}
+
+	if _, err := power.WakeAddress(config.Power.WakeBroadcast); err != nil {
+		return nil, fmt.Errorf("invalid power.wake_broadcast %s: must be host or host:port", config.Power.WakeBroadcast)
+	}
+
+	if config.Kubernetes.Enabled {
+		if config.Kubernetes.Interval <= 0 || config.Kubernetes.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid kubernetes.interval %s or timeout %s: must be positive", config.Kubernetes.Interval, config.Kubernetes.Timeout)
+		}
+		if effect := config.Kubernetes.TaintEffect; effect != "" && !slices.Contains(kube.TaintEffects, effect) {
+			return nil, fmt.Errorf("invalid kubernetes.taint_effect %s: must be one of %s", effect, strings.Join(kube.TaintEffects, ", "))
+		}
+	}
+
+	if config.Hypervisor.Enabled {
+		if config.Hypervisor.Interval <= 0 || config.Hypervisor.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid hypervisor.interval %s or timeout %s: must be positive", config.Hypervisor.Interval, config.Hypervisor.Timeout)
+		}
+		if len(config.Hypervisor.Proxmox) == 0 && len(config.Hypervisor.Libvirt) == 0 {
+			return nil, fmt.Errorf("invalid hypervisor configuration: no proxmox clusters or libvirt hosts are configured")
+		}
+	}
+
+	if config.Redfish.Enabled {
+		if config.Redfish.Interval <= 0 || config.Redfish.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid redfish.interval %s or timeout %s: must be positive", config.Redfish.Interval, config.Redfish.Timeout)
+		}
+		if len(config.Redfish.Endpoints) == 0 {
+			return nil, fmt.Errorf("invalid redfish configuration: no endpoints are configured")
+		}
+		names := make(map[string]bool)
+		for i, endpoint := range config.Redfish.Endpoints {
+			if endpoint.Name == "" || strings.ContainsAny(endpoint.Name, " /\t") {
+				return nil, fmt.Errorf("invalid redfish.endpoints[%d].name %q: must be non-empty, without spaces or slashes", i, endpoint.Name)
+			}
+			if names[endpoint.Name] {
+				return nil, fmt.Errorf("invalid redfish.endpoints[%d].name %q: used by another endpoint", i, endpoint.Name)
+			}
+			names[endpoint.Name] = true
+			if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid redfish.endpoints[%d].url %q: must be an http or https URL", i, endpoint.URL)
+			}
+		}
+	}
+
+	if config.VSphere.Enabled {
+		if config.VSphere.Interval <= 0 || config.VSphere.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid vsphere.interval %s or timeout %s: must be positive", config.VSphere.Interval, config.VSphere.Timeout)
+		}
+		if len(config.VSphere.Endpoints) == 0 {
+			return nil, fmt.Errorf("invalid vsphere configuration: no endpoints are configured")
+		}
+		for i, endpoint := range config.VSphere.Endpoints {
+			if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid vsphere.endpoints[%d].url %q: must be an http or https URL", i, endpoint.URL)
+			}
+			if endpoint.Username == "" {
+				return nil, fmt.Errorf("invalid vsphere.endpoints[%d]: username is required", i)
+			}
+		}
+	}
+
+	if config.Alertmanager.Enabled {
+		if config.Alertmanager.Interval <= 0 || config.Alertmanager.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid alertmanager.interval %s or timeout %s: must be positive", config.Alertmanager.Interval, config.Alertmanager.Timeout)
+		}
+		if len(config.Alertmanager.URLs) == 0 {
+			return nil, fmt.Errorf("invalid alertmanager configuration: no urls are configured")
+		}
+		for i, u := range config.Alertmanager.URLs {
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, fmt.Errorf("invalid alertmanager.urls[%d] %q: must be an http or https URL", i, u)
+			}
+		}
+		if u := config.Alertmanager.ExternalURL; u != "" {
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, fmt.Errorf("invalid alertmanager.external_url %q: must be an http or https URL", u)
+			}
+		}
+		for name := range config.Alertmanager.Labels {
+			if !alertmanager.ValidLabelName(name) {
+				return nil, fmt.Errorf("invalid alertmanager.labels.%s: label names are letters, digits and underscores, not starting with a digit", name)
+			}
+		}
+	}
+
+	for name, schedule := range config.Jobs.Schedules {
+		if _, err := jobs.Parse(schedule); err != nil {
+			return nil, fmt.Errorf("invalid jobs.schedules.%s: %w", name, err)
+		}
+	}
+
+	if config.Health.Interval <= 0 {
+		return nil, fmt.Errorf("invalid health.interval %s: must be positive", config.Health.Interval)
+	}
+	if config.Health.ClientID == "" {
+		hostname, _ := os.Hostname()
+		config.Health.ClientID = "jacuzzi-server-" + hostname
+	}
+
+	// Each replica needs a distinct holder ID; default to hostname plus PID
+	if config.HA.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		config.HA.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
 	// Ensure data directory exists for SQLite
 	if config.Database.Type == "sqlite" {
 		dbDir := filepath.Dir(config.Database.Name)
@@ -98,3 +997,7 @@ func (c *Config) GetServerAddress() string {
 	}
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
+
+func (c *Config) GetHTTPAddress() string {
+	return fmt.Sprintf("%s:%d", c.Server.HTTPHost, c.Server.HTTPPort)
+}
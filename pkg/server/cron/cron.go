@@ -0,0 +1,213 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week. Fields take *, numbers, names (jan-dec,
+// sun-sat), ranges, lists and steps, e.g. "*/15 1-4 * * mon-fri".
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// As in cron, when both days are restricted either may match
+	domAny, dowAny bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    []string // Indexed from min
+}
+
+// Every minute and every hour, as bit sets
+const (
+	allMinutes = 1<<60 - 1
+	allHours   = 1<<24 - 1
+)
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is also Sunday
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// Parse parses a five field cron expression
+func Parse(expr string) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields, got %d", expr, len(fields), len(parts))
+	}
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := fields[i].parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		bits[i] = b
+	}
+	// Fold 7 into Sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &Schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(parts[2], "*"),
+		dowAny: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// Matches reports whether the minute containing t, in t's location, is in
+// the schedule
+func (s *Schedule) Matches(t time.Time) bool {
+	return s.minute&(1<<t.Minute()) != 0 && s.hour&(1<<t.Hour()) != 0 &&
+		s.month&(1<<int(t.Month())) != 0 && s.day(t)
+}
+
+// Next returns the start of the first minute after t in the schedule, in t's
+// location, or the zero time when none falls within five years, such as for
+// 30 February. As in cron, times of day that daylight saving skips fall on
+// the first minute after the clocks go forward, and times it repeats fall
+// only on their first pass, unless the minute or hour is every one.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	fixed := s.minute != allMinutes && s.hour != allHours
+	// Skip whole months, days and hours that cannot match
+	for t.Before(end) {
+		if fixed && s.skipped(t) {
+			return t
+		}
+		var next time.Time
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.day(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0, fixed && repeated(t):
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// A wall clock time skipped by daylight saving can normalize to an
+		// earlier time, so step by minutes through the gap
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// skipped reports whether clocks went forward at t past a time of day in the
+// schedule
+func (s *Schedule) skipped(t time.Time) bool {
+	if start, _ := t.ZoneBounds(); !start.Equal(t) {
+		return false
+	}
+	_, before := t.Add(-time.Second).Zone()
+	_, after := t.Zone()
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	for m := wall.Add(-time.Duration(after-before) * time.Second); m.Before(wall); m = m.Add(time.Minute) {
+		if s.Matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeated reports whether t's time of day already passed before clocks went
+// back
+func repeated(t time.Time) bool {
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return false
+	}
+	_, before := start.Add(-time.Second).Zone()
+	_, after := t.Zone()
+	return t.Sub(start) < time.Duration(before-after)*time.Second
+}
+
+// day reports whether t's day is in the schedule
+func (s *Schedule) day(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// parse returns the values a field covers as a bit set
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(expr, ",") {
+		spec, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, stepText)
+			}
+			step = n
+		}
+
+		var low, high int
+		switch {
+		case spec == "*":
+			low, high = f.min, f.max
+		case strings.Contains(spec, "-"):
+			lowText, highText, _ := strings.Cut(spec, "-")
+			var err error
+			if low, err = f.value(lowText); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(highText); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, spec)
+			}
+		default:
+			value, err := f.value(spec)
+			if err != nil {
+				return 0, err
+			}
+			low, high = value, value
+			// A single value with a step runs to the end, e.g. 5/15
+			if hasStep {
+				high = f.max
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or name within the field's range
+func (f field) value(text string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(text, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q: must be %d-%d", f.name, text, f.min, f.max)
+	}
+	return v, nil
+}
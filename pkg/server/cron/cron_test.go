@@ -0,0 +1,266 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s is not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestMatches(t *testing.T) {
+	utc := func(text string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", text)
+		if err != nil {
+			panic(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		expr  string
+		match []string
+		miss  []string
+	}{
+		{"* * * * *", []string{"2026-03-14 00:00", "2026-12-31 23:59"}, nil},
+		{"30 2 * * *", []string{"2026-03-14 02:30"}, []string{"2026-03-14 02:31", "2026-03-14 03:30"}},
+		// Ranges
+		{"* 9-17 * * *", []string{"2026-03-14 09:00", "2026-03-14 17:59"}, []string{"2026-03-14 08:59", "2026-03-14 18:00"}},
+		{"0 0 1-7 * *", []string{"2026-03-01 00:00", "2026-03-07 00:00"}, []string{"2026-03-08 00:00"}},
+		// Steps
+		{"*/15 * * * *", []string{"2026-03-14 10:00", "2026-03-14 10:15", "2026-03-14 10:45"}, []string{"2026-03-14 10:05", "2026-03-14 10:50"}},
+		{"5/20 * * * *", []string{"2026-03-14 10:05", "2026-03-14 10:25", "2026-03-14 10:45"}, []string{"2026-03-14 10:00", "2026-03-14 10:20"}},
+		{"0 8-18/4 * * *", []string{"2026-03-14 08:00", "2026-03-14 12:00", "2026-03-14 16:00"}, []string{"2026-03-14 10:00", "2026-03-14 18:00", "2026-03-14 20:00"}},
+		{"0 0 * */3 *", []string{"2026-01-14 00:00", "2026-04-14 00:00", "2026-10-14 00:00"}, []string{"2026-02-14 00:00", "2026-12-14 00:00"}},
+		{"*/90 * * * *", []string{"2026-03-14 10:00"}, []string{"2026-03-14 10:30"}},
+		// Lists
+		{"0,30 * * * *", []string{"2026-03-14 10:00", "2026-03-14 10:30"}, []string{"2026-03-14 10:15"}},
+		{"0 6,12-14,20 * * *", []string{"2026-03-14 06:00", "2026-03-14 13:00", "2026-03-14 20:00"}, []string{"2026-03-14 07:00", "2026-03-14 15:00"}},
+		// Names, in any case
+		{"0 0 * jan,JUL *", []string{"2026-01-05 00:00", "2026-07-05 00:00"}, []string{"2026-02-05 00:00"}},
+		{"* * * * mon-fri", []string{"2026-03-16 12:00", "2026-03-20 12:00"}, []string{"2026-03-14 12:00", "2026-03-15 12:00"}},
+		{"* * * * Sat,sun", []string{"2026-03-14 12:00", "2026-03-15 12:00"}, []string{"2026-03-16 12:00"}},
+		// 7 is Sunday as well as 0
+		{"* * * * 7", []string{"2026-03-15 12:00"}, []string{"2026-03-14 12:00"}},
+		{"* * * * 5-7", []string{"2026-03-13 12:00", "2026-03-14 12:00", "2026-03-15 12:00"}, []string{"2026-03-16 12:00"}},
+		// With both days restricted either may match, 13 March 2026 being a
+		// Friday
+		{"0 0 13 * fri", []string{"2026-03-13 00:00", "2026-02-13 00:00", "2026-03-20 00:00"}, []string{"2026-03-14 00:00"}},
+		{"0 0 1,15 * mon", []string{"2026-03-01 00:00", "2026-03-15 00:00", "2026-03-16 00:00"}, []string{"2026-03-17 00:00"}},
+		// Otherwise both must, including a day field that starts with *
+		{"0 0 13 * *", []string{"2026-03-13 00:00"}, []string{"2026-03-20 00:00"}},
+		{"0 0 * * fri", []string{"2026-03-20 00:00"}, []string{"2026-03-14 00:00"}},
+		{"0 0 */2 * fri", []string{"2026-03-13 00:00"}, []string{"2026-03-20 00:00", "2026-03-14 00:00"}},
+		{"0 0 13 * */5", []string{"2026-03-13 00:00"}, []string{"2026-03-20 00:00", "2026-03-15 00:00"}},
+		// Extra whitespace between fields
+		{" 0   0 * *  * ", []string{"2026-03-14 00:00"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			for _, text := range tt.match {
+				if !s.Matches(utc(text)) {
+					t.Errorf("does not match %s", text)
+				}
+				// Any second of a matching minute matches
+				if !s.Matches(utc(text).Add(59 * time.Second)) {
+					t.Errorf("does not match the end of %s", text)
+				}
+			}
+			for _, text := range tt.miss {
+				if s.Matches(utc(text)) {
+					t.Errorf("matches %s", text)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchesInTimezone(t *testing.T) {
+	// Times are matched by their wall clock in their location
+	s, err := Parse("0-59 22-23 * * fri")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	friday := time.Date(2026, 3, 13, 22, 30, 0, 0, tokyo)
+	if !s.Matches(friday) {
+		t.Errorf("does not match %s", friday)
+	}
+	if s.Matches(friday.UTC()) {
+		t.Errorf("matches %s, which is 22:30 on Friday only in Tokyo", friday.UTC())
+	}
+}
+
+func TestMatchesAcrossDaylightSaving(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	minutes := func(expr string, from time.Time, hours int) []string {
+		t.Helper()
+		s, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var matched []string
+		for m := from; m.Before(from.Add(time.Duration(hours) * time.Hour)); m = m.Add(time.Minute) {
+			if s.Matches(m) {
+				matched = append(matched, m.Format("15:04 MST"))
+			}
+		}
+		return matched
+	}
+
+	// Clocks went from 2:00 to 3:00 on 8 March 2026, so no minute of the
+	// skipped hour matches
+	springStart := time.Date(2026, 3, 8, 0, 0, 0, 0, newYork)
+	if got := minutes("30 2 * * *", springStart, 6); len(got) != 0 {
+		t.Errorf("matched %v in the skipped hour", got)
+	}
+	if got, want := minutes("30 1,3 * * *", springStart, 6), "01:30 EST,03:30 EDT"; strings.Join(got, ",") != want {
+		t.Errorf("matched %v, want %s", got, want)
+	}
+
+	// Clocks went from 2:00 back to 1:00 on 1 November 2026, so the wall
+	// clock repeats an hour and its minutes match in both
+	fallStart := time.Date(2026, 11, 1, 0, 0, 0, 0, newYork)
+	if got, want := minutes("30 1 * * *", fallStart, 6), "01:30 EDT,01:30 EST"; strings.Join(got, ",") != want {
+		t.Errorf("matched %v, want %s", got, want)
+	}
+	if got, want := minutes("0 0-3 * * *", fallStart, 6), "00:00 EDT,01:00 EDT,01:00 EST,02:00 EST,03:00 EST"; strings.Join(got, ",") != want {
+		t.Errorf("matched %v, want %s", got, want)
+	}
+}
+
+// runs returns the next n times of expr from from
+func runs(t *testing.T, expr string, from time.Time, n int) string {
+	t.Helper()
+	s, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var times []string
+	for next := from; len(times) < n; {
+		if next = s.Next(next); next.IsZero() {
+			times = append(times, "never")
+			break
+		}
+		times = append(times, next.Format("Mon 2006-01-02 15:04 MST"))
+	}
+	return strings.Join(times, ", ")
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 3, 14, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * * *", "Sat 2026-03-14 10:08 UTC, Sat 2026-03-14 10:09 UTC"},
+		{"*/15 * * * *", "Sat 2026-03-14 10:15 UTC, Sat 2026-03-14 10:30 UTC, Sat 2026-03-14 10:45 UTC"},
+		{"7 10 * * *", "Sun 2026-03-15 10:07 UTC, Mon 2026-03-16 10:07 UTC"},
+		{"0 3 * * mon-fri", "Mon 2026-03-16 03:00 UTC, Tue 2026-03-17 03:00 UTC"},
+		{"0 0 1 * *", "Wed 2026-04-01 00:00 UTC, Fri 2026-05-01 00:00 UTC"},
+		{"0 12 31 * *", "Tue 2026-03-31 12:00 UTC, Sun 2026-05-31 12:00 UTC"},
+		{"0 0 13 * fri", "Fri 2026-03-20 00:00 UTC, Fri 2026-03-27 00:00 UTC, Fri 2026-04-03 00:00 UTC, Fri 2026-04-10 00:00 UTC, Mon 2026-04-13 00:00 UTC"},
+		{"0 0 29 feb *", "Tue 2028-02-29 00:00 UTC, Sun 2032-02-29 00:00 UTC"},
+		{"30 23 31 dec *", "Thu 2026-12-31 23:30 UTC, Fri 2027-12-31 23:30 UTC"},
+		{"0 0 30 2 *", "never"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if got := runs(t, tt.expr, from, strings.Count(tt.want, ",")+1); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAcrossDaylightSaving(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	// Clocks went from 2:00 to 3:00 on 8 March 2026 and from 2:00 back to
+	// 1:00 on 1 November 2026
+	spring := time.Date(2026, 3, 7, 23, 0, 0, 0, newYork)
+	fall := time.Date(2026, 10, 31, 23, 0, 0, 0, newYork)
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want string
+	}{
+		{"skipped time runs when clocks go forward", "30 2 * * *", spring,
+			"Sun 2026-03-08 03:00 EDT, Mon 2026-03-09 02:30 EDT"},
+		{"skipped range runs once", "*/20 2 * * *", spring,
+			"Sun 2026-03-08 03:00 EDT, Mon 2026-03-09 02:00 EDT"},
+		{"times around the gap", "15 1-3 * * *", spring,
+			"Sun 2026-03-08 01:15 EST, Sun 2026-03-08 03:00 EDT, Sun 2026-03-08 03:15 EDT, Mon 2026-03-09 01:15 EDT"},
+		{"hourly skips the missing hour", "30 * * * *", spring,
+			"Sat 2026-03-07 23:30 EST, Sun 2026-03-08 00:30 EST, Sun 2026-03-08 01:30 EST, Sun 2026-03-08 03:30 EDT"},
+		{"repeated time runs once", "30 1 * * *", fall,
+			"Sun 2026-11-01 01:30 EDT, Mon 2026-11-02 01:30 EST"},
+		{"repeated range runs once", "0,30 1 * * *", fall,
+			"Sun 2026-11-01 01:00 EDT, Sun 2026-11-01 01:30 EDT, Mon 2026-11-02 01:00 EST"},
+		{"times around the repeat", "0 0-3 * * *", fall,
+			"Sun 2026-11-01 00:00 EDT, Sun 2026-11-01 01:00 EDT, Sun 2026-11-01 02:00 EST, Sun 2026-11-01 03:00 EST"},
+		{"hourly runs in both passes", "30 * * * *", fall,
+			"Sat 2026-10-31 23:30 EDT, Sun 2026-11-01 00:30 EDT, Sun 2026-11-01 01:30 EDT, Sun 2026-11-01 01:30 EST, Sun 2026-11-01 02:30 EST"},
+		{"every minute of an hour runs in both passes", "* 1 * * *", fall.Add(2*time.Hour + 58*time.Minute),
+			"Sun 2026-11-01 01:59 EDT, Sun 2026-11-01 01:00 EST, Sun 2026-11-01 01:01 EST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runs(t, tt.expr, tt.from, strings.Count(tt.want, ",")+1); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "must have 5 fields, got 0"},
+		{"* * * *", "must have 5 fields, got 4"},
+		{"* * * * * *", "must have 5 fields, got 6"},
+		{"60 * * * *", `invalid minute "60": must be 0-59`},
+		{"* 24 * * *", `invalid hour "24": must be 0-23`},
+		{"* * 0 * *", `invalid day of month "0": must be 1-31`},
+		{"* * 32 * *", `invalid day of month "32": must be 1-31`},
+		{"* * * 13 *", `invalid month "13": must be 1-12`},
+		{"* * * 0 *", `invalid month "0": must be 1-12`},
+		{"* * * * 8", `invalid day of week "8": must be 0-7`},
+		{"* * * * -1", `invalid day of week "": must be 0-7`},
+		{"* * * * sunday", `invalid day of week "sunday": must be 0-7`},
+		{"* * * foo *", `invalid month "foo": must be 1-12`},
+		{"mon * * * *", `invalid minute "mon": must be 0-59`},
+		{"5-1 * * * *", `invalid minute range "5-1"`},
+		{"* * * * fri-mon", `invalid day of week range "fri-mon"`},
+		{"1- * * * *", `invalid minute "": must be 0-59`},
+		{"*/0 * * * *", `invalid minute step "0"`},
+		{"*/-5 * * * *", `invalid minute step "-5"`},
+		{"*/x * * * *", `invalid minute step "x"`},
+		{"1,,2 * * * *", `invalid minute "": must be 0-59`},
+		{"* * * * 1,", `invalid day of week "": must be 0-7`},
+		{"1.5 * * * *", `invalid minute "1.5": must be 0-59`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if err == nil {
+				t.Fatal("Parse succeeded, want an error")
+			}
+			if !strings.HasSuffix(err.Error(), tt.err) {
+				t.Errorf("got error %q, want it to end in %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	"github.com/nickheyer/jacuzzi/pkg/server/statsview"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -19,17 +24,47 @@ type Config struct {
 	Password string
 	DBName   string
 	SSLMode  string
+
+	StatementTimeout time.Duration // Postgres only; 0 keeps the server default
 }
 
 func NewDatabase(cfg Config) (*gorm.DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run migrations
+	if err := RunMigrations(db); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return db, nil
+}
+
+// Open connects to the database without running migrations
+func Open(cfg Config) (*gorm.DB, error) {
 	var dialector gorm.Dialector
 
 	switch cfg.Type {
 	case "sqlite":
-		dialector = sqlite.Open(cfg.DBName)
+		// Incremental auto_vacuum lets the vacuum job return pages freed by
+		// retention to the filesystem without rewriting the file. It applies
+		// to new databases at once and to existing ones after their next
+		// VACUUM. SQLite only enforces foreign keys when asked to, on every
+		// connection.
+		sep := "?"
+		if strings.Contains(cfg.DBName, "?") {
+			sep = "&"
+		}
+		dialector = sqlite.Open(cfg.DBName + sep + "_auto_vacuum=incremental&_foreign_keys=1")
 	case "postgres":
 		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
 			cfg.Host, cfg.User, cfg.Password, cfg.DBName, cfg.Port, cfg.SSLMode)
+		if cfg.StatementTimeout > 0 {
+			// Unrecognized DSN keys are sent as session parameters
+			dsn += fmt.Sprintf(" statement_timeout=%d", cfg.StatementTimeout.Milliseconds())
+		}
 		dialector = postgres.Open(dsn)
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
@@ -37,37 +72,318 @@ func NewDatabase(cfg Config) (*gorm.DB, error) {
 
 	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
+		// Report unique and foreign key violations as gorm.ErrDuplicatedKey
+		// and gorm.ErrForeignKeyViolated, whatever the backend
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Run migrations
-	if err := RunMigrations(db); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
-	}
-
 	return db, nil
 }
 
 func RunMigrations(db *gorm.DB) error {
 	log.Println("Running database migrations...")
 
+	// SQLite adds a foreign key by rebuilding the table, and dropping a
+	// rebuilt parent table with foreign keys enforced would delete the rows
+	// referring to it. Migrate on one connection with enforcement off.
+	if db.Dialector.Name() == "sqlite" {
+		return db.Connection(func(conn *gorm.DB) error {
+			if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+				return fmt.Errorf("failed to disable foreign keys: %w", err)
+			}
+			defer conn.Exec("PRAGMA foreign_keys = ON")
+			return migrate(conn.Session(&gorm.Session{}))
+		})
+	}
+	return migrate(db)
+}
+
+func migrate(db *gorm.DB) error {
+	if err := dropLegacyIndexes(db); err != nil {
+		return err
+	}
+
+	if err := dropLegacyConstraints(db); err != nil {
+		return err
+	}
+
+	if err := dedupeReadings(db); err != nil {
+		return fmt.Errorf("failed to deduplicate readings: %w", err)
+	}
+
+	if err := repairOrphans(db); err != nil {
+		return fmt.Errorf("failed to repair orphaned rows: %w", err)
+	}
+
+	if err := statsview.DropViews(db); err != nil {
+		return err
+	}
+
 	// AutoMigrate creates tables, missing columns, and missing indexes
 	// It will not delete unused columns to protect data
 	err := db.AutoMigrate(
 		&models.Client{},
+		&models.ClientAddress{},
 		&models.Sensor{},
 		&models.TemperatureReading{},
+		&models.ReadingChunk{},
 		&models.AlertRule{},
 		&models.AlertAction{},
 		&models.Alert{},
+		&models.AlertContextReading{},
+		&models.PendingAlert{},
 		&models.Setting{},
+		&models.TemperatureRollup{},
+		&models.RollupWatermark{},
+		&models.EnrollmentToken{},
+		&models.Lease{},
+		&models.Report{},
+		&models.Dashboard{},
+		&models.UserPreferences{},
+		&models.Annotation{},
+		&models.Webhook{},
+		&models.Script{},
+		&models.Command{},
+		&models.ClientPower{},
+		&models.Event{},
+		&models.Job{},
+		&models.RequestUsage{},
+		&models.QuarantinedReading{},
+		&models.ConfigProfile{},
+		&models.NotificationChannel{},
+		&models.NotificationRoute{},
+		&models.HeldNotification{},
+		&models.DutyCycle{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to auto migrate: %w", err)
 	}
 
+	if err := backfillSensorLastReading(db); err != nil {
+		return fmt.Errorf("failed to backfill sensor last readings: %w", err)
+	}
+
+	if err := normalizeSensorTypes(db); err != nil {
+		return fmt.Errorf("failed to normalize sensor types: %w", err)
+	}
+
 	log.Println("Database migrations completed successfully")
 	return nil
 }
+
+// dropLegacyIndexes removes unique indexes that did not include client_id,
+// which merged sensors with the same ID across different clients
+func dropLegacyIndexes(db *gorm.DB) error {
+	migrator := db.Migrator()
+	legacy := []struct {
+		model interface{}
+		index string
+	}{
+		{&models.Sensor{}, "idx_sensors_sensor_id"},
+		{&models.TemperatureReading{}, "idx_readings_sensor_time"},
+		{&models.TemperatureRollup{}, "idx_rollups_sensor_bucket"},
+	}
+	for _, l := range legacy {
+		if !migrator.HasTable(l.model) || !migrator.HasIndex(l.model, l.index) {
+			continue
+		}
+		if err := migrator.DropIndex(l.model, l.index); err != nil {
+			return fmt.Errorf("failed to drop index %s: %w", l.index, err)
+		}
+		log.Printf("Dropped legacy index %s", l.index)
+	}
+	return nil
+}
+
+// dropLegacyConstraints removes the foreign keys of alert rules created
+// without cascading, which are replaced by ones that do
+func dropLegacyConstraints(db *gorm.DB) error {
+	migrator := db.Migrator()
+	legacy := []struct {
+		model      interface{}
+		constraint string
+	}{
+		{&models.AlertAction{}, "fk_alert_rules_actions"},
+		{&models.Alert{}, "fk_alert_rules_alerts"},
+	}
+	for _, l := range legacy {
+		if !migrator.HasTable(l.model) || !migrator.HasConstraint(l.model, l.constraint) {
+			continue
+		}
+		if err := migrator.DropConstraint(l.model, l.constraint); err != nil {
+			return fmt.Errorf("failed to drop constraint %s: %w", l.constraint, err)
+		}
+		log.Printf("Dropped legacy constraint %s", l.constraint)
+	}
+	return nil
+}
+
+// dedupeReadings removes duplicate (client_id, sensor_id, created_at) readings
+// so the unique index on temperature_readings can be created on existing databases
+func dedupeReadings(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.TemperatureReading{}) || migrator.HasIndex(&models.TemperatureReading{}, "idx_readings_client_sensor_time") {
+		return nil
+	}
+
+	result := db.Exec(`DELETE FROM temperature_readings WHERE id NOT IN (
+		SELECT MIN(id) FROM temperature_readings GROUP BY client_id, sensor_id, created_at
+	)`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Removed %d duplicate temperature readings", result.RowsAffected)
+	}
+	return nil
+}
+
+// backfillSensorLastReading sets last_reading_at on sensors created before it
+// was tracked. Sensors without readings are left unset.
+func backfillSensorLastReading(db *gorm.DB) error {
+	result := db.Exec(`UPDATE sensors SET last_reading_at = (
+		SELECT MAX(created_at) FROM temperature_readings
+		WHERE temperature_readings.client_id = sensors.client_id AND temperature_readings.sensor_id = sensors.sensor_id
+	) WHERE last_reading_at IS NULL AND EXISTS (
+		SELECT 1 FROM temperature_readings
+		WHERE temperature_readings.client_id = sensors.client_id AND temperature_readings.sensor_id = sensors.sensor_id
+	)`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Recorded the last reading time of %d sensors", result.RowsAffected)
+	}
+	return nil
+}
+
+// repairOrphans fixes rows that refer to missing parents so their foreign
+// keys can be added to existing databases: actions of deleted rules are
+// deleted, deleted rules with alerts are restored as deleted rules named
+// after their ID, and clients with sensors, readings or addresses but no row
+// are restored as pending clients. Each step runs until its foreign key
+// exists.
+func repairOrphans(db *gorm.DB) error {
+	migrator := db.Migrator()
+
+	if migrator.HasTable(&models.AlertRule{}) && migrator.HasTable(&models.AlertAction{}) && !migrator.HasConstraint(&models.AlertRule{}, "Actions") {
+		result := db.Exec("DELETE FROM alert_actions WHERE rule_id NOT IN (SELECT rule_id FROM alert_rules)")
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Removed %d actions of deleted alert rules", result.RowsAffected)
+		}
+	}
+
+	if migrator.HasTable(&models.AlertRule{}) && migrator.HasTable(&models.Alert{}) && !migrator.HasConstraint(&models.AlertRule{}, "Alerts") {
+		if !migrator.HasColumn(&models.AlertRule{}, "DeletedAt") {
+			if err := migrator.AddColumn(&models.AlertRule{}, "DeletedAt"); err != nil {
+				return err
+			}
+		}
+		var ruleIDs []string
+		if err := db.Model(&models.Alert{}).Distinct("rule_id").Where("rule_id NOT IN (SELECT rule_id FROM alert_rules)").Pluck("rule_id", &ruleIDs).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, ruleID := range ruleIDs {
+			rule := models.AlertRule{
+				RuleID:        ruleID,
+				Name:          "Deleted rule " + ruleID,
+				ConditionType: models.ConditionTypeThreshold,
+				Operator:      "OPERATOR_UNSPECIFIED",
+				Severity:      "SEVERITY_WARNING",
+				DeletedAt:     gorm.DeletedAt{Time: now, Valid: true},
+			}
+			if err := db.Create(&rule).Error; err != nil {
+				return err
+			}
+			// Enabled has a default, so it is only false when set explicitly
+			if err := db.Unscoped().Model(&rule).Update("enabled", false).Error; err != nil {
+				return err
+			}
+		}
+		if len(ruleIDs) > 0 {
+			log.Printf("Restored %d deleted alert rules that alerts refer to", len(ruleIDs))
+		}
+	}
+
+	if !migrator.HasTable(&models.Client{}) {
+		return nil
+	}
+	restored := 0
+	for _, child := range []struct {
+		model    interface{}
+		relation string
+	}{
+		{&models.ClientAddress{}, "Addresses"},
+		{&models.Sensor{}, "Sensors"},
+		{&models.TemperatureReading{}, "Readings"},
+	} {
+		if !migrator.HasTable(child.model) || migrator.HasConstraint(&models.Client{}, child.relation) {
+			continue
+		}
+		var clientIDs []string
+		if err := db.Model(child.model).Distinct("client_id").Where("client_id NOT IN (SELECT client_id FROM clients)").Pluck("client_id", &clientIDs).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, clientID := range clientIDs {
+			client := models.Client{
+				ClientID:  clientID,
+				Hostname:  clientID,
+				FirstSeen: now,
+				LastSeen:  now,
+				Status:    models.ClientStatusPending,
+			}
+			if err := db.Create(&client).Error; err != nil {
+				return err
+			}
+		}
+		restored += len(clientIDs)
+	}
+	if restored > 0 {
+		log.Printf("Restored %d clients that sensors, readings or addresses refer to; they are pending approval", restored)
+	}
+	return nil
+}
+
+// normalizeSensorTypes rewrites sensor types stored before they were
+// normalized at ingest to their canonical names. Rules keep an empty type,
+// which matches every sensor.
+func normalizeSensorTypes(db *gorm.DB) error {
+	for _, model := range []interface{}{&models.TemperatureReading{}, &models.Sensor{}, &models.AlertRule{}} {
+		var types []sql.NullString
+		if err := db.Unscoped().Model(model).Distinct("sensor_type").Pluck("sensor_type", &types).Error; err != nil {
+			return err
+		}
+		_, isRule := model.(*models.AlertRule)
+		for _, sensorType := range types {
+			canonical := sensortype.Normalize(sensorType.String)
+			if sensorType.Valid && (canonical == sensorType.String || isRule && sensorType.String == "") {
+				continue
+			}
+			if isRule && sensorType.String == "" {
+				canonical = ""
+			}
+			// Unscoped so deleted rules are normalized too. UpdateColumn
+			// keeps updated_at, which on readings is their ingest time.
+			query := db.Unscoped().Model(model)
+			if sensorType.Valid {
+				query = query.Where("sensor_type = ?", sensorType.String)
+			} else {
+				query = query.Where("sensor_type IS NULL")
+			}
+			result := query.UpdateColumn("sensor_type", canonical)
+			if result.Error != nil {
+				return result.Error
+			}
+			log.Printf("Renamed sensor type %q to %q on %d rows", sensorType.String, canonical, result.RowsAffected)
+		}
+	}
+	return nil
+}
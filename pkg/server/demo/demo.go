@@ -0,0 +1,428 @@
+package demo
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Prefix marks demo clients and alert rules, so they can be found and
+// removed again
+const Prefix = "demo-"
+
+// insertBatchSize bounds the rows of one INSERT
+const insertBatchSize = 500
+
+// Options controls how much demo data is generated
+type Options struct {
+	Clients  int           // Number of demo clients
+	History  time.Duration // How far back readings go
+	Interval time.Duration // Time between readings of a sensor
+	Now      time.Time     // End of the history; zero means now
+	Seed     uint64        // Seeds the noise, so runs are repeatable
+}
+
+// Summary counts the generated rows
+type Summary struct {
+	Clients  int `json:"clients"`
+	Sensors  int `json:"sensors"`
+	Readings int `json:"readings"`
+	Rules    int `json:"rules"`
+	Alerts   int `json:"alerts"`
+}
+
+// sensorProfile describes how one sensor's temperature follows its
+// machine's load
+type sensorProfile struct {
+	id, sensorType, name string
+	idle                 float64 // Temperature at no load
+	load                 float64 // Rise at full load
+	noise                float64 // Standard deviation of the noise
+}
+
+// machineProfile is a kind of machine with a characteristic workload
+type machineProfile struct {
+	role     string
+	os, arch string
+	sensors  []sensorProfile
+	// load returns the machine's load in [0, 1] at t
+	load func(t time.Time, jobs []window) float64
+	// offline is how long before now the machine stopped reporting
+	offline time.Duration
+}
+
+// window is a span of time with extra load, such as a render job
+type window struct {
+	start, end time.Time
+}
+
+var profiles = []machineProfile{
+	{
+		role: "web", os: "linux", arch: "amd64",
+		sensors: []sensorProfile{
+			{"cpu-package", "CPU", "Package id 0", 41, 42, 1.2},
+			{"cpu-core-0", "CPU", "Core 0", 40, 40, 1.5},
+			{"nvme0", "DISK", "Samsung SSD 980", 34, 10, 0.5},
+		},
+		load: func(t time.Time, _ []window) float64 { return traffic(t) },
+	},
+	{
+		role: "db", os: "linux", arch: "amd64",
+		sensors: []sensorProfile{
+			{"cpu-package", "CPU", "Package id 0", 38, 30, 1},
+			{"sda", "DISK", "WDC WD40EFRX", 33, 9, 0.4},
+			{"sdb", "DISK", "WDC WD40EFRX", 34, 9, 0.4},
+		},
+		load: func(t time.Time, _ []window) float64 {
+			// Traffic by day, maintenance jobs at night
+			if h := t.Hour(); h >= 2 && h < 4 {
+				return 0.9
+			}
+			return 0.8 * traffic(t)
+		},
+	},
+	{
+		role: "gpu", os: "linux", arch: "amd64",
+		sensors: []sensorProfile{
+			{"cpu-package", "CPU", "Package id 0", 36, 30, 1},
+			{"gpu0", "GPU", "NVIDIA GeForce RTX 4090", 34, 55, 1.5},
+			{"gpu1", "GPU", "NVIDIA GeForce RTX 4090", 35, 57, 1.5},
+		},
+		load: func(t time.Time, jobs []window) float64 {
+			for _, job := range jobs {
+				if !t.Before(job.start) && t.Before(job.end) {
+					return 1
+				}
+			}
+			return 0.05
+		},
+	},
+	{
+		role: "nas", os: "linux", arch: "arm64",
+		sensors: []sensorProfile{
+			{"cpu-thermal", "CPU", "cpu_thermal", 45, 15, 0.8},
+			{"sda", "DISK", "Seagate IronWolf", 36, 12, 0.3},
+			{"sdb", "DISK", "Seagate IronWolf", 37, 12, 0.3},
+			{"sdc", "DISK", "Seagate IronWolf", 36, 12, 0.3},
+		},
+		load: func(t time.Time, _ []window) float64 {
+			// Nightly backups
+			if h := t.Hour(); h >= 1 && h < 3 {
+				return 1
+			}
+			return 0.1
+		},
+	},
+	{
+		role: "workstation", os: "windows", arch: "amd64",
+		sensors: []sensorProfile{
+			{"cpu-package", "CPU", "CPU Package", 38, 35, 2},
+			{"gpu0", "GPU", "AMD Radeon RX 7800 XT", 36, 25, 1.5},
+		},
+		load: func(t time.Time, _ []window) float64 { return officeHours(t) },
+	},
+	{
+		role: "edge", os: "linux", arch: "arm64",
+		sensors: []sensorProfile{
+			{"cpu-thermal", "CPU", "cpu_thermal", 50, 12, 1},
+		},
+		load:    func(t time.Time, _ []window) float64 { return 0.3 + 0.2*traffic(t) },
+		offline: 3 * time.Hour,
+	},
+}
+
+// ruleSpec is a demo alert rule; threshold rules also drive the alert
+// history generated with the readings
+type ruleSpec struct {
+	id, name, description string
+	conditionType         string
+	sensorType            string
+	threshold             float64
+	durationSeconds       int32
+	severity              string
+}
+
+var rules = []ruleSpec{
+	{"cpu-hot", "CPU running hot", "A CPU above 80 °C", models.ConditionTypeThreshold, "CPU", 80, 120, "SEVERITY_WARNING"},
+	{"gpu-critical", "GPU near throttling", "A GPU above 88 °C", models.ConditionTypeThreshold, "GPU", 88, 60, "SEVERITY_CRITICAL"},
+	{"disk-warm", "Disk too warm", "A disk above 45 °C shortens its life", models.ConditionTypeThreshold, "DISK", 45, 300, "SEVERITY_WARNING"},
+	{"sensor-stale", "Sensor stopped reporting", "A sensor silent for 10 minutes", models.ConditionTypeStaleSensor, "", 0, 600, "SEVERITY_WARNING"},
+	{"client-offline", "Machine offline", "A machine silent for 15 minutes", models.ConditionTypeClientOffline, "", 0, 900, "SEVERITY_CRITICAL"},
+}
+
+// resolveMargin is how far below its threshold a reading must fall to
+// resolve a generated alert, so noise does not open and close it repeatedly
+const resolveMargin = 3
+
+// Exists reports whether demo data has been seeded
+func Exists(ctx context.Context, db *gorm.DB) (bool, error) {
+	var count int64
+	if err := db.WithContext(ctx).Model(&models.Client{}).Where("client_id LIKE ?", Prefix+"%").Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to count demo clients: %w", err)
+	}
+	return count > 0, nil
+}
+
+// Remove deletes every demo client with its sensors, readings, rollups and
+// alerts, and the demo alert rules
+func Remove(ctx context.Context, db *gorm.DB) error {
+	pattern := Prefix + "%"
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.TemperatureReading{},
+			&models.ReadingChunk{},
+			&models.TemperatureRollup{},
+			&models.QuarantinedReading{},
+			&models.DutyCycle{},
+			&models.Sensor{},
+			&models.Alert{},
+			&models.Client{},
+		} {
+			if err := tx.Where("client_id LIKE ?", pattern).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to delete demo data: %w", err)
+			}
+		}
+		if err := tx.Where("rule_id LIKE ?", pattern).Delete(&models.Alert{}).Error; err != nil {
+			return fmt.Errorf("failed to delete demo alerts: %w", err)
+		}
+		if err := tx.Where("rule_id LIKE ?", pattern).Delete(&models.AlertAction{}).Error; err != nil {
+			return fmt.Errorf("failed to delete demo alert actions: %w", err)
+		}
+		if err := tx.Unscoped().Where("rule_id LIKE ?", pattern).Delete(&models.AlertRule{}).Error; err != nil {
+			return fmt.Errorf("failed to delete demo alert rules: %w", err)
+		}
+		return nil
+	})
+}
+
+// Seed generates demo clients, sensors, readings following daily and weekly
+// load patterns, alert rules, and the alerts those readings would have raised
+func Seed(ctx context.Context, db *gorm.DB, opts Options) (*Summary, error) {
+	if opts.Clients < 1 || opts.History <= 0 || opts.Interval <= 0 {
+		return nil, fmt.Errorf("clients, history and interval must be positive")
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.Truncate(opts.Interval)
+	start := now.Add(-opts.History)
+	rng := rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x9e3779b97f4a7c15))
+
+	summary := &Summary{}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		ruleModels, err := createRules(tx)
+		if err != nil {
+			return err
+		}
+		summary.Rules = len(ruleModels)
+
+		for i := 0; i < opts.Clients; i++ {
+			profile := profiles[i%len(profiles)]
+			hostname := fmt.Sprintf("%s-%02d", profile.role, i/len(profiles)+1)
+			counts, err := seedClient(tx, rng, profile, hostname, ruleModels, start, now, opts.Interval)
+			if err != nil {
+				return err
+			}
+			summary.Clients++
+			summary.Sensors += counts.Sensors
+			summary.Readings += counts.Readings
+			summary.Alerts += counts.Alerts
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func createRules(tx *gorm.DB) ([]models.AlertRule, error) {
+	var created []models.AlertRule
+	for _, spec := range rules {
+		rule := models.AlertRule{
+			RuleID:          Prefix + spec.id,
+			Name:            spec.name,
+			Description:     spec.description,
+			SensorType:      spec.sensorType,
+			ConditionType:   spec.conditionType,
+			Operator:        "OPERATOR_GREATER_THAN",
+			Threshold:       spec.threshold,
+			DurationSeconds: spec.durationSeconds,
+			Severity:        spec.severity,
+			Enabled:         true,
+		}
+		if err := tx.Create(&rule).Error; err != nil {
+			return nil, fmt.Errorf("failed to create demo rule %s: %w", rule.RuleID, err)
+		}
+		created = append(created, rule)
+	}
+	return created, nil
+}
+
+// seedClient generates one client's sensors, readings and alerts
+func seedClient(tx *gorm.DB, rng *rand.Rand, profile machineProfile, hostname string, rules []models.AlertRule, start, now time.Time, interval time.Duration) (*Summary, error) {
+	clientID := Prefix + hostname
+	lastSeen := now.Add(-profile.offline)
+	client := models.Client{
+		ClientID:  clientID,
+		Hostname:  hostname,
+		IPAddress: fmt.Sprintf("10.0.%d.%d", 10+rng.IntN(10), 10+rng.IntN(200)),
+		OS:        profile.os,
+		Arch:      profile.arch,
+		FirstSeen: start,
+		LastSeen:  lastSeen,
+		IsOnline:  profile.offline == 0,
+		MachineID: uuid.New().String(),
+		Status:    models.ClientStatusApproved,
+	}
+	if err := tx.Create(&client).Error; err != nil {
+		return nil, fmt.Errorf("failed to create demo client %s: %w", clientID, err)
+	}
+
+	jobs := renderJobs(rng, start, now)
+	counts := &Summary{Sensors: len(profile.sensors)}
+	for _, sp := range profile.sensors {
+		var readings []models.TemperatureReading
+		var open *models.Alert
+		var alerts []*models.Alert
+		rule := matchingRule(rules, sp.sensorType)
+
+		for t := start; !t.After(lastSeen); t = t.Add(interval) {
+			temp := sp.idle + sp.load*profile.load(t, jobs) + ambient(t) + rng.NormFloat64()*sp.noise
+			temp = math.Round(temp*10) / 10
+			readings = append(readings, models.TemperatureReading{
+				SensorID:           sp.id,
+				ClientID:           clientID,
+				TemperatureCelsius: temp,
+				SensorType:         sp.sensorType,
+				SensorName:         sp.name,
+				CreatedAt:          t,
+			})
+
+			if rule == nil {
+				continue
+			}
+			switch {
+			case open == nil && temp > rule.Threshold:
+				open = &models.Alert{
+					AlertID:     uuid.New().String(),
+					RuleID:      rule.RuleID,
+					ClientID:    clientID,
+					SensorID:    sp.id,
+					Value:       temp,
+					TriggeredAt: t,
+					IsActive:    true,
+					Severity:    rule.Severity,
+					Message:     fmt.Sprintf("%s: %s on %s is %.1f°C, above %.1f°C", rule.Name, sp.name, hostname, temp, rule.Threshold),
+				}
+				alerts = append(alerts, open)
+			case open != nil && temp < rule.Threshold-resolveMargin:
+				resolved := t
+				open.ResolvedAt = &resolved
+				open.IsActive = false
+				// Older alerts have been looked at
+				if now.Sub(t) > 24*time.Hour {
+					open.AcknowledgedAt = &resolved
+					open.AcknowledgedBy = "demo"
+				}
+				open = nil
+			}
+		}
+
+		if err := tx.CreateInBatches(readings, insertBatchSize).Error; err != nil {
+			return nil, fmt.Errorf("failed to create demo readings for %s/%s: %w", clientID, sp.id, err)
+		}
+		if len(alerts) > 0 {
+			// is_active defaults to true, so the zero value is not inserted and
+			// the default is read back into the alerts
+			var resolved []string
+			for _, alert := range alerts {
+				if !alert.IsActive {
+					resolved = append(resolved, alert.AlertID)
+				}
+			}
+			if err := tx.CreateInBatches(alerts, insertBatchSize).Error; err != nil {
+				return nil, fmt.Errorf("failed to create demo alerts for %s/%s: %w", clientID, sp.id, err)
+			}
+			if len(resolved) > 0 {
+				err := tx.Model(&models.Alert{}).Where("alert_id IN ?", resolved).UpdateColumn("is_active", false).Error
+				if err != nil {
+					return nil, fmt.Errorf("failed to resolve demo alerts for %s/%s: %w", clientID, sp.id, err)
+				}
+			}
+		}
+
+		last := readings[len(readings)-1].CreatedAt
+		sensor := models.Sensor{
+			SensorID:      sp.id,
+			ClientID:      clientID,
+			SensorType:    sp.sensorType,
+			SensorName:    sp.name,
+			LastReadingAt: &last,
+		}
+		if err := tx.Create(&sensor).Error; err != nil {
+			return nil, fmt.Errorf("failed to create demo sensor %s/%s: %w", clientID, sp.id, err)
+		}
+		counts.Readings += len(readings)
+		counts.Alerts += len(alerts)
+	}
+	return counts, nil
+}
+
+// matchingRule returns the threshold rule for a sensor type, if any
+func matchingRule(rules []models.AlertRule, sensorType string) *models.AlertRule {
+	for i := range rules {
+		if rules[i].ConditionType == models.ConditionTypeThreshold && rules[i].SensorType == sensorType {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// renderJobs schedules a few hours-long jobs per day, some overnight
+func renderJobs(rng *rand.Rand, start, end time.Time) []window {
+	var jobs []window
+	for t := start; t.Before(end); {
+		t = t.Add(time.Duration(2+rng.IntN(10)) * time.Hour)
+		length := time.Duration(30+rng.IntN(180)) * time.Minute
+		jobs = append(jobs, window{start: t, end: t.Add(length)})
+		t = t.Add(length)
+	}
+	return jobs
+}
+
+// traffic is a diurnal load curve peaking mid-afternoon, lighter at weekends
+func traffic(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	load := 0.45 - 0.4*math.Cos(2*math.Pi*(hour-3)/24)
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		load *= 0.6
+	}
+	return load
+}
+
+// officeHours is the load of a machine used on weekdays during work hours
+func officeHours(t time.Time) float64 {
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return 0.02
+	}
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	if hour < 8.5 || hour > 18 {
+		return 0.02
+	}
+	// Busier before lunch and mid-afternoon
+	return 0.5 + 0.3*math.Sin(2*math.Pi*(hour-8.5)/4.75)
+}
+
+// ambient is the room temperature's daily swing around its mean
+func ambient(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	return 1.5 * math.Sin(2*math.Pi*(hour-9)/24)
+}
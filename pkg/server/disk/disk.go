@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+// Package disk reports the space on the filesystems holding server data
+package disk
+
+import "syscall"
+
+// Space returns the bytes available to unprivileged users and the total size
+// of the filesystem holding path
+func Space(path string) (free, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+// Package disk reports the space on the filesystems holding server data
+package disk
+
+import "errors"
+
+// Space is not supported on this platform
+func Space(path string) (free, total int64, err error) {
+	return 0, 0, errors.New("disk space is not supported on this platform")
+}
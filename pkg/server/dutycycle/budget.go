@@ -0,0 +1,155 @@
+package dutycycle
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertevents"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertlog"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/mute"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"gorm.io/gorm"
+)
+
+// periodStart returns the start of the day or week of a thermal budget
+// rule's period containing t
+func periodStart(period string, t time.Time, loc *time.Location) time.Time {
+	if period == models.DutyCyclePeriodWeek {
+		return timezone.Floor(t, timezone.Week, loc)
+	}
+	return timezone.Floor(t, timezone.Day, loc)
+}
+
+// evaluate raises an alert for every sensor matched by an enabled thermal
+// budget rule that spent more than the rule's duration above its threshold
+// in the current period, and resolves alerts whose period ended or whose
+// sensor was retired
+func (t *Tracker) evaluate(db *gorm.DB, rules []models.AlertRule, now time.Time, loc *time.Location) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	// Weeks start no later than days, so the duty cycles of this week cover
+	// every rule
+	since := periodStart(models.DutyCyclePeriodWeek, now, loc)
+	var cycles []models.DutyCycle
+	if err := db.Where("day >= ?", since.UTC()).Find(&cycles).Error; err != nil {
+		return fmt.Errorf("failed to load duty cycles: %w", err)
+	}
+	var sensors []models.Sensor
+	if err := db.Where("retired_at IS NULL").Find(&sensors).Error; err != nil {
+		return fmt.Errorf("failed to load sensors: %w", err)
+	}
+	type sensorKey struct{ client, sensor string }
+	bySensor := make(map[sensorKey]*models.Sensor, len(sensors))
+	for i := range sensors {
+		bySensor[sensorKey{sensors[i].ClientID, sensors[i].SensorID}] = &sensors[i]
+	}
+
+	ruleIDs := make([]string, len(rules))
+	for i, rule := range rules {
+		ruleIDs[i] = rule.RuleID
+	}
+	var active []models.Alert
+	if err := db.Where("is_active = ? AND rule_id IN ?", true, ruleIDs).Find(&active).Error; err != nil {
+		return fmt.Errorf("failed to load active alerts: %w", err)
+	}
+	type alertKey struct{ rule, client, sensor string }
+	open := make(map[alertKey]models.Alert, len(active))
+	for _, alert := range active {
+		open[alertKey{alert.RuleID, alert.ClientID, alert.SensorID}] = alert
+	}
+
+	for _, rule := range rules {
+		start := periodStart(rule.Period, now, loc)
+		above := make(map[sensorKey]float64)
+		for _, cycle := range cycles {
+			if cycle.Threshold == rule.Threshold && !cycle.Day.Before(start) {
+				above[sensorKey{cycle.ClientID, cycle.SensorID}] += cycle.Above
+			}
+		}
+		for key, seconds := range above {
+			sensor, ok := bySensor[key]
+			if !ok || !matches(rule, sensor) || seconds <= float64(rule.DurationSeconds) {
+				continue
+			}
+			target := alertKey{rule.RuleID, sensor.ClientID, sensor.SensorID}
+			if _, ok := open[target]; ok {
+				// Still over budget; keep the alert open
+				delete(open, target)
+				continue
+			}
+			if err := t.trigger(db, rule, sensor, seconds, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Alerts left over belong to periods that ended, or to sensors that were
+	// retired or no longer match their rule
+	for _, alert := range open {
+		resolvedAt := now
+		err := db.Model(&models.Alert{}).
+			Where("id = ?", alert.ID).
+			Updates(map[string]interface{}{"is_active": false, "resolved_at": &resolvedAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to resolve alert %s: %w", alert.AlertID, err)
+		}
+		log.Printf("Resolved thermal budget alert %s for sensor %s on client %s", alert.AlertID, alert.SensorID, alert.ClientID)
+		alert.IsActive = false
+		alert.ResolvedAt = &resolvedAt
+		alertevents.Publish(db, t.cfg.Events, activity.AlertResolved, &alert, nil)
+		t.cfg.Notify.Resolved(&alert)
+	}
+	return nil
+}
+
+func (t *Tracker) trigger(db *gorm.DB, rule models.AlertRule, sensor *models.Sensor, seconds float64, now time.Time) error {
+	name := sensor.SensorID
+	if sensor.SensorName != "" {
+		name = sensor.SensorName
+	}
+	l, err := i18n.Load(db)
+	if err != nil {
+		log.Printf("Writing alert messages in the default locale: %v", err)
+	}
+	format := "%s: sensor %s has been above %.1f°C for %s today"
+	if rule.Period == models.DutyCyclePeriodWeek {
+		format = "%s: sensor %s has been above %.1f°C for %s this week"
+	}
+	spent := time.Duration(seconds * float64(time.Second)).Round(time.Minute)
+
+	// The value is the time above the threshold in the period, in seconds
+	alert := &models.Alert{
+		AlertID:     uuid.New().String(),
+		RuleID:      rule.RuleID,
+		ClientID:    sensor.ClientID,
+		SensorID:    sensor.SensorID,
+		Value:       seconds,
+		TriggeredAt: now,
+		IsActive:    true,
+		Severity:    rule.Severity,
+		Message:     l.T(format, rule.Name, name, rule.Threshold, spent),
+		Muted:       mute.Silenced(db, rule, now),
+	}
+	if err := db.Create(alert).Error; err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	log.Printf("Triggered thermal budget alert %s for sensor %s on client %s", alert.AlertID, sensor.SensorID, sensor.ClientID)
+	alertevents.Publish(db, t.cfg.Events, activity.AlertTriggered, alert, nil)
+	if alert.Muted {
+		log.Printf("Skipped notifications of alert %s: notifications are muted or rule %s is snoozed", alert.AlertID, rule.RuleID)
+		return nil
+	}
+	t.cfg.Scripts.OnAlert(alert)
+	commands.RunEmergencyActions(db, rule, alert)
+	alertlog.Run(db, rule, alert)
+	t.cfg.Notify.Triggered(alert)
+	return nil
+}
@@ -0,0 +1,267 @@
+// Package dutycycle tracks how long each sensor spends above thresholds each
+// local day, for thermal budget rules and for judging the long-term stress
+// on hardware. A reading counts until the sensor's next one, or for at most a
+// few of its typical intervals when the next is late, so gaps in reporting
+// are not counted either way.
+package dutycycle
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
+	"github.com/nickheyer/jacuzzi/pkg/server/maintenance"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/notify"
+	"github.com/nickheyer/jacuzzi/pkg/server/scripting"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// gapFactor is how many typical intervals a reading counts for at most
+	gapFactor = 3
+	// carryIn is how far before a day readings are loaded, as the last one
+	// before midnight counts into the day
+	carryIn = 15 * time.Minute
+)
+
+// Config controls which thresholds are tracked
+type Config struct {
+	// Thresholds in °C by canonical sensor type, and for types without their
+	// own. The thresholds of thermal budget rules matching a sensor are
+	// tracked as well.
+	Thresholds        map[string][]float64
+	DefaultThresholds []float64
+
+	// Runs scripts on triggered alerts; nil disables
+	Scripts *scripting.Engine
+
+	// Sends triggered and resolved alerts to notification channels; nil
+	// disables
+	Notify *notify.Router
+
+	// Hub that alert events are published to for streaming subscribers; nil
+	// disables
+	Events *events.Hub
+}
+
+// Tracker updates the duty cycles of sensors that are not retired and
+// raises alerts for thermal budget rules
+type Tracker struct {
+	db  *gorm.DB
+	cfg Config
+}
+
+func NewTracker(db *gorm.DB, cfg Config) *Tracker {
+	return &Tracker{db: db, cfg: cfg}
+}
+
+// RunOnce updates the duty cycles of every day from the last one tracked,
+// or yesterday if later, until now, then evaluates thermal budget rules.
+// The first run covers the retention period of readings.
+func (t *Tracker) RunOnce(ctx context.Context, now time.Time) error {
+	db := t.db.WithContext(ctx)
+	loc, err := timezone.Load(db)
+	if err != nil {
+		return err
+	}
+	var rules []models.AlertRule
+	err = db.Preload("Actions").
+		Where("enabled = ? AND condition_type = ?", true, models.ConditionTypeThermalBudget).
+		Find(&rules).Error
+	if err != nil {
+		return fmt.Errorf("failed to load thermal budget rules: %w", err)
+	}
+
+	start := timezone.Previous(timezone.Floor(now, timezone.Day, loc), timezone.Day, loc)
+	var latest []models.DutyCycle
+	if err := db.Order("day DESC").Limit(1).Find(&latest).Error; err != nil {
+		return fmt.Errorf("failed to load duty cycles: %w", err)
+	}
+	if len(latest) == 0 {
+		days, err := maintenance.RetentionDays(db)
+		if err != nil {
+			return err
+		}
+		start = timezone.Floor(now.AddDate(0, 0, -days), timezone.Day, loc)
+	} else if latest[0].Day.Before(start) {
+		start = timezone.Floor(latest[0].Day, timezone.Day, loc)
+	}
+
+	var sensors []models.Sensor
+	err = db.Where("retired_at IS NULL AND last_reading_at >= ?", start.Add(-carryIn)).Find(&sensors).Error
+	if err != nil {
+		return fmt.Errorf("failed to load sensors: %w", err)
+	}
+	for i := range sensors {
+		sensor := &sensors[i]
+		thresholds := t.thresholds(sensor, rules)
+		if len(thresholds) == 0 {
+			continue
+		}
+		if err := t.track(db, sensor, thresholds, start, now, loc); err != nil {
+			return err
+		}
+	}
+	return t.evaluate(db, rules, now, loc)
+}
+
+// thresholds returns the thresholds tracked for a sensor, lowest first
+func (t *Tracker) thresholds(sensor *models.Sensor, rules []models.AlertRule) []float64 {
+	configured, ok := t.cfg.Thresholds[sensor.SensorType]
+	if !ok {
+		configured = t.cfg.DefaultThresholds
+	}
+	thresholds := slices.Clone(configured)
+	for _, rule := range rules {
+		if matches(rule, sensor) {
+			thresholds = append(thresholds, rule.Threshold)
+		}
+	}
+	slices.Sort(thresholds)
+	return slices.Compact(thresholds)
+}
+
+// track updates a sensor's duty cycles of each day from start until now
+func (t *Tracker) track(db *gorm.DB, sensor *models.Sensor, thresholds []float64, start, now time.Time, loc *time.Location) error {
+	samples, err := loadSamples(db, sensor, start.Add(-carryIn), now)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+	limit := gapFactor * typicalInterval(samples)
+
+	for day := start; day.Before(now); day = timezone.Next(day, timezone.Day, loc) {
+		end := timezone.Next(day, timezone.Day, loc)
+		if end.After(now) {
+			end = now
+		}
+		// The last reading before the day counts into it
+		first := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(day) })
+		if first > 0 {
+			first--
+		}
+		above, tracked := measure(samples[first:], thresholds, day, end, limit)
+		if tracked == 0 {
+			continue
+		}
+		rows := make([]models.DutyCycle, len(thresholds))
+		for i, threshold := range thresholds {
+			rows[i] = models.DutyCycle{
+				ClientID:  sensor.ClientID,
+				SensorID:  sensor.SensorID,
+				Threshold: threshold,
+				Day:       day.UTC(),
+				Above:     above[i],
+				Tracked:   tracked,
+			}
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "client_id"}, {Name: "sensor_id"}, {Name: "threshold"}, {Name: "day"}},
+			DoUpdates: clause.AssignmentColumns([]string{"above", "tracked", "updated_at"}),
+		}).Create(&rows).Error
+		if err != nil {
+			return fmt.Errorf("failed to save duty cycles of sensor %s on client %s: %w", sensor.SensorID, sensor.ClientID, err)
+		}
+	}
+	return nil
+}
+
+// sample is one reading of a sensor
+type sample struct {
+	Time    time.Time
+	Celsius float64
+}
+
+// loadSamples returns a sensor's regular readings from start until end, raw
+// and compressed, oldest first
+func loadSamples(db *gorm.DB, sensor *models.Sensor, start, end time.Time) ([]sample, error) {
+	var raw []models.TemperatureReading
+	err := db.Select("temperature_celsius", "created_at").
+		Where("client_id = ? AND sensor_id = ? AND burst = ?", sensor.ClientID, sensor.SensorID, false).
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Find(&raw).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load readings of sensor %s on client %s: %w", sensor.SensorID, sensor.ClientID, err)
+	}
+	compressed, err := chunks.Readings(db, chunks.Filter{
+		ClientID:  sensor.ClientID,
+		SensorIDs: []string{sensor.SensorID},
+		Start:     start,
+		End:       end.Add(-time.Millisecond),
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]sample, 0, len(raw)+len(compressed))
+	for _, r := range append(raw, compressed...) {
+		samples = append(samples, sample{Time: r.CreatedAt, Celsius: r.TemperatureCelsius})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// typicalInterval returns the median interval between samples, or a minute
+// for a single sample
+func typicalInterval(samples []sample) time.Duration {
+	if len(samples) < 2 {
+		return time.Minute
+	}
+	intervals := make([]time.Duration, len(samples)-1)
+	for i := 1; i < len(samples); i++ {
+		intervals[i-1] = samples[i].Time.Sub(samples[i-1].Time)
+	}
+	slices.Sort(intervals)
+	return intervals[len(intervals)/2]
+}
+
+// measure returns the seconds samples spent above each threshold from start
+// until end, and the seconds they cover. Each sample counts until the next,
+// or for at most limit.
+func measure(samples []sample, thresholds []float64, start, end time.Time, limit time.Duration) ([]float64, float64) {
+	above := make([]float64, len(thresholds))
+	var tracked float64
+	for i, s := range samples {
+		if !s.Time.Before(end) {
+			break
+		}
+		until := s.Time.Add(limit)
+		if i+1 < len(samples) && samples[i+1].Time.Before(until) {
+			until = samples[i+1].Time
+		}
+		from := s.Time
+		if from.Before(start) {
+			from = start
+		}
+		if until.After(end) {
+			until = end
+		}
+		if !until.After(from) {
+			continue
+		}
+		seconds := until.Sub(from).Seconds()
+		tracked += seconds
+		for j, threshold := range thresholds {
+			if s.Celsius > threshold {
+				above[j] += seconds
+			}
+		}
+	}
+	return above, tracked
+}
+
+// matches reports whether a thermal budget rule applies to a sensor
+func matches(rule models.AlertRule, sensor *models.Sensor) bool {
+	return (rule.ClientID == "" || rule.ClientID == sensor.ClientID) &&
+		(rule.SensorID == "" || rule.SensorID == sensor.SensorID) &&
+		(rule.SensorType == "" || rule.SensorType == sensor.SensorType)
+}
@@ -0,0 +1,230 @@
+package evaluator
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertevents"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// backfillWatermark names the watermark of ingested readings checked for
+// backfilled breaches
+const backfillWatermark = "alert_backfill"
+
+const (
+	// Readings ingested within this window of now are left for the next run
+	// so transactions that are still committing are not skipped
+	backfillLag = 30 * time.Second
+	// backfillBatch bounds the ingested readings loaded at once
+	backfillBatch = 5000
+)
+
+// backfill records breaches of threshold rules shown by readings that
+// arrived too late for the evaluation at their time, such as readings an
+// agent buffered while offline. A breach is recorded as an alert that is
+// already resolved, without notifications or actions, once a later reading
+// clears it; one still going on is left to the regular evaluation. Aggregate
+// rules are not checked, as the rest of the group at that time is not known.
+func (e *Evaluator) backfill(db *gorm.DB, rules []models.AlertRule, settings alertSettings, loc *time.Location, now time.Time) error {
+	var wm models.RollupWatermark
+	if err := db.Where("name = ?", backfillWatermark).FirstOrCreate(&wm, models.RollupWatermark{Name: backfillWatermark}).Error; err != nil {
+		return fmt.Errorf("failed to load backfill watermark: %w", err)
+	}
+	upTo := now.Add(-backfillLag)
+	if wm.Watermark.IsZero() {
+		// Readings ingested before backfill evaluation was enabled are not
+		// checked
+		wm.Watermark = upTo
+		if err := db.Save(&wm).Error; err != nil {
+			return fmt.Errorf("failed to save backfill watermark: %w", err)
+		}
+		return nil
+	}
+	if !upTo.After(wm.Watermark) {
+		return nil
+	}
+
+	// A reading is late when it arrived after the evaluation that would have
+	// seen it as the sensor's latest
+	type sensorKey struct{ client, sensor string }
+	late := make(map[sensorKey][]models.TemperatureReading)
+	var lastID uint
+	for {
+		var readings []models.TemperatureReading
+		err := db.Where("updated_at > ? AND updated_at <= ? AND burst = ? AND id > ?", wm.Watermark, upTo, false, lastID).
+			Order("id").
+			Limit(backfillBatch).
+			Find(&readings).Error
+		if err != nil {
+			return fmt.Errorf("failed to find ingested readings: %w", err)
+		}
+		for _, r := range readings {
+			if r.UpdatedAt.Sub(r.CreatedAt) > settings.interval {
+				key := sensorKey{r.ClientID, r.SensorID}
+				late[key] = append(late[key], r)
+			}
+		}
+		if len(readings) < backfillBatch {
+			break
+		}
+		lastID = readings[len(readings)-1].ID
+	}
+
+	if len(late) > 0 {
+		// One late reading per sensor is enough to match rules against
+		first := make([]models.TemperatureReading, 0, len(late))
+		for _, readings := range late {
+			sort.Slice(readings, func(i, j int) bool { return readings[i].CreatedAt.Before(readings[j].CreatedAt) })
+			first = append(first, readings[0])
+		}
+		sensors, err := withClients(db, first)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if rule.ConditionType != models.ConditionTypeThreshold {
+				continue
+			}
+			for _, r := range sensors.matching(rule) {
+				readings := late[sensorKey{r.ClientID, r.SensorID}]
+				if err := e.backfillSensor(db, rule, readings, settings, loc); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	wm.Watermark = upTo
+	if err := db.Save(&wm).Error; err != nil {
+		return fmt.Errorf("failed to save backfill watermark: %w", err)
+	}
+	return nil
+}
+
+// backfillSensor records the breaches of a rule by one sensor that include
+// any of its late readings, oldest first
+func (e *Evaluator) backfillSensor(db *gorm.DB, rule models.AlertRule, late []models.TemperatureReading, settings alertSettings, loc *time.Location) error {
+	// The readings around the late ones tell where their breaches start and
+	// end, up to the first reading after the last late one
+	from, to := late[0].CreatedAt, late[len(late)-1].CreatedAt
+	var series []models.TemperatureReading
+	err := db.Where("client_id = ? AND sensor_id = ? AND burst = ? AND created_at >= ? AND created_at <= ?", late[0].ClientID, late[0].SensorID, false, from, to).
+		Order("created_at").
+		Find(&series).Error
+	if err != nil {
+		return fmt.Errorf("failed to load readings of sensor %s: %w", late[0].SensorID, err)
+	}
+	var next []models.TemperatureReading
+	err = db.Where("client_id = ? AND sensor_id = ? AND burst = ? AND created_at > ?", late[0].ClientID, late[0].SensorID, false, to).
+		Order("created_at").
+		Limit(1).
+		Find(&next).Error
+	if err != nil {
+		return fmt.Errorf("failed to load readings of sensor %s: %w", late[0].SensorID, err)
+	}
+	series = append(series, next...)
+
+	lateIDs := make(map[uint]bool, len(late))
+	for _, r := range late {
+		lateIDs[r.ID] = true
+	}
+	duration := time.Duration(rule.DurationSeconds) * time.Second
+	start := -1
+	for i, r := range series {
+		threshold, window, suppressed := activeThreshold(rule, r.CreatedAt.In(loc))
+		if !suppressed && breaches(rule.Operator, r.TemperatureCelsius, threshold) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		run := series[start:i]
+		start = -1
+
+		// The breach fires at its first reading past the rule's duration
+		fired := -1
+		hasLate := false
+		for j, b := range run {
+			hasLate = hasLate || lateIDs[b.ID]
+			if fired < 0 && b.CreatedAt.Sub(run[0].CreatedAt) >= duration {
+				fired = j
+			}
+		}
+		if fired < 0 || !hasLate {
+			continue
+		}
+		threshold, window, _ = activeThreshold(rule, run[fired].CreatedAt.In(loc))
+		if err := e.recordBackfilled(db, rule, run[fired], r.CreatedAt, run[0].CreatedAt, threshold, window, settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordBackfilled creates a resolved alert for a breach found in late
+// readings, unless the rule already has an alert for the sensor overlapping
+// it
+func (e *Evaluator) recordBackfilled(db *gorm.DB, rule models.AlertRule, r models.TemperatureReading, resolvedAt, since time.Time, threshold float64, window *models.ThresholdWindow, settings alertSettings) error {
+	var overlapping int64
+	err := db.Model(&models.Alert{}).
+		Where("rule_id = ? AND client_id = ? AND sensor_id = ?", rule.RuleID, r.ClientID, r.SensorID).
+		Where("triggered_at <= ? AND (resolved_at IS NULL OR resolved_at >= ?)", resolvedAt, since).
+		Count(&overlapping).Error
+	if err != nil {
+		return fmt.Errorf("failed to check alerts of rule %s: %w", rule.RuleID, err)
+	}
+	if overlapping > 0 {
+		return nil
+	}
+
+	l := settings.locale
+	name := r.SensorID
+	if r.SensorName != "" {
+		name = r.SensorName
+	}
+	message := l.T(comparisons[rule.Operator], rule.Name, l.T("sensor %s", name), r.TemperatureCelsius, threshold)
+	if window != nil {
+		label := window.Name
+		if label == "" {
+			label = window.Schedule
+		}
+		message += l.T(" (%s window)", label)
+	}
+	message += l.T(" (backfilled)")
+	alert := &models.Alert{
+		AlertID:     uuid.New().String(),
+		RuleID:      rule.RuleID,
+		ClientID:    r.ClientID,
+		SensorID:    r.SensorID,
+		Value:       r.TemperatureCelsius,
+		TriggeredAt: r.CreatedAt,
+		ResolvedAt:  &resolvedAt,
+		Severity:    rule.Severity,
+		Message:     message,
+	}
+	// is_active defaults to true on create, so it is cleared in the same
+	// transaction
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(alert).Error; err != nil {
+			return err
+		}
+		return tx.Model(alert).UpdateColumn("is_active", false).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	log.Printf("Recorded backfilled alert %s for sensor %s on client %s", alert.AlertID, r.SensorID, r.ClientID)
+	details := map[string]string{"backfilled": "true"}
+	alertevents.Publish(db, e.cfg.Events, activity.AlertTriggered, alert, details)
+	alertevents.Publish(db, e.cfg.Events, activity.AlertResolved, alert, details)
+	return nil
+}
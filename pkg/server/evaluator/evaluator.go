@@ -0,0 +1,438 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertcontext"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertevents"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertlog"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/cron"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/mute"
+	"github.com/nickheyer/jacuzzi/pkg/server/notify"
+	"github.com/nickheyer/jacuzzi/pkg/server/scripting"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"gorm.io/gorm"
+)
+
+// defaultInterval is used when the alerts.check_interval_seconds setting is
+// unset or invalid
+const defaultInterval = time.Minute
+
+// flapWindow is the period triggers are counted over for flap detection
+const flapWindow = time.Hour
+
+// Evaluator raises alerts for threshold rules from the latest reading of
+// each sensor, and for aggregate rules from the aggregate of a group's
+// latest readings, using the threshold of the rule's window that covers the
+// current time, and resolves them once the value no longer breaches or, for
+// rules with a clear point, is back past it
+type Evaluator struct {
+	db  *gorm.DB
+	cfg Config
+	// When each breaching sensor or group was first seen breaching its rule,
+	// so rules with a duration fire only once the breach has lasted that long.
+	// Mirrors the pending_alerts table once restored from it.
+	pending map[alertKey]time.Time
+}
+
+// Config holds optional integrations for triggered alerts
+type Config struct {
+	// Runs scripts on triggered alerts; nil disables
+	Scripts *scripting.Engine
+
+	// Sends triggered and resolved alerts to notification channels; nil
+	// disables
+	Notify *notify.Router
+
+	// Hub that alert events are published to for streaming subscribers; nil
+	// disables
+	Events *events.Hub
+
+	// Also check readings that arrived late, such as those agents buffered
+	// while offline, against threshold rules and record their breaches as
+	// resolved alerts
+	Backfill bool
+}
+
+// alertKey identifies a rule's target; aggregate rules have a single target
+// with an empty client and sensor
+type alertKey struct{ rule, client, sensor string }
+
+func NewEvaluator(db *gorm.DB, cfg Config) *Evaluator {
+	return &Evaluator{db: db, cfg: cfg}
+}
+
+// Evaluate runs the alert evaluation job, which does nothing while alerts
+// are disabled
+func (e *Evaluator) Evaluate(ctx context.Context) error {
+	settings, err := loadSettings(e.db.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !settings.enabled {
+		return nil
+	}
+	return e.RunOnce(ctx, time.Now())
+}
+
+// Schedule runs alert evaluation every alerts.check_interval_seconds. The
+// setting is reread after each run, so changes apply without a restart.
+type Schedule struct{}
+
+func (Schedule) Next(db *gorm.DB, last time.Time) time.Time {
+	if last.IsZero() {
+		return time.Now()
+	}
+	// Settings that fail to load fall back to the default interval
+	settings, _ := loadSettings(db)
+	return last.Add(settings.interval)
+}
+
+func (Schedule) String() string {
+	return "every alerts.check_interval_seconds"
+}
+
+// alertSettings are the alerts settings the evaluator follows
+type alertSettings struct {
+	enabled       bool
+	interval      time.Duration
+	flapThreshold int64         // Triggers within flapWindow after which alerts are flapping
+	contextWindow time.Duration // Kept with threshold alerts before and after they trigger
+	locale        i18n.Locale   // Language of alert messages
+}
+
+func loadSettings(db *gorm.DB) (alertSettings, error) {
+	result := alertSettings{
+		enabled:       true,
+		interval:      defaultInterval,
+		flapThreshold: models.DefaultAlertFlapThreshold,
+		contextWindow: models.DefaultAlertContextMinutes * time.Minute,
+		locale:        i18n.Default,
+	}
+	var stored []models.Setting
+	keys := []string{models.SettingAlertsEnabled, models.SettingAlertsCheckInterval, models.SettingAlertsFlapThreshold, models.SettingAlertsContextMinutes, models.SettingLocale}
+	if err := db.Where("key IN ?", keys).Find(&stored).Error; err != nil {
+		return result, fmt.Errorf("failed to load alert settings: %w", err)
+	}
+	for _, setting := range stored {
+		switch setting.Key {
+		case models.SettingAlertsEnabled:
+			if value, err := strconv.ParseBool(setting.Value); err == nil {
+				result.enabled = value
+			}
+		case models.SettingAlertsCheckInterval:
+			if seconds, err := strconv.Atoi(setting.Value); err == nil && seconds > 0 {
+				result.interval = time.Duration(seconds) * time.Second
+			}
+		case models.SettingAlertsFlapThreshold:
+			if threshold, err := strconv.ParseInt(setting.Value, 10, 64); err == nil && threshold > 0 {
+				result.flapThreshold = threshold
+			}
+		case models.SettingAlertsContextMinutes:
+			if minutes, err := strconv.Atoi(setting.Value); err == nil && minutes > 0 {
+				result.contextWindow = time.Duration(minutes) * time.Minute
+			}
+		case models.SettingLocale:
+			if locale, ok := i18n.Parse(setting.Value); ok {
+				result.locale = locale
+			}
+		}
+	}
+	return result, nil
+}
+
+// RunOnce evaluates every enabled threshold rule against the latest reading
+// of each matching sensor, and every aggregate rule against the aggregate of
+// its group's latest readings
+func (e *Evaluator) RunOnce(ctx context.Context, now time.Time) error {
+	db := e.db.WithContext(ctx)
+	if e.pending == nil {
+		if err := e.restore(db); err != nil {
+			return err
+		}
+	}
+
+	// Context windows that ended are captured whatever rules remain
+	if _, err := alertcontext.Complete(db, now); err != nil {
+		log.Printf("Failed to capture alert context: %v", err)
+	}
+
+	var rules []models.AlertRule
+	err := db.Preload("Actions").
+		Where("enabled = ? AND condition_type IN ?", true, []string{models.ConditionTypeThreshold, models.ConditionTypeAggregate}).
+		Find(&rules).Error
+	if err != nil {
+		return fmt.Errorf("failed to load threshold rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return e.save(db, map[alertKey]time.Time{})
+	}
+
+	loc, err := timezone.Load(db)
+	if err != nil {
+		return err
+	}
+	settings, err := loadSettings(db)
+	if err != nil {
+		return err
+	}
+	latest, err := loadLatest(db)
+	if err != nil {
+		return err
+	}
+
+	ruleIDs := make([]string, len(rules))
+	for i, rule := range rules {
+		ruleIDs[i] = rule.RuleID
+	}
+	var active []models.Alert
+	if err := db.Where("is_active = ? AND rule_id IN ?", true, ruleIDs).Find(&active).Error; err != nil {
+		return fmt.Errorf("failed to load active alerts: %w", err)
+	}
+	open := make(map[alertKey]models.Alert, len(active))
+	for _, alert := range active {
+		open[alertKey{alert.RuleID, alert.ClientID, alert.SensorID}] = alert
+	}
+
+	pending := make(map[alertKey]time.Time, len(e.pending))
+	for _, rule := range rules {
+		threshold, window, suppressed := activeThreshold(rule, now.In(loc))
+		var targets []target
+		if rule.ConditionType == models.ConditionTypeAggregate {
+			targets = latest.aggregate(rule, now)
+		} else {
+			targets = latest.sensors(rule)
+		}
+		for _, t := range targets {
+			key := alertKey{rule.RuleID, t.client, t.sensor}
+			if suppressed {
+				// Leave open alerts as they are until the window ends
+				delete(open, key)
+				continue
+			}
+			if _, ok := open[key]; ok {
+				if !cleared(rule, t.value, threshold) {
+					// Not back past the clear point; keep the alert open
+					delete(open, key)
+				}
+				continue
+			}
+			if !breaches(rule.Operator, t.value, threshold) {
+				continue
+			}
+			since, ok := e.pending[key]
+			if !ok {
+				since = t.since
+			}
+			pending[key] = since
+			// A stale sensor's last reading is not news, so it raises nothing
+			if t.stale {
+				continue
+			}
+			if now.Sub(since) < time.Duration(rule.DurationSeconds)*time.Second {
+				continue
+			}
+			if err := e.trigger(db, rule, t, threshold, window, settings, now); err != nil {
+				return err
+			}
+			delete(pending, key)
+		}
+	}
+	if err := e.save(db, pending); err != nil {
+		return err
+	}
+
+	// Alerts left over belong to sensors or groups that cleared, sensors that
+	// were retired, or targets that no longer match their rule
+	for _, alert := range open {
+		resolvedAt := now
+		err := db.Model(&models.Alert{}).
+			Where("id = ?", alert.ID).
+			Updates(map[string]interface{}{"is_active": false, "resolved_at": &resolvedAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to resolve alert %s: %w", alert.AlertID, err)
+		}
+		if alert.SensorID == "" {
+			log.Printf("Resolved aggregate alert %s of rule %s", alert.AlertID, alert.RuleID)
+		} else {
+			log.Printf("Resolved threshold alert %s for sensor %s on client %s", alert.AlertID, alert.SensorID, alert.ClientID)
+		}
+		alert.IsActive = false
+		alert.ResolvedAt = &resolvedAt
+		alertevents.Publish(db, e.cfg.Events, activity.AlertResolved, &alert, nil)
+		e.cfg.Notify.Resolved(&alert)
+	}
+
+	if e.cfg.Backfill {
+		return e.backfill(db, rules, settings, loc, now)
+	}
+	return nil
+}
+
+// activeThreshold returns the threshold of the first of the rule's windows
+// covering local, or the rule's own threshold outside every window, and
+// whether the window suppresses alerts. Windows that do not parse are
+// skipped; they are validated when the rule is saved.
+func activeThreshold(rule models.AlertRule, local time.Time) (float64, *models.ThresholdWindow, bool) {
+	if rule.Windows == "" {
+		return rule.Threshold, nil, false
+	}
+	var windows []models.ThresholdWindow
+	if err := json.Unmarshal([]byte(rule.Windows), &windows); err != nil {
+		log.Printf("Ignoring invalid threshold windows of rule %s: %v", rule.RuleID, err)
+		return rule.Threshold, nil, false
+	}
+	for i := range windows {
+		schedule, err := cron.Parse(windows[i].Schedule)
+		if err != nil {
+			log.Printf("Ignoring threshold window %q of rule %s: %v", windows[i].Schedule, rule.RuleID, err)
+			continue
+		}
+		if schedule.Matches(local) {
+			return windows[i].Threshold, &windows[i], windows[i].Suppress
+		}
+	}
+	return rule.Threshold, nil, false
+}
+
+// breaches compares a value with a threshold using a stored operator
+func breaches(operator string, value, threshold float64) bool {
+	switch operator {
+	case "OPERATOR_GREATER_THAN":
+		return value > threshold
+	case "OPERATOR_LESS_THAN":
+		return value < threshold
+	case "OPERATOR_EQUAL":
+		return value == threshold
+	case "OPERATOR_NOT_EQUAL":
+		return value != threshold
+	}
+	return false
+}
+
+// cleared reports whether the value of an open alert is back past its rule's
+// clear point, or no longer breaches when the rule has none
+func cleared(rule models.AlertRule, value, threshold float64) bool {
+	point, ok := clearPoint(rule, threshold)
+	if !ok {
+		return !breaches(rule.Operator, value, threshold)
+	}
+	switch rule.Operator {
+	case "OPERATOR_GREATER_THAN":
+		return value < point
+	case "OPERATOR_LESS_THAN":
+		return value > point
+	}
+	return !breaches(rule.Operator, value, threshold)
+}
+
+// clearPoint returns the value an open alert must get back past to resolve,
+// relative to the threshold in effect
+func clearPoint(rule models.AlertRule, threshold float64) (float64, bool) {
+	switch rule.Operator {
+	case "OPERATOR_GREATER_THAN":
+		if rule.ClearThreshold != nil {
+			// A window may lower the threshold past the clear threshold
+			return min(*rule.ClearThreshold, threshold), true
+		}
+		if rule.ClearPercent > 0 {
+			return threshold - math.Abs(threshold)*rule.ClearPercent/100, true
+		}
+	case "OPERATOR_LESS_THAN":
+		if rule.ClearThreshold != nil {
+			return max(*rule.ClearThreshold, threshold), true
+		}
+		if rule.ClearPercent > 0 {
+			return threshold + math.Abs(threshold)*rule.ClearPercent/100, true
+		}
+	}
+	return 0, false
+}
+
+// comparisons are the alert messages of each operator, of the rule name,
+// subject, value and threshold
+var comparisons = map[string]string{
+	"OPERATOR_GREATER_THAN": "%s: %s is %.1f°C, above %.1f°C",
+	"OPERATOR_LESS_THAN":    "%s: %s is %.1f°C, below %.1f°C",
+	"OPERATOR_EQUAL":        "%s: %s is %.1f°C, at %.1f°C",
+	"OPERATOR_NOT_EQUAL":    "%s: %s is %.1f°C, not at %.1f°C",
+}
+
+func (e *Evaluator) trigger(db *gorm.DB, rule models.AlertRule, t target, threshold float64, window *models.ThresholdWindow, settings alertSettings, now time.Time) error {
+	l := settings.locale
+	message := l.T(comparisons[rule.Operator], rule.Name, t.subject(l), t.value, threshold)
+	if window != nil {
+		label := window.Name
+		if label == "" {
+			label = window.Schedule
+		}
+		message += l.T(" (%s window)", label)
+	}
+	// Every trigger follows a resolve, so the triggers in the flap window
+	// count the target's flaps
+	var recent int64
+	err := db.Model(&models.Alert{}).
+		Where("rule_id = ? AND client_id = ? AND sensor_id = ? AND triggered_at > ?", rule.RuleID, t.client, t.sensor, now.Add(-flapWindow).UTC()).
+		Count(&recent).Error
+	if err != nil {
+		return fmt.Errorf("failed to count recent alerts of rule %s: %w", rule.RuleID, err)
+	}
+	alert := &models.Alert{
+		AlertID:     uuid.New().String(),
+		RuleID:      rule.RuleID,
+		ClientID:    t.client,
+		SensorID:    t.sensor,
+		Value:       t.value,
+		TriggeredAt: now,
+		IsActive:    true,
+		Severity:    rule.Severity,
+		Message:     message,
+		Muted:       mute.Silenced(db, rule, now),
+		Flapping:    recent+1 > settings.flapThreshold,
+	}
+	alertcontext.Window(alert, settings.contextWindow)
+	if err := db.Create(alert).Error; err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	// The readings after the trigger are captured once its window ends
+	if err := alertcontext.Capture(db, alert); err != nil {
+		log.Printf("Failed to capture context of alert %s: %v", alert.AlertID, err)
+	}
+	if t.sensor == "" {
+		log.Printf("Triggered aggregate alert %s of rule %s", alert.AlertID, rule.RuleID)
+	} else {
+		log.Printf("Triggered threshold alert %s for sensor %s on client %s", alert.AlertID, t.sensor, t.client)
+	}
+	alertevents.Publish(db, e.cfg.Events, activity.AlertTriggered, alert, nil)
+	e.notify(db, rule, alert)
+	return nil
+}
+
+// notify runs the scripts, emergency actions and log actions of a triggered
+// alert, unless it was raised while muted or flapping
+func (e *Evaluator) notify(db *gorm.DB, rule models.AlertRule, alert *models.Alert) {
+	if alert.Muted {
+		log.Printf("Skipped notifications of alert %s: notifications are muted or rule %s is snoozed", alert.AlertID, rule.RuleID)
+		return
+	}
+	if alert.Flapping {
+		log.Printf("Skipped notifications of alert %s: rule %s is flapping", alert.AlertID, rule.RuleID)
+		return
+	}
+	e.cfg.Scripts.OnAlert(alert)
+	commands.RunEmergencyActions(db, rule, alert)
+	alertlog.Run(db, rule, alert)
+	e.cfg.Notify.Triggered(alert)
+}
@@ -0,0 +1,64 @@
+package evaluator
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// restore loads the breaches a previous evaluator was timing, so their
+// durations carry on across restarts and leader changes
+func (e *Evaluator) restore(db *gorm.DB) error {
+	var stored []models.PendingAlert
+	if err := db.Find(&stored).Error; err != nil {
+		return fmt.Errorf("failed to load pending alerts: %w", err)
+	}
+	e.pending = make(map[alertKey]time.Time, len(stored))
+	for _, p := range stored {
+		e.pending[alertKey{p.RuleID, p.ClientID, p.SensorID}] = p.Since
+	}
+	if len(stored) > 0 {
+		log.Printf("Restored %d pending alerts", len(stored))
+	}
+	return nil
+}
+
+// save replaces the pending breaches with those of the latest evaluation,
+// writing only the ones that started or ended
+func (e *Evaluator) save(db *gorm.DB, pending map[alertKey]time.Time) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for key, since := range e.pending {
+			if current, ok := pending[key]; ok && current.Equal(since) {
+				continue
+			}
+			err := tx.Where("rule_id = ? AND client_id = ? AND sensor_id = ?", key.rule, key.client, key.sensor).
+				Delete(&models.PendingAlert{}).Error
+			if err != nil {
+				return fmt.Errorf("failed to delete pending alert of rule %s: %w", key.rule, err)
+			}
+		}
+		for key, since := range pending {
+			if previous, ok := e.pending[key]; ok && previous.Equal(since) {
+				continue
+			}
+			err := tx.Create(&models.PendingAlert{
+				RuleID:   key.rule,
+				ClientID: key.client,
+				SensorID: key.sensor,
+				Since:    since,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to save pending alert of rule %s: %w", key.rule, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	e.pending = pending
+	return nil
+}
@@ -0,0 +1,187 @@
+package evaluator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// target is what a rule compares with its threshold: the latest reading of
+// one sensor, or the aggregate of the latest readings of a group
+type target struct {
+	client, sensor string                   // Both empty for aggregates
+	subject        func(i18n.Locale) string // e.g. "sensor cpu0" or "average of 4 sensors"
+	value          float64
+	since          time.Time // Start of a breach that begins now
+	stale          bool      // The sensor stopped reporting, so its value is old
+}
+
+// reading is a sensor's latest reading with what rules select it by
+type reading struct {
+	models.TemperatureReading
+	stale    bool
+	site     string
+	metadata map[string]string
+}
+
+// latest holds the latest reading of every sensor that is not retired
+type latest []reading
+
+func loadLatest(db *gorm.DB) (latest, error) {
+	// Readings of stale sensors are included so their alerts stay open; the
+	// stale sensor rules report the silence
+	var sensors []models.Sensor
+	if err := db.Where("retired_at IS NULL AND last_reading_at IS NOT NULL").Find(&sensors).Error; err != nil {
+		return nil, fmt.Errorf("failed to load sensors: %w", err)
+	}
+	type sensorKey struct{ client, sensor string }
+	stale := make(map[sensorKey]bool, len(sensors))
+	for _, sensor := range sensors {
+		stale[sensorKey{sensor.ClientID, sensor.SensorID}] = sensor.StaleSince != nil
+	}
+
+	var readings []models.TemperatureReading
+	err := db.Model(&models.TemperatureReading{}).
+		Select("temperature_readings.*").
+		Joins("JOIN sensors ON sensors.client_id = temperature_readings.client_id AND sensors.sensor_id = temperature_readings.sensor_id").
+		Where("sensors.retired_at IS NULL AND temperature_readings.created_at = sensors.last_reading_at").
+		Find(&readings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load latest readings: %w", err)
+	}
+
+	result, err := withClients(db, readings)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].stale = stale[sensorKey{result[i].ClientID, result[i].SensorID}]
+	}
+	return result, nil
+}
+
+// withClients adds the site and metadata of their clients to readings
+func withClients(db *gorm.DB, readings []models.TemperatureReading) (latest, error) {
+	var clients []models.Client
+	if err := db.Select("client_id", "site", "metadata").Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	byID := make(map[string]*models.Client, len(clients))
+	metadata := make(map[string]map[string]string, len(clients))
+	for i := range clients {
+		client := &clients[i]
+		byID[client.ClientID] = client
+		if client.Metadata != "" {
+			var values map[string]string
+			json.Unmarshal([]byte(client.Metadata), &values)
+			metadata[client.ClientID] = values
+		}
+	}
+
+	result := make(latest, len(readings))
+	for i, r := range readings {
+		result[i] = reading{TemperatureReading: r, metadata: metadata[r.ClientID]}
+		if client := byID[r.ClientID]; client != nil {
+			result[i].site = client.Site
+		}
+	}
+	return result, nil
+}
+
+// matching returns the readings a rule applies to
+func (l latest) matching(rule models.AlertRule) []reading {
+	var selector map[string]string
+	if rule.MetadataSelector != "" {
+		if err := json.Unmarshal([]byte(rule.MetadataSelector), &selector); err != nil {
+			log.Printf("Ignoring rule %s: invalid metadata selector: %v", rule.RuleID, err)
+			return nil
+		}
+	}
+	var matched []reading
+	for _, r := range l {
+		if rule.ClientID != "" && rule.ClientID != r.ClientID ||
+			rule.SensorID != "" && rule.SensorID != r.SensorID ||
+			rule.SensorType != "" && rule.SensorType != r.SensorType ||
+			rule.Site != "" && rule.Site != r.site {
+			continue
+		}
+		selected := true
+		for key, value := range selector {
+			if r.metadata[key] != value {
+				selected = false
+				break
+			}
+		}
+		if selected {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// sensors returns a target for each sensor a threshold rule applies to
+func (l latest) sensors(rule models.AlertRule) []target {
+	matched := l.matching(rule)
+	targets := make([]target, len(matched))
+	for i, r := range matched {
+		name := r.SensorID
+		if r.SensorName != "" {
+			name = r.SensorName
+		}
+		targets[i] = target{
+			client:  r.ClientID,
+			sensor:  r.SensorID,
+			subject: func(l i18n.Locale) string { return l.T("sensor %s", name) },
+			value:   r.TemperatureCelsius,
+			since:   r.CreatedAt,
+			stale:   r.stale,
+		}
+	}
+	return targets
+}
+
+// aggregate returns the single target of an aggregate rule, over the
+// sensors that still report, or none when no sensor of the group does
+func (l latest) aggregate(rule models.AlertRule, now time.Time) []target {
+	var values []float64
+	for _, r := range l.matching(rule) {
+		if !r.stale {
+			values = append(values, r.TemperatureCelsius)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	value, name := values[0], "average"
+	switch rule.Aggregate {
+	case models.AggregateMax:
+		name = "maximum"
+		for _, v := range values[1:] {
+			value = max(value, v)
+		}
+	case models.AggregateMin:
+		name = "minimum"
+		for _, v := range values[1:] {
+			value = min(value, v)
+		}
+	default:
+		for _, v := range values[1:] {
+			value += v
+		}
+		value /= float64(len(values))
+	}
+	count := len(values)
+	subject := func(l i18n.Locale) string {
+		if count == 1 {
+			return l.T("%s of 1 sensor", l.T(name))
+		}
+		return l.T("%s of %d sensors", l.T(name), count)
+	}
+	return []target{{subject: subject, value: value, since: now}}
+}
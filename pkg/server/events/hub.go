@@ -0,0 +1,199 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+)
+
+// Topics published on the hub
+const (
+	TopicReadings = "readings" // TemperatureReadingBatch of newly stored readings
+	TopicAlerts   = "alerts"   // AlertEvent of an alert that triggered, resolved or was acknowledged
+)
+
+// Backends for sharing events between server replicas
+const (
+	BackendLocal = "local" // Events stay within this process
+	BackendRedis = "redis" // Redis pub/sub
+	BackendNATS  = "nats"  // NATS core subjects
+)
+
+// publishTimeout bounds how long a publish may block the caller
+const publishTimeout = 2 * time.Second
+
+var droppedEvents = metrics.NewCounterVec(
+	"jacuzzi_events_dropped_total",
+	"Events dropped because a subscriber was not keeping up.",
+	"topic",
+)
+
+// Transport carries events between server replicas. Every event published
+// through it, including by this replica, is delivered back to Run's callback.
+type Transport interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+	// Run delivers events until ctx is done, reconnecting as needed
+	Run(ctx context.Context, deliver func(topic string, data []byte))
+	Close() error
+}
+
+// Hub fans events out to local subscribers, such as StreamTemperatures calls.
+// With a transport, events published on any replica reach subscribers on all
+// of them.
+type Hub struct {
+	transport Transport
+
+	mu     sync.RWMutex
+	subs   map[string]map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription receives events for one topic on C until it is closed. Events
+// are dropped rather than blocking publishers when C is full.
+type Subscription struct {
+	C <-chan []byte
+
+	hub   *Hub
+	topic string
+	ch    chan []byte
+	once  sync.Once
+}
+
+// NewHub creates a hub. A nil transport keeps events within this process.
+func NewHub(transport Transport) *Hub {
+	return &Hub{
+		transport: transport,
+		subs:      make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// NewTransport creates the transport for a backend, or nil for local
+func NewTransport(backend, rawURL, prefix string) (Transport, error) {
+	if backend == BackendLocal || backend == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid events URL: %w", err)
+	}
+
+	switch backend {
+	case BackendRedis:
+		return newRedisTransport(u, prefix)
+	case BackendNATS:
+		return newNATSTransport(u, prefix)
+	}
+	return nil, fmt.Errorf("unknown events backend %q", backend)
+}
+
+// Run receives events from other replicas until ctx is done. It returns
+// immediately for a local hub.
+func (h *Hub) Run(ctx context.Context) {
+	if h.transport == nil {
+		return
+	}
+	h.transport.Run(ctx, h.deliver)
+	if err := h.transport.Close(); err != nil {
+		log.Printf("Failed to close events transport: %v", err)
+	}
+}
+
+// Publish sends an event to subscribers on every replica. If the transport is
+// unavailable the event is still delivered to local subscribers.
+func (h *Hub) Publish(ctx context.Context, topic string, data []byte) error {
+	if h.transport == nil {
+		h.deliver(topic, data)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	if err := h.transport.Publish(ctx, topic, data); err != nil {
+		h.deliver(topic, data)
+		return err
+	}
+	return nil
+}
+
+// Subscribe registers a subscriber for a topic with the given channel buffer
+func (h *Hub) Subscribe(topic string, buffer int) *Subscription {
+	ch := make(chan []byte, buffer)
+	sub := &Subscription{C: ch, hub: h, topic: topic, ch: ch}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		sub.once.Do(func() {})
+		return sub
+	}
+	if h.subs[topic] == nil {
+		h.subs[topic] = make(map[*Subscription]struct{})
+	}
+	h.subs[topic][sub] = struct{}{}
+	return sub
+}
+
+// Close unregisters the subscription and closes C
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.hub.mu.Lock()
+		delete(s.hub.subs[s.topic], s)
+		s.hub.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+// Close ends every subscription so streaming calls return during shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	var subs []*Subscription
+	for _, topicSubs := range h.subs {
+		for sub := range topicSubs {
+			subs = append(subs, sub)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+func (h *Hub) deliver(topic string, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs[topic] {
+		select {
+		case sub.ch <- data:
+		default:
+			droppedEvents.Inc(topic)
+		}
+	}
+}
+
+// backoff returns the delay before reconnect attempt n, capped at 30 seconds
+func backoff(n int) time.Duration {
+	d := time.Second << min(n, 5)
+	return min(d, 30*time.Second)
+}
+
+// sleepCtx waits for d or until ctx is done, reporting whether ctx is still live
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,41 @@
+package events
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/nats"
+)
+
+// natsTransport shares events over NATS core subjects named <prefix>.<topic>
+type natsTransport struct {
+	client *nats.Client
+	prefix string
+}
+
+func newNATSTransport(u *url.URL, prefix string) (*natsTransport, error) {
+	client, err := nats.NewClient(u.String(), "jacuzzi-server events")
+	if err != nil {
+		return nil, err
+	}
+	return &natsTransport{client: client, prefix: prefix}, nil
+}
+
+func (t *natsTransport) Publish(ctx context.Context, topic string, data []byte) error {
+	return t.client.Publish(ctx, t.prefix+"."+topic, data)
+}
+
+func (t *natsTransport) Run(ctx context.Context, deliver func(topic string, data []byte)) {
+	// Topics are single tokens, so deeper subjects under the prefix are ignored
+	t.client.Subscribe(t.prefix+".*", func(subject string, data []byte) {
+		if topic, ok := strings.CutPrefix(subject, t.prefix+"."); ok {
+			deliver(topic, data)
+		}
+	})
+	t.client.Run(ctx)
+}
+
+func (t *natsTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,213 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisTransport shares events over Redis pub/sub using a minimal RESP client.
+// Events are published to channels named <prefix>.<topic>.
+type redisTransport struct {
+	addr     string
+	password string
+	prefix   string
+
+	mu   sync.Mutex
+	conn *redisConn // Connection used for PUBLISH; subscribers use their own
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newRedisTransport parses redis://[:password@]host[:port]
+func newRedisTransport(u *url.URL, prefix string) (*redisTransport, error) {
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid Redis URL %q: scheme must be redis", u.String())
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	t := &redisTransport{addr: addr, prefix: prefix}
+	if u.User != nil {
+		t.password, _ = u.User.Password()
+	}
+	return t, nil
+}
+
+func (t *redisTransport) Publish(ctx context.Context, topic string, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Retry once in case the pooled connection went stale
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if t.conn == nil {
+			if t.conn, err = t.dial(ctx); err != nil {
+				return err
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			t.conn.SetDeadline(deadline)
+		}
+		if _, err = t.conn.do("PUBLISH", t.prefix+"."+topic, string(data)); err == nil {
+			return nil
+		}
+		t.conn.Close()
+		t.conn = nil
+	}
+	return fmt.Errorf("failed to publish to Redis: %w", err)
+}
+
+func (t *redisTransport) Run(ctx context.Context, deliver func(topic string, data []byte)) {
+	for attempt := 0; ; attempt++ {
+		err := t.subscribe(ctx, deliver, func() { attempt = 0 })
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Redis event subscription failed: %v", err)
+		if !sleepCtx(ctx, backoff(attempt)) {
+			return
+		}
+	}
+}
+
+// subscribe receives events on one connection until it fails or ctx is done
+func (t *redisTransport) subscribe(ctx context.Context, deliver func(topic string, data []byte), connected func()) error {
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	pattern := t.prefix + ".*"
+	if err := conn.send("PSUBSCRIBE", pattern); err != nil {
+		return err
+	}
+	connected()
+
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		// Messages arrive as [pmessage, pattern, channel, payload]
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 4 || msg[0] != "pmessage" {
+			continue
+		}
+		channel, _ := msg[2].(string)
+		payload, _ := msg[3].(string)
+		if topic, ok := strings.CutPrefix(channel, t.prefix+"."); ok {
+			deliver(topic, []byte(payload))
+		}
+	}
+}
+
+func (t *redisTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *redisTransport) dial(ctx context.Context) (*redisConn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis at %s: %w", t.addr, err)
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if t.password != "" {
+		conn.SetDeadline(time.Now().Add(publishTimeout))
+		if _, err := conn.do("AUTH", t.password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to authenticate with Redis: %w", err)
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, nil
+}
+
+// do sends a command and reads its reply
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// send writes a command as a RESP array of bulk strings
+func (c *redisConn) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.Conn, b.String())
+	return err
+}
+
+// read parses one RESP reply. Error replies are returned as errors.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected Redis reply %q", line)
+}
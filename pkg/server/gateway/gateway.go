@@ -0,0 +1,367 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/clientip"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"nhooyr.io/websocket"
+)
+
+// PathPrefix is where the gateway serves methods, as
+// POST /api/<package.Service>/<Method>, and server-streaming methods as
+// WebSockets opened on the same path
+const PathPrefix = "/api/"
+
+// maxBodySize bounds request bodies, matching the gRPC receive limit default
+const maxBodySize = 16 * 1024 * 1024
+
+// requestTimeout bounds how long a WebSocket may take to send the request of
+// the stream it opened
+const requestTimeout = 10 * time.Second
+
+// closeCodeBase is added to the gRPC code a stream failed with to give the
+// code its WebSocket closes with, in the range RFC 6455 leaves to
+// applications
+const closeCodeBase = 4000
+
+// forwardedHeaders are copied from HTTP requests into incoming gRPC metadata
+var forwardedHeaders = []string{"x-api-key", "authorization", clientip.ForwardedFor, clientip.RealIP}
+
+var (
+	marshalOptions   = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+	unmarshalOptions = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+// Gateway exposes unary gRPC methods as JSON over HTTP, and server-streaming
+// methods as JSON over WebSockets. Services are registered on it exactly as
+// on a grpc.Server and called in-process.
+type Gateway struct {
+	interceptor       grpc.UnaryServerInterceptor
+	streamInterceptor grpc.StreamServerInterceptor
+
+	mu       sync.RWMutex
+	methods  map[string]*method // Keyed by "package.Service/Method"
+	streams  map[string]*stream // Keyed by "package.Service/Method"
+	routes   map[string]string  // Paths served by a unary method, keyed by path
+	services []protoreflect.ServiceDescriptor
+}
+
+type method struct {
+	handler grpc.MethodHandler
+	impl    interface{}
+}
+
+type stream struct {
+	handler grpc.StreamHandler
+	impl    interface{}
+}
+
+// New returns a gateway that calls methods through the interceptors, as the
+// gRPC server does; nil calls them directly
+func New(interceptor grpc.UnaryServerInterceptor, streamInterceptor grpc.StreamServerInterceptor) *Gateway {
+	return &Gateway{
+		interceptor:       interceptor,
+		streamInterceptor: streamInterceptor,
+		methods:           make(map[string]*method),
+		streams:           make(map[string]*stream),
+		routes:            make(map[string]string),
+	}
+}
+
+// Route serves a unary method, named "package.Service/Method", on a path
+// under PathPrefix besides its own, for callers that want a stable short path
+func (g *Gateway) Route(path, name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.routes[path] = name
+}
+
+// Routes returns the paths added with Route and the methods they serve
+func (g *Gateway) Routes() map[string]string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return maps.Clone(g.routes)
+}
+
+// RegisterService implements grpc.ServiceRegistrar so the generated
+// Register...Server functions can register services on the gateway
+func (g *Gateway) RegisterService(sd *grpc.ServiceDesc, impl interface{}) {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(sd.ServiceName))
+	if err != nil {
+		panic(fmt.Sprintf("gateway: unknown service %s: %v", sd.ServiceName, err))
+	}
+	service := d.(protoreflect.ServiceDescriptor)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.services = append(g.services, service)
+	sort.Slice(g.services, func(i, j int) bool { return g.services[i].FullName() < g.services[j].FullName() })
+
+	for i := range sd.Methods {
+		md := &sd.Methods[i]
+		g.methods[sd.ServiceName+"/"+md.MethodName] = &method{
+			handler: md.Handler,
+			impl:    impl,
+		}
+	}
+	// Methods that stream requests take more than the one message a
+	// WebSocket opens with and are not exposed
+	for i := range sd.Streams {
+		desc := &sd.Streams[i]
+		if desc.ServerStreams && !desc.ClientStreams {
+			g.streams[sd.ServiceName+"/"+desc.StreamName] = &stream{
+				handler: desc.Handler,
+				impl:    impl,
+			}
+		}
+	}
+}
+
+// Services returns the registered service descriptors in name order
+func (g *Gateway) Services() []protoreflect.ServiceDescriptor {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]protoreflect.ServiceDescriptor(nil), g.services...)
+}
+
+// exposed reports whether a method is served by the gateway
+func (g *Gateway) exposed(service, name string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.methods[service+"/"+name]
+	return ok
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, PathPrefix)
+	g.mu.RLock()
+	if routed, ok := g.routes[r.URL.Path]; ok {
+		name = routed
+	}
+	m, ok := g.methods[name]
+	st, streaming := g.streams[name]
+	g.mu.RUnlock()
+	if streaming {
+		g.serveStream(w, r, name, st)
+		return
+	}
+	if !ok {
+		writeError(w, status.Errorf(codes.NotFound, "unknown method %s", name))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "method must be called with POST"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		code := codes.InvalidArgument
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			code = codes.ResourceExhausted
+		}
+		writeError(w, status.Errorf(code, "failed to read request body: %v", err))
+		return
+	}
+
+	ctx := incomingContext(r)
+	dec := func(v interface{}) error {
+		if len(body) == 0 {
+			return nil
+		}
+		if err := unmarshalOptions.Unmarshal(body, v.(proto.Message)); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
+		}
+		return nil
+	}
+
+	resp, err := m.handler(m.impl, ctx, dec, g.interceptor)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	data, err := marshalOptions.Marshal(resp.(proto.Message))
+	if err != nil {
+		writeError(w, apierror.Wrap(err, "failed to encode response"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// serveStream runs a server-streaming method over a WebSocket. The client
+// sends the request as its first message, as JSON, and receives each response
+// as a JSON text message. The socket closes normally when the stream ends, or
+// with 4000 plus the gRPC code and the error message when it fails.
+func (g *Gateway) serveStream(w http.ResponseWriter, r *http.Request, name string, st *stream) {
+	if r.Method != http.MethodGet || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		w.Header().Set("Allow", http.MethodGet)
+		writeStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "streaming method must be opened as a WebSocket"))
+		return
+	}
+
+	// The server's timeouts are meant for requests, not for streams that stay
+	// open
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	// Callers authenticate with headers rather than cookies, so any origin
+	// may connect, as with gRPC-Web
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+	conn.SetReadLimit(maxBodySize)
+
+	ctx := incomingContext(r)
+	readCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	_, body, err := conn.Read(readCtx)
+	cancel()
+	if err != nil {
+		closeStream(conn, status.Errorf(codes.InvalidArgument, "failed to read request: %v", err))
+		return
+	}
+	// Later messages are not expected; reading on discards them and ends ctx
+	// when the client goes away
+	ctx = conn.CloseRead(ctx)
+
+	ss := &wsStream{ctx: ctx, conn: conn, body: body}
+	info := &grpc.StreamServerInfo{FullMethod: "/" + name, IsServerStream: true}
+	if g.streamInterceptor != nil {
+		err = g.streamInterceptor(st.impl, ss, info, st.handler)
+	} else {
+		err = st.handler(st.impl, ss)
+	}
+	closeStream(conn, err)
+}
+
+// closeStream closes a WebSocket with the outcome of its stream
+func closeStream(conn *websocket.Conn, err error) {
+	if err == nil {
+		conn.Close(websocket.StatusNormalClosure, "")
+		return
+	}
+	st, _ := status.FromError(err)
+	reason := st.Message()
+	// Close frames have room for 123 bytes of reason
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	conn.Close(websocket.StatusCode(closeCodeBase+int(st.Code())), reason)
+}
+
+// wsStream is the grpc.ServerStream of a method served over a WebSocket
+type wsStream struct {
+	ctx  context.Context
+	conn *websocket.Conn
+	body []byte // The request, until received
+	read bool
+}
+
+func (s *wsStream) Context() context.Context     { return s.ctx }
+func (s *wsStream) SetHeader(metadata.MD) error  { return nil }
+func (s *wsStream) SendHeader(metadata.MD) error { return nil }
+func (s *wsStream) SetTrailer(metadata.MD)       {}
+
+func (s *wsStream) SendMsg(m interface{}) error {
+	data, err := marshalOptions.Marshal(m.(proto.Message))
+	if err != nil {
+		return apierror.Wrap(err, "failed to encode response")
+	}
+	return s.conn.Write(s.ctx, websocket.MessageText, data)
+}
+
+func (s *wsStream) RecvMsg(m interface{}) error {
+	if s.read {
+		return io.EOF
+	}
+	s.read = true
+	if len(s.body) == 0 {
+		return nil
+	}
+	if err := unmarshalOptions.Unmarshal(s.body, m.(proto.Message)); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+	return nil
+}
+
+// incomingContext carries forwarded headers and the caller address into the
+// context the way a gRPC server would
+func incomingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, key := range forwardedHeaders {
+		if values := r.Header.Values(key); len(values) > 0 {
+			md.Set(key, values...)
+		}
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	return ctx
+}
+
+// httpStatus maps gRPC codes to HTTP statuses as grpc-gateway does
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// writeError writes a gRPC error as {"code": ..., "message": ...}
+func writeError(w http.ResponseWriter, err error) {
+	st, _ := status.FromError(err)
+
+	code, ok := httpStatus[st.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	writeStatus(w, code, st)
+}
+
+func writeStatus(w http.ResponseWriter, code int, st *status.Status) {
+	data, _ := marshalOptions.Marshal(st.Proto())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
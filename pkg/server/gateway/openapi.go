@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+//go:embed swagger.html
+var swaggerHTML []byte
+
+// OpenAPI returns an OpenAPI 3 document describing the methods the gateway
+// serves, derived from the registered services' protobuf descriptors
+func (g *Gateway) OpenAPI(title, version string) ([]byte, error) {
+	b := &schemaBuilder{schemas: make(map[string]interface{})}
+	errorRef := b.message((&spb.Status{}).ProtoReflect().Descriptor())
+
+	paths := make(map[string]interface{})
+	var tags []interface{}
+	exposed := make(map[string]protoreflect.MethodDescriptor)
+	for _, service := range g.Services() {
+		tags = append(tags, map[string]interface{}{"name": string(service.Name())})
+
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			m := methods.Get(i)
+			name := string(service.FullName()) + "/" + string(m.Name())
+			if !g.exposed(string(service.FullName()), string(m.Name())) {
+				continue
+			}
+			exposed[name] = m
+			paths[PathPrefix+name] = b.operation(m, string(service.Name())+"_"+string(m.Name()), errorRef)
+		}
+	}
+	for path, name := range g.Routes() {
+		if m, ok := exposed[name]; ok {
+			id := string(m.Parent().Name()) + "_" + string(m.Name()) + "_" + strings.ReplaceAll(strings.TrimPrefix(path, PathPrefix), "/", "_")
+			paths[path] = b.operation(m, id, errorRef)
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       title,
+			"version":     version,
+			"description": "JSON gateway to the Jacuzzi gRPC API. Every unary RPC is served as POST " + PathPrefix + "<package.Service>/<Method> with the request message as the JSON body. Server-streaming RPCs are served as WebSockets opened with GET on the same path: send the request message as the first JSON text message, and each response arrives as one. Other streaming RPCs are only available over gRPC and gRPC-Web. Some unary RPCs are also served on shorter paths, listed with them.",
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "x-api-key"},
+			},
+		},
+		// The API key is only required for clients enrolled with a token
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"apiKey": []string{}},
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// OpenAPIHandler serves the OpenAPI document. It is generated on first use,
+// so all services must be registered before serving.
+func (g *Gateway) OpenAPIHandler(title, version string) http.Handler {
+	var once sync.Once
+	var doc []byte
+	var err error
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { doc, err = g.OpenAPI(title, version) })
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+}
+
+// SwaggerUIHandler serves a Swagger UI page for the document at /openapi.json
+func SwaggerUIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(swaggerHTML)
+	})
+}
+
+// operation describes calling a method with POST
+func (b *schemaBuilder) operation(m protoreflect.MethodDescriptor, id string, errorRef interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"post": map[string]interface{}{
+			"operationId": id,
+			"tags":        []string{string(m.Parent().Name())},
+			"requestBody": map[string]interface{}{
+				"content": jsonContent(b.message(m.Input())),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content":     jsonContent(b.message(m.Output())),
+				},
+				"default": map[string]interface{}{
+					"description": "gRPC status of a failed call",
+					"content":     jsonContent(errorRef),
+				},
+			},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaBuilder converts message descriptors to JSON schemas matching the
+// gateway's protojson encoding, collecting named schemas as it goes
+type schemaBuilder struct {
+	schemas map[string]interface{}
+}
+
+// wellKnown maps well-known types to the schema of their JSON form
+var wellKnown = map[protoreflect.FullName]map[string]interface{}{
+	"google.protobuf.Timestamp":   {"type": "string", "format": "date-time"},
+	"google.protobuf.Duration":    {"type": "string", "example": "1.5s"},
+	"google.protobuf.FieldMask":   {"type": "string"},
+	"google.protobuf.Empty":       {"type": "object"},
+	"google.protobuf.Struct":      {"type": "object"},
+	"google.protobuf.Any":         {"type": "object"},
+	"google.protobuf.Value":       {},
+	"google.protobuf.ListValue":   {"type": "array", "items": map[string]interface{}{}},
+	"google.protobuf.StringValue": {"type": "string"},
+	"google.protobuf.BoolValue":   {"type": "boolean"},
+	"google.protobuf.BytesValue":  {"type": "string", "format": "byte"},
+	"google.protobuf.Int32Value":  {"type": "integer", "format": "int32"},
+	"google.protobuf.UInt32Value": {"type": "integer", "minimum": 0},
+	"google.protobuf.Int64Value":  {"type": "string", "format": "int64"},
+	"google.protobuf.UInt64Value": {"type": "string", "format": "uint64"},
+	"google.protobuf.FloatValue":  {"type": "number", "format": "float"},
+	"google.protobuf.DoubleValue": {"type": "number", "format": "double"},
+}
+
+// message returns a schema for a message, referencing a named component
+func (b *schemaBuilder) message(md protoreflect.MessageDescriptor) interface{} {
+	if schema, ok := wellKnown[md.FullName()]; ok {
+		return schema
+	}
+
+	name := string(md.FullName())
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	if _, ok := b.schemas[name]; ok {
+		return ref
+	}
+
+	// Register before walking fields so recursive messages terminate
+	properties := make(map[string]interface{})
+	b.schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		properties[string(fd.Name())] = b.field(fd)
+	}
+	return ref
+}
+
+func (b *schemaBuilder) field(fd protoreflect.FieldDescriptor) interface{} {
+	switch {
+	case fd.IsMap():
+		return map[string]interface{}{"type": "object", "additionalProperties": b.value(fd.MapValue())}
+	case fd.IsList():
+		return map[string]interface{}{"type": "array", "items": b.value(fd)}
+	}
+	return b.value(fd)
+}
+
+// value returns the schema for a single value of a field
+func (b *schemaBuilder) value(fd protoreflect.FieldDescriptor) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]interface{}{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case protoreflect.StringKind:
+		return map[string]interface{}{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, values.Len())
+		for i := range names {
+			names[i] = string(values.Get(i).Name())
+		}
+		return map[string]interface{}{"type": "string", "enum": names}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return b.message(fd.Message())
+	}
+	return map[string]interface{}{}
+}
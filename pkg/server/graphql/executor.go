@@ -0,0 +1,786 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxDepth bounds selection nesting so a single query cannot fan out without limit
+const maxDepth = 10
+
+// Schema is a set of object types rooted at Query. Only queries are
+// supported; the schema is read-only.
+type Schema struct {
+	Query *Object
+	types map[string]*Object
+}
+
+// Object is an output type with resolvable fields
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+// Field is a field of an object type. Type is written in SDL notation, for
+// example "[Reading!]!"; named types are scalars or other objects in the schema.
+type Field struct {
+	Name        string
+	Type        string
+	Description string
+	Args        []*Arg
+	Resolve     ResolveFunc // Nil reads the value from a map source by name
+}
+
+// Arg is a field argument; Type is an input scalar in SDL notation
+type Arg struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// ResolveFunc produces a field value from its parent value and coerced
+// arguments. Missing nullable arguments are absent from args.
+type ResolveFunc func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// Built-in scalars. Time is an RFC 3339 string.
+var scalars = map[string]string{
+	"String":  "UTF-8 text.",
+	"Int":     "Signed 32-bit integer.",
+	"Float":   "Double-precision floating point number.",
+	"Boolean": "true or false.",
+	"ID":      "Unique identifier, serialized as a string.",
+	"Time":    "Timestamp in RFC 3339 format.",
+}
+
+// NewSchema builds a schema from the query root and the other object types
+// its fields refer to by name
+func NewSchema(query *Object, types ...*Object) (*Schema, error) {
+	s := &Schema{Query: query, types: make(map[string]*Object)}
+	for _, obj := range append([]*Object{query}, types...) {
+		if _, ok := s.types[obj.Name]; ok {
+			return nil, fmt.Errorf("duplicate type %s", obj.Name)
+		}
+		if _, ok := scalars[obj.Name]; ok {
+			return nil, fmt.Errorf("type %s conflicts with a scalar", obj.Name)
+		}
+		s.types[obj.Name] = obj
+	}
+
+	for _, obj := range s.types {
+		for _, f := range obj.Fields {
+			name := namedType(f.Type)
+			if _, ok := scalars[name]; !ok && s.types[name] == nil {
+				return nil, fmt.Errorf("field %s.%s has unknown type %s", obj.Name, f.Name, name)
+			}
+			for _, arg := range f.Args {
+				if _, ok := scalars[namedType(arg.Type)]; !ok {
+					return nil, fmt.Errorf("argument %s.%s(%s) must be a scalar", obj.Name, f.Name, arg.Name)
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is a GraphQL result. Data is omitted when the request failed
+// before execution.
+type Response struct {
+	Data   *orderedMap `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error with the location and result path it applies to
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Execute parses and runs a query
+func (s *Schema) Execute(ctx context.Context, req *Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return &Response{Errors: []*Error{{
+				Message:   "Syntax Error: " + syntaxErr.Message,
+				Locations: []Location{{Line: syntaxErr.Line, Column: syntaxErr.Col}},
+			}}}
+		}
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	if op.kind != "query" {
+		return &Response{Errors: []*Error{{Message: fmt.Sprintf("%s operations are not supported", op.kind)}}}
+	}
+
+	vars, err := coerceVariables(op, req.Variables)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	e := &executor{schema: s, doc: doc, vars: vars}
+	if err := e.validate(s.Query, op.selection, 1, map[string]bool{}); err != nil {
+		return &Response{Errors: []*Error{err}}
+	}
+
+	data, err := e.selectionSet(ctx, s.Query, nil, op.selection, nil)
+	resp := &Response{Data: data, Errors: e.errors}
+	if err != nil {
+		resp.Data = nil
+	}
+	return resp
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, errors.New("operationName is required when the document contains multiple operations")
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// coerceVariables applies defaults and checks provided values against the
+// declared variable types
+func coerceVariables(op *operation, provided map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, def := range op.vars {
+		if _, ok := scalars[namedType(def.typ)]; !ok {
+			return nil, fmt.Errorf("variable $%s has unsupported type %s", def.name, def.typ)
+		}
+		value, ok := provided[def.name]
+		if !ok {
+			if !def.has {
+				if strings.HasSuffix(def.typ, "!") {
+					return nil, fmt.Errorf("variable $%s of required type %s was not provided", def.name, def.typ)
+				}
+				continue
+			}
+			value = def.def
+		}
+		coerced, err := coerceInput(def.typ, value)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %v", def.name, err)
+		}
+		vars[def.name] = coerced
+	}
+	return vars, nil
+}
+
+type executor struct {
+	schema *Schema
+	doc    *document
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// errNull signals that a non-null field resolved to null, after its error
+// was recorded, so the nearest nullable parent becomes null
+var errNull = errors.New("null in non-null position")
+
+// validate checks field names, arguments, and selection shapes before
+// anything is resolved
+func (e *executor) validate(obj *Object, sels []selection, depth int, visiting map[string]bool) *Error {
+	if depth > maxDepth {
+		return &Error{Message: fmt.Sprintf("query exceeds the maximum depth of %d", maxDepth)}
+	}
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *field:
+			loc := []Location{{Line: sel.line, Column: sel.col}}
+			if sel.name == "__typename" {
+				continue
+			}
+			if strings.HasPrefix(sel.name, "__") {
+				return &Error{Message: "introspection is not supported; fetch the schema from /graphql/schema", Locations: loc}
+			}
+			f := obj.field(sel.name)
+			if f == nil {
+				return &Error{Message: fmt.Sprintf("Cannot query field %q on type %q.", sel.name, obj.Name), Locations: loc}
+			}
+			for _, arg := range sel.args {
+				if f.arg(arg.name) == nil {
+					return &Error{Message: fmt.Sprintf("Unknown argument %q on field %q.", arg.name, obj.Name+"."+f.Name), Locations: loc}
+				}
+			}
+			child := e.schema.types[namedType(f.Type)]
+			switch {
+			case child == nil && sel.selection != nil:
+				return &Error{Message: fmt.Sprintf("Field %q must not have a selection since type %q has no subfields.", sel.name, f.Type), Locations: loc}
+			case child != nil && sel.selection == nil:
+				return &Error{Message: fmt.Sprintf("Field %q of type %q must have a selection of subfields.", sel.name, f.Type), Locations: loc}
+			case child != nil:
+				if err := e.validate(child, sel.selection, depth+1, visiting); err != nil {
+					return err
+				}
+			}
+		case *fragmentSpread:
+			frag, ok := e.doc.fragments[sel.name]
+			if !ok {
+				return &Error{Message: fmt.Sprintf("Unknown fragment %q.", sel.name)}
+			}
+			if visiting[sel.name] {
+				return &Error{Message: fmt.Sprintf("Cannot spread fragment %q within itself.", sel.name)}
+			}
+			if err := e.checkTypeCondition(frag.typeCond); err != nil {
+				return err
+			}
+			if frag.typeCond != obj.Name {
+				continue
+			}
+			visiting[sel.name] = true
+			err := e.validate(obj, frag.selection, depth, visiting)
+			delete(visiting, sel.name)
+			if err != nil {
+				return err
+			}
+		case *inlineFragment:
+			if sel.typeCond != "" {
+				if err := e.checkTypeCondition(sel.typeCond); err != nil {
+					return err
+				}
+				if sel.typeCond != obj.Name {
+					continue
+				}
+			}
+			if err := e.validate(obj, sel.selection, depth, visiting); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *executor) checkTypeCondition(name string) *Error {
+	if e.schema.types[name] == nil {
+		return &Error{Message: fmt.Sprintf("Unknown type %q.", name)}
+	}
+	return nil
+}
+
+func (f *Field) arg(name string) *Arg {
+	for _, a := range f.Args {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// collectFields flattens fragments and applies @skip and @include, grouping
+// fields by response key in query order
+func (e *executor) collectFields(obj *Object, sels []selection, keys *[]string, groups map[string][]*field) error {
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *field:
+			ok, err := e.included(sel.directives)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			key := sel.responseKey()
+			if _, seen := groups[key]; !seen {
+				*keys = append(*keys, key)
+			}
+			groups[key] = append(groups[key], sel)
+		case *fragmentSpread:
+			frag := e.doc.fragments[sel.name]
+			if ok, err := e.included(sel.directives); err != nil || !ok || frag.typeCond != obj.Name {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := e.collectFields(obj, frag.selection, keys, groups); err != nil {
+				return err
+			}
+		case *inlineFragment:
+			if ok, err := e.included(sel.directives); err != nil || !ok || (sel.typeCond != "" && sel.typeCond != obj.Name) {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := e.collectFields(obj, sel.selection, keys, groups); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// included evaluates @skip(if:) and @include(if:)
+func (e *executor) included(dirs []*directive) (bool, error) {
+	for _, dir := range dirs {
+		if dir.name != "skip" && dir.name != "include" {
+			return false, fmt.Errorf("unknown directive @%s", dir.name)
+		}
+		if len(dir.args) != 1 || dir.args[0].name != "if" {
+			return false, fmt.Errorf("directive @%s requires a single \"if\" argument", dir.name)
+		}
+		value, err := e.argValue(dir.args[0].value, "Boolean!")
+		if err != nil {
+			return false, fmt.Errorf("directive @%s: %v", dir.name, err)
+		}
+		if value.(bool) == (dir.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// selectionSet resolves the selected fields of an object value
+func (e *executor) selectionSet(ctx context.Context, obj *Object, source interface{}, sels []selection, path []interface{}) (*orderedMap, error) {
+	var keys []string
+	groups := make(map[string][]*field)
+	if err := e.collectFields(obj, sels, &keys, groups); err != nil {
+		e.errors = append(e.errors, &Error{Message: err.Error(), Path: path})
+		return nil, errNull
+	}
+
+	result := &orderedMap{}
+	for _, key := range keys {
+		fields := groups[key]
+		sel := fields[0]
+		fieldPath := appendPath(path, key)
+
+		if sel.name == "__typename" {
+			result.set(key, obj.Name)
+			continue
+		}
+
+		f := obj.field(sel.name)
+		value, err := e.resolveField(ctx, f, source, fields, fieldPath)
+		if err != nil {
+			if strings.HasSuffix(f.Type, "!") {
+				return nil, errNull
+			}
+			value = nil
+		}
+		result.set(key, value)
+	}
+	return result, nil
+}
+
+func (e *executor) resolveField(ctx context.Context, f *Field, source interface{}, fields []*field, path []interface{}) (interface{}, error) {
+	sel := fields[0]
+	fail := func(err error) (interface{}, error) {
+		e.errors = append(e.errors, &Error{
+			Message:   err.Error(),
+			Locations: []Location{{Line: sel.line, Column: sel.col}},
+			Path:      path,
+		})
+		return nil, errNull
+	}
+
+	args, err := e.coerceArgs(f, sel.args)
+	if err != nil {
+		return fail(err)
+	}
+
+	var value interface{}
+	if f.Resolve != nil {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		value, err = f.Resolve(ctx, source, args)
+		if err != nil {
+			return fail(err)
+		}
+	} else if m, ok := source.(map[string]interface{}); ok {
+		value = m[f.Name]
+	}
+
+	// Merge sub-selections of fields that share a response key
+	var subSels []selection
+	for _, fld := range fields {
+		subSels = append(subSels, fld.selection...)
+	}
+	return e.complete(ctx, f.Type, value, subSels, path, fail)
+}
+
+// complete converts a resolved value to its result form according to typ
+func (e *executor) complete(ctx context.Context, typ string, value interface{}, sels []selection, path []interface{}, fail func(error) (interface{}, error)) (interface{}, error) {
+	if inner, ok := strings.CutSuffix(typ, "!"); ok {
+		result, err := e.complete(ctx, inner, value, sels, path, fail)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			return fail(fmt.Errorf("cannot return null for non-nullable field"))
+		}
+		return result, nil
+	}
+
+	if isNil(value) {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		itemType := typ[1 : len(typ)-1]
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fail(fmt.Errorf("expected a list, got %T", value))
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			itemPath := appendPath(path, i)
+			itemFail := func(err error) (interface{}, error) {
+				e.errors = append(e.errors, &Error{Message: err.Error(), Path: itemPath})
+				return nil, errNull
+			}
+			item, err := e.complete(ctx, itemType, rv.Index(i).Interface(), sels, itemPath, itemFail)
+			if err != nil {
+				if strings.HasSuffix(itemType, "!") {
+					return nil, errNull
+				}
+				item = nil
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+
+	if obj, ok := e.schema.types[typ]; ok {
+		result, err := e.selectionSet(ctx, obj, value, sels, path)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	result, err := serializeScalar(typ, value)
+	if err != nil {
+		return fail(err)
+	}
+	return result, nil
+}
+
+func serializeScalar(typ string, value interface{}) (interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	switch typ {
+	case "String", "ID":
+		switch rv.Kind() {
+		case reflect.String:
+			return rv.String(), nil
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+			if typ == "ID" {
+				return fmt.Sprint(rv.Interface()), nil
+			}
+		}
+	case "Int":
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if n := rv.Int(); n >= math.MinInt32 && n <= math.MaxInt32 {
+				return n, nil
+			}
+			return nil, fmt.Errorf("Int cannot represent %d", rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n := rv.Uint(); n <= math.MaxInt32 {
+				return int64(n), nil
+			}
+			return nil, fmt.Errorf("Int cannot represent %d", rv.Uint())
+		}
+	case "Float":
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f := rv.Float()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("Float cannot represent %v", f)
+			}
+			return f, nil
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		}
+	case "Boolean":
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case "Time":
+		if t, ok := rv.Interface().(time.Time); ok {
+			if t.IsZero() {
+				return nil, nil
+			}
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+	}
+	return nil, fmt.Errorf("%s cannot represent %T", typ, value)
+}
+
+// coerceArgs resolves variables and converts argument values to Go types
+func (e *executor) coerceArgs(f *Field, args []*argument) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, def := range f.Args {
+		var arg *argument
+		for _, a := range args {
+			if a.name == def.Name {
+				arg = a
+			}
+		}
+		if arg == nil {
+			if strings.HasSuffix(def.Type, "!") {
+				return nil, fmt.Errorf("argument %q of type %q is required", def.Name, def.Type)
+			}
+			continue
+		}
+		if name, ok := arg.value.(variable); ok {
+			if _, provided := e.vars[string(name)]; !provided && !strings.HasSuffix(def.Type, "!") {
+				continue
+			}
+		}
+		value, err := e.argValue(arg.value, def.Type)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %v", def.Name, err)
+		}
+		if value != nil {
+			result[def.Name] = value
+		}
+	}
+	return result, nil
+}
+
+func (e *executor) argValue(literal interface{}, typ string) (interface{}, error) {
+	if name, ok := literal.(variable); ok {
+		value, provided := e.vars[string(name)]
+		if !provided && strings.HasSuffix(typ, "!") {
+			return nil, fmt.Errorf("variable $%s is not provided", name)
+		}
+		return coerceInput(typ, value)
+	}
+	return coerceInput(typ, literal)
+}
+
+// coerceInput converts a literal or JSON variable value to the Go type of an
+// input scalar: string, int64, float64, bool, or time.Time
+func coerceInput(typ string, value interface{}) (interface{}, error) {
+	if inner, ok := strings.CutSuffix(typ, "!"); ok {
+		if value == nil {
+			return nil, fmt.Errorf("expected non-null %s", inner)
+		}
+		return coerceInput(inner, value)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	if strings.HasPrefix(typ, "[") {
+		itemType := typ[1 : len(typ)-1]
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			coerced, err := coerceInput(itemType, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = coerced
+		}
+		return items, nil
+	}
+
+	switch typ {
+	case "String", "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if n, ok := value.(int64); ok && typ == "ID" {
+			return fmt.Sprint(n), nil
+		}
+	case "Int":
+		switch v := value.(type) {
+		case int64:
+			if v >= math.MinInt32 && v <= math.MaxInt32 {
+				return v, nil
+			}
+		case float64: // JSON variables
+			if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int64(v), nil
+			}
+		}
+	case "Float":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "Time":
+		if s, ok := value.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, fmt.Errorf("Time must be RFC 3339, got %q", s)
+			}
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%s cannot represent %s", typ, describeValue(value))
+}
+
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case enumValue:
+		return string(v)
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(value)
+}
+
+// namedType strips list and non-null wrappers from an SDL type
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	return append(append([]interface{}(nil), path...), elem)
+}
+
+// orderedMap is a JSON object that keeps fields in selection order, as the
+// GraphQL specification requires for results
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// SDL renders the schema in GraphQL schema definition language
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	b.WriteString("schema {\n  query: " + s.Query.Name + "\n}\n")
+
+	custom := []string{"Time"}
+	for _, name := range custom {
+		fmt.Fprintf(&b, "\n\"%s\"\nscalar %s\n", scalars[name], name)
+	}
+
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		if name != s.Query.Name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{s.Query.Name}, names...)
+
+	for _, name := range names {
+		obj := s.types[name]
+		b.WriteString("\n")
+		writeDescription(&b, "", obj.Description)
+		fmt.Fprintf(&b, "type %s {\n", obj.Name)
+		for _, f := range obj.Fields {
+			writeDescription(&b, "  ", f.Description)
+			b.WriteString("  " + f.Name)
+			if len(f.Args) > 0 {
+				// Arguments go on their own lines so descriptions stay readable
+				b.WriteString("(\n")
+				for _, arg := range f.Args {
+					writeDescription(&b, "    ", arg.Description)
+					b.WriteString("    " + arg.Name + ": " + arg.Type + "\n")
+				}
+				b.WriteString("  )")
+			}
+			b.WriteString(": " + f.Type + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+func writeDescription(b *strings.Builder, indent, desc string) {
+	if desc != "" {
+		fmt.Fprintf(b, "%s\"%s\"\n", indent, strings.ReplaceAll(desc, `"`, `\"`))
+	}
+}
@@ -0,0 +1,458 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNode is the source value of Node fields
+type testNode struct {
+	id    string
+	value float64
+	child *testNode
+}
+
+// testSchema builds a small schema exercising each kind of field and argument
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	node := &Object{Name: "Node"}
+	node.Fields = []*Field{
+		{Name: "id", Type: "ID!", Resolve: func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(*testNode).id, nil
+		}},
+		{Name: "value", Type: "Float", Resolve: func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(*testNode).value, nil
+		}},
+		{Name: "child", Type: "Node", Resolve: func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(*testNode).child, nil
+		}},
+		{Name: "broken", Type: "String!", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("broken field")
+		}},
+	}
+
+	// A chain deeper than maxDepth
+	deep := &testNode{id: "n0"}
+	for i, n := 1, deep; i <= maxDepth+1; i, n = i+1, n.child {
+		n.child = &testNode{id: "n" + strconv.Itoa(i)}
+	}
+
+	echo := func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+		out, err := json.Marshal(args)
+		return string(out), err
+	}
+	query := &Object{Name: "Query", Fields: []*Field{
+		{Name: "hello", Type: "String!", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return "world", nil
+		}},
+		{Name: "echo", Type: "String", Resolve: echo, Args: []*Arg{
+			{Name: "s", Type: "String"},
+			{Name: "id", Type: "ID"},
+			{Name: "n", Type: "Int"},
+			{Name: "f", Type: "Float"},
+			{Name: "b", Type: "Boolean"},
+			{Name: "t", Type: "Time"},
+			{Name: "list", Type: "[Int!]"},
+		}},
+		{Name: "required", Type: "String", Resolve: echo, Args: []*Arg{{Name: "n", Type: "Int!"}}},
+		{Name: "node", Type: "Node", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return deep, nil
+		}},
+		{Name: "nodes", Type: "[Node!]", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return []*testNode{{id: "a", value: 1.5}, {id: "b", value: math.NaN()}}, nil
+		}},
+		{Name: "missing", Type: "Node", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		}},
+		{Name: "fail", Type: "String", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("resolver failed")
+		}},
+		{Name: "count", Type: "Int", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return int64(math.MaxInt32) + 1, nil
+		}},
+		{Name: "when", Type: "Time", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600)), nil
+		}},
+		{Name: "map", Type: "String"}, // Read from a map source; the root has none
+	}}
+
+	schema, err := NewSchema(query, node)
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+	return schema
+}
+
+// execute runs a query and returns its response as JSON
+func execute(t *testing.T, schema *Schema, req *Request) string {
+	t.Helper()
+	out, err := json.Marshal(schema.Execute(context.Background(), req))
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	return string(out)
+}
+
+func TestExecute(t *testing.T) {
+	schema := testSchema(t)
+	tests := []struct {
+		name  string
+		query string
+		vars  string
+		op    string
+		want  string
+	}{
+		{
+			name:  "fields in selection order",
+			query: `{ node { value id } hello }`,
+			want:  `{"data":{"node":{"value":0,"id":"n0"},"hello":"world"}}`,
+		},
+		{
+			name:  "aliases and typename",
+			query: `{ a: hello b: hello __typename node { __typename } }`,
+			want:  `{"data":{"a":"world","b":"world","__typename":"Query","node":{"__typename":"Node"}}}`,
+		},
+		{
+			name:  "merged fields",
+			query: `{ node { id } node { child { id } } }`,
+			want:  `{"data":{"node":{"id":"n0","child":{"id":"n1"}}}}`,
+		},
+		{
+			name:  "named fragment",
+			query: `{ node { ...F } } fragment F on Node { id child { ...G } } fragment G on Node { id }`,
+			want:  `{"data":{"node":{"id":"n0","child":{"id":"n1"}}}}`,
+		},
+		{
+			name:  "inline fragments",
+			query: `{ node { ... on Node { id } ... { value } ... on Query { hello } } }`,
+			want:  `{"data":{"node":{"id":"n0","value":0}}}`,
+		},
+		{
+			name:  "skip and include",
+			query: `query ($yes: Boolean!) { a: hello @skip(if: true) b: hello @include(if: $yes) c: hello @include(if: false) ... @skip(if: $yes) { d: hello } }`,
+			vars:  `{"yes": true}`,
+			want:  `{"data":{"b":"world"}}`,
+		},
+		{
+			name:  "operation name",
+			query: `query A { a: hello } query B { b: hello }`,
+			op:    "B",
+			want:  `{"data":{"b":"world"}}`,
+		},
+		{
+			name:  "null object",
+			query: `{ missing { id } }`,
+			want:  `{"data":{"missing":null}}`,
+		},
+		{
+			name:  "time serialized in UTC",
+			query: `{ when }`,
+			want:  `{"data":{"when":"2024-05-01T10:00:00Z"}}`,
+		},
+		{
+			name:  "map source without resolver",
+			query: `{ map }`,
+			want:  `{"data":{"map":null}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Query: tt.query, OperationName: tt.op}
+			if tt.vars != "" {
+				if err := json.Unmarshal([]byte(tt.vars), &req.Variables); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := execute(t, schema, req); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteArguments(t *testing.T) {
+	schema := testSchema(t)
+	tests := []struct {
+		name  string
+		query string
+		vars  string
+		want  string
+	}{
+		{
+			name:  "literals",
+			query: `{ echo(s: "x", id: 7, n: -3, f: 2, b: true, t: "2024-05-01T12:00:00+02:00", list: [1, 2]) }`,
+			want:  `{"b":true,"f":2,"id":"7","list":[1,2],"n":-3,"s":"x","t":"2024-05-01T12:00:00+02:00"}`,
+		},
+		{
+			name:  "single value coerced to list",
+			query: `{ echo(list: 5) }`,
+			want:  `{"list":[5]}`,
+		},
+		{
+			name:  "null literal omitted",
+			query: `{ echo(s: null) }`,
+			want:  `{}`,
+		},
+		{
+			name:  "variables from JSON",
+			query: `query ($n: Int, $f: Float, $l: [Int!]) { echo(n: $n, f: $f, list: $l) }`,
+			vars:  `{"n": 4, "f": 1.25, "l": [1, 2]}`,
+			want:  `{"f":1.25,"list":[1,2],"n":4}`,
+		},
+		{
+			name:  "variable defaults",
+			query: `query ($n: Int = 9, $s: String = "d") { echo(n: $n, s: $s) }`,
+			want:  `{"n":9,"s":"d"}`,
+		},
+		{
+			name:  "omitted nullable variable",
+			query: `query ($n: Int) { echo(n: $n) }`,
+			want:  `{}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Query: tt.query}
+			if tt.vars != "" {
+				if err := json.Unmarshal([]byte(tt.vars), &req.Variables); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp := schema.Execute(context.Background(), req)
+			if len(resp.Errors) > 0 {
+				t.Fatalf("unexpected error %q", resp.Errors[0].Message)
+			}
+			if got := resp.Data.values["echo"]; got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	schema := testSchema(t)
+	tests := []struct {
+		name  string
+		query string
+		vars  string
+		op    string
+		want  string
+	}{
+		{
+			name:  "syntax error",
+			query: `{ hello`,
+			want:  `{"errors":[{"message":"Syntax Error: unexpected end of document","locations":[{"line":1,"column":8}]}]}`,
+		},
+		{
+			name:  "mutation",
+			query: `mutation { hello }`,
+			want:  `{"errors":[{"message":"mutation operations are not supported"}]}`,
+		},
+		{
+			name:  "operation name required",
+			query: `query A { hello } query B { hello }`,
+			want:  `{"errors":[{"message":"operationName is required when the document contains multiple operations"}]}`,
+		},
+		{
+			name:  "unknown operation",
+			query: `query A { hello }`,
+			op:    "B",
+			want:  `{"errors":[{"message":"unknown operation \"B\""}]}`,
+		},
+		{
+			name:  "unknown field",
+			query: `{ node { nope } }`,
+			want:  `{"errors":[{"message":"Cannot query field \"nope\" on type \"Node\".","locations":[{"line":1,"column":10}]}]}`,
+		},
+		{
+			name:  "unknown argument",
+			query: `{ echo(x: 1) }`,
+			want:  `{"errors":[{"message":"Unknown argument \"x\" on field \"Query.echo\".","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "selection on scalar",
+			query: `{ hello { x } }`,
+			want:  `{"errors":[{"message":"Field \"hello\" must not have a selection since type \"String!\" has no subfields.","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "missing selection on object",
+			query: `{ node }`,
+			want:  `{"errors":[{"message":"Field \"node\" of type \"Node\" must have a selection of subfields.","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "introspection",
+			query: `{ __schema { types { name } } }`,
+			want:  `{"errors":[{"message":"introspection is not supported; fetch the schema from /graphql/schema","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "unknown fragment",
+			query: `{ node { ...F } }`,
+			want:  `{"errors":[{"message":"Unknown fragment \"F\"."}]}`,
+		},
+		{
+			name:  "fragment cycle",
+			query: `{ node { ...F } } fragment F on Node { ...G } fragment G on Node { ...F }`,
+			want:  `{"errors":[{"message":"Cannot spread fragment \"F\" within itself."}]}`,
+		},
+		{
+			name:  "unknown type condition",
+			query: `{ node { ... on Nope { id } } }`,
+			want:  `{"errors":[{"message":"Unknown type \"Nope\"."}]}`,
+		},
+		{
+			name:  "unsupported variable type",
+			query: `query ($n: Node) { hello }`,
+			want:  `{"errors":[{"message":"variable $n has unsupported type Node"}]}`,
+		},
+		{
+			name:  "missing required variable",
+			query: `query ($n: Int!) { required(n: $n) }`,
+			want:  `{"errors":[{"message":"variable $n of required type Int! was not provided"}]}`,
+		},
+		{
+			name:  "variable of wrong type",
+			query: `query ($n: Int) { echo(n: $n) }`,
+			vars:  `{"n": 1.5}`,
+			want:  `{"errors":[{"message":"variable $n: Int cannot represent 1.5"}]}`,
+		},
+		{
+			name:  "invalid time variable",
+			query: `query ($t: Time) { echo(t: $t) }`,
+			vars:  `{"t": "yesterday"}`,
+			want:  `{"errors":[{"message":"variable $t: Time must be RFC 3339, got \"yesterday\""}]}`,
+		},
+		{
+			name:  "missing required argument",
+			query: `{ required }`,
+			want:  `{"data":{"required":null},"errors":[{"message":"argument \"n\" of type \"Int!\" is required","locations":[{"line":1,"column":3}],"path":["required"]}]}`,
+		},
+		{
+			name:  "argument out of range",
+			query: `{ echo(n: 2147483648) }`,
+			want:  `{"data":{"echo":null},"errors":[{"message":"argument \"n\": Int cannot represent 2147483648","locations":[{"line":1,"column":3}],"path":["echo"]}]}`,
+		},
+		{
+			name:  "enum value for string",
+			query: `{ echo(s: RED) }`,
+			want:  `{"data":{"echo":null},"errors":[{"message":"argument \"s\": String cannot represent RED","locations":[{"line":1,"column":3}],"path":["echo"]}]}`,
+		},
+		{
+			name:  "unknown directive",
+			query: `{ hello @nope }`,
+			want:  `{"errors":[{"message":"unknown directive @nope"}]}`,
+		},
+		{
+			name:  "directive without if",
+			query: `{ hello @skip }`,
+			want:  `{"errors":[{"message":"directive @skip requires a single \"if\" argument"}]}`,
+		},
+		{
+			name:  "resolver error nulls nullable field",
+			query: `{ fail hello }`,
+			want:  `{"data":{"fail":null,"hello":"world"},"errors":[{"message":"resolver failed","locations":[{"line":1,"column":3}],"path":["fail"]}]}`,
+		},
+		{
+			name:  "non-null error nulls the parent",
+			query: `{ node { id broken } hello }`,
+			want:  `{"data":{"node":null,"hello":"world"},"errors":[{"message":"broken field","locations":[{"line":1,"column":13}],"path":["node","broken"]}]}`,
+		},
+		{
+			name:  "unserializable value nulls nullable field",
+			query: `{ nodes { id value } }`,
+			want:  `{"data":{"nodes":[{"id":"a","value":1.5},{"id":"b","value":null}]},"errors":[{"message":"Float cannot represent NaN","locations":[{"line":1,"column":14}],"path":["nodes",1,"value"]}]}`,
+		},
+		{
+			name:  "non-null list item error nulls the list",
+			query: `{ nodes { id broken } }`,
+			want:  `{"data":{"nodes":null},"errors":[{"message":"broken field","locations":[{"line":1,"column":14}],"path":["nodes",0,"broken"]}]}`,
+		},
+		{
+			name:  "scalar out of range",
+			query: `{ count }`,
+			want:  `{"data":{"count":null},"errors":[{"message":"Int cannot represent 2147483648","locations":[{"line":1,"column":3}],"path":["count"]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Query: tt.query, OperationName: tt.op}
+			if tt.vars != "" {
+				if err := json.Unmarshal([]byte(tt.vars), &req.Variables); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := execute(t, schema, req); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteDepthLimit(t *testing.T) {
+	schema := testSchema(t)
+	nested := func(depth int) string {
+		// node is at depth 1 and each child adds one
+		return "{ node { " + strings.Repeat("child { ", depth-1) + "id" + strings.Repeat(" }", depth) + " }"
+	}
+
+	resp := schema.Execute(context.Background(), &Request{Query: nested(maxDepth - 1)})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("query at the depth limit failed: %s", resp.Errors[0].Message)
+	}
+
+	resp = schema.Execute(context.Background(), &Request{Query: nested(maxDepth)})
+	if resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "maximum depth") {
+		t.Fatalf("got %+v, want a depth error", resp.Errors)
+	}
+
+	// Fragments do not add depth of their own but cannot hide it either
+	frag := `{ node { ...F } } fragment F on Node { child { ` + strings.Repeat("child { ", maxDepth) + "id" + strings.Repeat(" }", maxDepth+1) + ` }`
+	resp = schema.Execute(context.Background(), &Request{Query: frag})
+	if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "maximum depth") {
+		t.Fatalf("got %+v, want a depth error", resp.Errors)
+	}
+}
+
+func TestExecuteCanceled(t *testing.T) {
+	schema := testSchema(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp := schema.Execute(ctx, &Request{Query: `{ fail hello }`})
+	// hello is non-null, so the canceled context nulls the whole result
+	if resp.Data != nil || len(resp.Errors) == 0 || resp.Errors[0].Message != context.Canceled.Error() {
+		t.Fatalf("got %+v, want a canceled error", resp.Errors)
+	}
+}
+
+func TestNewSchemaErrors(t *testing.T) {
+	str := func(name string) *Field { return &Field{Name: name, Type: "String"} }
+	tests := []struct {
+		name  string
+		query *Object
+		types []*Object
+		want  string
+	}{
+		{"duplicate type", &Object{Name: "Query", Fields: []*Field{str("a")}}, []*Object{{Name: "Query"}}, "duplicate type Query"},
+		{"scalar name", &Object{Name: "Query", Fields: []*Field{str("a")}}, []*Object{{Name: "Time"}}, "type Time conflicts with a scalar"},
+		{"unknown type", &Object{Name: "Query", Fields: []*Field{{Name: "a", Type: "[Nope!]"}}}, nil, "field Query.a has unknown type Nope"},
+		{
+			"object argument",
+			&Object{Name: "Query", Fields: []*Field{{Name: "a", Type: "String", Args: []*Arg{{Name: "x", Type: "Obj"}}}}},
+			[]*Object{{Name: "Obj", Fields: []*Field{str("b")}}},
+			"argument Query.a(x) must be a scalar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSchema(tt.query, tt.types...)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
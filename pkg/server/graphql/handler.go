@@ -0,0 +1,97 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Path is where queries are served; the schema is served at Path + "/schema"
+const Path = "/graphql"
+
+// maxQuerySize bounds request bodies
+const maxQuerySize = 1 << 20
+
+// Handler serves GraphQL queries over HTTP, as POST with a JSON body or GET
+// with query, operationName, and variables parameters
+type Handler struct {
+	schema *Schema
+}
+
+func NewHandler(db *gorm.DB) (*Handler, error) {
+	schema, err := newSchema(db)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{schema: schema}, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == Path+"/schema" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, h.schema.SDL())
+		return
+	}
+
+	req := &Request{}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeResponse(w, http.StatusBadRequest, requestError("variables must be a JSON object"))
+				return
+			}
+		}
+	case http.MethodPost:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxQuerySize))
+		if err != nil {
+			code := http.StatusBadRequest
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			writeResponse(w, code, requestError("failed to read request body: "+err.Error()))
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, req); err != nil {
+			writeResponse(w, http.StatusBadRequest, requestError("invalid request body: "+err.Error()))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeResponse(w, http.StatusMethodNotAllowed, requestError("method must be GET or POST"))
+		return
+	}
+
+	if req.Query == "" {
+		writeResponse(w, http.StatusBadRequest, requestError("query is required"))
+		return
+	}
+
+	resp := h.schema.Execute(r.Context(), req)
+	code := http.StatusOK
+	if resp.Data == nil && len(resp.Errors) > 0 {
+		// Nothing was executed, or the whole result was nulled out
+		code = http.StatusBadRequest
+	}
+	writeResponse(w, code, resp)
+}
+
+func requestError(message string) *Response {
+	return &Response{Errors: []*Error{{Message: message}}}
+}
+
+func writeResponse(w http.ResponseWriter, code int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,572 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Query documents are parsed into this small AST. Only the executable parts
+// of the language are supported: operations, fragments, variables, and
+// directives; type system definitions are rejected.
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind      string // query, mutation, or subscription
+	name      string
+	vars      []*varDef
+	selection []selection
+}
+
+type varDef struct {
+	name string
+	typ  string
+	def  interface{} // Default value, or nil
+	has  bool        // Whether a default was given
+}
+
+type fragment struct {
+	name       string
+	typeCond   string
+	directives []*directive
+	selection  []selection
+}
+
+// selection is a *field, *fragmentSpread, or *inlineFragment
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	selection  []selection
+	line, col  int
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+}
+
+type inlineFragment struct {
+	typeCond   string
+	directives []*directive
+	selection  []selection
+}
+
+type argument struct {
+	name  string
+	value interface{}
+}
+
+type directive struct {
+	name string
+	args []*argument
+}
+
+// Literal values are int64, float64, string, bool, nil, enumValue, variable,
+// []interface{}, or map[string]interface{}
+type enumValue string
+type variable string
+
+// responseKey is the alias if given, otherwise the field name
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind      int
+	value     string
+	line, col int
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	col  int
+	tok  token
+}
+
+// SyntaxError reports a query that could not be parsed
+type SyntaxError struct {
+	Message   string
+	Line, Col int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.Line, e.Col, e.Message)
+}
+
+// parse parses a query document
+func parse(src string) (doc *document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = syntaxErr
+		}
+	}()
+
+	p := &parser{src: src, line: 1, col: 1}
+	p.next()
+	doc = &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peekPunct("{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", selection: p.selectionSet()})
+		case p.tok.kind == tokName && p.tok.value == "fragment":
+			frag := p.fragmentDefinition()
+			if _, dup := doc.fragments[frag.name]; dup {
+				p.fail("duplicate fragment %q", frag.name)
+			}
+			doc.fragments[frag.name] = frag
+		case p.tok.kind == tokName && (p.tok.value == "query" || p.tok.value == "mutation" || p.tok.value == "subscription"):
+			doc.operations = append(doc.operations, p.operationDefinition())
+		default:
+			p.fail("unexpected %s", p.describe())
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail("document contains no operations")
+	}
+	return doc, nil
+}
+
+func (p *parser) operationDefinition() *operation {
+	op := &operation{kind: p.tok.value}
+	p.next()
+	if p.tok.kind == tokName {
+		op.name = p.tok.value
+		p.next()
+	}
+	if p.skipPunct("(") {
+		for !p.skipPunct(")") {
+			op.vars = append(op.vars, p.variableDefinition())
+		}
+	}
+	p.directives()
+	op.selection = p.selectionSet()
+	return op
+}
+
+func (p *parser) variableDefinition() *varDef {
+	p.expectPunct("$")
+	v := &varDef{name: p.name()}
+	p.expectPunct(":")
+	v.typ = p.typeRef()
+	if p.skipPunct("=") {
+		v.def = p.value(true)
+		v.has = true
+	}
+	p.directives()
+	return v
+}
+
+// typeRef parses a type reference such as [String!]! into its SDL text
+func (p *parser) typeRef() string {
+	var typ string
+	if p.skipPunct("[") {
+		typ = "[" + p.typeRef() + "]"
+		p.expectPunct("]")
+	} else {
+		typ = p.name()
+	}
+	if p.skipPunct("!") {
+		typ += "!"
+	}
+	return typ
+}
+
+func (p *parser) fragmentDefinition() *fragment {
+	p.next() // fragment
+	frag := &fragment{name: p.name()}
+	if frag.name == "on" {
+		p.fail("fragment cannot be named \"on\"")
+	}
+	p.expectKeyword("on")
+	frag.typeCond = p.name()
+	frag.directives = p.directives()
+	frag.selection = p.selectionSet()
+	return frag
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expectPunct("{")
+	if p.peekPunct("}") {
+		p.fail("selection set cannot be empty")
+	}
+	var sels []selection
+	for !p.skipPunct("}") {
+		if p.skipPunct("...") {
+			if p.tok.kind == tokName && p.tok.value != "on" {
+				sels = append(sels, &fragmentSpread{name: p.name(), directives: p.directives()})
+				continue
+			}
+			inline := &inlineFragment{}
+			if p.tok.kind == tokName && p.tok.value == "on" {
+				p.next()
+				inline.typeCond = p.name()
+			}
+			inline.directives = p.directives()
+			inline.selection = p.selectionSet()
+			sels = append(sels, inline)
+			continue
+		}
+		sels = append(sels, p.field())
+	}
+	return sels
+}
+
+func (p *parser) field() *field {
+	f := &field{line: p.tok.line, col: p.tok.col}
+	f.name = p.name()
+	if p.skipPunct(":") {
+		f.alias = f.name
+		f.name = p.name()
+	}
+	f.args = p.arguments(false)
+	f.directives = p.directives()
+	if p.peekPunct("{") {
+		f.selection = p.selectionSet()
+	}
+	return f
+}
+
+func (p *parser) arguments(constant bool) []*argument {
+	if !p.skipPunct("(") {
+		return nil
+	}
+	var args []*argument
+	for !p.skipPunct(")") {
+		arg := &argument{name: p.name()}
+		p.expectPunct(":")
+		arg.value = p.value(constant)
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var dirs []*directive
+	for p.skipPunct("@") {
+		dirs = append(dirs, &directive{name: p.name(), args: p.arguments(false)})
+	}
+	return dirs
+}
+
+func (p *parser) value(constant bool) interface{} {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			p.fail("invalid integer %s", tok.value)
+		}
+		p.next()
+		return n
+	case tokFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			p.fail("invalid float %s", tok.value)
+		}
+		p.next()
+		return f
+	case tokString:
+		p.next()
+		return tok.value
+	case tokName:
+		p.next()
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(tok.value)
+	case tokPunct:
+		switch tok.value {
+		case "$":
+			if constant {
+				p.fail("variables are not allowed here")
+			}
+			p.next()
+			return variable(p.name())
+		case "[":
+			p.next()
+			list := []interface{}{}
+			for !p.skipPunct("]") {
+				list = append(list, p.value(constant))
+			}
+			return list
+		case "{":
+			p.next()
+			obj := map[string]interface{}{}
+			for !p.skipPunct("}") {
+				name := p.name()
+				p.expectPunct(":")
+				obj[name] = p.value(constant)
+			}
+			return obj
+		}
+	}
+	p.fail("unexpected %s", p.describe())
+	return nil
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokName {
+		p.fail("expected name, found %s", p.describe())
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+func (p *parser) expectKeyword(kw string) {
+	if p.tok.kind != tokName || p.tok.value != kw {
+		p.fail("expected %q, found %s", kw, p.describe())
+	}
+	p.next()
+}
+
+func (p *parser) peekPunct(s string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == s
+}
+
+func (p *parser) skipPunct(s string) bool {
+	if p.peekPunct(s) {
+		p.next()
+		return true
+	}
+	if p.tok.kind == tokEOF {
+		p.fail("unexpected end of document")
+	}
+	return false
+}
+
+func (p *parser) expectPunct(s string) {
+	if !p.skipPunct(s) {
+		p.fail("expected %q, found %s", s, p.describe())
+	}
+}
+
+func (p *parser) describe() string {
+	switch p.tok.kind {
+	case tokEOF:
+		return "end of document"
+	case tokString:
+		return "string"
+	}
+	return strconv.Quote(p.tok.value)
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(&SyntaxError{Message: fmt.Sprintf(format, args...), Line: p.tok.line, Col: p.tok.col})
+}
+
+// next advances to the next token, skipping whitespace, commas, and comments
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.advance(1)
+			p.line++
+			p.col = 1
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.advance(1)
+			continue
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.advance(1)
+			}
+			continue
+		}
+		break
+	}
+
+	p.tok = token{line: p.line, col: p.col}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokEOF
+		return
+	}
+
+	rest := p.src[p.pos:]
+	c := rest[0]
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		p.tok.kind, p.tok.value = tokPunct, "..."
+		p.advance(3)
+	case strings.ContainsRune("!$()=:@[]{}|&", rune(c)):
+		p.tok.kind, p.tok.value = tokPunct, string(c)
+		p.advance(1)
+	case c == '_' || isLetter(c):
+		end := 1
+		for end < len(rest) && (rest[end] == '_' || isLetter(rest[end]) || isDigit(rest[end])) {
+			end++
+		}
+		p.tok.kind, p.tok.value = tokName, rest[:end]
+		p.advance(end)
+	case c == '-' || isDigit(c):
+		p.number(rest)
+	case strings.HasPrefix(rest, `"""`):
+		p.blockString(rest)
+	case c == '"':
+		p.string(rest)
+	default:
+		r, _ := utf8.DecodeRuneInString(rest)
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func (p *parser) advance(n int) {
+	p.pos += n
+	p.col += n
+}
+
+func (p *parser) number(rest string) {
+	end := 0
+	if rest[end] == '-' {
+		end++
+	}
+	digits := end
+	for end < len(rest) && isDigit(rest[end]) {
+		end++
+	}
+	if end == digits {
+		p.fail("invalid number")
+	}
+	kind := tokInt
+	if end < len(rest) && rest[end] == '.' {
+		kind = tokFloat
+		end++
+		for end < len(rest) && isDigit(rest[end]) {
+			end++
+		}
+	}
+	if end < len(rest) && (rest[end] == 'e' || rest[end] == 'E') {
+		kind = tokFloat
+		end++
+		if end < len(rest) && (rest[end] == '+' || rest[end] == '-') {
+			end++
+		}
+		for end < len(rest) && isDigit(rest[end]) {
+			end++
+		}
+	}
+	p.tok.kind, p.tok.value = kind, rest[:end]
+	p.advance(end)
+}
+
+func (p *parser) string(rest string) {
+	var b strings.Builder
+	i := 1
+	for {
+		if i >= len(rest) || rest[i] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := rest[i]
+		if c == '"' {
+			i++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if i+1 >= len(rest) {
+			p.fail("unterminated string")
+		}
+		switch esc := rest[i+1]; esc {
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if i+6 > len(rest) {
+				p.fail("invalid unicode escape")
+			}
+			n, err := strconv.ParseUint(rest[i+2:i+6], 16, 32)
+			if err != nil {
+				p.fail("invalid unicode escape")
+			}
+			b.WriteRune(rune(n))
+			i += 4
+		default:
+			p.fail("invalid escape \\%c", esc)
+		}
+		i += 2
+	}
+	p.tok.kind, p.tok.value = tokString, b.String()
+	p.advance(i)
+}
+
+func (p *parser) blockString(rest string) {
+	// The string ends at the first """ not escaped as \"""
+	end := 0
+	for {
+		i := strings.Index(rest[3+end:], `"""`)
+		if i < 0 {
+			p.fail("unterminated block string")
+		}
+		end += i
+		if end == 0 || rest[3+end-1] != '\\' {
+			break
+		}
+		end += 3
+	}
+	raw := rest[3 : 3+end]
+	p.tok.kind, p.tok.value = tokString, strings.TrimSpace(strings.ReplaceAll(raw, `\"""`, `"""`))
+
+	// Keep line numbers accurate across the string
+	lines := strings.Count(raw, "\n")
+	p.pos += end + 6
+	if lines > 0 {
+		p.line += lines
+		p.col = len(raw) - strings.LastIndex(raw, "\n") + 3
+	} else {
+		p.col += end + 6
+	}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,227 @@
+package graphql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseOperations(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		kinds []string
+		names []string
+	}{
+		{"shorthand", `{ a }`, []string{"query"}, []string{""}},
+		{"named query", `query Q { a }`, []string{"query"}, []string{"Q"}},
+		{"anonymous query", `query { a }`, []string{"query"}, []string{""}},
+		{"mutation", `mutation M { a }`, []string{"mutation"}, []string{"M"}},
+		{"multiple", `query A { a } query B { b }`, []string{"query", "query"}, []string{"A", "B"}},
+		{"comments and commas", "# leading\nquery A { a, b # trailing\n }", []string{"query"}, []string{"A"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parse(tt.query)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if len(doc.operations) != len(tt.kinds) {
+				t.Fatalf("got %d operations, want %d", len(doc.operations), len(tt.kinds))
+			}
+			for i, op := range doc.operations {
+				if op.kind != tt.kinds[i] || op.name != tt.names[i] {
+					t.Errorf("operation %d is %s %q, want %s %q", i, op.kind, op.name, tt.kinds[i], tt.names[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	doc, err := parse(`{
+  first: client(id: "c1") @include(if: true) {
+    hostname
+    sensors { id }
+  }
+}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	sels := doc.operations[0].selection
+	if len(sels) != 1 {
+		t.Fatalf("got %d selections, want 1", len(sels))
+	}
+	f, ok := sels[0].(*field)
+	if !ok {
+		t.Fatalf("selection is %T, want *field", sels[0])
+	}
+	if f.alias != "first" || f.name != "client" || f.responseKey() != "first" {
+		t.Errorf("got alias %q name %q, want first: client", f.alias, f.name)
+	}
+	if f.line != 2 || f.col != 3 {
+		t.Errorf("got location %d:%d, want 2:3", f.line, f.col)
+	}
+	if len(f.args) != 1 || f.args[0].name != "id" || f.args[0].value != "c1" {
+		t.Errorf("unexpected arguments %+v", f.args)
+	}
+	if len(f.directives) != 1 || f.directives[0].name != "include" {
+		t.Errorf("unexpected directives %+v", f.directives)
+	}
+	if len(f.selection) != 2 {
+		t.Fatalf("got %d subfields, want 2", len(f.selection))
+	}
+	if sub := f.selection[1].(*field); sub.name != "sensors" || len(sub.selection) != 1 {
+		t.Errorf("unexpected subfield %+v", sub)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		literal string
+		want    interface{}
+	}{
+		{`42`, int64(42)},
+		{`-7`, int64(-7)},
+		{`1.5`, 1.5},
+		{`2e3`, 2000.0},
+		{`-1.5E-1`, -0.15},
+		{`"text"`, "text"},
+		{`"esc\"\\\/\n\té"`, "esc\"\\/\n\té"},
+		{`"""  block "quoted" \""" """`, `block "quoted" """`},
+		{`true`, true},
+		{`false`, false},
+		{`null`, nil},
+		{`CRITICAL`, enumValue("CRITICAL")},
+		{`$since`, variable("since")},
+		{`[1, "a", [true]]`, []interface{}{int64(1), "a", []interface{}{true}}},
+		{`[]`, []interface{}{}},
+		{`{a: 1, b: {c: null}}`, map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.literal, func(t *testing.T) {
+			doc, err := parse(`{ f(v: ` + tt.literal + `) }`)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got := doc.operations[0].selection[0].(*field).args[0].value
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	doc, err := parse(`query Q($id: String!, $ids: [ID!]! = ["a"], $limit: Int = 10, $since: Time) { a }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []*varDef{
+		{name: "id", typ: "String!"},
+		{name: "ids", typ: "[ID!]!", def: []interface{}{"a"}, has: true},
+		{name: "limit", typ: "Int", def: int64(10), has: true},
+		{name: "since", typ: "Time"},
+	}
+	if !reflect.DeepEqual(doc.operations[0].vars, want) {
+		t.Errorf("got %+v, want %+v", doc.operations[0].vars, want)
+	}
+}
+
+func TestParseFragments(t *testing.T) {
+	doc, err := parse(`
+query { client(id: "c1") { ...Fields ... on Client { os } ... @skip(if: false) { arch } } }
+fragment Fields on Client @include(if: true) { hostname }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	frag, ok := doc.fragments["Fields"]
+	if !ok {
+		t.Fatal("fragment Fields was not parsed")
+	}
+	if frag.typeCond != "Client" || len(frag.directives) != 1 || len(frag.selection) != 1 {
+		t.Errorf("unexpected fragment %+v", frag)
+	}
+
+	sels := doc.operations[0].selection[0].(*field).selection
+	if len(sels) != 3 {
+		t.Fatalf("got %d selections, want 3", len(sels))
+	}
+	if spread, ok := sels[0].(*fragmentSpread); !ok || spread.name != "Fields" {
+		t.Errorf("selection 0 is %#v, want spread of Fields", sels[0])
+	}
+	if inline, ok := sels[1].(*inlineFragment); !ok || inline.typeCond != "Client" {
+		t.Errorf("selection 1 is %#v, want inline fragment on Client", sels[1])
+	}
+	if inline, ok := sels[2].(*inlineFragment); !ok || inline.typeCond != "" || len(inline.directives) != 1 {
+		t.Errorf("selection 2 is %#v, want inline fragment with @skip", sels[2])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		message   string
+		line, col int
+	}{
+		{"empty", ``, "document contains no operations", 1, 1},
+		{"only fragment", `fragment F on Client { id }`, "document contains no operations", 1, 28},
+		{"unterminated selection", `{ a`, "unexpected end of document", 1, 4},
+		{"empty selection", `{ }`, "selection set cannot be empty", 1, 3},
+		{"type definition", `type Foo { a: Int }`, `unexpected "type"`, 1, 1},
+		{"duplicate fragment", `{ a } fragment F on Q { a } fragment F on Q { b }`, `duplicate fragment "F"`, 1, 50},
+		{"fragment named on", `{ a } fragment on on Q { a }`, `fragment cannot be named "on"`, 1, 19},
+		{"missing type condition", `{ a } fragment F { a }`, `expected "on", found "{"`, 1, 18},
+		{"missing colon", `{ f(a 1) }`, `expected ":", found "1"`, 1, 7},
+		{"variable in default", `query ($a: Int = $b) { a }`, "variables are not allowed here", 1, 18},
+		{"unterminated string", `{ f(a: "x) }`, "unterminated string", 1, 8},
+		{"newline in string", "{ f(a: \"x\n\") }", "unterminated string", 1, 8},
+		{"bad escape", `{ f(a: "\q") }`, `invalid escape \q`, 1, 8},
+		{"bad unicode escape", `{ f(a: "\u00zz") }`, "invalid unicode escape", 1, 8},
+		{"unterminated block string", `{ f(a: """x) }`, "unterminated block string", 1, 8},
+		{"lone minus", `{ f(a: -) }`, "invalid number", 1, 8},
+		{"integer overflow", `{ f(a: 99999999999999999999) }`, "invalid integer 99999999999999999999", 1, 8},
+		{"unexpected character", `{ a ? }`, `unexpected character '?'`, 1, 5},
+		{"line tracking", "{\n  a\n  ?\n}", `unexpected character '?'`, 3, 3},
+		{"line tracking after block string", "{ f(a: \"\"\"x\ny\"\"\") ? }", `unexpected character '?'`, 2, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.query)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got error %v, want a syntax error", err)
+			}
+			if syntaxErr.Message != tt.message || syntaxErr.Line != tt.line || syntaxErr.Col != tt.col {
+				t.Errorf("got %q at %d:%d, want %q at %d:%d", syntaxErr.Message, syntaxErr.Line, syntaxErr.Col, tt.message, tt.line, tt.col)
+			}
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		`{ a }`,
+		`query Q($a: [Int!]! = [1]) { f(a: $a) @skip(if: false) { ...F ... on T { b } } }`,
+		`fragment F on T { a: b(c: {d: "eé", f: 1.5e3}) }`,
+		`{ f(a: """block""") }`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		// Any input either parses or fails with a syntax error; nothing else
+		// may escape the parser
+		doc, err := parse(src)
+		if err != nil {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got error %v, want a syntax error", err)
+			}
+			return
+		}
+		if len(doc.operations) == 0 {
+			t.Fatal("parsed a document without operations")
+		}
+	})
+}
@@ -0,0 +1,505 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	"gorm.io/gorm"
+)
+
+// Limits for list fields, matching GetTemperatureHistory
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// sensorStats aggregates the readings of one sensor over a time range
+type sensorStats struct {
+	ClientID string
+	SensorID string
+	Min      float64
+	Max      float64
+	Avg      float64
+	Count    int64
+}
+
+// resolver answers queries from the database
+type resolver struct {
+	db *gorm.DB
+}
+
+// Shared argument lists
+var (
+	rangeArgs = []*Arg{
+		{Name: "since", Type: "Time", Description: "Only include data at or after this time."},
+		{Name: "until", Type: "Time", Description: "Only include data at or before this time."},
+	}
+	limitArg = &Arg{Name: "limit", Type: "Int", Description: fmt.Sprintf("Maximum number of results, newest first (default %d, max %d).", defaultLimit, maxLimit)}
+)
+
+func withArgs(groups ...interface{}) []*Arg {
+	var args []*Arg
+	for _, g := range groups {
+		switch g := g.(type) {
+		case *Arg:
+			args = append(args, g)
+		case []*Arg:
+			args = append(args, g...)
+		}
+	}
+	return args
+}
+
+// newSchema builds the jacuzzi query schema
+func newSchema(db *gorm.DB) (*Schema, error) {
+	r := &resolver{db: db}
+
+	client := &Object{Name: "Client", Description: "A monitored host running the jacuzzi agent."}
+	sensor := &Object{Name: "Sensor", Description: "A temperature sensor reported by a client."}
+	reading := &Object{Name: "Reading", Description: "A single temperature reading."}
+	stats := &Object{Name: "SensorStats", Description: "Aggregate temperatures for one sensor over a time range."}
+	alert := &Object{Name: "Alert", Description: "An alert triggered by an alert rule."}
+	annotation := &Object{Name: "Annotation", Description: "An event recorded to explain temperature shifts."}
+
+	client.Fields = []*Field{
+		prop("id", "String!", func(c *models.Client) interface{} { return c.ClientID }),
+		prop("hostname", "String!", func(c *models.Client) interface{} { return c.Hostname }),
+		prop("ipAddress", "String!", func(c *models.Client) interface{} { return c.IPAddress }),
+		prop("os", "String!", func(c *models.Client) interface{} { return c.OS }),
+		prop("arch", "String!", func(c *models.Client) interface{} { return c.Arch }),
+		prop("status", "String!", func(c *models.Client) interface{} { return c.Status }),
+		prop("isOnline", "Boolean!", func(c *models.Client) interface{} { return c.IsOnline }),
+		prop("firstSeen", "Time", func(c *models.Client) interface{} { return c.FirstSeen }),
+		prop("lastSeen", "Time", func(c *models.Client) interface{} { return c.LastSeen }),
+		prop("site", "String!", func(c *models.Client) interface{} { return c.Site }),
+		prop("rack", "String!", func(c *models.Client) interface{} { return c.Rack }),
+		prop("latitude", "Float", func(c *models.Client) interface{} { return c.Latitude }),
+		prop("longitude", "Float", func(c *models.Client) interface{} { return c.Longitude }),
+		prop("location", "String!", func(c *models.Client) interface{} { return c.LocationDescription }),
+		{
+			Name: "sensors", Type: "[Sensor!]!",
+			Args: []*Arg{
+				{Name: "type", Type: "String", Description: "Only include sensors of this type."},
+				{Name: "includeRetired", Type: "Boolean", Description: "Include retired sensors."},
+			},
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				args["clientId"] = src.(*models.Client).ClientID
+				return r.sensors(ctx, args)
+			},
+		},
+		{
+			Name: "readings", Type: "[Reading!]!",
+			Args: withArgs(&Arg{Name: "sensorId", Type: "String"}, rangeArgs, limitArg),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				args["clientId"] = src.(*models.Client).ClientID
+				return r.readings(ctx, args)
+			},
+		},
+		{
+			Name: "stats", Type: "[SensorStats!]!", Description: "Per-sensor statistics.",
+			Args: withArgs(rangeArgs),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				args["clientId"] = src.(*models.Client).ClientID
+				return r.stats(ctx, args)
+			},
+		},
+		{
+			Name: "alerts", Type: "[Alert!]!",
+			Args: withArgs(&Arg{Name: "active", Type: "Boolean"}, rangeArgs, limitArg),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				args["clientId"] = src.(*models.Client).ClientID
+				return r.alerts(ctx, args)
+			},
+		},
+	}
+
+	sensor.Fields = []*Field{
+		prop("id", "String!", func(s *models.Sensor) interface{} { return s.SensorID }),
+		prop("clientId", "String!", func(s *models.Sensor) interface{} { return s.ClientID }),
+		prop("type", "String!", func(s *models.Sensor) interface{} { return s.SensorType }),
+		prop("name", "String!", func(s *models.Sensor) interface{} { return s.SensorName }),
+		prop("lastReadingAt", "Time", func(s *models.Sensor) interface{} { return s.LastReadingAt }),
+		prop("staleSince", "Time", func(s *models.Sensor) interface{} { return s.StaleSince }),
+		prop("retiredAt", "Time", func(s *models.Sensor) interface{} { return s.RetiredAt }),
+		{
+			Name: "client", Type: "Client",
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.client(ctx, src.(*models.Sensor).ClientID)
+			},
+		},
+		{
+			Name: "latest", Type: "Reading", Description: "The most recent reading.",
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				s := src.(*models.Sensor)
+				readings, err := r.readings(ctx, map[string]interface{}{"clientId": s.ClientID, "sensorId": s.SensorID, "limit": int64(1)})
+				if err != nil || len(readings) == 0 {
+					return nil, err
+				}
+				return readings[0], nil
+			},
+		},
+		{
+			Name: "readings", Type: "[Reading!]!",
+			Args: withArgs(rangeArgs, limitArg),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				s := src.(*models.Sensor)
+				args["clientId"], args["sensorId"] = s.ClientID, s.SensorID
+				return r.readings(ctx, args)
+			},
+		},
+		{
+			Name: "stats", Type: "SensorStats",
+			Args: withArgs(rangeArgs),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				s := src.(*models.Sensor)
+				args["clientId"], args["sensorId"] = s.ClientID, s.SensorID
+				stats, err := r.stats(ctx, args)
+				if err != nil || len(stats) == 0 {
+					return nil, err
+				}
+				return stats[0], nil
+			},
+		},
+	}
+
+	reading.Fields = []*Field{
+		prop("clientId", "String!", func(t *models.TemperatureReading) interface{} { return t.ClientID }),
+		prop("sensorId", "String!", func(t *models.TemperatureReading) interface{} { return t.SensorID }),
+		prop("sensorType", "String!", func(t *models.TemperatureReading) interface{} { return t.SensorType }),
+		prop("sensorName", "String!", func(t *models.TemperatureReading) interface{} { return t.SensorName }),
+		prop("temperature", "Float!", func(t *models.TemperatureReading) interface{} { return t.TemperatureCelsius }),
+		prop("timestamp", "Time!", func(t *models.TemperatureReading) interface{} { return t.CreatedAt }),
+	}
+
+	stats.Fields = []*Field{
+		prop("clientId", "String!", func(s *sensorStats) interface{} { return s.ClientID }),
+		prop("sensorId", "String!", func(s *sensorStats) interface{} { return s.SensorID }),
+		prop("min", "Float!", func(s *sensorStats) interface{} { return s.Min }),
+		prop("max", "Float!", func(s *sensorStats) interface{} { return s.Max }),
+		prop("avg", "Float!", func(s *sensorStats) interface{} { return s.Avg }),
+		prop("count", "Int!", func(s *sensorStats) interface{} { return s.Count }),
+	}
+
+	alert.Fields = []*Field{
+		prop("id", "String!", func(a *models.Alert) interface{} { return a.AlertID }),
+		prop("ruleId", "String!", func(a *models.Alert) interface{} { return a.RuleID }),
+		prop("clientId", "String!", func(a *models.Alert) interface{} { return a.ClientID }),
+		prop("sensorId", "String!", func(a *models.Alert) interface{} { return a.SensorID }),
+		prop("value", "Float!", func(a *models.Alert) interface{} { return a.Value }),
+		prop("severity", "String!", func(a *models.Alert) interface{} { return a.Severity }),
+		prop("message", "String!", func(a *models.Alert) interface{} { return a.Message }),
+		prop("isActive", "Boolean!", func(a *models.Alert) interface{} { return a.IsActive }),
+		prop("triggeredAt", "Time!", func(a *models.Alert) interface{} { return a.TriggeredAt }),
+		prop("resolvedAt", "Time", func(a *models.Alert) interface{} { return a.ResolvedAt }),
+		prop("acknowledgedAt", "Time", func(a *models.Alert) interface{} { return a.AcknowledgedAt }),
+		prop("acknowledgedBy", "String!", func(a *models.Alert) interface{} { return a.AcknowledgedBy }),
+		{
+			Name: "client", Type: "Client",
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.client(ctx, src.(*models.Alert).ClientID)
+			},
+		},
+	}
+
+	annotation.Fields = []*Field{
+		prop("id", "String!", func(a *models.Annotation) interface{} { return a.AnnotationID }),
+		prop("clientId", "String!", func(a *models.Annotation) interface{} { return a.ClientID }),
+		prop("time", "Time!", func(a *models.Annotation) interface{} { return a.Time }),
+		prop("endTime", "Time", func(a *models.Annotation) interface{} { return a.EndTime }),
+		prop("text", "String!", func(a *models.Annotation) interface{} { return a.Text }),
+		prop("tags", "[String!]!", func(a *models.Annotation) interface{} {
+			tags := []string{}
+			if a.Tags != "" {
+				json.Unmarshal([]byte(a.Tags), &tags)
+			}
+			return tags
+		}),
+		prop("author", "String!", func(a *models.Annotation) interface{} { return a.Author }),
+	}
+
+	query := &Object{Name: "Query", Fields: []*Field{
+		{
+			Name: "clients", Type: "[Client!]!",
+			Args: []*Arg{
+				{Name: "status", Type: "String", Description: "Only include clients in this enrollment state (pending, approved, rejected)."},
+				{Name: "online", Type: "Boolean"},
+				{Name: "site", Type: "String", Description: "Only include clients at this site."},
+			},
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.clients(ctx, args)
+			},
+		},
+		{
+			Name: "client", Type: "Client",
+			Args: []*Arg{{Name: "id", Type: "String!"}},
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.client(ctx, args["id"].(string))
+			},
+		},
+		{
+			Name: "sensors", Type: "[Sensor!]!",
+			Args: []*Arg{{Name: "clientId", Type: "String"}, {Name: "type", Type: "String"}, {Name: "includeRetired", Type: "Boolean"}},
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.sensors(ctx, args)
+			},
+		},
+		{
+			Name: "readings", Type: "[Reading!]!",
+			Args: withArgs(&Arg{Name: "clientId", Type: "String"}, &Arg{Name: "sensorId", Type: "String"}, rangeArgs, limitArg),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.readings(ctx, args)
+			},
+		},
+		{
+			Name: "stats", Type: "[SensorStats!]!", Description: "Per-sensor statistics.",
+			Args: withArgs(&Arg{Name: "clientId", Type: "String"}, &Arg{Name: "sensorId", Type: "String"}, rangeArgs),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.stats(ctx, args)
+			},
+		},
+		{
+			Name: "alerts", Type: "[Alert!]!",
+			Args: withArgs(
+				&Arg{Name: "clientId", Type: "String"},
+				&Arg{Name: "active", Type: "Boolean"},
+				&Arg{Name: "severity", Type: "String", Description: "SEVERITY_INFO, SEVERITY_WARNING, or SEVERITY_CRITICAL."},
+				rangeArgs, limitArg,
+			),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.alerts(ctx, args)
+			},
+		},
+		{
+			Name: "annotations", Type: "[Annotation!]!", Description: "Annotations overlapping the range.",
+			Args: withArgs(&Arg{Name: "clientId", Type: "String", Description: "Only include this client's and global annotations."}, rangeArgs, limitArg),
+			Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+				return r.annotations(ctx, args)
+			},
+		},
+	}}
+
+	return NewSchema(query, client, sensor, reading, stats, alert, annotation)
+}
+
+// prop declares a field read from a typed source value
+func prop[T any](name, typ string, get func(*T) interface{}) *Field {
+	return &Field{
+		Name: name,
+		Type: typ,
+		Resolve: func(ctx context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+			return get(src.(*T)), nil
+		},
+	}
+}
+
+func (r *resolver) clients(ctx context.Context, args map[string]interface{}) ([]*models.Client, error) {
+	query := r.db.WithContext(ctx).Model(&models.Client{})
+	if status, ok := args["status"]; ok {
+		query = query.Where("status = ?", status)
+	}
+	if online, ok := args["online"]; ok {
+		query = query.Where("is_online = ?", online)
+	}
+	if site, ok := args["site"]; ok {
+		query = query.Where("site = ?", site)
+	}
+
+	var clients []*models.Client
+	if err := query.Order("client_id").Find(&clients).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to query clients")
+	}
+	return clients, nil
+}
+
+func (r *resolver) client(ctx context.Context, clientID string) (*models.Client, error) {
+	var clients []*models.Client
+	if err := r.db.WithContext(ctx).Where("client_id = ?", clientID).Limit(1).Find(&clients).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to query client")
+	}
+	if len(clients) == 0 {
+		return nil, nil
+	}
+	return clients[0], nil
+}
+
+func (r *resolver) sensors(ctx context.Context, args map[string]interface{}) ([]*models.Sensor, error) {
+	query := r.db.WithContext(ctx).Model(&models.Sensor{})
+	if clientID, ok := args["clientId"]; ok {
+		query = query.Where("client_id = ?", clientID)
+	}
+	if sensorType, ok := args["type"].(string); ok {
+		query = query.Where("sensor_type = ?", sensortype.Filter(sensorType))
+	}
+	if include, _ := args["includeRetired"].(bool); !include {
+		query = query.Where("retired_at IS NULL")
+	}
+
+	var sensors []*models.Sensor
+	if err := query.Order("client_id, sensor_id").Find(&sensors).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to query sensors")
+	}
+	return sensors, nil
+}
+
+func (r *resolver) readings(ctx context.Context, args map[string]interface{}) ([]*models.TemperatureReading, error) {
+	query := filterRange(r.db.WithContext(ctx).Model(&models.TemperatureReading{}), "created_at", args)
+	if clientID, ok := args["clientId"]; ok {
+		query = query.Where("client_id = ?", clientID)
+	}
+	if sensorID, ok := args["sensorId"]; ok {
+		query = query.Where("sensor_id = ?", sensorID)
+	}
+
+	var readings []*models.TemperatureReading
+	if err := query.Order("created_at DESC").Limit(limit(args)).Find(&readings).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to query readings")
+	}
+	compressed, err := chunks.Readings(r.db.WithContext(ctx), chunkFilter(args), limit(args))
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to query compressed readings")
+	}
+	if len(compressed) > 0 {
+		for i := range compressed {
+			readings = append(readings, &compressed[i])
+		}
+		sort.SliceStable(readings, func(i, j int) bool { return readings[i].CreatedAt.After(readings[j].CreatedAt) })
+		if len(readings) > limit(args) {
+			readings = readings[:limit(args)]
+		}
+	}
+	return readings, nil
+}
+
+func (r *resolver) stats(ctx context.Context, args map[string]interface{}) ([]*sensorStats, error) {
+	query := filterRange(r.db.WithContext(ctx).Model(&models.TemperatureReading{}), "created_at", args)
+	if clientID, ok := args["clientId"]; ok {
+		query = query.Where("client_id = ?", clientID)
+	}
+	if sensorID, ok := args["sensorId"]; ok {
+		query = query.Where("sensor_id = ?", sensorID)
+	}
+
+	var stats []*sensorStats
+	err := query.Select(`
+		client_id, sensor_id,
+		MIN(COALESCE(min_celsius, temperature_celsius)) as min,
+		MAX(COALESCE(max_celsius, temperature_celsius)) as max,
+		AVG(COALESCE(avg_celsius, temperature_celsius)) as avg,
+		COUNT(*) as count
+	`).Group("client_id, sensor_id").Order("client_id, sensor_id").Scan(&stats).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to calculate stats")
+	}
+
+	type sensorKey struct{ clientID, sensorID string }
+	compressed, err := chunks.Aggregate(r.db.WithContext(ctx), chunkFilter(args), func(clientID, sensorID string, _ time.Time) (sensorKey, bool) {
+		return sensorKey{clientID, sensorID}, true
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to calculate compressed stats")
+	}
+	if len(compressed) == 0 {
+		return stats, nil
+	}
+	for _, stat := range stats {
+		key := sensorKey{stat.ClientID, stat.SensorID}
+		if c, ok := compressed[key]; ok {
+			total := float64(stat.Count + c.Count)
+			stat.Avg = (stat.Avg*float64(stat.Count) + c.Sum) / total
+			stat.Min = min(stat.Min, c.Min)
+			stat.Max = max(stat.Max, c.Max)
+			stat.Count += c.Count
+			delete(compressed, key)
+		}
+	}
+	// Sensors whose readings in range are all compressed
+	for key, c := range compressed {
+		stats = append(stats, &sensorStats{ClientID: key.clientID, SensorID: key.sensorID, Min: c.Min, Max: c.Max, Avg: c.Avg(), Count: c.Count})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ClientID != stats[j].ClientID {
+			return stats[i].ClientID < stats[j].ClientID
+		}
+		return stats[i].SensorID < stats[j].SensorID
+	})
+	return stats, nil
+}
+
+func (r *resolver) alerts(ctx context.Context, args map[string]interface{}) ([]*models.Alert, error) {
+	query := filterRange(r.db.WithContext(ctx).Model(&models.Alert{}), "triggered_at", args)
+	if clientID, ok := args["clientId"]; ok {
+		query = query.Where("client_id = ?", clientID)
+	}
+	if active, ok := args["active"]; ok {
+		query = query.Where("is_active = ?", active)
+	}
+	if severity, ok := args["severity"]; ok {
+		query = query.Where("severity = ?", severity)
+	}
+
+	var alerts []*models.Alert
+	if err := query.Order("triggered_at DESC").Limit(limit(args)).Find(&alerts).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to query alerts")
+	}
+	return alerts, nil
+}
+
+func (r *resolver) annotations(ctx context.Context, args map[string]interface{}) ([]*models.Annotation, error) {
+	query := r.db.WithContext(ctx).Model(&models.Annotation{})
+	if clientID, ok := args["clientId"]; ok {
+		query = query.Where("client_id = ? OR client_id = ''", clientID)
+	}
+	if since, ok := args["since"].(time.Time); ok {
+		// Events spanning a period overlap the range if they end inside it
+		query = query.Where("time >= ? OR end_time >= ?", since, since)
+	}
+	if until, ok := args["until"].(time.Time); ok {
+		query = query.Where("time <= ?", until)
+	}
+
+	var annotations []*models.Annotation
+	if err := query.Order("time DESC").Limit(limit(args)).Find(&annotations).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to query annotations")
+	}
+	return annotations, nil
+}
+
+// filterRange applies the since and until arguments to a time column
+func filterRange(query *gorm.DB, column string, args map[string]interface{}) *gorm.DB {
+	if since, ok := args["since"].(time.Time); ok {
+		query = query.Where(column+" >= ?", since)
+	}
+	if until, ok := args["until"].(time.Time); ok {
+		query = query.Where(column+" <= ?", until)
+	}
+	return query
+}
+
+// chunkFilter selects the compressed readings the client, sensor and range
+// arguments cover
+func chunkFilter(args map[string]interface{}) chunks.Filter {
+	var filter chunks.Filter
+	filter.ClientID, _ = args["clientId"].(string)
+	if sensorID, ok := args["sensorId"].(string); ok {
+		filter.SensorIDs = []string{sensorID}
+	}
+	filter.Start, _ = args["since"].(time.Time)
+	filter.End, _ = args["until"].(time.Time)
+	return filter
+}
+
+// limit returns the limit argument, clamped like GetTemperatureHistory
+func limit(args map[string]interface{}) int {
+	n, _ := args["limit"].(int64)
+	if n <= 0 || n > maxLimit {
+		return defaultLimit
+	}
+	return int(n)
+}
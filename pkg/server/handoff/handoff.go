@@ -0,0 +1,97 @@
+// Package handoff lets a new server binary take over the gRPC and HTTP
+// listeners of the running one, so upgrades do not refuse agent connections.
+// On SIGUSR2 the server starts its executable again with the listening
+// sockets as inherited files and waits for the new process to report it is
+// ready, then shuts down gracefully while the new process accepts
+// connections on the same sockets.
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// envListeners names the inherited listeners, comma-separated, in the
+	// order of their files from fd 3
+	envListeners = "JACUZZI_HANDOFF_LISTENERS"
+	// envReady is the fd of the pipe the new process reports readiness on
+	envReady = "JACUZZI_HANDOFF_READY"
+)
+
+// errUnsupported is returned by Upgrade where listeners cannot be inherited
+var errUnsupported = errors.New("listener handoff is not supported on this platform")
+
+// Handoff holds the listeners a process serves, to pass them on to its
+// successor
+type Handoff struct {
+	pidFile string
+
+	mu        sync.Mutex
+	inherited map[string]net.Listener
+	names     []string
+	listeners map[string]net.Listener
+	ready     *os.File // Until Ready, when started by a predecessor
+	inheritor bool     // Started by a predecessor
+	upgrading bool
+}
+
+// Listen returns the listener inherited under name, or listens on addr. The
+// listener is handed to the successor on upgrade.
+func (h *Handoff) Listen(name, addr string) (net.Listener, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, ok := h.inherited[name]
+	if ok {
+		delete(h.inherited, name)
+	} else {
+		var err error
+		if l, err = net.Listen("tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	h.names = append(h.names, name)
+	h.listeners[name] = l
+	return l, nil
+}
+
+// Inherited reports whether the process took over listeners from a
+// predecessor
+func (h *Handoff) Inherited() bool {
+	return h.inheritor
+}
+
+// Ready writes the PID file, if any, and tells the predecessor this process
+// serves its listeners, so it can shut down. Listeners inherited but not
+// taken by Listen are closed.
+func (h *Handoff) Ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name, l := range h.inherited {
+		l.Close()
+		delete(h.inherited, name)
+	}
+	if h.pidFile != "" {
+		if err := os.WriteFile(h.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to write PID file: %w", err)
+		}
+	}
+	if h.ready == nil {
+		return nil
+	}
+	_, err := h.ready.Write([]byte{1})
+	if closeErr := h.ready.Close(); err == nil {
+		err = closeErr
+	}
+	h.ready = nil
+	if err != nil {
+		return fmt.Errorf("failed to report readiness to the previous process: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !linux && !darwin && !freebsd
+
+package handoff
+
+import (
+	"context"
+	"net"
+	"os"
+)
+
+// New returns the handoff of this process. Listeners cannot be inherited on
+// this platform, so it only listens and writes the PID file.
+func New(pidFile string) (*Handoff, error) {
+	return &Handoff{
+		pidFile:   pidFile,
+		inherited: make(map[string]net.Listener),
+		listeners: make(map[string]net.Listener),
+	}, nil
+}
+
+// Notify does nothing, as there is no upgrade signal on this platform
+func Notify(c chan<- os.Signal) {}
+
+// IsUpgrade reports false, as there is no upgrade signal on this platform
+func IsUpgrade(sig os.Signal) bool {
+	return false
+}
+
+// Upgrade is not supported on this platform
+func (h *Handoff) Upgrade(ctx context.Context) error {
+	return errUnsupported
+}
@@ -0,0 +1,164 @@
+//go:build linux || darwin || freebsd
+
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// readyTimeout bounds how long the new process may take to start serving,
+// including database migrations
+const readyTimeout = 2 * time.Minute
+
+// New returns the handoff of this process, taking the listeners of the
+// predecessor that started it, if any. pidFile, when set, is where the
+// process serving the listeners writes its PID, so service managers can
+// follow upgrades.
+func New(pidFile string) (*Handoff, error) {
+	h := &Handoff{
+		pidFile:   pidFile,
+		inherited: make(map[string]net.Listener),
+		listeners: make(map[string]net.Listener),
+	}
+	names := os.Getenv(envListeners)
+	if names == "" {
+		return h, nil
+	}
+	os.Unsetenv(envListeners)
+	readyFD, err := strconv.Atoi(os.Getenv(envReady))
+	os.Unsetenv(envReady)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envReady, err)
+	}
+
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit %s listener: %w", name, err)
+		}
+		h.inherited[name] = l
+	}
+	h.ready = os.NewFile(uintptr(readyFD), "handoff-ready")
+	h.inheritor = true
+	return h, nil
+}
+
+// Notify relays the upgrade signal, SIGUSR2, to c
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// IsUpgrade reports whether sig is the upgrade signal
+func IsUpgrade(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
+
+// Upgrade starts the executable again with this process's arguments and
+// listeners, and returns once it is ready to serve them. The caller should
+// then stop accepting and shut down. On error the new process is stopped
+// and this one keeps serving.
+func (h *Handoff) Upgrade(ctx context.Context) error {
+	h.mu.Lock()
+	if h.upgrading {
+		h.mu.Unlock()
+		return errors.New("an upgrade is already in progress")
+	}
+	h.upgrading = true
+	names := append([]string(nil), h.names...)
+	listeners := make([]net.Listener, len(names))
+	for i, name := range names {
+		listeners[i] = h.listeners[name]
+	}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.upgrading = false
+		h.mu.Unlock()
+	}()
+
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, l := range listeners {
+		filer, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%s listener cannot be handed off", names[i])
+		}
+		f, err := filer.File()
+		if err != nil {
+			return fmt.Errorf("failed to duplicate %s listener: %w", names[i], err)
+		}
+		files = append(files, f)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	attr := &os.ProcAttr{
+		Env: append(os.Environ(),
+			envListeners+"="+strings.Join(names, ","),
+			envReady+"="+strconv.Itoa(3+len(names)),
+		),
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...),
+	}
+	proc, err := os.StartProcess(executable, os.Args, attr)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", executable, err)
+	}
+	// Only the new process may hold the write end, so its exit ends the read
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	exited := make(chan error, 1)
+	go func() {
+		state, err := proc.Wait()
+		if err == nil {
+			err = fmt.Errorf("new process exited: %s", state)
+		}
+		exited <- err
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	select {
+	case err := <-ready:
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("new process did not report ready: %w", err)
+		proc.Kill()
+		return err
+	case err := <-exited:
+		return err
+	case <-ctx.Done():
+		proc.Kill()
+		return fmt.Errorf("new process was not ready within %s", readyTimeout)
+	}
+}
@@ -0,0 +1,208 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/disk"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// SensorType is the sensor type of the server's own sensors, so rules can
+// target them
+const SensorType = "SERVER"
+
+// Sensors of the server's client
+const (
+	SensorDiskFree  = "disk_free_percent" // Free space on the disk holding the database, or the working directory
+	SensorMemory    = "memory_mb"         // Memory the server process has taken from the OS
+	SensorDBErrors  = "db_errors"         // Failed queries since the previous sample
+	SensorDBLatency = "db_latency_ms"     // Round trip of a trivial query
+)
+
+// SubmitFunc stores the server's readings through the normal ingest path
+type SubmitFunc func(ctx context.Context, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error)
+
+// Config configures self-monitoring
+type Config struct {
+	ClientID string        // Client the server reports as
+	Interval time.Duration // Between samples
+	DiskPath string        // Directory whose filesystem is checked for free space
+}
+
+// Monitor records the server's own health as readings of a client
+// representing the server, so alert rules, dashboards and offline detection
+// cover the server like any other host. Every replica runs its own.
+type Monitor struct {
+	db       *gorm.DB
+	cfg      Config
+	dbErrors atomic.Int64
+}
+
+// NewMonitor returns a monitor that counts the failed queries of db
+func NewMonitor(db *gorm.DB, cfg Config) (*Monitor, error) {
+	m := &Monitor{db: db, cfg: cfg}
+	callbacks := db.Callback()
+	for name, err := range map[string]error{
+		"create": callbacks.Create().After("*").Register("health:count_errors", m.countError),
+		"query":  callbacks.Query().After("*").Register("health:count_errors", m.countError),
+		"update": callbacks.Update().After("*").Register("health:count_errors", m.countError),
+		"delete": callbacks.Delete().After("*").Register("health:count_errors", m.countError),
+		"row":    callbacks.Row().After("*").Register("health:count_errors", m.countError),
+		"raw":    callbacks.Raw().After("*").Register("health:count_errors", m.countError),
+	} {
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s errors: %w", name, err)
+		}
+	}
+	return m, nil
+}
+
+// countError counts a query failure, leaving out missing records and
+// cancelled requests, which are not database faults
+func (m *Monitor) countError(tx *gorm.DB) {
+	err := tx.Error
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	m.dbErrors.Add(1)
+}
+
+// Run records a sample every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context, submit SubmitFunc) {
+	if err := m.ensureClient(ctx); err != nil {
+		log.Printf("Failed to register server client %s: %v", m.cfg.ClientID, err)
+	}
+
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		readings := m.sample(ctx)
+		if _, err := submit(ctx, &temperaturev1.SubmitTemperatureRequest{Readings: readings}); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to record server health: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample measures the server, leaving out what cannot be measured
+func (m *Monitor) sample(ctx context.Context) []*temperaturev1.TemperatureReading {
+	now := timestamppb.Now()
+	var readings []*temperaturev1.TemperatureReading
+	add := func(sensorID, name string, value float64) {
+		readings = append(readings, &temperaturev1.TemperatureReading{
+			SensorId:           sensorID,
+			ClientId:           m.cfg.ClientID,
+			TemperatureCelsius: value,
+			Timestamp:          now,
+			SensorType:         SensorType,
+			SensorName:         name,
+		})
+	}
+
+	if free, total, err := disk.Space(m.cfg.DiskPath); err == nil && total > 0 {
+		add(SensorDiskFree, "Free disk space (%)", float64(free)/float64(total)*100)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	add(SensorMemory, "Server memory (MiB)", float64(mem.Sys)/(1<<20))
+
+	started := time.Now()
+	if err := m.db.WithContext(ctx).Exec("SELECT 1").Error; err == nil {
+		add(SensorDBLatency, "Database latency (ms)", float64(time.Since(started).Microseconds())/1000)
+	}
+	add(SensorDBErrors, "Database errors", float64(m.dbErrors.Swap(0)))
+	return readings
+}
+
+// ensureClient registers the server's client, approved whatever the
+// enrollment mode, and on first registration adds rules alerting on low disk
+// space and database errors. Rules removed later are not added back.
+func (m *Monitor) ensureClient(ctx context.Context) error {
+	db := m.db.WithContext(ctx)
+	var existing int64
+	if err := db.Model(&models.Client{}).Where("client_id = ?", m.cfg.ClientID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	metadata, _ := json.Marshal(map[string]string{"role": "server"})
+	now := time.Now()
+	client := &models.Client{
+		ClientID:  m.cfg.ClientID,
+		Hostname:  hostname,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		FirstSeen: now,
+		LastSeen:  now,
+		IsOnline:  true,
+		Metadata:  string(metadata),
+		Status:    models.ClientStatusApproved,
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(client).Error; err != nil {
+			return err
+		}
+		for _, rule := range defaultRules() {
+			// Deleted rules count as existing, so they stay deleted
+			if err := tx.Unscoped().Where("rule_id = ?", rule.RuleID).FirstOrCreate(&rule).Error; err != nil {
+				return err
+			}
+		}
+		activity.Client(tx, activity.ClientRegistered, client, "Server health monitoring started")
+		return nil
+	})
+}
+
+// defaultRules alert on every replica's disk filling up and on database
+// errors persisting
+func defaultRules() []models.AlertRule {
+	return []models.AlertRule{
+		{
+			RuleID:          "server-disk-space",
+			Name:            "Server disk space low",
+			Description:     "Less than 10% free on the disk holding the server's database",
+			SensorID:        SensorDiskFree,
+			SensorType:      SensorType,
+			ConditionType:   models.ConditionTypeThreshold,
+			Operator:        "OPERATOR_LESS_THAN",
+			Threshold:       10,
+			DurationSeconds: 300,
+			Severity:        "SEVERITY_CRITICAL",
+			Enabled:         true,
+		},
+		{
+			RuleID:          "server-database-errors",
+			Name:            "Server database errors",
+			Description:     "Database queries have been failing for 5 minutes",
+			SensorID:        SensorDBErrors,
+			SensorType:      SensorType,
+			ConditionType:   models.ConditionTypeThreshold,
+			Operator:        "OPERATOR_GREATER_THAN",
+			Threshold:       0,
+			DurationSeconds: 300,
+			Severity:        "SEVERITY_WARNING",
+			Enabled:         true,
+		},
+	}
+}
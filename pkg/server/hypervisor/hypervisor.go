@@ -0,0 +1,177 @@
+package hypervisor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
+	"gorm.io/gorm"
+)
+
+// Client metadata keys the syncer maintains
+const (
+	MetadataProvider      = "hypervisor.provider"
+	MetadataHost          = "hypervisor.host"
+	MetadataVMsRunning    = "hypervisor.vms_running"
+	MetadataVMsTotal      = "hypervisor.vms_total"
+	MetadataCPUPercent    = "hypervisor.cpu_percent"
+	MetadataMemoryPercent = "hypervisor.memory_percent"
+	// ID of the critical alert a migration was last requested for, so each
+	// alert requests one migration
+	MetadataMigrationAlert = "hypervisor.migration_alert"
+)
+
+// Host is a hypervisor host and its load
+type Host struct {
+	Provider      string
+	Name          string
+	Addresses     []string
+	VMsRunning    int
+	VMsTotal      int
+	CPUPercent    float64
+	MemoryPercent float64
+}
+
+// Provider lists the hosts of a hypervisor or cluster
+type Provider interface {
+	Name() string
+	Hosts(ctx context.Context) ([]Host, error)
+}
+
+// SyncConfig controls the hypervisor syncer
+type SyncConfig struct {
+	// Notify hypervisor.migration_requested webhooks when a host's client
+	// raises a critical alert
+	RequestMigration bool
+	Webhooks         *webhook.Dispatcher
+}
+
+// Syncer matches clients to hypervisor hosts, records each host's VM count
+// and load in its client's metadata, and asks webhooks to migrate VMs off
+// hosts with critical thermal alerts
+type Syncer struct {
+	db        *gorm.DB
+	providers []Provider
+	cfg       SyncConfig
+}
+
+func NewSyncer(db *gorm.DB, providers []Provider, cfg SyncConfig) *Syncer {
+	return &Syncer{db: db, providers: providers, cfg: cfg}
+}
+
+// RunOnce lists every provider's hosts, updates the metadata of the clients
+// they match, and requests migrations for newly critical hosts
+func (s *Syncer) RunOnce(ctx context.Context) error {
+	var hosts []Host
+	for _, provider := range s.providers {
+		// One unreachable hypervisor should not stop the others
+		found, err := provider.Hosts(ctx)
+		if err != nil {
+			log.Printf("Hypervisor sync: %s: %v", provider.Name(), err)
+			continue
+		}
+		hosts = append(hosts, found...)
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	db := s.db.WithContext(ctx)
+	var clients []models.Client
+	if err := db.Find(&clients).Error; err != nil {
+		return fmt.Errorf("failed to load clients: %w", err)
+	}
+	matched := 0
+	for i := range hosts {
+		host := &hosts[i]
+		client := match(host, clients)
+		if client == nil {
+			continue
+		}
+		matched++
+		if err := s.update(db, host, client); err != nil {
+			return err
+		}
+	}
+	log.Printf("Hypervisor sync: %d hosts, %d matched to clients", len(hosts), matched)
+	return nil
+}
+
+// match finds the client running on a host by hostname, ignoring domains,
+// then by IP address
+func match(host *Host, clients []models.Client) *models.Client {
+	name, _, _ := strings.Cut(strings.ToLower(host.Name), ".")
+	for i := range clients {
+		hostname, _, _ := strings.Cut(strings.ToLower(clients[i].Hostname), ".")
+		if hostname != "" && hostname == name {
+			return &clients[i]
+		}
+	}
+	for _, address := range host.Addresses {
+		for i := range clients {
+			if clients[i].IPAddress != "" && clients[i].IPAddress == address {
+				return &clients[i]
+			}
+		}
+	}
+	return nil
+}
+
+// update records a host in its client's metadata and, when enabled, requests
+// a migration for the client's newest critical alert
+func (s *Syncer) update(db *gorm.DB, host *Host, client *models.Client) error {
+	metadata := make(map[string]string)
+	if client.Metadata != "" {
+		json.Unmarshal([]byte(client.Metadata), &metadata)
+	}
+	updated := maps.Clone(metadata)
+	updated[MetadataProvider] = host.Provider
+	updated[MetadataHost] = host.Name
+	updated[MetadataVMsRunning] = strconv.Itoa(host.VMsRunning)
+	updated[MetadataVMsTotal] = strconv.Itoa(host.VMsTotal)
+	updated[MetadataCPUPercent] = strconv.FormatFloat(host.CPUPercent, 'f', 1, 64)
+	updated[MetadataMemoryPercent] = strconv.FormatFloat(host.MemoryPercent, 'f', 1, 64)
+
+	if s.cfg.RequestMigration && host.VMsRunning > 0 {
+		// Only alerts raised here are about the host's temperatures
+		var alert models.Alert
+		err := db.Where("client_id = ? AND is_active = ? AND severity = ? AND external_id = ?", client.ClientID, true, "SEVERITY_CRITICAL", "").
+			Order("triggered_at DESC").
+			Limit(1).
+			Find(&alert).Error
+		if err != nil {
+			return fmt.Errorf("failed to load critical alerts of client %s: %w", client.ClientID, err)
+		}
+		if alert.ID != 0 && metadata[MetadataMigrationAlert] != alert.AlertID {
+			updated[MetadataMigrationAlert] = alert.AlertID
+			if !alert.Muted {
+				log.Printf("Requesting migration of %d VMs off %s host %s for alert %s", host.VMsRunning, host.Provider, host.Name, alert.AlertID)
+				s.cfg.Webhooks.MigrationRequested(client, &alert, webhook.Hypervisor{
+					Provider:   host.Provider,
+					Host:       host.Name,
+					VMsRunning: host.VMsRunning,
+				})
+			}
+		}
+	}
+	if maps.Equal(metadata, updated) {
+		return nil
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	err = db.Model(&models.Client{}).Where("id = ?", client.ID).UpdateColumn("metadata", string(data)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update metadata of client %s: %w", client.ClientID, err)
+	}
+	client.Metadata = string(data)
+	return nil
+}
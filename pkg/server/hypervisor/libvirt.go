@@ -0,0 +1,103 @@
+package hypervisor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Libvirt reads a libvirt host through the virsh binary, which speaks the
+// libvirt RPC protocol over any transport, e.g. qemu+ssh://root@kvm1/system
+type Libvirt struct {
+	virsh string
+	uri   string
+}
+
+func NewLibvirt(virsh, uri string) (*Libvirt, error) {
+	if _, err := url.Parse(uri); err != nil || uri == "" {
+		return nil, fmt.Errorf("invalid libvirt URI %q", uri)
+	}
+	return &Libvirt{virsh: virsh, uri: uri}, nil
+}
+
+func (l *Libvirt) Name() string {
+	return "libvirt " + l.uri
+}
+
+func (l *Libvirt) Hosts(ctx context.Context) ([]Host, error) {
+	hostname, err := l.run(ctx, "hostname")
+	if err != nil {
+		return nil, err
+	}
+	all, err := l.run(ctx, "list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+	running, err := l.run(ctx, "list", "--name")
+	if err != nil {
+		return nil, err
+	}
+	host := Host{
+		Provider:   "libvirt",
+		Name:       strings.TrimSpace(hostname),
+		VMsTotal:   len(strings.Fields(all)),
+		VMsRunning: len(strings.Fields(running)),
+	}
+	if u, err := url.Parse(l.uri); err == nil && u.Hostname() != "" {
+		host.Addresses = []string{u.Hostname()}
+	}
+
+	// Load is best effort; not every driver reports it
+	if stats, err := l.run(ctx, "nodecpustats", "--percent"); err == nil {
+		if idle, ok := parseStats(stats)["idle"]; ok {
+			host.CPUPercent = 100 - idle
+		}
+	}
+	if stats, err := l.run(ctx, "nodememstats"); err == nil {
+		mem := parseStats(stats)
+		if total := mem["total"]; total > 0 {
+			host.MemoryPercent = (total - mem["free"] - mem["buffers"] - mem["cached"]) / total * 100
+		}
+	}
+	return []Host{host}, nil
+}
+
+// run runs a virsh command against the host and returns its output
+func (l *Libvirt) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, l.virsh, append([]string{"-c", l.uri}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if result := strings.TrimSpace(string(output)); result != "" {
+			return "", fmt.Errorf("virsh %s failed: %v: %s", args[0], err, result)
+		}
+		return "", fmt.Errorf("virsh %s failed: %w", args[0], err)
+	}
+	return string(output), nil
+}
+
+// parseStats reads virsh "name: value unit" lines, e.g. "idle: 97.5%" or
+// "total  : 16307656 KiB"
+func parseStats(output string) map[string]float64 {
+	stats := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "%"), 64)
+		if err != nil {
+			continue
+		}
+		stats[strings.TrimSpace(name)] = number
+	}
+	return stats
+}
@@ -0,0 +1,157 @@
+package hypervisor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProxmoxConfig is how to reach a Proxmox VE cluster's API
+type ProxmoxConfig struct {
+	URL string // e.g. https://pve1.example.com:8006
+	// API token, e.g. jacuzzi@pve!monitor, and its secret; the token needs
+	// the PVEAuditor role
+	TokenID     string
+	TokenSecret string
+	// CA bundle the API's certificate is checked against; empty uses the
+	// system roots
+	CAFile string
+	// Skip certificate verification, for the self-signed certificate Proxmox
+	// installs by default
+	InsecureSkipVerify bool
+}
+
+// Proxmox lists the nodes of a Proxmox VE cluster through its REST API
+type Proxmox struct {
+	cfg  ProxmoxConfig
+	http *http.Client
+}
+
+func NewProxmox(cfg ProxmoxConfig, timeout time.Duration) (*Proxmox, error) {
+	if cfg.URL == "" || cfg.TokenID == "" {
+		return nil, fmt.Errorf("proxmox url and token_id are required")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &Proxmox{
+		cfg: cfg,
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+func (p *Proxmox) Name() string {
+	return "proxmox " + p.cfg.URL
+}
+
+func (p *Proxmox) Hosts(ctx context.Context) ([]Host, error) {
+	var nodes []struct {
+		Node   string  `json:"node"`
+		Status string  `json:"status"`
+		CPU    float64 `json:"cpu"` // Fraction of all cores
+		Mem    float64 `json:"mem"`
+		MaxMem float64 `json:"maxmem"`
+	}
+	if err := p.get(ctx, "/nodes", &nodes); err != nil {
+		return nil, err
+	}
+	// Cluster status carries each node's address, for matching by IP
+	var members []struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		IP   string `json:"ip"`
+	}
+	if err := p.get(ctx, "/cluster/status", &members); err != nil {
+		return nil, err
+	}
+	var guests []struct {
+		Node   string `json:"node"`
+		Status string `json:"status"`
+	}
+	if err := p.get(ctx, "/cluster/resources?type=vm", &guests); err != nil {
+		return nil, err
+	}
+
+	addresses := make(map[string]string)
+	for _, member := range members {
+		if member.Type == "node" && member.IP != "" {
+			addresses[member.Name] = member.IP
+		}
+	}
+	hosts := make([]Host, 0, len(nodes))
+	index := make(map[string]int)
+	for _, node := range nodes {
+		// Offline nodes report no load, and their guests run elsewhere
+		if node.Status != "online" {
+			continue
+		}
+		host := Host{
+			Provider:   "proxmox",
+			Name:       node.Node,
+			CPUPercent: node.CPU * 100,
+		}
+		if address := addresses[node.Node]; address != "" {
+			host.Addresses = []string{address}
+		}
+		if node.MaxMem > 0 {
+			host.MemoryPercent = node.Mem / node.MaxMem * 100
+		}
+		index[node.Node] = len(hosts)
+		hosts = append(hosts, host)
+	}
+	for _, guest := range guests {
+		i, ok := index[guest.Node]
+		if !ok {
+			continue
+		}
+		hosts[i].VMsTotal++
+		if guest.Status == "running" {
+			hosts[i].VMsRunning++
+		}
+	}
+	return hosts, nil
+}
+
+// get fetches an API path and decodes the data field of the response
+func (p *Proxmox) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.URL+"/api2/json"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", p.cfg.TokenID, p.cfg.TokenSecret))
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		return fmt.Errorf("failed to get %s: %s", path, resp.Status)
+	}
+	body := struct {
+		Data interface{} `json:"data"`
+	}{Data: out}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package i18n
+
+// german translates server messages to German
+var german = map[string]string{
+	// Severities and statuses
+	"CRITICAL": "KRITISCH",
+	"WARNING":  "WARNUNG",
+	"INFO":     "INFO",
+	"RESOLVED": "BEHOBEN",
+
+	// Alert messages
+	"%s: %s is %.1f°C, above %.1f°C":                       "%s: %s ist %.1f°C, über %.1f°C",
+	"%s: %s is %.1f°C, below %.1f°C":                       "%s: %s ist %.1f°C, unter %.1f°C",
+	"%s: %s is %.1f°C, at %.1f°C":                          "%s: %s ist %.1f°C, gleich %.1f°C",
+	"%s: %s is %.1f°C, not at %.1f°C":                      "%s: %s ist %.1f°C, ungleich %.1f°C",
+	" (%s window)":                                         " (Zeitfenster %s)",
+	"sensor %s":                                            "Sensor %s",
+	"%s of 1 sensor":                                       "%s von 1 Sensor",
+	"%s of %d sensors":                                     "%s von %d Sensoren",
+	"average":                                              "Mittelwert",
+	"maximum":                                              "Maximum",
+	"minimum":                                              "Minimum",
+	"%s: sensor %s has not reported for %s":                "%s: Sensor %s hat seit %s nicht gemeldet",
+	"%s: client %s has not reported for %s":                "%s: Client %s hat seit %s nicht gemeldet",
+	"%s: sensor %s has a quality score of %.1f":            "%s: Sensor %s hat eine Qualitätsbewertung von %.1f",
+	"%.0f%% coverage":                                      "%.0f%% Abdeckung",
+	"%d quarantined readings":                              "%d Messwerte in Quarantäne",
+	"flatlined":                                            "unverändert",
+	"noisy at %.1f °C between readings":                    "verrauscht mit %.1f °C zwischen Messwerten",
+	"%s: sensor %s has been above %.1f°C for %s today":     "%[1]s: Sensor %[2]s war heute %[4]s lang über %[3].1f°C",
+	"%s: sensor %s has been above %.1f°C for %s this week": "%[1]s: Sensor %[2]s war diese Woche %[4]s lang über %[3].1f°C",
+	"[%s] %s on %s":                                        "[%s] %s auf %s",
+	"Jacuzzi test notification":                            "Jacuzzi-Testbenachrichtigung",
+	"This channel receives Jacuzzi alert notifications.":   "Dieser Kanal empfängt Jacuzzi-Alarmbenachrichtigungen.",
+	"Jacuzzi digest: 1 alert notification":                 "Jacuzzi-Zusammenfassung: 1 Alarmbenachrichtigung",
+	"Jacuzzi digest: %d alert notifications":               "Jacuzzi-Zusammenfassung: %d Alarmbenachrichtigungen",
+	"Notifications held since the last digest:":            "Seit der letzten Zusammenfassung zurückgehaltene Benachrichtigungen:",
+
+	// Notification fields
+	"Severity":  "Schweregrad",
+	"Client":    "Client",
+	"Location":  "Standort",
+	"Sensor":    "Sensor",
+	"Value":     "Wert",
+	"Triggered": "Ausgelöst",
+	"Resolved":  "Behoben",
+	"Alert":     "Alarm",
+
+	// Alerts recorded from late readings
+	" (backfilled)": " (nachgeliefert)",
+
+	// Reports
+	"Thermal report":          "Temperaturbericht",
+	"Period:":                 "Zeitraum:",
+	"%s to %s":                "%s bis %s",
+	"Generated:":              "Erstellt:",
+	"Clients:":                "Clients:",
+	"Thresholds:":             "Schwellen:",
+	"not configured":          "nicht konfiguriert",
+	"warning %s, critical %s": "Warnung %s, kritisch %s",
+	"Sensor summary (temperatures in degrees C)": "Sensorübersicht (Temperaturen in Grad C)",
+	"Readings":         "Messwerte",
+	"Min":              "Min",
+	"Avg":              "Mittel",
+	"Max":              "Max",
+	"Peak at":          "Spitze um",
+	">Warn %":          ">Warn %",
+	">Crit %":          ">Krit %",
+	"(no sensors)":     "(keine Sensoren)",
+	"Alerts by client": "Alarme nach Client",
+	"No alerts were raised during the period.": "Im Zeitraum wurden keine Alarme ausgelöst.",
+	"Alerts":  "Alarme",
+	"Message": "Meldung",
+	"... %d more alerts are listed in the CSV format.":                     "... %d weitere Alarme sind im CSV-Format aufgeführt.",
+	"Only the first %d alerts are included.":                               "Nur die ersten %d Alarme sind enthalten.",
+	"Page %d of %d":                                                        "Seite %d von %d",
+	"Thermal wear (hours above threshold, estimated over time in service)": "Thermische Abnutzung (Stunden über der Schwelle, geschätzt über die Einsatzdauer)",
+	"No duty cycles were tracked.":                                         "Es wurden keine Einschaltdauern erfasst.",
+	"Type":                                                                 "Typ",
+	"Threshold":                                                            "Schwelle",
+	"Days":                                                                 "Tage",
+	"Hours":                                                                "Stunden",
+	"Above %":                                                              "Über %",
+	"In service":                                                           "Im Einsatz",
+	"Est. hours":                                                           "Gesch. Std",
+	"%d days":                                                              "%d Tage",
+	"... %d more components are listed in the CSV format.": "... %d weitere Komponenten sind im CSV-Format aufgeführt.",
+}
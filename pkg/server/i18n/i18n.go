@@ -0,0 +1,117 @@
+// Package i18n translates the text the server writes for people, such as
+// alert messages, notifications and report headings. Messages are looked up
+// by their English format string, so untranslated messages read in English.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Locale is a supported language, by its ISO 639-1 code
+type Locale string
+
+const (
+	English Locale = "en"
+	German  Locale = "de"
+)
+
+// Default is the locale when the general.locale setting is unset
+const Default = English
+
+// Locales lists the supported locales
+var Locales = []Locale{English, German}
+
+// catalogs maps the English format strings of each locale to translations
+var catalogs = map[Locale]map[string]string{
+	German: german,
+}
+
+// Parse returns the supported locale of a language tag such as de or de-AT
+func Parse(tag string) (Locale, bool) {
+	language := strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	for _, locale := range Locales {
+		if string(locale) == language {
+			return locale, true
+		}
+	}
+	return "", false
+}
+
+// Load returns the locale of the general.locale setting, or Default when it
+// is unset or not supported
+func Load(db *gorm.DB) (Locale, error) {
+	var settings []models.Setting
+	if err := db.Where("key = ?", models.SettingLocale).Limit(1).Find(&settings).Error; err != nil {
+		return Default, fmt.Errorf("failed to query locale setting: %w", err)
+	}
+	if len(settings) == 0 {
+		return Default, nil
+	}
+	if locale, ok := Parse(settings[0].Value); ok {
+		return locale, nil
+	}
+	return Default, nil
+}
+
+// Resolve returns the locale of a language tag, or the configured one when
+// tag is empty. An unsupported tag is an error rather than a silent fallback.
+func Resolve(db *gorm.DB, tag string) (Locale, error) {
+	if tag == "" {
+		return Load(db)
+	}
+	locale, ok := Parse(tag)
+	if !ok {
+		return "", &UnsupportedError{Tag: tag}
+	}
+	return locale, nil
+}
+
+// UnsupportedError reports a language tag no supported locale matches
+type UnsupportedError struct {
+	Tag string
+}
+
+func (e *UnsupportedError) Error() string {
+	supported := make([]string, len(Locales))
+	for i, locale := range Locales {
+		supported[i] = string(locale)
+	}
+	return fmt.Sprintf("unsupported locale %q: want one of %s", e.Tag, strings.Join(supported, ", "))
+}
+
+// T translates an English format string and formats args into it like
+// fmt.Sprintf. Translations may reorder arguments with explicit indexes such
+// as %[2]s.
+func (l Locale) T(format string, args ...interface{}) string {
+	if translated, ok := catalogs[l][format]; ok {
+		format = translated
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Severity names a stored severity such as SEVERITY_CRITICAL in capitals,
+// e.g. CRITICAL
+func (l Locale) Severity(severity string) string {
+	switch severity {
+	case "SEVERITY_CRITICAL":
+		return l.T("CRITICAL")
+	case "SEVERITY_WARNING":
+		return l.T("WARNING")
+	case "SEVERITY_INFO":
+		return l.T("INFO")
+	}
+	if label := strings.TrimPrefix(severity, "SEVERITY_"); label != "" {
+		return label
+	}
+	return "UNSPECIFIED"
+}
@@ -0,0 +1,307 @@
+package interceptor
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/clientip"
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+	"github.com/nickheyer/jacuzzi/pkg/server/usage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// AgentMethods are called by agents, which authenticate with their per-client
+// API keys instead of the auth token
+var AgentMethods = map[string]bool{
+	"/jacuzzi.v1.ClientService/EnrollClient":           true,
+	"/jacuzzi.v1.ClientService/RegisterClient":         true,
+	"/jacuzzi.v1.TemperatureService/SubmitTemperature": true,
+	"/jacuzzi.v1.CommandService/PollCommands":          true,
+	"/jacuzzi.v1.CommandService/ReportCommandResult":   true,
+}
+
+// serverCodes are the failures logged even when requests are not, since
+// they point at the server rather than the caller
+var serverCodes = map[codes.Code]bool{
+	codes.Unknown:          true,
+	codes.Internal:         true,
+	codes.DataLoss:         true,
+	codes.Unavailable:      true,
+	codes.DeadlineExceeded: true,
+}
+
+var (
+	requests = metrics.NewCounterVec(
+		"jacuzzi_grpc_requests_total",
+		"RPCs handled, by method and status code.",
+		"method", "code",
+	)
+	requestSeconds = metrics.NewCounterVec(
+		"jacuzzi_grpc_request_seconds_total",
+		"Time spent handling RPCs, by method; divide by jacuzzi_grpc_requests_total for the mean.",
+		"method",
+	)
+	panics = metrics.NewCounterVec(
+		"jacuzzi_grpc_panics_total",
+		"RPC handlers that panicked, by method.",
+		"method",
+	)
+)
+
+// Config controls the interceptor chain
+type Config struct {
+	// Bearer token required in the authorization metadata of every RPC but
+	// AgentMethods; empty disables the check
+	AuthToken string
+	// Requests per minute allowed from each caller address; 0 disables
+	RateLimit int
+	// Log every RPC, not only server failures
+	LogRequests bool
+	// Deadline for each unary RPC; 0 leaves only the caller's deadline.
+	// Streams are long-lived and not bounded.
+	Timeout time.Duration
+	// Finds the caller's address behind trusted proxies; nil uses the peer
+	ClientIP *clientip.Resolver
+	// Counts RPCs by method and caller for usage stats; nil disables
+	Usage *usage.Recorder
+}
+
+// Chain builds the interceptors every RPC passes through, outermost first:
+// panic recovery, error normalization, logging, metrics, auth, rate
+// limiting, and deadlines
+type Chain struct {
+	cfg     Config
+	limiter *limiter
+}
+
+func NewChain(cfg Config) *Chain {
+	return &Chain{cfg: cfg, limiter: newLimiter(cfg.RateLimit)}
+}
+
+// Unary intercepts unary RPCs, for grpc.UnaryInterceptor and the JSON gateway
+func (c *Chain) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, r)
+		}
+		err = apierror.Normalize(err)
+		c.observe(ctx, info.FullMethod, start, err)
+		c.cfg.Usage.Record(info.FullMethod, c.callerAddr(ctx), err != nil, messageSize(req), messageSize(resp))
+	}()
+
+	if err := c.admit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	if c.cfg.Timeout > 0 {
+		// The earlier of this and the caller's deadline applies
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	resp, err = handler(ctx, req)
+	return resp, contextError(ctx, err)
+}
+
+// Stream intercepts streaming RPCs, for grpc.StreamInterceptor and the JSON
+// gateway's WebSockets. The stream is admitted once, when it opens.
+func (c *Chain) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	counted := &countingStream{ServerStream: ss}
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, r)
+		}
+		err = apierror.Normalize(err)
+		c.observe(ss.Context(), info.FullMethod, start, err)
+		c.cfg.Usage.Record(info.FullMethod, c.callerAddr(ss.Context()), err != nil, counted.received, counted.sent)
+	}()
+	if c.cfg.Usage != nil {
+		ss = counted
+	}
+
+	if err := c.admit(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// countingStream adds up the encoded size of the messages on a stream
+type countingStream struct {
+	grpc.ServerStream
+	received, sent int64
+}
+
+func (s *countingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received += messageSize(m)
+	}
+	return err
+}
+
+func (s *countingStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent += messageSize(m)
+	}
+	return err
+}
+
+// messageSize is the encoded size of a protobuf message, or 0 for nil
+func messageSize(m interface{}) int64 {
+	if msg, ok := m.(proto.Message); ok && msg != nil {
+		return int64(proto.Size(msg))
+	}
+	return 0
+}
+
+// contextError reports handlers that failed because their context ended,
+// which services wrap as Internal, as DeadlineExceeded or Canceled
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	st := status.Convert(err)
+	if st.Code() != codes.Internal && st.Code() != codes.Unknown {
+		return err
+	}
+	return status.Error(status.FromContextError(ctx.Err()).Code(), st.Message())
+}
+
+// recovered logs a handler panic with its stack and turns it into an
+// Internal error, keeping the panic's details from the caller
+func recovered(method string, r interface{}) error {
+	panics.Inc(method)
+	log.Printf("Panic in %s: %v\n%s", method, r, debug.Stack())
+	return status.Error(codes.Internal, "internal server error")
+}
+
+// observe logs and counts a finished RPC
+func (c *Chain) observe(ctx context.Context, method string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	code := status.Code(err)
+	requests.Inc(method, code.String())
+	requestSeconds.Add(elapsed.Seconds(), method)
+
+	switch {
+	case err != nil && (c.cfg.LogRequests || serverCodes[code]):
+		log.Printf("RPC %s from %s failed after %s: %v", method, c.callerAddr(ctx), elapsed.Round(time.Microsecond), err)
+	case c.cfg.LogRequests:
+		log.Printf("RPC %s from %s: %s in %s", method, c.callerAddr(ctx), code, elapsed.Round(time.Microsecond))
+	}
+}
+
+// admit checks the auth token and rate limit of an RPC
+func (c *Chain) admit(ctx context.Context, method string) error {
+	if c.cfg.AuthToken != "" && !AgentMethods[method] {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if !c.Authorized(md.Get("authorization")) {
+			return status.Error(codes.Unauthenticated, "missing or invalid auth token")
+		}
+	}
+	if !c.limiter.Allow(c.callerAddr(ctx), time.Now()) {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded; retry later")
+	}
+	return nil
+}
+
+// Authorized reports whether authorization values carry the auth token
+func (c *Chain) Authorized(values []string) bool {
+	if c.cfg.AuthToken == "" {
+		return true
+	}
+	for _, value := range values {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(c.cfg.AuthToken)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireAuth wraps HTTP handlers that expose data outside the gRPC services,
+// such as GraphQL, with the auth token check
+func (c *Chain) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.Authorized(r.Header.Values("Authorization")) {
+			http.Error(w, "missing or invalid auth token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// callerAddr is the caller's IP address, or "unknown"
+func (c *Chain) callerAddr(ctx context.Context) string {
+	if addr := c.cfg.ClientIP.FromContext(ctx); addr != "" {
+		return addr
+	}
+	return "unknown"
+}
+
+// limiter is a per-caller token bucket holding a minute of requests
+type limiter struct {
+	perMinute float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(perMinute int) *limiter {
+	return &limiter{perMinute: float64(perMinute), buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token for a caller, reporting whether one was left
+func (l *limiter) Allow(caller string, now time.Time) bool {
+	if l.perMinute <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Buckets idle for a minute are full again, so they can be dropped
+	if now.Sub(l.lastPrune) > time.Minute {
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > time.Minute {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[caller]
+	if !ok {
+		b = &bucket{tokens: l.perMinute, last: now}
+		l.buckets[caller] = b
+	} else {
+		b.tokens += now.Sub(b.last).Minutes() * l.perMinute
+		if b.tokens > l.perMinute {
+			b.tokens = l.perMinute
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
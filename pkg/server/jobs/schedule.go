@@ -0,0 +1,95 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/cron"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"gorm.io/gorm"
+)
+
+// Schedule decides when a job runs
+type Schedule interface {
+	// Next returns when the job runs after a run that started at last, which
+	// is zero if the job has never run, or the zero time if it only runs on
+	// demand
+	Next(db *gorm.DB, last time.Time) time.Time
+	String() string
+}
+
+// Parse parses a configured schedule: a duration such as 5m, a five field
+// cron expression such as "0 3 * * *", or off to only run on demand
+func Parse(text string) (Schedule, error) {
+	if text == "off" {
+		return off{}, nil
+	}
+	if d, err := time.ParseDuration(text); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: must be positive", text)
+		}
+		return Every(d), nil
+	}
+	schedule, err := cron.Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: must be a duration, cron expression or off: %w", text, err)
+	}
+	return cronSchedule{expr: text, schedule: schedule}, nil
+}
+
+// Every runs a job at a fixed interval, and at once if it has never run. It
+// panics if interval is not positive, which would run the job without pause;
+// configured intervals are validated when the configuration is loaded.
+func Every(interval time.Duration) Schedule {
+	if interval <= 0 {
+		panic(fmt.Sprintf("jobs: non-positive interval %s", interval))
+	}
+	return every(interval)
+}
+
+type every time.Duration
+
+func (e every) Next(_ *gorm.DB, last time.Time) time.Time {
+	if last.IsZero() {
+		return time.Now()
+	}
+	return last.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return "every " + time.Duration(e).String()
+}
+
+// cronSchedule runs a job at the times of a cron expression in the
+// general.timezone setting's timezone, so "0 3 * * *" runs at 3am local time
+type cronSchedule struct {
+	expr     string
+	schedule *cron.Schedule
+}
+
+func (c cronSchedule) Next(db *gorm.DB, last time.Time) time.Time {
+	loc, err := timezone.Load(db)
+	if err != nil {
+		loc = time.UTC
+	}
+	// A job that has never run waits for its first time rather than running
+	// at once
+	if last.IsZero() {
+		last = time.Now()
+	}
+	return c.schedule.Next(last.In(loc))
+}
+
+func (c cronSchedule) String() string {
+	return "cron " + c.expr
+}
+
+type off struct{}
+
+func (off) Next(*gorm.DB, time.Time) time.Time {
+	return time.Time{}
+}
+
+func (off) String() string {
+	return "off"
+}
@@ -0,0 +1,249 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrNotRunning = errors.New("background jobs do not run on this server; with HA enabled they run on the leader")
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// Job is a background task run on a schedule, such as rollups or alert
+// evaluation
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on their schedules, each in its own goroutine, and
+// records their runs in the jobs table
+type Scheduler struct {
+	db        *gorm.DB
+	overrides map[string]Schedule
+	jobs      []*entry
+
+	mu  sync.Mutex
+	ctx context.Context // Of Run, while it runs
+}
+
+type entry struct {
+	Job
+	mu      sync.Mutex // Held during a run
+	failing bool       // The latest run failed
+}
+
+// NewScheduler returns a scheduler that runs the jobs named in overrides on
+// those schedules instead of their own
+func NewScheduler(db *gorm.DB, overrides map[string]Schedule) *Scheduler {
+	return &Scheduler{db: db, overrides: overrides}
+}
+
+// Register adds a job. Jobs must be registered before Run.
+func (s *Scheduler) Register(job Job) {
+	if schedule, ok := s.overrides[job.Name]; ok {
+		job.Schedule = schedule
+	}
+	s.jobs = append(s.jobs, &entry{Job: job})
+}
+
+// Run runs every job on its schedule until ctx is done. Under HA only the
+// leader runs it.
+func (s *Scheduler) Run(ctx context.Context) {
+	for name := range s.overrides {
+		if s.find(name) == nil {
+			log.Printf("Ignoring schedule of unknown job %s", name)
+		}
+	}
+	lastRuns, err := s.prepare(s.db.WithContext(ctx))
+	if err != nil {
+		log.Printf("Failed to load job records: %v", err)
+	}
+
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, e, lastRuns[e.Name])
+		}()
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	s.ctx = nil
+	s.mu.Unlock()
+}
+
+// RunNow runs a job at once and waits for it to finish. If ctx is done
+// first the run carries on in the background.
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	e := s.find(name)
+	if e == nil {
+		return ErrUnknownJob
+	}
+	s.mu.Lock()
+	runCtx := s.ctx
+	s.mu.Unlock()
+	if runCtx == nil {
+		return ErrNotRunning
+	}
+	if !e.mu.TryLock() {
+		return ErrJobRunning
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer e.mu.Unlock()
+		s.run(runCtx, e)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) find(name string) *entry {
+	for _, e := range s.jobs {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// prepare creates the records of new jobs, updates their schedules, clears
+// runs cut short by a crash, and removes the records of jobs no longer
+// registered, such as a disabled Kubernetes sync. It returns when each job
+// last ran.
+func (s *Scheduler) prepare(db *gorm.DB) (map[string]time.Time, error) {
+	lastRuns := make(map[string]time.Time, len(s.jobs))
+	names := make([]string, len(s.jobs))
+	for i, e := range s.jobs {
+		names[i] = e.Name
+		record := models.Job{Name: e.Name}
+		if err := db.Where("name = ?", e.Name).FirstOrCreate(&record).Error; err != nil {
+			return lastRuns, fmt.Errorf("failed to load job %s: %w", e.Name, err)
+		}
+		err := db.Model(&record).Updates(map[string]interface{}{
+			"schedule": e.Schedule.String(),
+			"running":  false,
+		}).Error
+		if err != nil {
+			return lastRuns, fmt.Errorf("failed to update job %s: %w", e.Name, err)
+		}
+		e.failing = record.LastError != ""
+		if record.LastRunAt != nil {
+			lastRuns[e.Name] = *record.LastRunAt
+		}
+	}
+	if err := db.Where("name NOT IN ?", names).Delete(&models.Job{}).Error; err != nil {
+		return lastRuns, fmt.Errorf("failed to remove old jobs: %w", err)
+	}
+	return lastRuns, nil
+}
+
+// loop runs a job whenever its schedule says until ctx is done. A job whose
+// run was missed while the server was down runs at once.
+func (s *Scheduler) loop(ctx context.Context, e *entry, last time.Time) {
+	db := s.db.WithContext(ctx)
+	for {
+		next := e.Schedule.Next(db, last)
+		var nextRun *time.Time
+		if !next.IsZero() {
+			nextRun = &next
+		}
+		if err := db.Model(&models.Job{}).Where("name = ?", e.Name).Update("next_run_at", nextRun).Error; err != nil && ctx.Err() == nil {
+			log.Printf("Failed to record next run of job %s: %v", e.Name, err)
+		}
+
+		// Jobs that only run on demand wait for shutdown
+		if nextRun == nil {
+			<-ctx.Done()
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		e.mu.Lock()
+		last = time.Now()
+		s.run(ctx, e)
+		e.mu.Unlock()
+	}
+}
+
+// run runs a job and records the outcome. The caller holds e.mu.
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	started := time.Now()
+	err := s.db.Model(&models.Job{}).Where("name = ?", e.Name).Updates(map[string]interface{}{
+		"running":     true,
+		"last_run_at": started,
+	}).Error
+	if err != nil {
+		log.Printf("Failed to record run of job %s: %v", e.Name, err)
+	}
+
+	err = e.Run(ctx)
+	// The bookkeeping below ignores ctx, so a run cut short by shutdown is
+	// not left marked running
+	updates := map[string]interface{}{"running": false}
+	if ctx.Err() == nil {
+		updates["last_duration"] = time.Since(started).Milliseconds()
+		updates["run_count"] = gorm.Expr("run_count + 1")
+		if err != nil {
+			log.Printf("Job %s failed: %v", e.Name, err)
+			updates["last_error"] = err.Error()
+			updates["failure_count"] = gorm.Expr("failure_count + 1")
+		} else {
+			updates["last_error"] = ""
+			updates["last_success_at"] = started
+		}
+	}
+	if err := s.db.Model(&models.Job{}).Where("name = ?", e.Name).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record run of job %s: %v", e.Name, err)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Record when a job starts failing and when it recovers rather than
+	// every run
+	switch {
+	case err != nil && !e.failing:
+		activity.Record(s.db, activity.Event{
+			Type:    activity.JobFailed,
+			Message: fmt.Sprintf("Job %s failed: %v", e.Name, err),
+			Details: map[string]string{"job": e.Name},
+		})
+	case err == nil && e.failing:
+		activity.Record(s.db, activity.Event{
+			Type:    activity.JobRecovered,
+			Message: fmt.Sprintf("Job %s recovered", e.Name),
+			Details: map[string]string{"job": e.Name},
+		})
+	}
+	e.failing = err != nil
+}
@@ -0,0 +1,217 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// In-cluster service account credentials, mounted into every pod
+const (
+	DefaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Patch content types understood by the API server
+const (
+	mergePatch          = "application/merge-patch+json"
+	strategicMergePatch = "application/strategic-merge-patch+json"
+)
+
+// Node is the part of a Kubernetes node the integration reads
+type Node struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Labels          map[string]string `json:"labels"`
+		ResourceVersion string            `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []Taint `json:"taints"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []Condition `json:"conditions"`
+		Addresses  []struct {
+			Type    string `json:"type"` // Hostname, InternalIP or ExternalIP
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+// Taint repels pods that do not tolerate it from a node
+type Taint struct {
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	Effect    string `json:"effect"`
+	TimeAdded string `json:"timeAdded,omitempty"`
+}
+
+// Condition is an entry in a node's status conditions
+type Condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"` // True, False or Unknown
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastHeartbeatTime  string `json:"lastHeartbeatTime,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// ClientConfig is how to reach the Kubernetes API
+type ClientConfig struct {
+	// API server URL; empty uses the in-cluster service address, along with
+	// the pod's service account token and CA bundle unless others are set
+	APIServer string
+	// Bearer token file, re-read on every request since projected tokens rotate
+	TokenFile string
+	// CA bundle the API server's certificate is checked against; empty uses
+	// the system roots
+	CAFile  string
+	Timeout time.Duration
+}
+
+// Client is a minimal Kubernetes API client for the node resources the
+// integration uses
+type Client struct {
+	server    string
+	tokenFile string
+	http      *http.Client
+}
+
+func NewClient(cfg ClientConfig) (*Client, error) {
+	server := cfg.APIServer
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("no API server is configured and not running in a cluster")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+		if cfg.TokenFile == "" {
+			cfg.TokenFile = DefaultTokenFile
+		}
+		if cfg.CAFile == "" {
+			cfg.CAFile = DefaultCAFile
+		}
+	}
+	if _, err := url.Parse(server); err != nil {
+		return nil, fmt.Errorf("invalid API server URL %q: %w", server, err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+
+	return &Client{
+		server:    strings.TrimSuffix(server, "/"),
+		tokenFile: cfg.TokenFile,
+		http: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+// ListNodes returns the nodes matching a label selector, or all when empty
+func (c *Client) ListNodes(ctx context.Context, selector string) ([]Node, error) {
+	path := "/api/v1/nodes"
+	if selector != "" {
+		path += "?labelSelector=" + url.QueryEscape(selector)
+	}
+	var list struct {
+		Items []Node `json:"items"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &list); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	return list.Items, nil
+}
+
+// SetTaints replaces a node's taints. The patch carries the resource version
+// the taints were read at, so a concurrent change by another controller fails
+// with a conflict instead of being overwritten.
+func (c *Client) SetTaints(ctx context.Context, node *Node, taints []Taint) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": node.Metadata.ResourceVersion},
+		"spec":     map[string]interface{}{"taints": taints},
+	}
+	if err := c.do(ctx, http.MethodPatch, "/api/v1/nodes/"+url.PathEscape(node.Metadata.Name), mergePatch, patch, nil); err != nil {
+		return fmt.Errorf("failed to set taints on node %s: %w", node.Metadata.Name, err)
+	}
+	return nil
+}
+
+// SetCondition adds or updates one of a node's status conditions, leaving the
+// others, which the kubelet owns, alone
+func (c *Client) SetCondition(ctx context.Context, node string, condition Condition) error {
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []Condition{condition}},
+	}
+	if err := c.do(ctx, http.MethodPatch, "/api/v1/nodes/"+url.PathEscape(node)+"/status", strategicMergePatch, patch, nil); err != nil {
+		return fmt.Errorf("failed to set condition %s on node %s: %w", condition.Type, node, err)
+	}
+	return nil
+}
+
+// do sends a request to the API server and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path, contentType string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		// Kubernetes errors are Status objects with a readable message
+		var status struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &status) == nil && status.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, status.Message)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,307 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Client metadata keys the syncer maintains
+const (
+	MetadataNode        = "k8s.node"   // Name of the node the client runs on
+	MetadataLabelPrefix = "k8s.label." // Followed by a node label key
+)
+
+// Marks set on nodes with an active critical alert
+const (
+	ConditionType = "JacuzziThermalCritical"
+	TaintKey      = "jacuzzi.io/thermal-critical"
+)
+
+// Taint effects a node can be given
+var TaintEffects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+// SyncConfig controls what the syncer reads from and writes to the cluster
+type SyncConfig struct {
+	// Only nodes matching this label selector are synced; empty is all
+	LabelSelector string
+	// Copy node labels into client metadata
+	ImportLabels bool
+	// Set a node condition while the node's client has an active critical alert
+	SetCondition bool
+	// Taint nodes while their client has an active critical alert; empty disables
+	TaintEffect string
+}
+
+// Syncer correlates clients with the Kubernetes nodes they run on, copies
+// node labels into client metadata, and marks nodes with critical thermal
+// alerts so the scheduler can avoid them
+type Syncer struct {
+	db     *gorm.DB
+	client *Client
+	cfg    SyncConfig
+}
+
+func NewSyncer(db *gorm.DB, client *Client, cfg SyncConfig) *Syncer {
+	return &Syncer{db: db, client: client, cfg: cfg}
+}
+
+// RunOnce lists nodes, matches them to clients, updates client metadata, and
+// sets or clears the condition and taint of each matched node
+func (s *Syncer) RunOnce(ctx context.Context, now time.Time) error {
+	nodes, err := s.client.ListNodes(ctx, s.cfg.LabelSelector)
+	if err != nil {
+		return err
+	}
+	db := s.db.WithContext(ctx)
+	var clients []models.Client
+	if err := db.Find(&clients).Error; err != nil {
+		return fmt.Errorf("failed to load clients: %w", err)
+	}
+
+	matches := match(nodes, clients)
+	if len(matches) == 0 {
+		return nil
+	}
+	critical, err := s.criticalAlerts(db, matches)
+	if err != nil {
+		return err
+	}
+
+	marked := 0
+	for i := range nodes {
+		node := &nodes[i]
+		client, ok := matches[node.Metadata.Name]
+		if !ok {
+			continue
+		}
+		if err := s.updateMetadata(db, node, client); err != nil {
+			return err
+		}
+		alert, isCritical := critical[client.ClientID]
+		if isCritical {
+			marked++
+		}
+		// A node that cannot be marked should not stop the others
+		if s.cfg.SetCondition {
+			if err := s.setCondition(ctx, node, alert, isCritical, now); err != nil {
+				log.Printf("Kubernetes sync: %v", err)
+			}
+		}
+		if s.cfg.TaintEffect != "" {
+			if err := s.setTaint(ctx, node, isCritical, now); err != nil {
+				log.Printf("Kubernetes sync: %v", err)
+			}
+		}
+	}
+	log.Printf("Kubernetes sync: %d nodes, %d matched to clients, %d with critical alerts", len(nodes), len(matches), marked)
+	return nil
+}
+
+// match pairs nodes with clients by node name: the name an agent in a
+// DaemonSet reports, then the client's hostname, then its IP address
+func match(nodes []Node, clients []models.Client) map[string]*models.Client {
+	byNode := make(map[string]*models.Client)
+	byHostname := make(map[string]*models.Client)
+	byIP := make(map[string]*models.Client)
+	for i := range clients {
+		client := &clients[i]
+		if node := decodeMetadata(client.Metadata)[MetadataNode]; node != "" {
+			byNode[node] = client
+		}
+		if client.Hostname != "" {
+			byHostname[strings.ToLower(client.Hostname)] = client
+		}
+		if client.IPAddress != "" {
+			byIP[client.IPAddress] = client
+		}
+	}
+
+	matches := make(map[string]*models.Client)
+	for _, node := range nodes {
+		name := node.Metadata.Name
+		if client, ok := byNode[name]; ok {
+			matches[name] = client
+			continue
+		}
+		candidates := []string{name}
+		for _, address := range node.Status.Addresses {
+			if address.Type == "Hostname" {
+				candidates = append(candidates, address.Address)
+			}
+		}
+		for _, candidate := range candidates {
+			candidate = strings.ToLower(candidate)
+			// Nodes are often named by FQDN while agents report short hostnames
+			short, _, _ := strings.Cut(candidate, ".")
+			if client, ok := byHostname[candidate]; ok {
+				matches[name] = client
+				break
+			}
+			if client, ok := byHostname[short]; ok {
+				matches[name] = client
+				break
+			}
+		}
+		if _, ok := matches[name]; ok {
+			continue
+		}
+		for _, address := range node.Status.Addresses {
+			if address.Type != "InternalIP" && address.Type != "ExternalIP" {
+				continue
+			}
+			if client, ok := byIP[address.Address]; ok {
+				matches[name] = client
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// criticalAlerts returns the newest active critical alert of each matched
+// client that has one
+func (s *Syncer) criticalAlerts(db *gorm.DB, matches map[string]*models.Client) (map[string]models.Alert, error) {
+	ids := make([]string, 0, len(matches))
+	for _, client := range matches {
+		ids = append(ids, client.ClientID)
+	}
+	// Alerts received from elsewhere say nothing about the node's
+	// temperatures
+	var alerts []models.Alert
+	err := db.Where("is_active = ? AND severity = ? AND external_id = ? AND client_id IN ?", true, "SEVERITY_CRITICAL", "", ids).
+		Order("triggered_at").
+		Find(&alerts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load critical alerts: %w", err)
+	}
+	critical := make(map[string]models.Alert, len(alerts))
+	for _, alert := range alerts {
+		critical[alert.ClientID] = alert
+	}
+	return critical, nil
+}
+
+// updateMetadata records the node name and, when enabled, the node's labels
+// in the client's metadata, dropping labels the node no longer has
+func (s *Syncer) updateMetadata(db *gorm.DB, node *Node, client *models.Client) error {
+	metadata := decodeMetadata(client.Metadata)
+	updated := maps.Clone(metadata)
+	updated[MetadataNode] = node.Metadata.Name
+	if s.cfg.ImportLabels {
+		for key := range updated {
+			if strings.HasPrefix(key, MetadataLabelPrefix) {
+				delete(updated, key)
+			}
+		}
+		for key, value := range node.Metadata.Labels {
+			updated[MetadataLabelPrefix+key] = value
+		}
+	}
+	if maps.Equal(metadata, updated) {
+		return nil
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	err = db.Model(&models.Client{}).Where("id = ?", client.ID).UpdateColumn("metadata", string(data)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update metadata of client %s: %w", client.ClientID, err)
+	}
+	client.Metadata = string(data)
+	return nil
+}
+
+// setCondition sets the node's thermal condition when its status or message
+// changed
+func (s *Syncer) setCondition(ctx context.Context, node *Node, alert models.Alert, critical bool, now time.Time) error {
+	condition := Condition{
+		Type:    ConditionType,
+		Status:  "False",
+		Reason:  "NoCriticalAlerts",
+		Message: "No critical thermal alerts are active",
+	}
+	if critical {
+		condition.Status = "True"
+		condition.Reason = "CriticalAlertActive"
+		condition.Message = fmt.Sprintf("Critical thermal alert on sensor %s since %s", alert.SensorID, alert.TriggeredAt.UTC().Format(time.RFC3339))
+		if alert.Message != "" {
+			condition.Message += ": " + alert.Message
+		}
+	}
+
+	current := "False" // A node without the condition has never been marked
+	for _, existing := range node.Status.Conditions {
+		if existing.Type == ConditionType {
+			current = existing.Status
+			if existing.Message == condition.Message {
+				return nil
+			}
+		}
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+	condition.LastHeartbeatTime = timestamp
+	if current != condition.Status {
+		condition.LastTransitionTime = timestamp
+	}
+	if err := s.client.SetCondition(ctx, node.Metadata.Name, condition); err != nil {
+		return err
+	}
+	if current != condition.Status {
+		log.Printf("Set condition %s=%s on node %s", ConditionType, condition.Status, node.Metadata.Name)
+	}
+	return nil
+}
+
+// setTaint adds the thermal taint to a node with a critical alert, and
+// removes it once the node has none
+func (s *Syncer) setTaint(ctx context.Context, node *Node, critical bool, now time.Time) error {
+	taints := make([]Taint, 0, len(node.Spec.Taints)+1)
+	tainted := false
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == TaintKey {
+			tainted = true
+			continue
+		}
+		taints = append(taints, taint)
+	}
+	if tainted == critical {
+		return nil
+	}
+	if critical {
+		taints = append(taints, Taint{
+			Key:       TaintKey,
+			Value:     "true",
+			Effect:    s.cfg.TaintEffect,
+			TimeAdded: now.UTC().Format(time.RFC3339),
+		})
+	}
+	if err := s.client.SetTaints(ctx, node, taints); err != nil {
+		return err
+	}
+	if critical {
+		log.Printf("Tainted node %s with %s:%s", node.Metadata.Name, TaintKey, s.cfg.TaintEffect)
+	} else {
+		log.Printf("Removed taint %s from node %s", TaintKey, node.Metadata.Name)
+	}
+	return nil
+}
+
+// decodeMetadata parses a client's metadata JSON, treating unreadable
+// metadata as empty
+func decodeMetadata(metadata string) map[string]string {
+	decoded := make(map[string]string)
+	if metadata != "" {
+		json.Unmarshal([]byte(metadata), &decoded)
+	}
+	return decoded
+}
@@ -0,0 +1,129 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Elector uses a row in the leases table so that only one server instance
+// sharing a database runs background jobs at a time. The holder renews the
+// lease every third of its TTL; if it stops renewing, another instance takes
+// over once the lease expires.
+type Elector struct {
+	db       *gorm.DB
+	name     string
+	holder   string
+	ttl      time.Duration
+	isLeader atomic.Bool
+}
+
+func NewElector(db *gorm.DB, name, holder string, ttl time.Duration) *Elector {
+	return &Elector{db: db, name: name, holder: holder, ttl: ttl}
+}
+
+// IsLeader reports whether this instance currently holds the lease
+func (e *Elector) IsLeader() bool {
+	return e.isLeader.Load()
+}
+
+// Run campaigns for the lease until ctx is done. Each time leadership is
+// gained, onElected is called with a context that is cancelled when the lease
+// is lost or ctx is done; it should start its work and return.
+func (e *Elector) Run(ctx context.Context, onElected func(ctx context.Context)) {
+	defer e.release()
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := e.tryAcquire(ctx)
+		if err != nil {
+			log.Printf("Leader election for %s failed: %v", e.name, err)
+		}
+		if acquired {
+			e.lead(ctx, ticker, onElected)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs onElected and keeps renewing the lease until it is lost or ctx is done
+func (e *Elector) lead(ctx context.Context, ticker *time.Ticker, onElected func(ctx context.Context)) {
+	log.Printf("Acquired %s leadership as %s", e.name, e.holder)
+	e.isLeader.Store(true)
+	defer e.isLeader.Store(false)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	onElected(leaderCtx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		acquired, err := e.tryAcquire(ctx)
+		if err != nil {
+			log.Printf("Leader election for %s failed: %v", e.name, err)
+		}
+		if !acquired {
+			log.Printf("Lost %s leadership", e.name)
+			return
+		}
+	}
+}
+
+// tryAcquire takes or renews the lease, returning whether this instance holds it
+func (e *Elector) tryAcquire(ctx context.Context) (bool, error) {
+	db := e.db.WithContext(ctx)
+	now := time.Now()
+
+	// Make sure the lease row exists, then claim it if it is ours or expired
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Lease{
+		Name:      e.name,
+		Holder:    e.holder,
+		ExpiresAt: now.Add(e.ttl),
+	}).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to create lease: %w", err)
+	}
+
+	result := db.Model(&models.Lease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", e.name, e.holder, now).
+		Updates(map[string]interface{}{
+			"holder":     e.holder,
+			"expires_at": now.Add(e.ttl),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to renew lease: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// release expires the lease if this instance holds it so another can take over
+// without waiting for the TTL
+func (e *Elector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := e.db.WithContext(ctx).Model(&models.Lease{}).
+		Where("name = ? AND holder = ?", e.name, e.holder).
+		Update("expires_at", time.Now()).Error
+	if err != nil {
+		log.Printf("Failed to release %s lease: %v", e.name, err)
+	}
+}
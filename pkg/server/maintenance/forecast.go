@@ -0,0 +1,186 @@
+package maintenance
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/disk"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// With fewer rows than this the database's size says more about its empty
+// tables than about its rows, so rows are assumed to take estimatedRowBytes
+const minMeasuredRows = 10000
+
+// Bytes a reading or rollup takes with its indexes, measured on SQLite
+const estimatedRowBytes = 400
+
+// Storage runs out later than this is not projected
+const maxForecastDays = 100 * 365
+
+// Forecast projects the database's growth from the last day's ingest rate
+type Forecast struct {
+	UsedBytes         int64 // In use now; SQLite's free pages are excluded as they are reused first
+	ReadingsPerDay    int64 // Ingested in the last 24 hours
+	RollupsPerDay     int64 // Rollup buckets created in the last 24 hours
+	BytesPerRow       int64 // Measured, or estimated for small databases
+	GrowthBytesPerDay int64 // Current growth, which drops once retention starts pruning
+	RetentionDays     int
+	SteadyAt          *time.Time // When pruning starts balancing ingest; nil if it already does
+	ProjectedAt       time.Time
+	ProjectedBytes    int64
+	CapacityBytes     int64      // 0 when neither a quota nor the free disk space is known
+	CapacityLimit     string     // disk or quota
+	FullAt            *time.Time // When the database outgrows its capacity; nil if not projected to
+	Warning           string     // Set when the database outgrows its capacity before ProjectedAt
+}
+
+// GetForecast projects the database's size days from now. Readings are kept
+// for the retention period while rollups are kept forever, so growth slows
+// once the oldest readings are pruned but does not stop. quota is the
+// database.size_quota config, 0 for none.
+func GetForecast(db *gorm.DB, quota int64, days int) (*Forecast, error) {
+	now := time.Now()
+	dayAgo := now.Add(-24 * time.Hour).UTC()
+	forecast := &Forecast{ProjectedAt: now.AddDate(0, 0, days)}
+
+	stats, err := GetStats(db)
+	if err != nil {
+		return nil, err
+	}
+	forecast.UsedBytes = stats.SizeBytes - stats.FreeBytes
+	var readings, rollups int64
+	for _, table := range stats.Tables {
+		switch table.Name {
+		case models.TemperatureReading{}.TableName():
+			readings = table.Rows
+		case models.TemperatureRollup{}.TableName():
+			rollups = table.Rows
+		}
+	}
+
+	// Readings by ingest time, as late data takes space all the same
+	if err := db.Model(&models.TemperatureReading{}).Where("updated_at > ?", dayAgo).Count(&forecast.ReadingsPerDay).Error; err != nil {
+		return nil, fmt.Errorf("failed to count ingested readings: %w", err)
+	}
+	if err := db.Model(&models.TemperatureRollup{}).Where("created_at > ?", dayAgo).Count(&forecast.RollupsPerDay).Error; err != nil {
+		return nil, fmt.Errorf("failed to count new rollups: %w", err)
+	}
+
+	forecast.BytesPerRow = estimatedRowBytes
+	if rows := readings + rollups; rows >= minMeasuredRows {
+		forecast.BytesPerRow = forecast.UsedBytes / rows
+		if stats.Backend == "postgres" {
+			var tableBytes int64
+			if err := db.Raw("SELECT pg_total_relation_size(?)", models.TemperatureReading{}.TableName()).Scan(&tableBytes).Error; err != nil {
+				return nil, fmt.Errorf("failed to query readings size: %w", err)
+			}
+			if readings > 0 {
+				forecast.BytesPerRow = tableBytes / readings
+			}
+		}
+	}
+
+	forecast.RetentionDays, err = RetentionDays(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Readings grow until the retention period is full, rollups for good
+	readingGrowth := float64(forecast.ReadingsPerDay * forecast.BytesPerRow)
+	rollupGrowth := float64(forecast.RollupsPerDay * forecast.BytesPerRow)
+	var filling float64 // Days until pruning balances ingest
+	if retained := forecast.ReadingsPerDay * int64(forecast.RetentionDays); retained > readings && forecast.ReadingsPerDay > 0 {
+		filling = float64(retained-readings) / float64(forecast.ReadingsPerDay)
+		steadyAt := now.Add(daysDuration(filling))
+		forecast.SteadyAt = &steadyAt
+		forecast.GrowthBytesPerDay = int64(readingGrowth)
+	}
+	forecast.GrowthBytesPerDay += int64(rollupGrowth)
+
+	sizeAfter := func(d float64) float64 {
+		return float64(forecast.UsedBytes) + readingGrowth*math.Min(d, filling) + rollupGrowth*d
+	}
+	forecast.ProjectedBytes = int64(sizeAfter(float64(days)))
+
+	if err := forecastCapacity(db, forecast, stats, quota); err != nil {
+		return nil, err
+	}
+	if forecast.CapacityBytes == 0 {
+		return forecast, nil
+	}
+
+	// Solve sizeAfter(d) = capacity over the filling period, then after it
+	var full float64
+	remaining := float64(forecast.CapacityBytes - forecast.UsedBytes)
+	switch {
+	case remaining <= 0:
+		full = 0
+	case remaining <= (readingGrowth+rollupGrowth)*filling:
+		full = remaining / (readingGrowth + rollupGrowth)
+	case rollupGrowth > 0:
+		full = filling + (remaining-(readingGrowth+rollupGrowth)*filling)/rollupGrowth
+	default:
+		return forecast, nil
+	}
+	if full > maxForecastDays {
+		return forecast, nil
+	}
+	fullAt := now.Add(daysDuration(full))
+	forecast.FullAt = &fullAt
+	switch {
+	case full == 0 && forecast.CapacityLimit == "quota":
+		forecast.Warning = "The database has exceeded its quota"
+	case full == 0:
+		forecast.Warning = "The database has filled its disk"
+	case full <= float64(days) && forecast.CapacityLimit == "quota":
+		forecast.Warning = fmt.Sprintf("The database is projected to exceed its quota in %d days", int(math.Ceil(full)))
+	case full <= float64(days):
+		forecast.Warning = fmt.Sprintf("The database is projected to fill its disk in %d days", int(math.Ceil(full)))
+	}
+	return forecast, nil
+}
+
+// forecastCapacity sets the bytes the database may grow to: the quota, or
+// for SQLite the free space on its disk if that is smaller
+func forecastCapacity(db *gorm.DB, forecast *Forecast, stats *Stats, quota int64) error {
+	if quota > 0 {
+		forecast.CapacityBytes = quota
+		forecast.CapacityLimit = "quota"
+	}
+	if stats.Backend != "sqlite" {
+		return nil
+	}
+
+	var files []struct {
+		Name string
+		File string
+	}
+	if err := db.Raw("PRAGMA database_list").Scan(&files).Error; err != nil {
+		return fmt.Errorf("failed to query database file: %w", err)
+	}
+	for _, file := range files {
+		// In-memory databases have no file
+		if file.Name != "main" || file.File == "" {
+			continue
+		}
+		free, _, err := disk.Space(filepath.Dir(file.File))
+		if err != nil {
+			// Fall back to the quota alone
+			return nil
+		}
+		if capacity := stats.SizeBytes + free; forecast.CapacityBytes == 0 || capacity < forecast.CapacityBytes {
+			forecast.CapacityBytes = capacity
+			forecast.CapacityLimit = "disk"
+		}
+	}
+	return nil
+}
+
+// daysDuration converts a fractional number of days to a duration
+func daysDuration(days float64) time.Duration {
+	return time.Duration(days * float64(24*time.Hour))
+}
@@ -0,0 +1,223 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/rollup"
+	"gorm.io/gorm"
+)
+
+// Rows are deleted in batches so pruning a large backlog does not hold one
+// long write lock
+const batchSize = 5000
+
+// SQLite's PRAGMA auto_vacuum modes
+const (
+	autoVacuumNone        = 0
+	autoVacuumFull        = 1
+	autoVacuumIncremental = 2
+)
+
+// Result counts the rows changed by a maintenance run
+type Result struct {
+	RetentionDays        int
+	ReadingsDeleted      int64
+	EventsDeleted        int64
+	BurstRetentionHours  int
+	BurstReadingsDeleted int64
+	RollupBuckets        int  // Buckets updated; rollups are kept past the retention period
+	Optimized            bool // Vacuum and analyze ran
+}
+
+// Run rolls up new readings, deletes readings, raw and compressed, activity
+// events and request usage older than the data.retention_days setting and
+// burst capture readings older than the data.burst_retention_hours setting,
+// and optionally vacuums and analyzes the database. worker is nil when
+// rollups are disabled.
+func Run(ctx context.Context, db *gorm.DB, worker *rollup.Worker, optimize bool) (Result, error) {
+	var result Result
+
+	// Roll up first so readings about to be pruned are in their buckets
+	if worker != nil {
+		buckets, err := worker.RunOnce(ctx)
+		if err != nil {
+			return result, fmt.Errorf("failed to roll up readings: %w", err)
+		}
+		result.RollupBuckets = buckets
+	}
+
+	db = db.WithContext(ctx)
+	days, err := RetentionDays(db)
+	if err != nil {
+		return result, err
+	}
+	result.RetentionDays = days
+	cutoff := time.Now().AddDate(0, 0, -days).UTC()
+
+	result.ReadingsDeleted, err = deleteBefore(db, &models.TemperatureReading{}, cutoff)
+	if err != nil {
+		return result, fmt.Errorf("failed to prune readings: %w", err)
+	}
+	compressed, err := deleteChunksBefore(db, cutoff)
+	if err != nil {
+		return result, fmt.Errorf("failed to prune compressed readings: %w", err)
+	}
+	result.ReadingsDeleted += compressed
+	result.EventsDeleted, err = deleteBefore(db, &models.Event{}, cutoff)
+	if err != nil {
+		return result, fmt.Errorf("failed to prune events: %w", err)
+	}
+	if _, err := deleteBefore(db, &models.RequestUsage{}, cutoff); err != nil {
+		return result, fmt.Errorf("failed to prune request usage: %w", err)
+	}
+	if _, err := deleteBefore(db, &models.QuarantinedReading{}, cutoff); err != nil {
+		return result, fmt.Errorf("failed to prune quarantined readings: %w", err)
+	}
+
+	result.BurstRetentionHours, err = settingInt(db, models.SettingDataBurstRetentionHours, models.DefaultBurstRetentionHours)
+	if err != nil {
+		return result, err
+	}
+	burstCutoff := time.Now().Add(-time.Duration(result.BurstRetentionHours) * time.Hour).UTC()
+	if burstCutoff.After(cutoff) {
+		bursts := db.Where("burst = ?", true).Session(&gorm.Session{})
+		result.BurstReadingsDeleted, err = deleteBefore(bursts, &models.TemperatureReading{}, burstCutoff)
+		if err != nil {
+			return result, fmt.Errorf("failed to prune burst readings: %w", err)
+		}
+	}
+
+	if optimize {
+		if err := Optimize(db); err != nil {
+			return result, err
+		}
+		result.Optimized = true
+	}
+	return result, nil
+}
+
+// RetentionDays returns the data.retention_days setting, or the default when
+// it is unset or not a positive number
+func RetentionDays(db *gorm.DB) (int, error) {
+	return settingInt(db, models.SettingDataRetentionDays, models.DefaultDataRetentionDays)
+}
+
+// settingInt returns a positive integer setting, or def when it is unset or
+// not a positive number
+func settingInt(db *gorm.DB, key string, def int) (int, error) {
+	var settings []models.Setting
+	if err := db.Where("key = ?", key).Limit(1).Find(&settings).Error; err != nil {
+		return 0, fmt.Errorf("failed to query %s setting: %w", key, err)
+	}
+	if len(settings) == 0 {
+		return def, nil
+	}
+	value, err := strconv.Atoi(settings[0].Value)
+	if err != nil || value <= 0 {
+		return def, nil
+	}
+	return value, nil
+}
+
+// deleteBefore deletes the rows of model created before cutoff, a batch at a
+// time, and returns how many were deleted. db may carry further conditions
+// in a new session.
+func deleteBefore(db *gorm.DB, model interface{}, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		batch := db.Model(model).Select("id").Where("created_at < ?", cutoff).Limit(batchSize)
+		result := db.Where("id IN (?)", batch).Delete(model)
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// deleteChunksBefore deletes the compressed readings of every hour that ended
+// before cutoff, a batch of chunks at a time, and returns how many readings
+// they held. A chunk is kept whole until its last reading is past cutoff.
+func deleteChunksBefore(db *gorm.DB, cutoff time.Time) (int64, error) {
+	const chunkBatchSize = 500
+
+	var total int64
+	for {
+		var chunks []models.ReadingChunk
+		err := db.Select("id", "count").Where("hour <= ?", cutoff.Add(-time.Hour)).Limit(chunkBatchSize).Find(&chunks).Error
+		if err != nil {
+			return total, err
+		}
+		if len(chunks) == 0 {
+			return total, nil
+		}
+		ids := make([]uint, len(chunks))
+		for i, chunk := range chunks {
+			ids[i] = chunk.ID
+			total += chunk.Count
+		}
+		if err := db.Where("id IN ?", ids).Delete(&models.ReadingChunk{}).Error; err != nil {
+			return total, err
+		}
+	}
+}
+
+// Optimize reclaims the space of deleted rows and refreshes the query
+// planner's statistics
+func Optimize(db *gorm.DB) error {
+	var statements []string
+	switch name := db.Dialector.Name(); name {
+	case "sqlite":
+		statements = []string{"VACUUM", "ANALYZE"}
+	case "postgres":
+		statements = []string{"VACUUM ANALYZE"}
+	default:
+		return fmt.Errorf("unsupported database type: %s", name)
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to run %s: %w", statement, err)
+		}
+	}
+	return nil
+}
+
+// Vacuum returns the pages freed by deleted rows to the filesystem. With
+// SQLite's incremental auto_vacuum that is cheap; a database created before
+// it was enabled is rewritten once by a full VACUUM, which enables it.
+// Postgres's autovacuum already reuses freed space, so there it does nothing.
+func Vacuum(db *gorm.DB) error {
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	var mode int
+	if err := db.Raw("PRAGMA auto_vacuum").Scan(&mode).Error; err != nil {
+		return fmt.Errorf("failed to query auto_vacuum: %w", err)
+	}
+	if mode != autoVacuumIncremental {
+		if err := db.Exec("VACUUM").Error; err != nil {
+			return fmt.Errorf("failed to run VACUUM: %w", err)
+		}
+		return nil
+	}
+
+	// Each step of incremental_vacuum frees one page, so step through all of
+	// its rows
+	rows, err := db.Raw("PRAGMA incremental_vacuum").Rows()
+	if err != nil {
+		return fmt.Errorf("failed to run incremental_vacuum: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to run incremental_vacuum: %w", err)
+	}
+	return nil
+}
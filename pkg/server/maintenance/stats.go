@@ -0,0 +1,123 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Stats describes the size and contents of the database
+type Stats struct {
+	Backend    string // sqlite or postgres
+	SizeBytes  int64
+	FreeBytes  int64  // SQLite pages free for reuse, returned to the filesystem by vacuuming
+	AutoVacuum string // SQLite's auto_vacuum mode: none, full or incremental
+	Tables     []TableStats
+	// Timestamps of the oldest and newest readings; nil without readings
+	OldestReading *time.Time
+	NewestReading *time.Time
+}
+
+// TableStats counts the rows of a table
+type TableStats struct {
+	Name string
+	Rows int64
+}
+
+// GetStats measures the database. Row counts are exact, so on a large
+// Postgres database this scans every table.
+func GetStats(db *gorm.DB) (*Stats, error) {
+	stats := &Stats{Backend: db.Dialector.Name()}
+
+	switch stats.Backend {
+	case "sqlite":
+		var pageSize, pageCount, freePages, mode int64
+		pragmas := []struct {
+			name string
+			dest *int64
+		}{
+			{"page_size", &pageSize},
+			{"page_count", &pageCount},
+			{"freelist_count", &freePages},
+			{"auto_vacuum", &mode},
+		}
+		for _, pragma := range pragmas {
+			if err := db.Raw("PRAGMA " + pragma.name).Scan(pragma.dest).Error; err != nil {
+				return nil, fmt.Errorf("failed to query %s: %w", pragma.name, err)
+			}
+		}
+		stats.SizeBytes = pageSize * pageCount
+		stats.FreeBytes = pageSize * freePages
+		switch mode {
+		case autoVacuumNone:
+			stats.AutoVacuum = "none"
+		case autoVacuumFull:
+			stats.AutoVacuum = "full"
+		case autoVacuumIncremental:
+			stats.AutoVacuum = "incremental"
+		}
+	case "postgres":
+		if err := db.Raw("SELECT pg_database_size(current_database())").Scan(&stats.SizeBytes).Error; err != nil {
+			return nil, fmt.Errorf("failed to query database size: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", stats.Backend)
+	}
+
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		// Skip SQLite's own tables, such as sqlite_sequence
+		if strings.HasPrefix(table, "sqlite_") {
+			continue
+		}
+		var rows int64
+		if err := db.Table(table).Count(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to count rows of %s: %w", table, err)
+		}
+		stats.Tables = append(stats.Tables, TableStats{Name: table, Rows: rows})
+	}
+
+	// Plucking the column rather than selecting MIN keeps its type, which
+	// SQLite loses on aggregates
+	for _, bound := range []struct {
+		order string
+		dest  **time.Time
+	}{
+		{"created_at", &stats.OldestReading},
+		{"created_at DESC", &stats.NewestReading},
+	} {
+		var times []time.Time
+		err := db.Model(&models.TemperatureReading{}).Order(bound.order).Limit(1).Pluck("created_at", &times).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to query reading times: %w", err)
+		}
+		if len(times) > 0 {
+			*bound.dest = &times[0]
+		}
+	}
+
+	// Compressed readings are older than raw ones
+	var oldest []models.ReadingChunk
+	if err := db.Order("hour").Limit(1).Find(&oldest).Error; err != nil {
+		return nil, fmt.Errorf("failed to query compressed reading times: %w", err)
+	}
+	if len(oldest) > 0 {
+		points, err := chunks.Decode(oldest[0].Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode oldest chunk: %w", err)
+		}
+		if len(points) > 0 && (stats.OldestReading == nil || points[0].Time.Before(*stats.OldestReading)) {
+			stats.OldestReading = &points[0].Time
+		}
+	}
+	return stats, nil
+}
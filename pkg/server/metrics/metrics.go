@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and renders them in the Prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	counters []*CounterVec
+}
+
+// Default is the registry served on /metrics
+var Default = &Registry{}
+
+// CounterVec is a monotonically increasing counter partitioned by labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter and registers it with the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec creates a counter and registers it with r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	r.mu.Lock()
+	r.counters = append(r.counters, c)
+	r.mu.Unlock()
+	return c
+}
+
+// Add increases the counter for the given label values, in declaration order
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+// Inc increases the counter for the given label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	counters := append([]*CounterVec(nil), r.counters...)
+	r.mu.Unlock()
+
+	for _, c := range counters {
+		if err := c.writeText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CounterVec) writeText(w io.Writer) error {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]float64, len(keys))
+	for i, key := range keys {
+		values[i] = c.values[key]
+	}
+	c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s%s %g\n", c.name, c.formatLabels(key), values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelEscaper escapes label values as the text exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders a label key as {name="value",...}
+func (c *CounterVec) formatLabels(key string) string {
+	if len(c.labels) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, len(c.labels))
+	for i, label := range c.labels {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, label, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
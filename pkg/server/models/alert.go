@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type AlertRule struct {
@@ -12,25 +14,74 @@ type AlertRule struct {
 	ClientID    string    `gorm:"index"` // Apply to specific client or empty for all
 	SensorID    string    `gorm:"index"` // Apply to specific sensor or empty for all
 	SensorType  string    `gorm:"index"` // Apply to sensor type (CPU, GPU, etc) or empty for all
+	Site        string    // Apply to clients at this site or empty for all; threshold and aggregate rules
+	MetadataSelector string `gorm:"type:text"` // JSON map the metadata of matching clients must contain; threshold and aggregate rules
 	
 	// Condition fields
+	ConditionType    string  `gorm:"not null;default:'TYPE_THRESHOLD'"` // TYPE_THRESHOLD, TYPE_STALE_SENSOR, TYPE_CLIENT_OFFLINE, TYPE_AGGREGATE, TYPE_SENSOR_QUALITY, TYPE_EXTERNAL or TYPE_THERMAL_BUDGET
+	Aggregate        string  // AGGREGATE_AVERAGE, AGGREGATE_MAX or AGGREGATE_MIN for aggregate rules
+	Period           string  // DUTY_CYCLE_PERIOD_DAY or DUTY_CYCLE_PERIOD_WEEK for thermal budget rules
 	Operator         string  `gorm:"not null"` // OPERATOR_GREATER_THAN, OPERATOR_LESS_THAN, etc.
 	Threshold        float64 `gorm:"not null"`
 	DurationSeconds  int32   `gorm:"not null"` // How long condition must be true
+	// Open alerts resolve once the value is back past the clear threshold,
+	// or the clear percentage of the threshold back from it; with neither,
+	// once the value no longer breaches
+	ClearThreshold   *float64
+	ClearPercent     float64
+	Severity         string  `gorm:"not null;default:'SEVERITY_WARNING'"` // SEVERITY_INFO, SEVERITY_WARNING, SEVERITY_CRITICAL
+	Windows          string  `gorm:"type:text"` // JSON list of threshold windows
 	
 	Enabled   bool      `gorm:"default:true"`
+	SnoozedUntil *time.Time // Alerts of the rule skip notifications until then
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// Deleted rules are kept so the history of their alerts still refers to
+	// them; their actions are deleted with them
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 	
-	// Relations
-	Actions []AlertAction `gorm:"foreignKey:RuleID;references:RuleID"`
-	Alerts  []Alert       `gorm:"foreignKey:RuleID;references:RuleID"`
+	// Relations; purging a rule deletes its actions and alerts
+	Actions []AlertAction `gorm:"foreignKey:RuleID;references:RuleID;constraint:fk_alert_actions_rule,OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Alerts  []Alert       `gorm:"foreignKey:RuleID;references:RuleID;constraint:fk_alerts_rule,OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
 func (AlertRule) TableName() string {
 	return "alert_rules"
 }
 
+// Alert rule condition types
+const (
+	ConditionTypeThreshold     = "TYPE_THRESHOLD"
+	ConditionTypeStaleSensor   = "TYPE_STALE_SENSOR"
+	ConditionTypeClientOffline = "TYPE_CLIENT_OFFLINE"
+	ConditionTypeAggregate     = "TYPE_AGGREGATE"
+	ConditionTypeSensorQuality = "TYPE_SENSOR_QUALITY"
+	ConditionTypeExternal      = "TYPE_EXTERNAL"
+	ConditionTypeThermalBudget = "TYPE_THERMAL_BUDGET"
+)
+
+// Aggregates of aggregate rules
+const (
+	AggregateAverage = "AGGREGATE_AVERAGE"
+	AggregateMax     = "AGGREGATE_MAX"
+	AggregateMin     = "AGGREGATE_MIN"
+)
+
+// Periods of thermal budget rules
+const (
+	DutyCyclePeriodDay  = "DUTY_CYCLE_PERIOD_DAY"
+	DutyCyclePeriodWeek = "DUTY_CYCLE_PERIOD_WEEK"
+)
+
+// ThresholdWindow is a recurring window in which a threshold rule uses a
+// different threshold
+type ThresholdWindow struct {
+	Name      string  `json:"name,omitempty"`
+	Schedule  string  `json:"schedule"` // Cron expression of the minutes covered
+	Threshold float64 `json:"threshold"`
+	Suppress  bool    `json:"suppress,omitempty"` // No alerts during the window
+}
+
 type AlertAction struct {
 	ID       uint   `gorm:"primaryKey"`
 	RuleID   string `gorm:"index;not null"`
@@ -48,17 +99,71 @@ type Alert struct {
 	ID         uint      `gorm:"primaryKey"`
 	AlertID    string    `gorm:"uniqueIndex;not null"`
 	RuleID     string    `gorm:"index;not null"`
-	ClientID   string    `gorm:"index;not null"`
+	ClientID   string    `gorm:"index;not null;index:idx_alerts_active_client,priority:2"`
 	SensorID   string    `gorm:"index;not null"`
 	Value      float64   `gorm:"not null"`
-	TriggeredAt time.Time `gorm:"index;not null"`
+	TriggeredAt time.Time `gorm:"index;not null;index:idx_alerts_active_triggered,priority:2"`
 	ResolvedAt  *time.Time `gorm:"index"`
-	IsActive    bool      `gorm:"default:true;index"`
+	// Composite indexes keep the active alerts summary from scanning history
+	IsActive    bool      `gorm:"default:true;index;index:idx_alerts_active_severity,priority:1;index:idx_alerts_active_client,priority:1;index:idx_alerts_active_triggered,priority:1"`
+	Severity    string    `gorm:"index:idx_alerts_active_severity,priority:2"` // Copied from the rule when triggered
+	AcknowledgedAt *time.Time
+	AcknowledgedBy string
 	Message     string
+	Muted       bool      `gorm:"not null;default:false"` // Raised while notifications were muted or the rule snoozed, so nothing was notified
+	Flapping    bool      `gorm:"not null;default:false"` // Raised while the rule and sensor were flapping, so nothing was notified
+	ExternalID  string    `gorm:"not null;default:'';index"` // Fingerprint the sender gave an alert received through the alert receiver; empty for alerts raised here
+	// Readings of the client from ContextStart to ContextEnd are kept with a
+	// threshold alert; both are unset for alerts without context
+	ContextStart    *time.Time
+	ContextEnd      *time.Time
+	ContextComplete bool      `gorm:"not null;default:false;index"` // Readings after the trigger have been captured too
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	
+	// Relations; an alert's context is deleted with it
+	Context []AlertContextReading `gorm:"foreignKey:AlertID;references:AlertID;constraint:fk_alert_context_alert,OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
 func (Alert) TableName() string {
 	return "alerts"
-}
\ No newline at end of file
+}
+
+// AlertContextReading is a reading copied into a threshold alert's context,
+// so it outlives the retention period of raw readings
+type AlertContextReading struct {
+	ID                 uint      `gorm:"primaryKey"`
+	AlertID            string    `gorm:"not null;uniqueIndex:idx_alert_context_readings_reading,priority:1"`
+	SensorID           string    `gorm:"not null;uniqueIndex:idx_alert_context_readings_reading,priority:2"`
+	SensorType         string
+	SensorName         string
+	TemperatureCelsius float64   `gorm:"not null"`
+	MinCelsius         *float64
+	MaxCelsius         *float64
+	AvgCelsius         *float64
+	SampleCount        int32     `gorm:"not null;default:1"`
+	Burst              bool      `gorm:"not null;default:false"`
+	ReadingAt          time.Time `gorm:"not null;uniqueIndex:idx_alert_context_readings_reading,priority:3"` // Timestamp of the reading
+	CreatedAt          time.Time
+}
+
+func (AlertContextReading) TableName() string {
+	return "alert_context_readings"
+}
+
+// PendingAlert is a target of a threshold or aggregate rule that breaches
+// but not yet for the rule's duration. The evaluator stores them so a
+// restart or a change of leader does not start the duration over. Aggregate
+// rules have an empty client and sensor.
+type PendingAlert struct {
+	ID        uint      `gorm:"primaryKey"`
+	RuleID    string    `gorm:"not null;uniqueIndex:idx_pending_alerts_target,priority:1"`
+	ClientID  string    `gorm:"not null;uniqueIndex:idx_pending_alerts_target,priority:2"`
+	SensorID  string    `gorm:"not null;uniqueIndex:idx_pending_alerts_target,priority:3"`
+	Since     time.Time `gorm:"not null"` // When the breach began
+	CreatedAt time.Time
+}
+
+func (PendingAlert) TableName() string {
+	return "pending_alerts"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// Annotation records an event shown alongside temperature history
+type Annotation struct {
+	ID           uint       `gorm:"primaryKey"`
+	AnnotationID string     `gorm:"uniqueIndex;not null"`
+	ClientID     string     `gorm:"index"` // Empty for global annotations
+	Time         time.Time  `gorm:"index;not null"`
+	EndTime      *time.Time // Set for events spanning a period
+	Text         string     `gorm:"type:text;not null"`
+	Tags         string     `gorm:"type:text"` // JSON array of tags
+	Author       string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (Annotation) TableName() string {
+	return "annotations"
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// ReadingChunk holds the regular readings of one sensor over one UTC hour,
+// compressed once they are older than the compression age. Burst readings
+// are never compressed.
+type ReadingChunk struct {
+	ID         uint      `gorm:"primaryKey"`
+	ClientID   string    `gorm:"not null;uniqueIndex:idx_reading_chunks_client_sensor_hour,priority:1"`
+	SensorID   string    `gorm:"not null;uniqueIndex:idx_reading_chunks_client_sensor_hour,priority:2"`
+	Hour       time.Time `gorm:"not null;index;uniqueIndex:idx_reading_chunks_client_sensor_hour,priority:3"`
+	SensorType string    `gorm:"index"`
+	SensorName string
+	// Aggregates of the readings, over their summaries where they have one,
+	// so stats over whole hours need not decode the data
+	Count      int64   `gorm:"not null"`
+	MinCelsius float64 `gorm:"not null"`
+	MaxCelsius float64 `gorm:"not null"`
+	SumCelsius float64 `gorm:"not null"`
+	Data       []byte  `gorm:"not null"` // Encoded by the chunks package
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (ReadingChunk) TableName() string {
+	return "reading_chunks"
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// Command is an action queued for an agent, which polls for it
+type Command struct {
+	ID          uint      `gorm:"primaryKey"`
+	CommandID   string    `gorm:"uniqueIndex;not null"`
+	ClientID    string    `gorm:"index;not null"`
+	Type        string    `gorm:"not null"`               // e.g. set_fan
+	Source      string    `gorm:"not null;default:'api'"` // Who queued the command: api, rule:<rule_id> for emergency actions, or config
+	Payload     string    `gorm:"type:text"`              // protojson of the command's action
+	Status      string    `gorm:"index;not null"`         // pending, delivered, succeeded, failed
+	Result      string    `gorm:"type:text"`              // Message reported by the agent
+	ExpiresAt   time.Time `gorm:"index"`
+	DeliveredAt *time.Time
+	CompletedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Command) TableName() string {
+	return "commands"
+}
+
+// Command states; a pending command past its expiry is reported as expired
+const (
+	CommandStatusPending   = "pending"
+	CommandStatusDelivered = "delivered"
+	CommandStatusSucceeded = "succeeded"
+	CommandStatusFailed    = "failed"
+)
+
+// Command types
+const (
+	CommandTypeSetFan       = "set_fan"
+	CommandTypeRunAction    = "run_action"
+	CommandTypeBurstCapture = "burst_capture"
+	CommandTypeApplyConfig  = "apply_config"
+	CommandTypeLogEvent     = "log_event"
+)
+
+// Command sources
+const (
+	CommandSourceAPI    = "api"
+	CommandSourceRule   = "rule:"  // Followed by the rule ID, for emergency and event log actions
+	CommandSourceConfig = "config" // Configuration profiles and overrides
+)
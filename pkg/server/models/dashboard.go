@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Dashboard is a user-defined dashboard. Panels and layout are stored as JSON
+// documents since the server only validates and returns them.
+type Dashboard struct {
+	ID          uint   `gorm:"primaryKey"`
+	DashboardID string `gorm:"uniqueIndex;not null"`
+	Name        string `gorm:"not null"`
+	Description string
+	Owner       string `gorm:"index"` // Empty for dashboards anyone may edit
+	Shared      bool   `gorm:"index;default:false"`
+	Panels      string `gorm:"type:text"` // JSON array of panels
+	Layout      string `gorm:"type:text"` // JSON document owned by the UI
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Dashboard) TableName() string {
+	return "dashboards"
+}
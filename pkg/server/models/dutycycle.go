@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// DutyCycle is the time one sensor spent above one threshold over one local
+// day, as tracked by the duty cycle job. Days are kept past the retention of
+// readings, as a record of each sensor's long-term thermal stress.
+type DutyCycle struct {
+	ID        uint      `gorm:"primaryKey"`
+	ClientID  string    `gorm:"not null;index;uniqueIndex:idx_duty_cycles_sensor_day,priority:1"`
+	SensorID  string    `gorm:"not null;uniqueIndex:idx_duty_cycles_sensor_day,priority:2"`
+	Threshold float64   `gorm:"not null;uniqueIndex:idx_duty_cycles_sensor_day,priority:3"`       // °C
+	Day       time.Time `gorm:"not null;index;uniqueIndex:idx_duty_cycles_sensor_day,priority:4"` // Start of the day in the general.timezone setting's zone
+	Above     float64   `gorm:"not null"`                                                         // Seconds above the threshold
+	Tracked   float64   `gorm:"not null"`                                                         // Seconds covered by readings
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (DutyCycle) TableName() string {
+	return "duty_cycles"
+}
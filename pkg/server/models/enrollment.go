@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// EnrollmentToken lets agents enroll themselves. Only a hash of the token is stored.
+type EnrollmentToken struct {
+	ID          string `gorm:"primaryKey"`
+	TokenHash   string `gorm:"uniqueIndex;not null"`
+	Description string
+	MaxUses     int `gorm:"not null"` // 0 means unlimited
+	Uses        int `gorm:"not null;default:0"`
+	ExpiresAt   *time.Time
+	RevokedAt   *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (EnrollmentToken) TableName() string {
+	return "enrollment_tokens"
+}
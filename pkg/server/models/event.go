@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Event is an entry of the activity log, a single timeline of what happened
+// to clients, alerts, settings and background jobs
+type Event struct {
+	ID        uint   `gorm:"primaryKey"`
+	EventID   string `gorm:"uniqueIndex;not null"`
+	Type      string `gorm:"index;not null"` // e.g. client.offline or alert.triggered
+	ClientID  string `gorm:"index"`
+	SensorID  string
+	RuleID    string
+	AlertID   string
+	Message   string    `gorm:"type:text"`
+	Details   string    `gorm:"type:text"` // JSON object of strings
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (Event) TableName() string {
+	return "events"
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// Job records the schedule and latest run of a background job, such as
+// rollups or alert evaluation
+type Job struct {
+	ID            uint       `gorm:"primaryKey"`
+	Name          string     `gorm:"uniqueIndex;not null"`
+	Schedule      string     // Description, e.g. "every 1m0s" or "cron 0 3 * * *"
+	Running       bool       // A run started and has not finished
+	LastRunAt     *time.Time // Start of the latest run
+	LastDuration  int64      // Milliseconds
+	LastError     string     `gorm:"type:text"` // Empty when the latest run succeeded
+	LastSuccessAt *time.Time
+	NextRunAt     *time.Time
+	RunCount      int64
+	FailureCount  int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (Job) TableName() string {
+	return "jobs"
+}
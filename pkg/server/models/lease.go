@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// Lease records which server instance currently holds a named lock, such as
+// leadership for background jobs
+type Lease struct {
+	Name      string    `gorm:"primaryKey"`
+	Holder    string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (Lease) TableName() string {
+	return "leases"
+}
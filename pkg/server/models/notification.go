@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+)
+
+// NotificationChannel is a destination of alert notifications, such as an
+// email list or a Slack webhook, shared by the routes that name it
+type NotificationChannel struct {
+	ID             uint   `gorm:"primaryKey"`
+	ChannelID      string `gorm:"uniqueIndex;not null"`
+	Name           string `gorm:"not null"`
+	Type           string `gorm:"not null"`  // TYPE_EMAIL, TYPE_SLACK or TYPE_WEBHOOK
+	Config         string `gorm:"type:text"` // JSON map of the type's settings
+	Enabled        bool   `gorm:"index"`
+	SendResolved   bool   // Also notify when alerts resolve
+	LastDeliveryAt *time.Time
+	LastStatus     string // Result of the last delivery
+	// Cron expression of the minutes in which notifications are held for a
+	// digest; empty for none
+	QuietHours string `gorm:"not null;default:''"`
+	// SEVERITY_* value from which notifications are sent during quiet hours
+	// anyway; empty holds every notification
+	QuietBypassSeverity string `gorm:"not null;default:''"`
+	// How long after the first held notification a digest is sent; 0 sends
+	// notifications at once outside quiet hours
+	DigestIntervalSeconds int32 `gorm:"not null;default:0"`
+	// SEVERITY_* value from which notifications skip the digest; empty puts
+	// every notification in it
+	DigestBypassSeverity string `gorm:"not null;default:''"`
+	// Language of notifications, e.g. de; empty for the general.locale
+	// setting
+	Locale    string `gorm:"not null;default:''"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (NotificationChannel) TableName() string {
+	return "notification_channels"
+}
+
+// NotificationRoute sends the alerts it matches to channels. Routes are
+// checked in position order, up to the first matching route that stops.
+type NotificationRoute struct {
+	ID               uint   `gorm:"primaryKey"`
+	RouteID          string `gorm:"uniqueIndex;not null"`
+	Name             string `gorm:"not null"`
+	Position         int32  `gorm:"not null;default:0;index"`
+	Severities       string `gorm:"type:text"` // JSON array of SEVERITY_* values; empty for any
+	SensorTypes      string `gorm:"type:text"` // JSON array; empty for any
+	MetadataSelector string `gorm:"type:text"` // JSON map the metadata of matching clients must contain
+	ChannelIDs       string `gorm:"type:text"` // JSON array
+	Stop             bool   // Check no later routes for matching alerts
+	Enabled          bool   `gorm:"index"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (NotificationRoute) TableName() string {
+	return "notification_routes"
+}
+
+// HeldNotification is a notification a channel did not send right away,
+// waiting to be sent in a digest
+type HeldNotification struct {
+	ID         uint      `gorm:"primaryKey"`
+	ChannelID  string    `gorm:"index;not null"`
+	Type       string    `gorm:"not null"` // alert.triggered or alert.resolved
+	AlertID    string    `gorm:"not null"`
+	Severity   string    `gorm:"not null"`
+	Title      string    `gorm:"not null"`
+	NotifiedAt time.Time `gorm:"not null"` // When it would have been sent
+	CreatedAt  time.Time
+}
+
+func (HeldNotification) TableName() string {
+	return "held_notifications"
+}
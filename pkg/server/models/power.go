@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// ClientPower is how the server reaches a client's machine for power control
+type ClientPower struct {
+	ID            uint   `gorm:"primaryKey"`
+	ClientID      string `gorm:"uniqueIndex;not null"`
+	MACAddress    string // For Wake-on-LAN
+	WakeBroadcast string // Overrides power.wake_broadcast
+	BMCAddress    string // Host or host:port of the IPMI BMC
+	BMCUsername   string
+	BMCPassword   string // Encrypted with power.secret_key; never returned by the API
+	BMCInterface  string // ipmitool interface, lanplus when empty
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (ClientPower) TableName() string {
+	return "client_power"
+}
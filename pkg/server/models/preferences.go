@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// UserPreferences overrides global display settings for one user. Empty
+// fields fall back to the global settings.
+type UserPreferences struct {
+	ID                 uint   `gorm:"primaryKey"`
+	Username           string `gorm:"uniqueIndex;not null"` // Not "user", which Postgres reserves
+	TemperatureUnit    string // celsius or fahrenheit
+	Theme              string // light, dark, or system
+	Timezone           string
+	DefaultDashboardID string
+	Locale             string // Language, e.g. de
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+func (UserPreferences) TableName() string {
+	return "user_preferences"
+}
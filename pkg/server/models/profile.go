@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// ConfigProfile is agent configuration applied to the clients at a site, with
+// metadata values, or both. Profiles that apply to a client are layered by
+// priority, then the client's own override.
+type ConfigProfile struct {
+	ID               uint   `gorm:"primaryKey"`
+	ProfileID        string `gorm:"uniqueIndex;not null"`
+	Name             string `gorm:"not null"`
+	Description      string
+	Site             string `gorm:"index"`     // Empty for any site
+	MetadataSelector string `gorm:"type:text"` // JSON map the metadata of matching clients must contain
+	Priority         int32  `gorm:"not null;default:0"`
+	Config           string `gorm:"type:text"` // protojson of the AgentConfig
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (ConfigProfile) TableName() string {
+	return "config_profiles"
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// SensorQuality is how trustworthy a sensor's recent readings are, as scored
+// by the quality job over its window
+type SensorQuality struct {
+	Score       *float64 `gorm:"index"` // 0 to 100; nil until scored, or with nothing reported in the window
+	Readings    int64    // Readings stored in the window, excluding bursts
+	Coverage    float64  // Share of the window not lost to gaps in reporting, 0 to 1
+	Quarantined int64    // Readings quarantined at ingest in the window
+	Flatline    bool     // Every reading the same, as from a stuck probe
+	Noise       float64  // Mean change between successive readings, in °C
+	Noisy       bool     // Noise above the configured limit
+	CheckedAt   *time.Time
+}
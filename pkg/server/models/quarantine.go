@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// Reasons a reading is quarantined
+const (
+	QuarantineRejectedValue = "rejected_value" // A value sensors report for a failed read, such as 65535 m°C
+	QuarantineOutOfRange    = "out_of_range"   // Outside the plausible range of its sensor type
+	QuarantineSpike         = "spike"          // Too far from the sensor's previous reading
+)
+
+// QuarantinedReading is a reading that failed ingest validation. It is kept
+// for inspection instead of being stored, charted or alerted on.
+type QuarantinedReading struct {
+	ID                 uint   `gorm:"primaryKey"`
+	ClientID           string `gorm:"index;not null"`
+	SensorID           string `gorm:"not null"`
+	SensorType         string
+	SensorName         string
+	TemperatureCelsius float64  `gorm:"not null"`
+	MinCelsius         *float64 // Set when the agent summarized several samples
+	MaxCelsius         *float64
+	AvgCelsius         *float64
+	SampleCount        int32     `gorm:"not null;default:1"`
+	Reason             string    `gorm:"index;not null"`
+	PreviousCelsius    *float64  // Reading the spike was measured from
+	ReadingTime        time.Time `gorm:"not null"` // Timestamp of the reading
+	CreatedAt          time.Time `gorm:"index"`    // When it was quarantined
+}
+
+func (QuarantinedReading) TableName() string {
+	return "quarantined_readings"
+}
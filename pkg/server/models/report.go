@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Report is a generated report file, downloadable through a signed URL until
+// it expires. Reports are stored in the database so any replica can serve them.
+type Report struct {
+	ID          string    `gorm:"primaryKey"`
+	Filename    string    `gorm:"not null"`
+	ContentType string    `gorm:"not null"`
+	Data        []byte    `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"index;not null"`
+	CreatedAt   time.Time
+}
+
+func (Report) TableName() string {
+	return "reports"
+}
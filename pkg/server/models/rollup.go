@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// TemperatureRollup holds aggregated readings for one sensor over a fixed bucket
+type TemperatureRollup struct {
+	ID            uint      `gorm:"primaryKey"`
+	SensorID      string    `gorm:"not null;uniqueIndex:idx_rollups_client_sensor_bucket,priority:2"`
+	ClientID      string    `gorm:"index;not null;uniqueIndex:idx_rollups_client_sensor_bucket,priority:1"`
+	BucketSeconds int64     `gorm:"not null;uniqueIndex:idx_rollups_client_sensor_bucket,priority:3"`
+	BucketStart   time.Time `gorm:"not null;index;uniqueIndex:idx_rollups_client_sensor_bucket,priority:4"`
+	MinTemp       float64
+	MaxTemp       float64
+	AvgTemp       float64
+	Count         int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (TemperatureRollup) TableName() string {
+	return "temperature_rollups"
+}
+
+// RollupWatermark tracks how far a rollup job has processed ingested readings.
+// The watermark is based on ingest time rather than reading time so readings
+// that arrive late or out of order still cause their buckets to be recomputed.
+type RollupWatermark struct {
+	Name      string    `gorm:"primaryKey"`
+	Watermark time.Time `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (RollupWatermark) TableName() string {
+	return "rollup_watermarks"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// Script is a user automation script run on server events
+type Script struct {
+	ID          uint   `gorm:"primaryKey"`
+	ScriptID    string `gorm:"uniqueIndex;not null"`
+	Name        string `gorm:"not null"`
+	Description string
+	Source      string `gorm:"type:text;not null"` // Starlark source
+	Enabled     bool   `gorm:"index"`
+	LastError   string `gorm:"type:text"` // Error of the most recent failed run, cleared by the next success
+	LastErrorAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Script) TableName() string {
+	return "scripts"
+}
@@ -36,8 +36,35 @@ const (
 	
 	// Data retention
 	SettingDataRetentionDays = "data.retention_days"
+	SettingDataBurstRetentionHours = "data.burst_retention_hours"
+	
+	// Threshold rule evaluation
+	SettingAlertsEnabled       = "alerts.enabled"
+	SettingAlertsCheckInterval = "alerts.check_interval_seconds"
+	SettingAlertsFlapThreshold = "alerts.flap_threshold"
+	SettingAlertsContextMinutes = "alerts.context_minutes"
+	
+	// Notification mute, set through the AlertService
+	SettingAlertsMutedUntil = "alerts.muted_until" // RFC 3339, empty when not muted
+	SettingAlertsMuteReason = "alerts.mute_reason"
 	
 	// General settings  
 	SettingSystemName = "general.system_name"
 	SettingTimezone   = "general.timezone"
-)
\ No newline at end of file
+	SettingLocale     = "general.locale" // Language of server-written text, e.g. de
+)
+// DefaultAlertFlapThreshold is used when the alerts.flap_threshold setting
+// is unset
+const DefaultAlertFlapThreshold = 5
+
+// DefaultAlertContextMinutes is used when the alerts.context_minutes setting
+// is unset
+const DefaultAlertContextMinutes = 15
+
+// DefaultDataRetentionDays is used when the data.retention_days setting is
+// unset
+const DefaultDataRetentionDays = 30
+
+// DefaultBurstRetentionHours is used when the data.burst_retention_hours
+// setting is unset
+const DefaultBurstRetentionHours = 24
@@ -6,13 +6,19 @@ import (
 
 type TemperatureReading struct {
 	ID               uint      `gorm:"primaryKey"`
-	SensorID         string    `gorm:"index;not null"`
-	ClientID         string    `gorm:"index;not null"`
-	TemperatureCelsius float64 `gorm:"not null"`
+	SensorID         string    `gorm:"index;not null;uniqueIndex:idx_readings_client_sensor_time,priority:2"`
+	ClientID         string    `gorm:"index;not null;uniqueIndex:idx_readings_client_sensor_time,priority:1"`
+	TemperatureCelsius float64 `gorm:"not null"` // Last sample of a summarized reading
+	// Set when the agent summarized several samples into the reading
+	MinCelsius       *float64
+	MaxCelsius       *float64
+	AvgCelsius       *float64
+	SampleCount      int32     `gorm:"not null;default:1"`
+	Burst            bool      `gorm:"index;not null;default:false"` // Reported during a burst capture; pruned early and left out of rollups
 	SensorType       string    `gorm:"index"`
 	SensorName       string
-	CreatedAt        time.Time `gorm:"index"`
-	UpdatedAt        time.Time
+	CreatedAt        time.Time `gorm:"index;uniqueIndex:idx_readings_client_sensor_time,priority:3"`
+	UpdatedAt        time.Time `gorm:"index"` // Ingest time, used by the rollup watermark
 }
 
 func (TemperatureReading) TableName() string {
@@ -22,7 +28,7 @@ func (TemperatureReading) TableName() string {
 type Client struct {
 	ID        uint      `gorm:"primaryKey"`
 	ClientID  string    `gorm:"uniqueIndex;not null"`
-	Hostname  string
+	Hostname  string    `gorm:"index"` // Display name, not unique
 	IPAddress string
 	OS        string
 	Arch      string
@@ -30,20 +36,78 @@ type Client struct {
 	LastSeen  time.Time
 	IsOnline  bool      `gorm:"default:false"`
 	Metadata  string    `gorm:"type:text"` // JSON string for metadata map
+	ClockSkewMs int64   // Estimated clock offset from the server, positive means ahead
+	MachineID string    // Host machine ID reported at registration
+	IdentityConflictAt *time.Time // Last time another machine registered with this client ID
+	Status    string    `gorm:"index;not null;default:'approved'"` // pending, approved, rejected
+	APIKeyHash string   // SHA-256 of the API key issued at token enrollment or when opting in to commands
+	Capabilities string `gorm:"type:text"` // JSON list of commands the agent accepts
+	LocalActions string `gorm:"type:text"` // JSON list of local actions the agent offers to run_action commands
+	ConfigOverride string `gorm:"type:text"` // protojson of the AgentConfig layered over the client's profiles
+	ConfigRevision string // Revision of the configuration last queued for the agent
+	Site      string    `gorm:"index"` // Site the client is installed at, for grouping multi-site fleets
+	Rack      string    // Rack or room within the site
+	Latitude  *float64  // Coordinates in decimal degrees; both or neither are set
+	Longitude *float64
+	LocationDescription string // Free text location
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	
+	// Relations; a client's data follows it when its ID changes and is
+	// deleted with it
+	Addresses []ClientAddress      `gorm:"foreignKey:ClientID;references:ClientID;constraint:fk_client_addresses_client,OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Sensors   []Sensor             `gorm:"foreignKey:ClientID;references:ClientID;constraint:fk_sensors_client,OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Readings  []TemperatureReading `gorm:"foreignKey:ClientID;references:ClientID;constraint:fk_readings_client,OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Chunks    []ReadingChunk       `gorm:"foreignKey:ClientID;references:ClientID;constraint:fk_reading_chunks_client,OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
 func (Client) TableName() string {
 	return "clients"
 }
 
+// ClientAddress is an IP address a client has reported from. The client's
+// current address is also kept in Client.IPAddress; rows are written only
+// when the address changes.
+type ClientAddress struct {
+	ID        uint      `gorm:"primaryKey"`
+	ClientID  string    `gorm:"not null;uniqueIndex:idx_client_addresses_client_ip,priority:1"`
+	IPAddress string    `gorm:"not null;uniqueIndex:idx_client_addresses_client_ip,priority:2"`
+	FirstSeen time.Time // First report from the address
+	LastSeen  time.Time `gorm:"index"` // Last report before the client moved to another address
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (ClientAddress) TableName() string {
+	return "client_addresses"
+}
+
+// Client enrollment states
+const (
+	ClientStatusPending  = "pending"
+	ClientStatusApproved = "approved"
+	ClientStatusRejected = "rejected"
+)
+
+// Capabilities agents opt in to
+const (
+	CapabilityFanControl   = "fan_control"   // Accepts set_fan commands
+	CapabilityLocalActions = "local_actions" // Accepts run_action commands for the actions it offers
+	CapabilityBurstCapture = "burst_capture" // Accepts burst_capture commands
+	CapabilityRemoteConfig = "remote_config" // Accepts apply_config commands
+	CapabilityEventLog     = "event_log"     // Accepts log_event commands
+)
+
 type Sensor struct {
 	ID         uint   `gorm:"primaryKey"`
-	SensorID   string `gorm:"uniqueIndex;not null"`
-	ClientID   string `gorm:"index;not null"`
+	SensorID   string `gorm:"not null;uniqueIndex:idx_sensors_client_sensor,priority:2"`
+	ClientID   string `gorm:"index;not null;uniqueIndex:idx_sensors_client_sensor,priority:1"`
 	SensorType string
 	SensorName string
+	LastReadingAt *time.Time `gorm:"index"` // Timestamp of the newest stored reading
+	StaleSince    *time.Time `gorm:"index"` // Set while the sensor is silent but its client still reports
+	RetiredAt     *time.Time `gorm:"index"` // Set while the sensor is retired and hidden from current views
+	Quality       SensorQuality `gorm:"embedded;embeddedPrefix:quality_"`
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 }
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// RequestUsage counts the RPCs one caller made to one method over one UTC
+// hour, summed across replicas
+type RequestUsage struct {
+	ID            uint      `gorm:"primaryKey"`
+	Hour          time.Time `gorm:"not null;index;uniqueIndex:idx_request_usages_hour_method_caller,priority:1"`
+	Method        string    `gorm:"not null;uniqueIndex:idx_request_usages_hour_method_caller,priority:2"`
+	Caller        string    `gorm:"not null;uniqueIndex:idx_request_usages_hour_method_caller,priority:3"` // Address
+	Requests      int64     `gorm:"not null"`
+	Errors        int64     `gorm:"not null"`
+	RequestBytes  int64     `gorm:"not null"` // Encoded protobuf messages, excluding transport framing
+	ResponseBytes int64     `gorm:"not null"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (RequestUsage) TableName() string {
+	return "request_usages"
+}
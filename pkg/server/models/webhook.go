@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Webhook is an endpoint notified of system events, such as a client going
+// offline. Alert notifications are configured per rule with AlertAction.
+type Webhook struct {
+	ID             uint   `gorm:"primaryKey"`
+	WebhookID      string `gorm:"uniqueIndex;not null"`
+	Name           string `gorm:"not null"`
+	URL            string `gorm:"not null"`
+	Secret         string // Signs deliveries; empty sends them unsigned
+	EventTypes     string `gorm:"type:text"` // JSON array of event types; empty for every type
+	Enabled        bool   `gorm:"index"`
+	LastDeliveryAt *time.Time
+	LastStatus     string // HTTP status or error of the last delivery
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (Webhook) TableName() string {
+	return "webhooks"
+}
@@ -0,0 +1,103 @@
+package mute
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// MaxDuration bounds mutes and snoozes so notifications always come back
+const MaxDuration = 7 * 24 * time.Hour
+
+// Global is the fleet-wide notification mute
+type Global struct {
+	Until  time.Time // Zero when not muted
+	Reason string
+}
+
+// Active reports whether the mute is in effect at now
+func (g Global) Active(now time.Time) bool {
+	return now.Before(g.Until)
+}
+
+// Get loads the global mute. An expired mute reads as not muted, so it lifts
+// on its own without being cleared.
+func Get(db *gorm.DB) (Global, error) {
+	var settings []models.Setting
+	err := db.Where("key IN ?", []string{models.SettingAlertsMutedUntil, models.SettingAlertsMuteReason}).
+		Find(&settings).Error
+	if err != nil {
+		return Global{}, fmt.Errorf("failed to load mute settings: %w", err)
+	}
+
+	var global Global
+	for _, setting := range settings {
+		switch setting.Key {
+		case models.SettingAlertsMutedUntil:
+			if setting.Value != "" {
+				until, err := time.Parse(time.RFC3339, setting.Value)
+				if err != nil {
+					return Global{}, fmt.Errorf("invalid %s setting %q: %w", setting.Key, setting.Value, err)
+				}
+				global.Until = until
+			}
+		case models.SettingAlertsMuteReason:
+			global.Reason = setting.Value
+		}
+	}
+	if !global.Active(time.Now()) {
+		return Global{}, nil
+	}
+	return global, nil
+}
+
+// Set mutes notifications until the given time, or unmutes them when it is
+// zero
+func Set(db *gorm.DB, global Global) error {
+	until := ""
+	if !global.Until.IsZero() {
+		until = global.Until.UTC().Format(time.RFC3339)
+	} else {
+		global.Reason = ""
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for key, value := range map[string]string{
+			models.SettingAlertsMutedUntil: until,
+			models.SettingAlertsMuteReason: global.Reason,
+		} {
+			// Assign a map so empty values are written too
+			var setting models.Setting
+			err := tx.Where("key = ?", key).
+				Attrs(models.Setting{Key: key, ValueType: "string", Category: "alerts"}).
+				Assign(map[string]interface{}{"value": value}).
+				FirstOrCreate(&setting).Error
+			if err != nil {
+				return fmt.Errorf("failed to save %s setting: %w", key, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Silenced reports whether an alert of rule raised at now should skip its
+// notifications, because notifications are muted or the rule is snoozed. A
+// mute that cannot be read does not silence anything.
+func Silenced(db *gorm.DB, rule models.AlertRule, now time.Time) bool {
+	if rule.SnoozedUntil != nil && now.Before(*rule.SnoozedUntil) {
+		return true
+	}
+	return Muted(db, now)
+}
+
+// Muted reports whether notifications are muted fleet-wide at now
+func Muted(db *gorm.DB, now time.Time) bool {
+	global, err := Get(db)
+	if err != nil {
+		log.Printf("Failed to check notification mute: %v", err)
+		return false
+	}
+	return global.Active(now)
+}
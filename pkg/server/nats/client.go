@@ -0,0 +1,288 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotConnected is returned when publishing while the client is disconnected
+var ErrNotConnected = errors.New("not connected to NATS")
+
+// Handler receives messages for a subscription
+type Handler func(subject string, data []byte)
+
+// Client is a minimal client for the NATS core text protocol. A single
+// connection, owned by Run, carries publishes and subscriptions; it is
+// re-established with backoff and subscriptions are replayed on reconnect.
+type Client struct {
+	addr    string
+	connect map[string]interface{}
+
+	mu   sync.Mutex // Serializes writes and guards conn and subs
+	conn net.Conn
+	subs []subscription
+}
+
+type subscription struct {
+	subject string
+	queue   string
+	handler Handler
+}
+
+// NewClient parses nats://[user:password@|token@]host[:port]. The name is
+// reported to the server and used in log messages.
+func NewClient(rawURL, name string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("invalid NATS URL %q: scheme must be nats", rawURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	connect := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     name,
+		"lang":     "go",
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			connect["user"] = u.User.Username()
+			connect["pass"] = password
+		} else {
+			connect["auth_token"] = u.User.Username()
+		}
+	}
+	return &Client{addr: addr, connect: connect}, nil
+}
+
+// Subscribe registers a handler for a subject, which may contain wildcards.
+// Handlers run on the connection's read loop and should not block for long.
+func (c *Client) Subscribe(subject string, handler Handler) {
+	c.QueueSubscribe(subject, "", handler)
+}
+
+// QueueSubscribe registers a handler in a queue group, so each message is
+// delivered to only one member of the group across all connections
+func (c *Client) QueueSubscribe(subject, queue string, handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub := subscription{subject: subject, queue: queue, handler: handler}
+	c.subs = append(c.subs, sub)
+	if c.conn != nil {
+		io.WriteString(c.conn, sub.command(len(c.subs)))
+	}
+}
+
+// command returns the SUB protocol line for the subscription
+func (s subscription) command(sid int) string {
+	if s.queue == "" {
+		return fmt.Sprintf("SUB %s %d\r\n", s.subject, sid)
+	}
+	return fmt.Sprintf("SUB %s %s %d\r\n", s.subject, s.queue, sid)
+}
+
+// Publish sends a message, failing with ErrNotConnected while disconnected
+func (c *Client) Publish(ctx context.Context, subject string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(data), data)
+	if _, err := io.WriteString(c.conn, msg); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	return nil
+}
+
+// Run keeps the client connected until ctx is done
+func (c *Client) Run(ctx context.Context) {
+	name, _ := c.connect["name"].(string)
+	for attempt := 0; ; attempt++ {
+		err := c.serve(ctx, func() { attempt = 0 })
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("NATS connection for %s failed: %v", name, err)
+
+		delay := min(time.Second<<min(attempt, 5), 30*time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// serve connects, replays subscriptions, and processes protocol messages
+// until the connection fails or ctx is done
+func (c *Client) serve(ctx context.Context, connected func()) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS at %s: %w", c.addr, err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	if err := c.handshake(conn, r); err != nil {
+		return err
+	}
+	connected()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+	}()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			subject, sid, data, err := readMsg(r, line)
+			if err != nil {
+				return err
+			}
+			if handler := c.handler(sid); handler != nil {
+				handler(subject, data)
+			}
+		case line == "PING":
+			if err := c.write("PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(line[4:]))
+		}
+	}
+}
+
+// handshake reads the server INFO, sends CONNECT and the subscriptions, and
+// waits for the PONG that confirms the server accepted them
+func (c *Client) handshake(conn net.Conn, r *bufio.Reader) error {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	info, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read NATS INFO: %w", err)
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(info))
+	}
+
+	connect, err := json.Marshal(c.connect)
+	if err != nil {
+		return err
+	}
+
+	// Hold the lock until the connection is published so Subscribe calls in
+	// between are neither lost nor sent twice
+	c.mu.Lock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "CONNECT %s\r\n", connect)
+	for i, sub := range c.subs {
+		b.WriteString(sub.command(i + 1))
+	}
+	b.WriteString("PING\r\n")
+	_, err = io.WriteString(conn, b.String())
+	if err == nil {
+		err = waitPong(r)
+	}
+	if err == nil {
+		c.conn = conn
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func waitPong(r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to connect to NATS: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "MSG "):
+			// Skip the payload of a message that raced the handshake
+			if _, _, _, err := readMsg(r, line); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS rejected connection: %s", strings.TrimSpace(line[4:]))
+		}
+	}
+}
+
+func (c *Client) handler(sid int) Handler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sid < 1 || sid > len(c.subs) {
+		return nil
+	}
+	return c.subs[sid-1].handler
+}
+
+func (c *Client) write(s string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	_, err := io.WriteString(c.conn, s)
+	return err
+}
+
+// readMsg parses "MSG <subject> <sid> [reply-to] <#bytes>" and its payload
+func readMsg(r *bufio.Reader, line string) (string, int, []byte, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields) > 5 {
+		return "", 0, nil, fmt.Errorf("malformed NATS message %q", line)
+	}
+	sid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("malformed NATS message %q", line)
+	}
+	n, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || n < 0 {
+		return "", 0, nil, fmt.Errorf("malformed NATS message %q", line)
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", 0, nil, err
+	}
+	return fields[1], sid, buf[:n], nil
+}
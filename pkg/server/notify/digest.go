@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/cron"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+)
+
+// inQuietHours reports whether local is in a channel's quiet hours.
+// Schedules that do not parse are ignored; they are validated when the
+// channel is saved.
+func inQuietHours(channel *models.NotificationChannel, local time.Time) bool {
+	if channel.QuietHours == "" {
+		return false
+	}
+	schedule, err := cron.Parse(channel.QuietHours)
+	if err != nil {
+		log.Printf("Ignoring quiet hours %q of channel %s: %v", channel.QuietHours, channel.ChannelID, err)
+		return false
+	}
+	return schedule.Matches(local)
+}
+
+// holds reports whether a channel holds a notification sent at local for a
+// digest rather than sending it, because the channel sends digests or is in
+// quiet hours, and the notification is below the severity that bypasses it
+func holds(channel *models.NotificationChannel, n *Notification, local time.Time) bool {
+	below := func(bypass string) bool {
+		return bypass == "" || alertv1.Severity_value[n.Alert.Severity] < alertv1.Severity_value[bypass]
+	}
+	if channel.DigestIntervalSeconds > 0 && below(channel.DigestBypassSeverity) {
+		return true
+	}
+	return inQuietHours(channel, local) && below(channel.QuietBypassSeverity)
+}
+
+// digestDue reports whether a channel's held notifications, oldest first,
+// are due to be sent at now
+func digestDue(channel *models.NotificationChannel, held []models.HeldNotification, now time.Time) bool {
+	if channel.DigestIntervalSeconds <= 0 {
+		return true
+	}
+	interval := time.Duration(channel.DigestIntervalSeconds) * time.Second
+	return !now.Before(held[0].NotifiedAt.Add(interval))
+}
+
+// hold keeps a notification for the channel's next digest
+func (r *Router) hold(channel *models.NotificationChannel, n *Notification) {
+	held := &models.HeldNotification{
+		ChannelID:  channel.ChannelID,
+		Type:       n.Type,
+		AlertID:    n.Alert.AlertID,
+		Severity:   n.Alert.Severity,
+		Title:      n.Title(),
+		NotifiedAt: n.Time,
+	}
+	if err := r.db.WithContext(r.ctx).Create(held).Error; err != nil {
+		log.Printf("Failed to hold notification of alert %s for channel %s: %v", n.Alert.AlertID, channel.ChannelID, err)
+	}
+}
+
+// Flush sends the notifications each channel held as one digest, once the
+// channel's digest interval has passed since the first of them and outside
+// its quiet hours. Notifications held for channels since disabled or deleted
+// are dropped.
+func (r *Router) Flush(ctx context.Context) error {
+	db := r.db.WithContext(ctx)
+	var held []models.HeldNotification
+	if err := db.Order("notified_at, id").Find(&held).Error; err != nil {
+		return fmt.Errorf("failed to load held notifications: %w", err)
+	}
+	if len(held) == 0 {
+		return nil
+	}
+	byChannel := make(map[string][]models.HeldNotification)
+	var channelIDs []string
+	for _, h := range held {
+		if _, ok := byChannel[h.ChannelID]; !ok {
+			channelIDs = append(channelIDs, h.ChannelID)
+		}
+		byChannel[h.ChannelID] = append(byChannel[h.ChannelID], h)
+	}
+
+	var channels []models.NotificationChannel
+	if err := db.Where("channel_id IN ? AND enabled = ?", channelIDs, true).Find(&channels).Error; err != nil {
+		return fmt.Errorf("failed to load channels: %w", err)
+	}
+	loc, err := timezone.Load(db)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	locale := r.locale(ctx)
+
+	var failed int
+	for _, channel := range channels {
+		entries := byChannel[channel.ChannelID]
+		delete(byChannel, channel.ChannelID)
+		if inQuietHours(&channel, now.In(loc)) || !digestDue(&channel, entries, now) {
+			continue
+		}
+
+		n := &Notification{
+			ID:     uuid.New().String(),
+			Type:   TypeDigest,
+			Time:   now.UTC(),
+			Held:   entries,
+			Locale: channelLocale(&channel, locale),
+		}
+		result, err := r.send(ctx, &channel, n)
+		r.record(&channel, result)
+		if err != nil {
+			log.Printf("Channel %s failed to receive a digest of %d notifications: %v", channel.ChannelID, len(entries), err)
+			failed++
+			continue
+		}
+		if err := r.release(ctx, entries); err != nil {
+			return err
+		}
+	}
+	for _, entries := range byChannel {
+		if err := r.release(ctx, entries); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d digests failed and will be retried", failed)
+	}
+	return nil
+}
+
+// release deletes held notifications
+func (r *Router) release(ctx context.Context, entries []models.HeldNotification) error {
+	ids := make([]uint, len(entries))
+	for i, h := range entries {
+		ids[i] = h.ID
+	}
+	if err := r.db.WithContext(ctx).Delete(&models.HeldNotification{}, ids).Error; err != nil {
+		return fmt.Errorf("failed to delete held notifications: %w", err)
+	}
+	return nil
+}
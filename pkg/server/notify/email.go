@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+)
+
+// sendEmail sends a notification through the SMTP server of the email
+// settings to the channel's recipients
+func (r *Router) sendEmail(ctx context.Context, config map[string]string, n *Notification) (string, error) {
+	var stored []models.Setting
+	if err := r.db.WithContext(ctx).Where("category = ?", "email").Find(&stored).Error; err != nil {
+		return "failed to load email settings", fmt.Errorf("failed to load email settings: %w", err)
+	}
+	settings := make(map[string]string, len(stored))
+	for _, setting := range stored {
+		settings[setting.Key] = setting.Value
+	}
+	host := settings[models.SettingEmailSMTPHost]
+	from := settings[models.SettingEmailFrom]
+	if host == "" || from == "" {
+		err := errors.New("the email settings have no SMTP host or from address")
+		return err.Error(), err
+	}
+	port := settings[models.SettingEmailSMTPPort]
+	if port == "" {
+		port = "587"
+	}
+
+	var recipients []string
+	for _, recipient := range strings.Split(config["recipients"], ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	subject := n.Title()
+	// Only email actions of rules set the whole subject
+	if custom := config["subject"]; custom != "" {
+		subject = custom
+	}
+	if prefix := config["subject_prefix"]; prefix != "" {
+		subject = prefix + " " + subject
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@jacuzzi>\r\n", n.ID)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+
+	addr := net.JoinHostPort(host, port)
+	err := sendMail(ctx, addr, host, settings, from, recipients, msg.String())
+	if err != nil {
+		return err.Error(), err
+	}
+	return fmt.Sprintf("sent to %d recipients through %s", len(recipients), addr), nil
+}
+
+// sendMail delivers a message, upgrading to TLS when the server offers it,
+// or failing when email.use_tls is set and it does not
+func sendMail(ctx context.Context, addr, host string, settings map[string]string, from string, to []string, msg string) error {
+	envelope, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid from address %q: %w", from, err)
+	}
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("%s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	} else if settings[models.SettingEmailUseTLS] != "false" {
+		return fmt.Errorf("%s does not offer STARTTLS but email.use_tls is enabled", addr)
+	}
+	if username := settings[models.SettingEmailUsername]; username != "" {
+		if err := client.Auth(smtp.PlainAuth("", username, settings[models.SettingEmailPassword], host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(envelope.Address); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", recipient, err)
+		}
+		if err := client.Rcpt(address.Address); err != nil {
+			return fmt.Errorf("recipient %s refused: %w", address.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
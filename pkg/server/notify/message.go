@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Title is a one-line summary, e.g. "[CRITICAL] CPU too hot on web-1"
+func (n *Notification) Title() string {
+	l := n.Locale
+	switch n.Type {
+	case TypeTest:
+		return l.T("Jacuzzi test notification")
+	case TypeDigest:
+		if len(n.Held) == 1 {
+			return l.T("Jacuzzi digest: 1 alert notification")
+		}
+		return l.T("Jacuzzi digest: %d alert notifications", len(n.Held))
+	}
+	status := l.Severity(n.Alert.Severity)
+	if n.Type == TypeResolved {
+		status = l.T("RESOLVED")
+	}
+	name := n.Alert.RuleID
+	if n.Rule != nil {
+		name = n.Rule.Name
+	}
+	if host := n.host(); host != "" {
+		return l.T("[%s] %s on %s", status, name, host)
+	}
+	return fmt.Sprintf("[%s] %s", status, name)
+}
+
+// Text is the body of the notification in plain text
+func (n *Notification) Text() string {
+	var b strings.Builder
+	switch n.Type {
+	case TypeTest:
+		return n.Locale.T("This channel receives Jacuzzi alert notifications.")
+	case TypeDigest:
+		fmt.Fprintf(&b, "%s\n", n.Locale.T("Notifications held since the last digest:"))
+		for _, line := range n.Lines() {
+			fmt.Fprintf(&b, "%s\n", line)
+		}
+		return b.String()
+	}
+	if n.Alert.Message != "" {
+		fmt.Fprintf(&b, "%s\n\n", n.Alert.Message)
+	}
+	for _, field := range n.Fields() {
+		fmt.Fprintf(&b, "%s: %s\n", field[0], field[1])
+	}
+	return b.String()
+}
+
+// Fields are the details of the notification as name and value pairs, the
+// alert ID last
+func (n *Notification) Fields() [][2]string {
+	l := n.Locale
+	alert := n.Alert
+	fields := [][2]string{{l.T("Severity"), l.Severity(alert.Severity)}}
+	if host := n.host(); host != "" {
+		fields = append(fields, [2]string{l.T("Client"), host})
+	}
+	if n.Client != nil && n.Client.Site != "" {
+		location := n.Client.Site
+		if n.Client.Rack != "" {
+			location += " / " + n.Client.Rack
+		}
+		fields = append(fields, [2]string{l.T("Location"), location})
+	}
+	if alert.SensorID != "" {
+		sensor := alert.SensorID
+		if n.SensorName != "" {
+			sensor = n.SensorName
+		}
+		fields = append(fields, [2]string{l.T("Sensor"), sensor})
+	}
+	fields = append(fields,
+		[2]string{l.T("Value"), fmt.Sprintf("%.1f", alert.Value)},
+		[2]string{l.T("Triggered"), alert.TriggeredAt.UTC().Format(time.RFC3339)},
+	)
+	if n.Type == TypeResolved && alert.ResolvedAt != nil {
+		fields = append(fields, [2]string{l.T("Resolved"), alert.ResolvedAt.UTC().Format(time.RFC3339)})
+	}
+	return append(fields, [2]string{l.T("Alert"), alert.AlertID})
+}
+
+// Lines summarize the notifications of a digest, one per line, e.g.
+// "2025-01-02T23:15:00Z [CRITICAL] CPU too hot on web-1"
+func (n *Notification) Lines() []string {
+	lines := make([]string, len(n.Held))
+	for i, held := range n.Held {
+		lines[i] = held.NotifiedAt.UTC().Format(time.RFC3339) + " " + held.Title
+	}
+	return lines
+}
+
+// host names the alert's client, or is empty for aggregate alerts
+func (n *Notification) host() string {
+	if n.Client != nil && n.Client.Hostname != "" {
+		return n.Client.Hostname
+	}
+	return n.Alert.ClientID
+}
@@ -0,0 +1,417 @@
+// Package notify sends alert notifications to channels, such as email lists
+// and Slack webhooks, chosen by the routes an alert matches.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"gorm.io/gorm"
+)
+
+// Channel types
+const (
+	ChannelTypeEmail   = "TYPE_EMAIL"
+	ChannelTypeSlack   = "TYPE_SLACK"
+	ChannelTypeWebhook = "TYPE_WEBHOOK"
+)
+
+// Notification types, also the event types of webhook channel deliveries
+const (
+	TypeTriggered = "alert.triggered"
+	TypeResolved  = "alert.resolved"
+	TypeTest      = "notification.test"
+	TypeDigest    = "notification.digest"
+)
+
+// Rule action types sent like channels of the same type, with the action's
+// config
+const (
+	ActionTypeEmail   = "ACTION_TYPE_EMAIL"
+	ActionTypeWebhook = "ACTION_TYPE_WEBHOOK"
+)
+
+// SecretConfig lists the config keys of each channel type that the API
+// never returns
+var SecretConfig = map[string][]string{
+	ChannelTypeWebhook: {"secret"},
+}
+
+// retryDelays are the waits before each retry of a failed delivery
+var retryDelays = []time.Duration{5 * time.Second, 30 * time.Second}
+
+// Notification is an alert change sent to a channel, with what it is about
+type Notification struct {
+	ID         string
+	Type       string // TypeTriggered, TypeResolved, TypeTest or TypeDigest
+	Time       time.Time
+	Alert      *models.Alert     // Nil for tests and digests
+	Rule       *models.AlertRule // Nil when the rule is gone
+	Client     *models.Client    // Nil for aggregate alerts
+	SensorType string
+	SensorName string
+	Held       []models.HeldNotification // Digests only
+	Locale     i18n.Locale               // Language of the channel it is sent to
+}
+
+// Router sends alerts to the channels of the routes they match. Sending
+// happens in the background, so routing never blocks the caller. A nil
+// Router drops every alert.
+type Router struct {
+	db     *gorm.DB
+	client *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRouter(db *gorm.DB, timeout time.Duration) *Router {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Router{
+		db:     db,
+		client: &http.Client{Timeout: timeout},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Close abandons pending retries and waits for deliveries in flight
+func (r *Router) Close() {
+	if r == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Triggered routes an alert that triggered
+func (r *Router) Triggered(alert *models.Alert) {
+	r.route(TypeTriggered, alert)
+}
+
+// Resolved routes an alert that resolved, to the channels that send
+// resolutions
+func (r *Router) Resolved(alert *models.Alert) {
+	r.route(TypeResolved, alert)
+}
+
+// route sends an alert to its channels and, when it triggered, to the email
+// and webhook actions of its rule, or holds it for channels that send
+// digests or are in quiet hours. Alerts raised while muted or flapping
+// notified nothing, so neither does their resolution, and alerts received
+// from Alertmanager or Grafana were notified by their sender.
+func (r *Router) route(notificationType string, alert *models.Alert) {
+	if r == nil || r.ctx.Err() != nil {
+		return
+	}
+	if alert.Muted || alert.Flapping || alert.ExternalID != "" {
+		return
+	}
+	snapshot := *alert
+	snapshot.Context = nil
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		n, err := r.describe(notificationType, &snapshot)
+		if err != nil {
+			log.Printf("Failed to route alert %s: %v", snapshot.AlertID, err)
+			return
+		}
+		channels, err := r.channels(n)
+		if err != nil {
+			log.Printf("Failed to route alert %s: %v", snapshot.AlertID, err)
+			return
+		}
+		channels = append(channels, ruleChannels(n)...)
+		if len(channels) == 0 {
+			return
+		}
+		loc, err := timezone.Load(r.db.WithContext(r.ctx))
+		if err != nil {
+			log.Printf("Failed to route alert %s: %v", snapshot.AlertID, err)
+			return
+		}
+		locale := r.locale(r.ctx)
+		for _, channel := range channels {
+			localized := *n
+			localized.Locale = channelLocale(&channel, locale)
+			if holds(&channel, &localized, n.Time.In(loc)) {
+				r.hold(&channel, &localized)
+				continue
+			}
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				r.deliver(channel, &localized)
+			}()
+		}
+	}()
+}
+
+// describe loads the rule, client and sensor of an alert
+func (r *Router) describe(notificationType string, alert *models.Alert) (*Notification, error) {
+	db := r.db.WithContext(r.ctx)
+	n := &Notification{
+		ID:    uuid.New().String(),
+		Type:  notificationType,
+		Time:  time.Now().UTC(),
+		Alert: alert,
+	}
+
+	var rule models.AlertRule
+	err := db.Unscoped().Preload("Actions").Where("rule_id = ?", alert.RuleID).First(&rule).Error
+	switch {
+	case err == nil:
+		n.Rule = &rule
+		n.SensorType = rule.SensorType
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("failed to load rule %s: %w", alert.RuleID, err)
+	}
+
+	if alert.ClientID != "" {
+		var client models.Client
+		err := db.Where("client_id = ?", alert.ClientID).First(&client).Error
+		switch {
+		case err == nil:
+			n.Client = &client
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, fmt.Errorf("failed to load client %s: %w", alert.ClientID, err)
+		}
+	}
+
+	if alert.SensorID != "" {
+		var sensors []models.Sensor
+		err := db.Where("client_id = ? AND sensor_id = ?", alert.ClientID, alert.SensorID).Limit(1).Find(&sensors).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load sensor %s: %w", alert.SensorID, err)
+		}
+		if len(sensors) == 1 {
+			n.SensorType = sensors[0].SensorType
+			n.SensorName = sensors[0].SensorName
+		}
+	}
+	return n, nil
+}
+
+// channels returns the enabled channels of the routes a notification
+// matches, each once
+func (r *Router) channels(n *Notification) ([]models.NotificationChannel, error) {
+	db := r.db.WithContext(r.ctx)
+	var routes []models.NotificationRoute
+	if err := db.Where("enabled = ?", true).Order("position, name, route_id").Find(&routes).Error; err != nil {
+		return nil, fmt.Errorf("failed to load routes: %w", err)
+	}
+
+	var ids []string
+	for _, route := range routes {
+		matched, err := Matches(&route, n)
+		if err != nil {
+			log.Printf("Skipping route %s: %v", route.RouteID, err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+		var channelIDs []string
+		if route.ChannelIDs != "" {
+			if err := json.Unmarshal([]byte(route.ChannelIDs), &channelIDs); err != nil {
+				log.Printf("Skipping route %s: invalid channel IDs: %v", route.RouteID, err)
+				continue
+			}
+		}
+		for _, id := range channelIDs {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		if route.Stop {
+			break
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := db.Where("enabled = ? AND channel_id IN ?", true, ids)
+	if n.Type == TypeResolved {
+		query = query.Where("send_resolved = ?", true)
+	}
+	var channels []models.NotificationChannel
+	if err := query.Find(&channels).Error; err != nil {
+		return nil, fmt.Errorf("failed to load channels: %w", err)
+	}
+	return channels, nil
+}
+
+// ruleChannels returns the email and webhook actions of a triggered alert's
+// rule as channels of their own, which are never held
+func ruleChannels(n *Notification) []models.NotificationChannel {
+	if n.Type != TypeTriggered || n.Rule == nil {
+		return nil
+	}
+	var channels []models.NotificationChannel
+	for i, action := range n.Rule.Actions {
+		var channelType string
+		switch action.Type {
+		case ActionTypeEmail:
+			channelType = ChannelTypeEmail
+		case ActionTypeWebhook:
+			channelType = ChannelTypeWebhook
+		default:
+			continue
+		}
+		channels = append(channels, models.NotificationChannel{
+			ChannelID: fmt.Sprintf("rule %s action %d", n.Rule.RuleID, i+1),
+			Name:      n.Rule.Name,
+			Type:      channelType,
+			Config:    action.Config,
+			Enabled:   true,
+		})
+	}
+	return channels
+}
+
+// Matches reports whether a route matches a notification's alert
+func Matches(route *models.NotificationRoute, n *Notification) (bool, error) {
+	var severities, sensorTypes []string
+	var selector map[string]string
+	for _, field := range []struct {
+		stored string
+		into   interface{}
+	}{
+		{route.Severities, &severities},
+		{route.SensorTypes, &sensorTypes},
+		{route.MetadataSelector, &selector},
+	} {
+		if field.stored == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(field.stored), field.into); err != nil {
+			return false, fmt.Errorf("invalid matcher: %w", err)
+		}
+	}
+
+	if len(severities) > 0 && !slices.Contains(severities, n.Alert.Severity) {
+		return false, nil
+	}
+	if len(sensorTypes) > 0 && !slices.Contains(sensorTypes, n.SensorType) {
+		return false, nil
+	}
+	if len(selector) > 0 {
+		if n.Client == nil {
+			return false, nil
+		}
+		var metadata map[string]string
+		if n.Client.Metadata != "" {
+			json.Unmarshal([]byte(n.Client.Metadata), &metadata)
+		}
+		for key, value := range selector {
+			if metadata[key] != value {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// deliver sends a notification to a channel, retrying failures, and records
+// the outcome of the last attempt on the channel
+func (r *Router) deliver(channel models.NotificationChannel, n *Notification) {
+	var result string
+	for attempt := 0; ; attempt++ {
+		var err error
+		result, err = r.send(r.ctx, &channel, n)
+		if err == nil || attempt == len(retryDelays) {
+			if err != nil {
+				log.Printf("Channel %s failed to receive notification of alert %s: %v", channel.ChannelID, n.Alert.AlertID, err)
+			}
+			break
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(retryDelays[attempt]):
+		}
+	}
+	r.record(&channel, result)
+}
+
+// Test sends a test notification to a channel once, enabled or not and
+// regardless of quiet hours
+func (r *Router) Test(ctx context.Context, channel *models.NotificationChannel) (string, error) {
+	n := &Notification{
+		ID:     uuid.New().String(),
+		Type:   TypeTest,
+		Time:   time.Now().UTC(),
+		Locale: channelLocale(channel, r.locale(ctx)),
+	}
+	result, err := r.send(ctx, channel, n)
+	r.record(channel, result)
+	return result, err
+}
+
+// locale returns the language of notifications to channels without their
+// own
+func (r *Router) locale(ctx context.Context) i18n.Locale {
+	locale, err := i18n.Load(r.db.WithContext(ctx))
+	if err != nil {
+		log.Printf("Writing notifications in the default locale: %v", err)
+	}
+	return locale
+}
+
+// channelLocale returns the language of a channel's notifications
+func channelLocale(channel *models.NotificationChannel, fallback i18n.Locale) i18n.Locale {
+	if locale, ok := i18n.Parse(channel.Locale); ok {
+		return locale
+	}
+	return fallback
+}
+
+// record stores the result of a delivery on a stored channel
+func (r *Router) record(channel *models.NotificationChannel, result string) {
+	if channel.ID == 0 {
+		return
+	}
+	now := time.Now()
+	err := r.db.Model(&models.NotificationChannel{}).
+		Where("id = ?", channel.ID).
+		UpdateColumns(map[string]interface{}{"last_delivery_at": now, "last_status": result}).Error
+	if err != nil {
+		log.Printf("Failed to record delivery for channel %s: %v", channel.ChannelID, err)
+	}
+}
+
+// send makes one delivery attempt and describes its result
+func (r *Router) send(ctx context.Context, channel *models.NotificationChannel, n *Notification) (string, error) {
+	config := make(map[string]string)
+	if channel.Config != "" {
+		if err := json.Unmarshal([]byte(channel.Config), &config); err != nil {
+			return "invalid config", fmt.Errorf("invalid config: %w", err)
+		}
+	}
+	switch channel.Type {
+	case ChannelTypeEmail:
+		return r.sendEmail(ctx, config, n)
+	case ChannelTypeSlack:
+		return r.sendSlack(ctx, config, n)
+	case ChannelTypeWebhook:
+		return r.sendWebhook(ctx, config, n)
+	}
+	err := fmt.Errorf("unknown channel type %q", channel.Type)
+	return err.Error(), err
+}
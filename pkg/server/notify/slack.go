@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Attachment colors by severity
+var slackColors = map[string]string{
+	"SEVERITY_CRITICAL": "danger",
+	"SEVERITY_WARNING":  "warning",
+	"SEVERITY_INFO":     "#439fe0",
+}
+
+// sendSlack posts a notification to a Slack incoming webhook, as a message
+// with an attachment colored by severity
+func (r *Router) sendSlack(ctx context.Context, config map[string]string, n *Notification) (string, error) {
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	type attachment struct {
+		Fallback string  `json:"fallback"`
+		Color    string  `json:"color,omitempty"`
+		Title    string  `json:"title"`
+		Text     string  `json:"text,omitempty"`
+		Fields   []field `json:"fields,omitempty"`
+	}
+	message := attachment{Fallback: n.Title(), Title: n.Title()}
+	switch n.Type {
+	case TypeTest:
+		message.Text = n.Text()
+	case TypeDigest:
+		message.Text = strings.Join(n.Lines(), "\n")
+	default:
+		message.Text = n.Alert.Message
+		message.Color = slackColors[n.Alert.Severity]
+		if n.Type == TypeResolved {
+			message.Color = "good"
+		}
+		fields := n.Fields()
+		for i, f := range fields {
+			// The alert ID, last, is too long to share a line
+			message.Fields = append(message.Fields, field{Title: f[0], Value: f[1], Short: i < len(fields)-1})
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"text":        n.Title(),
+		"attachments": []attachment{message},
+	})
+	if err != nil {
+		return err.Error(), err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config["url"], bytes.NewReader(body))
+	if err != nil {
+		return err.Error(), err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return r.post(req)
+}
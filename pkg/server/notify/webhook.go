@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
+)
+
+// Rule describes the rule of a notified alert
+type Rule struct {
+	RuleID string `json:"rule_id"`
+	Name   string `json:"name"`
+}
+
+// Alert is the data of a webhook channel delivery
+type Alert struct {
+	Alert  webhook.Alert   `json:"alert"`
+	Rule   *Rule           `json:"rule,omitempty"`   // Unset when the rule is gone
+	Client *webhook.Client `json:"client,omitempty"` // Unset for aggregate alerts
+	Title  string          `json:"title"`
+}
+
+// Digest is the data of a digest delivery
+type Digest struct {
+	Title         string        `json:"title"`
+	Notifications []DigestEntry `json:"notifications"`
+}
+
+// DigestEntry is a notification a digest summarizes
+type DigestEntry struct {
+	Type     string    `json:"type"` // alert.triggered or alert.resolved
+	Time     time.Time `json:"time"`
+	AlertID  string    `json:"alert_id"`
+	Severity string    `json:"severity"`
+	Title    string    `json:"title"`
+}
+
+// Test is the data of a test delivery
+type Test struct {
+	Message string `json:"message"`
+}
+
+// sendWebhook posts a notification as JSON, in the envelope and with the
+// headers of event webhooks
+func (r *Router) sendWebhook(ctx context.Context, config map[string]string, n *Notification) (string, error) {
+	event := webhook.Event{ID: n.ID, Type: n.Type, Time: n.Time}
+	switch n.Type {
+	case TypeTest:
+		event.Data = Test{Message: n.Text()}
+	case TypeDigest:
+		digest := Digest{Title: n.Title(), Notifications: make([]DigestEntry, len(n.Held))}
+		for i, held := range n.Held {
+			digest.Notifications[i] = DigestEntry{
+				Type:     held.Type,
+				Time:     held.NotifiedAt,
+				AlertID:  held.AlertID,
+				Severity: held.Severity,
+				Title:    held.Title,
+			}
+		}
+		event.Data = digest
+	default:
+		alert := n.Alert
+		data := Alert{
+			Alert: webhook.Alert{
+				AlertID:     alert.AlertID,
+				RuleID:      alert.RuleID,
+				SensorID:    alert.SensorID,
+				Severity:    alert.Severity,
+				Value:       alert.Value,
+				Message:     alert.Message,
+				TriggeredAt: alert.TriggeredAt,
+				ResolvedAt:  alert.ResolvedAt,
+			},
+			Title: n.Title(),
+		}
+		if n.Rule != nil {
+			data.Rule = &Rule{RuleID: n.Rule.RuleID, Name: n.Rule.Name}
+		}
+		if n.Client != nil {
+			client := webhook.ClientData(n.Client)
+			data.Client = &client
+		}
+		event.Data = data
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err.Error(), err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config["url"], bytes.NewReader(body))
+	if err != nil {
+		return err.Error(), err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jacuzzi-webhook")
+	req.Header.Set(webhook.HeaderEvent, event.Type)
+	req.Header.Set(webhook.HeaderDelivery, event.ID)
+	if secret := config["secret"]; secret != "" {
+		req.Header.Set(webhook.HeaderSignature, "sha256="+webhook.Sign(secret, body))
+	}
+	return r.post(req)
+}
+
+// post sends a request and describes its result
+func (r *Router) post(req *http.Request) (string, error) {
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err.Error(), err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.Status, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Status, nil
+}
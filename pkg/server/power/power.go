@@ -0,0 +1,143 @@
+package power
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Actions a controller can take on a machine
+const (
+	ActionWake     = "wake"        // Wake-on-LAN magic packet
+	ActionPowerOn  = "power_on"    // IPMI chassis power on
+	ActionPowerOff = "power_off"   // IPMI chassis power off, immediately
+	ActionSoftOff  = "soft_off"    // IPMI ACPI shutdown request
+	ActionCycle    = "power_cycle" // IPMI chassis power cycle
+	ActionReset    = "reset"       // IPMI hard reset
+	ActionStatus   = "status"      // IPMI chassis power status
+)
+
+// ipmiCommands maps actions to ipmitool chassis power subcommands
+var ipmiCommands = map[string]string{
+	ActionPowerOn:  "on",
+	ActionPowerOff: "off",
+	ActionSoftOff:  "soft",
+	ActionCycle:    "cycle",
+	ActionReset:    "reset",
+	ActionStatus:   "status",
+}
+
+// DefaultWakePort is the UDP port magic packets are sent to when the
+// broadcast address has none
+const DefaultWakePort = "9"
+
+// BMC is how to reach a machine's baseboard management controller
+type BMC struct {
+	Address   string // Host or host:port
+	Username  string
+	Password  string
+	Interface string // ipmitool interface, e.g. lanplus
+}
+
+// Controller wakes machines with Wake-on-LAN and controls their power through
+// ipmitool
+type Controller struct {
+	ipmitool  string
+	broadcast string
+}
+
+// NewController returns a controller that runs the ipmitool binary at path
+// and sends magic packets to broadcast (host[:port]) by default
+func NewController(ipmitool, broadcast string) *Controller {
+	return &Controller{ipmitool: ipmitool, broadcast: broadcast}
+}
+
+// Wake sends a Wake-on-LAN magic packet for mac to broadcast, or to the
+// controller's default broadcast address when empty
+func (c *Controller) Wake(ctx context.Context, mac, broadcast string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return fmt.Errorf("invalid MAC address %q", mac)
+	}
+	if broadcast == "" {
+		broadcast = c.broadcast
+	}
+	addr, err := WakeAddress(broadcast)
+	if err != nil {
+		return err
+	}
+
+	// Six 0xff bytes followed by the MAC sixteen times
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, hw...)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to open socket to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to send magic packet to %s: %w", addr, err)
+	}
+	return nil
+}
+
+// WakeAddress adds the default port to a broadcast address without one
+func WakeAddress(broadcast string) (string, error) {
+	if _, _, err := net.SplitHostPort(broadcast); err == nil {
+		return broadcast, nil
+	}
+	if broadcast == "" || strings.Contains(broadcast, ":") {
+		return "", fmt.Errorf("invalid broadcast address %q", broadcast)
+	}
+	return net.JoinHostPort(broadcast, DefaultWakePort), nil
+}
+
+// IPMI runs a chassis power action against a BMC and returns ipmitool's
+// output, e.g. "Chassis Power is on"
+func (c *Controller) IPMI(ctx context.Context, bmc BMC, action string) (string, error) {
+	subcommand, ok := ipmiCommands[action]
+	if !ok {
+		return "", fmt.Errorf("unknown IPMI action %q", action)
+	}
+	if bmc.Address == "" {
+		return "", fmt.Errorf("no BMC address is configured")
+	}
+
+	iface := bmc.Interface
+	if iface == "" {
+		iface = "lanplus"
+	}
+	host, port := bmc.Address, ""
+	if h, p, err := net.SplitHostPort(bmc.Address); err == nil {
+		host, port = h, p
+	}
+	args := []string{"-I", iface, "-H", host}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	if bmc.Username != "" {
+		args = append(args, "-U", bmc.Username)
+	}
+	// -E reads the password from IPMI_PASSWORD, keeping it out of the process list
+	args = append(args, "-E", "chassis", "power", subcommand)
+
+	cmd := exec.CommandContext(ctx, c.ipmitool, args...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+bmc.Password)
+	output, err := cmd.CombinedOutput()
+	result := strings.TrimSpace(string(output))
+	if err != nil {
+		if result != "" {
+			return "", fmt.Errorf("ipmitool %s failed: %v: %s", subcommand, err, result)
+		}
+		return "", fmt.Errorf("ipmitool %s failed: %w", subcommand, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package power
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks a stored value encrypted by Secrets; the version allows
+// changing the scheme later
+const sealedPrefix = "enc:v1:"
+
+// Secrets encrypts BMC passwords for storage, so a copy of the database
+// without the server's configuration does not reveal them
+type Secrets struct {
+	aead cipher.AEAD
+}
+
+// NewSecrets returns Secrets using AES-256-GCM with a key derived from the
+// configured secret key
+func NewSecrets(key string) (*Secrets, error) {
+	if key == "" {
+		return nil, errors.New("secret key is empty")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Secrets{aead: aead}, nil
+}
+
+// Seal encrypts a value for storage
+func (s *Secrets) Seal(value string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value stored by Seal
+func (s *Secrets) Open(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return "", errors.New("value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("encrypted value is malformed")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	value, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value, the secret key may have changed: %w", err)
+	}
+	return string(value), nil
+}
+
+// IsSealed reports whether a stored value was encrypted by Seal, rather than
+// saved in plain text before encryption was configured
+func IsSealed(stored string) bool {
+	return strings.HasPrefix(stored, sealedPrefix)
+}
@@ -0,0 +1,310 @@
+// Package profiles resolves the configuration each agent is sent, from the
+// profiles of the groups its client belongs to and the client's own
+// override, and queues it for agents that accept remote configuration.
+package profiles
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	commandv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/command/v1"
+	profilev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/profile/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// ConfigTTL is how long a configuration waits for its agent to poll; an agent
+// that comes back later is sent its configuration when it registers
+const ConfigTTL = 24 * time.Hour
+
+// Collectors are the sensor kinds a configuration may turn on or off
+var Collectors = []string{"cpu", "gpu", "disk"}
+
+const maxInterval = 24 * time.Hour
+
+// Validate checks a configuration, normalizing its collectors and threshold
+// sensor types
+func Validate(config *profilev1.AgentConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.IntervalSeconds < 0 || time.Duration(config.IntervalSeconds)*time.Second > maxInterval {
+		return fmt.Errorf("interval_seconds %d must be between 1 and %d", config.IntervalSeconds, int64(maxInterval.Seconds()))
+	}
+
+	var collectors []string
+	for _, collector := range config.Collectors {
+		collector = strings.ToLower(strings.TrimSpace(collector))
+		if !slices.Contains(Collectors, collector) {
+			return fmt.Errorf("unknown collector %q: must be one of %s", collector, strings.Join(Collectors, ", "))
+		}
+		if !slices.Contains(collectors, collector) {
+			collectors = append(collectors, collector)
+		}
+	}
+	config.Collectors = collectors
+
+	if config.HeartbeatSeconds < 0 || time.Duration(config.HeartbeatSeconds)*time.Second > maxInterval {
+		return fmt.Errorf("heartbeat_seconds %d must be between 1 and %d", config.HeartbeatSeconds, int64(maxInterval.Seconds()))
+	}
+
+	var err error
+	if config.Thresholds, err = normalizeThresholds("thresholds", config.Thresholds); err != nil {
+		return err
+	}
+	if config.ReportThresholds, err = normalizeThresholds("report_thresholds", config.ReportThresholds); err != nil {
+		return err
+	}
+	return nil
+}
+
+// normalizeThresholds checks thresholds by sensor type, keying them by
+// canonical type, or returns nil for none
+func normalizeThresholds(field string, thresholds map[string]float64) (map[string]float64, error) {
+	if len(thresholds) == 0 {
+		return nil, nil
+	}
+	normalized := make(map[string]float64, len(thresholds))
+	for sensorType, celsius := range thresholds {
+		canonical := sensortype.Normalize(sensorType)
+		if canonical == sensortype.Other && !strings.EqualFold(strings.TrimSpace(sensorType), sensortype.Other) {
+			return nil, fmt.Errorf("unknown sensor type %q in %s", sensorType, field)
+		}
+		if celsius <= 0 {
+			return nil, fmt.Errorf("%s for %s must be positive", field, canonical)
+		}
+		normalized[canonical] = celsius
+	}
+	return normalized, nil
+}
+
+// Encode stores a configuration, as empty when it sets nothing
+func Encode(config *profilev1.AgentConfig) (string, error) {
+	if isEmpty(config) {
+		return "", nil
+	}
+	data, err := protojson.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode config: %w", err)
+	}
+	return string(data), nil
+}
+
+// Decode reads a configuration stored by Encode
+func Decode(stored string) (*profilev1.AgentConfig, error) {
+	config := &profilev1.AgentConfig{}
+	if stored == "" {
+		return config, nil
+	}
+	if err := protojson.Unmarshal([]byte(stored), config); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	return config, nil
+}
+
+func isEmpty(config *profilev1.AgentConfig) bool {
+	return config == nil || config.IntervalSeconds == 0 && len(config.Collectors) == 0 && len(config.Thresholds) == 0 &&
+		len(config.ReportThresholds) == 0 && config.HeartbeatSeconds == 0
+}
+
+// Matches reports whether a profile applies to a client
+func Matches(profile *models.ConfigProfile, client *models.Client) bool {
+	if profile.Site != "" && profile.Site != client.Site {
+		return false
+	}
+	if profile.MetadataSelector == "" {
+		return true
+	}
+	var selector, metadata map[string]string
+	if err := json.Unmarshal([]byte(profile.MetadataSelector), &selector); err != nil {
+		log.Printf("Ignoring profile %s: invalid metadata selector: %v", profile.ProfileID, err)
+		return false
+	}
+	if client.Metadata != "" {
+		json.Unmarshal([]byte(client.Metadata), &metadata)
+	}
+	for key, value := range selector {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolved is the configuration of a client and the profiles it came from
+type Resolved struct {
+	Config     *profilev1.AgentConfig
+	ProfileIDs []string // Lowest priority first
+	Override   *profilev1.AgentConfig
+	Revision   string // Empty when the configuration sets nothing
+}
+
+// Resolve layers the profiles that apply to a client by priority, then its
+// override
+func Resolve(db *gorm.DB, client *models.Client) (*Resolved, error) {
+	profiles, err := load(db)
+	if err != nil {
+		return nil, err
+	}
+	return resolve(profiles, client)
+}
+
+// load returns every profile, lowest priority first
+func load(db *gorm.DB) ([]models.ConfigProfile, error) {
+	var profiles []models.ConfigProfile
+	if err := db.Order("priority, profile_id").Find(&profiles).Error; err != nil {
+		return nil, fmt.Errorf("failed to load config profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+func resolve(profiles []models.ConfigProfile, client *models.Client) (*Resolved, error) {
+	resolved := &Resolved{Config: &profilev1.AgentConfig{}}
+	for i := range profiles {
+		profile := &profiles[i]
+		if !Matches(profile, client) {
+			continue
+		}
+		config, err := Decode(profile.Config)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile.ProfileID, err)
+		}
+		layer(resolved.Config, config)
+		resolved.ProfileIDs = append(resolved.ProfileIDs, profile.ProfileID)
+	}
+
+	override, err := Decode(client.ConfigOverride)
+	if err != nil {
+		return nil, fmt.Errorf("override of client %s: %w", client.ClientID, err)
+	}
+	layer(resolved.Config, override)
+	resolved.Override = override
+	resolved.Revision = revision(resolved.Config)
+	return resolved, nil
+}
+
+// layer sets the fields of config that top sets
+func layer(config, top *profilev1.AgentConfig) {
+	if top.IntervalSeconds > 0 {
+		config.IntervalSeconds = top.IntervalSeconds
+	}
+	if len(top.Collectors) > 0 {
+		config.Collectors = slices.Clone(top.Collectors)
+	}
+	for sensorType, celsius := range top.Thresholds {
+		if config.Thresholds == nil {
+			config.Thresholds = make(map[string]float64)
+		}
+		config.Thresholds[sensorType] = celsius
+	}
+	for sensorType, celsius := range top.ReportThresholds {
+		if config.ReportThresholds == nil {
+			config.ReportThresholds = make(map[string]float64)
+		}
+		config.ReportThresholds[sensorType] = celsius
+	}
+	if top.HeartbeatSeconds > 0 {
+		config.HeartbeatSeconds = top.HeartbeatSeconds
+	}
+}
+
+// revision identifies a configuration, so an agent is only sent one when it
+// changes
+func revision(config *profilev1.AgentConfig) string {
+	if isEmpty(config) {
+		return ""
+	}
+	sorted := proto.Clone(config).(*profilev1.AgentConfig)
+	sort.Strings(sorted.Collectors)
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(sorted)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Push queues a client's configuration for its agent if it changed since the
+// last one queued, replacing any still waiting. Clients that are not approved
+// or whose agents do not accept remote configuration are skipped. It returns
+// whether a configuration was queued.
+func Push(db *gorm.DB, client *models.Client) (bool, error) {
+	profiles, err := load(db)
+	if err != nil {
+		return false, err
+	}
+	return push(db, profiles, client)
+}
+
+func push(db *gorm.DB, profiles []models.ConfigProfile, client *models.Client) (bool, error) {
+	if client.Status != models.ClientStatusApproved ||
+		!slices.Contains(commands.DecodeList(client.Capabilities), models.CapabilityRemoteConfig) {
+		return false, nil
+	}
+	resolved, err := resolve(profiles, client)
+	if err != nil {
+		return false, err
+	}
+	if resolved.Revision == client.ConfigRevision {
+		return false, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Command{}).
+			Where("client_id = ? AND type = ? AND status = ?", client.ClientID, models.CommandTypeApplyConfig, models.CommandStatusPending).
+			Update("expires_at", time.Now()).Error
+		if err != nil {
+			return fmt.Errorf("failed to expire superseded configurations: %w", err)
+		}
+		command := &commandv1.Command{Action: &commandv1.Command_ApplyConfig{ApplyConfig: &commandv1.ApplyConfigCommand{
+			Config:   resolved.Config,
+			Revision: resolved.Revision,
+		}}}
+		if _, err := commands.Queue(tx, client.ClientID, models.CommandTypeApplyConfig, command, ConfigTTL, models.CommandSourceConfig); err != nil {
+			return err
+		}
+		return tx.Model(client).UpdateColumn("config_revision", resolved.Revision).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to push configuration to client %s: %w", client.ClientID, err)
+	}
+	return true, nil
+}
+
+// PushAll pushes the configuration of every client, as after a profile
+// changes, and returns how many were queued. A client that fails is logged
+// and skipped.
+func PushAll(db *gorm.DB) (int, error) {
+	profiles, err := load(db)
+	if err != nil {
+		return 0, err
+	}
+	var clients []models.Client
+	if err := db.Where("status = ?", models.ClientStatusApproved).Find(&clients).Error; err != nil {
+		return 0, fmt.Errorf("failed to load clients: %w", err)
+	}
+
+	pushed := 0
+	for i := range clients {
+		queued, err := push(db, profiles, &clients[i])
+		if err != nil {
+			log.Printf("Skipping client %s: %v", clients[i].ClientID, err)
+			continue
+		}
+		if queued {
+			pushed++
+		}
+	}
+	return pushed, nil
+}
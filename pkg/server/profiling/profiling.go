@@ -0,0 +1,133 @@
+// Package profiling serves runtime debugging endpoints, for profiling a
+// running server without rebuilding it:
+//
+//	/debug/pprof/        net/http/pprof profiles, for go tool pprof
+//	/debug/vars          expvar, including runtime.MemStats
+//	POST /debug/dump     write goroutine and heap dumps to the dump directory
+//
+// The endpoints expose internals, so the server wraps them in the auth token
+// check and serves them only when enabled.
+package profiling
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strings"
+	"time"
+)
+
+// PathPrefix is where the endpoints are served
+const PathPrefix = "/debug/"
+
+// Dump kinds
+const (
+	DumpGoroutine = "goroutine" // Stacks of every goroutine, as text
+	DumpHeap      = "heap"      // Heap profile after a garbage collection, for go tool pprof
+)
+
+// Handler serves the debugging endpoints
+type Handler struct {
+	dumpDir string
+	mux     *http.ServeMux
+}
+
+// NewHandler creates a handler that writes dumps to dumpDir
+func NewHandler(dumpDir string) *Handler {
+	h := &Handler{dumpDir: dumpDir, mux: http.NewServeMux()}
+	h.mux.HandleFunc(PathPrefix+"pprof/", pprof.Index)
+	h.mux.HandleFunc(PathPrefix+"pprof/cmdline", pprof.Cmdline)
+	h.mux.HandleFunc(PathPrefix+"pprof/profile", pprof.Profile)
+	h.mux.HandleFunc(PathPrefix+"pprof/symbol", pprof.Symbol)
+	h.mux.HandleFunc(PathPrefix+"pprof/trace", pprof.Trace)
+	h.mux.Handle(PathPrefix+"vars", expvar.Handler())
+	h.mux.HandleFunc(PathPrefix+"dump", h.dump)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// CPU profiles and traces run for as long as the caller asks, past the
+	// server's write timeout
+	if strings.HasPrefix(r.URL.Path, PathPrefix+"pprof/") {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("Failed to lift write deadline for %s: %v", r.URL.Path, err)
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// dump writes the dumps named by ?kind= (goroutine or heap, both if unset)
+// and responds with their paths
+func (h *Handler) dump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	kinds := r.URL.Query()["kind"]
+	if len(kinds) == 0 {
+		kinds = []string{DumpGoroutine, DumpHeap}
+	}
+	for _, kind := range kinds {
+		if kind != DumpGoroutine && kind != DumpHeap {
+			http.Error(w, fmt.Sprintf("unknown dump kind %q; use goroutine or heap", kind), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := os.MkdirAll(h.dumpDir, 0o700); err != nil {
+		log.Printf("Failed to create dump directory: %v", err)
+		http.Error(w, "failed to create dump directory", http.StatusInternalServerError)
+		return
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000Z")
+	var paths []string
+	for _, kind := range kinds {
+		path, err := h.write(kind, stamp)
+		if err != nil {
+			log.Printf("Failed to write %s dump: %v", kind, err)
+			http.Error(w, fmt.Sprintf("failed to write %s dump", kind), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Wrote %s dump to %s", kind, path)
+		paths = append(paths, path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"paths": paths})
+}
+
+func (h *Handler) write(kind, stamp string) (string, error) {
+	ext := ".pprof"
+	if kind == DumpGoroutine {
+		ext = ".txt"
+	}
+	path := filepath.Join(h.dumpDir, kind+"-"+stamp+ext)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	switch kind {
+	case DumpGoroutine:
+		err = rpprof.Lookup("goroutine").WriteTo(f, 2)
+	case DumpHeap:
+		runtime.GC()
+		err = rpprof.WriteHeapProfile(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,210 @@
+// Package quality scores the data of each sensor, so probes that are failing
+// can be replaced before they matter. A sensor's score starts at 100 and is
+// lowered by gaps in its reporting, by readings quarantined at ingest, and
+// by readings that never change or jump around between samples. Sensor
+// quality alert rules fire on low scores.
+package quality
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// gapFactor is how many typical intervals a silence must last to count
+	// as a gap
+	gapFactor = 3
+	// minFlatlineReadings is how many identical readings make a flatline;
+	// fewer may just be a steady temperature
+	minFlatlineReadings = 30
+	// variancePenalty scales the score of flatlined or noisy sensors
+	variancePenalty = 0.5
+)
+
+// Config controls how sensors are scored
+type Config struct {
+	Window time.Duration // Readings scored, back from each run
+	// Mean change between successive readings, in °C, above which a sensor
+	// is noisy; 0 disables the check
+	NoiseLimit float64
+}
+
+// Scorer scores the sensors that are not retired
+type Scorer struct {
+	db  *gorm.DB
+	cfg Config
+}
+
+func NewScorer(db *gorm.DB, cfg Config) *Scorer {
+	return &Scorer{db: db, cfg: cfg}
+}
+
+// RunOnce scores every sensor over the window before now
+func (s *Scorer) RunOnce(ctx context.Context, now time.Time) error {
+	db := s.db.WithContext(ctx)
+	start := now.Add(-s.cfg.Window)
+
+	var sensors []models.Sensor
+	if err := db.Where("retired_at IS NULL").Find(&sensors).Error; err != nil {
+		return fmt.Errorf("failed to load sensors: %w", err)
+	}
+
+	type sensorKey struct{ clientID, sensorID string }
+	var rows []struct {
+		ClientID string
+		SensorID string
+		Count    int64
+	}
+	err := db.Model(&models.QuarantinedReading{}).
+		Select("client_id, sensor_id, COUNT(*) as count").
+		Where("reading_time >= ? AND reading_time < ?", start, now).
+		Group("client_id, sensor_id").
+		Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to count quarantined readings: %w", err)
+	}
+	quarantined := make(map[sensorKey]int64, len(rows))
+	for _, row := range rows {
+		quarantined[sensorKey{row.ClientID, row.SensorID}] = row.Count
+	}
+
+	for i := range sensors {
+		sensor := &sensors[i]
+		samples, err := s.samples(db, sensor, start, now)
+		if err != nil {
+			return err
+		}
+		// A sensor added during the window is judged from when it appeared,
+		// or from its first reading when that was backfilled
+		from := start
+		if sensor.CreatedAt.After(from) {
+			from = sensor.CreatedAt
+		}
+		if len(samples) > 0 && samples[0].Time.Before(from) {
+			from = samples[0].Time
+		}
+		q := Score(samples, quarantined[sensorKey{sensor.ClientID, sensor.SensorID}], from, now, s.cfg.NoiseLimit)
+		q.CheckedAt = &now
+
+		// Updated columns only, leaving updated_at to retirement
+		err = db.Model(&models.Sensor{}).Where("id = ?", sensor.ID).UpdateColumns(map[string]interface{}{
+			"quality_score":       q.Score,
+			"quality_readings":    q.Readings,
+			"quality_coverage":    q.Coverage,
+			"quality_quarantined": q.Quarantined,
+			"quality_flatline":    q.Flatline,
+			"quality_noise":       q.Noise,
+			"quality_noisy":       q.Noisy,
+			"quality_checked_at":  q.CheckedAt,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to save quality of sensor %s on client %s: %w", sensor.SensorID, sensor.ClientID, err)
+		}
+	}
+	return nil
+}
+
+// samples returns a sensor's regular readings from start until end, raw and
+// compressed, oldest first
+func (s *Scorer) samples(db *gorm.DB, sensor *models.Sensor, start, end time.Time) ([]Sample, error) {
+	var raw []models.TemperatureReading
+	err := db.Select("temperature_celsius", "created_at").
+		Where("client_id = ? AND sensor_id = ? AND burst = ?", sensor.ClientID, sensor.SensorID, false).
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Find(&raw).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load readings of sensor %s on client %s: %w", sensor.SensorID, sensor.ClientID, err)
+	}
+	compressed, err := chunks.Readings(db, chunks.Filter{
+		ClientID:  sensor.ClientID,
+		SensorIDs: []string{sensor.SensorID},
+		Start:     start,
+		End:       end.Add(-time.Millisecond),
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]Sample, 0, len(raw)+len(compressed))
+	for _, r := range append(raw, compressed...) {
+		samples = append(samples, Sample{Time: r.CreatedAt, Celsius: r.TemperatureCelsius})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// Sample is one reading of a sensor
+type Sample struct {
+	Time    time.Time
+	Celsius float64
+}
+
+// Score rates the samples a sensor reported from start until end, oldest
+// first, and the number of its readings quarantined in that time. Gaps are
+// silences longer than a few of the sensor's typical intervals, including
+// those before its first and after its last sample. A noiseLimit of 0
+// skips the noise check. The score is nil when nothing was reported.
+func Score(samples []Sample, quarantined int64, start, end time.Time, noiseLimit float64) models.SensorQuality {
+	q := models.SensorQuality{
+		Readings:    int64(len(samples)),
+		Coverage:    1,
+		Quarantined: quarantined,
+	}
+	if len(samples) == 0 && quarantined == 0 {
+		q.Coverage = 0
+		return q
+	}
+	if len(samples) == 0 {
+		zero := 0.0
+		q.Coverage = 0
+		q.Score = &zero
+		return q
+	}
+
+	if len(samples) > 1 {
+		intervals := make([]time.Duration, len(samples)-1)
+		var change float64
+		for i := 1; i < len(samples); i++ {
+			intervals[i-1] = samples[i].Time.Sub(samples[i-1].Time)
+			change += math.Abs(samples[i].Celsius - samples[i-1].Celsius)
+		}
+		q.Noise = change / float64(len(intervals))
+
+		sorted := append([]time.Duration(nil), intervals...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		typical := sorted[len(sorted)/2]
+
+		var lost time.Duration
+		for _, interval := range append(intervals, samples[0].Time.Sub(start), end.Sub(samples[len(samples)-1].Time)) {
+			if interval > gapFactor*typical {
+				lost += interval - typical
+			}
+		}
+		if span := end.Sub(start); span > 0 {
+			q.Coverage = math.Max(0, 1-float64(lost)/float64(span))
+		}
+	}
+
+	lowest, highest := samples[0].Celsius, samples[0].Celsius
+	for _, sample := range samples[1:] {
+		lowest = math.Min(lowest, sample.Celsius)
+		highest = math.Max(highest, sample.Celsius)
+	}
+	q.Flatline = len(samples) >= minFlatlineReadings && lowest == highest
+
+	score := 100 * q.Coverage * float64(len(samples)) / float64(int64(len(samples))+quarantined)
+	q.Noisy = noiseLimit > 0 && q.Noise > noiseLimit
+	if q.Flatline || q.Noisy {
+		score *= variancePenalty
+	}
+	score = math.Round(score*10) / 10
+	q.Score = &score
+	return q
+}
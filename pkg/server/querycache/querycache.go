@@ -0,0 +1,173 @@
+// Package querycache caches the results of expensive read endpoints, such as
+// stats and chart series, for a short time. Entries are dropped when readings
+// are stored for the clients they cover, on any replica.
+package querycache
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	lookups = metrics.NewCounterVec(
+		"jacuzzi_query_cache_lookups_total",
+		"Query cache lookups, by endpoint and result (hit or miss).",
+		"endpoint", "result",
+	)
+	evictions = metrics.NewCounterVec(
+		"jacuzzi_query_cache_evictions_total",
+		"Query cache entries dropped before expiring, by reason (size or ingest).",
+		"reason",
+	)
+)
+
+// Cache is a size-bounded LRU cache whose entries expire after a TTL. A nil
+// Cache caches nothing.
+type Cache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key      string
+	clientID string // Empty for results covering every client
+	value    any
+	expires  time.Time
+}
+
+// New creates a cache of up to size entries kept for ttl, or returns nil if
+// either is not positive
+func New(size int, ttl time.Duration) *Cache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached result of an endpoint for a key
+func (c *Cache) Get(endpoint, key string) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[endpoint+"\x00"+key]
+	if ok && time.Now().After(el.Value.(*entry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		lookups.Inc(endpoint, "miss")
+		return nil, false
+	}
+	lookups.Inc(endpoint, "hit")
+	c.order.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Set caches the result of an endpoint for a key. clientID is the client the
+// result covers, or empty if it may cover any; cached values must not be
+// modified afterwards.
+func (c *Cache) Set(endpoint, key, clientID string, value any) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key = endpoint + "\x00" + key
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&entry{
+		key:      key,
+		clientID: clientID,
+		value:    value,
+		expires:  time.Now().Add(c.ttl),
+	})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		evictions.Inc("size")
+	}
+}
+
+// Invalidate drops the results covering any of the clients
+func (c *Cache) Invalidate(clientIDs ...string) {
+	if c == nil || len(clientIDs) == 0 {
+		return
+	}
+	clients := make(map[string]bool, len(clientIDs)+1)
+	for _, id := range clientIDs {
+		clients[id] = true
+	}
+	clients[""] = true
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if clients[el.Value.(*entry).clientID] {
+			c.remove(el)
+			evictions.Inc("ingest")
+		}
+		el = next
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// Run invalidates results as readings published on the hub are stored, until
+// ctx is done
+func (c *Cache) Run(ctx context.Context, hub *events.Hub) {
+	if c == nil {
+		return
+	}
+	sub := hub.Subscribe(events.TopicReadings, 256)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			var batch temperaturev1.TemperatureReadingBatch
+			if err := proto.Unmarshal(data, &batch); err != nil {
+				log.Printf("Failed to decode published readings: %v", err)
+				continue
+			}
+			seen := make(map[string]bool)
+			var clientIDs []string
+			for _, reading := range batch.Readings {
+				if !seen[reading.ClientId] {
+					seen[reading.ClientId] = true
+					clientIDs = append(clientIDs, reading.ClientId)
+				}
+			}
+			c.Invalidate(clientIDs...)
+		}
+	}
+}
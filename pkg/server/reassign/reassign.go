@@ -0,0 +1,222 @@
+// Package reassign moves everything recorded for one client ID to another,
+// for machines that came back under a new ID after a hostname change, or are
+// about to.
+package reassign
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/rollup"
+	"gorm.io/gorm"
+)
+
+// ErrSameClient is returned when a client would be merged into itself
+var ErrSameClient = errors.New("clients must differ")
+
+// Result counts what a merge moved
+type Result struct {
+	Readings   int64 // Raw readings moved
+	Duplicates int64 // Raw readings dropped because the target had one at the same time
+	Chunks     int64 // Compressed hours moved or merged
+	Rollups    int64 // Rollup buckets moved or combined
+	Sensors    int64 // Sensors moved or merged
+	Alerts     int64 // Alerts moved
+}
+
+// ErrClientExists is returned when a client would be renamed to an ID that
+// is taken
+var ErrClientExists = errors.New("client ID already exists")
+
+// records are the tables referring to a client by ID that have no unique key
+// on it, so a client's rows move without conflict
+var records = []interface{}{
+	&models.Alert{},
+	&models.AlertRule{},
+	&models.Command{},
+	&models.Event{},
+	&models.Annotation{},
+	&models.QuarantinedReading{},
+}
+
+// keyed is a table with a unique key per client; rows of the source whose
+// key the target already has are dropped before the rest are moved
+type keyed struct {
+	model interface{}
+	table string
+	keys  []string
+}
+
+// Merge moves the sensors, readings, alerts and other records of one client
+// to another and deletes the first, in tx. Where both clients have a record
+// with the same key, such as a reading of a sensor at the same time, the
+// target's is kept.
+func Merge(tx *gorm.DB, from, into *models.Client) (Result, error) {
+	var result Result
+	if from.ClientID == into.ClientID {
+		return result, ErrSameClient
+	}
+
+	dropped, moved, err := move(tx, keyed{&models.TemperatureReading{}, "temperature_readings", []string{"sensor_id", "created_at"}}, from.ClientID, into.ClientID)
+	if err != nil {
+		return result, err
+	}
+	result.Duplicates, result.Readings = dropped, moved
+
+	if result.Chunks, err = chunks.Reassign(tx, from.ClientID, into.ClientID); err != nil {
+		return result, err
+	}
+	if result.Rollups, err = rollup.Reassign(tx, from.ClientID, into.ClientID); err != nil {
+		return result, err
+	}
+	if result.Sensors, err = mergeSensors(tx, from.ClientID, into.ClientID); err != nil {
+		return result, err
+	}
+
+	for _, table := range []keyed{
+		{&models.ClientAddress{}, "client_addresses", []string{"ip_address"}},
+		{&models.PendingAlert{}, "pending_alerts", []string{"rule_id", "sensor_id"}},
+		{&models.DutyCycle{}, "duty_cycles", []string{"sensor_id", "threshold", "day"}},
+		{&models.ClientPower{}, "client_power", nil},
+	} {
+		if _, _, err := move(tx, table, from.ClientID, into.ClientID); err != nil {
+			return result, err
+		}
+	}
+
+	for _, model := range records {
+		// Deleted rules move too, as they are kept for the alerts they raised
+		update := tx.Unscoped().Model(model).Where("client_id = ?", from.ClientID).Update("client_id", into.ClientID)
+		if update.Error != nil {
+			return result, fmt.Errorf("failed to move records of client %s: %w", from.ClientID, update.Error)
+		}
+		if _, ok := model.(*models.Alert); ok {
+			result.Alerts = update.RowsAffected
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if from.FirstSeen.Before(into.FirstSeen) {
+		updates["first_seen"] = from.FirstSeen
+	}
+	if from.LastSeen.After(into.LastSeen) {
+		updates["last_seen"] = from.LastSeen
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(into).Updates(updates).Error; err != nil {
+			return result, fmt.Errorf("failed to update client %s: %w", into.ClientID, err)
+		}
+	}
+	if err := tx.Delete(from).Error; err != nil {
+		return result, fmt.Errorf("failed to delete client %s: %w", from.ClientID, err)
+	}
+	return result, nil
+}
+
+// Rename changes the ID of a client and of everything recorded for it, in tx.
+// Readings keep their ingest times, so nothing is rolled up again.
+func Rename(tx *gorm.DB, client *models.Client, to string) error {
+	from := client.ClientID
+	if from == to {
+		return ErrSameClient
+	}
+	var taken int64
+	if err := tx.Model(&models.Client{}).Where("client_id = ?", to).Count(&taken).Error; err != nil {
+		return fmt.Errorf("failed to check client %s: %w", to, err)
+	}
+	if taken > 0 {
+		return ErrClientExists
+	}
+
+	// The client goes first, as the tables with foreign keys on it follow it
+	// by cascade; they are updated below anyway in case the database did not
+	if err := tx.Model(client).Update("client_id", to).Error; err != nil {
+		return fmt.Errorf("failed to rename client %s: %w", from, err)
+	}
+	tables := append([]interface{}{
+		&models.ClientAddress{},
+		&models.Sensor{},
+		&models.TemperatureReading{},
+		&models.ReadingChunk{},
+		&models.TemperatureRollup{},
+		&models.PendingAlert{},
+		&models.ClientPower{},
+		&models.DutyCycle{},
+	}, records...)
+	for _, model := range tables {
+		err := tx.Unscoped().Model(model).Where("client_id = ?", from).UpdateColumn("client_id", to).Error
+		if err != nil {
+			return fmt.Errorf("failed to rename records of client %s: %w", from, err)
+		}
+	}
+	return nil
+}
+
+// move drops the rows of a table for from whose key into already has, then
+// moves the rest to into. A table without keys holds one row per client.
+func move(tx *gorm.DB, table keyed, from, into string) (dropped, moved int64, err error) {
+	match := ""
+	for _, key := range table.keys {
+		match += fmt.Sprintf(" AND t.%s = %s.%s", key, table.table, key)
+	}
+	drop := tx.Where(fmt.Sprintf("client_id = ? AND EXISTS (SELECT 1 FROM %s t WHERE t.client_id = ?%s)", table.table, match), from, into).
+		Delete(table.model)
+	if drop.Error != nil {
+		return 0, 0, fmt.Errorf("failed to drop duplicate %s of client %s: %w", table.table, from, drop.Error)
+	}
+
+	// Models with updated_at get it set, so readings are rolled up again
+	// under the new client
+	update := tx.Model(table.model).Where("client_id = ?", from).Update("client_id", into)
+	if update.Error != nil {
+		return 0, 0, fmt.Errorf("failed to move %s of client %s: %w", table.table, from, update.Error)
+	}
+	return drop.RowsAffected, update.RowsAffected, nil
+}
+
+// mergeSensors moves the sensors of from to into. A sensor into already has
+// keeps its row, taking the later reading time and staying in service if
+// either was.
+func mergeSensors(tx *gorm.DB, from, into string) (int64, error) {
+	var sources []models.Sensor
+	if err := tx.Where("client_id = ?", from).Find(&sources).Error; err != nil {
+		return 0, fmt.Errorf("failed to load sensors of client %s: %w", from, err)
+	}
+
+	for i := range sources {
+		source := &sources[i]
+		var target models.Sensor
+		err := tx.Where("client_id = ? AND sensor_id = ?", into, source.SensorID).Limit(1).Find(&target).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to load sensor %s: %w", source.SensorID, err)
+		}
+		if target.ID == 0 {
+			continue
+		}
+
+		updates := map[string]interface{}{}
+		if source.LastReadingAt != nil && (target.LastReadingAt == nil || source.LastReadingAt.After(*target.LastReadingAt)) {
+			updates["last_reading_at"] = source.LastReadingAt
+			updates["stale_since"] = source.StaleSince
+		}
+		if source.RetiredAt == nil && target.RetiredAt != nil {
+			updates["retired_at"] = nil
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&target).Updates(updates).Error; err != nil {
+				return 0, fmt.Errorf("failed to update sensor %s: %w", target.SensorID, err)
+			}
+		}
+		if err := tx.Delete(source).Error; err != nil {
+			return 0, fmt.Errorf("failed to delete merged sensor %s: %w", source.SensorID, err)
+		}
+	}
+
+	err := tx.Model(&models.Sensor{}).Where("client_id = ?", from).Update("client_id", into).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to move sensors of client %s: %w", from, err)
+	}
+	return int64(len(sources)), nil
+}
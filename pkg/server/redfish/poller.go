@@ -0,0 +1,219 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// SubmitFunc stores readings through the normal ingest path
+type SubmitFunc func(ctx context.Context, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error)
+
+// contextTypes maps the physical contexts of temperature sensors to sensor
+// types; others are OTHER
+var contextTypes = map[string]string{
+	"CPU":              "CPU",
+	"CPUSubsystem":     "CPU",
+	"GPU":              "GPU",
+	"GPUSubsystem":     "GPU",
+	"Accelerator":      "GPU",
+	"Memory":           "MEMORY",
+	"MemorySubsystem":  "MEMORY",
+	"StorageDevice":    "DISK",
+	"NetworkingDevice": "NETWORK",
+	"Intake":           "AMBIENT",
+	"Room":             "AMBIENT",
+	"SystemBoard":      "MOTHERBOARD",
+	"VoltageRegulator": "MOTHERBOARD",
+	"Battery":          "BATTERY",
+}
+
+// MetadataFanPrefix is followed by a fan's name in the metadata of a BMC's
+// client, e.g. redfish.fan.Fan 1: "5400 RPM"
+const MetadataFanPrefix = "redfish.fan."
+
+// Poller reads the thermal data of BMCs and records each BMC's temperatures
+// as readings of a client representing it, and its fan speeds in the
+// client's metadata, so alert rules, dashboards and offline detection cover
+// machines without an agent
+type Poller struct {
+	db        *gorm.DB
+	endpoints []*Endpoint
+	submit    SubmitFunc
+}
+
+func NewPoller(db *gorm.DB, endpoints []*Endpoint, submit SubmitFunc) *Poller {
+	return &Poller{db: db, endpoints: endpoints, submit: submit}
+}
+
+// RunOnce polls every BMC at once and records what those that answered
+// reported
+func (p *Poller) RunOnce(ctx context.Context) error {
+	results := make([]*Thermal, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// One unreachable BMC should not stop the others
+			thermal, err := endpoint.Thermal(ctx)
+			if err != nil {
+				log.Printf("Redfish poll: %s: %v", endpoint.Name(), err)
+				return
+			}
+			results[i] = thermal
+		}()
+	}
+	wg.Wait()
+
+	polled := 0
+	for i, thermal := range results {
+		if thermal == nil {
+			continue
+		}
+		endpoint := p.endpoints[i]
+		client, err := p.ensureClient(ctx, endpoint, thermal)
+		if err != nil {
+			return fmt.Errorf("failed to register client %s: %w", endpoint.ClientID(), err)
+		}
+		if err := p.updateFans(ctx, client, thermal.Fans); err != nil {
+			return err
+		}
+		if readings := p.readings(endpoint, thermal); len(readings) > 0 {
+			if _, err := p.submit(ctx, &temperaturev1.SubmitTemperatureRequest{Readings: readings}); err != nil {
+				return fmt.Errorf("failed to record readings of %s: %w", endpoint.Name(), err)
+			}
+		}
+		polled++
+	}
+	log.Printf("Redfish poll: %d of %d BMCs answered", polled, len(p.endpoints))
+	return nil
+}
+
+// readings converts a BMC's temperatures to readings of its client
+func (p *Poller) readings(endpoint *Endpoint, thermal *Thermal) []*temperaturev1.TemperatureReading {
+	now := timestamppb.Now()
+	readings := make([]*temperaturev1.TemperatureReading, 0, len(thermal.Temperatures))
+	for _, t := range thermal.Temperatures {
+		sensorType, ok := contextTypes[t.PhysicalContext]
+		if !ok {
+			sensorType = "OTHER"
+		}
+		readings = append(readings, &temperaturev1.TemperatureReading{
+			SensorId:           t.ID,
+			ClientId:           endpoint.ClientID(),
+			TemperatureCelsius: t.Celsius,
+			Timestamp:          now,
+			SensorType:         sensorType,
+			SensorName:         t.Name,
+		})
+	}
+	return readings
+}
+
+// updateFans replaces the fan speeds in a client's metadata
+func (p *Poller) updateFans(ctx context.Context, client *models.Client, fans []Fan) error {
+	metadata := make(map[string]string)
+	if client.Metadata != "" {
+		json.Unmarshal([]byte(client.Metadata), &metadata)
+	}
+	updated := maps.Clone(metadata)
+	maps.DeleteFunc(updated, func(key, _ string) bool { return strings.HasPrefix(key, MetadataFanPrefix) })
+	for _, f := range fans {
+		name := f.Name
+		if name == "" {
+			name = f.ID
+		}
+		units := f.Units
+		if units == "Percent" {
+			units = "%"
+		}
+		updated[MetadataFanPrefix+name] = strconv.FormatFloat(f.Reading, 'f', -1, 64) + " " + units
+	}
+	if maps.Equal(metadata, updated) {
+		return nil
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	err = p.db.WithContext(ctx).Model(&models.Client{}).Where("id = ?", client.ID).UpdateColumn("metadata", string(data)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update metadata of client %s: %w", client.ClientID, err)
+	}
+	client.Metadata = string(data)
+	return nil
+}
+
+// ensureClient returns the BMC's client, registering it the first time the
+// BMC answers, approved whatever the enrollment mode
+func (p *Poller) ensureClient(ctx context.Context, endpoint *Endpoint, thermal *Thermal) (*models.Client, error) {
+	db := p.db.WithContext(ctx)
+	var client models.Client
+	err := db.Where("client_id = ?", endpoint.ClientID()).First(&client).Error
+	if err == nil {
+		return &client, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	metadata := map[string]string{"role": "bmc", "redfish.url": endpoint.cfg.URL}
+	if thermal.Manufacturer != "" {
+		metadata["redfish.manufacturer"] = thermal.Manufacturer
+	}
+	if thermal.Model != "" {
+		metadata["redfish.model"] = thermal.Model
+	}
+	data, _ := json.Marshal(metadata)
+	now := time.Now()
+	client = models.Client{
+		ClientID:  endpoint.ClientID(),
+		Hostname:  endpoint.Name(),
+		IPAddress: hostIP(endpoint.cfg.URL),
+		FirstSeen: now,
+		LastSeen:  now,
+		IsOnline:  true,
+		Metadata:  string(data),
+		Status:    models.ClientStatusApproved,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&client).Error; err != nil {
+			return err
+		}
+		activity.Client(tx, activity.ClientRegistered, &client, "Redfish polling of "+endpoint.cfg.URL+" started")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// hostIP returns the host of a URL when it is an IP address
+func hostIP(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
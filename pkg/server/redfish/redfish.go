@@ -0,0 +1,224 @@
+// Package redfish polls the thermal data of server BMCs through their Redfish
+// APIs, reporting each BMC's temperatures and fan speeds under a client
+// representing it.
+package redfish
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// EndpointConfig is how to reach a BMC's Redfish API
+type EndpointConfig struct {
+	Name string // Names the BMC's client, redfish-<name>
+	URL  string // e.g. https://bmc1.example.com
+	// Account the BMC's API is read with; read-only access is enough
+	Username string
+	Password string
+	// CA bundle the BMC's certificate is checked against; empty uses the
+	// system roots
+	CAFile string
+	// Skip certificate verification, for the self-signed certificates most
+	// BMCs ship with
+	InsecureSkipVerify bool
+}
+
+// Endpoint reads a BMC's Redfish API
+type Endpoint struct {
+	cfg  EndpointConfig
+	http *http.Client
+}
+
+// Temperature is a temperature sensor of a chassis
+type Temperature struct {
+	ID              string // Chassis ID and member ID, e.g. 1/0
+	Name            string
+	Celsius         float64
+	PhysicalContext string // Where the sensor is, such as CPU or Intake
+}
+
+// Fan is a fan of a chassis
+type Fan struct {
+	ID      string
+	Name    string
+	Reading float64
+	Units   string // RPM or Percent
+}
+
+// Thermal is what a BMC reports across its chassis
+type Thermal struct {
+	Manufacturer string
+	Model        string
+	Temperatures []Temperature
+	Fans         []Fan
+}
+
+func NewEndpoint(cfg EndpointConfig, timeout time.Duration) (*Endpoint, error) {
+	if cfg.Name == "" || cfg.URL == "" {
+		return nil, fmt.Errorf("redfish name and url are required")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &Endpoint{
+		cfg: cfg,
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+func (e *Endpoint) Name() string {
+	return e.cfg.Name
+}
+
+// ClientID is the ID of the client representing the BMC
+func (e *Endpoint) ClientID() string {
+	return "redfish-" + e.cfg.Name
+}
+
+// link is a reference to another resource
+type link struct {
+	ID string `json:"@odata.id"`
+}
+
+type status struct {
+	State string `json:"State"` // Enabled, Absent, Disabled...
+}
+
+// Thermal reads the Thermal resource of every chassis, leaving out sensors
+// that are absent, disabled or have no reading
+func (e *Endpoint) Thermal(ctx context.Context) (*Thermal, error) {
+	var collection struct {
+		Members []link `json:"Members"`
+	}
+	if err := e.get(ctx, "/redfish/v1/Chassis", &collection); err != nil {
+		return nil, err
+	}
+
+	thermal := &Thermal{}
+	for _, member := range collection.Members {
+		var chassis struct {
+			ID           string `json:"Id"`
+			Manufacturer string `json:"Manufacturer"`
+			Model        string `json:"Model"`
+			Thermal      *link  `json:"Thermal"`
+		}
+		if err := e.get(ctx, member.ID, &chassis); err != nil {
+			return nil, err
+		}
+		if thermal.Manufacturer == "" {
+			thermal.Manufacturer, thermal.Model = chassis.Manufacturer, chassis.Model
+		}
+		if chassis.Thermal == nil {
+			continue
+		}
+
+		var resource struct {
+			Temperatures []struct {
+				MemberID        string   `json:"MemberId"`
+				Name            string   `json:"Name"`
+				ReadingCelsius  *float64 `json:"ReadingCelsius"`
+				PhysicalContext string   `json:"PhysicalContext"`
+				Status          status   `json:"Status"`
+			} `json:"Temperatures"`
+			Fans []struct {
+				MemberID     string   `json:"MemberId"`
+				Name         string   `json:"Name"`
+				FanName      string   `json:"FanName"` // Before Redfish 2016.2
+				Reading      *float64 `json:"Reading"`
+				ReadingUnits string   `json:"ReadingUnits"`
+				Status       status   `json:"Status"`
+			} `json:"Fans"`
+		}
+		if err := e.get(ctx, chassis.Thermal.ID, &resource); err != nil {
+			return nil, err
+		}
+		for i, t := range resource.Temperatures {
+			if t.ReadingCelsius == nil || !present(t.Status) {
+				continue
+			}
+			thermal.Temperatures = append(thermal.Temperatures, Temperature{
+				ID:              sensorID(chassis.ID, "temp", t.MemberID, i),
+				Name:            t.Name,
+				Celsius:         *t.ReadingCelsius,
+				PhysicalContext: t.PhysicalContext,
+			})
+		}
+		for i, f := range resource.Fans {
+			if f.Reading == nil || !present(f.Status) {
+				continue
+			}
+			name := f.Name
+			if name == "" {
+				name = f.FanName
+			}
+			units := f.ReadingUnits
+			if units == "" {
+				units = "RPM"
+			}
+			thermal.Fans = append(thermal.Fans, Fan{
+				ID:      sensorID(chassis.ID, "fan", f.MemberID, i),
+				Name:    name,
+				Reading: *f.Reading,
+				Units:   units,
+			})
+		}
+	}
+	return thermal, nil
+}
+
+// present reports whether a sensor is installed and enabled; sensors that
+// report no state are taken as enabled
+func present(s status) bool {
+	return s.State == "" || s.State == "Enabled"
+}
+
+// sensorID identifies a sensor by its chassis and member ID, or its position
+// when the BMC gives no member ID
+func sensorID(chassis, kind, member string, index int) string {
+	if member == "" {
+		member = fmt.Sprint(index)
+	}
+	return fmt.Sprintf("%s/%s/%s", chassis, kind, member)
+}
+
+func (e *Endpoint) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.cfg.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("OData-Version", "4.0")
+	if e.cfg.Username != "" {
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,166 @@
+package report
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteCSV writes the report as a ZIP archive of summary.csv, daily.csv,
+// alerts.csv, and wear.csv. Temperatures are in degrees Celsius and timestamps in RFC 3339.
+func WriteCSV(w io.Writer, data *Data) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name  string
+		write func(*csv.Writer, *Data) error
+	}{
+		{"summary.csv", writeSummaryCSV},
+		{"daily.csv", writeDailyCSV},
+		{"alerts.csv", writeAlertsCSV},
+		{"wear.csv", writeWearCSV},
+	}
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: data.Generated})
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		if err := file.write(cw, data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func writeSummaryCSV(w *csv.Writer, data *Data) error {
+	header := []string{"client_id", "hostname", "sensor_id", "sensor_name", "sensor_type",
+		"readings", "min_c", "avg_c", "max_c", "peak_at", "pct_above_warning", "pct_above_critical"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, client := range data.Clients {
+		for _, sensor := range client.Sensors {
+			peakAt := ""
+			if sensor.Count > 0 {
+				peakAt = sensor.PeakAt.In(data.Location).Format(time.RFC3339)
+			}
+			record := []string{
+				client.ClientID, client.Hostname, sensor.SensorID, sensor.Name, sensor.Type,
+				strconv.FormatInt(sensor.Count, 10),
+				formatStat(sensor.Stats, sensor.Min),
+				formatStat(sensor.Stats, sensor.Avg()),
+				formatStat(sensor.Stats, sensor.Max),
+				peakAt,
+				percent(sensor.AboveWarning, sensor.Count, data.WarningThreshold != nil),
+				percent(sensor.AboveCritical, sensor.Count, data.CriticalThreshold != nil),
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeDailyCSV(w *csv.Writer, data *Data) error {
+	if err := w.Write([]string{"day", "client_id", "sensor_id", "readings", "min_c", "avg_c", "max_c"}); err != nil {
+		return err
+	}
+	for _, day := range data.Daily {
+		record := []string{
+			day.Day, day.ClientID, day.SensorID,
+			strconv.FormatInt(day.Count, 10),
+			formatStat(day.Stats, day.Min),
+			formatStat(day.Stats, day.Avg()),
+			formatStat(day.Stats, day.Max),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAlertsCSV(w *csv.Writer, data *Data) error {
+	header := []string{"alert_id", "rule_id", "client_id", "sensor_id", "severity", "value_c",
+		"message", "triggered_at", "resolved_at", "acknowledged_at", "acknowledged_by"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.In(data.Location).Format(time.RFC3339)
+	}
+	for _, alert := range data.Alerts {
+		record := []string{
+			alert.AlertID, alert.RuleID, alert.ClientID, alert.SensorID, alert.Severity,
+			strconv.FormatFloat(alert.Value, 'f', 2, 64),
+			alert.Message,
+			formatTime(&alert.TriggeredAt),
+			formatTime(alert.ResolvedAt),
+			formatTime(alert.AcknowledgedAt),
+			alert.AcknowledgedBy,
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeWearCSV(w *csv.Writer, data *Data) error {
+	header := []string{"client_id", "hostname", "client_first_seen", "sensor_id", "sensor_name", "sensor_type",
+		"threshold_c", "days", "hours_above", "hours_tracked", "pct_above", "days_in_service", "est_hours_above"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	hours := func(seconds float64) string {
+		return strconv.FormatFloat(seconds/3600, 'f', 2, 64)
+	}
+	for _, wear := range data.Wear {
+		firstSeen := ""
+		if !wear.ClientFirstSeen.IsZero() {
+			firstSeen = wear.ClientFirstSeen.In(data.Location).Format(time.RFC3339)
+		}
+		record := []string{
+			wear.ClientID, wear.Hostname, firstSeen, wear.SensorID, wear.Name, wear.Type,
+			strconv.FormatFloat(wear.Threshold, 'f', 2, 64),
+			strconv.FormatInt(wear.Days, 10),
+			hours(wear.Above),
+			hours(wear.Tracked),
+			strconv.FormatFloat(wear.PercentAbove(), 'f', 2, 64),
+			strconv.FormatFloat(wear.InService/(24*60*60), 'f', 2, 64),
+			hours(wear.EstimatedAbove()),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatStat formats a temperature, or nothing when there were no readings
+func formatStat(s Stats, v float64) string {
+	if s.Count == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// percent formats n as a percentage of total, or nothing when the threshold
+// it counts against is not configured
+func percent(n, total int64, configured bool) string {
+	if !configured || total == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(n)*100/float64(total), 'f', 2, 64)
+}
@@ -0,0 +1,140 @@
+package report
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PathPrefix is where reports are downloaded, as
+// GET /reports/<id>?expires=<unix>&signature=<hex>
+const PathPrefix = "/reports/"
+
+// signingKeySetting stores the generated key so every replica, and every
+// restart, accepts the same links
+const signingKeySetting = "reports.signing_key"
+
+// Signer creates and checks download links
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// LoadSigningKey returns the configured key, or the key stored in the
+// database, generating and storing one on first use
+func LoadSigningKey(db *gorm.DB, configured string) ([]byte, error) {
+	if configured != "" {
+		return []byte(configured), nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Setting{
+		Key:         signingKeySetting,
+		Value:       hex.EncodeToString(key),
+		ValueType:   "string",
+		Category:    "reports",
+		Description: "Key that signs report download links",
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to store report signing key: %w", err)
+	}
+
+	// Another replica may have stored its key first
+	var setting models.Setting
+	if err := db.Where("key = ?", signingKeySetting).First(&setting).Error; err != nil {
+		return nil, fmt.Errorf("failed to load report signing key: %w", err)
+	}
+	return hex.DecodeString(setting.Value)
+}
+
+func (s *Signer) signature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// URL returns the signed download path for a report
+func (s *Signer) URL(id string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("signature", s.signature(id, expires.Unix()))
+	return PathPrefix + url.PathEscape(id) + "?" + q.Encode()
+}
+
+// Verify checks a download link's signature and expiry
+func (s *Signer) Verify(id, expires, signature string, now time.Time) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(s.signature(id, unix)))
+}
+
+// Handler serves stored reports to holders of a valid signed link
+type Handler struct {
+	db     *gorm.DB
+	signer *Signer
+}
+
+func NewHandler(db *gorm.DB, signer *Signer) *Handler {
+	return &Handler{db: db, signer: signer}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, PathPrefix)
+	q := r.URL.Query()
+	now := time.Now()
+	if !h.signer.Verify(id, q.Get("expires"), q.Get("signature"), now) {
+		http.Error(w, "invalid or expired link", http.StatusForbidden)
+		return
+	}
+
+	var reports []models.Report
+	err := h.db.WithContext(r.Context()).
+		Where("id = ? AND expires_at > ?", id, now).
+		Limit(1).
+		Find(&reports).Error
+	if err != nil {
+		log.Printf("Failed to load report %s: %v", id, err)
+		http.Error(w, "failed to load report", http.StatusInternalServerError)
+		return
+	}
+	if len(reports) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	report := reports[0]
+
+	w.Header().Set("Content-Type", report.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": report.Filename}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(report.Data)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	if r.Method == http.MethodGet {
+		w.Write(report.Data)
+	}
+}
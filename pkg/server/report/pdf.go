@@ -0,0 +1,302 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+)
+
+// Page layout: A4 landscape in points, set in Courier so tables align
+const (
+	pageWidth    = 842
+	pageHeight   = 595
+	pageMargin   = 36
+	fontSize     = 8
+	lineHeight   = 10
+	linesPerPage = (pageHeight - 2*pageMargin) / lineHeight
+	lineChars    = 160 // Courier is 0.6 em wide: (842 - 72) / 4.8
+)
+
+// maxPDFAlerts bounds the alerts listed individually; the CSV has them all
+const maxPDFAlerts = 200
+
+// maxPDFWear bounds the components listed in the thermal wear section
+const maxPDFWear = 50
+
+// pdfLine is a line of text, optionally bold
+type pdfLine struct {
+	text string
+	bold bool
+}
+
+// WritePDF writes the report as a text-only PDF document in the report's
+// locale: a summary per sensor, the alerts raised during the period and the
+// components with the most time above their tracked thresholds. Daily figures
+// are only in the CSV format.
+func WritePDF(w io.Writer, data *Data) error {
+	l := data.Locale
+	var lines []pdfLine
+	add := func(bold bool, format string, args ...interface{}) {
+		lines = append(lines, pdfLine{text: fmt.Sprintf(format, args...), bold: bold})
+	}
+	blank := func() { lines = append(lines, pdfLine{}) }
+
+	layout := "2006-01-02 15:04 MST"
+	add(true, "%s", data.Title)
+	field := "%-12s%s"
+	add(false, field, l.T("Period:"), l.T("%s to %s", data.Start.In(data.Location).Format(layout), data.End.In(data.Location).Format(layout)))
+	add(false, field, l.T("Generated:"), data.Generated.In(data.Location).Format(layout))
+	add(false, field, l.T("Clients:"), fmt.Sprint(len(data.Clients)))
+	thresholds := l.T("not configured")
+	if data.WarningThreshold != nil || data.CriticalThreshold != nil {
+		thresholds = l.T("warning %s, critical %s", formatThreshold(data.WarningThreshold), formatThreshold(data.CriticalThreshold))
+	}
+	add(false, field, l.T("Thresholds:"), thresholds)
+	blank()
+
+	add(true, "%s", l.T("Sensor summary (temperatures in degrees C)"))
+	row := "%-24.24s %-28.28s %9s %7s %7s %7s  %-17s %8s %8s"
+	add(true, row, l.T("Client"), l.T("Sensor"), l.T("Readings"), l.T("Min"), l.T("Avg"), l.T("Max"), l.T("Peak at"), l.T(">Warn %"), l.T(">Crit %"))
+	for _, client := range data.Clients {
+		name := client.ClientID
+		if client.Hostname != "" {
+			name = client.Hostname
+		}
+		if len(client.Sensors) == 0 {
+			add(false, row, name, l.T("(no sensors)"), "", "", "", "", "", "", "")
+		}
+		for _, sensor := range client.Sensors {
+			label := sensor.SensorID
+			if sensor.Name != "" {
+				label = sensor.Name
+			}
+			peakAt := ""
+			if sensor.Count > 0 {
+				peakAt = sensor.PeakAt.In(data.Location).Format("2006-01-02 15:04")
+			}
+			add(false, row, name, label, fmt.Sprint(sensor.Count),
+				formatStat(sensor.Stats, sensor.Min),
+				formatStat(sensor.Stats, sensor.Avg()),
+				formatStat(sensor.Stats, sensor.Max),
+				peakAt,
+				percent(sensor.AboveWarning, sensor.Count, data.WarningThreshold != nil),
+				percent(sensor.AboveCritical, sensor.Count, data.CriticalThreshold != nil))
+			name = "" // Only name the client on its first row
+		}
+	}
+	blank()
+
+	add(true, "%s", l.T("Alerts by client"))
+	severities := alertSeverities(data)
+	if len(severities) == 0 {
+		add(false, "%s", l.T("No alerts were raised during the period."))
+	} else {
+		header := fmt.Sprintf("%-40s", l.T("Client"))
+		for _, severity := range severities {
+			header += fmt.Sprintf(" %10s", l.Severity(severity))
+		}
+		add(true, "%s", header)
+		for _, client := range data.Clients {
+			if len(client.Alerts) == 0 {
+				continue
+			}
+			line := fmt.Sprintf("%-40.40s", client.ClientID)
+			for _, severity := range severities {
+				line += fmt.Sprintf(" %10d", client.Alerts[severity])
+			}
+			add(false, "%s", line)
+		}
+		blank()
+
+		add(true, "%s", l.T("Alerts"))
+		alertRow := "%-17s %-10s %-24.24s %-20.20s %8s  %s"
+		add(true, alertRow, l.T("Triggered"), l.T("Severity"), l.T("Client"), l.T("Sensor"), l.T("Value"), l.T("Message"))
+		for i, alert := range data.Alerts {
+			if i == maxPDFAlerts {
+				add(false, "%s", l.T("... %d more alerts are listed in the CSV format.", len(data.Alerts)-maxPDFAlerts))
+				break
+			}
+			add(false, alertRow,
+				alert.TriggeredAt.In(data.Location).Format("2006-01-02 15:04"),
+				l.Severity(alert.Severity),
+				alert.ClientID, alert.SensorID,
+				fmt.Sprintf("%.1f", alert.Value),
+				truncate(alert.Message, 60))
+		}
+		if data.AlertsTruncated {
+			add(false, "%s", l.T("Only the first %d alerts are included.", maxAlerts))
+		}
+	}
+	blank()
+
+	add(true, "%s", l.T("Thermal wear (hours above threshold, estimated over time in service)"))
+	if len(data.Wear) == 0 {
+		add(false, "%s", l.T("No duty cycles were tracked."))
+	} else {
+		wearRow := "%-24.24s %-28.28s %-12.12s %9s %6s %10s %8s %10s %10s"
+		add(true, wearRow, l.T("Client"), l.T("Sensor"), l.T("Type"), l.T("Threshold"), l.T("Days"), l.T("Hours"), l.T("Above %"), l.T("In service"), l.T("Est. hours"))
+		for i, wear := range data.Wear {
+			if i == maxPDFWear {
+				add(false, "%s", l.T("... %d more components are listed in the CSV format.", len(data.Wear)-maxPDFWear))
+				break
+			}
+			name := wear.ClientID
+			if wear.Hostname != "" {
+				name = wear.Hostname
+			}
+			label := wear.SensorID
+			if wear.Name != "" {
+				label = wear.Name
+			}
+			inService := "-"
+			if wear.InService > 0 {
+				inService = l.T("%d days", int(wear.InService/(24*60*60)))
+			}
+			add(false, wearRow, name, label, wear.Type,
+				fmt.Sprintf("%.1f C", wear.Threshold),
+				fmt.Sprint(wear.Days),
+				fmt.Sprintf("%.1f", wear.Above/3600),
+				fmt.Sprintf("%.1f", wear.PercentAbove()),
+				inService,
+				fmt.Sprintf("%.1f", wear.EstimatedAbove()/3600))
+		}
+	}
+
+	return writePDFDocument(w, data.Title, lines, data.Generated, l)
+}
+
+func alertSeverities(data *Data) []string {
+	seen := make(map[string]bool)
+	for _, client := range data.Clients {
+		for severity := range client.Alerts {
+			seen[severity] = true
+		}
+	}
+	order := map[string]int{"SEVERITY_CRITICAL": 0, "SEVERITY_WARNING": 1, "SEVERITY_INFO": 2}
+	severities := make([]string, 0, len(seen))
+	for severity := range seen {
+		severities = append(severities, severity)
+	}
+	sort.Slice(severities, func(i, j int) bool {
+		oi, ok := order[severities[i]]
+		if !ok {
+			oi = len(order)
+		}
+		oj, ok := order[severities[j]]
+		if !ok {
+			oj = len(order)
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return severities[i] < severities[j]
+	})
+	return severities
+}
+
+func formatThreshold(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f C", *v)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
+
+// writePDFDocument lays lines out on pages and writes a minimal PDF 1.4 file
+// using the standard Courier fonts, which viewers provide without embedding
+func writePDFDocument(w io.Writer, title string, lines []pdfLine, created time.Time, l i18n.Locale) error {
+	var pages [][]pdfLine
+	for len(lines) > 0 {
+		n := min(len(lines), linesPerPage-2) // Leave room for the footer
+		pages = append(pages, lines[:n])
+		lines = lines[n:]
+	}
+	if len(pages) == 0 {
+		pages = append(pages, nil)
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-5 are fixed; each page then takes a page and a content object
+	firstPage := 6
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title %s /Producer (Jacuzzi) /CreationDate (D:%s) >>",
+		pdfString(title), created.UTC().Format("20060102150405Z")))
+
+	for i, page := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n%d TL\n%d %d Td\n", lineHeight, pageMargin, pageHeight-pageMargin-fontSize)
+		for _, line := range page {
+			font := "F1"
+			if line.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(&content, "/%s %d Tf %s Tj T*\n", font, fontSize, pdfString(truncate(line.text, lineChars)))
+		}
+		footer := l.T("Page %d of %d", i+1, len(pages))
+		fmt.Fprintf(&content, "ET\nBT\n/F1 %d Tf %d %d Td %s Tj\nET\n",
+			fontSize, pageWidth-pageMargin-len(footer)*fontSize*6/10, pageMargin-fontSize, pdfString(footer))
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, len(offsets)+2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfString encodes text as a PDF literal string in WinAnsiEncoding.
+// Characters outside Latin-1 become '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
@@ -0,0 +1,358 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// MaxRange bounds the period a single report may cover
+const MaxRange = 400 * 24 * time.Hour
+
+// maxAlerts bounds the alerts listed in a report
+const maxAlerts = 10000
+
+// Params selects what a report covers
+type Params struct {
+	Title            string // Defaults to "Thermal report" in the report's locale
+	Start, End       time.Time
+	ClientIDs        []string          // Empty for all approved clients
+	MetadataSelector map[string]string // Only clients whose metadata has all of these values
+	Location         *time.Location    // Timezone of days and timestamps; nil for the timezone setting
+	Locale           i18n.Locale       // Language of headings; empty for the locale setting
+}
+
+// Data is the content of a report, independent of its file format
+type Data struct {
+	Title     string
+	Start     time.Time
+	End       time.Time
+	Generated time.Time
+	Location  *time.Location // Days and timestamps are in the configured timezone
+	Locale    i18n.Locale    // Language of headings
+
+	// Thresholds from settings; nil when not configured
+	WarningThreshold  *float64
+	CriticalThreshold *float64
+
+	Clients         []*ClientSummary
+	Daily           []*DailyStats
+	Alerts          []models.Alert
+	AlertsTruncated bool
+
+	// Tracked components by their time above each threshold until the end of
+	// the period, most first
+	Wear []*ComponentWear
+}
+
+// ClientSummary covers one client over the whole period
+type ClientSummary struct {
+	ClientID string
+	Hostname string
+	Sensors  []*SensorSummary
+	Alerts   map[string]int // Alert counts by severity
+}
+
+// SensorSummary covers one sensor over the whole period
+type SensorSummary struct {
+	SensorID string
+	Name     string
+	Type     string
+	Stats
+	PeakAt        time.Time
+	AboveWarning  int64 // Readings above the warning threshold
+	AboveCritical int64 // Readings above the critical threshold
+}
+
+// DailyStats covers one sensor over one day in the report timezone
+type DailyStats struct {
+	Day      string // YYYY-MM-DD
+	ClientID string
+	SensorID string
+	Stats
+}
+
+// Stats aggregates a set of readings
+type Stats struct {
+	Min, Max float64
+	Count    int64
+	sum      float64
+}
+
+func (s *Stats) add(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.sum += v
+	s.Count++
+}
+
+// Avg returns the mean reading
+func (s *Stats) Avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.Count)
+}
+
+// Build gathers report data in one pass over the readings in range
+func Build(ctx context.Context, db *gorm.DB, p Params) (*Data, error) {
+	db = db.WithContext(ctx)
+	data := &Data{
+		Title:     p.Title,
+		Start:     p.Start,
+		End:       p.End,
+		Generated: time.Now(),
+		Location:  time.UTC,
+		Locale:    i18n.Default,
+	}
+	if err := loadSettings(db, data); err != nil {
+		return nil, err
+	}
+	if p.Location != nil {
+		data.Location = p.Location
+	}
+	if p.Locale != "" {
+		data.Locale = p.Locale
+	}
+	if data.Title == "" {
+		data.Title = data.Locale.T("Thermal report")
+	}
+
+	clients, err := selectClients(db, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return data, nil
+	}
+	ids := make([]string, len(clients))
+	byClient := make(map[string]*ClientSummary, len(clients))
+	for i, client := range clients {
+		ids[i] = client.ClientID
+		summary := &ClientSummary{ClientID: client.ClientID, Hostname: client.Hostname, Alerts: make(map[string]int)}
+		byClient[client.ClientID] = summary
+		data.Clients = append(data.Clients, summary)
+	}
+
+	// Sensor names come from the sensors table; readings may predate renames
+	var sensors []models.Sensor
+	if err := db.Where("client_id IN ?", ids).Find(&sensors).Error; err != nil {
+		return nil, fmt.Errorf("failed to query sensors: %w", err)
+	}
+	type sensorKey struct{ client, sensor string }
+	sensorByKey := make(map[sensorKey]*SensorSummary)
+	for _, sensor := range sensors {
+		summary := &SensorSummary{SensorID: sensor.SensorID, Name: sensor.SensorName, Type: sensor.SensorType}
+		sensorByKey[sensorKey{sensor.ClientID, sensor.SensorID}] = summary
+		byClient[sensor.ClientID].Sensors = append(byClient[sensor.ClientID].Sensors, summary)
+	}
+
+	rows, err := db.Model(&models.TemperatureReading{}).
+		Select("client_id, sensor_id, temperature_celsius, created_at").
+		Where("client_id IN ? AND created_at >= ? AND created_at <= ?", ids, p.Start, p.End).
+		Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query readings: %w", err)
+	}
+	defer rows.Close()
+
+	type dayKey struct {
+		sensorKey
+		day string
+	}
+	daily := make(map[dayKey]*DailyStats)
+	add := func(reading *models.TemperatureReading) {
+		key := sensorKey{reading.ClientID, reading.SensorID}
+		summary, ok := sensorByKey[key]
+		if !ok {
+			summary = &SensorSummary{SensorID: reading.SensorID}
+			sensorByKey[key] = summary
+			byClient[reading.ClientID].Sensors = append(byClient[reading.ClientID].Sensors, summary)
+		}
+
+		v := reading.TemperatureCelsius
+		if summary.Count == 0 || v > summary.Max {
+			summary.PeakAt = reading.CreatedAt
+		}
+		summary.add(v)
+		if data.WarningThreshold != nil && v > *data.WarningThreshold {
+			summary.AboveWarning++
+		}
+		if data.CriticalThreshold != nil && v > *data.CriticalThreshold {
+			summary.AboveCritical++
+		}
+
+		dk := dayKey{key, reading.CreatedAt.In(data.Location).Format("2006-01-02")}
+		day, ok := daily[dk]
+		if !ok {
+			day = &DailyStats{Day: dk.day, ClientID: key.client, SensorID: key.sensor}
+			daily[dk] = day
+		}
+		day.add(v)
+	}
+	for rows.Next() {
+		var reading models.TemperatureReading
+		if err := db.ScanRows(rows, &reading); err != nil {
+			return nil, fmt.Errorf("failed to read readings: %w", err)
+		}
+		add(&reading)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read readings: %w", err)
+	}
+	for _, id := range ids {
+		compressed, err := chunks.Readings(db, chunks.Filter{ClientID: id, Start: p.Start, End: p.End}, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query compressed readings: %w", err)
+		}
+		for i := range compressed {
+			add(&compressed[i])
+		}
+	}
+
+	for _, client := range data.Clients {
+		sort.Slice(client.Sensors, func(i, j int) bool { return client.Sensors[i].SensorID < client.Sensors[j].SensorID })
+	}
+	for _, day := range daily {
+		data.Daily = append(data.Daily, day)
+	}
+	sort.Slice(data.Daily, func(i, j int) bool {
+		a, b := data.Daily[i], data.Daily[j]
+		if a.ClientID != b.ClientID {
+			return a.ClientID < b.ClientID
+		}
+		if a.SensorID != b.SensorID {
+			return a.SensorID < b.SensorID
+		}
+		return a.Day < b.Day
+	})
+
+	err = db.Where("client_id IN ? AND triggered_at >= ? AND triggered_at <= ?", ids, p.Start, p.End).
+		Order("triggered_at").
+		Limit(maxAlerts + 1).
+		Find(&data.Alerts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	if len(data.Alerts) > maxAlerts {
+		data.Alerts = data.Alerts[:maxAlerts]
+		data.AlertsTruncated = true
+	}
+	for _, alert := range data.Alerts {
+		byClient[alert.ClientID].Alerts[alert.Severity]++
+	}
+
+	data.Wear, err = LoadWear(db, WearFilter{ClientIDs: ids, End: p.End})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// selectClients returns the approved clients matching the params, failing
+// if an explicitly requested client does not exist
+func selectClients(db *gorm.DB, p Params) ([]models.Client, error) {
+	query := db.Where("status = ?", models.ClientStatusApproved)
+	if len(p.ClientIDs) > 0 {
+		query = query.Where("client_id IN ?", p.ClientIDs)
+	}
+	var clients []models.Client
+	if err := query.Order("client_id").Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to query clients: %w", err)
+	}
+
+	if len(p.ClientIDs) > 0 {
+		found := make(map[string]bool, len(clients))
+		for _, client := range clients {
+			found[client.ClientID] = true
+		}
+		for _, id := range p.ClientIDs {
+			if !found[id] {
+				return nil, &UnknownClientError{ClientID: id}
+			}
+		}
+	}
+
+	if len(p.MetadataSelector) == 0 {
+		return clients, nil
+	}
+	matched := clients[:0]
+	for _, client := range clients {
+		var metadata map[string]string
+		if client.Metadata != "" {
+			json.Unmarshal([]byte(client.Metadata), &metadata)
+		}
+		ok := true
+		for key, value := range p.MetadataSelector {
+			if metadata[key] != value {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, client)
+		}
+	}
+	return matched, nil
+}
+
+// UnknownClientError reports a requested client that does not exist or is
+// not approved
+type UnknownClientError struct {
+	ClientID string
+}
+
+func (e *UnknownClientError) Error() string {
+	return fmt.Sprintf("client %s not found", e.ClientID)
+}
+
+func loadSettings(db *gorm.DB, data *Data) error {
+	var settings []models.Setting
+	err := db.Where("key IN ?", []string{
+		"general.timezone",
+		models.SettingLocale,
+		models.SettingTempWarningThreshold,
+		models.SettingTempCriticalThreshold,
+	}).Find(&settings).Error
+	if err != nil {
+		return fmt.Errorf("failed to query settings: %w", err)
+	}
+	for _, setting := range settings {
+		switch setting.Key {
+		case "general.timezone":
+			if loc, err := time.LoadLocation(setting.Value); err == nil {
+				data.Location = loc
+			}
+		case models.SettingLocale:
+			if locale, ok := i18n.Parse(setting.Value); ok {
+				data.Locale = locale
+			}
+		case models.SettingTempWarningThreshold:
+			data.WarningThreshold = parseThreshold(setting.Value)
+		case models.SettingTempCriticalThreshold:
+			data.CriticalThreshold = parseThreshold(setting.Value)
+		}
+	}
+	return nil
+}
+
+func parseThreshold(value string) *float64 {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,157 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// ComponentWear is the time one sensor's component spent above one threshold
+// over every day its duty cycle was tracked, and an estimate over its
+// client's whole time in service
+type ComponentWear struct {
+	ClientID        string
+	Hostname        string
+	ClientFirstSeen time.Time
+	SensorID        string
+	Name            string
+	Type            string
+	Threshold       float64
+	Days            int64   // Days with a tracked duty cycle
+	Above           float64 // Seconds above the threshold on those days
+	Tracked         float64 // Seconds covered by readings on those days
+	InService       float64 // Seconds from the client's first report until the end
+}
+
+// PercentAbove returns the share of tracked time spent above the threshold
+func (w *ComponentWear) PercentAbove() float64 {
+	if w.Tracked == 0 {
+		return 0
+	}
+	return 100 * w.Above / w.Tracked
+}
+
+// EstimatedAbove returns the seconds above the threshold over the client's
+// whole time in service, assuming untracked time was like tracked time
+func (w *ComponentWear) EstimatedAbove() float64 {
+	if w.Tracked == 0 || w.InService < w.Tracked {
+		return w.Above
+	}
+	return w.Above / w.Tracked * w.InService
+}
+
+// WearFilter selects the components ranked by LoadWear
+type WearFilter struct {
+	ClientIDs      []string  // Empty for all clients
+	SensorType     string    // Canonical sensor type; empty for all
+	Threshold      float64   // 0 for every tracked threshold
+	End            time.Time // Only days starting before this; zero for now
+	IncludeRetired bool
+}
+
+// LoadWear ranks the tracked components by their estimated time above each
+// threshold, most first
+func LoadWear(db *gorm.DB, f WearFilter) ([]*ComponentWear, error) {
+	end := f.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	var rows []struct {
+		ClientID  string
+		SensorID  string
+		Threshold float64
+		Days      int64
+		Above     float64
+		Tracked   float64
+	}
+	query := db.Model(&models.DutyCycle{}).
+		Select("client_id, sensor_id, threshold, COUNT(*) AS days, SUM(above) AS above, SUM(tracked) AS tracked").
+		Where("day < ?", end.UTC()).
+		Group("client_id, sensor_id, threshold")
+	if len(f.ClientIDs) > 0 {
+		query = query.Where("client_id IN ?", f.ClientIDs)
+	}
+	if f.Threshold != 0 {
+		query = query.Where("threshold = ?", f.Threshold)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to query duty cycles: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	sensorQuery := db.Select("client_id, sensor_id, sensor_name, sensor_type, retired_at")
+	clientQuery := db.Select("client_id, hostname, first_seen")
+	if len(f.ClientIDs) > 0 {
+		sensorQuery = sensorQuery.Where("client_id IN ?", f.ClientIDs)
+		clientQuery = clientQuery.Where("client_id IN ?", f.ClientIDs)
+	}
+	var sensors []models.Sensor
+	if err := sensorQuery.Find(&sensors).Error; err != nil {
+		return nil, fmt.Errorf("failed to query sensors: %w", err)
+	}
+	type sensorKey struct{ client, sensor string }
+	bySensor := make(map[sensorKey]*models.Sensor, len(sensors))
+	for i := range sensors {
+		bySensor[sensorKey{sensors[i].ClientID, sensors[i].SensorID}] = &sensors[i]
+	}
+	var clients []models.Client
+	if err := clientQuery.Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to query clients: %w", err)
+	}
+	byClient := make(map[string]*models.Client, len(clients))
+	for i := range clients {
+		byClient[clients[i].ClientID] = &clients[i]
+	}
+
+	var wear []*ComponentWear
+	for _, row := range rows {
+		// Duty cycles of sensors and clients that were removed are skipped
+		sensor, ok := bySensor[sensorKey{row.ClientID, row.SensorID}]
+		if !ok || (sensor.RetiredAt != nil && !f.IncludeRetired) {
+			continue
+		}
+		if f.SensorType != "" && sensor.SensorType != f.SensorType {
+			continue
+		}
+		client, ok := byClient[row.ClientID]
+		if !ok {
+			continue
+		}
+		component := &ComponentWear{
+			ClientID:        client.ClientID,
+			Hostname:        client.Hostname,
+			ClientFirstSeen: client.FirstSeen,
+			SensorID:        sensor.SensorID,
+			Name:            sensor.SensorName,
+			Type:            sensor.SensorType,
+			Threshold:       row.Threshold,
+			Days:            row.Days,
+			Above:           row.Above,
+			Tracked:         row.Tracked,
+		}
+		if !client.FirstSeen.IsZero() && client.FirstSeen.Before(end) {
+			component.InService = end.Sub(client.FirstSeen).Seconds()
+		}
+		wear = append(wear, component)
+	}
+	sort.Slice(wear, func(i, j int) bool {
+		a, b := wear[i], wear[j]
+		if ea, eb := a.EstimatedAbove(), b.EstimatedAbove(); ea != eb {
+			return ea > eb
+		}
+		if a.ClientID != b.ClientID {
+			return a.ClientID < b.ClientID
+		}
+		if a.SensorID != b.SensorID {
+			return a.SensorID < b.SensorID
+		}
+		return a.Threshold < b.Threshold
+	})
+	return wear, nil
+}
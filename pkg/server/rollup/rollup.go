@@ -0,0 +1,241 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Bucket sizes maintained by the worker
+var Buckets = []time.Duration{time.Hour, 24 * time.Hour}
+
+const watermarkName = "temperature_rollups"
+
+// Readings ingested within this window of now are left for the next run so
+// transactions that are still committing are not skipped
+const ingestLag = 30 * time.Second
+
+// batchSize bounds the ingested readings and chunks loaded at once, as the
+// first run after an upgrade covers the whole table
+const batchSize = 5000
+
+type Worker struct {
+	db *gorm.DB
+}
+
+func NewWorker(db *gorm.DB) *Worker {
+	return &Worker{db: db}
+}
+
+// RunOnce recomputes every bucket touched by readings ingested since the last
+// watermark, including buckets that were already rolled up before late data
+// arrived, and returns the number of buckets recomputed
+func (w *Worker) RunOnce(ctx context.Context) (int, error) {
+	db := w.db.WithContext(ctx)
+
+	var wm models.RollupWatermark
+	if err := db.Where("name = ?", watermarkName).FirstOrCreate(&wm, models.RollupWatermark{Name: watermarkName}).Error; err != nil {
+		return 0, fmt.Errorf("failed to load watermark: %w", err)
+	}
+
+	upTo := time.Now().Add(-ingestLag)
+	if !upTo.After(wm.Watermark) {
+		return 0, nil
+	}
+
+	type bucketKey struct {
+		clientID string
+		sensorID string
+		size     time.Duration
+		start    time.Time
+	}
+	dirty := make(map[bucketKey]struct{})
+	touch := func(clientID, sensorID string, at time.Time) {
+		for _, size := range Buckets {
+			dirty[bucketKey{clientID, sensorID, size, at.UTC().Truncate(size)}] = struct{}{}
+		}
+	}
+	// Buckets are recomputed after each batch so memory stays bounded; one
+	// touched by several batches is recomputed again, which is harmless
+	updated := 0
+	flush := func() error {
+		for key := range dirty {
+			if err := w.recompute(db, key.sensorID, key.clientID, key.size, key.start); err != nil {
+				return err
+			}
+		}
+		updated += len(dirty)
+		clear(dirty)
+		return nil
+	}
+
+	// Find readings ingested since the watermark, whatever their timestamp,
+	// a batch at a time. Burst readings are left out so buckets do not depend
+	// on whether a burst capture has been pruned yet.
+	var lastID uint
+	for {
+		var readings []models.TemperatureReading
+		err := db.Select("id", "sensor_id", "client_id", "created_at").
+			Where("updated_at > ? AND updated_at <= ? AND burst = ? AND id > ?", wm.Watermark, upTo, false, lastID).
+			Order("id").
+			Limit(batchSize).
+			Find(&readings).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to find ingested readings: %w", err)
+		}
+		for _, r := range readings {
+			touch(r.ClientID, r.SensorID, r.CreatedAt)
+		}
+		if err := flush(); err != nil {
+			return 0, err
+		}
+		if len(readings) < batchSize {
+			break
+		}
+		lastID = readings[len(readings)-1].ID
+	}
+	// Late readings may have been compressed before they were rolled up, so
+	// the hours of updated chunks are recomputed too
+	lastID = 0
+	for {
+		var chunks []models.ReadingChunk
+		err := db.Select("id", "client_id", "sensor_id", "hour").
+			Where("updated_at > ? AND updated_at <= ? AND id > ?", wm.Watermark, upTo, lastID).
+			Order("id").
+			Limit(batchSize).
+			Find(&chunks).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to find updated chunks: %w", err)
+		}
+		for _, chunk := range chunks {
+			touch(chunk.ClientID, chunk.SensorID, chunk.Hour)
+		}
+		if err := flush(); err != nil {
+			return 0, err
+		}
+		if len(chunks) < batchSize {
+			break
+		}
+		lastID = chunks[len(chunks)-1].ID
+	}
+
+	wm.Watermark = upTo
+	if err := db.Save(&wm).Error; err != nil {
+		return 0, fmt.Errorf("failed to save watermark: %w", err)
+	}
+
+	return updated, nil
+}
+
+// recompute aggregates the regular readings in a bucket, raw and compressed,
+// and upserts the rollup
+func (w *Worker) recompute(db *gorm.DB, sensorID, clientID string, size time.Duration, start time.Time) error {
+	var stats struct {
+		MinTemp float64
+		MaxTemp float64
+		AvgTemp float64
+		Count   int64
+	}
+	err := db.Model(&models.TemperatureReading{}).
+		Select("MIN(COALESCE(min_celsius, temperature_celsius)) as min_temp, MAX(COALESCE(max_celsius, temperature_celsius)) as max_temp, AVG(COALESCE(avg_celsius, temperature_celsius)) as avg_temp, COUNT(*) as count").
+		Where("client_id = ? AND sensor_id = ? AND created_at >= ? AND created_at < ? AND burst = ?", clientID, sensorID, start, start.Add(size), false).
+		Scan(&stats).Error
+	if err != nil {
+		return fmt.Errorf("failed to aggregate bucket for sensor %s: %w", sensorID, err)
+	}
+
+	// Chunks hold whole UTC hours, so each lies within one bucket
+	var compressed struct {
+		MinTemp float64
+		MaxTemp float64
+		SumTemp float64
+		Count   int64
+	}
+	err = db.Model(&models.ReadingChunk{}).
+		Select("MIN(min_celsius) as min_temp, MAX(max_celsius) as max_temp, SUM(sum_celsius) as sum_temp, COALESCE(SUM(count), 0) as count").
+		Where("client_id = ? AND sensor_id = ? AND hour >= ? AND hour < ?", clientID, sensorID, start, start.Add(size)).
+		Scan(&compressed).Error
+	if err != nil {
+		return fmt.Errorf("failed to aggregate compressed bucket for sensor %s: %w", sensorID, err)
+	}
+	if compressed.Count > 0 {
+		if stats.Count == 0 {
+			stats.MinTemp, stats.MaxTemp = compressed.MinTemp, compressed.MaxTemp
+		}
+		stats.MinTemp = min(stats.MinTemp, compressed.MinTemp)
+		stats.MaxTemp = max(stats.MaxTemp, compressed.MaxTemp)
+		stats.AvgTemp = (stats.AvgTemp*float64(stats.Count) + compressed.SumTemp) / float64(stats.Count+compressed.Count)
+		stats.Count += compressed.Count
+	}
+	if stats.Count == 0 {
+		return nil
+	}
+
+	rollup := &models.TemperatureRollup{
+		SensorID:      sensorID,
+		ClientID:      clientID,
+		BucketSeconds: int64(size.Seconds()),
+		BucketStart:   start,
+		MinTemp:       stats.MinTemp,
+		MaxTemp:       stats.MaxTemp,
+		AvgTemp:       stats.AvgTemp,
+		Count:         stats.Count,
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "client_id"}, {Name: "sensor_id"}, {Name: "bucket_seconds"}, {Name: "bucket_start"}},
+		DoUpdates: clause.AssignmentColumns([]string{"min_temp", "max_temp", "avg_temp", "count", "updated_at"}),
+	}).Create(rollup).Error
+	if err != nil {
+		return fmt.Errorf("failed to save rollup for sensor %s: %w", sensorID, err)
+	}
+	return nil
+}
+
+// Reassign moves the rollups of one client to another. A bucket the other
+// client already has for the sensor is combined with it, so history whose raw
+// readings were pruned is kept. It returns how many rollups were moved or
+// combined.
+func Reassign(tx *gorm.DB, fromClientID, toClientID string) (int64, error) {
+	var sources []models.TemperatureRollup
+	if err := tx.Where("client_id = ?", fromClientID).Find(&sources).Error; err != nil {
+		return 0, fmt.Errorf("failed to load rollups of client %s: %w", fromClientID, err)
+	}
+
+	for i := range sources {
+		source := &sources[i]
+		var target models.TemperatureRollup
+		err := tx.Where("client_id = ? AND sensor_id = ? AND bucket_seconds = ? AND bucket_start = ?", toClientID, source.SensorID, source.BucketSeconds, source.BucketStart).
+			Limit(1).
+			Find(&target).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to load rollup of sensor %s: %w", source.SensorID, err)
+		}
+		if target.ID == 0 {
+			continue
+		}
+
+		count := target.Count + source.Count
+		if count > 0 {
+			target.AvgTemp = (target.AvgTemp*float64(target.Count) + source.AvgTemp*float64(source.Count)) / float64(count)
+		}
+		target.MinTemp = min(target.MinTemp, source.MinTemp)
+		target.MaxTemp = max(target.MaxTemp, source.MaxTemp)
+		target.Count = count
+		if err := tx.Save(&target).Error; err != nil {
+			return 0, fmt.Errorf("failed to save rollup of sensor %s: %w", target.SensorID, err)
+		}
+		if err := tx.Delete(source).Error; err != nil {
+			return 0, fmt.Errorf("failed to delete combined rollup of sensor %s: %w", source.SensorID, err)
+		}
+	}
+
+	err := tx.Model(&models.TemperatureRollup{}).Where("client_id = ?", fromClientID).Update("client_id", toClientID).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to move rollups of client %s: %w", fromClientID, err)
+	}
+	return int64(len(sources)), nil
+}
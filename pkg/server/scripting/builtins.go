@@ -0,0 +1,240 @@
+package scripting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	starlarkjson "go.starlark.net/lib/json"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// runKey is the thread local holding the run a thread belongs to
+const runKey = "jacuzzi.run"
+
+// Limits on what one run of a script may do
+const (
+	maxWebhookCalls = 10
+	maxHistory      = 1000
+)
+
+// run is the state of one handler run, or of loading a script
+type run struct {
+	ctx          context.Context
+	engine       *Engine
+	scriptID     string
+	webhookCalls int
+}
+
+func runOf(thread *starlark.Thread) *run {
+	return thread.Local(runKey).(*run)
+}
+
+// jacuzziModule is the API scripts use to query the server and act on it
+var jacuzziModule = &starlarkstruct.Module{
+	Name: "jacuzzi",
+	Members: starlark.StringDict{
+		"latest":   starlark.NewBuiltin("latest", latest),
+		"history":  starlark.NewBuiltin("history", history),
+		"clients":  starlark.NewBuiltin("clients", clients),
+		"webhook":  starlark.NewBuiltin("webhook", callWebhook),
+		"cooldown": starlark.NewBuiltin("cooldown", cooldown),
+	},
+}
+
+func readingValue(clientID, sensorID, sensorType, sensorName string, temp float64, t time.Time) starlark.Value {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"client_id":   starlark.String(clientID),
+		"sensor_id":   starlark.String(sensorID),
+		"sensor_type": starlark.String(sensorType),
+		"sensor_name": starlark.String(sensorName),
+		"temperature": starlark.Float(temp),
+		"time":        starlarktime.Time(t),
+	})
+}
+
+func readingList(readings []models.TemperatureReading) *starlark.List {
+	list := make([]starlark.Value, len(readings))
+	for i, r := range readings {
+		list[i] = readingValue(r.ClientID, r.SensorID, r.SensorType, r.SensorName, r.TemperatureCelsius, r.CreatedAt)
+	}
+	return starlark.NewList(list)
+}
+
+// latest(client_id="", sensor_type="") returns the newest reading of every
+// sensor that is not retired, optionally filtered by client and sensor type
+func latest(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var clientID, sensorType string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "client_id?", &clientID, "sensor_type?", &sensorType); err != nil {
+		return nil, err
+	}
+
+	r := runOf(thread)
+	query := r.engine.db.WithContext(r.ctx).
+		Model(&models.TemperatureReading{}).
+		Select("temperature_readings.*").
+		Joins("JOIN sensors ON sensors.client_id = temperature_readings.client_id AND sensors.sensor_id = temperature_readings.sensor_id").
+		Where("sensors.retired_at IS NULL AND temperature_readings.created_at = sensors.last_reading_at")
+	if clientID != "" {
+		query = query.Where("temperature_readings.client_id = ?", clientID)
+	}
+	if sensorType != "" {
+		query = query.Where("temperature_readings.sensor_type = ?", sensortype.Filter(sensorType))
+	}
+
+	var readings []models.TemperatureReading
+	if err := query.Order("temperature_readings.client_id, temperature_readings.sensor_id").Find(&readings).Error; err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return readingList(readings), nil
+}
+
+// history(client_id, sensor_id, since=3600, limit=100) returns a sensor's
+// readings from the last since seconds, newest first
+func history(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var clientID, sensorID string
+	since, limit := 3600, 100
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "client_id", &clientID, "sensor_id", &sensorID, "since?", &since, "limit?", &limit); err != nil {
+		return nil, err
+	}
+	if since <= 0 {
+		return nil, fmt.Errorf("%s: since must be positive", b.Name())
+	}
+	if limit <= 0 || limit > maxHistory {
+		return nil, fmt.Errorf("%s: limit must be between 1 and %d", b.Name(), maxHistory)
+	}
+
+	r := runOf(thread)
+	var readings []models.TemperatureReading
+	err := r.engine.db.WithContext(r.ctx).
+		Where("client_id = ? AND sensor_id = ? AND created_at >= ?", clientID, sensorID, time.Now().Add(-time.Duration(since)*time.Second)).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&readings).Error
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return readingList(readings), nil
+}
+
+// clients(online=None) returns the approved clients, optionally only those
+// online or offline
+func clients(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var online starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "online?", &online); err != nil {
+		return nil, err
+	}
+
+	r := runOf(thread)
+	query := r.engine.db.WithContext(r.ctx).Where("status = ?", models.ClientStatusApproved)
+	switch online {
+	case starlark.None:
+	case starlark.True, starlark.False:
+		query = query.Where("is_online = ?", online == starlark.True)
+	default:
+		return nil, fmt.Errorf("%s: online must be a bool or None, got %s", b.Name(), online.Type())
+	}
+
+	var found []models.Client
+	if err := query.Order("hostname, client_id").Find(&found).Error; err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	list := make([]starlark.Value, len(found))
+	for i, c := range found {
+		metadata := starlark.NewDict(0)
+		if c.Metadata != "" {
+			var values map[string]string
+			if err := json.Unmarshal([]byte(c.Metadata), &values); err == nil {
+				for k, v := range values {
+					metadata.SetKey(starlark.String(k), starlark.String(v))
+				}
+			}
+		}
+		list[i] = starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"client_id": starlark.String(c.ClientID),
+			"hostname":  starlark.String(c.Hostname),
+			"online":    starlark.Bool(c.IsOnline),
+			"last_seen": starlarktime.Time(c.LastSeen),
+			"metadata":  metadata,
+		})
+	}
+	return starlark.NewList(list), nil
+}
+
+// webhook(url, body=None, headers={}) posts body as JSON to an http or https
+// URL and returns the response status code
+func callWebhook(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var rawURL string
+	var body starlark.Value = starlark.None
+	headers := &starlark.Dict{}
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "url", &rawURL, "body?", &body, "headers?", &headers); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s: invalid url %q: must be an absolute http or https URL", b.Name(), rawURL)
+	}
+
+	r := runOf(thread)
+	if r.webhookCalls >= maxWebhookCalls {
+		return nil, fmt.Errorf("%s: a run may call at most %d webhooks", b.Name(), maxWebhookCalls)
+	}
+	r.webhookCalls++
+
+	encoded, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{body}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodPost, rawURL, bytes.NewReader([]byte(encoded.(starlark.String))))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jacuzzi-script")
+	for _, item := range headers.Items() {
+		k, ok1 := starlark.AsString(item[0])
+		v, ok2 := starlark.AsString(item[1])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s: headers must map strings to strings", b.Name())
+		}
+		req.Header.Set(k, v)
+	}
+
+	resp, err := r.engine.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return starlark.MakeInt(resp.StatusCode), nil
+}
+
+// cooldown(key, seconds) returns True, and starts the cooldown, when key has
+// not started one in the last seconds seconds. Keys are per script and kept
+// in memory, so they reset when the server restarts.
+func cooldown(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var seconds int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "seconds", &seconds); err != nil {
+		return nil, err
+	}
+
+	r := runOf(thread)
+	key = r.scriptID + "/" + key
+	now := time.Now()
+	if last, ok := r.engine.cooldowns[key]; ok && now.Sub(last) < time.Duration(seconds)*time.Second {
+		return starlark.False, nil
+	}
+	r.engine.cooldowns[key] = now
+	return starlark.True, nil
+}
@@ -0,0 +1,297 @@
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+	"gorm.io/gorm"
+)
+
+// Functions a script defines to handle events. A script only runs for the
+// events it has a handler for.
+const (
+	HandlerReadings = "on_readings" // Called with the list of readings stored by one request
+	HandlerAlert    = "on_alert"    // Called with an alert that fired
+)
+
+// Handlers lists every handler name
+var Handlers = []string{HandlerReadings, HandlerAlert}
+
+// queueSize bounds the events waiting for scripts; further events are
+// dropped until the scripts catch up
+const queueSize = 256
+
+var (
+	scriptRuns = metrics.NewCounterVec(
+		"jacuzzi_script_runs_total",
+		"Script handler runs, by script name and result (ok or error).",
+		"script", "result",
+	)
+	droppedScriptEvents = metrics.NewCounterVec(
+		"jacuzzi_script_events_dropped_total",
+		"Events dropped because scripts were not keeping up.",
+		"handler",
+	)
+)
+
+// fileOptions are the Starlark dialect scripts are written in
+var fileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// predeclared are the modules available to every script
+var predeclared = starlark.StringDict{
+	"jacuzzi": jacuzziModule,
+	"json":    json.Module,
+	"math":    math.Module,
+	"time":    starlarktime.Module,
+}
+
+// Config limits each handler run
+type Config struct {
+	Timeout  time.Duration
+	MaxSteps uint64 // Starlark execution steps; 0 is unlimited
+}
+
+// Engine runs enabled scripts on server events. Events are queued and
+// handled one at a time in the background, so emitting never blocks the
+// caller. A nil Engine drops every event.
+type Engine struct {
+	db     *gorm.DB
+	cfg    Config
+	client *http.Client
+	queue  chan event
+
+	// Only used by the Run goroutine
+	programs  map[string]*program
+	cooldowns map[string]time.Time
+}
+
+type event struct {
+	handler string
+	arg     starlark.Value
+}
+
+// program is a loaded script
+type program struct {
+	updatedAt time.Time
+	globals   starlark.StringDict
+	err       error  // Error loading the script
+	lastError string // Error stored on the script
+}
+
+func NewEngine(db *gorm.DB, cfg Config) *Engine {
+	return &Engine{
+		db:        db,
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		queue:     make(chan event, queueSize),
+		programs:  make(map[string]*program),
+		cooldowns: make(map[string]time.Time),
+	}
+}
+
+// Check parses a script and returns the handlers it defines
+func Check(name, source string) ([]string, error) {
+	f, _, err := starlark.SourceProgramOptions(fileOptions, name+".star", source, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+	var handlers []string
+	for _, stmt := range f.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && slices.Contains(Handlers, def.Name.Name) {
+			handlers = append(handlers, def.Name.Name)
+		}
+	}
+	return handlers, nil
+}
+
+// OnReadings queues newly stored readings for on_readings handlers
+func (e *Engine) OnReadings(readings []*temperaturev1.TemperatureReading) {
+	if e == nil || len(readings) == 0 {
+		return
+	}
+	list := make([]starlark.Value, len(readings))
+	for i, r := range readings {
+		list[i] = readingValue(r.ClientId, r.SensorId, r.SensorType, r.SensorName, r.TemperatureCelsius, r.Timestamp.AsTime())
+	}
+	e.emit(HandlerReadings, starlark.NewList(list))
+}
+
+// OnAlert queues a fired alert for on_alert handlers
+func (e *Engine) OnAlert(alert *models.Alert) {
+	if e == nil {
+		return
+	}
+	e.emit(HandlerAlert, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"id":           starlark.String(alert.AlertID),
+		"rule_id":      starlark.String(alert.RuleID),
+		"client_id":    starlark.String(alert.ClientID),
+		"sensor_id":    starlark.String(alert.SensorID),
+		"value":        starlark.Float(alert.Value),
+		"severity":     starlark.String(alert.Severity),
+		"message":      starlark.String(alert.Message),
+		"triggered_at": starlarktime.Time(alert.TriggeredAt),
+	}))
+}
+
+func (e *Engine) emit(handler string, arg starlark.Value) {
+	arg.Freeze()
+	select {
+	case e.queue <- event{handler: handler, arg: arg}:
+	default:
+		droppedScriptEvents.Inc(handler)
+	}
+}
+
+// Run handles queued events until ctx is done
+func (e *Engine) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-e.queue:
+			if err := e.dispatch(ctx, ev); err != nil {
+				log.Printf("Failed to run %s scripts: %v", ev.handler, err)
+			}
+		}
+	}
+}
+
+// dispatch calls the event's handler in every enabled script that defines it
+func (e *Engine) dispatch(ctx context.Context, ev event) error {
+	var scripts []models.Script
+	if err := e.db.WithContext(ctx).Where("enabled = ?", true).Order("name, script_id").Find(&scripts).Error; err != nil {
+		return err
+	}
+
+	loaded := make(map[string]bool, len(scripts))
+	for i := range scripts {
+		script := &scripts[i]
+		loaded[script.ScriptID] = true
+		prog := e.load(ctx, script)
+		if prog.err != nil {
+			continue
+		}
+		fn, ok := prog.globals[ev.handler].(starlark.Callable)
+		if !ok {
+			continue
+		}
+
+		thread, done := e.newThread(ctx, script)
+		_, err := starlark.Call(thread, fn, starlark.Tuple{ev.arg}, nil)
+		done()
+		e.record(script, prog, err)
+	}
+
+	// Forget scripts that were deleted or disabled
+	for id := range e.programs {
+		if !loaded[id] {
+			delete(e.programs, id)
+		}
+	}
+	for key := range e.cooldowns {
+		if id, _, _ := strings.Cut(key, "/"); !loaded[id] {
+			delete(e.cooldowns, key)
+		}
+	}
+	return nil
+}
+
+// load returns a script's program, running its top level again when the
+// script changed
+func (e *Engine) load(ctx context.Context, script *models.Script) *program {
+	if prog, ok := e.programs[script.ScriptID]; ok && prog.updatedAt.Equal(script.UpdatedAt) {
+		return prog
+	}
+
+	prog := &program{updatedAt: script.UpdatedAt, lastError: script.LastError}
+	thread, done := e.newThread(ctx, script)
+	prog.globals, prog.err = starlark.ExecFileOptions(fileOptions, thread, script.Name+".star", script.Source, predeclared)
+	done()
+	if prog.err == nil {
+		prog.globals.Freeze()
+	} else {
+		log.Printf("Failed to load script %s: %v", script.Name, prog.err)
+	}
+	e.programs[script.ScriptID] = prog
+	e.storeError(script, prog, prog.err)
+	return prog
+}
+
+// newThread prepares a thread for one run of a script, returning a function
+// that releases it
+func (e *Engine) newThread(ctx context.Context, script *models.Script) (*starlark.Thread, func()) {
+	runCtx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	thread := &starlark.Thread{
+		Name: script.Name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("Script %s: %s", script.Name, msg)
+		},
+	}
+	if e.cfg.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(e.cfg.MaxSteps)
+	}
+	thread.SetLocal(runKey, &run{ctx: runCtx, engine: e, scriptID: script.ScriptID})
+	stop := context.AfterFunc(runCtx, func() {
+		thread.Cancel(fmt.Sprintf("exceeded the %s time limit", e.cfg.Timeout))
+	})
+	return thread, func() {
+		stop()
+		cancel()
+	}
+}
+
+// record counts a handler run and stores its result
+func (e *Engine) record(script *models.Script, prog *program, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+		log.Printf("Script %s failed: %v", script.Name, err)
+	}
+	scriptRuns.Inc(script.Name, result)
+	e.storeError(script, prog, err)
+}
+
+// storeError stores the error of a script's latest run, or clears it after a
+// successful run, when it differs from the error already stored
+func (e *Engine) storeError(script *models.Script, prog *program, err error) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			message = evalErr.Backtrace()
+		}
+	}
+	if message == prog.lastError {
+		return
+	}
+
+	updates := map[string]interface{}{"last_error": message, "last_error_at": nil}
+	if err != nil {
+		updates["last_error_at"] = time.Now()
+	}
+	// Leave updated_at alone so the script is not reloaded
+	if err := e.db.Model(&models.Script{}).Where("id = ?", script.ID).UpdateColumns(updates).Error; err != nil {
+		log.Printf("Failed to record result of script %s: %v", script.Name, err)
+		return
+	}
+	prog.lastError = message
+}
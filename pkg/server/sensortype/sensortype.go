@@ -0,0 +1,81 @@
+// Package sensortype maps the sensor types agents report to the canonical
+// SensorType names, so "CPU", "cpu" and "coretemp" filter and match rules
+// alike.
+package sensortype
+
+import (
+	"strings"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+)
+
+const prefix = "SENSOR_TYPE_"
+
+// Canonical names of the sensor types
+var (
+	CPU         = name(temperaturev1.SensorType_SENSOR_TYPE_CPU)
+	GPU         = name(temperaturev1.SensorType_SENSOR_TYPE_GPU)
+	Disk        = name(temperaturev1.SensorType_SENSOR_TYPE_DISK)
+	Motherboard = name(temperaturev1.SensorType_SENSOR_TYPE_MOTHERBOARD)
+	Memory      = name(temperaturev1.SensorType_SENSOR_TYPE_MEMORY)
+	Network     = name(temperaturev1.SensorType_SENSOR_TYPE_NETWORK)
+	Battery     = name(temperaturev1.SensorType_SENSOR_TYPE_BATTERY)
+	Ambient     = name(temperaturev1.SensorType_SENSOR_TYPE_AMBIENT)
+	Server      = name(temperaturev1.SensorType_SENSOR_TYPE_SERVER)
+	Other       = name(temperaturev1.SensorType_SENSOR_TYPE_OTHER)
+)
+
+// Spellings and Linux hwmon driver names of each type, lowercase
+var aliases = map[string]string{
+	"processor": CPU, "coretemp": CPU, "k10temp": CPU, "k8temp": CPU, "zenpower": CPU,
+	"core": CPU, "cpu_thermal": CPU, "x86_pkg_temp": CPU, "package": CPU, "soc_thermal": CPU,
+	"graphics": GPU, "amdgpu": GPU, "radeon": GPU, "nouveau": GPU, "nvidia": GPU, "i915": GPU,
+	"drive": Disk, "drivetemp": Disk, "nvme": Disk, "hdd": Disk, "ssd": Disk, "storage": Disk,
+	"mainboard": Motherboard, "board": Motherboard, "chipset": Motherboard, "pch": Motherboard,
+	"acpitz": Motherboard, "vrm": Motherboard,
+	"ram": Memory, "dimm": Memory, "jc42": Memory, "spd5118": Memory,
+	"nic": Network, "ethernet": Network,
+	"bat":  Battery,
+	"room": Ambient, "inlet": Ambient, "intake": Ambient,
+}
+
+func name(t temperaturev1.SensorType) string {
+	return strings.TrimPrefix(t.String(), prefix)
+}
+
+// Normalize returns the canonical name of a reported sensor type. Names of
+// the enum match in any case, with or without their prefix; aliases match
+// alone or followed by a device suffix such as "nvme-pci-0100" or "acpitz1".
+// Empty and unrecognized types are Other.
+func Normalize(sensorType string) string {
+	key := strings.ToLower(strings.TrimSpace(sensorType))
+	key = strings.TrimPrefix(key, strings.ToLower(prefix))
+	if key == "" {
+		return Other
+	}
+	candidates := []string{key}
+	if i := strings.IndexAny(key, "-_ "); i > 0 {
+		candidates = append(candidates, key[:i])
+	}
+	if trimmed := strings.TrimRight(key, "0123456789"); trimmed != "" && trimmed != key {
+		candidates = append(candidates, trimmed)
+	}
+	for _, candidate := range candidates {
+		if t, ok := temperaturev1.SensorType_value[prefix+strings.ToUpper(candidate)]; ok && t != int32(temperaturev1.SensorType_SENSOR_TYPE_UNSPECIFIED) {
+			return name(temperaturev1.SensorType(t))
+		}
+		if canonical, ok := aliases[candidate]; ok {
+			return canonical
+		}
+	}
+	return Other
+}
+
+// Filter normalizes a sensor type a caller filters by, keeping empty as no
+// filter
+func Filter(sensorType string) string {
+	if strings.TrimSpace(sensorType) == "" {
+		return ""
+	}
+	return Normalize(sensorType)
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	"github.com/nickheyer/jacuzzi/pkg/systemlog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// actionConfig lists the config keys an action type accepts
+type actionConfig struct {
+	required []string
+	optional []string
+}
+
+// Config keys of each action type; keys not listed are rejected so typos do
+// not silently disable an action
+var actionConfigs = map[alertv1.AlertAction_ActionType]actionConfig{
+	alertv1.AlertAction_ACTION_TYPE_EMAIL: {
+		required: []string{"recipients"}, // Comma separated addresses
+		optional: []string{"subject"},
+	},
+	alertv1.AlertAction_ACTION_TYPE_WEBHOOK: {
+		required: []string{"url"},
+		optional: []string{"secret"},
+	},
+	alertv1.AlertAction_ACTION_TYPE_LOG: {},
+	alertv1.AlertAction_ACTION_TYPE_EMERGENCY: {
+		required: []string{"action"}, // One of the client's local_actions
+	},
+	alertv1.AlertAction_ACTION_TYPE_SYSLOG: {
+		optional: []string{"tag", "facility"},
+	},
+	alertv1.AlertAction_ACTION_TYPE_EVENT_LOG: {
+		optional: []string{"source"},
+	},
+}
+
+// Helper function to validate a rule's actions before anything is stored
+func validateAlertActions(conditionType alertv1.AlertCondition_Type, severity alertv1.Severity, actions []*alertv1.AlertAction) error {
+	for i, action := range actions {
+		if action == nil || action.Type == alertv1.AlertAction_ACTION_TYPE_UNSPECIFIED {
+			return status.Errorf(codes.InvalidArgument, "action %d needs a type", i+1)
+		}
+		schema, ok := actionConfigs[action.Type]
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "action %d has unknown type %d", i+1, action.Type)
+		}
+
+		allowed := make(map[string]bool, len(schema.required)+len(schema.optional))
+		for _, key := range append(schema.required, schema.optional...) {
+			allowed[key] = true
+		}
+		keys := make([]string, 0, len(action.Config))
+		for key := range action.Config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !allowed[key] {
+				return status.Errorf(codes.InvalidArgument, "action %d: %s actions have no config %q", i+1, action.Type, key)
+			}
+		}
+		for _, key := range schema.required {
+			if strings.TrimSpace(action.Config[key]) == "" {
+				return status.Errorf(codes.InvalidArgument, "action %d: %s actions need config %q", i+1, action.Type, key)
+			}
+		}
+
+		switch action.Type {
+		case alertv1.AlertAction_ACTION_TYPE_EMAIL:
+			for _, recipient := range strings.Split(action.Config["recipients"], ",") {
+				if _, err := mail.ParseAddress(strings.TrimSpace(recipient)); err != nil {
+					return status.Errorf(codes.InvalidArgument, "action %d: invalid recipient %q", i+1, strings.TrimSpace(recipient))
+				}
+			}
+		case alertv1.AlertAction_ACTION_TYPE_WEBHOOK:
+			target, err := url.Parse(action.Config["url"])
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				return status.Errorf(codes.InvalidArgument, "action %d: webhook url must be an absolute http or https URL", i+1)
+			}
+		case alertv1.AlertAction_ACTION_TYPE_EMERGENCY:
+			// Emergency actions run commands on clients, so only critical
+			// rules may have them. An offline client would only run the
+			// action once it is back, aggregate alerts belong to a group
+			// rather than a single client, and a failing probe says nothing
+			// about the machine's temperature.
+			if conditionType == alertv1.AlertCondition_TYPE_CLIENT_OFFLINE {
+				return status.Error(codes.InvalidArgument, "client offline rules cannot have emergency actions")
+			}
+			if conditionType == alertv1.AlertCondition_TYPE_AGGREGATE {
+				return status.Error(codes.InvalidArgument, "aggregate rules cannot have emergency actions")
+			}
+			if conditionType == alertv1.AlertCondition_TYPE_SENSOR_QUALITY {
+				return status.Error(codes.InvalidArgument, "sensor quality rules cannot have emergency actions")
+			}
+			if severity != alertv1.Severity_SEVERITY_CRITICAL {
+				return status.Error(codes.InvalidArgument, "emergency actions require a critical rule")
+			}
+		case alertv1.AlertAction_ACTION_TYPE_SYSLOG:
+			if tag := action.Config["tag"]; tag != "" && !systemlog.ValidSource(tag) {
+				return status.Errorf(codes.InvalidArgument, "action %d: syslog tag %q must be 1-64 letters, digits, dots, underscores or hyphens", i+1, tag)
+			}
+			if facility := action.Config["facility"]; facility != "" && !systemlog.ValidFacility(facility) {
+				return status.Errorf(codes.InvalidArgument, "action %d: unknown syslog facility %q", i+1, facility)
+			}
+		case alertv1.AlertAction_ACTION_TYPE_EVENT_LOG:
+			// The entry is written by the alert's client, which an offline
+			// alert's client cannot do and an aggregate alert does not have
+			if conditionType == alertv1.AlertCondition_TYPE_CLIENT_OFFLINE {
+				return status.Error(codes.InvalidArgument, "client offline rules cannot have event log actions")
+			}
+			if conditionType == alertv1.AlertCondition_TYPE_AGGREGATE {
+				return status.Error(codes.InvalidArgument, "aggregate rules cannot have event log actions")
+			}
+			if source := action.Config["source"]; source != "" && !systemlog.ValidSource(source) {
+				return status.Errorf(codes.InvalidArgument, "action %d: event log source %q must be 1-64 letters, digits, dots, underscores or hyphens", i+1, source)
+			}
+		}
+	}
+	return nil
+}
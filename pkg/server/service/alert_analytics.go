@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxAnalyticsRange bounds the range of alert analytics, which read every
+// alert in it
+const maxAnalyticsRange = 400 * 24 * time.Hour
+
+// frequency tallies the alerts of a rule or client
+type frequency struct {
+	id          string
+	count       int64
+	resolved    int64
+	resolveTime time.Duration
+}
+
+func (f *frequency) proto(name string, days float64) *alertv1.AlertFrequency {
+	return &alertv1.AlertFrequency{
+		Id:          f.id,
+		Name:        name,
+		Count:       f.count,
+		PerDay:      float64(f.count) / days,
+		MttrSeconds: meanSeconds(f.resolveTime, f.resolved),
+	}
+}
+
+func (s *AlertService) GetAlertAnalytics(ctx context.Context, req *alertv1.GetAlertAnalyticsRequest) (*alertv1.GetAlertAnalyticsResponse, error) {
+	db := s.db.WithContext(ctx)
+	loc, err := resolveTimezone(db, req.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	end := now
+	if req.EndTime != nil {
+		end = req.EndTime.AsTime()
+	}
+	start := end.Add(-7 * 24 * time.Hour)
+	if req.StartTime != nil {
+		start = req.StartTime.AsTime()
+	}
+	if !start.Before(end) {
+		return nil, status.Error(codes.InvalidArgument, "start_time must be before end_time")
+	}
+	if end.Sub(start) > maxAnalyticsRange {
+		return nil, status.Errorf(codes.InvalidArgument, "range must be at most %d days", int(maxAnalyticsRange/(24*time.Hour)))
+	}
+	limit := int(req.Limit)
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+
+	query, err := alertFilter{
+		ruleID:   req.RuleId,
+		clientID: req.ClientId,
+		start:    timestamppb.New(start),
+		end:      timestamppb.New(end),
+	}.query(db)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &alertv1.GetAlertAnalyticsResponse{
+		StartTime: timestamppb.New(start),
+		EndTime:   timestamppb.New(end),
+	}
+	var resolveTime, ackTime time.Duration
+	var acknowledged int64
+	rules := make(map[string]*frequency)
+	clients := make(map[string]*frequency)
+	var hours [24]int64
+	type running struct {
+		id       uint
+		duration time.Duration
+	}
+	var durations []running
+	tally := func(tallies map[string]*frequency, id string, alert *models.Alert) {
+		f, ok := tallies[id]
+		if !ok {
+			f = &frequency{id: id}
+			tallies[id] = f
+		}
+		f.count++
+		if alert.ResolvedAt != nil {
+			f.resolved++
+			f.resolveTime += alert.ResolvedAt.Sub(alert.TriggeredAt)
+		}
+	}
+
+	err = eachAlert(query.Select("id, rule_id, client_id, triggered_at, resolved_at, acknowledged_at, is_active"), func(alerts []models.Alert) error {
+		for i := range alerts {
+			alert := &alerts[i]
+			resp.TotalCount++
+			if alert.IsActive {
+				resp.ActiveCount++
+			}
+			if alert.ResolvedAt != nil {
+				resp.ResolvedCount++
+				resolveTime += alert.ResolvedAt.Sub(alert.TriggeredAt)
+			}
+			if alert.AcknowledgedAt != nil {
+				acknowledged++
+				ackTime += alert.AcknowledgedAt.Sub(alert.TriggeredAt)
+			}
+			tally(rules, alert.RuleID, alert)
+			if alert.ClientID != "" {
+				tally(clients, alert.ClientID, alert)
+			}
+			hours[alert.TriggeredAt.In(loc).Hour()]++
+
+			switch {
+			case alert.ResolvedAt != nil:
+				durations = append(durations, running{alert.ID, alert.ResolvedAt.Sub(alert.TriggeredAt)})
+			case alert.IsActive:
+				durations = append(durations, running{alert.ID, now.Sub(alert.TriggeredAt)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.MttrSeconds = meanSeconds(resolveTime, resp.ResolvedCount)
+	resp.MttaSeconds = meanSeconds(ackTime, acknowledged)
+
+	names, err := loadAlertNames(db)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to load names")
+	}
+	days := end.Sub(start).Hours() / 24
+	for _, f := range rankFrequencies(rules, limit) {
+		resp.Rules = append(resp.Rules, f.proto(names.rules[f.id], days))
+	}
+	for _, f := range rankFrequencies(clients, limit) {
+		resp.Clients = append(resp.Clients, f.proto(names.hostnames[f.id], days))
+	}
+
+	for hour, count := range hours {
+		if count > 0 {
+			resp.Hours = append(resp.Hours, &alertv1.HourCount{Hour: int32(hour), Count: count})
+		}
+	}
+	sort.SliceStable(resp.Hours, func(i, j int) bool { return resp.Hours[i].Count > resp.Hours[j].Count })
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i].duration > durations[j].duration })
+	if len(durations) > limit {
+		durations = durations[:limit]
+	}
+	if len(durations) > 0 {
+		ids := make([]uint, len(durations))
+		for i, d := range durations {
+			ids[i] = d.id
+		}
+		var alerts []models.Alert
+		if err := db.Where("id IN ?", ids).Find(&alerts).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to load longest-running alerts")
+		}
+		byID := make(map[uint]*models.Alert, len(alerts))
+		for i := range alerts {
+			byID[alerts[i].ID] = &alerts[i]
+		}
+		for _, d := range durations {
+			if alert, ok := byID[d.id]; ok {
+				resp.Longest = append(resp.Longest, &alertv1.LongRunningAlert{
+					Alert:           s.modelToProtoAlert(alert),
+					DurationSeconds: int64(d.duration / time.Second),
+				})
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+// rankFrequencies returns up to limit tallies, most alerts first
+func rankFrequencies(tallies map[string]*frequency, limit int) []*frequency {
+	ranked := make([]*frequency, 0, len(tallies))
+	for _, f := range tallies {
+		ranked = append(ranked, f)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].count != ranked[j].count {
+			return ranked[i].count > ranked[j].count
+		}
+		return ranked[i].id < ranked[j].id
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// meanSeconds returns the mean of n durations totalling total, or 0 for none
+func meanSeconds(total time.Duration, n int64) float64 {
+	if n == 0 {
+		return 0
+	}
+	return total.Seconds() / float64(n)
+}
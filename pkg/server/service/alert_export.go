@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// alertBatchSize bounds the alerts loaded at once by eachAlert
+const alertBatchSize = 500
+
+// exportChunkSize is the size from which buffered CSV is sent as a chunk
+const exportChunkSize = 64 << 10
+
+var alertExportHeader = []string{
+	"alert_id", "rule_id", "rule_name", "client_id", "hostname", "sensor_id", "sensor_name",
+	"severity", "value", "message", "triggered_at", "resolved_at", "duration_seconds",
+	"acknowledged_at", "acknowledged_by", "seconds_to_acknowledge", "active", "muted", "flapping",
+	"external_id",
+}
+
+func (s *AlertService) ExportAlertHistory(req *alertv1.ExportAlertHistoryRequest, stream jacuzziv1.AlertService_ExportAlertHistoryServer) error {
+	db := s.db.WithContext(stream.Context())
+	loc, err := resolveTimezone(db, req.Timezone)
+	if err != nil {
+		return err
+	}
+	query, err := alertFilter{
+		ruleID:     req.RuleId,
+		clientID:   req.ClientId,
+		activeOnly: req.ActiveOnly,
+		start:      req.StartTime,
+		end:        req.EndTime,
+		severity:   req.Severity,
+	}.query(db)
+	if err != nil {
+		return err
+	}
+	names, err := loadAlertNames(db)
+	if err != nil {
+		return apierror.Wrap(err, "failed to load names")
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	flush := func(force bool) error {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		if buf.Len() == 0 || (!force && buf.Len() < exportChunkSize) {
+			return nil
+		}
+		// The chunk is marshaled before Send returns, so the buffer can be reused
+		if err := stream.Send(&alertv1.ExportAlertHistoryChunk{Data: buf.Bytes()}); err != nil {
+			return err
+		}
+		buf.Reset()
+		return nil
+	}
+
+	if err := w.Write(alertExportHeader); err != nil {
+		return apierror.Wrap(err, "failed to write alert export")
+	}
+	now := time.Now()
+	err = eachAlert(query, func(alerts []models.Alert) error {
+		for i := range alerts {
+			if err := w.Write(names.record(&alerts[i], loc, now)); err != nil {
+				return apierror.Wrap(err, "failed to write alert export")
+			}
+		}
+		return flush(false)
+	})
+	if err != nil {
+		return err
+	}
+	return flush(true)
+}
+
+// eachAlert passes the alerts a query matches to fn in batches, oldest
+// first. Batches continue after the last alert passed, as alerts triggered
+// meanwhile would shift offsets.
+func eachAlert(query *gorm.DB, fn func([]models.Alert) error) error {
+	var last *models.Alert
+	for {
+		page := query.Session(&gorm.Session{})
+		if last != nil {
+			page = page.Where("triggered_at > ? OR (triggered_at = ? AND id > ?)", last.TriggeredAt, last.TriggeredAt, last.ID)
+		}
+		var alerts []models.Alert
+		if err := page.Order("triggered_at, id").Limit(alertBatchSize).Find(&alerts).Error; err != nil {
+			return apierror.Wrap(err, "failed to load alerts")
+		}
+		if len(alerts) > 0 {
+			if err := fn(alerts); err != nil {
+				return err
+			}
+		}
+		if len(alerts) < alertBatchSize {
+			return nil
+		}
+		last = &alerts[len(alerts)-1]
+	}
+}
+
+// alertNames resolves the rule, client and sensor IDs of alerts to names
+type alertNames struct {
+	rules     map[string]string
+	hostnames map[string]string
+	sensors   map[[2]string]string // By client and sensor ID
+}
+
+func loadAlertNames(db *gorm.DB) (*alertNames, error) {
+	names := &alertNames{
+		rules:     make(map[string]string),
+		hostnames: make(map[string]string),
+		sensors:   make(map[[2]string]string),
+	}
+	// Alerts outlive their rules, which are soft deleted
+	var rules []models.AlertRule
+	if err := db.Unscoped().Select("rule_id, name").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		names.rules[rule.RuleID] = rule.Name
+	}
+	var clients []models.Client
+	if err := db.Select("client_id, hostname").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		names.hostnames[client.ClientID] = client.Hostname
+	}
+	var sensors []models.Sensor
+	if err := db.Select("client_id, sensor_id, sensor_name").Find(&sensors).Error; err != nil {
+		return nil, err
+	}
+	for _, sensor := range sensors {
+		names.sensors[[2]string{sensor.ClientID, sensor.SensorID}] = sensor.SensorName
+	}
+	return names, nil
+}
+
+// record returns the CSV row of an alert, with its duration up to now while
+// it is active
+func (n *alertNames) record(alert *models.Alert, loc *time.Location, now time.Time) []string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.In(loc).Format(time.RFC3339)
+	}
+	seconds := func(from time.Time, to *time.Time) string {
+		if to == nil {
+			return ""
+		}
+		return strconv.FormatInt(int64(to.Sub(from)/time.Second), 10)
+	}
+	end := alert.ResolvedAt
+	if end == nil && alert.IsActive {
+		end = &now
+	}
+	return []string{
+		alert.AlertID,
+		alert.RuleID,
+		n.rules[alert.RuleID],
+		alert.ClientID,
+		n.hostnames[alert.ClientID],
+		alert.SensorID,
+		n.sensors[[2]string{alert.ClientID, alert.SensorID}],
+		alert.Severity,
+		strconv.FormatFloat(alert.Value, 'f', 2, 64),
+		alert.Message,
+		formatTime(&alert.TriggeredAt),
+		formatTime(alert.ResolvedAt),
+		seconds(alert.TriggeredAt, end),
+		formatTime(alert.AcknowledgedAt),
+		alert.AcknowledgedBy,
+		seconds(alert.TriggeredAt, alert.AcknowledgedAt),
+		fmt.Sprint(alert.IsActive),
+		fmt.Sprint(alert.Muted),
+		fmt.Sprint(alert.Flapping),
+		alert.ExternalID,
+	}
+}
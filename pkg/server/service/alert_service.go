@@ -4,24 +4,57 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"slices"
+	"time"
 
 	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
 	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertevents"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertlog"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/bus"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
 	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/mute"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 	"github.com/google/uuid"
 )
 
+// minClientOfflineSeconds is the shortest silence a client offline rule can
+// alert on
+const minClientOfflineSeconds = 60
+
+// AlertServiceConfig holds optional integrations for alert events
+type AlertServiceConfig struct {
+	Bus *bus.Bridge // Message bus that alert events are exported to; nil disables export
+
+	// Hub that alert events are published to for streaming subscribers; nil
+	// creates one local to this service
+	Events *events.Hub
+}
+
 type AlertService struct {
 	jacuzziv1.UnimplementedAlertServiceServer
-	db *gorm.DB
+	db     *gorm.DB
+	cfg    AlertServiceConfig
+	events *events.Hub
 }
 
-func NewAlertService(db *gorm.DB) *AlertService {
-	return &AlertService{db: db}
+func NewAlertService(db *gorm.DB, cfg AlertServiceConfig) *AlertService {
+	hub := cfg.Events
+	if hub == nil {
+		hub = events.NewHub(nil)
+	}
+	return &AlertService{db: db, cfg: cfg, events: hub}
 }
 
 func (s *AlertService) CreateAlertRule(ctx context.Context, req *alertv1.CreateAlertRuleRequest) (*alertv1.CreateAlertRuleResponse, error) {
@@ -37,27 +70,183 @@ func (s *AlertService) CreateAlertRule(ctx context.Context, req *alertv1.CreateA
 		return nil, status.Error(codes.InvalidArgument, "rule condition is required")
 	}
 	
-	// Generate a new rule ID
-	ruleID := uuid.New().String()
+	// Unknown enum values would be stored as numbers no evaluator matches
+	if _, ok := alertv1.AlertCondition_Type_name[int32(rule.Condition.Type)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown condition type %d", rule.Condition.Type)
+	}
+	if _, ok := alertv1.AlertCondition_Operator_name[int32(rule.Condition.Operator)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown operator %d", rule.Condition.Operator)
+	}
+	if _, ok := alertv1.AlertCondition_Aggregate_name[int32(rule.Condition.Aggregate)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown aggregate %d", rule.Condition.Aggregate)
+	}
+	if _, ok := temperaturev1.DutyCyclePeriod_name[int32(rule.Condition.Period)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown period %d", rule.Condition.Period)
+	}
+	if _, ok := alertv1.Severity_name[int32(rule.Severity)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown severity %d", rule.Severity)
+	}
+	
+	// Update the existing rule when an ID is provided, otherwise generate one
+	ruleID := rule.Id
+	var existing models.AlertRule
+	if ruleID != "" {
+		if err := s.db.WithContext(ctx).Where("rule_id = ?", ruleID).First(&existing).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, status.Error(codes.NotFound, "alert rule not found")
+			}
+			return nil, apierror.Wrap(err, "failed to get alert rule")
+		}
+	} else {
+		ruleID = uuid.New().String()
+	}
+	
+	// Default to a threshold condition when the type is not specified
+	conditionType := rule.Condition.Type
+	if conditionType == alertv1.AlertCondition_TYPE_UNSPECIFIED {
+		conditionType = alertv1.AlertCondition_TYPE_THRESHOLD
+	}
+	
+	// Default to warning severity when not specified
+	severity := rule.Severity
+	if severity == alertv1.Severity_SEVERITY_UNSPECIFIED {
+		severity = alertv1.Severity_SEVERITY_WARNING
+	}
+	
+	// External rules stand for alerts raised elsewhere, so they only come
+	// from the alert receiver and keep their type
+	existingExternal := existing.ConditionType == models.ConditionTypeExternal
+	if conditionType == alertv1.AlertCondition_TYPE_EXTERNAL && !existingExternal {
+		return nil, status.Error(codes.InvalidArgument, "external rules are created by the alert receiver")
+	}
+	if existingExternal && conditionType != alertv1.AlertCondition_TYPE_EXTERNAL {
+		return nil, status.Error(codes.InvalidArgument, "the condition type of external rules cannot change")
+	}
+	if existingExternal && len(rule.Actions) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "external rules cannot have actions; their sender notifies")
+	}
+	
+	// Client offline rules fire on silence, so a shorter duration would alert
+	// between the reports of a healthy client
+	if conditionType == alertv1.AlertCondition_TYPE_CLIENT_OFFLINE && rule.Condition.DurationSeconds < minClientOfflineSeconds {
+		return nil, status.Errorf(codes.InvalidArgument, "client offline rules need a duration of at least %d seconds", minClientOfflineSeconds)
+	}
+	
+	// Quality scores run from 0 to 100, so other thresholds never or always fire
+	if conditionType == alertv1.AlertCondition_TYPE_SENSOR_QUALITY && (rule.Condition.Threshold <= 0 || rule.Condition.Threshold > 100) {
+		return nil, status.Error(codes.InvalidArgument, "sensor quality rules need a threshold above 0 and at most 100")
+	}
+	
+	// A budget longer than its period could never be spent
+	period := ""
+	if conditionType == alertv1.AlertCondition_TYPE_THERMAL_BUDGET {
+		period = models.DutyCyclePeriodDay
+		length := int32(24 * time.Hour / time.Second)
+		if rule.Condition.Period == temperaturev1.DutyCyclePeriod_DUTY_CYCLE_PERIOD_WEEK {
+			period = models.DutyCyclePeriodWeek
+			length *= 7
+		}
+		if rule.Condition.DurationSeconds <= 0 || rule.Condition.DurationSeconds >= length {
+			return nil, status.Errorf(codes.InvalidArgument, "thermal budget rules need a duration above 0 and below the %d seconds of their period", length)
+		}
+	}
+	
+	windows, err := protoToModelWindows(conditionType, rule.Condition.Windows)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Only the evaluator of threshold and aggregate rules knows the clients
+	// behind each reading
+	measured := conditionType == alertv1.AlertCondition_TYPE_THRESHOLD || conditionType == alertv1.AlertCondition_TYPE_AGGREGATE
+	if !measured && (rule.Site != "" || len(rule.MetadataSelector) > 0) {
+		return nil, status.Error(codes.InvalidArgument, "only threshold and aggregate rules can select clients by site or metadata")
+	}
+	metadataSelector := ""
+	if len(rule.MetadataSelector) > 0 {
+		data, err := json.Marshal(rule.MetadataSelector)
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to encode metadata selector")
+		}
+		metadataSelector = string(data)
+	}
+	aggregate := ""
+	if conditionType == alertv1.AlertCondition_TYPE_AGGREGATE {
+		aggregate = rule.Condition.Aggregate.String()
+		if rule.Condition.Aggregate == alertv1.AlertCondition_AGGREGATE_UNSPECIFIED {
+			aggregate = models.AggregateAverage
+		}
+	}
+	
+	// A clear point lies back from the threshold, so it needs a direction
+	var clearThreshold *float64
+	var clearPercent float64
+	if rule.Condition.Clear != nil {
+		if !measured {
+			return nil, status.Error(codes.InvalidArgument, "only threshold and aggregate rules can have a clear point")
+		}
+		operator := rule.Condition.Operator
+		if operator != alertv1.AlertCondition_OPERATOR_GREATER_THAN && operator != alertv1.AlertCondition_OPERATOR_LESS_THAN {
+			return nil, status.Error(codes.InvalidArgument, "a clear point needs a greater than or less than operator")
+		}
+		switch clear := rule.Condition.Clear.(type) {
+		case *alertv1.AlertCondition_ClearThreshold:
+			if operator == alertv1.AlertCondition_OPERATOR_GREATER_THAN && clear.ClearThreshold > rule.Condition.Threshold {
+				return nil, status.Error(codes.InvalidArgument, "clear_threshold must not be above the threshold of a greater than rule")
+			}
+			if operator == alertv1.AlertCondition_OPERATOR_LESS_THAN && clear.ClearThreshold < rule.Condition.Threshold {
+				return nil, status.Error(codes.InvalidArgument, "clear_threshold must not be below the threshold of a less than rule")
+			}
+			clearThreshold = &clear.ClearThreshold
+		case *alertv1.AlertCondition_ClearPercent:
+			if clear.ClearPercent <= 0 || clear.ClearPercent >= 100 {
+				return nil, status.Error(codes.InvalidArgument, "clear_percent must be between 0 and 100")
+			}
+			clearPercent = clear.ClearPercent
+		}
+	}
+	
+	if err := validateAlertActions(conditionType, severity, rule.Actions); err != nil {
+		return nil, err
+	}
 	
 	// Create the alert rule
 	alertRule := &models.AlertRule{
+		ID:              existing.ID,
 		RuleID:          ruleID,
 		Name:            rule.Name,
 		Description:     rule.Description,
 		ClientID:        rule.ClientId,
 		SensorID:        rule.SensorId,
-		SensorType:      rule.SensorType,
+		SensorType:      sensortype.Filter(rule.SensorType),
+		Site:            rule.Site,
+		MetadataSelector: metadataSelector,
+		ConditionType:   conditionType.String(),
+		Aggregate:       aggregate,
+		Period:          period,
 		Operator:        rule.Condition.Operator.String(),
 		Threshold:       rule.Condition.Threshold,
 		DurationSeconds: rule.Condition.DurationSeconds,
+		ClearThreshold:  clearThreshold,
+		ClearPercent:    clearPercent,
+		Windows:         windows,
+		Severity:        severity.String(),
 		Enabled:         rule.Enabled,
+		SnoozedUntil:    existing.SnoozedUntil,
+		CreatedAt:       existing.CreatedAt,
 	}
 	
 	// Start transaction
-	err := s.db.Transaction(func(tx *gorm.DB) error {
-		// Create the rule
-		if err := tx.Create(alertRule).Error; err != nil {
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if existing.ID != 0 {
+			// Update the rule and replace its actions
+			if err := tx.Save(alertRule).Error; err != nil {
+				return fmt.Errorf("failed to update alert rule: %w", err)
+			}
+			if err := tx.Where("rule_id = ?", ruleID).Delete(&models.AlertAction{}).Error; err != nil {
+				return fmt.Errorf("failed to replace alert actions: %w", err)
+			}
+		} else if err := tx.Create(alertRule).Error; err != nil {
 			return fmt.Errorf("failed to create alert rule: %w", err)
 		}
 		
@@ -83,18 +272,23 @@ func (s *AlertService) CreateAlertRule(ctx context.Context, req *alertv1.CreateA
 	})
 	
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create alert rule: %v", err)
+		return nil, apierror.Wrap(err, "failed to create alert rule")
+	}
+	
+	message := "Alert rule created successfully"
+	if existing.ID != 0 {
+		message = "Alert rule updated successfully"
 	}
 	
 	return &alertv1.CreateAlertRuleResponse{
 		RuleId:  ruleID,
 		Success: true,
-		Message: "Alert rule created successfully",
+		Message: message,
 	}, nil
 }
 
 func (s *AlertService) ListAlertRules(ctx context.Context, req *alertv1.ListAlertRulesRequest) (*alertv1.ListAlertRulesResponse, error) {
-	query := s.db.Model(&models.AlertRule{}).Preload("Actions")
+	query := s.db.WithContext(ctx).Model(&models.AlertRule{}).Preload("Actions")
 	
 	if req.ClientId != "" {
 		query = query.Where("client_id = ?", req.ClientId)
@@ -106,7 +300,7 @@ func (s *AlertService) ListAlertRules(ctx context.Context, req *alertv1.ListAler
 	// Get total count
 	var totalCount int64
 	if err := query.Count(&totalCount).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to count alert rules: %v", err)
+		return nil, apierror.Wrap(err, "failed to count alert rules")
 	}
 	
 	// Apply pagination
@@ -118,7 +312,7 @@ func (s *AlertService) ListAlertRules(ctx context.Context, req *alertv1.ListAler
 	
 	var rules []models.AlertRule
 	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&rules).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to list alert rules: %v", err)
+		return nil, apierror.Wrap(err, "failed to list alert rules")
 	}
 	
 	// Convert to proto
@@ -126,7 +320,7 @@ func (s *AlertService) ListAlertRules(ctx context.Context, req *alertv1.ListAler
 	for i, rule := range rules {
 		protoRule, err := s.modelToProtoAlertRule(&rule)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to convert alert rule: %v", err)
+			return nil, apierror.Wrap(err, "failed to convert alert rule")
 		}
 		protoRules[i] = protoRule
 	}
@@ -142,19 +336,22 @@ func (s *AlertService) DeleteAlertRule(ctx context.Context, req *alertv1.DeleteA
 		return nil, status.Error(codes.InvalidArgument, "rule_id is required")
 	}
 	
-	// Delete the rule and its actions (cascade delete)
-	result := s.db.Where("rule_id = ?", req.RuleId).Delete(&models.AlertRule{})
-	if result.Error != nil {
-		return nil, status.Errorf(codes.Internal, "failed to delete alert rule: %v", result.Error)
-	}
-	
-	if result.RowsAffected == 0 {
-		return nil, status.Error(codes.NotFound, "alert rule not found")
+	// The rule is soft deleted so its alerts keep referring to it, and its
+	// actions go with it
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("rule_id = ?", req.RuleId).Delete(&models.AlertRule{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return status.Error(codes.NotFound, "alert rule not found")
+		}
+		return tx.Where("rule_id = ?", req.RuleId).Delete(&models.AlertAction{}).Error
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to delete alert rule")
 	}
 	
-	// Also delete associated actions
-	s.db.Where("rule_id = ?", req.RuleId).Delete(&models.AlertAction{})
-	
 	return &alertv1.DeleteAlertRuleResponse{
 		Success: true,
 		Message: "Alert rule deleted successfully",
@@ -162,22 +359,42 @@ func (s *AlertService) DeleteAlertRule(ctx context.Context, req *alertv1.DeleteA
 }
 
 func (s *AlertService) GetAlertHistory(ctx context.Context, req *alertv1.GetAlertHistoryRequest) (*alertv1.GetAlertHistoryResponse, error) {
-	query := s.db.Model(&models.Alert{})
+	if req.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must not be negative")
+	}
+	query, err := alertFilter{
+		ruleID:     req.RuleId,
+		clientID:   req.ClientId,
+		activeOnly: req.ActiveOnly,
+		start:      req.StartTime,
+		end:        req.EndTime,
+		severity:   req.Severity,
+	}.query(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
 	
-	if req.RuleId != "" {
-		query = query.Where("rule_id = ?", req.RuleId)
+	var totalCount int64
+	if err := query.Session(&gorm.Session{}).Count(&totalCount).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to count alert history")
 	}
-	if req.ClientId != "" {
-		query = query.Where("client_id = ?", req.ClientId)
+	
+	// Facets let filters show their counts without a query each
+	ruleCounts, err := countAlertsBy(query, "rule_id")
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to count alert history by rule")
 	}
-	if req.ActiveOnly {
-		query = query.Where("is_active = ?", true)
+	clientCounts, err := countAlertsBy(query, "client_id")
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to count alert history by client")
 	}
-	if req.StartTime != nil {
-		query = query.Where("triggered_at >= ?", req.StartTime.AsTime())
+	storedSeverityCounts, err := countAlertsBy(query, "severity")
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to count alert history by severity")
 	}
-	if req.EndTime != nil {
-		query = query.Where("triggered_at <= ?", req.EndTime.AsTime())
+	severityCounts := make(map[string]int32, len(storedSeverityCounts))
+	for severity, count := range storedSeverityCounts {
+		severityCounts[alertevents.ParseSeverity(severity).String()] += count
 	}
 	
 	limit := int(req.Limit)
@@ -186,34 +403,343 @@ func (s *AlertService) GetAlertHistory(ctx context.Context, req *alertv1.GetAler
 	}
 	
 	var alerts []models.Alert
-	if err := query.Order("triggered_at DESC").Limit(limit).Find(&alerts).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get alert history: %v", err)
+	if err := query.Order("triggered_at DESC").Order("id DESC").Limit(limit).Offset(int(req.Offset)).Find(&alerts).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to get alert history")
 	}
 	
 	// Convert to proto
 	protoAlerts := make([]*alertv1.Alert, len(alerts))
 	for i, alert := range alerts {
-		protoAlert := &alertv1.Alert{
-			Id:          alert.AlertID,
-			RuleId:      alert.RuleID,
-			ClientId:    alert.ClientID,
-			SensorId:    alert.SensorID,
-			Value:       alert.Value,
-			TriggeredAt: timestamppb.New(alert.TriggeredAt),
-			IsActive:    alert.IsActive,
-			Message:     alert.Message,
+		protoAlerts[i] = s.modelToProtoAlert(&alert)
+	}
+	
+	return &alertv1.GetAlertHistoryResponse{
+		Alerts:         protoAlerts,
+		TotalCount:     totalCount,
+		RuleCounts:     ruleCounts,
+		ClientCounts:   clientCounts,
+		SeverityCounts: severityCounts,
+	}, nil
+}
+
+// alertFilter selects alerts of the history
+type alertFilter struct {
+	ruleID, clientID string
+	activeOnly       bool
+	start, end       *timestamppb.Timestamp // Of when alerts triggered
+	severity         alertv1.Severity
+}
+
+// query returns a query of the alerts the filter selects
+func (f alertFilter) query(db *gorm.DB) (*gorm.DB, error) {
+	if _, ok := alertv1.Severity_name[int32(f.severity)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown severity %d", f.severity)
+	}
+	query := db.Model(&models.Alert{})
+	if f.ruleID != "" {
+		query = query.Where("rule_id = ?", f.ruleID)
+	}
+	if f.clientID != "" {
+		query = query.Where("client_id = ?", f.clientID)
+	}
+	if f.activeOnly {
+		query = query.Where("is_active = ?", true)
+	}
+	if f.start != nil {
+		query = query.Where("triggered_at >= ?", f.start.AsTime())
+	}
+	if f.end != nil {
+		query = query.Where("triggered_at <= ?", f.end.AsTime())
+	}
+	if f.severity != alertv1.Severity_SEVERITY_UNSPECIFIED {
+		query = query.Where("severity = ?", f.severity.String())
+	}
+	return query, nil
+}
+
+// countAlertsBy counts the alerts a query matches by the values of a column
+func countAlertsBy(query *gorm.DB, column string) (map[string]int32, error) {
+	var rows []struct {
+		Value string
+		Count int32
+	}
+	err := query.Session(&gorm.Session{}).Select(column + " AS value, COUNT(*) AS count").Group(column).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int32, len(rows))
+	for _, row := range rows {
+		counts[row.Value] = row.Count
+	}
+	return counts, nil
+}
+
+func (s *AlertService) GetActiveAlerts(ctx context.Context, req *alertv1.GetActiveAlertsRequest) (*alertv1.GetActiveAlertsResponse, error) {
+	query := s.db.WithContext(ctx).Model(&models.Alert{}).Where("is_active = ?", true)
+	if req.ClientId != "" {
+		query = query.Where("client_id = ?", req.ClientId)
+	}
+	
+	// Count active alerts per severity
+	var severityRows []struct {
+		Severity string
+		Count    int32
+	}
+	if err := query.Session(&gorm.Session{}).Select("severity, COUNT(*) as count").Group("severity").Scan(&severityRows).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to count active alerts by severity")
+	}
+	
+	var totalCount int32
+	severityCounts := make(map[string]int32)
+	for _, row := range severityRows {
+		severityCounts[alertevents.ParseSeverity(row.Severity).String()] += row.Count
+		totalCount += row.Count
+	}
+	
+	// Count active alerts per client
+	var clientRows []struct {
+		ClientID string
+		Count    int32
+	}
+	if err := query.Session(&gorm.Session{}).Select("client_id, COUNT(*) as count").Group("client_id").Scan(&clientRows).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to count active alerts by client")
+	}
+	
+	clientCounts := make(map[string]int32, len(clientRows))
+	for _, row := range clientRows {
+		clientCounts[row.ClientID] = row.Count
+	}
+	
+	// Fetch the newest active alerts
+	limit := int(req.Limit)
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+	
+	var alerts []models.Alert
+	if err := query.Session(&gorm.Session{}).Order("triggered_at DESC").Limit(limit).Find(&alerts).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to get active alerts")
+	}
+	
+	protoAlerts := make([]*alertv1.Alert, len(alerts))
+	for i, alert := range alerts {
+		protoAlerts[i] = s.modelToProtoAlert(&alert)
+	}
+	
+	return &alertv1.GetActiveAlertsResponse{
+		TotalCount:     totalCount,
+		SeverityCounts: severityCounts,
+		ClientCounts:   clientCounts,
+		Alerts:         protoAlerts,
+	}, nil
+}
+
+func (s *AlertService) AcknowledgeAlert(ctx context.Context, req *alertv1.AcknowledgeAlertRequest) (*alertv1.AcknowledgeAlertResponse, error) {
+	if req.AlertId == "" {
+		return nil, status.Error(codes.InvalidArgument, "alert_id is required")
+	}
+	
+	now := time.Now()
+	result := s.db.WithContext(ctx).Model(&models.Alert{}).
+		Where("alert_id = ?", req.AlertId).
+		Updates(map[string]interface{}{
+			"acknowledged_at": now,
+			"acknowledged_by": req.AcknowledgedBy,
+		})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to acknowledge alert")
+	}
+	
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "alert not found")
+	}
+
+	var alert models.Alert
+	if err := s.db.WithContext(ctx).Where("alert_id = ?", req.AlertId).First(&alert).Error; err == nil {
+		var details map[string]string
+		if req.AcknowledgedBy != "" {
+			details = map[string]string{"acknowledged_by": req.AcknowledgedBy}
 		}
-		if alert.ResolvedAt != nil {
-			protoAlert.ResolvedAt = timestamppb.New(*alert.ResolvedAt)
+		alertevents.Publish(s.db.WithContext(ctx), s.events, activity.AlertAcknowledged, &alert, details)
+		if s.cfg.Bus != nil {
+			s.cfg.Bus.PublishAlert(bus.AlertAcknowledged, s.modelToProtoAlert(&alert))
 		}
-		protoAlerts[i] = protoAlert
 	}
 	
-	return &alertv1.GetAlertHistoryResponse{
-		Alerts: protoAlerts,
+	return &alertv1.AcknowledgeAlertResponse{
+		Success: true,
+		Message: "Alert acknowledged successfully",
 	}, nil
 }
 
+func (s *AlertService) GetAlertContext(ctx context.Context, req *alertv1.GetAlertContextRequest) (*alertv1.GetAlertContextResponse, error) {
+	if req.AlertId == "" {
+		return nil, status.Error(codes.InvalidArgument, "alert_id is required")
+	}
+
+	var alert models.Alert
+	if err := s.db.WithContext(ctx).Where("alert_id = ?", req.AlertId).First(&alert).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "alert not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get alert")
+	}
+	resp := &alertv1.GetAlertContextResponse{
+		Alert:    s.modelToProtoAlert(&alert),
+		Complete: alert.ContextComplete,
+	}
+	if alert.ContextStart == nil || alert.ContextEnd == nil {
+		return resp, nil
+	}
+	resp.WindowStart = timestamppb.New(*alert.ContextStart)
+	resp.WindowEnd = timestamppb.New(*alert.ContextEnd)
+
+	var readings []models.AlertContextReading
+	if err := s.db.WithContext(ctx).Where("alert_id = ?", alert.AlertID).Order("reading_at, id").Find(&readings).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to get alert context")
+	}
+	resp.Readings = make([]*temperaturev1.TemperatureReading, len(readings))
+	for i := range readings {
+		resp.Readings[i] = modelToProtoContextReading(alert.ClientID, &readings[i])
+	}
+	return resp, nil
+}
+
+func (s *AlertService) MuteNotifications(ctx context.Context, req *alertv1.MuteNotificationsRequest) (*alertv1.MuteNotificationsResponse, error) {
+	duration, err := muteDuration(req.DurationSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	var global mute.Global
+	if duration > 0 {
+		global = mute.Global{Until: time.Now().Add(duration), Reason: req.Reason}
+	}
+	if err := mute.Set(s.db.WithContext(ctx), global); err != nil {
+		return nil, apierror.Wrap(err, "failed to mute notifications")
+	}
+
+	resp := &alertv1.MuteNotificationsResponse{}
+	if duration > 0 {
+		log.Printf("Notifications muted until %s: %s", global.Until.Format(time.RFC3339), req.Reason)
+		resp.MutedUntil = timestamppb.New(global.Until)
+	} else {
+		log.Printf("Notifications unmuted")
+	}
+	return resp, nil
+}
+
+func (s *AlertService) SnoozeAlertRule(ctx context.Context, req *alertv1.SnoozeAlertRuleRequest) (*alertv1.SnoozeAlertRuleResponse, error) {
+	if req.RuleId == "" {
+		return nil, status.Error(codes.InvalidArgument, "rule_id is required")
+	}
+	duration, err := muteDuration(req.DurationSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	var until *time.Time
+	if duration > 0 {
+		t := time.Now().Add(duration)
+		until = &t
+	}
+	result := s.db.WithContext(ctx).Model(&models.AlertRule{}).
+		Where("rule_id = ?", req.RuleId).
+		UpdateColumn("snoozed_until", until)
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to snooze alert rule")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "alert rule not found")
+	}
+
+	resp := &alertv1.SnoozeAlertRuleResponse{}
+	if until != nil {
+		log.Printf("Alert rule %s snoozed until %s", req.RuleId, until.Format(time.RFC3339))
+		resp.SnoozedUntil = timestamppb.New(*until)
+	} else {
+		log.Printf("Alert rule %s unsnoozed", req.RuleId)
+	}
+	return resp, nil
+}
+
+func (s *AlertService) StreamAlerts(req *alertv1.StreamAlertsRequest, stream jacuzziv1.AlertService_StreamAlertsServer) error {
+	sub := s.events.Subscribe(events.TopicAlerts, 64)
+	defer sub.Close()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			var event alertv1.AlertEvent
+			if err := proto.Unmarshal(data, &event); err != nil {
+				log.Printf("Failed to decode published alert event: %v", err)
+				continue
+			}
+			alert := event.Alert
+			if alert == nil {
+				continue
+			}
+			if req.ClientId != "" && alert.ClientId != req.ClientId {
+				continue
+			}
+			if req.RuleId != "" && alert.RuleId != req.RuleId {
+				continue
+			}
+			if len(req.Types) > 0 && !slices.Contains(req.Types, event.Type) {
+				continue
+			}
+			if alert.Severity < req.MinSeverity {
+				continue
+			}
+			if err := stream.Send(&event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// muteDuration validates the duration of a mute or snooze, where 0 ends it
+func muteDuration(seconds int64) (time.Duration, error) {
+	duration := time.Duration(seconds) * time.Second
+	if seconds < 0 || duration > mute.MaxDuration {
+		return 0, status.Errorf(codes.InvalidArgument, "duration_seconds must be between 0 and %d", int64(mute.MaxDuration/time.Second))
+	}
+	return duration, nil
+}
+
+// Helper function to convert alert model to proto
+func (s *AlertService) modelToProtoAlert(alert *models.Alert) *alertv1.Alert {
+	return alertevents.ToProto(alert)
+}
+
+// Helper function to convert alert context reading model to proto
+func modelToProtoContextReading(clientID string, reading *models.AlertContextReading) *temperaturev1.TemperatureReading {
+	protoReading := &temperaturev1.TemperatureReading{
+		SensorId:           reading.SensorID,
+		ClientId:           clientID,
+		TemperatureCelsius: reading.TemperatureCelsius,
+		Timestamp:          timestamppb.New(reading.ReadingAt),
+		SensorType:         reading.SensorType,
+		SensorName:         reading.SensorName,
+		Burst:              reading.Burst,
+	}
+	if reading.MinCelsius != nil && reading.MaxCelsius != nil && reading.AvgCelsius != nil {
+		protoReading.Summary = &temperaturev1.ReadingSummary{
+			MinCelsius:  *reading.MinCelsius,
+			MaxCelsius:  *reading.MaxCelsius,
+			AvgCelsius:  *reading.AvgCelsius,
+			SampleCount: reading.SampleCount,
+		}
+	}
+	return protoReading
+}
+
 // Helper function to convert model to proto
 func (s *AlertService) modelToProtoAlertRule(rule *models.AlertRule) (*alertv1.AlertRule, error) {
 	// Parse operator
@@ -228,6 +754,36 @@ func (s *AlertService) modelToProtoAlertRule(rule *models.AlertRule) (*alertv1.A
 	case "OPERATOR_NOT_EQUAL":
 		operator = alertv1.AlertCondition_OPERATOR_NOT_EQUAL
 	}
+	conditionType := alertv1.AlertCondition_TYPE_THRESHOLD
+	switch rule.ConditionType {
+	case models.ConditionTypeStaleSensor:
+		conditionType = alertv1.AlertCondition_TYPE_STALE_SENSOR
+	case models.ConditionTypeClientOffline:
+		conditionType = alertv1.AlertCondition_TYPE_CLIENT_OFFLINE
+	case models.ConditionTypeAggregate:
+		conditionType = alertv1.AlertCondition_TYPE_AGGREGATE
+	case models.ConditionTypeSensorQuality:
+		conditionType = alertv1.AlertCondition_TYPE_SENSOR_QUALITY
+	case models.ConditionTypeExternal:
+		conditionType = alertv1.AlertCondition_TYPE_EXTERNAL
+	case models.ConditionTypeThermalBudget:
+		conditionType = alertv1.AlertCondition_TYPE_THERMAL_BUDGET
+	}
+	aggregate := alertv1.AlertCondition_AGGREGATE_UNSPECIFIED
+	switch rule.Aggregate {
+	case models.AggregateAverage:
+		aggregate = alertv1.AlertCondition_AGGREGATE_AVERAGE
+	case models.AggregateMax:
+		aggregate = alertv1.AlertCondition_AGGREGATE_MAX
+	case models.AggregateMin:
+		aggregate = alertv1.AlertCondition_AGGREGATE_MIN
+	}
+	var metadataSelector map[string]string
+	if rule.MetadataSelector != "" {
+		if err := json.Unmarshal([]byte(rule.MetadataSelector), &metadataSelector); err != nil {
+			return nil, fmt.Errorf("invalid metadata selector of rule %s: %w", rule.RuleID, err)
+		}
+	}
 	
 	// Convert actions
 	protoActions := make([]*alertv1.AlertAction, len(rule.Actions))
@@ -240,6 +796,12 @@ func (s *AlertService) modelToProtoAlertRule(rule *models.AlertRule) (*alertv1.A
 			actionType = alertv1.AlertAction_ACTION_TYPE_WEBHOOK
 		case "ACTION_TYPE_LOG":
 			actionType = alertv1.AlertAction_ACTION_TYPE_LOG
+		case commands.ActionTypeEmergency:
+			actionType = alertv1.AlertAction_ACTION_TYPE_EMERGENCY
+		case alertlog.ActionTypeSyslog:
+			actionType = alertv1.AlertAction_ACTION_TYPE_SYSLOG
+		case alertlog.ActionTypeEventLog:
+			actionType = alertv1.AlertAction_ACTION_TYPE_EVENT_LOG
 		}
 		
 		config := make(map[string]string)
@@ -256,21 +818,43 @@ func (s *AlertService) modelToProtoAlertRule(rule *models.AlertRule) (*alertv1.A
 		}
 	}
 	
-	return &alertv1.AlertRule{
+	windows, err := modelToProtoWindows(rule)
+	if err != nil {
+		return nil, err
+	}
+	
+	protoRule := &alertv1.AlertRule{
 		Id:          rule.RuleID,
 		Name:        rule.Name,
 		Description: rule.Description,
 		ClientId:    rule.ClientID,
 		SensorId:    rule.SensorID,
 		SensorType:  rule.SensorType,
+		MetadataSelector: metadataSelector,
+		Site:        rule.Site,
 		Condition: &alertv1.AlertCondition{
 			Operator:        operator,
 			Threshold:       rule.Threshold,
 			DurationSeconds: rule.DurationSeconds,
+			Type:            conditionType,
+			Windows:         windows,
+			Aggregate:       aggregate,
+			Period:          temperaturev1.DutyCyclePeriod(temperaturev1.DutyCyclePeriod_value[rule.Period]),
 		},
 		Actions:   protoActions,
 		Enabled:   rule.Enabled,
+		Severity:  alertevents.ParseSeverity(rule.Severity),
 		CreatedAt: timestamppb.New(rule.CreatedAt),
 		UpdatedAt: timestamppb.New(rule.UpdatedAt),
-	}, nil
+	}
+	switch {
+	case rule.ClearThreshold != nil:
+		protoRule.Condition.Clear = &alertv1.AlertCondition_ClearThreshold{ClearThreshold: *rule.ClearThreshold}
+	case rule.ClearPercent > 0:
+		protoRule.Condition.Clear = &alertv1.AlertCondition_ClearPercent{ClearPercent: rule.ClearPercent}
+	}
+	if rule.SnoozedUntil != nil && time.Now().Before(*rule.SnoozedUntil) {
+		protoRule.SnoozedUntil = timestamppb.New(*rule.SnoozedUntil)
+	}
+	return protoRule, nil
 }
\ No newline at end of file
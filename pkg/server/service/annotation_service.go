@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	annotationv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/annotation/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Limits on annotation content
+const (
+	maxAnnotationText = 4096
+	maxAnnotationTags = 20
+	maxAnnotationTag  = 64
+)
+
+type AnnotationService struct {
+	jacuzziv1.UnimplementedAnnotationServiceServer
+	db *gorm.DB
+}
+
+func NewAnnotationService(db *gorm.DB) *AnnotationService {
+	return &AnnotationService{db: db}
+}
+
+func (s *AnnotationService) CreateAnnotation(ctx context.Context, req *annotationv1.CreateAnnotationRequest) (*annotationv1.CreateAnnotationResponse, error) {
+	a := req.Annotation
+	if a == nil {
+		return nil, status.Error(codes.InvalidArgument, "annotation is required")
+	}
+	text := strings.TrimSpace(a.Text)
+	if text == "" {
+		return nil, status.Error(codes.InvalidArgument, "annotation text is required")
+	}
+	if len(text) > maxAnnotationText {
+		return nil, status.Errorf(codes.InvalidArgument, "annotation text must be at most %d bytes", maxAnnotationText)
+	}
+	if len(a.Tags) > maxAnnotationTags {
+		return nil, status.Errorf(codes.InvalidArgument, "an annotation can have at most %d tags", maxAnnotationTags)
+	}
+	for _, tag := range a.Tags {
+		if tag == "" || len(tag) > maxAnnotationTag {
+			return nil, status.Errorf(codes.InvalidArgument, "tags must be 1 to %d bytes long", maxAnnotationTag)
+		}
+	}
+
+	at := time.Now()
+	if a.Time != nil {
+		at = a.Time.AsTime()
+	}
+	var endTime *time.Time
+	if a.EndTime != nil {
+		end := a.EndTime.AsTime()
+		if end.Before(at) {
+			return nil, status.Error(codes.InvalidArgument, "end_time must not be before time")
+		}
+		endTime = &end
+	}
+
+	db := s.db.WithContext(ctx)
+	if a.ClientId != "" {
+		var count int64
+		if err := db.Model(&models.Client{}).Where("client_id = ?", a.ClientId).Count(&count).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to check client")
+		}
+		if count == 0 {
+			return nil, status.Errorf(codes.NotFound, "client %s not found", a.ClientId)
+		}
+	}
+
+	annotation := &models.Annotation{
+		AnnotationID: uuid.New().String(),
+		ClientID:     a.ClientId,
+		Time:         at,
+		EndTime:      endTime,
+		Text:         text,
+		Author:       a.Author,
+	}
+	if len(a.Tags) > 0 {
+		tags, err := json.Marshal(a.Tags)
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to encode tags")
+		}
+		annotation.Tags = string(tags)
+	}
+	if err := db.Create(annotation).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create annotation")
+	}
+
+	return &annotationv1.CreateAnnotationResponse{Annotation: modelToProtoAnnotation(annotation)}, nil
+}
+
+func (s *AnnotationService) ListAnnotations(ctx context.Context, req *annotationv1.ListAnnotationsRequest) (*annotationv1.ListAnnotationsResponse, error) {
+	filter := annotationFilter{
+		clientID: req.ClientId,
+		tag:      req.Tag,
+		limit:    int(req.Limit),
+	}
+	if req.StartTime != nil {
+		filter.start = req.StartTime.AsTime()
+	}
+	if req.EndTime != nil {
+		filter.end = req.EndTime.AsTime()
+	}
+
+	annotations, err := listAnnotations(s.db.WithContext(ctx), filter)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to list annotations")
+	}
+	return &annotationv1.ListAnnotationsResponse{Annotations: annotations}, nil
+}
+
+func (s *AnnotationService) DeleteAnnotation(ctx context.Context, req *annotationv1.DeleteAnnotationRequest) (*annotationv1.DeleteAnnotationResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "annotation id is required")
+	}
+
+	result := s.db.WithContext(ctx).Where("annotation_id = ?", req.Id).Delete(&models.Annotation{})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to delete annotation")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "annotation not found")
+	}
+
+	return &annotationv1.DeleteAnnotationResponse{
+		Success: true,
+		Message: "Annotation deleted successfully",
+	}, nil
+}
+
+// annotationFilter selects annotations overlapping a time range. Zero times
+// leave that end of the range open.
+type annotationFilter struct {
+	clientID   string // Also matches global annotations
+	start, end time.Time
+	tag        string
+	limit      int
+}
+
+// listAnnotations returns matching annotations, newest first
+func listAnnotations(db *gorm.DB, filter annotationFilter) ([]*annotationv1.Annotation, error) {
+	query := db.Model(&models.Annotation{})
+	if filter.clientID != "" {
+		query = query.Where("client_id = ? OR client_id = ''", filter.clientID)
+	}
+	if !filter.start.IsZero() {
+		// Events spanning a period overlap the range if they end inside it
+		query = query.Where("time >= ? OR end_time >= ?", filter.start, filter.start)
+	}
+	if !filter.end.IsZero() {
+		query = query.Where("time <= ?", filter.end)
+	}
+	if filter.tag != "" {
+		tag, err := json.Marshal(filter.tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode tag: %w", err)
+		}
+		query = query.Where(`tags LIKE ? ESCAPE '\'`, "%"+escapeLike(string(tag))+"%")
+	}
+
+	limit := filter.limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var annotations []models.Annotation
+	if err := query.Order("time DESC").Limit(limit).Find(&annotations).Error; err != nil {
+		return nil, err
+	}
+
+	protoAnnotations := make([]*annotationv1.Annotation, len(annotations))
+	for i := range annotations {
+		protoAnnotations[i] = modelToProtoAnnotation(&annotations[i])
+	}
+	return protoAnnotations, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s, using \ as the escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func modelToProtoAnnotation(annotation *models.Annotation) *annotationv1.Annotation {
+	protoAnnotation := &annotationv1.Annotation{
+		Id:        annotation.AnnotationID,
+		ClientId:  annotation.ClientID,
+		Time:      timestamppb.New(annotation.Time),
+		Text:      annotation.Text,
+		Author:    annotation.Author,
+		CreatedAt: timestamppb.New(annotation.CreatedAt),
+	}
+	if annotation.EndTime != nil {
+		protoAnnotation.EndTime = timestamppb.New(*annotation.EndTime)
+	}
+	if annotation.Tags != "" {
+		// Tags are always written by CreateAnnotation, so a decode failure
+		// only loses the tags
+		json.Unmarshal([]byte(annotation.Tags), &protoAnnotation.Tags)
+	}
+	return protoAnnotation
+}
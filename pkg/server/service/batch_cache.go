@@ -0,0 +1,57 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// batchCache remembers recently submitted batch IDs so exact replays of a
+// request (e.g. a client retry after a timeout) can be discarded
+type batchCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]time.Time
+	pruned  time.Time // Last eviction of expired entries
+}
+
+func newBatchCache(ttl time.Duration) *batchCache {
+	return &batchCache{
+		ttl:     ttl,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Seen reports whether the batch ID was added within the TTL
+func (c *batchCache) Seen(batchID string) bool {
+	if c.ttl <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, ok := c.entries[batchID]
+	return ok && time.Now().Before(expires)
+}
+
+// Add records a batch ID, evicting expired entries at most once a minute
+func (c *batchCache) Add(batchID string) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// Evict expired entries now and then rather than on every submission
+	if now.Sub(c.pruned) > time.Minute {
+		for id, expires := range c.entries {
+			if now.After(expires) {
+				delete(c.entries, id)
+			}
+		}
+		c.pruned = now
+	}
+	c.entries[batchID] = now.Add(c.ttl)
+}
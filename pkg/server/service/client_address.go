@@ -0,0 +1,71 @@
+package service
+
+import (
+	"time"
+
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// recordAddress adds the address a client reported from to its address
+// history when it differs from the client's current address. The previous
+// address is closed at the client's last report.
+func recordAddress(tx *gorm.DB, client *models.Client, ip string, now time.Time) error {
+	if ip == "" {
+		return nil
+	}
+	var current int64
+	err := tx.Model(&models.ClientAddress{}).
+		Where("client_id = ? AND ip_address = ?", client.ClientID, client.IPAddress).
+		Count(&current).Error
+	if err != nil {
+		return err
+	}
+	if ip == client.IPAddress && current > 0 {
+		return nil
+	}
+	if ip != client.IPAddress && current > 0 {
+		err := tx.Model(&models.ClientAddress{}).
+			Where("client_id = ? AND ip_address = ?", client.ClientID, client.IPAddress).
+			UpdateColumn("last_seen", client.LastSeen).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// A client returning to an earlier address reopens its row
+	result := tx.Model(&models.ClientAddress{}).
+		Where("client_id = ? AND ip_address = ?", client.ClientID, ip).
+		UpdateColumns(map[string]interface{}{"first_seen": now, "last_seen": now})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	return tx.Create(&models.ClientAddress{
+		ClientID:  client.ClientID,
+		IPAddress: ip,
+		FirstSeen: now,
+		LastSeen:  now,
+	}).Error
+}
+
+// modelToProtoAddresses converts a client's address history, reporting the
+// current address as seen at the client's last report
+func modelToProtoAddresses(client *models.Client, addresses []models.ClientAddress) []*clientv1.ClientAddress {
+	result := make([]*clientv1.ClientAddress, len(addresses))
+	for i, address := range addresses {
+		current := address.IPAddress == client.IPAddress
+		lastSeen := address.LastSeen
+		if current {
+			lastSeen = client.LastSeen
+		}
+		result[i] = &clientv1.ClientAddress{
+			IpAddress: address.IPAddress,
+			FirstSeen: timestamppb.New(address.FirstSeen),
+			LastSeen:  timestamppb.New(lastSeen),
+			Current:   current,
+		}
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func (s *ClientService) SetClientLocation(ctx context.Context, req *clientv1.SetClientLocationRequest) (*clientv1.SetClientLocationResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	location := req.Location
+	if location == nil {
+		location = &clientv1.Location{}
+	}
+	if c := location.Coordinates; c != nil {
+		if c.Latitude < -90 || c.Latitude > 90 {
+			return nil, status.Error(codes.InvalidArgument, "latitude must be between -90 and 90")
+		}
+		if c.Longitude < -180 || c.Longitude > 180 {
+			return nil, status.Error(codes.InvalidArgument, "longitude must be between -180 and 180")
+		}
+	}
+
+	var client models.Client
+	if err := s.db.WithContext(ctx).Where("client_id = ?", req.ClientId).First(&client).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "client not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get client")
+	}
+
+	client.Site = strings.TrimSpace(location.Site)
+	client.Rack = strings.TrimSpace(location.Rack)
+	client.LocationDescription = strings.TrimSpace(location.Description)
+	client.Latitude, client.Longitude = nil, nil
+	if c := location.Coordinates; c != nil {
+		client.Latitude, client.Longitude = &c.Latitude, &c.Longitude
+	}
+	// Assigning a location is not activity, so last_seen and updated_at are
+	// left alone
+	err := s.db.WithContext(ctx).Model(&models.Client{}).Where("id = ?", client.ID).UpdateColumns(map[string]interface{}{
+		"site":                 client.Site,
+		"rack":                 client.Rack,
+		"latitude":             client.Latitude,
+		"longitude":            client.Longitude,
+		"location_description": client.LocationDescription,
+	}).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to update client location")
+	}
+	pushConfig(s.db.WithContext(ctx), &client)
+
+	protoClient, err := s.modelToProtoClient(&client)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert client")
+	}
+	return &clientv1.SetClientLocationResponse{Client: protoClient}, nil
+}
+
+// Helper function to convert a client's location to proto, nil when unassigned
+func modelToProtoLocation(client *models.Client) *clientv1.Location {
+	if client.Site == "" && client.Rack == "" && client.LocationDescription == "" && client.Latitude == nil {
+		return nil
+	}
+	location := &clientv1.Location{
+		Site:        client.Site,
+		Rack:        client.Rack,
+		Description: client.LocationDescription,
+	}
+	if client.Latitude != nil && client.Longitude != nil {
+		location.Coordinates = &clientv1.Coordinates{
+			Latitude:  *client.Latitude,
+			Longitude: *client.Longitude,
+		}
+	}
+	return location
+}
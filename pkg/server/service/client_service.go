@@ -3,37 +3,64 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
 	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/clientip"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
 	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/reassign"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 )
 
+// ClientServiceConfig controls how new clients are enrolled
+type ClientServiceConfig struct {
+	EnrollmentMode string        // open, approval, or token
+	OfflineAfter   time.Duration // Clients not seen for this long are reported offline
+
+	// Notified of registered clients and retired sensors; nil disables
+	Webhooks *webhook.Dispatcher
+
+	// Finds the address clients call from; nil ignores forwarding headers
+	ClientIP *clientip.Resolver
+}
+
 type ClientService struct {
 	jacuzziv1.UnimplementedClientServiceServer
-	db *gorm.DB
+	db  *gorm.DB
+	cfg ClientServiceConfig
 }
 
-func NewClientService(db *gorm.DB) *ClientService {
-	return &ClientService{db: db}
+func NewClientService(db *gorm.DB, cfg ClientServiceConfig) *ClientService {
+	return &ClientService{db: db, cfg: cfg}
 }
 
 func (s *ClientService) ListClients(ctx context.Context, req *clientv1.ListClientsRequest) (*clientv1.ListClientsResponse, error) {
-	query := s.db.Model(&models.Client{})
+	query := s.db.WithContext(ctx).Model(&models.Client{})
 	
 	if req.OnlineOnly {
 		query = query.Where("is_online = ?", true)
 	}
+	if req.Status != clientv1.ClientStatus_CLIENT_STATUS_UNSPECIFIED {
+		query = query.Where("status = ?", protoToClientStatus(req.Status))
+	}
+	if req.Site != "" {
+		query = query.Where("site = ?", req.Site)
+	}
 	
 	// Get total count
 	var totalCount int64
 	if err := query.Count(&totalCount).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to count clients: %v", err)
+		return nil, apierror.Wrap(err, "failed to count clients")
 	}
 	
 	// Apply pagination
@@ -45,7 +72,7 @@ func (s *ClientService) ListClients(ctx context.Context, req *clientv1.ListClien
 	
 	var clients []models.Client
 	if err := query.Order("client_id").Limit(limit).Offset(offset).Find(&clients).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to list clients: %v", err)
+		return nil, apierror.Wrap(err, "failed to list clients")
 	}
 	
 	// Convert to proto
@@ -53,7 +80,7 @@ func (s *ClientService) ListClients(ctx context.Context, req *clientv1.ListClien
 	for i, client := range clients {
 		protoClient, err := s.modelToProtoClient(&client)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to convert client: %v", err)
+			return nil, apierror.Wrap(err, "failed to convert client")
 		}
 		protoClients[i] = protoClient
 	}
@@ -70,76 +97,191 @@ func (s *ClientService) GetClient(ctx context.Context, req *clientv1.GetClientRe
 	}
 	
 	var client models.Client
-	if err := s.db.Where("client_id = ?", req.ClientId).First(&client).Error; err != nil {
+	if err := s.db.WithContext(ctx).Where("client_id = ?", req.ClientId).First(&client).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, status.Error(codes.NotFound, "client not found")
 		}
-		return nil, status.Errorf(codes.Internal, "failed to get client: %v", err)
+		return nil, apierror.Wrap(err, "failed to get client")
 	}
 	
 	protoClient, err := s.modelToProtoClient(&client)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to convert client: %v", err)
+		return nil, apierror.Wrap(err, "failed to convert client")
 	}
 	
-	// Get sensors for this client
-	var sensors []models.Sensor
-	if err := s.db.Where("client_id = ?", req.ClientId).Find(&sensors).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get sensors: %v", err)
+	// Get sensors for this client with their latest readings
+	query := s.sensorsWithLatest(ctx, req.ClientId).Where("sensors.client_id = ?", req.ClientId)
+	if !req.IncludeRetired {
+		query = query.Where("sensors.retired_at IS NULL")
+	}
+	var sensors []sensorWithLatest
+	if err := query.Order("sensors.sensor_id").Find(&sensors).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to get sensors")
 	}
 	
-	// Get latest temperature for each sensor
+	cutoff := time.Now().Add(-defaultSensorStaleAfter)
 	sensorInfos := make([]*clientv1.SensorInfo, len(sensors))
 	for i, sensor := range sensors {
-		var latestReading models.TemperatureReading
-		err := s.db.Where("sensor_id = ?", sensor.SensorID).
-			Order("created_at DESC").
-			First(&latestReading).Error
-		
-		sensorInfo := &clientv1.SensorInfo{
-			SensorId:   sensor.SensorID,
-			SensorType: sensor.SensorType,
-			SensorName: sensor.SensorName,
-		}
-		
-		if err == nil {
-			sensorInfo.CurrentTemperature = latestReading.TemperatureCelsius
-			sensorInfo.LastReading = timestamppb.New(latestReading.CreatedAt)
-		}
-		
-		sensorInfos[i] = sensorInfo
+		sensorInfos[i] = sensor.toProto(cutoff)
+	}
+	
+	var addresses []models.ClientAddress
+	if err := s.db.WithContext(ctx).Where("client_id = ?", req.ClientId).Order("first_seen DESC").Find(&addresses).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to get addresses")
 	}
 	
 	return &clientv1.GetClientResponse{
-		Client:  protoClient,
-		Sensors: sensorInfos,
+		Client:    protoClient,
+		Sensors:   sensorInfos,
+		Addresses: modelToProtoAddresses(&client, addresses),
+	}, nil
+}
+
+// defaultSensorStaleAfter is how long a sensor may go without a reading
+// before it is reported as stale
+const defaultSensorStaleAfter = 5 * time.Minute
+
+func (s *ClientService) ListSensors(ctx context.Context, req *clientv1.ListSensorsRequest) (*clientv1.ListSensorsResponse, error) {
+	if req.StaleAfterSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "stale_after_seconds must not be negative")
+	}
+	staleAfter := defaultSensorStaleAfter
+	if req.StaleAfterSeconds > 0 {
+		staleAfter = time.Duration(req.StaleAfterSeconds) * time.Second
+	}
+	cutoff := time.Now().Add(-staleAfter)
+	
+	query := s.sensorsWithLatest(ctx, req.ClientId)
+	if req.ClientId != "" {
+		query = query.Where("sensors.client_id = ?", req.ClientId)
+	}
+	if req.SensorType != "" {
+		query = query.Where("sensors.sensor_type = ?", sensortype.Filter(req.SensorType))
+	}
+	if req.StaleOnly {
+		query = query.Where("readings.created_at IS NULL OR readings.created_at < ?", cutoff)
+	}
+	if !req.IncludeRetired {
+		query = query.Where("sensors.retired_at IS NULL")
+	}
+	if req.QualityBelow > 0 {
+		query = query.Where("sensors.quality_score < ?", req.QualityBelow)
+	}
+	
+	// Get total count
+	var totalCount int64
+	if err := query.Session(&gorm.Session{}).Count(&totalCount).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to count sensors")
+	}
+	
+	// Apply pagination
+	limit := int(req.Limit)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	offset := int(req.Offset)
+	
+	var sensors []sensorWithLatest
+	if err := query.Order("sensors.client_id, sensors.sensor_id").Limit(limit).Offset(offset).Find(&sensors).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list sensors")
+	}
+	
+	sensorInfos := make([]*clientv1.SensorInfo, len(sensors))
+	for i, sensor := range sensors {
+		sensorInfos[i] = sensor.toProto(cutoff)
+	}
+	
+	return &clientv1.ListSensorsResponse{
+		Sensors:    sensorInfos,
+		TotalCount: int32(totalCount),
 	}, nil
 }
 
+// sensorWithLatest is a sensor joined with its most recent reading, if any
+type sensorWithLatest struct {
+	models.Sensor
+	TemperatureCelsius *float64
+	LastReadingAt      *time.Time
+}
+
+// sensorsWithLatest selects sensors joined with their latest reading in a
+// single query. A non-empty clientID narrows the latest-reading aggregate to
+// that client; callers still filter the sensors themselves.
+func (s *ClientService) sensorsWithLatest(ctx context.Context, clientID string) *gorm.DB {
+	latest := s.db.WithContext(ctx).Model(&models.TemperatureReading{}).
+		Select("client_id, sensor_id, MAX(created_at) as max_created_at").
+		Group("client_id, sensor_id")
+	if clientID != "" {
+		latest = latest.Where("client_id = ?", clientID)
+	}
+	
+	return s.db.WithContext(ctx).Model(&models.Sensor{}).
+		Select("sensors.*, readings.temperature_celsius, readings.created_at AS last_reading_at").
+		Joins("LEFT JOIN (?) as latest ON latest.client_id = sensors.client_id AND latest.sensor_id = sensors.sensor_id", latest).
+		Joins("LEFT JOIN temperature_readings as readings ON readings.client_id = latest.client_id AND readings.sensor_id = latest.sensor_id AND readings.created_at = latest.max_created_at")
+}
+
+func (sensor *sensorWithLatest) toProto(staleCutoff time.Time) *clientv1.SensorInfo {
+	info := &clientv1.SensorInfo{
+		SensorId:   sensor.SensorID,
+		SensorType: sensor.SensorType,
+		SensorName: sensor.SensorName,
+		ClientId:   sensor.ClientID,
+		Stale:      true,
+	}
+	if sensor.LastReadingAt != nil {
+		info.LastReading = timestamppb.New(*sensor.LastReadingAt)
+		info.Stale = sensor.LastReadingAt.Before(staleCutoff)
+	}
+	if sensor.TemperatureCelsius != nil {
+		info.CurrentTemperature = *sensor.TemperatureCelsius
+	}
+	if sensor.StaleSince != nil {
+		info.StaleSince = timestamppb.New(*sensor.StaleSince)
+	}
+	if sensor.RetiredAt != nil {
+		info.RetiredAt = timestamppb.New(*sensor.RetiredAt)
+	}
+	if q := sensor.Quality; q.Score != nil && q.CheckedAt != nil {
+		info.Quality = &clientv1.SensorQuality{
+			Score:            *q.Score,
+			ReadingCount:     q.Readings,
+			Coverage:         q.Coverage,
+			QuarantinedCount: q.Quarantined,
+			Flatline:         q.Flatline,
+			NoiseCelsius:     q.Noise,
+			Noisy:            q.Noisy,
+			CheckedAt:        timestamppb.New(*q.CheckedAt),
+		}
+	}
+	return info
+}
+
 func (s *ClientService) UpdateClient(ctx context.Context, req *clientv1.UpdateClientRequest) (*clientv1.UpdateClientResponse, error) {
 	if req.ClientId == "" {
 		return nil, status.Error(codes.InvalidArgument, "client_id is required")
 	}
 	
 	var client models.Client
-	if err := s.db.Where("client_id = ?", req.ClientId).First(&client).Error; err != nil {
+	if err := s.db.WithContext(ctx).Where("client_id = ?", req.ClientId).First(&client).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, status.Error(codes.NotFound, "client not found")
 		}
-		return nil, status.Errorf(codes.Internal, "failed to get client: %v", err)
+		return nil, apierror.Wrap(err, "failed to get client")
 	}
 	
 	// Update metadata if provided
 	if len(req.Metadata) > 0 {
 		metadataJSON, err := json.Marshal(req.Metadata)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to marshal metadata: %v", err)
+			return nil, apierror.Wrap(err, "failed to marshal metadata")
 		}
 		client.Metadata = string(metadataJSON)
 		
-		if err := s.db.Save(&client).Error; err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to update client: %v", err)
+		if err := s.db.WithContext(ctx).Save(&client).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to update client")
 		}
+		pushConfig(s.db.WithContext(ctx), &client)
 	}
 	
 	return &clientv1.UpdateClientResponse{
@@ -148,6 +290,378 @@ func (s *ClientService) UpdateClient(ctx context.Context, req *clientv1.UpdateCl
 	}, nil
 }
 
+func (s *ClientService) RegisterClient(ctx context.Context, req *clientv1.RegisterClientRequest) (*clientv1.RegisterClientResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+
+	now := time.Now()
+	var client models.Client
+	err := s.db.WithContext(ctx).Where("client_id = ?", req.ClientId).First(&client).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, apierror.Wrap(err, "failed to get client")
+	}
+
+	// Agents from before client IDs were persisted used their hostname as
+	// their ID. An upgraded agent keeps that client, with its history and
+	// rules, unless another machine has already claimed it.
+	if err == gorm.ErrRecordNotFound && req.LegacyClientId != "" && req.LegacyClientId != req.ClientId {
+		var legacy models.Client
+		lerr := s.db.WithContext(ctx).Where("client_id = ?", req.LegacyClientId).First(&legacy).Error
+		if lerr != nil && lerr != gorm.ErrRecordNotFound {
+			return nil, apierror.Wrap(lerr, "failed to get client")
+		}
+		if lerr == nil && (legacy.MachineID == "" || legacy.MachineID == req.MachineId) {
+			client, err = legacy, nil
+			req.ClientId = legacy.ClientID
+		}
+	}
+
+	message := "Client registered successfully"
+	if err == gorm.ErrRecordNotFound {
+		clientStatus, err := newClientStatus(s.cfg.EnrollmentMode)
+		if err != nil {
+			return nil, err
+		}
+		client = models.Client{
+			ClientID:  req.ClientId,
+			IPAddress: s.cfg.ClientIP.FromContext(ctx),
+			Hostname:  req.Hostname,
+			OS:        req.Os,
+			Arch:      req.Arch,
+			MachineID: req.MachineId,
+			FirstSeen: now,
+			LastSeen:  now,
+			Status:    clientStatus,
+
+			Capabilities: commands.EncodeCapabilities(req.Capabilities),
+			LocalActions: commands.EncodeLocalActions(req.LocalActions),
+		}
+		if client.Metadata, err = mergeMetadata("", req.Metadata); err != nil {
+			return nil, apierror.Wrap(err, "failed to marshal metadata")
+		}
+		err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&client).Error; err != nil {
+				return err
+			}
+			return recordAddress(tx, &client, client.IPAddress, now)
+		})
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to create client")
+		}
+		activity.Client(s.db.WithContext(ctx), activity.ClientRegistered, &client, "Client registered")
+		s.cfg.Webhooks.ClientRegistered(&client)
+	} else {
+		if err := checkAPIKey(ctx, &client); err != nil {
+			return nil, err
+		}
+
+		// The same ID from a different machine means the identity was copied or cloned
+		if client.MachineID != "" && req.MachineId != "" && client.MachineID != req.MachineId {
+			if err := s.db.WithContext(ctx).Model(&client).Update("identity_conflict_at", now).Error; err != nil {
+				return nil, apierror.Wrap(err, "failed to flag client conflict")
+			}
+			return nil, status.Errorf(codes.AlreadyExists, "client ID %s is already registered to another machine (hostname %q)", req.ClientId, client.Hostname)
+		}
+		if client.Status == models.ClientStatusRejected {
+			return nil, status.Errorf(codes.PermissionDenied, "client %s has been rejected", req.ClientId)
+		}
+
+		updates := map[string]interface{}{
+			"hostname":  req.Hostname,
+			"os":        req.Os,
+			"arch":      req.Arch,
+			"last_seen": now,
+
+			// Agents report what they accept on every start, so opting out takes effect
+			"capabilities":  commands.EncodeCapabilities(req.Capabilities),
+			"local_actions": commands.EncodeLocalActions(req.LocalActions),
+		}
+		if req.MachineId != "" {
+			updates["machine_id"] = req.MachineId
+		}
+		if len(req.Metadata) > 0 {
+			metadata, err := mergeMetadata(client.Metadata, req.Metadata)
+			if err != nil {
+				return nil, apierror.Wrap(err, "failed to marshal metadata")
+			}
+			updates["metadata"] = metadata
+		}
+		if client.FirstSeen.IsZero() {
+			updates["first_seen"] = now
+		}
+		ip := s.cfg.ClientIP.FromContext(ctx)
+		if ip != "" && ip != client.IPAddress {
+			updates["ip_address"] = ip
+		}
+		err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := recordAddress(tx, &client, ip, now); err != nil {
+				return err
+			}
+			return tx.Model(&client).Updates(updates).Error
+		})
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to update client")
+		}
+		if err := s.db.WithContext(ctx).First(&client, client.ID).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to reload client")
+		}
+		message = "Client re-registered successfully"
+	}
+
+	// A restarted agent has only its own configuration, so it is sent the
+	// resolved one again
+	if err := s.db.WithContext(ctx).Model(&client).UpdateColumn("config_revision", "").Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update client")
+	}
+	client.ConfigRevision = ""
+	pushConfig(s.db.WithContext(ctx), &client)
+
+	// Hostnames are display names, so a shared hostname is reported but allowed
+	var sameHostname int64
+	if req.Hostname != "" {
+		if err := s.db.WithContext(ctx).Model(&models.Client{}).Where("hostname = ? AND client_id <> ?", req.Hostname, req.ClientId).Count(&sameHostname).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to check hostname")
+		}
+	}
+	if sameHostname > 0 {
+		message = fmt.Sprintf("%s; %d other client(s) share hostname %q", message, sameHostname, req.Hostname)
+	}
+	if client.Status == models.ClientStatusPending {
+		message += "; awaiting admin approval"
+	}
+
+	// Commands are only delivered to agents presenting an API key, so one is
+	// issued the first time a client without one opts in to them. Later
+	// registrations must present it.
+	var apiKey string
+	if len(req.Capabilities) > 0 && client.APIKeyHash == "" {
+		if apiKey, err = newSecret("jzk_"); err != nil {
+			return nil, apierror.Wrap(err, "failed to generate API key")
+		}
+		client.APIKeyHash = hashSecret(apiKey)
+		if err := s.db.WithContext(ctx).Model(&client).UpdateColumn("api_key_hash", client.APIKeyHash).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to save API key")
+		}
+	}
+
+	protoClient, err := s.modelToProtoClient(&client)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert client")
+	}
+
+	return &clientv1.RegisterClientResponse{
+		Client:  protoClient,
+		Message: message,
+		ApiKey:  apiKey,
+	}, nil
+}
+
+func (s *ClientService) SetClientApproval(ctx context.Context, req *clientv1.SetClientApprovalRequest) (*clientv1.SetClientApprovalResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+
+	newStatus := models.ClientStatusRejected
+	if req.Approved {
+		newStatus = models.ClientStatusApproved
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.Client{}).Where("client_id = ?", req.ClientId).Update("status", newStatus)
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to update client status")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "client not found")
+	}
+	if req.Approved {
+		var client models.Client
+		if err := s.db.WithContext(ctx).Where("client_id = ?", req.ClientId).First(&client).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to get client")
+		}
+		pushConfig(s.db.WithContext(ctx), &client)
+	}
+
+	return &clientv1.SetClientApprovalResponse{
+		Success: true,
+		Message: fmt.Sprintf("Client %s", newStatus),
+	}, nil
+}
+
+func (s *ClientService) MergeClients(ctx context.Context, req *clientv1.MergeClientsRequest) (*clientv1.MergeClientsResponse, error) {
+	if req.SourceClientId == "" || req.TargetClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "source_client_id and target_client_id are required")
+	}
+	if req.SourceClientId == req.TargetClientId {
+		return nil, status.Error(codes.InvalidArgument, "source and target clients must differ")
+	}
+
+	var result reassign.Result
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var source, target models.Client
+		if err := tx.Where("client_id = ?", req.SourceClientId).First(&source).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return status.Errorf(codes.NotFound, "client %s not found", req.SourceClientId)
+			}
+			return apierror.Wrap(err, "failed to get client")
+		}
+		if err := tx.Where("client_id = ?", req.TargetClientId).First(&target).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return status.Errorf(codes.NotFound, "client %s not found", req.TargetClientId)
+			}
+			return apierror.Wrap(err, "failed to get client")
+		}
+
+		var err error
+		if result, err = reassign.Merge(tx, &source, &target); err != nil {
+			return apierror.Wrap(err, "failed to merge clients")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Merged client %s into %s", req.SourceClientId, req.TargetClientId)
+	activity.Record(s.db.WithContext(ctx), activity.Event{
+		Type:     activity.ClientMerged,
+		ClientID: req.TargetClientId,
+		Message:  message,
+		Details: map[string]string{
+			"source_client_id":   req.SourceClientId,
+			"readings_moved":     fmt.Sprint(result.Readings),
+			"duplicate_readings": fmt.Sprint(result.Duplicates),
+			"chunks_moved":       fmt.Sprint(result.Chunks),
+			"rollups_moved":      fmt.Sprint(result.Rollups),
+			"sensors_moved":      fmt.Sprint(result.Sensors),
+			"alerts_moved":       fmt.Sprint(result.Alerts),
+		},
+	})
+
+	return &clientv1.MergeClientsResponse{
+		Success:           true,
+		Message:           message,
+		ReadingsMoved:     result.Readings,
+		DuplicateReadings: result.Duplicates,
+		ChunksMoved:       result.Chunks,
+		SensorsMoved:      result.Sensors,
+		AlertsMoved:       result.Alerts,
+	}, nil
+}
+
+func (s *ClientService) RenameClient(ctx context.Context, req *clientv1.RenameClientRequest) (*clientv1.RenameClientResponse, error) {
+	if req.ClientId == "" || req.NewClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id and new_client_id are required")
+	}
+	if req.ClientId == req.NewClientId {
+		return nil, status.Error(codes.InvalidArgument, "new_client_id must differ from client_id")
+	}
+
+	var client models.Client
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("client_id = ?", req.ClientId).First(&client).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return status.Error(codes.NotFound, "client not found")
+			}
+			return apierror.Wrap(err, "failed to get client")
+		}
+		if err := reassign.Rename(tx, &client, req.NewClientId); err != nil {
+			if err == reassign.ErrClientExists {
+				return status.Errorf(codes.AlreadyExists, "client %s already exists", req.NewClientId)
+			}
+			return apierror.Wrap(err, "failed to rename client")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Renamed client %s to %s", req.ClientId, req.NewClientId)
+	activity.Record(s.db.WithContext(ctx), activity.Event{
+		Type:     activity.ClientRenamed,
+		ClientID: req.NewClientId,
+		Message:  message,
+		Details:  map[string]string{"previous_client_id": req.ClientId},
+	})
+
+	protoClient, err := s.modelToProtoClient(&client)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert client")
+	}
+	return &clientv1.RenameClientResponse{
+		Success: true,
+		Message: message,
+		Client:  protoClient,
+	}, nil
+}
+
+func (s *ClientService) SetSensorRetired(ctx context.Context, req *clientv1.SetSensorRetiredRequest) (*clientv1.SetSensorRetiredResponse, error) {
+	if req.ClientId == "" || req.SensorId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id and sensor_id are required")
+	}
+
+	var sensor models.Sensor
+	err := s.db.WithContext(ctx).Where("client_id = ? AND sensor_id = ?", req.ClientId, req.SensorId).First(&sensor).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "sensor not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get sensor")
+	}
+	wasRetired := sensor.RetiredAt != nil
+
+	// Retired sensors are not stale; clearing the flag also resolves their
+	// stale sensor alerts on the next check
+	updates := map[string]interface{}{"retired_at": nil}
+	message := "Sensor restored"
+	if req.Retired {
+		now := time.Now()
+		updates = map[string]interface{}{"retired_at": now, "stale_since": nil}
+		message = "Sensor retired"
+		sensor.RetiredAt = &now
+	}
+
+	if err := s.db.WithContext(ctx).Model(&sensor).Updates(updates).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update sensor")
+	}
+	if req.Retired && !wasRetired {
+		activity.Record(s.db.WithContext(ctx), activity.Event{
+			Type:     activity.SensorRetired,
+			ClientID: sensor.ClientID,
+			SensorID: sensor.SensorID,
+			Message:  "Sensor retired by hand",
+		})
+		s.cfg.Webhooks.SensorRetired(&sensor)
+	}
+
+	return &clientv1.SetSensorRetiredResponse{
+		Success: true,
+		Message: message,
+	}, nil
+}
+
+// mergeMetadata adds metadata reported by an agent to a client's stored
+// metadata JSON, keeping keys set by admins that the agent does not report
+func mergeMetadata(stored string, reported map[string]string) (string, error) {
+	if len(reported) == 0 {
+		return stored, nil
+	}
+	metadata := make(map[string]string)
+	if stored != "" {
+		// Unreadable metadata is replaced rather than blocking registration
+		json.Unmarshal([]byte(stored), &metadata)
+	}
+	for key, value := range reported {
+		metadata[key] = value
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 // Helper function to convert model to proto
 func (s *ClientService) modelToProtoClient(client *models.Client) (*clientv1.Client, error) {
 	// Parse metadata
@@ -160,17 +674,56 @@ func (s *ClientService) modelToProtoClient(client *models.Client) (*clientv1.Cli
 	}
 	
 	// Check if client is online (last seen within 5 minutes)
-	isOnline := time.Since(client.LastSeen) < 5*time.Minute
-	
-	return &clientv1.Client{
-		Id:        client.ClientID,
-		Hostname:  client.Hostname,
-		IpAddress: client.IPAddress,
-		Os:        client.OS,
-		Arch:      client.Arch,
-		FirstSeen: timestamppb.New(client.FirstSeen),
-		LastSeen:  timestamppb.New(client.LastSeen),
-		IsOnline:  isOnline,
-		Metadata:  metadata,
-	}, nil
-}
\ No newline at end of file
+	isOnline := time.Since(client.LastSeen) < s.cfg.OfflineAfter
+	
+	protoClient := &clientv1.Client{
+		Id:          client.ClientID,
+		Hostname:    client.Hostname,
+		IpAddress:   client.IPAddress,
+		Os:          client.OS,
+		Arch:        client.Arch,
+		FirstSeen:   timestamppb.New(client.FirstSeen),
+		LastSeen:    timestamppb.New(client.LastSeen),
+		IsOnline:    isOnline,
+		Metadata:    metadata,
+		ClockSkewMs: client.ClockSkewMs,
+		MachineId:   client.MachineID,
+		Status:      clientStatusToProto(client.Status),
+
+		Capabilities: commands.DecodeList(client.Capabilities),
+		LocalActions: commands.DecodeList(client.LocalActions),
+		Location:     modelToProtoLocation(client),
+	}
+	if client.IdentityConflictAt != nil {
+		protoClient.IdentityConflictAt = timestamppb.New(*client.IdentityConflictAt)
+	}
+	return protoClient, nil
+}
+
+// Helper function to convert client status to proto
+func clientStatusToProto(clientStatus string) clientv1.ClientStatus {
+	switch clientStatus {
+	case models.ClientStatusPending:
+		return clientv1.ClientStatus_CLIENT_STATUS_PENDING
+	case models.ClientStatusApproved:
+		return clientv1.ClientStatus_CLIENT_STATUS_APPROVED
+	case models.ClientStatusRejected:
+		return clientv1.ClientStatus_CLIENT_STATUS_REJECTED
+	default:
+		return clientv1.ClientStatus_CLIENT_STATUS_UNSPECIFIED
+	}
+}
+
+// Helper function to convert proto client status to its stored form
+func protoToClientStatus(clientStatus clientv1.ClientStatus) string {
+	switch clientStatus {
+	case clientv1.ClientStatus_CLIENT_STATUS_PENDING:
+		return models.ClientStatusPending
+	case clientv1.ClientStatus_CLIENT_STATUS_APPROVED:
+		return models.ClientStatusApproved
+	case clientv1.ClientStatus_CLIENT_STATUS_REJECTED:
+		return models.ClientStatusRejected
+	default:
+		return ""
+	}
+}
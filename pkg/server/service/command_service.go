@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	commandv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/command/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/systemlog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Limits on queued commands
+const (
+	defaultCommandTTL = 5 * time.Minute
+	maxCommandTTL     = 24 * time.Hour
+	maxFanCurvePoints = 16
+	maxCommandResult  = 4096
+
+	defaultBurstInterval = time.Second
+	maxBurstInterval     = time.Minute
+	defaultBurstDuration = 10 * time.Minute
+	maxBurstDuration     = time.Hour
+)
+
+type CommandService struct {
+	jacuzziv1.UnimplementedCommandServiceServer
+	db *gorm.DB
+}
+
+func NewCommandService(db *gorm.DB) *CommandService {
+	return &CommandService{db: db}
+}
+
+func (s *CommandService) SendCommand(ctx context.Context, req *commandv1.SendCommandRequest) (*commandv1.SendCommandResponse, error) {
+	if req.Command == nil || req.Command.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "command client_id is required")
+	}
+	ttl := defaultCommandTTL
+	if req.TtlSeconds < 0 || time.Duration(req.TtlSeconds)*time.Second > maxCommandTTL {
+		return nil, status.Errorf(codes.InvalidArgument, "ttl_seconds must be between 0 and %d", int64(maxCommandTTL.Seconds()))
+	}
+	if req.TtlSeconds > 0 {
+		ttl = time.Duration(req.TtlSeconds) * time.Second
+	}
+
+	commandType, capability, err := validateCommandAction(req.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	var client models.Client
+	if err := s.db.WithContext(ctx).Where("client_id = ?", req.Command.ClientId).First(&client).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "client not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get client")
+	}
+	if !slices.Contains(commands.DecodeList(client.Capabilities), capability) {
+		return nil, status.Errorf(codes.FailedPrecondition, "client %s has not opted in to %s", client.ClientID, capability)
+	}
+	if runAction := req.Command.GetRunAction(); runAction != nil && !slices.Contains(commands.DecodeList(client.LocalActions), runAction.Name) {
+		return nil, status.Errorf(codes.FailedPrecondition, "client %s does not offer local action %q", client.ClientID, runAction.Name)
+	}
+
+	command, err := commands.Queue(s.db.WithContext(ctx), client.ClientID, commandType, req.Command, ttl, models.CommandSourceAPI)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to queue command")
+	}
+
+	protoCommand, err := modelToProtoCommand(command)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert command")
+	}
+	return &commandv1.SendCommandResponse{Command: protoCommand}, nil
+}
+
+func (s *CommandService) ListCommands(ctx context.Context, req *commandv1.ListCommandsRequest) (*commandv1.ListCommandsResponse, error) {
+	limit := int(req.Limit)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	query := s.db.WithContext(ctx).Model(&models.Command{})
+	if req.ClientId != "" {
+		query = query.Where("client_id = ?", req.ClientId)
+	}
+	var commands []models.Command
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&commands).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list commands")
+	}
+
+	protoCommands, err := modelsToProtoCommands(commands)
+	if err != nil {
+		return nil, err
+	}
+	return &commandv1.ListCommandsResponse{Commands: protoCommands}, nil
+}
+
+func (s *CommandService) PollCommands(ctx context.Context, req *commandv1.PollCommandsRequest) (*commandv1.PollCommandsResponse, error) {
+	if _, err := s.agentClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var commands []models.Command
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("client_id = ? AND status = ? AND expires_at > ?", req.ClientId, models.CommandStatusPending, now).
+			Order("created_at, id").
+			Find(&commands).Error
+		if err != nil || len(commands) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(commands))
+		for i := range commands {
+			ids[i] = commands[i].ID
+			commands[i].Status = models.CommandStatusDelivered
+			commands[i].DeliveredAt = &now
+		}
+		// Only claim commands still pending, so concurrent polls never both deliver one
+		result := tx.Model(&models.Command{}).
+			Where("id IN ? AND status = ?", ids, models.CommandStatusPending).
+			Updates(map[string]interface{}{"status": models.CommandStatusDelivered, "delivered_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(ids)) {
+			return fmt.Errorf("commands were claimed by another poll")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to poll commands")
+	}
+
+	protoCommands, err := modelsToProtoCommands(commands)
+	if err != nil {
+		return nil, err
+	}
+	return &commandv1.PollCommandsResponse{Commands: protoCommands}, nil
+}
+
+func (s *CommandService) ReportCommandResult(ctx context.Context, req *commandv1.ReportCommandResultRequest) (*commandv1.ReportCommandResultResponse, error) {
+	if req.CommandId == "" {
+		return nil, status.Error(codes.InvalidArgument, "command_id is required")
+	}
+	if _, err := s.agentClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+
+	commandStatus := models.CommandStatusFailed
+	if req.Success {
+		commandStatus = models.CommandStatusSucceeded
+	}
+	message := req.Message
+	if len(message) > maxCommandResult {
+		message = message[:maxCommandResult]
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.Command{}).
+		Where("command_id = ? AND client_id = ? AND status = ?", req.CommandId, req.ClientId, models.CommandStatusDelivered).
+		Updates(map[string]interface{}{"status": commandStatus, "result": message, "completed_at": time.Now()})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to record command result")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "no delivered command with this id for the client")
+	}
+	log.Printf("Client %s reported command %s %s: %s", req.ClientId, req.CommandId, commandStatus, message)
+
+	return &commandv1.ReportCommandResultResponse{
+		Success: true,
+		Message: "Command result recorded",
+	}, nil
+}
+
+// agentClient loads the approved client an agent call is made for, checking
+// the API key it presented. Unlike other agent calls, a client without an API
+// key is refused, as commands act on the host.
+func (s *CommandService) agentClient(ctx context.Context, clientID string) (*models.Client, error) {
+	if clientID == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	var client models.Client
+	if err := s.db.WithContext(ctx).Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "client not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get client")
+	}
+	if client.APIKeyHash == "" {
+		return nil, errNoAPIKey
+	}
+	if err := checkAPIKey(ctx, &client); err != nil {
+		return nil, err
+	}
+	if client.Status != models.ClientStatusApproved {
+		return nil, status.Errorf(codes.PermissionDenied, "client %s is not approved", clientID)
+	}
+	return &client, nil
+}
+
+// validateCommandAction checks a command's action, returning its type and the
+// capability an agent needs to run it
+func validateCommandAction(command *commandv1.Command) (string, string, error) {
+	switch action := command.Action.(type) {
+	case *commandv1.Command_SetFan:
+		return models.CommandTypeSetFan, models.CapabilityFanControl, validateSetFan(action.SetFan)
+	case *commandv1.Command_RunAction:
+		if action.RunAction.Name == "" {
+			return "", "", status.Error(codes.InvalidArgument, "run_action name is required")
+		}
+		return models.CommandTypeRunAction, models.CapabilityLocalActions, nil
+	case *commandv1.Command_BurstCapture:
+		return models.CommandTypeBurstCapture, models.CapabilityBurstCapture, validateBurstCapture(action.BurstCapture)
+	case *commandv1.Command_ApplyConfig:
+		return "", "", status.Error(codes.InvalidArgument, "apply_config commands are queued from configuration profiles")
+	case *commandv1.Command_LogEvent:
+		return models.CommandTypeLogEvent, models.CapabilityEventLog, validateLogEvent(action.LogEvent)
+	default:
+		return "", "", status.Error(codes.InvalidArgument, "command action is required")
+	}
+}
+
+func validateLogEvent(event *commandv1.LogEventCommand) error {
+	if strings.TrimSpace(event.Message) == "" {
+		return status.Error(codes.InvalidArgument, "log_event message is required")
+	}
+	if event.Source != "" && !systemlog.ValidSource(event.Source) {
+		return status.Errorf(codes.InvalidArgument, "log_event source %q must be 1-64 letters, digits, dots, underscores or hyphens", event.Source)
+	}
+	if event.EventId > 1000 {
+		return status.Errorf(codes.InvalidArgument, "log_event event_id %d must be between 1 and 1000", event.EventId)
+	}
+	if _, ok := commandv1.LogEventCommand_Level_name[int32(event.Level)]; !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown log_event level %d", event.Level)
+	}
+	for key := range event.Fields {
+		if !systemlog.ValidField(key) {
+			return status.Errorf(codes.InvalidArgument, "log_event field %q must be lowercase letters, digits and underscores", key)
+		}
+	}
+	return nil
+}
+
+func validateSetFan(fan *commandv1.SetFanCommand) error {
+	if fan.FanId == "" {
+		return status.Error(codes.InvalidArgument, "set_fan fan_id is required")
+	}
+	if fan.Automatic {
+		return nil
+	}
+	if len(fan.Curve) == 0 {
+		if fan.Pwm < 0 || fan.Pwm > 255 {
+			return status.Errorf(codes.InvalidArgument, "set_fan pwm %d must be between 0 and 255", fan.Pwm)
+		}
+		return nil
+	}
+
+	if fan.SensorId == "" {
+		return status.Error(codes.InvalidArgument, "set_fan sensor_id is required with a curve")
+	}
+	if len(fan.Curve) > maxFanCurvePoints {
+		return status.Errorf(codes.InvalidArgument, "set_fan curve has more than %d points", maxFanCurvePoints)
+	}
+	for i, point := range fan.Curve {
+		if point.Pwm < 0 || point.Pwm > 255 {
+			return status.Errorf(codes.InvalidArgument, "set_fan curve point %d pwm %d must be between 0 and 255", i, point.Pwm)
+		}
+		if i > 0 && point.TemperatureCelsius <= fan.Curve[i-1].TemperatureCelsius {
+			return status.Errorf(codes.InvalidArgument, "set_fan curve temperatures must increase")
+		}
+	}
+	return nil
+}
+
+// validateBurstCapture checks a burst capture's limits and fills in the
+// defaults, so the agent is sent the interval and duration it will use
+func validateBurstCapture(burst *commandv1.BurstCaptureCommand) error {
+	if burst.IntervalSeconds == 0 {
+		burst.IntervalSeconds = int32(defaultBurstInterval.Seconds())
+	}
+	if burst.DurationSeconds == 0 {
+		burst.DurationSeconds = int32(defaultBurstDuration.Seconds())
+	}
+	if burst.IntervalSeconds < 1 || time.Duration(burst.IntervalSeconds)*time.Second > maxBurstInterval {
+		return status.Errorf(codes.InvalidArgument, "burst_capture interval_seconds must be between 1 and %d", int(maxBurstInterval.Seconds()))
+	}
+	if burst.DurationSeconds < 0 || time.Duration(burst.DurationSeconds)*time.Second > maxBurstDuration {
+		return status.Errorf(codes.InvalidArgument, "burst_capture duration_seconds must be between 1 and %d", int(maxBurstDuration.Seconds()))
+	}
+	if burst.DurationSeconds < burst.IntervalSeconds {
+		return status.Error(codes.InvalidArgument, "burst_capture duration_seconds must be at least interval_seconds")
+	}
+	return nil
+}
+
+// Helper function to convert command models to protos
+func modelsToProtoCommands(commands []models.Command) ([]*commandv1.Command, error) {
+	protoCommands := make([]*commandv1.Command, len(commands))
+	for i := range commands {
+		protoCommand, err := modelToProtoCommand(&commands[i])
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to convert command")
+		}
+		protoCommands[i] = protoCommand
+	}
+	return protoCommands, nil
+}
+
+// Helper function to convert command model to proto
+func modelToProtoCommand(command *models.Command) (*commandv1.Command, error) {
+	protoCommand := &commandv1.Command{}
+	if err := protojson.Unmarshal([]byte(command.Payload), protoCommand); err != nil {
+		return nil, err
+	}
+	protoCommand.Id = command.CommandID
+	protoCommand.ClientId = command.ClientID
+	protoCommand.Source = command.Source
+	protoCommand.Status = commandStatusToProto(command)
+	protoCommand.Result = command.Result
+	protoCommand.CreatedAt = timestamppb.New(command.CreatedAt)
+	protoCommand.ExpiresAt = timestamppb.New(command.ExpiresAt)
+	if command.DeliveredAt != nil {
+		protoCommand.DeliveredAt = timestamppb.New(*command.DeliveredAt)
+	}
+	if command.CompletedAt != nil {
+		protoCommand.CompletedAt = timestamppb.New(*command.CompletedAt)
+	}
+	return protoCommand, nil
+}
+
+// Helper function to convert command status to proto
+func commandStatusToProto(command *models.Command) commandv1.CommandStatus {
+	switch command.Status {
+	case models.CommandStatusPending:
+		if time.Now().After(command.ExpiresAt) {
+			return commandv1.CommandStatus_COMMAND_STATUS_EXPIRED
+		}
+		return commandv1.CommandStatus_COMMAND_STATUS_PENDING
+	case models.CommandStatusDelivered:
+		return commandv1.CommandStatus_COMMAND_STATUS_DELIVERED
+	case models.CommandStatusSucceeded:
+		return commandv1.CommandStatus_COMMAND_STATUS_SUCCEEDED
+	case models.CommandStatusFailed:
+		return commandv1.CommandStatus_COMMAND_STATUS_FAILED
+	default:
+		return commandv1.CommandStatus_COMMAND_STATUS_UNSPECIFIED
+	}
+}
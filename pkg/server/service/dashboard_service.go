@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	dashboardv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/dashboard/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// maxDashboardPanels bounds the panels on a single dashboard
+const maxDashboardPanels = 100
+
+type DashboardService struct {
+	jacuzziv1.UnimplementedDashboardServiceServer
+	db *gorm.DB
+}
+
+func NewDashboardService(db *gorm.DB) *DashboardService {
+	return &DashboardService{db: db}
+}
+
+func (s *DashboardService) CreateDashboard(ctx context.Context, req *dashboardv1.CreateDashboardRequest) (*dashboardv1.CreateDashboardResponse, error) {
+	if req.Dashboard == nil {
+		return nil, status.Error(codes.InvalidArgument, "dashboard is required")
+	}
+	if err := validateDashboard(req.Dashboard); err != nil {
+		return nil, err
+	}
+
+	dashboard := &models.Dashboard{
+		DashboardID: uuid.New().String(),
+		Owner:       req.User,
+	}
+	if err := applyDashboard(dashboard, req.Dashboard); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(dashboard).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create dashboard")
+	}
+
+	protoDashboard, err := modelToProtoDashboard(dashboard, true)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert dashboard")
+	}
+	return &dashboardv1.CreateDashboardResponse{Dashboard: protoDashboard}, nil
+}
+
+func (s *DashboardService) GetDashboard(ctx context.Context, req *dashboardv1.GetDashboardRequest) (*dashboardv1.GetDashboardResponse, error) {
+	dashboard, err := s.visibleDashboard(ctx, req.Id, req.User)
+	if err != nil {
+		return nil, err
+	}
+
+	protoDashboard, err := modelToProtoDashboard(dashboard, true)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert dashboard")
+	}
+	return &dashboardv1.GetDashboardResponse{Dashboard: protoDashboard}, nil
+}
+
+func (s *DashboardService) ListDashboards(ctx context.Context, req *dashboardv1.ListDashboardsRequest) (*dashboardv1.ListDashboardsResponse, error) {
+	query := s.db.WithContext(ctx).Model(&models.Dashboard{})
+	if req.OwnedOnly {
+		query = query.Where("owner = ?", req.User)
+	} else {
+		query = query.Where("owner = ? OR owner = '' OR shared = ?", req.User, true)
+	}
+
+	// Panels and layout are only needed once a dashboard is opened
+	var dashboards []models.Dashboard
+	err := query.Omit("panels", "layout").Order("name, dashboard_id").Find(&dashboards).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to list dashboards")
+	}
+
+	protoDashboards := make([]*dashboardv1.Dashboard, len(dashboards))
+	for i := range dashboards {
+		protoDashboard, err := modelToProtoDashboard(&dashboards[i], false)
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to convert dashboard")
+		}
+		protoDashboards[i] = protoDashboard
+	}
+	return &dashboardv1.ListDashboardsResponse{Dashboards: protoDashboards}, nil
+}
+
+func (s *DashboardService) UpdateDashboard(ctx context.Context, req *dashboardv1.UpdateDashboardRequest) (*dashboardv1.UpdateDashboardResponse, error) {
+	if req.Dashboard == nil {
+		return nil, status.Error(codes.InvalidArgument, "dashboard is required")
+	}
+	if err := validateDashboard(req.Dashboard); err != nil {
+		return nil, err
+	}
+
+	dashboard, err := s.editableDashboard(ctx, req.Dashboard.Id, req.User)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyDashboard(dashboard, req.Dashboard); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Save(dashboard).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update dashboard")
+	}
+
+	protoDashboard, err := modelToProtoDashboard(dashboard, true)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert dashboard")
+	}
+	return &dashboardv1.UpdateDashboardResponse{Dashboard: protoDashboard}, nil
+}
+
+func (s *DashboardService) DeleteDashboard(ctx context.Context, req *dashboardv1.DeleteDashboardRequest) (*dashboardv1.DeleteDashboardResponse, error) {
+	dashboard, err := s.editableDashboard(ctx, req.Id, req.User)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(dashboard).Error; err != nil {
+			return err
+		}
+		return clearDefaultDashboard(tx, dashboard.DashboardID)
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to delete dashboard")
+	}
+
+	return &dashboardv1.DeleteDashboardResponse{
+		Success: true,
+		Message: "Dashboard deleted successfully",
+	}, nil
+}
+
+// visibleDashboard loads a dashboard the user may view. Dashboards that are
+// neither shared nor owned by the user are reported as not found.
+func (s *DashboardService) visibleDashboard(ctx context.Context, id, user string) (*models.Dashboard, error) {
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "dashboard id is required")
+	}
+
+	var dashboard models.Dashboard
+	if err := s.db.WithContext(ctx).Where("dashboard_id = ?", id).First(&dashboard).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "dashboard not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get dashboard")
+	}
+	if !dashboard.Shared && dashboard.Owner != "" && dashboard.Owner != user {
+		return nil, status.Error(codes.NotFound, "dashboard not found")
+	}
+	return &dashboard, nil
+}
+
+// editableDashboard loads a dashboard the user may change
+func (s *DashboardService) editableDashboard(ctx context.Context, id, user string) (*models.Dashboard, error) {
+	dashboard, err := s.visibleDashboard(ctx, id, user)
+	if err != nil {
+		return nil, err
+	}
+	if dashboard.Owner != "" && dashboard.Owner != user {
+		return nil, status.Error(codes.PermissionDenied, "only the owner can change a shared dashboard")
+	}
+	return dashboard, nil
+}
+
+func validateDashboard(dashboard *dashboardv1.Dashboard) error {
+	if dashboard.Name == "" {
+		return status.Error(codes.InvalidArgument, "dashboard name is required")
+	}
+	if len(dashboard.Panels) > maxDashboardPanels {
+		return status.Errorf(codes.InvalidArgument, "a dashboard can have at most %d panels", maxDashboardPanels)
+	}
+	if dashboard.LayoutJson != "" && !json.Valid([]byte(dashboard.LayoutJson)) {
+		return status.Error(codes.InvalidArgument, "layout_json must be valid JSON")
+	}
+
+	ids := make(map[string]bool, len(dashboard.Panels))
+	for i, panel := range dashboard.Panels {
+		if panel.Id == "" {
+			return status.Errorf(codes.InvalidArgument, "panel %d: id is required", i)
+		}
+		if ids[panel.Id] {
+			return status.Errorf(codes.InvalidArgument, "panel %d: duplicate id %q", i, panel.Id)
+		}
+		ids[panel.Id] = true
+		if panel.RangeSeconds < 0 {
+			return status.Errorf(codes.InvalidArgument, "panel %q: range_seconds must not be negative", panel.Id)
+		}
+		if panel.OptionsJson != "" && !json.Valid([]byte(panel.OptionsJson)) {
+			return status.Errorf(codes.InvalidArgument, "panel %q: options_json must be valid JSON", panel.Id)
+		}
+		for _, sensor := range panel.Sensors {
+			if sensor.ClientId == "" || sensor.SensorId == "" {
+				return status.Errorf(codes.InvalidArgument, "panel %q: sensors need a client_id and sensor_id", panel.Id)
+			}
+		}
+	}
+	return nil
+}
+
+// applyDashboard copies the editable fields of a dashboard onto its model
+func applyDashboard(dashboard *models.Dashboard, from *dashboardv1.Dashboard) error {
+	panels := make([]json.RawMessage, len(from.Panels))
+	for i, panel := range from.Panels {
+		data, err := protojson.Marshal(panel)
+		if err != nil {
+			return apierror.Wrap(err, "failed to encode panel")
+		}
+		panels[i] = data
+	}
+	data, err := json.Marshal(panels)
+	if err != nil {
+		return apierror.Wrap(err, "failed to encode panels")
+	}
+
+	dashboard.Name = from.Name
+	dashboard.Description = from.Description
+	dashboard.Shared = from.Shared
+	dashboard.Panels = string(data)
+	dashboard.Layout = from.LayoutJson
+	return nil
+}
+
+// modelToProtoDashboard converts a dashboard, with its panels and layout when
+// full is set
+func modelToProtoDashboard(dashboard *models.Dashboard, full bool) (*dashboardv1.Dashboard, error) {
+	protoDashboard := &dashboardv1.Dashboard{
+		Id:          dashboard.DashboardID,
+		Name:        dashboard.Name,
+		Description: dashboard.Description,
+		Owner:       dashboard.Owner,
+		Shared:      dashboard.Shared,
+		CreatedAt:   timestamppb.New(dashboard.CreatedAt),
+		UpdatedAt:   timestamppb.New(dashboard.UpdatedAt),
+	}
+	if !full {
+		return protoDashboard, nil
+	}
+
+	protoDashboard.LayoutJson = dashboard.Layout
+	if dashboard.Panels == "" {
+		return protoDashboard, nil
+	}
+	var panels []json.RawMessage
+	if err := json.Unmarshal([]byte(dashboard.Panels), &panels); err != nil {
+		return nil, fmt.Errorf("failed to decode panels: %w", err)
+	}
+	for _, data := range panels {
+		panel := &dashboardv1.Panel{}
+		// Unknown fields are dropped so panels written by newer servers still load
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, panel); err != nil {
+			return nil, fmt.Errorf("failed to decode panel: %w", err)
+		}
+		protoDashboard.Panels = append(protoDashboard.Panels, panel)
+	}
+	return protoDashboard, nil
+}
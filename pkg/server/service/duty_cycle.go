@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/report"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GetDutyCycles sums the duty cycles of each sensor and threshold over the
+// local day or week containing the requested time
+func (s *TemperatureService) GetDutyCycles(ctx context.Context, req *temperaturev1.GetDutyCyclesRequest) (*temperaturev1.GetDutyCyclesResponse, error) {
+	if _, ok := temperaturev1.DutyCyclePeriod_name[int32(req.Period)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown period %d", req.Period)
+	}
+	db := s.db.WithContext(ctx)
+	// Duty cycles are stored by day in the configured zone only
+	loc, err := resolveTimezone(db, "")
+	if err != nil {
+		return nil, err
+	}
+	at := time.Now()
+	if req.Time != nil {
+		at = req.Time.AsTime()
+	}
+	unit := timezone.Day
+	if req.Period == temperaturev1.DutyCyclePeriod_DUTY_CYCLE_PERIOD_WEEK {
+		unit = timezone.Week
+	}
+	start := timezone.Floor(at, unit, loc)
+	end := timezone.Next(start, unit, loc)
+
+	query := db.Model(&models.DutyCycle{}).
+		Select("client_id, sensor_id, threshold, SUM(above) AS above, SUM(tracked) AS tracked").
+		Where("day >= ? AND day < ?", start.UTC(), end.UTC()).
+		Group("client_id, sensor_id, threshold")
+	if req.ClientId != "" {
+		query = query.Where("client_id = ?", req.ClientId)
+	}
+	if req.SensorId != "" {
+		query = query.Where("sensor_id = ?", req.SensorId)
+	}
+	if req.Threshold != 0 {
+		query = query.Where("threshold = ?", req.Threshold)
+	}
+	var rows []models.DutyCycle
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to load duty cycles")
+	}
+
+	sensors := db.Model(&models.Sensor{})
+	clients := db.Model(&models.Client{})
+	if req.ClientId != "" {
+		sensors = sensors.Where("client_id = ?", req.ClientId)
+		clients = clients.Where("client_id = ?", req.ClientId)
+	}
+	var sensorRows []models.Sensor
+	if err := sensors.Select("client_id, sensor_id, sensor_name, sensor_type").Find(&sensorRows).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to load sensors")
+	}
+	type sensorKey struct{ client, sensor string }
+	bySensor := make(map[sensorKey]*models.Sensor, len(sensorRows))
+	for i := range sensorRows {
+		bySensor[sensorKey{sensorRows[i].ClientID, sensorRows[i].SensorID}] = &sensorRows[i]
+	}
+	var clientRows []models.Client
+	if err := clients.Select("client_id, hostname").Find(&clientRows).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to load clients")
+	}
+	hostnames := make(map[string]string, len(clientRows))
+	for _, client := range clientRows {
+		hostnames[client.ClientID] = client.Hostname
+	}
+
+	sensorType := sensortype.Filter(req.SensorType)
+	resp := &temperaturev1.GetDutyCyclesResponse{
+		StartTime: timestamppb.New(start),
+		EndTime:   timestamppb.New(end),
+		Timezone:  loc.String(),
+	}
+	for _, row := range rows {
+		cycle := &temperaturev1.DutyCycle{
+			ClientId:       row.ClientID,
+			Hostname:       hostnames[row.ClientID],
+			SensorId:       row.SensorID,
+			Threshold:      row.Threshold,
+			SecondsAbove:   int64(row.Above),
+			SecondsTracked: int64(row.Tracked),
+		}
+		if sensor, ok := bySensor[sensorKey{row.ClientID, row.SensorID}]; ok {
+			cycle.SensorName = sensor.SensorName
+			cycle.SensorType = sensor.SensorType
+		}
+		if sensorType != "" && cycle.SensorType != sensorType {
+			continue
+		}
+		if row.Tracked > 0 {
+			cycle.PercentAbove = 100 * row.Above / row.Tracked
+		}
+		resp.DutyCycles = append(resp.DutyCycles, cycle)
+	}
+	sort.Slice(resp.DutyCycles, func(i, j int) bool {
+		a, b := resp.DutyCycles[i], resp.DutyCycles[j]
+		if a.SecondsAbove != b.SecondsAbove {
+			return a.SecondsAbove > b.SecondsAbove
+		}
+		if a.ClientId != b.ClientId {
+			return a.ClientId < b.ClientId
+		}
+		if a.SensorId != b.SensorId {
+			return a.SensorId < b.SensorId
+		}
+		return a.Threshold < b.Threshold
+	})
+	return resp, nil
+}
+
+// GetThermalWear ranks components by their time above tracked thresholds,
+// estimated over their client's time in service
+func (s *TemperatureService) GetThermalWear(ctx context.Context, req *temperaturev1.GetThermalWearRequest) (*temperaturev1.GetThermalWearResponse, error) {
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	limit := int(req.Limit)
+	if limit == 0 || limit > 1000 {
+		limit = 100
+	}
+	filter := report.WearFilter{
+		SensorType:     sensortype.Filter(req.SensorType),
+		Threshold:      req.Threshold,
+		IncludeRetired: req.IncludeRetired,
+	}
+	if req.ClientId != "" {
+		filter.ClientIDs = []string{req.ClientId}
+	}
+	wear, err := report.LoadWear(s.db.WithContext(ctx), filter)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to load thermal wear")
+	}
+
+	resp := &temperaturev1.GetThermalWearResponse{TotalCount: int32(len(wear))}
+	if len(wear) > limit {
+		wear = wear[:limit]
+	}
+	for _, w := range wear {
+		component := &temperaturev1.ComponentWear{
+			ClientId:              w.ClientID,
+			Hostname:              w.Hostname,
+			SensorId:              w.SensorID,
+			SensorName:            w.Name,
+			SensorType:            w.Type,
+			Threshold:             w.Threshold,
+			DaysTracked:           int32(w.Days),
+			SecondsAbove:          int64(w.Above),
+			SecondsTracked:        int64(w.Tracked),
+			PercentAbove:          w.PercentAbove(),
+			SecondsInService:      int64(w.InService),
+			EstimatedSecondsAbove: int64(w.EstimatedAbove()),
+		}
+		if !w.ClientFirstSeen.IsZero() {
+			component.ClientFirstSeen = timestamppb.New(w.ClientFirstSeen)
+		}
+		resp.Components = append(resp.Components, component)
+	}
+	return resp, nil
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	clientv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/client/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Enrollment modes for new clients
+const (
+	EnrollmentOpen     = "open"     // Accept new clients immediately
+	EnrollmentApproval = "approval" // Hold new clients as pending until approved
+	EnrollmentToken    = "token"    // Only accept clients enrolled with a token
+)
+
+// APIKeyHeader is the metadata key agents use to present their API key
+const APIKeyHeader = "x-api-key"
+
+var (
+	errNotEnrolled    = status.Error(codes.PermissionDenied, "client is not enrolled; an enrollment token is required")
+	errInvalidAPIKey  = status.Error(codes.Unauthenticated, "missing or invalid API key")
+	errNoAPIKey       = status.Error(codes.Unauthenticated, "client has no API key; restart the agent with commands enabled to be issued one")
+	errTokenNotUsable = status.Error(codes.PermissionDenied, "enrollment token is invalid, expired, revoked, or used up")
+)
+
+func (s *ClientService) CreateEnrollmentToken(ctx context.Context, req *clientv1.CreateEnrollmentTokenRequest) (*clientv1.CreateEnrollmentTokenResponse, error) {
+	secret, err := newSecret("jzt_")
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to generate token")
+	}
+
+	maxUses := int(req.MaxUses)
+	switch {
+	case maxUses == 0:
+		maxUses = 1
+	case maxUses < 0:
+		maxUses = 0
+	}
+
+	token := models.EnrollmentToken{
+		ID:          uuid.New().String(),
+		TokenHash:   hashSecret(secret),
+		Description: req.Description,
+		MaxUses:     maxUses,
+	}
+	if req.TtlSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.TtlSeconds) * time.Second)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.WithContext(ctx).Create(&token).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create enrollment token")
+	}
+
+	return &clientv1.CreateEnrollmentTokenResponse{
+		Token: secret,
+		Info:  modelToProtoEnrollmentToken(&token),
+	}, nil
+}
+
+func (s *ClientService) ListEnrollmentTokens(ctx context.Context, req *clientv1.ListEnrollmentTokensRequest) (*clientv1.ListEnrollmentTokensResponse, error) {
+	query := s.db.WithContext(ctx).Model(&models.EnrollmentToken{})
+	if !req.IncludeInactive {
+		query = activeTokens(query, time.Now())
+	}
+
+	var tokens []models.EnrollmentToken
+	if err := query.Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list enrollment tokens")
+	}
+
+	protoTokens := make([]*clientv1.EnrollmentToken, len(tokens))
+	for i := range tokens {
+		protoTokens[i] = modelToProtoEnrollmentToken(&tokens[i])
+	}
+
+	return &clientv1.ListEnrollmentTokensResponse{Tokens: protoTokens}, nil
+}
+
+func (s *ClientService) RevokeEnrollmentToken(ctx context.Context, req *clientv1.RevokeEnrollmentTokenRequest) (*clientv1.RevokeEnrollmentTokenResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.EnrollmentToken{}).
+		Where("id = ? AND revoked_at IS NULL", req.Id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to revoke enrollment token")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "enrollment token not found or already revoked")
+	}
+
+	return &clientv1.RevokeEnrollmentTokenResponse{
+		Success: true,
+		Message: "Enrollment token revoked",
+	}, nil
+}
+
+func (s *ClientService) EnrollClient(ctx context.Context, req *clientv1.EnrollClientRequest) (*clientv1.EnrollClientResponse, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	apiKey, err := newSecret("jzk_")
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to generate API key")
+	}
+
+	now := time.Now()
+	client := models.Client{
+		ClientID:   uuid.New().String(),
+		IPAddress:  s.cfg.ClientIP.FromContext(ctx),
+		Hostname:   req.Hostname,
+		OS:         req.Os,
+		Arch:       req.Arch,
+		MachineID:  req.MachineId,
+		FirstSeen:  now,
+		LastSeen:   now,
+		Status:     models.ClientStatusApproved,
+		APIKeyHash: hashSecret(apiKey),
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Claim a use atomically so concurrent enrollments cannot exceed max_uses
+		result := activeTokens(tx.Model(&models.EnrollmentToken{}), now).
+			Where("token_hash = ?", hashSecret(req.Token)).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTokenNotUsable
+		}
+		if err := tx.Create(&client).Error; err != nil {
+			return err
+		}
+		return recordAddress(tx, &client, client.IPAddress, now)
+	})
+	if err != nil {
+		if errors.Is(err, errTokenNotUsable) {
+			return nil, err
+		}
+		return nil, apierror.Wrap(err, "failed to enroll client")
+	}
+	activity.Client(s.db.WithContext(ctx), activity.ClientRegistered, &client, "Client enrolled with a token")
+	s.cfg.Webhooks.ClientRegistered(&client)
+
+	protoClient, err := s.modelToProtoClient(&client)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert client")
+	}
+
+	return &clientv1.EnrollClientResponse{
+		ClientId: client.ClientID,
+		ApiKey:   apiKey,
+		Client:   protoClient,
+	}, nil
+}
+
+// Helper function to pick the status for a newly seen client
+func newClientStatus(mode string) (string, error) {
+	switch mode {
+	case EnrollmentApproval:
+		return models.ClientStatusPending, nil
+	case EnrollmentToken:
+		return "", errNotEnrolled
+	default:
+		return models.ClientStatusApproved, nil
+	}
+}
+
+// Helper function to check the API key presented for a client that has one
+func checkAPIKey(ctx context.Context, client *models.Client) error {
+	if client.APIKeyHash == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(APIKeyHeader)
+	if len(keys) == 0 {
+		return errInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(keys[0])), []byte(client.APIKeyHash)) != 1 {
+		return errInvalidAPIKey
+	}
+	return nil
+}
+
+// Helper function to restrict a token query to usable tokens
+func activeTokens(query *gorm.DB, now time.Time) *gorm.DB {
+	return query.Where("revoked_at IS NULL").
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("max_uses = 0 OR uses < max_uses")
+}
+
+// Helper function to generate a random secret with a recognisable prefix
+func newSecret(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// Helper function to hash a secret for storage
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper function to convert enrollment token model to proto
+func modelToProtoEnrollmentToken(token *models.EnrollmentToken) *clientv1.EnrollmentToken {
+	protoToken := &clientv1.EnrollmentToken{
+		Id:          token.ID,
+		Description: token.Description,
+		MaxUses:     int32(token.MaxUses),
+		Uses:        int32(token.Uses),
+		CreatedAt:   timestamppb.New(token.CreatedAt),
+	}
+	if token.ExpiresAt != nil {
+		protoToken.ExpiresAt = timestamppb.New(*token.ExpiresAt)
+	}
+	if token.RevokedAt != nil {
+		protoToken.RevokedAt = timestamppb.New(*token.RevokedAt)
+	}
+	return protoToken
+}
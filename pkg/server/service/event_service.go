@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	eventv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/event/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+type EventService struct {
+	jacuzziv1.UnimplementedEventServiceServer
+	db *gorm.DB
+}
+
+func NewEventService(db *gorm.DB) *EventService {
+	return &EventService{db: db}
+}
+
+func (s *EventService) ListEvents(ctx context.Context, req *eventv1.ListEventsRequest) (*eventv1.ListEventsResponse, error) {
+	if req.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must not be negative")
+	}
+	query := s.db.WithContext(ctx).Model(&models.Event{})
+
+	if len(req.Types) > 0 {
+		// An unknown type matches nothing, so reject typos instead of
+		// returning an empty feed
+		conditions := make([]string, len(req.Types))
+		args := make([]interface{}, len(req.Types))
+		for i, eventType := range req.Types {
+			if !knownEventType(eventType) {
+				return nil, status.Errorf(codes.InvalidArgument, "unknown event type %q", eventType)
+			}
+			if strings.HasSuffix(eventType, ".") {
+				conditions[i] = `type LIKE ? ESCAPE '\'`
+				args[i] = escapeLike(eventType) + "%"
+			} else {
+				conditions[i] = "type = ?"
+				args[i] = eventType
+			}
+		}
+		query = query.Where(strings.Join(conditions, " OR "), args...)
+	}
+	if req.ClientId != "" {
+		query = query.Where("client_id = ?", req.ClientId)
+	}
+	if req.StartTime != nil {
+		query = query.Where("created_at >= ?", req.StartTime.AsTime())
+	}
+	if req.EndTime != nil {
+		query = query.Where("created_at <= ?", req.EndTime.AsTime())
+	}
+
+	var totalCount int64
+	if err := query.Session(&gorm.Session{}).Count(&totalCount).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to count events")
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	// Events recorded in the same instant keep their insertion order
+	var events []models.Event
+	err := query.Order("created_at DESC").Order("id DESC").Limit(limit).Offset(int(req.Offset)).Find(&events).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to list events")
+	}
+
+	protoEvents := make([]*eventv1.Event, len(events))
+	for i := range events {
+		protoEvents[i] = modelToProtoEvent(&events[i])
+	}
+	return &eventv1.ListEventsResponse{
+		Events:     protoEvents,
+		TotalCount: totalCount,
+	}, nil
+}
+
+// knownEventType reports whether an event type, or a type prefix ending in
+// ".", names recorded events
+func knownEventType(eventType string) bool {
+	for _, known := range activity.Types {
+		if known == eventType || strings.HasSuffix(eventType, ".") && strings.HasPrefix(known, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to convert an event to proto
+func modelToProtoEvent(event *models.Event) *eventv1.Event {
+	protoEvent := &eventv1.Event{
+		Id:       event.EventID,
+		Type:     event.Type,
+		Time:     timestamppb.New(event.CreatedAt),
+		ClientId: event.ClientID,
+		SensorId: event.SensorID,
+		RuleId:   event.RuleID,
+		AlertId:  event.AlertID,
+		Message:  event.Message,
+	}
+	if event.Details != "" {
+		// Details are always written by activity.Record, so a decode failure
+		// only loses the details
+		json.Unmarshal([]byte(event.Details), &protoEvent.Details)
+	}
+	return protoEvent
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	jobv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/job/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/jobs"
+	"github.com/nickheyer/jacuzzi/pkg/server/maintenance"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/rollup"
+	"github.com/nickheyer/jacuzzi/pkg/server/usage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+type JobService struct {
+	jacuzziv1.UnimplementedJobServiceServer
+	db        *gorm.DB
+	scheduler *jobs.Scheduler
+	rollups   *rollup.Worker // nil when rollups are disabled
+	quota     int64          // database.size_quota, 0 for none
+}
+
+func NewJobService(db *gorm.DB, scheduler *jobs.Scheduler, rollups *rollup.Worker, quota int64) *JobService {
+	return &JobService{db: db, scheduler: scheduler, rollups: rollups, quota: quota}
+}
+
+func (s *JobService) ListJobs(ctx context.Context, req *jobv1.ListJobsRequest) (*jobv1.ListJobsResponse, error) {
+	// Records are written by the scheduler on the leader, so every replica
+	// lists the same runs
+	var records []models.Job
+	if err := s.db.WithContext(ctx).Order("name").Find(&records).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list jobs")
+	}
+
+	protoJobs := make([]*jobv1.Job, len(records))
+	for i := range records {
+		protoJobs[i] = modelToProtoJob(&records[i])
+	}
+	return &jobv1.ListJobsResponse{Jobs: protoJobs}, nil
+}
+
+func (s *JobService) RunJobNow(ctx context.Context, req *jobv1.RunJobNowRequest) (*jobv1.RunJobNowResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "job name is required")
+	}
+
+	err := s.scheduler.RunNow(ctx, req.Name)
+	switch {
+	case errors.Is(err, jobs.ErrUnknownJob):
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.Name)
+	case errors.Is(err, jobs.ErrNotRunning), errors.Is(err, jobs.ErrJobRunning):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return nil, status.Errorf(codes.DeadlineExceeded, "job %s is still running", req.Name)
+	case err != nil:
+		return nil, apierror.Wrap(err, "failed to run job")
+	}
+
+	// The run's outcome, including a failure, is in its record
+	var record models.Job
+	if err := s.db.WithContext(ctx).Where("name = ?", req.Name).First(&record).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to get job")
+	}
+	return &jobv1.RunJobNowResponse{Job: modelToProtoJob(&record)}, nil
+}
+
+func (s *JobService) RunDataMaintenance(ctx context.Context, req *jobv1.RunDataMaintenanceRequest) (*jobv1.RunDataMaintenanceResponse, error) {
+	// Runs here rather than on the leader's scheduler so the rows changed can
+	// be reported; pruning and rollups are safe to repeat concurrently
+	started := time.Now()
+	result, err := maintenance.Run(ctx, s.db, s.rollups, !req.SkipVacuum)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to run data maintenance")
+	}
+	return &jobv1.RunDataMaintenanceResponse{
+		ReadingsDeleted:      result.ReadingsDeleted,
+		EventsDeleted:        result.EventsDeleted,
+		RollupBucketsUpdated: int32(result.RollupBuckets),
+		Vacuumed:             result.Optimized,
+		RetentionDays:        int32(result.RetentionDays),
+		DurationMs:           time.Since(started).Milliseconds(),
+		BurstReadingsDeleted: result.BurstReadingsDeleted,
+		BurstRetentionHours:  int32(result.BurstRetentionHours),
+	}, nil
+}
+
+func (s *JobService) GetDatabaseStats(ctx context.Context, req *jobv1.GetDatabaseStatsRequest) (*jobv1.GetDatabaseStatsResponse, error) {
+	stats, err := maintenance.GetStats(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to get database stats")
+	}
+
+	resp := &jobv1.GetDatabaseStatsResponse{
+		Backend:    stats.Backend,
+		SizeBytes:  stats.SizeBytes,
+		FreeBytes:  stats.FreeBytes,
+		AutoVacuum: stats.AutoVacuum,
+		Tables:     make([]*jobv1.TableStats, len(stats.Tables)),
+	}
+	for i, table := range stats.Tables {
+		resp.Tables[i] = &jobv1.TableStats{Name: table.Name, Rows: table.Rows}
+	}
+	if stats.OldestReading != nil {
+		resp.OldestReading = timestamppb.New(*stats.OldestReading)
+	}
+	if stats.NewestReading != nil {
+		resp.NewestReading = timestamppb.New(*stats.NewestReading)
+	}
+	return resp, nil
+}
+
+func (s *JobService) GetStorageForecast(ctx context.Context, req *jobv1.GetStorageForecastRequest) (*jobv1.GetStorageForecastResponse, error) {
+	days := int(req.Days)
+	if days < 0 || days > 3650 {
+		return nil, status.Error(codes.InvalidArgument, "days must be between 0 and 3650")
+	}
+	if days == 0 {
+		days = 30
+	}
+
+	forecast, err := maintenance.GetForecast(s.db.WithContext(ctx), s.quota, days)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to forecast storage")
+	}
+
+	resp := &jobv1.GetStorageForecastResponse{
+		UsedBytes:         forecast.UsedBytes,
+		ReadingsPerDay:    forecast.ReadingsPerDay,
+		RollupsPerDay:     forecast.RollupsPerDay,
+		BytesPerRow:       forecast.BytesPerRow,
+		GrowthBytesPerDay: forecast.GrowthBytesPerDay,
+		RetentionDays:     int32(forecast.RetentionDays),
+		ProjectedAt:       timestamppb.New(forecast.ProjectedAt),
+		ProjectedBytes:    forecast.ProjectedBytes,
+		CapacityBytes:     forecast.CapacityBytes,
+		CapacityLimit:     forecast.CapacityLimit,
+		Warning:           forecast.Warning,
+	}
+	if forecast.SteadyAt != nil {
+		resp.SteadyAt = timestamppb.New(*forecast.SteadyAt)
+	}
+	if forecast.FullAt != nil {
+		resp.FullAt = timestamppb.New(*forecast.FullAt)
+	}
+	return resp, nil
+}
+
+func (s *JobService) GetUsageStats(ctx context.Context, req *jobv1.GetUsageStatsRequest) (*jobv1.GetUsageStatsResponse, error) {
+	end := time.Now()
+	if req.EndTime != nil {
+		end = req.EndTime.AsTime()
+	}
+	start := end.AddDate(0, 0, -7)
+	if req.StartTime != nil {
+		start = req.StartTime.AsTime()
+	}
+	if !start.Before(end) {
+		return nil, status.Error(codes.InvalidArgument, "start_time must be before end_time")
+	}
+	if end.Sub(start) > usage.MaxDays*24*time.Hour {
+		return nil, status.Errorf(codes.InvalidArgument, "range must not exceed %d days", usage.MaxDays)
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = 50
+	}
+
+	stats, err := usage.GetStats(s.db.WithContext(ctx), start, end, limit)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to get usage stats")
+	}
+
+	resp := &jobv1.GetUsageStatsResponse{
+		StartTime:          timestamppb.New(stats.Start),
+		EndTime:            timestamppb.New(stats.End),
+		TotalRequests:      stats.Total.Requests,
+		TotalRequestBytes:  stats.Total.RequestBytes,
+		TotalResponseBytes: stats.Total.ResponseBytes,
+		BytesPerReading:    stats.BytesPerReading,
+	}
+	for _, m := range stats.Methods {
+		resp.Methods = append(resp.Methods, &jobv1.MethodUsage{
+			Method:        m.Method,
+			Requests:      m.Requests,
+			Errors:        m.Errors,
+			RequestBytes:  m.RequestBytes,
+			ResponseBytes: m.ResponseBytes,
+		})
+	}
+	for _, c := range stats.Callers {
+		resp.Callers = append(resp.Callers, &jobv1.CallerUsage{
+			Caller:        c.Caller,
+			Requests:      c.Requests,
+			Errors:        c.Errors,
+			RequestBytes:  c.RequestBytes,
+			ResponseBytes: c.ResponseBytes,
+			TopMethod:     c.TopMethod,
+		})
+	}
+	for _, day := range stats.ClientDays {
+		resp.ClientDays = append(resp.ClientDays, &jobv1.ClientDayUsage{
+			ClientId:       day.ClientID,
+			Day:            day.Day.Format(time.DateOnly),
+			Readings:       day.Readings,
+			EstimatedBytes: day.Readings * stats.BytesPerReading,
+		})
+	}
+	return resp, nil
+}
+
+// Helper function to convert a job record to proto
+func modelToProtoJob(job *models.Job) *jobv1.Job {
+	protoJob := &jobv1.Job{
+		Name:           job.Name,
+		Schedule:       job.Schedule,
+		Running:        job.Running,
+		LastDurationMs: job.LastDuration,
+		LastError:      job.LastError,
+		RunCount:       job.RunCount,
+		FailureCount:   job.FailureCount,
+	}
+	if job.LastRunAt != nil {
+		protoJob.LastRunAt = timestamppb.New(*job.LastRunAt)
+	}
+	if job.LastSuccessAt != nil {
+		protoJob.LastSuccessAt = timestamppb.New(*job.LastSuccessAt)
+	}
+	if job.NextRunAt != nil {
+		protoJob.NextRunAt = timestamppb.New(*job.NextRunAt)
+	}
+	return protoJob
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parseLocale normalizes a locale to store, such as de-AT to de, keeping it
+// empty when unset
+func parseLocale(tag string) (string, error) {
+	if tag == "" {
+		return "", nil
+	}
+	locale, ok := i18n.Parse(tag)
+	if !ok {
+		return "", status.Error(codes.InvalidArgument, (&i18n.UnsupportedError{Tag: tag}).Error())
+	}
+	return string(locale), nil
+}
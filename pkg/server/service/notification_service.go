@@ -0,0 +1,512 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/mail"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	notificationv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/notification/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertevents"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/cron"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/notify"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Config keys of each channel type, like those of rule actions
+var channelConfigs = map[notificationv1.NotificationChannel_Type]actionConfig{
+	notificationv1.NotificationChannel_TYPE_EMAIL: {
+		required: []string{"recipients"}, // Comma separated addresses
+		optional: []string{"subject_prefix"},
+	},
+	notificationv1.NotificationChannel_TYPE_SLACK: {
+		required: []string{"url"},
+	},
+	notificationv1.NotificationChannel_TYPE_WEBHOOK: {
+		required: []string{"url"},
+		optional: []string{"secret"},
+	},
+}
+
+type NotificationService struct {
+	jacuzziv1.UnimplementedNotificationServiceServer
+	db     *gorm.DB
+	router *notify.Router
+}
+
+func NewNotificationService(db *gorm.DB, router *notify.Router) *NotificationService {
+	return &NotificationService{db: db, router: router}
+}
+
+func (s *NotificationService) CreateNotificationChannel(ctx context.Context, req *notificationv1.CreateNotificationChannelRequest) (*notificationv1.CreateNotificationChannelResponse, error) {
+	if req.Channel == nil {
+		return nil, status.Error(codes.InvalidArgument, "channel is required")
+	}
+
+	channel := &models.NotificationChannel{ChannelID: uuid.New().String()}
+	if err := applyNotificationChannel(channel, req.Channel); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(channel).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create channel")
+	}
+
+	return &notificationv1.CreateNotificationChannelResponse{Channel: modelToProtoNotificationChannel(channel)}, nil
+}
+
+func (s *NotificationService) ListNotificationChannels(ctx context.Context, req *notificationv1.ListNotificationChannelsRequest) (*notificationv1.ListNotificationChannelsResponse, error) {
+	var channels []models.NotificationChannel
+	if err := s.db.WithContext(ctx).Order("name, channel_id").Find(&channels).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list channels")
+	}
+
+	held, err := s.heldCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	protoChannels := make([]*notificationv1.NotificationChannel, len(channels))
+	for i := range channels {
+		protoChannels[i] = modelToProtoNotificationChannel(&channels[i])
+		protoChannels[i].Held = held[channels[i].ChannelID]
+	}
+	return &notificationv1.ListNotificationChannelsResponse{Channels: protoChannels}, nil
+}
+
+func (s *NotificationService) UpdateNotificationChannel(ctx context.Context, req *notificationv1.UpdateNotificationChannelRequest) (*notificationv1.UpdateNotificationChannelResponse, error) {
+	if req.Channel == nil || req.Channel.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "channel id is required")
+	}
+
+	channel, err := s.getChannel(ctx, req.Channel.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyNotificationChannel(channel, req.Channel); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Save(channel).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update channel")
+	}
+
+	return &notificationv1.UpdateNotificationChannelResponse{Channel: modelToProtoNotificationChannel(channel)}, nil
+}
+
+func (s *NotificationService) DeleteNotificationChannel(ctx context.Context, req *notificationv1.DeleteNotificationChannelRequest) (*notificationv1.DeleteNotificationChannelResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "channel id is required")
+	}
+
+	// Routes would silently stop sending to it
+	var routes []models.NotificationRoute
+	if err := s.db.WithContext(ctx).Find(&routes).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to load routes")
+	}
+	for _, route := range routes {
+		var channelIDs []string
+		json.Unmarshal([]byte(route.ChannelIDs), &channelIDs)
+		if slices.Contains(channelIDs, req.Id) {
+			return nil, status.Errorf(codes.FailedPrecondition, "channel is used by route %q", route.Name)
+		}
+	}
+
+	var deleted int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("channel_id = ?", req.Id).Delete(&models.NotificationChannel{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return tx.Where("channel_id = ?", req.Id).Delete(&models.HeldNotification{}).Error
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to delete channel")
+	}
+	if deleted == 0 {
+		return nil, status.Error(codes.NotFound, "channel not found")
+	}
+
+	return &notificationv1.DeleteNotificationChannelResponse{
+		Success: true,
+		Message: "Channel deleted successfully",
+	}, nil
+}
+
+func (s *NotificationService) TestNotificationChannel(ctx context.Context, req *notificationv1.TestNotificationChannelRequest) (*notificationv1.TestNotificationChannelResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "channel id is required")
+	}
+
+	channel, err := s.getChannel(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	// A failed delivery is the test's result rather than an RPC error
+	result, err := s.router.Test(ctx, channel)
+	if err != nil {
+		return &notificationv1.TestNotificationChannelResponse{Message: err.Error()}, nil
+	}
+	return &notificationv1.TestNotificationChannelResponse{Success: true, Message: result}, nil
+}
+
+func (s *NotificationService) CreateNotificationRoute(ctx context.Context, req *notificationv1.CreateNotificationRouteRequest) (*notificationv1.CreateNotificationRouteResponse, error) {
+	if req.Route == nil {
+		return nil, status.Error(codes.InvalidArgument, "route is required")
+	}
+
+	route := &models.NotificationRoute{RouteID: uuid.New().String()}
+	if err := s.applyNotificationRoute(ctx, route, req.Route); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(route).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create route")
+	}
+
+	return &notificationv1.CreateNotificationRouteResponse{Route: modelToProtoNotificationRoute(route)}, nil
+}
+
+func (s *NotificationService) ListNotificationRoutes(ctx context.Context, req *notificationv1.ListNotificationRoutesRequest) (*notificationv1.ListNotificationRoutesResponse, error) {
+	var routes []models.NotificationRoute
+	if err := s.db.WithContext(ctx).Order("position, name, route_id").Find(&routes).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list routes")
+	}
+
+	protoRoutes := make([]*notificationv1.NotificationRoute, len(routes))
+	for i := range routes {
+		protoRoutes[i] = modelToProtoNotificationRoute(&routes[i])
+	}
+	return &notificationv1.ListNotificationRoutesResponse{Routes: protoRoutes}, nil
+}
+
+func (s *NotificationService) UpdateNotificationRoute(ctx context.Context, req *notificationv1.UpdateNotificationRouteRequest) (*notificationv1.UpdateNotificationRouteResponse, error) {
+	if req.Route == nil || req.Route.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "route id is required")
+	}
+
+	var route models.NotificationRoute
+	if err := s.db.WithContext(ctx).Where("route_id = ?", req.Route.Id).First(&route).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "route not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get route")
+	}
+	if err := s.applyNotificationRoute(ctx, &route, req.Route); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Save(&route).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update route")
+	}
+
+	return &notificationv1.UpdateNotificationRouteResponse{Route: modelToProtoNotificationRoute(&route)}, nil
+}
+
+func (s *NotificationService) DeleteNotificationRoute(ctx context.Context, req *notificationv1.DeleteNotificationRouteRequest) (*notificationv1.DeleteNotificationRouteResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "route id is required")
+	}
+
+	result := s.db.WithContext(ctx).Where("route_id = ?", req.Id).Delete(&models.NotificationRoute{})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to delete route")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "route not found")
+	}
+
+	return &notificationv1.DeleteNotificationRouteResponse{
+		Success: true,
+		Message: "Route deleted successfully",
+	}, nil
+}
+
+func (s *NotificationService) getChannel(ctx context.Context, channelID string) (*models.NotificationChannel, error) {
+	var channel models.NotificationChannel
+	if err := s.db.WithContext(ctx).Where("channel_id = ?", channelID).First(&channel).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "channel not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get channel")
+	}
+	return &channel, nil
+}
+
+// applyNotificationChannel validates and copies the editable fields of a
+// channel onto its model, keeping stored secrets the request leaves out
+func applyNotificationChannel(channel *models.NotificationChannel, from *notificationv1.NotificationChannel) error {
+	if from.Name == "" {
+		return status.Error(codes.InvalidArgument, "channel name is required")
+	}
+	schema, ok := channelConfigs[from.Type]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown channel type %d", from.Type)
+	}
+	channelType := from.Type.String()
+	if channel.Type != "" && channel.Type != channelType {
+		return status.Error(codes.InvalidArgument, "the type of a channel cannot change")
+	}
+
+	config := make(map[string]string, len(from.Config))
+	for key, value := range from.Config {
+		config[key] = strings.TrimSpace(value)
+	}
+	if channel.Config != "" {
+		var stored map[string]string
+		json.Unmarshal([]byte(channel.Config), &stored)
+		for _, key := range notify.SecretConfig[channelType] {
+			if _, ok := config[key]; !ok && stored[key] != "" {
+				config[key] = stored[key]
+			}
+		}
+	}
+
+	allowed := append(slices.Clone(schema.required), schema.optional...)
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !slices.Contains(allowed, key) {
+			return status.Errorf(codes.InvalidArgument, "%s channels have no config %q", from.Type, key)
+		}
+	}
+	for _, key := range schema.required {
+		if config[key] == "" {
+			return status.Errorf(codes.InvalidArgument, "%s channels need config %q", from.Type, key)
+		}
+	}
+
+	switch from.Type {
+	case notificationv1.NotificationChannel_TYPE_EMAIL:
+		for _, recipient := range strings.Split(config["recipients"], ",") {
+			if _, err := mail.ParseAddress(strings.TrimSpace(recipient)); err != nil {
+				return status.Errorf(codes.InvalidArgument, "invalid recipient %q", strings.TrimSpace(recipient))
+			}
+		}
+	case notificationv1.NotificationChannel_TYPE_SLACK, notificationv1.NotificationChannel_TYPE_WEBHOOK:
+		target, err := url.Parse(config["url"])
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return status.Error(codes.InvalidArgument, "channel url must be an absolute http or https URL")
+		}
+	}
+
+	quietHours := strings.TrimSpace(from.QuietHours)
+	if quietHours != "" {
+		if _, err := cron.Parse(quietHours); err != nil {
+			return status.Errorf(codes.InvalidArgument, "quiet hours: %v", err)
+		}
+	}
+	quietBypass, err := bypassSeverity(from.QuietBypassSeverity, quietHours != "", "quiet bypass severity needs quiet hours")
+	if err != nil {
+		return err
+	}
+	if from.DigestIntervalSeconds < 0 || (from.DigestIntervalSeconds > 0 && from.DigestIntervalSeconds < 60) {
+		return status.Error(codes.InvalidArgument, "digest interval must be at least 60 seconds, or 0 to send notifications at once")
+	}
+	digestBypass, err := bypassSeverity(from.DigestBypassSeverity, from.DigestIntervalSeconds > 0, "digest bypass severity needs a digest interval")
+	if err != nil {
+		return err
+	}
+	locale, err := parseLocale(strings.TrimSpace(from.Locale))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return apierror.Wrap(err, "failed to encode config")
+	}
+	channel.Name = from.Name
+	channel.Type = channelType
+	channel.Config = string(data)
+	channel.Enabled = from.Enabled
+	channel.SendResolved = from.SendResolved
+	channel.QuietHours = quietHours
+	channel.QuietBypassSeverity = quietBypass
+	channel.DigestIntervalSeconds = from.DigestIntervalSeconds
+	channel.DigestBypassSeverity = digestBypass
+	channel.Locale = locale
+	return nil
+}
+
+// bypassSeverity validates the severity from which notifications are sent at
+// once, which only applies when held is set, and returns it as stored
+func bypassSeverity(severity alertv1.Severity, held bool, unheld string) (string, error) {
+	if severity == alertv1.Severity_SEVERITY_UNSPECIFIED {
+		return "", nil
+	}
+	if _, ok := alertv1.Severity_name[int32(severity)]; !ok {
+		return "", status.Errorf(codes.InvalidArgument, "unknown severity %d", severity)
+	}
+	if !held {
+		return "", status.Error(codes.InvalidArgument, unheld)
+	}
+	return severity.String(), nil
+}
+
+// heldCounts counts the notifications each channel holds
+func (s *NotificationService) heldCounts(ctx context.Context) (map[string]int32, error) {
+	var rows []struct {
+		ChannelID string
+		Count     int32
+	}
+	err := s.db.WithContext(ctx).Model(&models.HeldNotification{}).
+		Select("channel_id, COUNT(*) AS count").
+		Group("channel_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to count held notifications")
+	}
+	counts := make(map[string]int32, len(rows))
+	for _, row := range rows {
+		counts[row.ChannelID] = row.Count
+	}
+	return counts, nil
+}
+
+func modelToProtoNotificationChannel(channel *models.NotificationChannel) *notificationv1.NotificationChannel {
+	// Config is validated when stored
+	var config map[string]string
+	json.Unmarshal([]byte(channel.Config), &config)
+	for _, key := range notify.SecretConfig[channel.Type] {
+		delete(config, key)
+	}
+	protoChannel := &notificationv1.NotificationChannel{
+		Id:                    channel.ChannelID,
+		Name:                  channel.Name,
+		Type:                  notificationv1.NotificationChannel_Type(notificationv1.NotificationChannel_Type_value[channel.Type]),
+		Config:                config,
+		Enabled:               channel.Enabled,
+		SendResolved:          channel.SendResolved,
+		LastStatus:            channel.LastStatus,
+		CreatedAt:             timestamppb.New(channel.CreatedAt),
+		UpdatedAt:             timestamppb.New(channel.UpdatedAt),
+		QuietHours:            channel.QuietHours,
+		DigestIntervalSeconds: channel.DigestIntervalSeconds,
+		Locale:                channel.Locale,
+	}
+	if channel.QuietBypassSeverity != "" {
+		protoChannel.QuietBypassSeverity = alertevents.ParseSeverity(channel.QuietBypassSeverity)
+	}
+	if channel.DigestBypassSeverity != "" {
+		protoChannel.DigestBypassSeverity = alertevents.ParseSeverity(channel.DigestBypassSeverity)
+	}
+	if channel.LastDeliveryAt != nil {
+		protoChannel.LastDeliveryAt = timestamppb.New(*channel.LastDeliveryAt)
+	}
+	return protoChannel
+}
+
+// applyNotificationRoute validates and copies the fields of a route onto its
+// model
+func (s *NotificationService) applyNotificationRoute(ctx context.Context, route *models.NotificationRoute, from *notificationv1.NotificationRoute) error {
+	if from.Name == "" {
+		return status.Error(codes.InvalidArgument, "route name is required")
+	}
+	if len(from.ChannelIds) == 0 {
+		return status.Error(codes.InvalidArgument, "route needs at least one channel")
+	}
+	var found int64
+	err := s.db.WithContext(ctx).Model(&models.NotificationChannel{}).Where("channel_id IN ?", from.ChannelIds).Count(&found).Error
+	if err != nil {
+		return apierror.Wrap(err, "failed to check channels")
+	}
+	channelIDs := slices.Compact(slices.Sorted(slices.Values(from.ChannelIds)))
+	if int(found) != len(channelIDs) {
+		return status.Error(codes.InvalidArgument, "route names a channel that does not exist")
+	}
+
+	var severities []string
+	for _, severity := range from.Severities {
+		if _, ok := alertv1.Severity_name[int32(severity)]; !ok || severity == alertv1.Severity_SEVERITY_UNSPECIFIED {
+			return status.Errorf(codes.InvalidArgument, "unknown severity %d", severity)
+		}
+		if !slices.Contains(severities, severity.String()) {
+			severities = append(severities, severity.String())
+		}
+	}
+	var sensorTypes []string
+	for _, sensorType := range from.SensorTypes {
+		if sensorType = sensortype.Filter(sensorType); sensorType != "" && !slices.Contains(sensorTypes, sensorType) {
+			sensorTypes = append(sensorTypes, sensorType)
+		}
+	}
+	for key := range from.MetadataSelector {
+		if key == "" {
+			return status.Error(codes.InvalidArgument, "metadata selector keys must not be empty")
+		}
+	}
+
+	encode := func(value interface{}, empty bool) (string, error) {
+		if empty {
+			return "", nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", apierror.Wrap(err, "failed to encode route")
+		}
+		return string(data), nil
+	}
+	if route.Severities, err = encode(severities, len(severities) == 0); err != nil {
+		return err
+	}
+	if route.SensorTypes, err = encode(sensorTypes, len(sensorTypes) == 0); err != nil {
+		return err
+	}
+	if route.MetadataSelector, err = encode(from.MetadataSelector, len(from.MetadataSelector) == 0); err != nil {
+		return err
+	}
+	if route.ChannelIDs, err = encode(from.ChannelIds, false); err != nil {
+		return err
+	}
+	route.Name = from.Name
+	route.Position = from.Position
+	route.Stop = from.Stop
+	route.Enabled = from.Enabled
+	return nil
+}
+
+func modelToProtoNotificationRoute(route *models.NotificationRoute) *notificationv1.NotificationRoute {
+	// Matchers are validated when stored
+	var severities, sensorTypes, channelIDs []string
+	var selector map[string]string
+	json.Unmarshal([]byte(route.ChannelIDs), &channelIDs)
+	if route.Severities != "" {
+		json.Unmarshal([]byte(route.Severities), &severities)
+	}
+	if route.SensorTypes != "" {
+		json.Unmarshal([]byte(route.SensorTypes), &sensorTypes)
+	}
+	if route.MetadataSelector != "" {
+		json.Unmarshal([]byte(route.MetadataSelector), &selector)
+	}
+	protoSeverities := make([]alertv1.Severity, len(severities))
+	for i, severity := range severities {
+		protoSeverities[i] = alertevents.ParseSeverity(severity)
+	}
+	return &notificationv1.NotificationRoute{
+		Id:               route.RouteID,
+		Name:             route.Name,
+		Position:         route.Position,
+		Severities:       protoSeverities,
+		SensorTypes:      sensorTypes,
+		MetadataSelector: selector,
+		ChannelIds:       channelIDs,
+		Stop:             route.Stop,
+		Enabled:          route.Enabled,
+		CreatedAt:        timestamppb.New(route.CreatedAt),
+		UpdatedAt:        timestamppb.New(route.UpdatedAt),
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	powerv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/power/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/power"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// ipmiInterfaces are the ipmitool interfaces a BMC can be reached through
+var ipmiInterfaces = map[string]bool{"": true, "lan": true, "lanplus": true, "open": true}
+
+// powerActions maps proto power actions to controller actions
+var powerActions = map[powerv1.PowerAction]string{
+	powerv1.PowerAction_POWER_ACTION_WAKE:     power.ActionWake,
+	powerv1.PowerAction_POWER_ACTION_ON:       power.ActionPowerOn,
+	powerv1.PowerAction_POWER_ACTION_OFF:      power.ActionPowerOff,
+	powerv1.PowerAction_POWER_ACTION_SOFT_OFF: power.ActionSoftOff,
+	powerv1.PowerAction_POWER_ACTION_CYCLE:    power.ActionCycle,
+	powerv1.PowerAction_POWER_ACTION_RESET:    power.ActionReset,
+	powerv1.PowerAction_POWER_ACTION_STATUS:   power.ActionStatus,
+}
+
+type PowerServiceConfig struct {
+	Controller *power.Controller // Nil when power actions are disabled
+	Timeout    time.Duration     // How long one power action may take
+	Secrets    *power.Secrets    // Encrypts stored BMC passwords; nil refuses new ones
+}
+
+type PowerService struct {
+	jacuzziv1.UnimplementedPowerServiceServer
+	db  *gorm.DB
+	cfg PowerServiceConfig
+}
+
+func NewPowerService(db *gorm.DB, cfg PowerServiceConfig) *PowerService {
+	return &PowerService{db: db, cfg: cfg}
+}
+
+func (s *PowerService) GetPowerConfig(ctx context.Context, req *powerv1.GetPowerConfigRequest) (*powerv1.GetPowerConfigResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+
+	config, err := s.getPowerConfig(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	return &powerv1.GetPowerConfigResponse{
+		Config:       modelToProtoPowerConfig(config),
+		PowerEnabled: s.cfg.Controller != nil,
+	}, nil
+}
+
+func (s *PowerService) SetPowerConfig(ctx context.Context, req *powerv1.SetPowerConfigRequest) (*powerv1.SetPowerConfigResponse, error) {
+	if req.Config == nil || req.Config.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	from := req.Config
+
+	var mac string
+	if from.MacAddress != "" {
+		hw, err := net.ParseMAC(from.MacAddress)
+		if err != nil || len(hw) != 6 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid mac_address %q", from.MacAddress)
+		}
+		mac = hw.String()
+	}
+	if from.WakeBroadcast != "" {
+		if _, err := power.WakeAddress(from.WakeBroadcast); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid wake_broadcast %q: must be host or host:port", from.WakeBroadcast)
+		}
+	}
+	if !ipmiInterfaces[from.BmcInterface] {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid bmc_interface %q: must be lan, lanplus or open", from.BmcInterface)
+	}
+
+	var client models.Client
+	if err := s.db.WithContext(ctx).Where("client_id = ?", from.ClientId).First(&client).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "client not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get client")
+	}
+
+	var config models.ClientPower
+	err := s.db.WithContext(ctx).Where("client_id = ?", from.ClientId).First(&config).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, apierror.Wrap(err, "failed to get power config")
+	}
+	config.ClientID = from.ClientId
+	config.MACAddress = mac
+	config.WakeBroadcast = from.WakeBroadcast
+	config.BMCAddress = from.BmcAddress
+	config.BMCUsername = from.BmcUsername
+	config.BMCInterface = from.BmcInterface
+	// The password is write-only, so an empty one keeps the stored password
+	switch {
+	case req.ClearBmcPassword:
+		config.BMCPassword = ""
+	case from.BmcPassword != "":
+		if s.cfg.Secrets == nil {
+			return nil, status.Error(codes.FailedPrecondition, "BMC passwords are stored encrypted; set power.secret_key on the server first")
+		}
+		sealed, err := s.cfg.Secrets.Seal(from.BmcPassword)
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to encrypt BMC password")
+		}
+		config.BMCPassword = sealed
+	}
+	if err := s.db.WithContext(ctx).Save(&config).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to save power config")
+	}
+
+	return &powerv1.SetPowerConfigResponse{Config: modelToProtoPowerConfig(&config)}, nil
+}
+
+func (s *PowerService) DeletePowerConfig(ctx context.Context, req *powerv1.DeletePowerConfigRequest) (*powerv1.DeletePowerConfigResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+
+	result := s.db.WithContext(ctx).Where("client_id = ?", req.ClientId).Delete(&models.ClientPower{})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to delete power config")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "power config not found")
+	}
+
+	return &powerv1.DeletePowerConfigResponse{
+		Success: true,
+		Message: "Power config deleted successfully",
+	}, nil
+}
+
+func (s *PowerService) PowerAction(ctx context.Context, req *powerv1.PowerActionRequest) (*powerv1.PowerActionResponse, error) {
+	if s.cfg.Controller == nil {
+		return nil, status.Error(codes.FailedPrecondition, "power actions are disabled on this server; set power.enabled")
+	}
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	action, ok := powerActions[req.Action]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "action is required")
+	}
+
+	config, err := s.getPowerConfig(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	var result string
+	if action == power.ActionWake {
+		if config.MACAddress == "" {
+			return nil, status.Error(codes.FailedPrecondition, "client has no mac_address for Wake-on-LAN")
+		}
+		err = s.cfg.Controller.Wake(ctx, config.MACAddress, config.WakeBroadcast)
+		result = "magic packet sent to " + config.MACAddress
+	} else {
+		if config.BMCAddress == "" {
+			return nil, status.Error(codes.FailedPrecondition, "client has no bmc_address for IPMI")
+		}
+		password, err := s.bmcPassword(config)
+		if err != nil {
+			return nil, err
+		}
+		result, err = s.cfg.Controller.IPMI(ctx, power.BMC{
+			Address:   config.BMCAddress,
+			Username:  config.BMCUsername,
+			Password:  password,
+			Interface: config.BMCInterface,
+		}, action)
+	}
+	// Power actions can take a machine down, so every attempt is logged
+	if err != nil {
+		log.Printf("Power action %s on client %s failed: %v", action, req.ClientId, err)
+		return nil, status.Errorf(codes.Unavailable, "power action %s on client %s failed: %v", action, req.ClientId, err)
+	}
+	log.Printf("Power action %s on client %s: %s", action, req.ClientId, result)
+
+	return &powerv1.PowerActionResponse{Result: result}, nil
+}
+
+func (s *PowerService) getPowerConfig(ctx context.Context, clientID string) (*models.ClientPower, error) {
+	var config models.ClientPower
+	if err := s.db.WithContext(ctx).Where("client_id = ?", clientID).First(&config).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "power config not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get power config")
+	}
+	return &config, nil
+}
+
+// bmcPassword decrypts a client's stored BMC password. Passwords saved in
+// plain text before a secret key was configured are used as they are.
+func (s *PowerService) bmcPassword(config *models.ClientPower) (string, error) {
+	if config.BMCPassword == "" || !power.IsSealed(config.BMCPassword) {
+		return config.BMCPassword, nil
+	}
+	if s.cfg.Secrets == nil {
+		return "", status.Error(codes.FailedPrecondition, "the BMC password is encrypted but power.secret_key is not set")
+	}
+	password, err := s.cfg.Secrets.Open(config.BMCPassword)
+	if err != nil {
+		return "", status.Errorf(codes.FailedPrecondition, "BMC password of client %s: %v", config.ClientID, err)
+	}
+	return password, nil
+}
+
+// EncryptStoredPasswords encrypts BMC passwords saved in plain text before a
+// secret key was configured, or warns about them when there is still none
+func (s *PowerService) EncryptStoredPasswords(ctx context.Context) error {
+	var configs []models.ClientPower
+	err := s.db.WithContext(ctx).
+		Where("bmc_password <> '' AND bmc_password NOT LIKE ?", "enc:%").
+		Find(&configs).Error
+	if err != nil {
+		return fmt.Errorf("failed to load power configs: %w", err)
+	}
+	if len(configs) == 0 {
+		return nil
+	}
+	if s.cfg.Secrets == nil {
+		log.Printf("Warning: %d BMC password(s) are stored in plain text; set power.secret_key to encrypt them", len(configs))
+		return nil
+	}
+	for _, config := range configs {
+		sealed, err := s.cfg.Secrets.Seal(config.BMCPassword)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt BMC password: %w", err)
+		}
+		if err := s.db.WithContext(ctx).Model(&config).UpdateColumn("bmc_password", sealed).Error; err != nil {
+			return fmt.Errorf("failed to save BMC password: %w", err)
+		}
+	}
+	log.Printf("Encrypted %d BMC password(s) stored in plain text", len(configs))
+	return nil
+}
+
+// Helper function to convert power config model to proto, leaving out the
+// BMC password
+func modelToProtoPowerConfig(config *models.ClientPower) *powerv1.PowerConfig {
+	return &powerv1.PowerConfig{
+		ClientId:       config.ClientID,
+		MacAddress:     config.MACAddress,
+		WakeBroadcast:  config.WakeBroadcast,
+		BmcAddress:     config.BMCAddress,
+		BmcUsername:    config.BMCUsername,
+		HasBmcPassword: config.BMCPassword != "",
+		BmcInterface:   config.BMCInterface,
+		UpdatedAt:      timestamppb.New(config.UpdatedAt),
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	settingsv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/settings/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func (s *SettingsService) GetUserPreferences(ctx context.Context, req *settingsv1.GetUserPreferencesRequest) (*settingsv1.GetUserPreferencesResponse, error) {
+	if req.User == "" {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+
+	prefs := models.UserPreferences{Username: req.User}
+	err := s.db.WithContext(ctx).Where("username = ?", req.User).Limit(1).Find(&prefs).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to load user preferences")
+	}
+
+	stored := modelToProtoUserPreferences(&prefs)
+	effective, err := s.effectivePreferences(ctx, stored)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to load settings")
+	}
+	return &settingsv1.GetUserPreferencesResponse{
+		Preferences: stored,
+		Effective:   effective,
+	}, nil
+}
+
+func (s *SettingsService) UpdateUserPreferences(ctx context.Context, req *settingsv1.UpdateUserPreferencesRequest) (*settingsv1.UpdateUserPreferencesResponse, error) {
+	p := req.Preferences
+	if p == nil {
+		return nil, status.Error(codes.InvalidArgument, "preferences are required")
+	}
+	if p.User == "" {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+	switch p.TemperatureUnit {
+	case "", "celsius", "fahrenheit":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid temperature_unit %q: must be celsius or fahrenheit", p.TemperatureUnit)
+	}
+	switch p.Theme {
+	case "", "light", "dark", "system":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid theme %q: must be light, dark, or system", p.Theme)
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid timezone %q", p.Timezone)
+		}
+	}
+	locale, err := parseLocale(p.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.db.WithContext(ctx)
+	if p.DefaultDashboardId != "" {
+		// The default dashboard must be one the user can open
+		var count int64
+		err := db.Model(&models.Dashboard{}).
+			Where("dashboard_id = ? AND (owner = ? OR owner = '' OR shared = ?)", p.DefaultDashboardId, p.User, true).
+			Count(&count).Error
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to check dashboard")
+		}
+		if count == 0 {
+			return nil, status.Error(codes.NotFound, "default dashboard not found")
+		}
+	}
+
+	prefs := models.UserPreferences{
+		Username:           p.User,
+		TemperatureUnit:    p.TemperatureUnit,
+		Theme:              p.Theme,
+		Timezone:           p.Timezone,
+		DefaultDashboardID: p.DefaultDashboardId,
+		Locale:             locale,
+	}
+	err = db.Where("username = ?", p.User).
+		Assign(map[string]interface{}{
+			"temperature_unit":     prefs.TemperatureUnit,
+			"theme":                prefs.Theme,
+			"timezone":             prefs.Timezone,
+			"default_dashboard_id": prefs.DefaultDashboardID,
+			"locale":               prefs.Locale,
+		}).
+		FirstOrCreate(&prefs).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to save user preferences")
+	}
+
+	stored := modelToProtoUserPreferences(&prefs)
+	effective, err := s.effectivePreferences(ctx, stored)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to load settings")
+	}
+	return &settingsv1.UpdateUserPreferencesResponse{
+		Preferences: stored,
+		Effective:   effective,
+	}, nil
+}
+
+// effectivePreferences fills unset preferences from the global settings
+func (s *SettingsService) effectivePreferences(ctx context.Context, prefs *settingsv1.UserPreferences) (*settingsv1.UserPreferences, error) {
+	settings, err := s.loadSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := &settingsv1.UserPreferences{
+		User:               prefs.User,
+		TemperatureUnit:    prefs.TemperatureUnit,
+		Theme:              prefs.Theme,
+		Timezone:           prefs.Timezone,
+		DefaultDashboardId: prefs.DefaultDashboardId,
+		Locale:             prefs.Locale,
+	}
+	if effective.TemperatureUnit == "" {
+		effective.TemperatureUnit = settings.TemperatureUnit
+	}
+	if effective.Theme == "" {
+		effective.Theme = settings.Theme
+	}
+	if effective.Timezone == "" {
+		effective.Timezone = settings.Timezone
+	}
+	if effective.Locale == "" {
+		effective.Locale = settings.Locale
+	}
+	return effective, nil
+}
+
+func modelToProtoUserPreferences(prefs *models.UserPreferences) *settingsv1.UserPreferences {
+	return &settingsv1.UserPreferences{
+		User:               prefs.Username,
+		TemperatureUnit:    prefs.TemperatureUnit,
+		Theme:              prefs.Theme,
+		Timezone:           prefs.Timezone,
+		DefaultDashboardId: prefs.DefaultDashboardID,
+		Locale:             prefs.Locale,
+	}
+}
+
+// clearDefaultDashboard unsets a deleted dashboard wherever it is a user's
+// default, so the UI does not try to open it
+func clearDefaultDashboard(tx *gorm.DB, dashboardID string) error {
+	return tx.Model(&models.UserPreferences{}).
+		Where("default_dashboard_id = ?", dashboardID).
+		Update("default_dashboard_id", "").Error
+}
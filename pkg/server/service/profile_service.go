@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+
+	"github.com/google/uuid"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	profilev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/profile/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/profiles"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+type ProfileService struct {
+	jacuzziv1.UnimplementedProfileServiceServer
+	db *gorm.DB
+}
+
+func NewProfileService(db *gorm.DB) *ProfileService {
+	return &ProfileService{db: db}
+}
+
+func (s *ProfileService) CreateConfigProfile(ctx context.Context, req *profilev1.CreateConfigProfileRequest) (*profilev1.CreateConfigProfileResponse, error) {
+	if req.Profile == nil {
+		return nil, status.Error(codes.InvalidArgument, "profile is required")
+	}
+
+	profile := &models.ConfigProfile{ProfileID: uuid.New().String()}
+	if err := applyConfigProfile(profile, req.Profile); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(profile).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create profile")
+	}
+
+	pushed, err := profiles.PushAll(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to push configurations")
+	}
+	protoProfile, err := modelToProtoConfigProfile(profile)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert profile")
+	}
+	return &profilev1.CreateConfigProfileResponse{Profile: protoProfile, ClientsUpdated: int32(pushed)}, nil
+}
+
+func (s *ProfileService) ListConfigProfiles(ctx context.Context, req *profilev1.ListConfigProfilesRequest) (*profilev1.ListConfigProfilesResponse, error) {
+	var stored []models.ConfigProfile
+	if err := s.db.WithContext(ctx).Order("priority DESC, name, profile_id").Find(&stored).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list profiles")
+	}
+
+	protoProfiles := make([]*profilev1.ConfigProfile, len(stored))
+	for i := range stored {
+		protoProfile, err := modelToProtoConfigProfile(&stored[i])
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to convert profile")
+		}
+		protoProfiles[i] = protoProfile
+	}
+	return &profilev1.ListConfigProfilesResponse{Profiles: protoProfiles}, nil
+}
+
+func (s *ProfileService) UpdateConfigProfile(ctx context.Context, req *profilev1.UpdateConfigProfileRequest) (*profilev1.UpdateConfigProfileResponse, error) {
+	if req.Profile == nil || req.Profile.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "profile id is required")
+	}
+
+	var profile models.ConfigProfile
+	if err := s.db.WithContext(ctx).Where("profile_id = ?", req.Profile.Id).First(&profile).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "profile not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get profile")
+	}
+	if err := applyConfigProfile(&profile, req.Profile); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Save(&profile).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update profile")
+	}
+
+	pushed, err := profiles.PushAll(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to push configurations")
+	}
+	protoProfile, err := modelToProtoConfigProfile(&profile)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to convert profile")
+	}
+	return &profilev1.UpdateConfigProfileResponse{Profile: protoProfile, ClientsUpdated: int32(pushed)}, nil
+}
+
+func (s *ProfileService) DeleteConfigProfile(ctx context.Context, req *profilev1.DeleteConfigProfileRequest) (*profilev1.DeleteConfigProfileResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "profile id is required")
+	}
+
+	result := s.db.WithContext(ctx).Where("profile_id = ?", req.Id).Delete(&models.ConfigProfile{})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to delete profile")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "profile not found")
+	}
+
+	pushed, err := profiles.PushAll(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to push configurations")
+	}
+	return &profilev1.DeleteConfigProfileResponse{
+		Success:        true,
+		Message:        "Profile deleted successfully",
+		ClientsUpdated: int32(pushed),
+	}, nil
+}
+
+func (s *ProfileService) SetClientConfigOverride(ctx context.Context, req *profilev1.SetClientConfigOverrideRequest) (*profilev1.SetClientConfigOverrideResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if err := profiles.Validate(req.Config); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid config: %v", err)
+	}
+	override, err := profiles.Encode(req.Config)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to encode config")
+	}
+
+	client, err := s.client(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Model(client).Update("config_override", override).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update client")
+	}
+	client.ConfigOverride = override
+	if _, err := profiles.Push(s.db.WithContext(ctx), client); err != nil {
+		return nil, apierror.Wrap(err, "failed to push configuration")
+	}
+
+	config, err := s.clientConfig(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return &profilev1.SetClientConfigOverrideResponse{Config: config}, nil
+}
+
+func (s *ProfileService) GetClientConfig(ctx context.Context, req *profilev1.GetClientConfigRequest) (*profilev1.GetClientConfigResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	client, err := s.client(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.clientConfig(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return &profilev1.GetClientConfigResponse{Config: config}, nil
+}
+
+func (s *ProfileService) client(ctx context.Context, clientID string) (*models.Client, error) {
+	var client models.Client
+	if err := s.db.WithContext(ctx).Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "client not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get client")
+	}
+	return &client, nil
+}
+
+func (s *ProfileService) clientConfig(ctx context.Context, client *models.Client) (*profilev1.ClientConfig, error) {
+	resolved, err := profiles.Resolve(s.db.WithContext(ctx), client)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to resolve configuration")
+	}
+	return &profilev1.ClientConfig{
+		ClientId:      client.ClientID,
+		Resolved:      resolved.Config,
+		ProfileIds:    resolved.ProfileIDs,
+		Override:      resolved.Override,
+		AcceptsConfig: slices.Contains(commands.DecodeList(client.Capabilities), models.CapabilityRemoteConfig),
+		Revision:      client.ConfigRevision,
+	}, nil
+}
+
+// applyConfigProfile validates and copies the editable fields of a profile
+// onto its model
+func applyConfigProfile(profile *models.ConfigProfile, from *profilev1.ConfigProfile) error {
+	if from.Name == "" {
+		return status.Error(codes.InvalidArgument, "profile name is required")
+	}
+	if err := profiles.Validate(from.Config); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid config: %v", err)
+	}
+	config, err := profiles.Encode(from.Config)
+	if err != nil {
+		return apierror.Wrap(err, "failed to encode config")
+	}
+	selector := ""
+	if len(from.MetadataSelector) > 0 {
+		data, err := json.Marshal(from.MetadataSelector)
+		if err != nil {
+			return apierror.Wrap(err, "failed to encode metadata selector")
+		}
+		selector = string(data)
+	}
+
+	profile.Name = from.Name
+	profile.Description = from.Description
+	profile.Site = from.Site
+	profile.MetadataSelector = selector
+	profile.Priority = from.Priority
+	profile.Config = config
+	return nil
+}
+
+func modelToProtoConfigProfile(profile *models.ConfigProfile) (*profilev1.ConfigProfile, error) {
+	config, err := profiles.Decode(profile.Config)
+	if err != nil {
+		return nil, err
+	}
+	protoProfile := &profilev1.ConfigProfile{
+		Id:          profile.ProfileID,
+		Name:        profile.Name,
+		Description: profile.Description,
+		Site:        profile.Site,
+		Priority:    profile.Priority,
+		Config:      config,
+		CreatedAt:   timestamppb.New(profile.CreatedAt),
+		UpdatedAt:   timestamppb.New(profile.UpdatedAt),
+	}
+	if profile.MetadataSelector != "" {
+		if err := json.Unmarshal([]byte(profile.MetadataSelector), &protoProfile.MetadataSelector); err != nil {
+			return nil, err
+		}
+	}
+	return protoProfile, nil
+}
+
+// pushConfig queues a client's configuration after a change that may move it
+// between profiles. The change itself stands, so a failure is only logged.
+func pushConfig(db *gorm.DB, client *models.Client) {
+	if _, err := profiles.Push(db, client); err != nil {
+		log.Printf("Failed to push configuration to client %s: %v", client.ClientID, err)
+	}
+}
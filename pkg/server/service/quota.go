@@ -0,0 +1,78 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+)
+
+// Quota actions for clients that exceed their ingestion quota
+const (
+	QuotaThrottle = "throttle" // Store readings up to the quota and drop the rest
+	QuotaReject   = "reject"   // Fail the whole request
+)
+
+// Quota names used in violation metrics
+const (
+	quotaReadingsPerMinute = "readings_per_minute"
+	quotaMaxSensors        = "max_sensors"
+)
+
+var quotaViolations = metrics.NewCounterVec(
+	"jacuzzi_ingest_quota_violations_total",
+	"Readings dropped or rejected because a client exceeded an ingestion quota.",
+	"client_id", "quota", "action",
+)
+
+// quotaLimiter is a per-client token bucket refilled at a fixed rate per minute
+type quotaLimiter struct {
+	mu        sync.Mutex
+	perMinute float64
+	buckets   map[string]*quotaBucket
+}
+
+type quotaBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newQuotaLimiter(perMinute int) *quotaLimiter {
+	return &quotaLimiter{
+		perMinute: float64(perMinute),
+		buckets:   make(map[string]*quotaBucket),
+	}
+}
+
+// Take consumes up to n tokens for a client and returns how many were granted.
+// When partial is false, either all n are granted or none are.
+func (l *quotaLimiter) Take(clientID string, n int, partial bool, now time.Time) int {
+	if l.perMinute <= 0 {
+		return n
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[clientID]
+	if !ok {
+		b = &quotaBucket{tokens: l.perMinute, last: now}
+		l.buckets[clientID] = b
+	} else {
+		b.tokens += now.Sub(b.last).Minutes() * l.perMinute
+		if b.tokens > l.perMinute {
+			b.tokens = l.perMinute
+		}
+		b.last = now
+	}
+
+	granted := n
+	if float64(n) > b.tokens {
+		if !partial {
+			return 0
+		}
+		granted = int(b.tokens)
+	}
+	b.tokens -= float64(granted)
+	return granted
+}
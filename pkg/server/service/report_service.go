@@ -0,0 +1,164 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	reportv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/report/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/report"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// ReportServiceConfig controls where generated reports are downloaded from
+type ReportServiceConfig struct {
+	Signer  *report.Signer
+	URLTTL  time.Duration // How long download links and stored reports last
+	BaseURL string        // Prefixed to download paths; empty for relative URLs
+}
+
+type ReportService struct {
+	jacuzziv1.UnimplementedReportServiceServer
+	db  *gorm.DB
+	cfg ReportServiceConfig
+}
+
+func NewReportService(db *gorm.DB, cfg ReportServiceConfig) *ReportService {
+	return &ReportService{db: db, cfg: cfg}
+}
+
+func (s *ReportService) GenerateReport(ctx context.Context, req *reportv1.GenerateReportRequest) (*reportv1.GenerateReportResponse, error) {
+	loc, err := resolveTimezone(s.db.WithContext(ctx), req.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	var start, end time.Time
+	if req.Period != reportv1.ReportPeriod_REPORT_PERIOD_UNSPECIFIED {
+		if req.StartTime != nil || req.EndTime != nil {
+			return nil, status.Error(codes.InvalidArgument, "period cannot be combined with start_time or end_time")
+		}
+		start, end, err = reportPeriod(req.Period, time.Now(), loc)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if req.StartTime == nil {
+			return nil, status.Error(codes.InvalidArgument, "start_time is required")
+		}
+		start = req.StartTime.AsTime()
+		end = time.Now()
+		if req.EndTime != nil {
+			end = req.EndTime.AsTime()
+		}
+	}
+	if !start.Before(end) {
+		return nil, status.Error(codes.InvalidArgument, "start_time must be before end_time")
+	}
+	if end.Sub(start) > report.MaxRange {
+		return nil, status.Errorf(codes.InvalidArgument, "report range must be at most %d days", int(report.MaxRange/(24*time.Hour)))
+	}
+
+	locale, err := parseLocale(req.Locale)
+	if err != nil {
+		return nil, err
+	}
+	data, err := report.Build(ctx, s.db, report.Params{
+		Title:            req.Title,
+		Start:            start,
+		End:              end,
+		ClientIDs:        req.ClientIds,
+		MetadataSelector: req.MetadataSelector,
+		Location:         loc,
+		Locale:           i18n.Locale(locale),
+	})
+	if err != nil {
+		var unknown *report.UnknownClientError
+		if errors.As(err, &unknown) {
+			return nil, status.Error(codes.NotFound, unknown.Error())
+		}
+		return nil, apierror.Wrap(err, "failed to build report")
+	}
+
+	var buf bytes.Buffer
+	var contentType, ext string
+	switch req.Format {
+	case reportv1.ReportFormat_REPORT_FORMAT_UNSPECIFIED, reportv1.ReportFormat_REPORT_FORMAT_PDF:
+		contentType, ext = "application/pdf", "pdf"
+		err = report.WritePDF(&buf, data)
+	case reportv1.ReportFormat_REPORT_FORMAT_CSV:
+		contentType, ext = "application/zip", "zip"
+		err = report.WriteCSV(&buf, data)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported report format %v", req.Format)
+	}
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to write report")
+	}
+
+	now := time.Now()
+	stored := &models.Report{
+		ID:          uuid.New().String(),
+		Filename:    fmt.Sprintf("thermal-report-%s-%s.%s", start.In(data.Location).Format("20060102"), end.In(data.Location).Format("20060102"), ext),
+		ContentType: contentType,
+		Data:        buf.Bytes(),
+		ExpiresAt:   now.Add(s.cfg.URLTTL),
+	}
+	if err := s.db.WithContext(ctx).Create(stored).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to store report")
+	}
+
+	// Expired reports are only ever removed here, so storage stays bounded by
+	// what was generated within one TTL
+	if err := s.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.Report{}).Error; err != nil {
+		log.Printf("Failed to delete expired reports: %v", err)
+	}
+
+	sensorCount := 0
+	for _, client := range data.Clients {
+		sensorCount += len(client.Sensors)
+	}
+	return &reportv1.GenerateReportResponse{
+		ReportId:    stored.ID,
+		DownloadUrl: strings.TrimSuffix(s.cfg.BaseURL, "/") + s.cfg.Signer.URL(stored.ID, stored.ExpiresAt),
+		ExpiresAt:   timestamppb.New(stored.ExpiresAt),
+		Filename:    stored.Filename,
+		ContentType: contentType,
+		SizeBytes:   int64(len(stored.Data)),
+		ClientCount: int32(len(data.Clients)),
+		SensorCount: int32(sensorCount),
+	}, nil
+}
+
+// reportPeriod returns the bounds of the whole local calendar period before
+// the one containing now. The end is just before the next period starts,
+// since reports include readings at their end time. Bounds are in UTC, as
+// SQLite compares timestamps as text.
+func reportPeriod(period reportv1.ReportPeriod, now time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	var unit timezone.Unit
+	switch period {
+	case reportv1.ReportPeriod_REPORT_PERIOD_PREVIOUS_DAY:
+		unit = timezone.Day
+	case reportv1.ReportPeriod_REPORT_PERIOD_PREVIOUS_WEEK:
+		unit = timezone.Week
+	case reportv1.ReportPeriod_REPORT_PERIOD_PREVIOUS_MONTH:
+		unit = timezone.Month
+	default:
+		return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "unsupported report period %v", period)
+	}
+	current := timezone.Floor(now, unit, loc)
+	return timezone.Previous(current, unit, loc).UTC(), current.Add(-time.Microsecond).UTC(), nil
+}
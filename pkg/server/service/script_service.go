@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	scriptv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/script/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/scripting"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Limits on the size of a script
+const (
+	maxScriptName   = 128
+	maxScriptSource = 64 * 1024
+)
+
+type ScriptServiceConfig struct {
+	Enabled bool // Whether the server runs scripts
+}
+
+type ScriptService struct {
+	jacuzziv1.UnimplementedScriptServiceServer
+	db  *gorm.DB
+	cfg ScriptServiceConfig
+}
+
+func NewScriptService(db *gorm.DB, cfg ScriptServiceConfig) *ScriptService {
+	return &ScriptService{db: db, cfg: cfg}
+}
+
+func (s *ScriptService) CreateScript(ctx context.Context, req *scriptv1.CreateScriptRequest) (*scriptv1.CreateScriptResponse, error) {
+	if req.Script == nil {
+		return nil, status.Error(codes.InvalidArgument, "script is required")
+	}
+
+	script := &models.Script{ScriptID: uuid.New().String()}
+	if err := applyScript(script, req.Script); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(script).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create script")
+	}
+
+	return &scriptv1.CreateScriptResponse{Script: modelToProtoScript(script)}, nil
+}
+
+func (s *ScriptService) ListScripts(ctx context.Context, req *scriptv1.ListScriptsRequest) (*scriptv1.ListScriptsResponse, error) {
+	var scripts []models.Script
+	if err := s.db.WithContext(ctx).Order("name, script_id").Find(&scripts).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list scripts")
+	}
+
+	protoScripts := make([]*scriptv1.Script, len(scripts))
+	for i := range scripts {
+		protoScripts[i] = modelToProtoScript(&scripts[i])
+	}
+	return &scriptv1.ListScriptsResponse{
+		Scripts:          protoScripts,
+		ScriptingEnabled: s.cfg.Enabled,
+	}, nil
+}
+
+func (s *ScriptService) UpdateScript(ctx context.Context, req *scriptv1.UpdateScriptRequest) (*scriptv1.UpdateScriptResponse, error) {
+	if req.Script == nil || req.Script.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "script id is required")
+	}
+
+	var script models.Script
+	if err := s.db.WithContext(ctx).Where("script_id = ?", req.Script.Id).First(&script).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "script not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get script")
+	}
+	if err := applyScript(&script, req.Script); err != nil {
+		return nil, err
+	}
+	// The error of the previous version no longer applies
+	script.LastError = ""
+	script.LastErrorAt = nil
+	if err := s.db.WithContext(ctx).Save(&script).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update script")
+	}
+
+	return &scriptv1.UpdateScriptResponse{Script: modelToProtoScript(&script)}, nil
+}
+
+func (s *ScriptService) DeleteScript(ctx context.Context, req *scriptv1.DeleteScriptRequest) (*scriptv1.DeleteScriptResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "script id is required")
+	}
+
+	result := s.db.WithContext(ctx).Where("script_id = ?", req.Id).Delete(&models.Script{})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to delete script")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "script not found")
+	}
+
+	return &scriptv1.DeleteScriptResponse{
+		Success: true,
+		Message: "Script deleted successfully",
+	}, nil
+}
+
+// applyScript validates and copies the editable fields of a script onto its
+// model, rejecting source that does not parse
+func applyScript(script *models.Script, from *scriptv1.Script) error {
+	if from.Name == "" {
+		return status.Error(codes.InvalidArgument, "script name is required")
+	}
+	if len(from.Name) > maxScriptName {
+		return status.Errorf(codes.InvalidArgument, "script name is longer than %d bytes", maxScriptName)
+	}
+	if len(from.Source) > maxScriptSource {
+		return status.Errorf(codes.InvalidArgument, "script source is longer than %d bytes", maxScriptSource)
+	}
+	handlers, err := scripting.Check(from.Name, from.Source)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid script source: %v", err)
+	}
+	if len(handlers) == 0 {
+		return status.Errorf(codes.InvalidArgument, "script defines no handler; define one of %v", scripting.Handlers)
+	}
+
+	script.Name = from.Name
+	script.Description = from.Description
+	script.Source = from.Source
+	script.Enabled = from.Enabled
+	return nil
+}
+
+func modelToProtoScript(script *models.Script) *scriptv1.Script {
+	// Stored source was checked when saved
+	handlers, _ := scripting.Check(script.Name, script.Source)
+	protoScript := &scriptv1.Script{
+		Id:          script.ScriptID,
+		Name:        script.Name,
+		Description: script.Description,
+		Source:      script.Source,
+		Enabled:     script.Enabled,
+		Handlers:    handlers,
+		LastError:   script.LastError,
+		CreatedAt:   timestamppb.New(script.CreatedAt),
+		UpdatedAt:   timestamppb.New(script.UpdatedAt),
+	}
+	if script.LastErrorAt != nil {
+		protoScript.LastErrorAt = timestamppb.New(*script.LastErrorAt)
+	}
+	return protoScript
+}
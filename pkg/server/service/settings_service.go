@@ -3,31 +3,46 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"strings"
+	"time"
 
 	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
 	settingsv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/settings/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
 	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/mute"
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 )
 
+// SettingsServiceConfig holds the settings service's dependencies
+type SettingsServiceConfig struct {
+	// Notified when settings change; nil disables
+	Webhooks *webhook.Dispatcher
+}
+
 type SettingsService struct {
 	jacuzziv1.UnimplementedSettingsServiceServer
-	db *gorm.DB
+	db  *gorm.DB
+	cfg SettingsServiceConfig
 }
 
-func NewSettingsService(db *gorm.DB) *SettingsService {
+func NewSettingsService(db *gorm.DB, cfg SettingsServiceConfig) *SettingsService {
 	// Initialize default settings on service creation
-	service := &SettingsService{db: db}
+	service := &SettingsService{db: db, cfg: cfg}
 	service.initializeDefaultSettings()
 	return service
 }
 
 func (s *SettingsService) GetSettings(ctx context.Context, req *settingsv1.GetSettingsRequest) (*settingsv1.GetSettingsResponse, error) {
-	settings, err := s.loadSettings()
+	settings, err := s.loadSettings(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to load settings: %v", err)
+		return nil, apierror.Wrap(err, "failed to load settings")
 	}
 	
 	return &settingsv1.GetSettingsResponse{
@@ -39,10 +54,30 @@ func (s *SettingsService) UpdateSettings(ctx context.Context, req *settingsv1.Up
 	if req.Settings == nil {
 		return nil, status.Error(codes.InvalidArgument, "settings are required")
 	}
+	// Stats, reports and the status page fall back to UTC for unknown zones,
+	// so catch typos here instead
+	if _, err := time.LoadLocation(req.Settings.Timezone); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown timezone %q", req.Settings.Timezone)
+	}
+	locale, err := parseLocale(req.Settings.Locale)
+	if err != nil {
+		return nil, err
+	}
+	req.Settings.Locale = locale
+	if req.Settings.AlertFlapThreshold < 0 {
+		return nil, status.Error(codes.InvalidArgument, "alert_flap_threshold must not be negative")
+	}
 	
-	err := s.saveSettings(req.Settings)
+	changed, err := s.saveSettings(ctx, req.Settings)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to update settings: %v", err)
+		return nil, apierror.Wrap(err, "failed to update settings")
+	}
+	if len(changed) > 0 {
+		activity.Record(s.db.WithContext(ctx), activity.Event{
+			Type:    activity.SettingsChanged,
+			Message: "Changed " + strings.Join(changed, ", "),
+		})
+		s.cfg.Webhooks.SettingsChanged(changed)
 	}
 	
 	return &settingsv1.UpdateSettingsResponse{
@@ -52,11 +87,11 @@ func (s *SettingsService) UpdateSettings(ctx context.Context, req *settingsv1.Up
 }
 
 // Helper function to load settings from database
-func (s *SettingsService) loadSettings() (*settingsv1.Settings, error) {
+func (s *SettingsService) loadSettings(ctx context.Context) (*settingsv1.Settings, error) {
 	settingsMap := make(map[string]string)
 	
 	var dbSettings []models.Setting
-	if err := s.db.Find(&dbSettings).Error; err != nil {
+	if err := s.db.WithContext(ctx).Find(&dbSettings).Error; err != nil {
 		return nil, err
 	}
 	
@@ -68,12 +103,16 @@ func (s *SettingsService) loadSettings() (*settingsv1.Settings, error) {
 	settings := &settingsv1.Settings{
 		SiteName:                    s.getStringSetting(settingsMap, "general.site_name", "Jacuzzi"),
 		Timezone:                    s.getStringSetting(settingsMap, "general.timezone", "UTC"),
-		RetentionDays:               int32(s.getIntSetting(settingsMap, "data.retention_days", 30)),
+		Locale:                      s.getStringSetting(settingsMap, models.SettingLocale, string(i18n.Default)),
+		RetentionDays:               int32(s.getIntSetting(settingsMap, "data.retention_days", models.DefaultDataRetentionDays)),
+		BurstRetentionHours:         int32(s.getIntSetting(settingsMap, models.SettingDataBurstRetentionHours, models.DefaultBurstRetentionHours)),
 		AggregationIntervalSeconds:  int32(s.getIntSetting(settingsMap, "data.aggregation_interval_seconds", 60)),
 		TemperatureUnit:             s.getStringSetting(settingsMap, "display.temperature_unit", "celsius"),
 		Theme:                       s.getStringSetting(settingsMap, "display.theme", "system"),
 		AlertsEnabled:               s.getBoolSetting(settingsMap, "alerts.enabled", true),
 		AlertCheckIntervalSeconds:   int32(s.getIntSetting(settingsMap, "alerts.check_interval_seconds", 60)),
+		AlertFlapThreshold:          int32(s.getIntSetting(settingsMap, models.SettingAlertsFlapThreshold, models.DefaultAlertFlapThreshold)),
+		AlertContextMinutes:         int32(s.getIntSetting(settingsMap, models.SettingAlertsContextMinutes, models.DefaultAlertContextMinutes)),
 		MaxConcurrentClients:        int32(s.getIntSetting(settingsMap, "performance.max_concurrent_clients", 100)),
 		ApiRateLimit:                int32(s.getIntSetting(settingsMap, "performance.api_rate_limit", 1000)),
 	}
@@ -96,20 +135,35 @@ func (s *SettingsService) loadSettings() (*settingsv1.Settings, error) {
 	
 	settings.EmailSettings = emailSettings
 	
+	// An expired mute reads as unmuted
+	global, err := mute.Get(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if !global.Until.IsZero() {
+		settings.NotificationsMutedUntil = timestamppb.New(global.Until)
+		settings.NotificationsMuteReason = global.Reason
+	}
+	
 	return settings, nil
 }
 
-// Helper function to save settings to database
-func (s *SettingsService) saveSettings(settings *settingsv1.Settings) error {
+// Helper function to save settings to database, returning the keys of the
+// settings whose values changed
+func (s *SettingsService) saveSettings(ctx context.Context, settings *settingsv1.Settings) ([]string, error) {
 	settingsToSave := []models.Setting{
 		{Key: "general.site_name", Value: settings.SiteName, ValueType: "string", Category: "general"},
 		{Key: "general.timezone", Value: settings.Timezone, ValueType: "string", Category: "general"},
+		{Key: models.SettingLocale, Value: settings.Locale, ValueType: "string", Category: "general"},
 		{Key: "data.retention_days", Value: s.intToString(int(settings.RetentionDays)), ValueType: "int", Category: "data"},
+		{Key: models.SettingDataBurstRetentionHours, Value: s.intToString(int(settings.BurstRetentionHours)), ValueType: "int", Category: "data"},
 		{Key: "data.aggregation_interval_seconds", Value: s.intToString(int(settings.AggregationIntervalSeconds)), ValueType: "int", Category: "data"},
 		{Key: "display.temperature_unit", Value: settings.TemperatureUnit, ValueType: "string", Category: "display"},
 		{Key: "display.theme", Value: settings.Theme, ValueType: "string", Category: "display"},
 		{Key: "alerts.enabled", Value: s.boolToString(settings.AlertsEnabled), ValueType: "bool", Category: "alerts"},
 		{Key: "alerts.check_interval_seconds", Value: s.intToString(int(settings.AlertCheckIntervalSeconds)), ValueType: "int", Category: "alerts"},
+		{Key: models.SettingAlertsFlapThreshold, Value: s.intToString(int(settings.AlertFlapThreshold)), ValueType: "int", Category: "alerts"},
+		{Key: models.SettingAlertsContextMinutes, Value: s.intToString(int(settings.AlertContextMinutes)), ValueType: "int", Category: "alerts"},
 		{Key: "performance.max_concurrent_clients", Value: s.intToString(int(settings.MaxConcurrentClients)), ValueType: "int", Category: "performance"},
 		{Key: "performance.api_rate_limit", Value: s.intToString(int(settings.ApiRateLimit)), ValueType: "int", Category: "performance"},
 	}
@@ -133,8 +187,16 @@ func (s *SettingsService) saveSettings(settings *settingsv1.Settings) error {
 		)
 	}
 	
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	var changed []string
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		for _, setting := range settingsToSave {
+			var existing []models.Setting
+			if err := tx.Where("key = ?", setting.Key).Limit(1).Find(&existing).Error; err != nil {
+				return err
+			}
+			if len(existing) == 0 || existing[0].Value != setting.Value {
+				changed = append(changed, setting.Key)
+			}
 			if err := tx.Where("key = ?", setting.Key).
 				Assign(setting).
 				FirstOrCreate(&setting).Error; err != nil {
@@ -143,6 +205,7 @@ func (s *SettingsService) saveSettings(settings *settingsv1.Settings) error {
 		}
 		return nil
 	})
+	return changed, err
 }
 
 // Initialize default settings if they don't exist
@@ -150,12 +213,14 @@ func (s *SettingsService) initializeDefaultSettings() error {
 	defaultSettings := []models.Setting{
 		{Key: "general.site_name", Value: "Jacuzzi", ValueType: "string", Category: "general", Description: "Site name"},
 		{Key: "general.timezone", Value: "UTC", ValueType: "string", Category: "general", Description: "System timezone"},
+		{Key: models.SettingLocale, Value: string(i18n.Default), ValueType: "string", Category: "general", Description: "Language of alert messages, notifications and reports"},
 		{Key: "data.retention_days", Value: "30", ValueType: "int", Category: "data", Description: "Days to retain temperature data"},
 		{Key: "data.aggregation_interval_seconds", Value: "60", ValueType: "int", Category: "data", Description: "Data aggregation interval"},
 		{Key: "display.temperature_unit", Value: "celsius", ValueType: "string", Category: "display", Description: "Temperature display unit"},
 		{Key: "display.theme", Value: "system", ValueType: "string", Category: "display", Description: "UI theme"},
 		{Key: "alerts.enabled", Value: "true", ValueType: "bool", Category: "alerts", Description: "Enable alerts"},
 		{Key: "alerts.check_interval_seconds", Value: "60", ValueType: "int", Category: "alerts", Description: "Alert check interval"},
+		{Key: models.SettingAlertsFlapThreshold, Value: "5", ValueType: "int", Category: "alerts", Description: "Triggers per hour after which an alert is flapping"},
 		{Key: "performance.max_concurrent_clients", Value: "100", ValueType: "int", Category: "performance", Description: "Max concurrent clients"},
 		{Key: "performance.api_rate_limit", Value: "1000", ValueType: "int", Category: "performance", Description: "API rate limit per minute"},
 		{Key: models.SettingEmailSMTPHost, Value: "", ValueType: "string", Category: "email", Description: "SMTP host"},
@@ -2,64 +2,211 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
 	"time"
 
 	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
 	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/bus"
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/clientip"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
 	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/querycache"
+	"github.com/nickheyer/jacuzzi/pkg/server/scripting"
+	"github.com/nickheyer/jacuzzi/pkg/server/sensortype"
+	"github.com/nickheyer/jacuzzi/pkg/server/statsview"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Clock skew handling for readings timestamped ahead of the server clock
+const (
+	ClockSkewAccept  = "accept"  // Store readings as reported
+	ClockSkewRewrite = "rewrite" // Shift readings back by the client's estimated skew
+	ClockSkewReject  = "reject"  // Drop readings beyond the allowed skew
+)
+
+// TemperatureServiceConfig holds ingestion limits
+type TemperatureServiceConfig struct {
+	MaxReadingsPerRequest int           // 0 means unlimited
+	BatchIDTTL            time.Duration // How long batch IDs are remembered
+	MaxClockSkew          time.Duration // Allowed lead of reading timestamps over the server clock
+	ClockSkewAction       string        // accept, rewrite, or reject
+	EnrollmentMode        string        // open, approval, or token
+
+	// Per-client quotas; 0 means unlimited
+	QuotaReadingsPerMinute int
+	QuotaMaxSensors        int
+	QuotaAction            string // throttle or reject
+
+	// Hub that stored readings are published to for streaming subscribers;
+	// nil creates one local to this service
+	Events *events.Hub
+
+	// Message bus that stored readings are exported to; nil disables export
+	Bus *bus.Bridge
+
+	// Notified of clients created by their first readings; nil disables
+	Webhooks *webhook.Dispatcher
+
+	// Runs scripts on stored readings; nil disables
+	Scripts *scripting.Engine
+
+	// Finds the address clients call from; nil ignores forwarding headers
+	ClientIP *clientip.Resolver
+
+	// Keeps stats results for repeated requests; nil disables
+	Cache *querycache.Cache
+
+	// Answer stats for whole hours before the rollup watermark from the
+	// rollups; set when the rollup worker runs, as the watermark is stale
+	// otherwise
+	RollupStats bool
+
+	// Quarantines implausible readings; nil stores every reading
+	Validation *ReadingValidation
+}
+
 type TemperatureService struct {
 	jacuzziv1.UnimplementedTemperatureServiceServer
-	db *gorm.DB
+	db      *gorm.DB
+	cfg     TemperatureServiceConfig
+	batches *batchCache
+	quota   *quotaLimiter
+	events  *events.Hub
 }
 
-func NewTemperatureService(db *gorm.DB) *TemperatureService {
-	return &TemperatureService{db: db}
+func NewTemperatureService(db *gorm.DB, cfg TemperatureServiceConfig) *TemperatureService {
+	hub := cfg.Events
+	if hub == nil {
+		hub = events.NewHub(nil)
+	}
+	return &TemperatureService{
+		db:      db,
+		cfg:     cfg,
+		batches: newBatchCache(cfg.BatchIDTTL),
+		quota:   newQuotaLimiter(cfg.QuotaReadingsPerMinute),
+		events:  hub,
+	}
 }
 
 func (s *TemperatureService) SubmitTemperature(ctx context.Context, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error) {
 	if len(req.Readings) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "no readings provided")
 	}
+	if s.cfg.MaxReadingsPerRequest > 0 && len(req.Readings) > s.cfg.MaxReadingsPerRequest {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many readings in request: %d exceeds limit of %d", len(req.Readings), s.cfg.MaxReadingsPerRequest)
+	}
+
+	// Discard exact replays of a batch that was already stored
+	if req.BatchId != "" && s.batches.Seen(req.BatchId) {
+		return &temperaturev1.SubmitTemperatureResponse{
+			Success:        true,
+			Message:        "Duplicate batch ignored",
+			DuplicateCount: int32(len(req.Readings)),
+		}, nil
+	}
+
+	// Store canonical sensor types, so filters and rules match however an
+	// agent spells them
+	for i, reading := range req.Readings {
+		reading.SensorType = sensortype.Normalize(reading.SensorType)
+		if err := validateSummary(reading); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "reading %d: %v", i+1, err)
+		}
+	}
+
+	var accepted, duplicates, rejected, unapproved, throttled, quarantined int32
+	var stored []*temperaturev1.TemperatureReading
+
+	receivedAt := time.Now()
+	skews := estimateClockSkew(req.Readings, receivedAt)
+
+	allowed, err := s.takeReadingQuota(req.Readings, receivedAt)
+	if err != nil {
+		return nil, err
+	}
 
-	err := s.db.Transaction(func(tx *gorm.DB) error {
+	var registered []*models.Client
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		clients := make(map[string]*models.Client)
+		latest := make(map[sensorKey]time.Time)
+		values := make(map[sensorKey]sensorValue)
 		for _, reading := range req.Readings {
-			// Update or create client
-			now := time.Now()
-			client := &models.Client{
-				ClientID: reading.ClientId,
-				LastSeen: now,
-				IsOnline: true,
-			}
-			if err := tx.Where("client_id = ?", reading.ClientId).FirstOrCreate(client).Error; err != nil {
-				return err
+			client, seen := clients[reading.ClientId]
+			if !seen {
+				var created bool
+				var err error
+				client, created, err = s.touchClient(ctx, tx, reading.ClientId, skews[reading.ClientId])
+				if err != nil {
+					return err
+				}
+				clients[reading.ClientId] = client
+				if created {
+					registered = append(registered, client)
+				}
 			}
-			// Update LastSeen and IsOnline for existing clients
-			if client.ID != 0 {
-				tx.Model(client).Updates(map[string]interface{}{
-					"last_seen": now,
-					"is_online": true,
-				})
-			} else {
-				// Set FirstSeen for new clients
-				client.FirstSeen = now
+
+			// Readings from clients that are not enrolled or approved are not stored
+			if client == nil || client.Status != models.ClientStatusApproved {
+				unapproved++
+				continue
 			}
 
-			// Update or create sensor
-			sensor := &models.Sensor{
-				SensorID:   reading.SensorId,
-				ClientID:   reading.ClientId,
-				SensorType: reading.SensorType,
-				SensorName: reading.SensorName,
+			// Readings beyond the client's per-minute quota are dropped
+			if allowed[reading.ClientId] == 0 {
+				throttled++
+				continue
 			}
-			if err := tx.Where("sensor_id = ?", reading.SensorId).FirstOrCreate(sensor).Error; err != nil {
+			allowed[reading.ClientId]--
+
+			// Update or create sensor
+			ok, err := s.ensureSensor(tx, reading)
+			if err != nil {
 				return err
 			}
+			if !ok {
+				quotaViolations.Inc(reading.ClientId, quotaMaxSensors, s.cfg.QuotaAction)
+				if s.cfg.QuotaAction == QuotaReject {
+					return status.Errorf(codes.ResourceExhausted, "client %s exceeded its quota of %d sensors", reading.ClientId, s.cfg.QuotaMaxSensors)
+				}
+				throttled++
+				continue
+			}
+
+			timestamp, ok := s.readingTimestamp(reading, skews[reading.ClientId], receivedAt)
+			if !ok {
+				rejected++
+				continue
+			}
+
+			// Implausible readings are kept aside instead of being stored
+			key := sensorKey{reading.ClientId, reading.SensorId}
+			if v := s.cfg.Validation; v != nil {
+				previous, err := v.previousValue(tx, values, reading, timestamp)
+				if err != nil {
+					return err
+				}
+				if reason := v.check(reading, previous); reason != "" {
+					if err := quarantine(tx, reading, timestamp, reason, previous); err != nil {
+						return err
+					}
+					quarantined++
+					continue
+				}
+			}
 
 			// Insert temperature reading
 			tempReading := &models.TemperatureReading{
@@ -68,27 +215,347 @@ func (s *TemperatureService) SubmitTemperature(ctx context.Context, req *tempera
 				TemperatureCelsius: reading.TemperatureCelsius,
 				SensorType:         reading.SensorType,
 				SensorName:         reading.SensorName,
-				CreatedAt:          reading.Timestamp.AsTime(),
+				Burst:              reading.Burst,
+				CreatedAt:          timestamp,
 			}
-			if err := tx.Create(tempReading).Error; err != nil {
-				return err
+			if summary := reading.Summary; summary != nil {
+				tempReading.MinCelsius = &summary.MinCelsius
+				tempReading.MaxCelsius = &summary.MaxCelsius
+				tempReading.AvgCelsius = &summary.AvgCelsius
+				tempReading.SampleCount = summary.SampleCount
+			}
+			// Readings already stored for this client, sensor and timestamp are skipped
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(tempReading)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				duplicates++
+			} else {
+				accepted++
+				if timestamp.After(latest[key]) {
+					latest[key] = timestamp
+				}
+				if timestamp.After(values[key].at) {
+					values[key] = sensorValue{celsius: reading.TemperatureCelsius, at: timestamp}
+				}
+				stored = append(stored, &temperaturev1.TemperatureReading{
+					SensorId:           reading.SensorId,
+					ClientId:           reading.ClientId,
+					TemperatureCelsius: reading.TemperatureCelsius,
+					Timestamp:          timestamppb.New(timestamp),
+					SensorType:         reading.SensorType,
+					SensorName:         reading.SensorName,
+					Summary:            reading.Summary,
+					Burst:              reading.Burst,
+				})
 			}
 		}
-		return nil
+		return s.recordLatestReadings(tx, latest)
 	})
 
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to save readings: %v", err)
+		if st, ok := status.FromError(err); ok {
+			return nil, st.Err()
+		}
+		return nil, apierror.Wrap(err, "failed to save readings")
+	}
+
+	for _, client := range registered {
+		activity.Client(s.db.WithContext(ctx), activity.ClientRegistered, client, "Client registered with its first readings")
+		s.cfg.Webhooks.ClientRegistered(client)
 	}
 
+	if unapproved == int32(len(req.Readings)) {
+		if s.cfg.EnrollmentMode == EnrollmentToken {
+			return nil, errNotEnrolled
+		}
+		return nil, status.Error(codes.PermissionDenied, "client is not approved; readings were not stored")
+	}
+
+	if req.BatchId != "" {
+		s.batches.Add(req.BatchId)
+	}
+
+	s.publishReadings(ctx, stored)
+	s.cfg.Scripts.OnReadings(stored)
+
 	return &temperaturev1.SubmitTemperatureResponse{
-		Success: true,
-		Message: "Temperature readings saved successfully",
+		Success:        true,
+		Message:        "Temperature readings saved successfully",
+		AcceptedCount:  accepted,
+		DuplicateCount: duplicates,
+		RejectedCount:   rejected,
+		UnapprovedCount: unapproved,
+		ThrottledCount:  throttled,
+		QuarantinedCount: quarantined,
 	}, nil
 }
 
+// publishReadings shares newly stored readings with streaming subscribers on
+// every replica and with the message bus. Failures are logged; the readings
+// are already saved.
+func (s *TemperatureService) publishReadings(ctx context.Context, readings []*temperaturev1.TemperatureReading) {
+	if len(readings) == 0 {
+		return
+	}
+	if s.cfg.Bus != nil {
+		s.cfg.Bus.PublishReadings(readings)
+	}
+
+	data, err := proto.Marshal(&temperaturev1.TemperatureReadingBatch{Readings: readings})
+	if err != nil {
+		log.Printf("Failed to encode readings for subscribers: %v", err)
+		return
+	}
+	if err := s.events.Publish(context.WithoutCancel(ctx), events.TopicReadings, data); err != nil {
+		log.Printf("Failed to publish readings to other replicas: %v", err)
+	}
+}
+
+func (s *TemperatureService) StreamTemperatures(req *temperaturev1.StreamTemperaturesRequest, stream jacuzziv1.TemperatureService_StreamTemperaturesServer) error {
+	sub := s.events.Subscribe(events.TopicReadings, 64)
+	defer sub.Close()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			var batch temperaturev1.TemperatureReadingBatch
+			if err := proto.Unmarshal(data, &batch); err != nil {
+				log.Printf("Failed to decode published readings: %v", err)
+				continue
+			}
+			for _, reading := range batch.Readings {
+				if req.ClientId != "" && reading.ClientId != req.ClientId {
+					continue
+				}
+				if req.SensorId != "" && reading.SensorId != req.SensorId {
+					continue
+				}
+				if err := stream.Send(reading); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// takeReadingQuota charges each client's per-minute quota for its readings and
+// returns how many readings each client may store. In reject mode a client
+// over quota fails the whole request.
+func (s *TemperatureService) takeReadingQuota(readings []*temperaturev1.TemperatureReading, now time.Time) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, reading := range readings {
+		counts[reading.ClientId]++
+	}
+
+	allowed := make(map[string]int, len(counts))
+	for clientID, n := range counts {
+		granted := s.quota.Take(clientID, n, s.cfg.QuotaAction != QuotaReject, now)
+		if granted < n {
+			quotaViolations.Add(float64(n-granted), clientID, quotaReadingsPerMinute, s.cfg.QuotaAction)
+			if s.cfg.QuotaAction == QuotaReject {
+				return nil, status.Errorf(codes.ResourceExhausted, "client %s exceeded its quota of %d readings per minute", clientID, s.cfg.QuotaReadingsPerMinute)
+			}
+		}
+		allowed[clientID] = granted
+	}
+	return allowed, nil
+}
+
+// validateSummary checks that a summarized reading's last sample lies within
+// its range and its average between its extremes
+func validateSummary(reading *temperaturev1.TemperatureReading) error {
+	summary := reading.Summary
+	if summary == nil {
+		return nil
+	}
+	if summary.SampleCount < 1 {
+		return fmt.Errorf("summary needs a sample count of at least 1")
+	}
+	if summary.MinCelsius > summary.MaxCelsius {
+		return fmt.Errorf("summary minimum %g is above its maximum %g", summary.MinCelsius, summary.MaxCelsius)
+	}
+	if summary.AvgCelsius < summary.MinCelsius || summary.AvgCelsius > summary.MaxCelsius {
+		return fmt.Errorf("summary average %g is outside its range", summary.AvgCelsius)
+	}
+	if reading.TemperatureCelsius < summary.MinCelsius || reading.TemperatureCelsius > summary.MaxCelsius {
+		return fmt.Errorf("last sample %g is outside the summary's range", reading.TemperatureCelsius)
+	}
+	return nil
+}
+
+// Helper function to convert a reading's stored summary to proto
+func modelToProtoSummary(reading *models.TemperatureReading) *temperaturev1.ReadingSummary {
+	if reading.MinCelsius == nil || reading.MaxCelsius == nil || reading.AvgCelsius == nil {
+		return nil
+	}
+	return &temperaturev1.ReadingSummary{
+		MinCelsius:  *reading.MinCelsius,
+		MaxCelsius:  *reading.MaxCelsius,
+		AvgCelsius:  *reading.AvgCelsius,
+		SampleCount: reading.SampleCount,
+	}
+}
+
+type sensorKey struct {
+	clientID, sensorID string
+}
+
+// recordLatestReadings advances each sensor's last reading time and clears its
+// stale and retired flags. Readings older than the sensor's last reading, such
+// as buffered backfill, leave them unchanged.
+func (s *TemperatureService) recordLatestReadings(tx *gorm.DB, latest map[sensorKey]time.Time) error {
+	for key, timestamp := range latest {
+		err := tx.Model(&models.Sensor{}).
+			Where("client_id = ? AND sensor_id = ? AND (last_reading_at IS NULL OR last_reading_at < ?)", key.clientID, key.sensorID, timestamp).
+			Updates(map[string]interface{}{"last_reading_at": timestamp, "stale_since": nil, "retired_at": nil}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureSensor finds or creates the sensor for a reading. It returns false if
+// creating the sensor would exceed the client's sensor quota.
+func (s *TemperatureService) ensureSensor(tx *gorm.DB, reading *temperaturev1.TemperatureReading) (bool, error) {
+	var sensor models.Sensor
+	err := tx.Where("client_id = ? AND sensor_id = ?", reading.ClientId, reading.SensorId).First(&sensor).Error
+	if err == nil {
+		return true, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return false, err
+	}
+
+	if s.cfg.QuotaMaxSensors > 0 {
+		var count int64
+		if err := tx.Model(&models.Sensor{}).Where("client_id = ?", reading.ClientId).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count >= int64(s.cfg.QuotaMaxSensors) {
+			return false, nil
+		}
+	}
+
+	sensor = models.Sensor{
+		SensorID:   reading.SensorId,
+		ClientID:   reading.ClientId,
+		SensorType: reading.SensorType,
+		SensorName: reading.SensorName,
+	}
+	return true, tx.Create(&sensor).Error
+}
+
+// touchClient loads a client, creating it according to the enrollment mode, and
+// records that it was seen. It returns nil if the client is not enrolled, and
+// whether the client was created.
+func (s *TemperatureService) touchClient(ctx context.Context, tx *gorm.DB, clientID string, skew time.Duration) (*models.Client, bool, error) {
+	now := time.Now()
+	ip := s.cfg.ClientIP.FromContext(ctx)
+	client := &models.Client{}
+	err := tx.Where("client_id = ?", clientID).First(client).Error
+	if err == gorm.ErrRecordNotFound {
+		// Unknown clients cannot enroll themselves in token mode
+		clientStatus, err := newClientStatus(s.cfg.EnrollmentMode)
+		if err != nil {
+			return nil, false, nil
+		}
+		client = &models.Client{
+			ClientID:    clientID,
+			IPAddress:   ip,
+			FirstSeen:   now,
+			LastSeen:    now,
+			IsOnline:    true,
+			ClockSkewMs: skew.Milliseconds(),
+			Status:      clientStatus,
+		}
+		if err := tx.Create(client).Error; err != nil {
+			return nil, false, err
+		}
+		return client, true, recordAddress(tx, client, ip, now)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := checkAPIKey(ctx, client); err != nil {
+		return nil, false, err
+	}
+
+	// Update LastSeen and IsOnline for existing clients
+	updates := map[string]interface{}{
+		"last_seen":     now,
+		"is_online":     true,
+		"clock_skew_ms": skew.Milliseconds(),
+	}
+	if ip != "" && ip != client.IPAddress {
+		if err := recordAddress(tx, client, ip, now); err != nil {
+			return nil, false, err
+		}
+		updates["ip_address"] = ip
+	}
+	wasOnline, lastSeen := client.IsOnline, client.LastSeen
+	if err := tx.Model(client).Updates(updates).Error; err != nil {
+		return nil, false, err
+	}
+	if !wasOnline {
+		activity.Client(tx, activity.ClientOnline, client, "Client is reporting again; last seen at "+lastSeen.Format(time.RFC3339))
+	}
+	return client, false, nil
+}
+
+// estimateClockSkew estimates each client's clock offset from the server as
+// the lead of its newest reading over the time the request was received
+func estimateClockSkew(readings []*temperaturev1.TemperatureReading, receivedAt time.Time) map[string]time.Duration {
+	newest := make(map[string]time.Time)
+	for _, reading := range readings {
+		if reading.Timestamp == nil {
+			continue
+		}
+		ts := reading.Timestamp.AsTime()
+		if ts.After(newest[reading.ClientId]) {
+			newest[reading.ClientId] = ts
+		}
+	}
+
+	skews := make(map[string]time.Duration, len(newest))
+	for clientID, ts := range newest {
+		skews[clientID] = ts.Sub(receivedAt)
+	}
+	return skews
+}
+
+// readingTimestamp resolves the stored timestamp for a reading, applying the
+// configured clock skew action. It returns false if the reading is rejected.
+func (s *TemperatureService) readingTimestamp(reading *temperaturev1.TemperatureReading, skew time.Duration, receivedAt time.Time) (time.Time, bool) {
+	if reading.Timestamp == nil {
+		return receivedAt, true
+	}
+
+	ts := reading.Timestamp.AsTime()
+	if s.cfg.MaxClockSkew <= 0 || ts.Sub(receivedAt) <= s.cfg.MaxClockSkew {
+		return ts, true
+	}
+
+	switch s.cfg.ClockSkewAction {
+	case ClockSkewRewrite:
+		return ts.Add(-skew), true
+	case ClockSkewReject:
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
 func (s *TemperatureService) GetTemperatureHistory(ctx context.Context, req *temperaturev1.GetTemperatureHistoryRequest) (*temperaturev1.GetTemperatureHistoryResponse, error) {
-	query := s.db.Model(&models.TemperatureReading{})
+	query := s.db.WithContext(ctx).Model(&models.TemperatureReading{})
 
 	if req.ClientId != "" {
 		query = query.Where("client_id = ?", req.ClientId)
@@ -111,7 +578,18 @@ func (s *TemperatureService) GetTemperatureHistory(ctx context.Context, req *tem
 
 	var readings []models.TemperatureReading
 	if err := query.Find(&readings).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to query temperature history: %v", err)
+		return nil, apierror.Wrap(err, "failed to query temperature history")
+	}
+	compressed, err := chunks.Readings(s.db.WithContext(ctx), historyChunkFilter(req), limit)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to query compressed temperature history")
+	}
+	if len(compressed) > 0 {
+		readings = append(readings, compressed...)
+		sort.SliceStable(readings, func(i, j int) bool { return readings[i].CreatedAt.After(readings[j].CreatedAt) })
+		if len(readings) > limit {
+			readings = readings[:limit]
+		}
 	}
 
 	protoReadings := make([]*temperaturev1.TemperatureReading, len(readings))
@@ -123,33 +601,81 @@ func (s *TemperatureService) GetTemperatureHistory(ctx context.Context, req *tem
 			Timestamp:          timestamppb.New(reading.CreatedAt),
 			SensorType:         reading.SensorType,
 			SensorName:         reading.SensorName,
+			Summary:            modelToProtoSummary(&reading),
+			Burst:              reading.Burst,
 		}
 	}
 
-	return &temperaturev1.GetTemperatureHistoryResponse{
+	resp := &temperaturev1.GetTemperatureHistoryResponse{
 		Readings: protoReadings,
-	}, nil
+	}
+	if req.IncludeAnnotations {
+		// Without a start time the range begins at the oldest returned reading
+		filter := annotationFilter{clientID: req.ClientId, limit: 1000}
+		if req.StartTime != nil {
+			filter.start = req.StartTime.AsTime()
+		} else if len(readings) > 0 {
+			filter.start = readings[len(readings)-1].CreatedAt
+		}
+		if req.EndTime != nil {
+			filter.end = req.EndTime.AsTime()
+		}
+		annotations, err := listAnnotations(s.db.WithContext(ctx), filter)
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to query annotations")
+		}
+		resp.Annotations = annotations
+	}
+	return resp, nil
+}
+
+// historyChunkFilter selects the compressed readings a history request covers
+func historyChunkFilter(req *temperaturev1.GetTemperatureHistoryRequest) chunks.Filter {
+	filter := chunks.Filter{ClientID: req.ClientId}
+	if req.SensorId != "" {
+		filter.SensorIDs = []string{req.SensorId}
+	}
+	if req.StartTime != nil {
+		filter.Start = req.StartTime.AsTime()
+	}
+	if req.EndTime != nil {
+		filter.End = req.EndTime.AsTime()
+	}
+	return filter
 }
 
 func (s *TemperatureService) GetCurrentTemperatures(ctx context.Context, req *temperaturev1.GetCurrentTemperaturesRequest) (*temperaturev1.GetCurrentTemperaturesResponse, error) {
-	if req.ClientId == "" {
-		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	// Get the latest reading of each sensor, skipping retired sensors. Sensors
+	// keep the time of their latest reading, so this needs no scan for it.
+	query := s.db.WithContext(ctx).Model(&models.TemperatureReading{}).
+		Select("temperature_readings.*").
+		Joins("JOIN sensors ON sensors.client_id = temperature_readings.client_id AND sensors.sensor_id = temperature_readings.sensor_id").
+		Where("sensors.retired_at IS NULL AND temperature_readings.created_at = sensors.last_reading_at")
+	clientIDs := req.ClientIds
+	if req.ClientId != "" {
+		clientIDs = append(clientIDs, req.ClientId)
+	}
+	if len(clientIDs) > 0 {
+		query = query.Where("temperature_readings.client_id IN ?", clientIDs)
+	}
+	if req.Site != "" {
+		query = query.Where("temperature_readings.client_id IN (?)", s.db.Model(&models.Client{}).Select("client_id").Where("site = ?", req.Site))
+	}
+	if len(req.Metadata) > 0 {
+		matching, err := s.clientsWithMetadata(ctx, req.Metadata)
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to query clients")
+		}
+		query = query.Where("temperature_readings.client_id IN ?", matching)
+	}
+	if sensorType := sensortype.Filter(req.SensorType); sensorType != "" {
+		query = query.Where("temperature_readings.sensor_type = ?", sensorType)
 	}
 
-	// Get the latest reading for each sensor of the client
 	var readings []models.TemperatureReading
-	subQuery := s.db.Model(&models.TemperatureReading{}).
-		Select("sensor_id, MAX(created_at) as max_created_at").
-		Where("client_id = ?", req.ClientId).
-		Group("sensor_id")
-
-	err := s.db.Model(&models.TemperatureReading{}).
-		Joins("INNER JOIN (?) as latest ON temperature_readings.sensor_id = latest.sensor_id AND temperature_readings.created_at = latest.max_created_at", subQuery).
-		Where("temperature_readings.client_id = ?", req.ClientId).
-		Find(&readings).Error
-
+	err := query.Order("temperature_readings.client_id, temperature_readings.sensor_id").Find(&readings).Error
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to query current temperatures: %v", err)
+		return nil, apierror.Wrap(err, "failed to query current temperatures")
 	}
 
 	protoReadings := make([]*temperaturev1.TemperatureReading, len(readings))
@@ -161,6 +687,8 @@ func (s *TemperatureService) GetCurrentTemperatures(ctx context.Context, req *te
 			Timestamp:          timestamppb.New(reading.CreatedAt),
 			SensorType:         reading.SensorType,
 			SensorName:         reading.SensorName,
+			Summary:            modelToProtoSummary(&reading),
+			Burst:              reading.Burst,
 		}
 	}
 
@@ -169,31 +697,136 @@ func (s *TemperatureService) GetCurrentTemperatures(ctx context.Context, req *te
 	}, nil
 }
 
-func (s *TemperatureService) GetDistinctClients(ctx context.Context) ([]string, error) {
+// clientsWithMetadata returns the IDs of the clients whose metadata has all
+// of the given values
+func (s *TemperatureService) clientsWithMetadata(ctx context.Context, values map[string]string) ([]string, error) {
+	var clients []models.Client
+	if err := s.db.WithContext(ctx).Select("client_id", "metadata").Where("metadata <> ''").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	matching := []string{}
+	for _, client := range clients {
+		var metadata map[string]string
+		if json.Unmarshal([]byte(client.Metadata), &metadata) != nil {
+			continue
+		}
+		matches := true
+		for key, value := range values {
+			if metadata[key] != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			matching = append(matching, client.ClientID)
+		}
+	}
+	return matching, nil
+}
+
+func (s *TemperatureService) GetDistinctClients(ctx context.Context, req *temperaturev1.GetDistinctClientsRequest) (*temperaturev1.GetDistinctClientsResponse, error) {
 	var clients []string
-	err := s.db.Model(&models.Client{}).
+	err := s.db.WithContext(ctx).Model(&models.Client{}).
 		Distinct("client_id").
 		Order("client_id").
 		Pluck("client_id", &clients).Error
 
 	if err != nil {
-		return nil, err
+		return nil, apierror.Wrap(err, "failed to list clients")
 	}
 
-	return clients, nil
+	return &temperaturev1.GetDistinctClientsResponse{ClientIds: clients}, nil
+}
+
+func (s *TemperatureService) ListSensorTypes(ctx context.Context, req *temperaturev1.ListSensorTypesRequest) (*temperaturev1.ListSensorTypesResponse, error) {
+	query := s.db.WithContext(ctx).Model(&models.Sensor{}).Where("sensor_type <> ''")
+	if req.ClientId != "" {
+		query = query.Where("client_id = ?", req.ClientId)
+	}
+
+	var sensorTypes []string
+	if err := query.Distinct("sensor_type").Order("sensor_type").Pluck("sensor_type", &sensorTypes).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list sensor types")
+	}
+
+	return &temperaturev1.ListSensorTypesResponse{SensorTypes: sensorTypes}, nil
 }
 
 func (s *TemperatureService) GetTemperatureStats(ctx context.Context, req *temperaturev1.GetTemperatureStatsRequest) (*temperaturev1.GetTemperatureStatsResponse, error) {
-	baseQuery := s.db.Model(&models.TemperatureReading{})
+	key, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to encode stats request")
+	}
+	if cached, ok := s.cfg.Cache.Get("stats", string(key)); ok {
+		return cached.(*temperaturev1.GetTemperatureStatsResponse), nil
+	}
+	resp, err := s.temperatureStats(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	s.cfg.Cache.Set("stats", string(key), req.ClientId, resp)
+	return resp, nil
+}
+
+func (s *TemperatureService) temperatureStats(ctx context.Context, req *temperaturev1.GetTemperatureStatsRequest) (*temperaturev1.GetTemperatureStatsResponse, error) {
+	baseQuery := s.db.WithContext(ctx).Model(&models.TemperatureReading{})
 
 	if req.ClientId != "" {
-		baseQuery = baseQuery.Where("client_id = ?", req.ClientId)
+		baseQuery = baseQuery.Where("temperature_readings.client_id = ?", req.ClientId)
+	}
+	if req.Site != "" {
+		baseQuery = baseQuery.Where("temperature_readings.client_id IN (?)", s.db.Model(&models.Client{}).Select("client_id").Where("site = ?", req.Site))
 	}
 	if req.StartTime != nil {
-		baseQuery = baseQuery.Where("created_at >= ?", req.StartTime.AsTime())
+		baseQuery = baseQuery.Where("temperature_readings.created_at >= ?", req.StartTime.AsTime())
 	}
 	if req.EndTime != nil {
-		baseQuery = baseQuery.Where("created_at <= ?", req.EndTime.AsTime())
+		baseQuery = baseQuery.Where("temperature_readings.created_at <= ?", req.EndTime.AsTime())
+	}
+	// Columns are qualified since stats by site join clients, which has
+	// client_id and created_at too. Each query below starts from these
+	// filters alone rather than adding to the conditions of the previous one.
+	baseQuery = baseQuery.Session(&gorm.Session{})
+
+	chunkFilter := chunks.Filter{ClientID: req.ClientId, Site: req.Site}
+	if req.SensorId != "" {
+		chunkFilter.SensorIDs = []string{req.SensorId}
+	}
+	if req.StartTime != nil {
+		chunkFilter.Start = req.StartTime.AsTime()
+	}
+	if req.EndTime != nil {
+		chunkFilter.End = req.EndTime.AsTime()
+	}
+
+	// The rollups answer for the whole hours before the rollup watermark, and
+	// raw readings and chunks for the rest of the range, along with the
+	// burst readings the rollups leave out.
+	// Stats by interval split the range their own way.
+	statsQuery := baseQuery
+	var window statsview.Window
+	var viewed bool
+	var err error
+	if s.cfg.RollupStats {
+		if window, viewed, err = statsview.Covering(s.db.WithContext(ctx), chunkFilter.Start, chunkFilter.End); err != nil {
+			return nil, apierror.Wrap(err, "failed to query rollup watermark")
+		}
+	}
+	viewedStats := make(map[string]*chunks.Stats)
+	rangeChunks := chunkFilter
+	if viewed {
+		statsQuery = baseQuery.Where("NOT (temperature_readings.created_at >= ? AND temperature_readings.created_at < ? AND temperature_readings.burst = ?)", window.Start, window.End, false).
+			Session(&gorm.Session{})
+		rangeChunks.SkipStart, rangeChunks.SkipEnd = window.Start, window.End
+		if viewedStats, err = viewStats(s.db.WithContext(ctx), window, req, false); err != nil {
+			return nil, err
+		}
+	}
+	compressed, err := chunks.Aggregate(s.db.WithContext(ctx), rangeChunks, func(_, sensorID string, _ time.Time) (string, bool) {
+		return sensorID, true
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to calculate compressed temperature stats")
 	}
 
 	// If specific sensor_id is requested, only get stats for that sensor
@@ -202,15 +835,29 @@ func (s *TemperatureService) GetTemperatureStats(ctx context.Context, req *tempe
 		sensorIds = []string{req.SensorId}
 	} else {
 		// Get all sensor IDs that match the criteria
-		if err := baseQuery.Distinct("sensor_id").Pluck("sensor_id", &sensorIds).Error; err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get sensor IDs: %v", err)
+		if err := statsQuery.Distinct("sensor_id").Pluck("sensor_id", &sensorIds).Error; err != nil {
+			return nil, apierror.Wrap(err, "failed to get sensor IDs")
+		}
+		// Along with sensors whose readings in range are all compressed or
+		// in the rollups
+		raw := make(map[string]bool, len(sensorIds))
+		for _, sensorId := range sensorIds {
+			raw[sensorId] = true
+		}
+		for _, other := range []map[string]*chunks.Stats{compressed, viewedStats} {
+			for sensorId := range other {
+				if !raw[sensorId] {
+					raw[sensorId] = true
+					sensorIds = append(sensorIds, sensorId)
+				}
+			}
 		}
 	}
 
 	sensorStats := make(map[string]*temperaturev1.TemperatureStats)
 	
 	for _, sensorId := range sensorIds {
-		query := baseQuery.Where("sensor_id = ?", sensorId)
+		query := statsQuery.Where("temperature_readings.sensor_id = ?", sensorId)
 		
 		var stats struct {
 			AvgTemp float64
@@ -220,14 +867,14 @@ func (s *TemperatureService) GetTemperatureStats(ctx context.Context, req *tempe
 		}
 
 		err := query.Select(`
-			AVG(temperature_celsius) as avg_temp,
-			MIN(temperature_celsius) as min_temp,
-			MAX(temperature_celsius) as max_temp,
+			AVG(COALESCE(avg_celsius, temperature_celsius)) as avg_temp,
+			MIN(COALESCE(min_celsius, temperature_celsius)) as min_temp,
+			MAX(COALESCE(max_celsius, temperature_celsius)) as max_temp,
 			COUNT(*) as count
 		`).Scan(&stats).Error
 
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to calculate temperature stats for sensor %s: %v", sensorId, err)
+			return nil, apierror.Wrap(err, "failed to calculate temperature stats for sensor "+sensorId)
 		}
 
 		sensorStats[sensorId] = &temperaturev1.TemperatureStats{
@@ -238,9 +885,323 @@ func (s *TemperatureService) GetTemperatureStats(ctx context.Context, req *tempe
 			PeriodStart:    req.StartTime,
 			PeriodEnd:      req.EndTime,
 		}
+		addStats(sensorStats[sensorId], compressed[sensorId])
+		addStats(sensorStats[sensorId], viewedStats[sensorId])
 	}
 
-	return &temperaturev1.GetTemperatureStatsResponse{
+	resp := &temperaturev1.GetTemperatureStatsResponse{
 		SensorStats: sensorStats,
-	}, nil
+	}
+	if req.GroupBySite {
+		var siteWindow *statsview.Window
+		if viewed {
+			siteWindow = &window
+		}
+		siteStats, err := s.siteStats(ctx, statsQuery, rangeChunks, siteWindow, req)
+		if err != nil {
+			return nil, err
+		}
+		resp.SiteStats = siteStats
+	}
+	if req.Interval != temperaturev1.StatsInterval_STATS_INTERVAL_UNSPECIFIED {
+		loc, err := resolveTimezone(s.db.WithContext(ctx), req.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		var intervalWindow *statsview.Window
+		if viewed {
+			intervalWindow = &window
+		}
+		buckets, err := intervalStats(s.db.WithContext(ctx), baseQuery, chunkFilter, intervalWindow, req, loc)
+		if err != nil {
+			return nil, err
+		}
+		resp.Buckets = buckets
+		resp.Timezone = loc.String()
+	}
+	return resp, nil
+}
+
+// addStats adds the stats of readings aggregated apart, compressed or in the
+// rollups, to those of the raw readings of the same sensors and period
+func addStats(stats *temperaturev1.TemperatureStats, compressed *chunks.Stats) {
+	if compressed == nil || compressed.Count == 0 {
+		return
+	}
+	if stats.ReadingCount == 0 {
+		stats.MinTemperature = compressed.Min
+		stats.MaxTemperature = compressed.Max
+		stats.AvgTemperature = compressed.Avg()
+	} else {
+		stats.MinTemperature = min(stats.MinTemperature, compressed.Min)
+		stats.MaxTemperature = max(stats.MaxTemperature, compressed.Max)
+		total := float64(stats.ReadingCount) + float64(compressed.Count)
+		stats.AvgTemperature = (stats.AvgTemperature*float64(stats.ReadingCount) + compressed.Sum) / total
+	}
+	stats.ReadingCount += int32(compressed.Count)
+}
+
+// viewStats aggregates the rollups over a window by sensor, or by site
+// when bySite is set, for the clients and sensor a stats request selects
+func viewStats(db *gorm.DB, w statsview.Window, req *temperaturev1.GetTemperatureStatsRequest, bySite bool) (map[string]*chunks.Stats, error) {
+	group := "stats_view.sensor_id"
+	query := db.Table("(?) AS stats_view", statsview.Rows(db, w))
+	if bySite {
+		group = "COALESCE(clients.site, '')"
+		query = query.Joins("LEFT JOIN clients ON clients.client_id = stats_view.client_id")
+	}
+	if req.ClientId != "" {
+		query = query.Where("stats_view.client_id = ?", req.ClientId)
+	}
+	if req.Site != "" {
+		query = query.Where("stats_view.client_id IN (?)", db.Model(&models.Client{}).Select("client_id").Where("site = ?", req.Site))
+	}
+	if req.SensorId != "" {
+		query = query.Where("stats_view.sensor_id = ?", req.SensorId)
+	}
+
+	var rows []struct {
+		GroupKey string
+		MinTemp  float64
+		MaxTemp  float64
+		SumTemp  float64
+		Count    int64
+	}
+	err := query.Select(group + ` as group_key,
+		MIN(stats_view.min_celsius) as min_temp,
+		MAX(stats_view.max_celsius) as max_temp,
+		SUM(stats_view.sum_celsius) as sum_temp,
+		SUM(stats_view.reading_count) as count
+	`).
+		Group(group).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to read rollups")
+	}
+	stats := make(map[string]*chunks.Stats, len(rows))
+	for _, row := range rows {
+		stats[row.GroupKey] = &chunks.Stats{Min: row.MinTemp, Max: row.MaxTemp, Sum: row.SumTemp, Count: row.Count}
+	}
+	return stats, nil
+}
+
+// maxStatsBuckets bounds the intervals one stats request may span
+const maxStatsBuckets = 1000
+
+// intervalStats aggregates the readings a stats query matches per sensor and
+// local calendar interval, so a daily maximum covers the configured day
+// rather than the UTC one. The rollups answer for the whole hours of each
+// interval in window, when it is set.
+func intervalStats(db *gorm.DB, query *gorm.DB, chunkFilter chunks.Filter, window *statsview.Window, req *temperaturev1.GetTemperatureStatsRequest, loc *time.Location) ([]*temperaturev1.SensorStatsBucket, error) {
+	var unit timezone.Unit
+	switch req.Interval {
+	case temperaturev1.StatsInterval_STATS_INTERVAL_HOUR:
+		unit = timezone.Hour
+	case temperaturev1.StatsInterval_STATS_INTERVAL_DAY:
+		unit = timezone.Day
+	case temperaturev1.StatsInterval_STATS_INTERVAL_WEEK:
+		unit = timezone.Week
+	case temperaturev1.StatsInterval_STATS_INTERVAL_MONTH:
+		unit = timezone.Month
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported interval %v", req.Interval)
+	}
+	if req.StartTime == nil {
+		return nil, status.Error(codes.InvalidArgument, "start_time is required with an interval")
+	}
+	end := time.Now()
+	if req.EndTime != nil {
+		end = req.EndTime.AsTime()
+	}
+
+	var bounds []time.Time
+	for t := timezone.Floor(req.StartTime.AsTime(), unit, loc); t.Before(end); t = timezone.Next(t, unit, loc) {
+		if len(bounds) == maxStatsBuckets {
+			return nil, status.Errorf(codes.InvalidArgument, "range spans more than %d intervals; use a longer interval or a shorter range", maxStatsBuckets)
+		}
+		bounds = append(bounds, t)
+	}
+	if req.SensorId != "" {
+		query = query.Where("temperature_readings.sensor_id = ?", req.SensorId).Session(&gorm.Session{})
+	}
+
+	// Local intervals need not start on a UTC hour, so each has its own part
+	// of the window
+	windows := make([]*statsview.Window, len(bounds))
+	if window != nil {
+		for i, start := range bounds {
+			next := timezone.Next(start, unit, loc)
+			if w, ok := window.Within(start, next); ok {
+				windows[i] = &w
+			}
+		}
+	}
+
+	type bucketSensor struct {
+		bucket   int
+		sensorID string
+	}
+	compressed, err := chunks.Aggregate(db, chunkFilter, func(_, sensorID string, t time.Time) (bucketSensor, bool) {
+		i := sort.Search(len(bounds), func(i int) bool { return bounds[i].After(t) }) - 1
+		if i < 0 || !t.Before(timezone.Next(bounds[i], unit, loc)) || windows[i] != nil && windows[i].Contains(t) {
+			return bucketSensor{}, false
+		}
+		return bucketSensor{i, sensorID}, true
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to calculate compressed temperature stats")
+	}
+	compressedSensors := make(map[int][]string)
+	for key := range compressed {
+		compressedSensors[key.bucket] = append(compressedSensors[key.bucket], key.sensorID)
+	}
+
+	var buckets []*temperaturev1.SensorStatsBucket
+	for i, start := range bounds {
+		next := timezone.Next(start, unit, loc)
+		// The query already holds the requested range, which clips the first
+		// and last intervals. Bounds are passed in UTC since SQLite compares
+		// timestamps as text.
+		intervalQuery := query
+		viewed := make(map[string]*chunks.Stats)
+		if windows[i] != nil {
+			w := *windows[i]
+			intervalQuery = query.Where("NOT (temperature_readings.created_at >= ? AND temperature_readings.created_at < ? AND temperature_readings.burst = ?)", w.Start, w.End, false)
+			var err error
+			if viewed, err = viewStats(db, w, req, false); err != nil {
+				return nil, err
+			}
+		}
+		var rows []struct {
+			SensorID string
+			AvgTemp  float64
+			MinTemp  float64
+			MaxTemp  float64
+			Count    int32
+		}
+		err := intervalQuery.Select(`
+			sensor_id,
+			AVG(COALESCE(avg_celsius, temperature_celsius)) as avg_temp,
+			MIN(COALESCE(min_celsius, temperature_celsius)) as min_temp,
+			MAX(COALESCE(max_celsius, temperature_celsius)) as max_temp,
+			COUNT(*) as count
+		`).
+			Where("temperature_readings.created_at >= ? AND temperature_readings.created_at < ?", start.UTC(), next.UTC()).
+			Group("sensor_id").
+			Order("sensor_id").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, apierror.Wrap(err, "failed to calculate temperature stats for "+start.Format(time.RFC3339))
+		}
+		var interval []*temperaturev1.SensorStatsBucket
+		raw := make(map[string]bool, len(rows))
+		for _, row := range rows {
+			raw[row.SensorID] = true
+			interval = append(interval, &temperaturev1.SensorStatsBucket{
+				SensorId: row.SensorID,
+				Stats: &temperaturev1.TemperatureStats{
+					MinTemperature: row.MinTemp,
+					MaxTemperature: row.MaxTemp,
+					AvgTemperature: row.AvgTemp,
+					ReadingCount:   row.Count,
+					PeriodStart:    timestamppb.New(start),
+					PeriodEnd:      timestamppb.New(next),
+				},
+			})
+		}
+		others := append([]string(nil), compressedSensors[i]...)
+		for sensorID := range viewed {
+			others = append(others, sensorID)
+		}
+		added := len(interval)
+		for _, sensorID := range others {
+			if !raw[sensorID] {
+				raw[sensorID] = true
+				interval = append(interval, &temperaturev1.SensorStatsBucket{
+					SensorId: sensorID,
+					Stats:    &temperaturev1.TemperatureStats{PeriodStart: timestamppb.New(start), PeriodEnd: timestamppb.New(next)},
+				})
+			}
+		}
+		for _, bucket := range interval {
+			addStats(bucket.Stats, compressed[bucketSensor{i, bucket.SensorId}])
+			addStats(bucket.Stats, viewed[bucket.SensorId])
+		}
+		if len(interval) > added {
+			sort.Slice(interval, func(a, b int) bool { return interval[a].SensorId < interval[b].SensorId })
+		}
+		buckets = append(buckets, interval...)
+	}
+	return buckets, nil
+}
+
+// siteStats aggregates the readings a stats query matches by the site of the
+// client that submitted them, along with the rollups over window when it
+// is set
+func (s *TemperatureService) siteStats(ctx context.Context, query *gorm.DB, chunkFilter chunks.Filter, window *statsview.Window, req *temperaturev1.GetTemperatureStatsRequest) (map[string]*temperaturev1.TemperatureStats, error) {
+	if req.SensorId != "" {
+		query = query.Where("temperature_readings.sensor_id = ?", req.SensorId)
+	}
+	var rows []struct {
+		Site    string
+		AvgTemp float64
+		MinTemp float64
+		MaxTemp float64
+		Count   int32
+	}
+	err := query.Select(`
+		COALESCE(clients.site, '') as site,
+		AVG(COALESCE(avg_celsius, temperature_celsius)) as avg_temp,
+		MIN(COALESCE(min_celsius, temperature_celsius)) as min_temp,
+		MAX(COALESCE(max_celsius, temperature_celsius)) as max_temp,
+		COUNT(*) as count
+	`).
+		Joins("LEFT JOIN clients ON clients.client_id = temperature_readings.client_id").
+		Group("COALESCE(clients.site, '')").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to calculate temperature stats by site")
+	}
+
+	siteStats := make(map[string]*temperaturev1.TemperatureStats, len(rows))
+	for _, row := range rows {
+		siteStats[row.Site] = &temperaturev1.TemperatureStats{
+			MinTemperature: row.MinTemp,
+			MaxTemperature: row.MaxTemp,
+			AvgTemperature: row.AvgTemp,
+			ReadingCount:   row.Count,
+			PeriodStart:    req.StartTime,
+			PeriodEnd:      req.EndTime,
+		}
+	}
+
+	var clients []models.Client
+	if err := s.db.WithContext(ctx).Select("client_id", "site").Find(&clients).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to load client sites")
+	}
+	sites := make(map[string]string, len(clients))
+	for _, client := range clients {
+		sites[client.ClientID] = client.Site
+	}
+	compressed, err := chunks.Aggregate(s.db.WithContext(ctx), chunkFilter, func(clientID, _ string, _ time.Time) (string, bool) {
+		return sites[clientID], true
+	})
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to calculate compressed temperature stats by site")
+	}
+	viewed := make(map[string]*chunks.Stats)
+	if window != nil {
+		if viewed, err = viewStats(s.db.WithContext(ctx), *window, req, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, other := range []map[string]*chunks.Stats{compressed, viewed} {
+		for site, stats := range other {
+			if siteStats[site] == nil {
+				siteStats[site] = &temperaturev1.TemperatureStats{PeriodStart: req.StartTime, PeriodEnd: req.EndTime}
+			}
+			addStats(siteStats[site], stats)
+		}
+	}
+	return siteStats, nil
 }
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	alertv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/alert/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/cron"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Helper function to validate threshold windows and encode them for storage
+func protoToModelWindows(conditionType alertv1.AlertCondition_Type, windows []*alertv1.ThresholdWindow) (string, error) {
+	if len(windows) == 0 {
+		return "", nil
+	}
+	if conditionType != alertv1.AlertCondition_TYPE_THRESHOLD && conditionType != alertv1.AlertCondition_TYPE_AGGREGATE {
+		return "", status.Error(codes.InvalidArgument, "only threshold and aggregate rules can have threshold windows")
+	}
+	stored := make([]models.ThresholdWindow, len(windows))
+	for i, window := range windows {
+		schedule := strings.Join(strings.Fields(window.Schedule), " ")
+		if schedule == "" {
+			return "", status.Errorf(codes.InvalidArgument, "threshold window %d needs a schedule", i+1)
+		}
+		if _, err := cron.Parse(schedule); err != nil {
+			return "", status.Errorf(codes.InvalidArgument, "threshold window %d: %v", i+1, err)
+		}
+		stored[i] = models.ThresholdWindow{
+			Name:      strings.TrimSpace(window.Name),
+			Schedule:  schedule,
+			Threshold: window.Threshold,
+			Suppress:  window.Suppress,
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", apierror.Wrap(err, "failed to encode threshold windows")
+	}
+	return string(data), nil
+}
+
+// Helper function to convert a rule's stored threshold windows to proto
+func modelToProtoWindows(rule *models.AlertRule) ([]*alertv1.ThresholdWindow, error) {
+	if rule.Windows == "" {
+		return nil, nil
+	}
+	var stored []models.ThresholdWindow
+	if err := json.Unmarshal([]byte(rule.Windows), &stored); err != nil {
+		return nil, fmt.Errorf("invalid threshold windows of rule %s: %w", rule.RuleID, err)
+	}
+	windows := make([]*alertv1.ThresholdWindow, len(stored))
+	for i, window := range stored {
+		windows[i] = &alertv1.ThresholdWindow{
+			Name:      window.Name,
+			Schedule:  window.Schedule,
+			Threshold: window.Threshold,
+			Suppress:  window.Suppress,
+		}
+	}
+	return windows, nil
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/timezone"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// resolveTimezone returns the timezone a request names, or the configured one
+// when it names none
+func resolveTimezone(db *gorm.DB, name string) (*time.Location, error) {
+	loc, err := timezone.Resolve(db, name)
+	if err != nil {
+		var unknown *timezone.UnknownError
+		if errors.As(err, &unknown) {
+			return nil, status.Error(codes.InvalidArgument, unknown.Error())
+		}
+		return nil, apierror.Wrap(err, "failed to load timezone")
+	}
+	return loc, nil
+}
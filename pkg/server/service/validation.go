@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/metrics"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+var readingsQuarantined = metrics.NewCounterVec(
+	"jacuzzi_ingest_readings_quarantined_total",
+	"Readings quarantined at ingest for failing validation.",
+	"reason",
+)
+
+// ReadingRange is the plausible range of a sensor type's readings in °C
+type ReadingRange struct {
+	Min, Max float64
+}
+
+// ReadingValidation quarantines implausible readings instead of storing them
+type ReadingValidation struct {
+	Ranges       map[string]ReadingRange // By canonical sensor type
+	DefaultRange *ReadingRange           // For types without a range; nil accepts any value
+	RejectValues []float64               // Values sensors report for a failed read
+	MaxSpike     float64                 // Largest change from the previous reading; 0 disables
+	SpikeWindow  time.Duration           // How recent the previous reading must be to compare against
+}
+
+// sensorValue is the latest accepted reading of a sensor
+type sensorValue struct {
+	celsius float64
+	at      time.Time
+}
+
+// check returns the reason a reading is quarantined, or "" when it is
+// plausible. previous is the sensor's last reading within the spike window,
+// or nil.
+func (v *ReadingValidation) check(reading *temperaturev1.TemperatureReading, previous *sensorValue) string {
+	for _, value := range v.RejectValues {
+		if math.Abs(reading.TemperatureCelsius-value) < 1e-6 {
+			return models.QuarantineRejectedValue
+		}
+	}
+
+	r, ok := v.Ranges[reading.SensorType]
+	if !ok && v.DefaultRange != nil {
+		r, ok = *v.DefaultRange, true
+	}
+	if ok {
+		low, high := reading.TemperatureCelsius, reading.TemperatureCelsius
+		if reading.Summary != nil {
+			low, high = reading.Summary.MinCelsius, reading.Summary.MaxCelsius
+		}
+		if low < r.Min || high > r.Max {
+			return models.QuarantineOutOfRange
+		}
+	}
+
+	if v.MaxSpike > 0 && previous != nil && math.Abs(reading.TemperatureCelsius-previous.celsius) > v.MaxSpike {
+		return models.QuarantineSpike
+	}
+	return ""
+}
+
+// previousValue returns the sensor's latest accepted reading within the spike
+// window before ts, from those accepted earlier in the request or else from
+// the database
+func (v *ReadingValidation) previousValue(tx *gorm.DB, accepted map[sensorKey]sensorValue, reading *temperaturev1.TemperatureReading, ts time.Time) (*sensorValue, error) {
+	if v.MaxSpike <= 0 {
+		return nil, nil
+	}
+	since := ts.Add(-v.SpikeWindow)
+	if last, ok := accepted[sensorKey{reading.ClientId, reading.SensorId}]; ok && last.at.Before(ts) && !last.at.Before(since) {
+		return &last, nil
+	}
+
+	var stored models.TemperatureReading
+	err := tx.Select("temperature_celsius", "created_at").
+		Where("client_id = ? AND sensor_id = ?", reading.ClientId, reading.SensorId).
+		Where("created_at >= ? AND created_at < ?", since, ts).
+		Order("created_at DESC").
+		Take(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sensorValue{celsius: stored.TemperatureCelsius, at: stored.CreatedAt}, nil
+}
+
+// quarantine saves a reading that failed validation
+func quarantine(tx *gorm.DB, reading *temperaturev1.TemperatureReading, ts time.Time, reason string, previous *sensorValue) error {
+	readingsQuarantined.Inc(reason)
+	q := &models.QuarantinedReading{
+		ClientID:           reading.ClientId,
+		SensorID:           reading.SensorId,
+		SensorType:         reading.SensorType,
+		SensorName:         reading.SensorName,
+		TemperatureCelsius: reading.TemperatureCelsius,
+		Reason:             reason,
+		ReadingTime:        ts,
+	}
+	if summary := reading.Summary; summary != nil {
+		q.MinCelsius = &summary.MinCelsius
+		q.MaxCelsius = &summary.MaxCelsius
+		q.AvgCelsius = &summary.AvgCelsius
+		q.SampleCount = summary.SampleCount
+	}
+	if reason == models.QuarantineSpike && previous != nil {
+		q.PreviousCelsius = &previous.celsius
+	}
+	return tx.Create(q).Error
+}
+
+func (s *TemperatureService) ListQuarantinedReadings(ctx context.Context, req *temperaturev1.ListQuarantinedReadingsRequest) (*temperaturev1.ListQuarantinedReadingsResponse, error) {
+	if req.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must not be negative")
+	}
+	query := s.db.WithContext(ctx).Model(&models.QuarantinedReading{})
+
+	if req.ClientId != "" {
+		query = query.Where("client_id = ?", req.ClientId)
+	}
+	if req.SensorId != "" {
+		query = query.Where("sensor_id = ?", req.SensorId)
+	}
+	switch req.Reason {
+	case "":
+	case models.QuarantineRejectedValue, models.QuarantineOutOfRange, models.QuarantineSpike:
+		query = query.Where("reason = ?", req.Reason)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown reason %q: must be %s, %s or %s", req.Reason,
+			models.QuarantineRejectedValue, models.QuarantineOutOfRange, models.QuarantineSpike)
+	}
+	if req.StartTime != nil {
+		query = query.Where("created_at >= ?", req.StartTime.AsTime())
+	}
+	if req.EndTime != nil {
+		query = query.Where("created_at <= ?", req.EndTime.AsTime())
+	}
+
+	var totalCount int64
+	if err := query.Session(&gorm.Session{}).Count(&totalCount).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to count quarantined readings")
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var readings []models.QuarantinedReading
+	err := query.Order("created_at DESC").Order("id DESC").Limit(limit).Offset(int(req.Offset)).Find(&readings).Error
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to list quarantined readings")
+	}
+
+	protoReadings := make([]*temperaturev1.QuarantinedReading, len(readings))
+	for i := range readings {
+		protoReadings[i] = modelToProtoQuarantinedReading(&readings[i])
+	}
+	return &temperaturev1.ListQuarantinedReadingsResponse{
+		Readings:   protoReadings,
+		TotalCount: totalCount,
+	}, nil
+}
+
+// Helper function to convert a quarantined reading to proto
+func modelToProtoQuarantinedReading(q *models.QuarantinedReading) *temperaturev1.QuarantinedReading {
+	reading := &temperaturev1.QuarantinedReading{
+		ClientId:           q.ClientID,
+		SensorId:           q.SensorID,
+		SensorType:         q.SensorType,
+		SensorName:         q.SensorName,
+		TemperatureCelsius: q.TemperatureCelsius,
+		Reason:             q.Reason,
+		Timestamp:          timestamppb.New(q.ReadingTime),
+		QuarantinedAt:      timestamppb.New(q.CreatedAt),
+	}
+	if q.MinCelsius != nil && q.MaxCelsius != nil && q.AvgCelsius != nil {
+		reading.Summary = &temperaturev1.ReadingSummary{
+			MinCelsius:  *q.MinCelsius,
+			MaxCelsius:  *q.MaxCelsius,
+			AvgCelsius:  *q.AvgCelsius,
+			SampleCount: q.SampleCount,
+		}
+	}
+	if q.PreviousCelsius != nil {
+		reading.PreviousCelsius = *q.PreviousCelsius
+	}
+	return reading
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/google/uuid"
+	jacuzziv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1"
+	webhookv1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/webhook/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/apierror"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+type WebhookService struct {
+	jacuzziv1.UnimplementedWebhookServiceServer
+	db *gorm.DB
+}
+
+func NewWebhookService(db *gorm.DB) *WebhookService {
+	return &WebhookService{db: db}
+}
+
+func (s *WebhookService) CreateWebhook(ctx context.Context, req *webhookv1.CreateWebhookRequest) (*webhookv1.CreateWebhookResponse, error) {
+	if req.Webhook == nil {
+		return nil, status.Error(codes.InvalidArgument, "webhook is required")
+	}
+
+	hook := &models.Webhook{WebhookID: uuid.New().String()}
+	if err := applyWebhook(hook, req.Webhook); err != nil {
+		return nil, err
+	}
+	hook.Secret = req.Webhook.Secret
+	if err := s.db.WithContext(ctx).Create(hook).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to create webhook")
+	}
+
+	return &webhookv1.CreateWebhookResponse{Webhook: modelToProtoWebhook(hook)}, nil
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context, req *webhookv1.ListWebhooksRequest) (*webhookv1.ListWebhooksResponse, error) {
+	var hooks []models.Webhook
+	if err := s.db.WithContext(ctx).Order("name, webhook_id").Find(&hooks).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to list webhooks")
+	}
+
+	protoHooks := make([]*webhookv1.Webhook, len(hooks))
+	for i := range hooks {
+		protoHooks[i] = modelToProtoWebhook(&hooks[i])
+	}
+	return &webhookv1.ListWebhooksResponse{
+		Webhooks:   protoHooks,
+		EventTypes: webhook.EventTypes,
+	}, nil
+}
+
+func (s *WebhookService) UpdateWebhook(ctx context.Context, req *webhookv1.UpdateWebhookRequest) (*webhookv1.UpdateWebhookResponse, error) {
+	if req.Webhook == nil || req.Webhook.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "webhook id is required")
+	}
+
+	var hook models.Webhook
+	if err := s.db.WithContext(ctx).Where("webhook_id = ?", req.Webhook.Id).First(&hook).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "webhook not found")
+		}
+		return nil, apierror.Wrap(err, "failed to get webhook")
+	}
+	if err := applyWebhook(&hook, req.Webhook); err != nil {
+		return nil, err
+	}
+	if req.ClearSecret {
+		hook.Secret = ""
+	} else if req.Webhook.Secret != "" {
+		hook.Secret = req.Webhook.Secret
+	}
+	if err := s.db.WithContext(ctx).Save(&hook).Error; err != nil {
+		return nil, apierror.Wrap(err, "failed to update webhook")
+	}
+
+	return &webhookv1.UpdateWebhookResponse{Webhook: modelToProtoWebhook(&hook)}, nil
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, req *webhookv1.DeleteWebhookRequest) (*webhookv1.DeleteWebhookResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "webhook id is required")
+	}
+
+	result := s.db.WithContext(ctx).Where("webhook_id = ?", req.Id).Delete(&models.Webhook{})
+	if result.Error != nil {
+		return nil, apierror.Wrap(result.Error, "failed to delete webhook")
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "webhook not found")
+	}
+
+	return &webhookv1.DeleteWebhookResponse{
+		Success: true,
+		Message: "Webhook deleted successfully",
+	}, nil
+}
+
+// applyWebhook validates and copies the editable fields of a webhook, except
+// the secret, onto its model
+func applyWebhook(hook *models.Webhook, from *webhookv1.Webhook) error {
+	if from.Name == "" {
+		return status.Error(codes.InvalidArgument, "webhook name is required")
+	}
+	u, err := url.Parse(from.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return status.Errorf(codes.InvalidArgument, "invalid webhook url %q: must be an absolute http or https URL", from.Url)
+	}
+	eventTypes, err := webhook.EncodeEventTypes(from.EventTypes)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid event_types: %v", err)
+	}
+
+	hook.Name = from.Name
+	hook.URL = from.Url
+	hook.EventTypes = eventTypes
+	hook.Enabled = from.Enabled
+	return nil
+}
+
+func modelToProtoWebhook(hook *models.Webhook) *webhookv1.Webhook {
+	// Event types are validated when stored
+	eventTypes, _ := webhook.DecodeEventTypes(hook.EventTypes)
+	protoHook := &webhookv1.Webhook{
+		Id:         hook.WebhookID,
+		Name:       hook.Name,
+		Url:        hook.URL,
+		HasSecret:  hook.Secret != "",
+		EventTypes: eventTypes,
+		Enabled:    hook.Enabled,
+		LastStatus: hook.LastStatus,
+		CreatedAt:  timestamppb.New(hook.CreatedAt),
+		UpdatedAt:  timestamppb.New(hook.UpdatedAt),
+	}
+	if hook.LastDeliveryAt != nil {
+		protoHook.LastDeliveryAt = timestamppb.New(*hook.LastDeliveryAt)
+	}
+	return protoHook
+}
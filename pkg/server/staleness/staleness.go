@@ -0,0 +1,547 @@
+package staleness
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertevents"
+	"github.com/nickheyer/jacuzzi/pkg/server/alertlog"
+	"github.com/nickheyer/jacuzzi/pkg/server/commands"
+	"github.com/nickheyer/jacuzzi/pkg/server/events"
+	"github.com/nickheyer/jacuzzi/pkg/server/i18n"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/mute"
+	"github.com/nickheyer/jacuzzi/pkg/server/notify"
+	"github.com/nickheyer/jacuzzi/pkg/server/scripting"
+	"github.com/nickheyer/jacuzzi/pkg/server/webhook"
+	"gorm.io/gorm"
+)
+
+// Checker flags sensors that stop reporting while their client keeps
+// reporting, such as a failed drive, raises alerts for stale sensor, sensor
+// quality and client offline rules, retires sensors that have not reported for a long
+// time, such as a removed GPU, and marks clients offline when they stop
+// reporting
+type Checker struct {
+	db  *gorm.DB
+	cfg Config
+}
+
+// Config controls what the checker looks for
+type Config struct {
+	StaleAfter   time.Duration // 0 disables stale checks
+	RetireAfter  time.Duration // 0 disables retirement
+	OfflineAfter time.Duration // 0 disables offline checks
+
+	// Notified of retired sensors and offline clients; nil disables
+	Webhooks *webhook.Dispatcher
+
+	// Runs scripts on triggered alerts; nil disables
+	Scripts *scripting.Engine
+
+	// Sends triggered and resolved alerts to notification channels; nil
+	// disables
+	Notify *notify.Router
+
+	// Hub that alert events are published to for streaming subscribers; nil
+	// disables
+	Events *events.Hub
+}
+
+func NewChecker(db *gorm.DB, cfg Config) *Checker {
+	return &Checker{db: db, cfg: cfg}
+}
+
+// RunOnce marks silent clients offline, retires long-silent sensors, flags
+// newly stale sensors, and updates stale sensor, sensor quality and client
+// offline alerts
+func (c *Checker) RunOnce(ctx context.Context, now time.Time) error {
+	db := c.db.WithContext(ctx)
+	if c.cfg.OfflineAfter > 0 {
+		if err := c.offline(db, now); err != nil {
+			return err
+		}
+	}
+	if c.cfg.RetireAfter > 0 {
+		if err := c.retire(db, now); err != nil {
+			return err
+		}
+	}
+	if c.cfg.StaleAfter > 0 {
+		if err := c.flag(db, now); err != nil {
+			return err
+		}
+	}
+	if err := c.evaluate(db, now); err != nil {
+		return err
+	}
+	if err := c.evaluateQuality(db, now); err != nil {
+		return err
+	}
+	return c.evaluateOffline(db, now)
+}
+
+// offline marks clients that have not reported within the offline period. A
+// client is marked online again by its next report.
+func (c *Checker) offline(db *gorm.DB, now time.Time) error {
+	cutoff := now.Add(-c.cfg.OfflineAfter)
+	var clients []models.Client
+	if err := db.Where("is_online = ? AND last_seen < ?", true, cutoff).Find(&clients).Error; err != nil {
+		return fmt.Errorf("failed to find offline clients: %w", err)
+	}
+
+	for i := range clients {
+		client := &clients[i]
+		// Recheck in case the client reported since the query above
+		result := db.Model(&models.Client{}).
+			Where("id = ? AND is_online = ? AND last_seen < ?", client.ID, true, cutoff).
+			UpdateColumn("is_online", false)
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark client %s offline: %w", client.ClientID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		log.Printf("Client %s went offline; last seen at %s", client.ClientID, client.LastSeen.Format(time.RFC3339))
+		client.IsOnline = false
+		activity.Client(db, activity.ClientOffline, client, "Client went offline; last seen at "+client.LastSeen.Format(time.RFC3339))
+		if mute.Muted(db, now) {
+			continue
+		}
+		c.cfg.Webhooks.ClientOffline(client)
+	}
+	return nil
+}
+
+// retire hides sensors that have neither reported nor been changed, such as
+// restored by hand, within the retirement period. A retired sensor is
+// restored when it reports again.
+func (c *Checker) retire(db *gorm.DB, now time.Time) error {
+	cutoff := now.Add(-c.cfg.RetireAfter)
+	silent := "retired_at IS NULL AND updated_at < ? AND (last_reading_at < ? OR last_reading_at IS NULL)"
+	var sensors []models.Sensor
+	if err := db.Where(silent, cutoff, cutoff).Find(&sensors).Error; err != nil {
+		return fmt.Errorf("failed to find sensors to retire: %w", err)
+	}
+
+	retired := 0
+	for i := range sensors {
+		sensor := &sensors[i]
+		// Recheck in case the sensor reported since the query above
+		result := db.Model(&models.Sensor{}).
+			Where("id = ?", sensor.ID).
+			Where(silent, cutoff, cutoff).
+			UpdateColumns(map[string]interface{}{"retired_at": now, "stale_since": nil})
+		if result.Error != nil {
+			return fmt.Errorf("failed to retire sensor %s on client %s: %w", sensor.SensorID, sensor.ClientID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		retired++
+		sensor.RetiredAt = &now
+		activity.Record(db, activity.Event{
+			Type:     activity.SensorRetired,
+			ClientID: sensor.ClientID,
+			SensorID: sensor.SensorID,
+			Message:  "Sensor retired after no readings since " + cutoff.Format(time.RFC3339),
+		})
+		c.cfg.Webhooks.SensorRetired(sensor)
+	}
+	if retired > 0 {
+		log.Printf("Retired %d sensors with no readings since %s", retired, cutoff.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// flag marks sensors whose last reading is older than the stale threshold
+// while their client has reported since. A sensor is unflagged by its next
+// reading, at ingest.
+func (c *Checker) flag(db *gorm.DB, now time.Time) error {
+	cutoff := now.Add(-c.cfg.StaleAfter)
+	reporting := db.Model(&models.Client{}).
+		Select("client_id").
+		Where("status = ? AND is_online = ? AND last_seen >= ?", models.ClientStatusApproved, true, cutoff)
+
+	var sensors []models.Sensor
+	err := db.Where("stale_since IS NULL AND retired_at IS NULL AND last_reading_at < ? AND client_id IN (?)", cutoff, reporting).
+		Find(&sensors).Error
+	if err != nil {
+		return fmt.Errorf("failed to find stale sensors: %w", err)
+	}
+	if len(sensors) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(sensors))
+	for i, sensor := range sensors {
+		ids[i] = sensor.ID
+		log.Printf("Sensor %s on client %s stopped reporting; last reading at %s",
+			sensor.SensorID, sensor.ClientID, sensor.LastReadingAt.Format(time.RFC3339))
+	}
+	// Recheck the age in case readings arrived since the query above. The flag
+	// leaves updated_at alone so it does not delay retirement.
+	err = db.Model(&models.Sensor{}).
+		Where("id IN ? AND stale_since IS NULL AND last_reading_at < ?", ids, cutoff).
+		UpdateColumn("stale_since", now).Error
+	if err != nil {
+		return fmt.Errorf("failed to flag stale sensors: %w", err)
+	}
+	return nil
+}
+
+// evaluate raises an alert for every stale sensor matched by an enabled stale
+// sensor rule once it has been stale for the rule's duration, and resolves
+// alerts whose sensor reports again or was retired
+func (c *Checker) evaluate(db *gorm.DB, now time.Time) error {
+	var rules []models.AlertRule
+	err := db.Preload("Actions").
+		Where("enabled = ? AND condition_type = ?", true, models.ConditionTypeStaleSensor).
+		Find(&rules).Error
+	if err != nil {
+		return fmt.Errorf("failed to load stale sensor rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var stale []models.Sensor
+	if err := db.Where("stale_since IS NOT NULL").Find(&stale).Error; err != nil {
+		return fmt.Errorf("failed to load stale sensors: %w", err)
+	}
+
+	ruleIDs := make([]string, len(rules))
+	for i, rule := range rules {
+		ruleIDs[i] = rule.RuleID
+	}
+	var active []models.Alert
+	if err := db.Where("is_active = ? AND rule_id IN ?", true, ruleIDs).Find(&active).Error; err != nil {
+		return fmt.Errorf("failed to load active alerts: %w", err)
+	}
+	type alertKey struct{ rule, client, sensor string }
+	open := make(map[alertKey]models.Alert, len(active))
+	for _, alert := range active {
+		open[alertKey{alert.RuleID, alert.ClientID, alert.SensorID}] = alert
+	}
+
+	for _, rule := range rules {
+		for _, sensor := range stale {
+			if !matches(rule, sensor) {
+				continue
+			}
+			key := alertKey{rule.RuleID, sensor.ClientID, sensor.SensorID}
+			if _, ok := open[key]; ok {
+				// Still stale; keep the alert open
+				delete(open, key)
+				continue
+			}
+			if now.Sub(*sensor.StaleSince) < time.Duration(rule.DurationSeconds)*time.Second {
+				continue
+			}
+			if err := c.trigger(db, rule, sensor, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Alerts left over belong to sensors that are no longer stale
+	for _, alert := range open {
+		resolvedAt := now
+		err := db.Model(&models.Alert{}).
+			Where("id = ?", alert.ID).
+			Updates(map[string]interface{}{"is_active": false, "resolved_at": &resolvedAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to resolve alert %s: %w", alert.AlertID, err)
+		}
+		log.Printf("Resolved stale sensor alert %s for sensor %s on client %s", alert.AlertID, alert.SensorID, alert.ClientID)
+		alert.IsActive = false
+		alert.ResolvedAt = &resolvedAt
+		alertevents.Publish(db, c.cfg.Events, activity.AlertResolved, &alert, nil)
+		c.cfg.Notify.Resolved(&alert)
+	}
+	return nil
+}
+
+func matches(rule models.AlertRule, sensor models.Sensor) bool {
+	return (rule.ClientID == "" || rule.ClientID == sensor.ClientID) &&
+		(rule.SensorID == "" || rule.SensorID == sensor.SensorID) &&
+		(rule.SensorType == "" || rule.SensorType == sensor.SensorType)
+}
+
+// messageLocale returns the language of alert messages
+func messageLocale(db *gorm.DB) i18n.Locale {
+	locale, err := i18n.Load(db)
+	if err != nil {
+		log.Printf("Writing alert messages in the default locale: %v", err)
+	}
+	return locale
+}
+
+func (c *Checker) trigger(db *gorm.DB, rule models.AlertRule, sensor models.Sensor, now time.Time) error {
+	name := sensor.SensorID
+	if sensor.SensorName != "" {
+		name = sensor.SensorName
+	}
+	// The value is the time since the last reading, in seconds
+	var silent time.Duration
+	if sensor.LastReadingAt != nil {
+		silent = now.Sub(*sensor.LastReadingAt)
+	}
+	alert := &models.Alert{
+		AlertID:     uuid.New().String(),
+		RuleID:      rule.RuleID,
+		ClientID:    sensor.ClientID,
+		SensorID:    sensor.SensorID,
+		Value:       silent.Seconds(),
+		TriggeredAt: now,
+		IsActive:    true,
+		Severity:    rule.Severity,
+		Message:     messageLocale(db).T("%s: sensor %s has not reported for %s", rule.Name, name, silent.Round(time.Second)),
+		Muted:       mute.Silenced(db, rule, now),
+	}
+	if err := db.Create(alert).Error; err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	log.Printf("Triggered stale sensor alert %s for sensor %s on client %s", alert.AlertID, sensor.SensorID, sensor.ClientID)
+	alertevents.Publish(db, c.cfg.Events, activity.AlertTriggered, alert, nil)
+	c.notify(db, rule, alert)
+	return nil
+}
+
+// evaluateQuality raises an alert for every scored sensor matched by an
+// enabled sensor quality rule whose quality score is below the rule's
+// threshold, and resolves alerts whose sensor scores at or above it again or
+// was retired
+func (c *Checker) evaluateQuality(db *gorm.DB, now time.Time) error {
+	var rules []models.AlertRule
+	err := db.Preload("Actions").
+		Where("enabled = ? AND condition_type = ?", true, models.ConditionTypeSensorQuality).
+		Find(&rules).Error
+	if err != nil {
+		return fmt.Errorf("failed to load sensor quality rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var scored []models.Sensor
+	if err := db.Where("quality_score IS NOT NULL AND retired_at IS NULL").Find(&scored).Error; err != nil {
+		return fmt.Errorf("failed to load scored sensors: %w", err)
+	}
+
+	ruleIDs := make([]string, len(rules))
+	for i, rule := range rules {
+		ruleIDs[i] = rule.RuleID
+	}
+	var active []models.Alert
+	if err := db.Where("is_active = ? AND rule_id IN ?", true, ruleIDs).Find(&active).Error; err != nil {
+		return fmt.Errorf("failed to load active alerts: %w", err)
+	}
+	type alertKey struct{ rule, client, sensor string }
+	open := make(map[alertKey]models.Alert, len(active))
+	for _, alert := range active {
+		open[alertKey{alert.RuleID, alert.ClientID, alert.SensorID}] = alert
+	}
+
+	for _, rule := range rules {
+		for _, sensor := range scored {
+			if !matches(rule, sensor) || *sensor.Quality.Score >= rule.Threshold {
+				continue
+			}
+			key := alertKey{rule.RuleID, sensor.ClientID, sensor.SensorID}
+			if _, ok := open[key]; ok {
+				// Still degraded; keep the alert open
+				delete(open, key)
+				continue
+			}
+			if err := c.triggerQuality(db, rule, sensor, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Alerts left over belong to sensors that score well again, or that were
+	// retired
+	for _, alert := range open {
+		resolvedAt := now
+		err := db.Model(&models.Alert{}).
+			Where("id = ?", alert.ID).
+			Updates(map[string]interface{}{"is_active": false, "resolved_at": &resolvedAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to resolve alert %s: %w", alert.AlertID, err)
+		}
+		log.Printf("Resolved sensor quality alert %s for sensor %s on client %s", alert.AlertID, alert.SensorID, alert.ClientID)
+		alert.IsActive = false
+		alert.ResolvedAt = &resolvedAt
+		alertevents.Publish(db, c.cfg.Events, activity.AlertResolved, &alert, nil)
+		c.cfg.Notify.Resolved(&alert)
+	}
+	return nil
+}
+
+func (c *Checker) triggerQuality(db *gorm.DB, rule models.AlertRule, sensor models.Sensor, now time.Time) error {
+	name := sensor.SensorID
+	if sensor.SensorName != "" {
+		name = sensor.SensorName
+	}
+	l := messageLocale(db)
+	q := sensor.Quality
+	var causes []string
+	if q.Coverage < 1 {
+		causes = append(causes, l.T("%.0f%% coverage", q.Coverage*100))
+	}
+	if q.Quarantined > 0 {
+		causes = append(causes, l.T("%d quarantined readings", q.Quarantined))
+	}
+	if q.Flatline {
+		causes = append(causes, l.T("flatlined"))
+	}
+	if q.Noisy {
+		causes = append(causes, l.T("noisy at %.1f °C between readings", q.Noise))
+	}
+	message := l.T("%s: sensor %s has a quality score of %.1f", rule.Name, name, *q.Score)
+	if len(causes) > 0 {
+		message += " (" + strings.Join(causes, ", ") + ")"
+	}
+
+	// The value is the quality score
+	alert := &models.Alert{
+		AlertID:     uuid.New().String(),
+		RuleID:      rule.RuleID,
+		ClientID:    sensor.ClientID,
+		SensorID:    sensor.SensorID,
+		Value:       *q.Score,
+		TriggeredAt: now,
+		IsActive:    true,
+		Severity:    rule.Severity,
+		Message:     message,
+		Muted:       mute.Silenced(db, rule, now),
+	}
+	if err := db.Create(alert).Error; err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	log.Printf("Triggered sensor quality alert %s for sensor %s on client %s", alert.AlertID, sensor.SensorID, sensor.ClientID)
+	alertevents.Publish(db, c.cfg.Events, activity.AlertTriggered, alert, nil)
+	c.notify(db, rule, alert)
+	return nil
+}
+
+// evaluateOffline raises an alert for every approved client matched by an
+// enabled client offline rule that has not reported for the rule's duration,
+// and resolves alerts whose client reports again. Rules use their own
+// duration rather than clients.offline_after, so they work with offline
+// checks disabled.
+func (c *Checker) evaluateOffline(db *gorm.DB, now time.Time) error {
+	var rules []models.AlertRule
+	err := db.Preload("Actions").
+		Where("enabled = ? AND condition_type = ?", true, models.ConditionTypeClientOffline).
+		Find(&rules).Error
+	if err != nil {
+		return fmt.Errorf("failed to load client offline rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var clients []models.Client
+	if err := db.Where("status = ?", models.ClientStatusApproved).Find(&clients).Error; err != nil {
+		return fmt.Errorf("failed to load clients: %w", err)
+	}
+
+	ruleIDs := make([]string, len(rules))
+	for i, rule := range rules {
+		ruleIDs[i] = rule.RuleID
+	}
+	var active []models.Alert
+	if err := db.Where("is_active = ? AND rule_id IN ?", true, ruleIDs).Find(&active).Error; err != nil {
+		return fmt.Errorf("failed to load active alerts: %w", err)
+	}
+	type alertKey struct{ rule, client string }
+	open := make(map[alertKey]models.Alert, len(active))
+	for _, alert := range active {
+		open[alertKey{alert.RuleID, alert.ClientID}] = alert
+	}
+
+	for _, rule := range rules {
+		for _, client := range clients {
+			if rule.ClientID != "" && rule.ClientID != client.ClientID {
+				continue
+			}
+			if now.Sub(client.LastSeen) < time.Duration(rule.DurationSeconds)*time.Second {
+				continue
+			}
+			key := alertKey{rule.RuleID, client.ClientID}
+			if _, ok := open[key]; ok {
+				// Still silent; keep the alert open
+				delete(open, key)
+				continue
+			}
+			if err := c.triggerOffline(db, rule, client, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Alerts left over belong to clients that report again, or that were
+	// removed or rejected
+	for _, alert := range open {
+		resolvedAt := now
+		err := db.Model(&models.Alert{}).
+			Where("id = ?", alert.ID).
+			Updates(map[string]interface{}{"is_active": false, "resolved_at": &resolvedAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to resolve alert %s: %w", alert.AlertID, err)
+		}
+		log.Printf("Resolved client offline alert %s for client %s", alert.AlertID, alert.ClientID)
+		alert.IsActive = false
+		alert.ResolvedAt = &resolvedAt
+		alertevents.Publish(db, c.cfg.Events, activity.AlertResolved, &alert, nil)
+		c.cfg.Notify.Resolved(&alert)
+	}
+	return nil
+}
+
+func (c *Checker) triggerOffline(db *gorm.DB, rule models.AlertRule, client models.Client, now time.Time) error {
+	name := client.ClientID
+	if client.Hostname != "" {
+		name = client.Hostname
+	}
+	// The value is the time since the client last reported, in seconds
+	silent := now.Sub(client.LastSeen)
+	alert := &models.Alert{
+		AlertID:     uuid.New().String(),
+		RuleID:      rule.RuleID,
+		ClientID:    client.ClientID,
+		Value:       silent.Seconds(),
+		TriggeredAt: now,
+		IsActive:    true,
+		Severity:    rule.Severity,
+		Message:     messageLocale(db).T("%s: client %s has not reported for %s", rule.Name, name, silent.Round(time.Second)),
+		Muted:       mute.Silenced(db, rule, now),
+	}
+	if err := db.Create(alert).Error; err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	log.Printf("Triggered client offline alert %s for client %s", alert.AlertID, client.ClientID)
+	alertevents.Publish(db, c.cfg.Events, activity.AlertTriggered, alert, nil)
+	c.notify(db, rule, alert)
+	return nil
+}
+
+// notify runs the scripts, emergency actions and log actions of a triggered
+// alert, unless it was raised while muted
+func (c *Checker) notify(db *gorm.DB, rule models.AlertRule, alert *models.Alert) {
+	if alert.Muted {
+		log.Printf("Skipped notifications of alert %s: notifications are muted or rule %s is snoozed", alert.AlertID, rule.RuleID)
+		return
+	}
+	c.cfg.Scripts.OnAlert(alert)
+	commands.RunEmergencyActions(db, rule, alert)
+	alertlog.Run(db, rule, alert)
+	c.cfg.Notify.Triggered(alert)
+}
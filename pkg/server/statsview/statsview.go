@@ -0,0 +1,124 @@
+// Package statsview answers stats requests for whole hours from the hourly
+// and daily rollups, so they aggregate a row per sensor-hour or sensor-day
+// rather than every reading. The rollup worker keeps the rollups current
+// incrementally from its watermark; requests read them for the whole hours
+// before the watermark and raw readings for the rest, so results stay
+// current. The rollups leave out burst captures, which are read raw in every
+// hour, and outlast the retention of raw readings.
+package statsview
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// rollupWatermark is the watermark of the rollup worker
+const rollupWatermark = "temperature_rollups"
+
+// Views and the watermark of their refreshes, from before stats were read
+// from the rollups
+var (
+	oldViews     = []string{"temperature_stats_daily", "temperature_stats_hourly"}
+	oldWatermark = "stats_views"
+)
+
+// DropViews drops the materialized views earlier versions kept on Postgres,
+// and must run before the tables they read are migrated, which Postgres
+// would refuse to alter
+func DropViews(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, view := range oldViews {
+		if err := db.Exec("DROP MATERIALIZED VIEW IF EXISTS " + view).Error; err != nil {
+			return fmt.Errorf("failed to drop %s: %w", view, err)
+		}
+	}
+	if db.Migrator().HasTable(&models.RollupWatermark{}) {
+		if err := db.Where("name = ?", oldWatermark).Delete(&models.RollupWatermark{}).Error; err != nil {
+			return fmt.Errorf("failed to delete watermark of %s: %w", oldWatermark, err)
+		}
+	}
+	return nil
+}
+
+// Window is a range of whole UTC hours the rollups answer for. Start is zero
+// when the range has no start.
+type Window struct {
+	Start time.Time
+	End   time.Time // Exclusive
+}
+
+// Covering returns the window of [start, end] the rollups answer for: the
+// whole hours in it before the rollup watermark. Zero start or end leave the
+// range open. It returns false when there is no such window, such as before
+// the rollup worker first ran. Only use it while the worker runs, as the
+// rollups miss readings that arrived after it stopped.
+func Covering(db *gorm.DB, start, end time.Time) (Window, bool, error) {
+	var wm models.RollupWatermark
+	if err := db.Where("name = ?", rollupWatermark).Limit(1).Find(&wm).Error; err != nil {
+		return Window{}, false, fmt.Errorf("failed to load watermark: %w", err)
+	}
+	if wm.Watermark.IsZero() {
+		return Window{}, false, nil
+	}
+
+	w := Window{End: wm.Watermark.UTC().Truncate(time.Hour)}
+	if !end.IsZero() && end.UTC().Truncate(time.Hour).Before(w.End) {
+		w.End = end.UTC().Truncate(time.Hour)
+	}
+	if !start.IsZero() {
+		w.Start = ceil(start.UTC(), time.Hour)
+		if !w.Start.Before(w.End) {
+			return Window{}, false, nil
+		}
+	}
+	return w, true, nil
+}
+
+// Within returns the part of the window in the whole hours of [start, end),
+// and false when there is none
+func (w Window) Within(start, end time.Time) (Window, bool) {
+	within := Window{Start: ceil(start.UTC(), time.Hour), End: end.UTC().Truncate(time.Hour)}
+	if within.Start.Before(w.Start) {
+		within.Start = w.Start
+	}
+	if w.End.Before(within.End) {
+		within.End = w.End
+	}
+	return within, within.Start.Before(within.End)
+}
+
+// Contains reports whether t is in the window
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// Rows selects the stats of every sensor-hour or sensor-day in a window,
+// from the daily rollups for whole days and the hourly ones for the hours
+// around them: client_id, sensor_id, bucket, min_celsius, max_celsius,
+// sum_celsius (of averages) and reading_count
+func Rows(db *gorm.DB, w Window) *gorm.DB {
+	const columns = "SELECT client_id, sensor_id, bucket_start AS bucket, min_temp AS min_celsius, max_temp AS max_celsius, " +
+		"avg_temp * count AS sum_celsius, count AS reading_count FROM temperature_rollups WHERE bucket_seconds = ? AND bucket_start >= ? AND bucket_start < ?"
+	hour, day := int64(time.Hour/time.Second), int64(24*time.Hour/time.Second)
+	firstDay := ceil(w.Start, 24*time.Hour)
+	lastDay := w.End.Truncate(24 * time.Hour)
+	if !firstDay.Before(lastDay) {
+		return db.Raw(columns, hour, w.Start, w.End)
+	}
+	return db.Raw(columns+" UNION ALL "+columns+" UNION ALL "+columns,
+		hour, w.Start, firstDay, day, firstDay, lastDay, hour, lastDay, w.End)
+}
+
+// ceil rounds t up to a multiple of d since the zero time, which for UTC
+// times and d of an hour or a day is the next hour or midnight
+func ceil(t time.Time, d time.Duration) time.Time {
+	if floor := t.Truncate(d); floor.Before(t) {
+		return floor.Add(d)
+	}
+	return t
+}
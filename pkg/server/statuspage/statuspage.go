@@ -0,0 +1,332 @@
+package statuspage
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"github.com/nickheyer/jacuzzi/pkg/server/querycache"
+	"gorm.io/gorm"
+)
+
+// Path is where the status page is served
+const Path = "/status"
+
+const (
+	defaultRefresh = 30 * time.Second
+	maxAlerts      = 50
+	staleAfter     = 5 * time.Minute // Readings older than this are shown as stale
+)
+
+//go:embed statuspage.html
+var pageTemplate string
+
+var page = template.Must(template.New("status").Funcs(template.FuncMap{
+	"severityClass": severityClass,
+}).Parse(pageTemplate))
+
+// Handler renders a plain HTML overview of clients, their latest
+// temperatures, and active alerts. It needs no JavaScript, so it works on
+// kiosk displays and in text browsers; the page refreshes itself with a meta
+// tag, every 30 seconds unless ?refresh=<seconds> says otherwise (0 disables).
+type Handler struct {
+	db    *gorm.DB
+	cache *querycache.Cache
+}
+
+// NewHandler creates a handler that keeps the overview in cache, which may
+// be nil
+func NewHandler(db *gorm.DB, cache *querycache.Cache) *Handler {
+	return &Handler{db: db, cache: cache}
+}
+
+type pageData struct {
+	SiteName   string
+	Generated  string
+	Refresh    int
+	Clients    []clientRow
+	Online     int
+	Sites      []siteRow // Empty unless some client has a site
+	Alerts     []alertRow
+	MoreAlerts bool
+}
+
+type clientRow struct {
+	ClientID string
+	Hostname string
+	Location string
+	Online   bool
+	LastSeen string
+	Sensors  []sensorRow
+}
+
+type siteRow struct {
+	Name    string
+	Clients int
+	Online  int
+	Alerts  int
+}
+
+type sensorRow struct {
+	Name        string
+	Temperature string
+	Age         string
+	Stale       bool
+}
+
+type alertRow struct {
+	ClientID     string
+	Site         string
+	SensorID     string
+	Severity     string
+	Value        string
+	Message      string
+	TriggeredAt  string
+	Acknowledged bool
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refresh := int(defaultRefresh.Seconds())
+	if v := r.URL.Query().Get("refresh"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "refresh must be a non-negative number of seconds", http.StatusBadRequest)
+			return
+		}
+		refresh = n
+	}
+
+	var data pageData
+	if cached, ok := h.cache.Get("status", ""); ok {
+		data = cached.(pageData)
+	} else {
+		loaded, err := h.load(r, time.Now())
+		if err != nil {
+			log.Printf("Failed to load status page: %v", err)
+			http.Error(w, "failed to load status", http.StatusInternalServerError)
+			return
+		}
+		data = *loaded
+		h.cache.Set("status", "", "", data)
+	}
+	data.Refresh = refresh
+
+	// Render fully before writing so a template error is not half a page
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		log.Printf("Failed to render status page: %v", err)
+		http.Error(w, "failed to render status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+func (h *Handler) load(r *http.Request, now time.Time) (*pageData, error) {
+	db := h.db.WithContext(r.Context())
+
+	settings, err := loadSettings(db)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(settings["general.timezone"])
+	if err != nil {
+		loc = time.UTC
+	}
+	fahrenheit := settings["display.temperature_unit"] == "fahrenheit"
+	formatTemp := func(celsius float64) string {
+		if fahrenheit {
+			return fmt.Sprintf("%.1f °F", celsius*9/5+32)
+		}
+		return fmt.Sprintf("%.1f °C", celsius)
+	}
+
+	data := &pageData{
+		SiteName:  settings["general.site_name"],
+		Generated: now.In(loc).Format("2006-01-02 15:04:05 MST"),
+	}
+	if data.SiteName == "" {
+		data.SiteName = "Jacuzzi"
+	}
+
+	var clients []models.Client
+	if err := db.Where("status = ?", models.ClientStatusApproved).Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to query clients: %w", err)
+	}
+	// Clients are listed site by site, those without a site last
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Site != clients[j].Site {
+			return clients[j].Site == "" || clients[i].Site != "" && clients[i].Site < clients[j].Site
+		}
+		return clientLabel(clients[i]) < clientLabel(clients[j])
+	})
+
+	// Latest reading of every sensor that is not retired, as in
+	// GetCurrentTemperatures
+	var readings []models.TemperatureReading
+	err = db.Model(&models.TemperatureReading{}).
+		Select("temperature_readings.*").
+		Joins("JOIN sensors ON sensors.client_id = temperature_readings.client_id AND sensors.sensor_id = temperature_readings.sensor_id").
+		Where("sensors.retired_at IS NULL AND temperature_readings.created_at = sensors.last_reading_at").
+		Find(&readings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest readings: %w", err)
+	}
+	byClient := make(map[string][]models.TemperatureReading)
+	for _, reading := range readings {
+		byClient[reading.ClientID] = append(byClient[reading.ClientID], reading)
+	}
+
+	sites := make(map[string]int) // Site name -> index in data.Sites
+	clientSites := make(map[string]string)
+	for _, client := range clients {
+		row := clientRow{
+			ClientID: client.ClientID,
+			Hostname: client.Hostname,
+			Location: locationLabel(client),
+			Online:   client.IsOnline,
+			LastSeen: formatAge(now.Sub(client.LastSeen)),
+		}
+		if client.LastSeen.IsZero() {
+			row.LastSeen = "never"
+		}
+		if row.Online {
+			data.Online++
+		}
+		if client.Site != "" {
+			clientSites[client.ClientID] = client.Site
+			i, ok := sites[client.Site]
+			if !ok {
+				i = len(data.Sites)
+				sites[client.Site] = i
+				data.Sites = append(data.Sites, siteRow{Name: client.Site})
+			}
+			data.Sites[i].Clients++
+			if row.Online {
+				data.Sites[i].Online++
+			}
+		}
+
+		sensors := byClient[client.ClientID]
+		sort.Slice(sensors, func(i, j int) bool { return sensorLabel(sensors[i]) < sensorLabel(sensors[j]) })
+		for _, reading := range sensors {
+			age := now.Sub(reading.CreatedAt)
+			row.Sensors = append(row.Sensors, sensorRow{
+				Name:        sensorLabel(reading),
+				Temperature: formatTemp(reading.TemperatureCelsius),
+				Age:         formatAge(age),
+				Stale:       age > staleAfter,
+			})
+		}
+		data.Clients = append(data.Clients, row)
+	}
+
+	var alerts []models.Alert
+	err = db.Where("is_active = ?", true).
+		Order("triggered_at DESC").
+		Limit(maxAlerts + 1).
+		Find(&alerts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	if len(alerts) > maxAlerts {
+		alerts = alerts[:maxAlerts]
+		data.MoreAlerts = true
+	}
+	for _, alert := range alerts {
+		site := clientSites[alert.ClientID]
+		if i, ok := sites[site]; ok {
+			data.Sites[i].Alerts++
+		}
+		data.Alerts = append(data.Alerts, alertRow{
+			ClientID:     alert.ClientID,
+			Site:         site,
+			SensorID:     alert.SensorID,
+			Severity:     alert.Severity,
+			Value:        formatTemp(alert.Value),
+			Message:      alert.Message,
+			TriggeredAt:  alert.TriggeredAt.In(loc).Format("2006-01-02 15:04"),
+			Acknowledged: alert.AcknowledgedAt != nil,
+		})
+	}
+
+	return data, nil
+}
+
+// loadSettings reads the display settings the page honors
+func loadSettings(db *gorm.DB) (map[string]string, error) {
+	var settings []models.Setting
+	err := db.Where("key IN ?", []string{"general.site_name", "general.timezone", "display.temperature_unit"}).
+		Find(&settings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query settings: %w", err)
+	}
+	values := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		values[setting.Key] = setting.Value
+	}
+	return values, nil
+}
+
+func clientLabel(client models.Client) string {
+	if client.Hostname != "" {
+		return client.Hostname
+	}
+	return client.ClientID
+}
+
+// locationLabel joins the parts of a client's location that are set
+func locationLabel(client models.Client) string {
+	var parts []string
+	for _, part := range []string{client.Site, client.Rack, client.LocationDescription} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+func sensorLabel(reading models.TemperatureReading) string {
+	if reading.SensorName != "" {
+		return reading.SensorName
+	}
+	return reading.SensorID
+}
+
+// formatAge renders a duration as a short "ago" string
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+func severityClass(severity string) string {
+	switch severity {
+	case "SEVERITY_CRITICAL":
+		return "critical"
+	case "SEVERITY_WARNING":
+		return "warning"
+	}
+	return "info"
+}
@@ -0,0 +1,115 @@
+package timezone
+
+import (
+	"fmt"
+	"time"
+	// Embedded so zone names resolve in images without /usr/share/zoneinfo
+	_ "time/tzdata"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Unit is a calendar period in local time
+type Unit int
+
+const (
+	Hour Unit = iota + 1
+	Day
+	Week // Starts on Monday
+	Month
+)
+
+// Load returns the timezone of the general.timezone setting, or UTC when it
+// is unset or not a known zone
+func Load(db *gorm.DB) (*time.Location, error) {
+	var settings []models.Setting
+	if err := db.Where("key = ?", models.SettingTimezone).Limit(1).Find(&settings).Error; err != nil {
+		return nil, fmt.Errorf("failed to query timezone setting: %w", err)
+	}
+	if len(settings) == 0 {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(settings[0].Value)
+	if err != nil {
+		return time.UTC, nil
+	}
+	return loc, nil
+}
+
+// Resolve returns the named timezone, or the configured one when name is
+// empty. An unknown name is an error rather than a silent fallback to UTC.
+func Resolve(db *gorm.DB, name string) (*time.Location, error) {
+	if name == "" {
+		return Load(db)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, &UnknownError{Name: name}
+	}
+	return loc, nil
+}
+
+// UnknownError reports a timezone name that is not in the zone database
+type UnknownError struct {
+	Name string
+}
+
+func (e *UnknownError) Error() string {
+	return fmt.Sprintf("unknown timezone %q", e.Name)
+}
+
+// Floor returns the start of the local hour, day, week or month containing t.
+// Days follow the calendar, so they are 23 or 25 hours long across daylight
+// saving changes.
+func Floor(t time.Time, unit Unit, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch unit {
+	case Hour:
+		// Offsets are not always whole hours, so truncate the wall clock time
+		_, offset := t.Zone()
+		shift := time.Duration(offset) * time.Second
+		return t.Add(shift).Truncate(time.Hour).Add(-shift).In(loc)
+	case Day:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	case Week:
+		back := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return t
+}
+
+// Next returns the start of the local period after the one starting at start
+func Next(start time.Time, unit Unit, loc *time.Location) time.Time {
+	start = start.In(loc)
+	switch unit {
+	case Hour:
+		return Floor(start.Add(time.Hour), Hour, loc)
+	case Day:
+		return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, loc)
+	case Week:
+		return time.Date(start.Year(), start.Month(), start.Day()+7, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, loc)
+	}
+	return start
+}
+
+// Previous returns the start of the local period before the one starting at
+// start
+func Previous(start time.Time, unit Unit, loc *time.Location) time.Time {
+	start = start.In(loc)
+	switch unit {
+	case Hour:
+		return Floor(start.Add(-time.Hour), Hour, loc)
+	case Day:
+		return time.Date(start.Year(), start.Month(), start.Day()-1, 0, 0, 0, 0, loc)
+	case Week:
+		return time.Date(start.Year(), start.Month(), start.Day()-7, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(start.Year(), start.Month()-1, 1, 0, 0, 0, 0, loc)
+	}
+	return start
+}
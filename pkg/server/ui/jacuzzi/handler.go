@@ -0,0 +1,237 @@
+package ui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SvelteKit puts content-hashed assets here; their names change whenever
+	// their content does, so they can be cached forever
+	immutablePrefix = "_app/immutable/"
+
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheStatic     = "public, max-age=3600"
+	cacheRevalidate = "no-cache" // Always revalidate, cheaply, with the ETag
+
+	// Smaller files gain little from compression
+	minCompressSize = 1024
+)
+
+// Pre-compressed variants produced by the static adapter, in preference order
+var encodings = []struct {
+	name   string // Content-Encoding token
+	suffix string // File name suffix of the variant
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// asset is an embedded file with its validator and encoded variants
+type asset struct {
+	name        string
+	contentType string
+	etag        string
+	data        []byte
+	variants    map[string][]byte // Keyed by Content-Encoding
+}
+
+type handler struct {
+	fsys fs.FS
+
+	mu     sync.Mutex
+	assets map[string]*asset // Keyed by file name; nil when the file does not exist
+}
+
+// Handler serves the embedded UI. Hashed assets are cached as immutable and
+// HTML is revalidated with ETags; pre-compressed .br and .gz files are served
+// to clients that accept them, and text files without one are gzipped once on
+// first use. Unknown routes fall back to index.html for client-side routing.
+func Handler() http.Handler {
+	distFS, err := fs.Sub(files, "build")
+	if err != nil {
+		panic(err)
+	}
+	return &handler{fsys: distFS, assets: make(map[string]*asset)}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a := h.resolve(r.URL.Path)
+	if a == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	header := w.Header()
+	switch {
+	case strings.HasPrefix(a.name, immutablePrefix):
+		header.Set("Cache-Control", cacheImmutable)
+	case strings.HasSuffix(a.name, ".html"):
+		header.Set("Cache-Control", cacheRevalidate)
+	default:
+		header.Set("Cache-Control", cacheStatic)
+	}
+	header.Set("Content-Type", a.contentType)
+	header.Add("Vary", "Accept-Encoding")
+
+	data, etag := a.data, a.etag
+	if encoding := negotiate(a, r.Header.Get("Accept-Encoding")); encoding != "" {
+		data = a.variants[encoding]
+		etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+		header.Set("Content-Encoding", encoding)
+	}
+	header.Set("ETag", etag)
+
+	// ServeContent answers If-None-Match and Range requests from the ETag
+	http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(data))
+}
+
+// resolve maps a URL path to an asset. Pages prerendered as page.html or
+// page/index.html are found by their route; other routes without a file
+// extension get index.html so the client-side router can handle them.
+func (h *handler) resolve(urlPath string) *asset {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return h.asset("index.html")
+	}
+
+	for _, candidate := range []string{name, name + ".html", name + "/index.html"} {
+		if a := h.asset(candidate); a != nil {
+			return a
+		}
+	}
+
+	// Missing scripts and styles must 404 rather than return HTML
+	if strings.HasPrefix(name, "_app/") || path.Ext(name) != "" {
+		return nil
+	}
+	return h.asset("index.html")
+}
+
+// asset loads and caches an embedded file
+func (h *handler) asset(name string) *asset {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if a, ok := h.assets[name]; ok {
+		return a
+	}
+
+	// Embedded files never change, so misses are cached too
+	a, _ := h.load(name)
+	h.assets[name] = a
+	return a
+}
+
+func (h *handler) load(name string) (*asset, error) {
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	data, err := fs.ReadFile(h.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	a := &asset{
+		name:        name,
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+		data:        data,
+		variants:    make(map[string][]byte),
+	}
+	if a.contentType == "" {
+		a.contentType = http.DetectContentType(data)
+	}
+
+	for _, enc := range encodings {
+		if variant, err := fs.ReadFile(h.fsys, name+enc.suffix); err == nil {
+			a.variants[enc.name] = variant
+		}
+	}
+	if _, ok := a.variants["gzip"]; !ok && compressible(a.contentType) && len(data) >= minCompressSize {
+		if compressed, err := gzipBytes(data); err == nil && len(compressed) < len(data) {
+			a.variants["gzip"] = compressed
+		}
+	}
+	return a, nil
+}
+
+// negotiate picks the preferred encoding the client accepts and the asset has
+func negotiate(a *asset, acceptEncoding string) string {
+	if len(a.variants) == 0 || acceptEncoding == "" {
+		return ""
+	}
+	// Tokens map to whether they are acceptable; q=0 explicitly refuses one
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		token, params, _ := strings.Cut(part, ";")
+		ok := true
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			q, err := strconv.ParseFloat(value, 64)
+			ok = err == nil && q > 0
+		}
+		accepted[strings.ToLower(strings.TrimSpace(token))] = ok
+	}
+	for _, enc := range encodings {
+		if _, ok := a.variants[enc.name]; !ok {
+			continue
+		}
+		if ok, listed := accepted[enc.name]; listed {
+			if ok {
+				return enc.name
+			}
+		} else if accepted["*"] {
+			return enc.name
+		}
+	}
+	return ""
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/javascript",
+		mediaType == "application/json",
+		mediaType == "application/manifest+json",
+		mediaType == "application/wasm",
+		mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,6 +1,6 @@
 import { createClient, type Client } from "@connectrpc/connect";
 import { createGrpcWebTransport } from "@connectrpc/connect-web";
-import { TemperatureService, ClientService, AlertService, SettingsService } from "./proto/jacuzzi/v1/services_pb";
+import { TemperatureService, ClientService, AlertService, SettingsService, EventService, JobService } from "./proto/jacuzzi/v1/services_pb";
 
 const transport = createGrpcWebTransport({
   baseUrl: window.location.origin,
@@ -9,4 +9,6 @@ const transport = createGrpcWebTransport({
 export const temperatureClient: Client<typeof TemperatureService> = createClient(TemperatureService, transport);
 export const clientClient: Client<typeof ClientService> = createClient(ClientService, transport);
 export const alertClient: Client<typeof AlertService> = createClient(AlertService, transport);
-export const settingsClient: Client<typeof SettingsService> = createClient(SettingsService, transport);
\ No newline at end of file
+export const settingsClient: Client<typeof SettingsService> = createClient(SettingsService, transport);
+export const eventClient: Client<typeof EventService> = createClient(EventService, transport);
+export const jobClient: Client<typeof JobService> = createClient(JobService, transport);
\ No newline at end of file
@@ -9,7 +9,9 @@ const config = {
 	kit: {
 		adapter: adapter({
 			// Single-page app mode
-			fallback: 'index.html'
+			fallback: 'index.html',
+			// Emit .br and .gz files for the server to send as-is
+			precompress: true
 		})
 	},
 };
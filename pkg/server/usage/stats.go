@@ -0,0 +1,221 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/chunks"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// MaxDays bounds the range of a usage report, which counts readings a day at
+// a time
+const MaxDays = 92
+
+// submitMethod is the RPC agents send readings with
+const submitMethod = "/jacuzzi.v1.TemperatureService/SubmitTemperature"
+
+// Totals are the RPCs counted for a method or caller
+type Totals struct {
+	Requests      int64
+	Errors        int64
+	RequestBytes  int64
+	ResponseBytes int64
+}
+
+func (t *Totals) add(o Totals) {
+	t.Requests += o.Requests
+	t.Errors += o.Errors
+	t.RequestBytes += o.RequestBytes
+	t.ResponseBytes += o.ResponseBytes
+}
+
+type MethodUsage struct {
+	Method string
+	Totals
+}
+
+type CallerUsage struct {
+	Caller    string
+	TopMethod string
+	Totals
+}
+
+// ClientDay counts the readings stored for a client on a UTC day
+type ClientDay struct {
+	ClientID string
+	Day      time.Time
+	Readings int64
+}
+
+// Stats is the usage over a range of whole UTC hours
+type Stats struct {
+	Start, End      time.Time
+	Methods         []MethodUsage // Most requests first
+	Callers         []CallerUsage // Most requests first
+	ClientDays      []ClientDay   // By day, then most readings first
+	Total           Totals
+	BytesPerReading int64 // SubmitTemperature request bytes per reading stored; 0 when unknown
+}
+
+// GetStats reports the usage between start and end, listing up to limit
+// methods and callers
+func GetStats(db *gorm.DB, start, end time.Time, limit int) (*Stats, error) {
+	stats := &Stats{
+		Start: start.UTC().Truncate(time.Hour),
+		End:   end.UTC().Truncate(time.Hour),
+	}
+	if stats.End.Before(end) {
+		stats.End = stats.End.Add(time.Hour)
+	}
+
+	var rows []struct {
+		Method string
+		Caller string
+		Totals
+	}
+	err := db.Model(&models.RequestUsage{}).
+		Select(`method, caller,
+			SUM(requests) as requests,
+			SUM(errors) as errors,
+			SUM(request_bytes) as request_bytes,
+			SUM(response_bytes) as response_bytes`).
+		Where("hour >= ? AND hour < ?", stats.Start, stats.End).
+		Group("method, caller").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request usage: %w", err)
+	}
+
+	methods := make(map[string]*MethodUsage)
+	callers := make(map[string]*CallerUsage)
+	callerTop := make(map[string]int64)
+	var submitBytes int64
+	for _, row := range rows {
+		stats.Total.add(row.Totals)
+		if row.Method == submitMethod {
+			submitBytes += row.RequestBytes
+		}
+
+		m, ok := methods[row.Method]
+		if !ok {
+			m = &MethodUsage{Method: row.Method}
+			methods[row.Method] = m
+		}
+		m.add(row.Totals)
+
+		c, ok := callers[row.Caller]
+		if !ok {
+			c = &CallerUsage{Caller: row.Caller}
+			callers[row.Caller] = c
+		}
+		c.add(row.Totals)
+		if row.Requests > callerTop[row.Caller] || row.Requests == callerTop[row.Caller] && row.Method < c.TopMethod {
+			callerTop[row.Caller] = row.Requests
+			c.TopMethod = row.Method
+		}
+	}
+	for _, m := range methods {
+		stats.Methods = append(stats.Methods, *m)
+	}
+	sort.Slice(stats.Methods, func(i, j int) bool {
+		a, b := stats.Methods[i], stats.Methods[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Method < b.Method
+	})
+	for _, c := range callers {
+		stats.Callers = append(stats.Callers, *c)
+	}
+	sort.Slice(stats.Callers, func(i, j int) bool {
+		a, b := stats.Callers[i], stats.Callers[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Caller < b.Caller
+	})
+	if limit > 0 {
+		stats.Methods = stats.Methods[:min(limit, len(stats.Methods))]
+		stats.Callers = stats.Callers[:min(limit, len(stats.Callers))]
+	}
+
+	stats.ClientDays, err = clientDays(db, stats.Start, stats.End)
+	if err != nil {
+		return nil, err
+	}
+	var readings int64
+	for _, day := range stats.ClientDays {
+		readings += day.Readings
+	}
+	if readings > 0 {
+		stats.BytesPerReading = submitBytes / readings
+	}
+	return stats, nil
+}
+
+// clientDays counts the readings stored per client and UTC day, raw and
+// compressed
+func clientDays(db *gorm.DB, start, end time.Time) ([]ClientDay, error) {
+	type clientDay struct {
+		clientID string
+		day      time.Time
+	}
+	counts := make(map[clientDay]int64)
+
+	for day := start.Truncate(24 * time.Hour); day.Before(end); day = day.AddDate(0, 0, 1) {
+		from, to := day, day.AddDate(0, 0, 1)
+		if from.Before(start) {
+			from = start
+		}
+		if end.Before(to) {
+			to = end
+		}
+		var rows []struct {
+			ClientID string
+			Readings int64
+		}
+		err := db.Model(&models.TemperatureReading{}).
+			Select("client_id, COUNT(*) as readings").
+			Where("created_at >= ? AND created_at < ?", from, to).
+			Group("client_id").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to count readings on %s: %w", day.Format(time.DateOnly), err)
+		}
+		for _, row := range rows {
+			counts[clientDay{row.ClientID, day}] += row.Readings
+		}
+	}
+
+	// Compressed readings keep millisecond timestamps, so the last
+	// millisecond before end is the inclusive end of the range
+	filter := chunks.Filter{Start: start, End: end.Add(-time.Millisecond)}
+	compressed, err := chunks.Aggregate(db, filter, func(clientID, _ string, t time.Time) (clientDay, bool) {
+		return clientDay{clientID, t.UTC().Truncate(24 * time.Hour)}, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key, s := range compressed {
+		counts[key] += s.Count
+	}
+
+	days := make([]ClientDay, 0, len(counts))
+	for key, n := range counts {
+		days = append(days, ClientDay{ClientID: key.clientID, Day: key.day, Readings: n})
+	}
+	sort.Slice(days, func(i, j int) bool {
+		a, b := days[i], days[j]
+		if !a.Day.Equal(b.Day) {
+			return a.Day.Before(b.Day)
+		}
+		if a.Readings != b.Readings {
+			return a.Readings > b.Readings
+		}
+		return a.ClientID < b.ClientID
+	})
+	return days, nil
+}
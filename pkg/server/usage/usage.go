@@ -0,0 +1,137 @@
+// Package usage counts the RPCs each caller makes and the bytes they carry,
+// by hour, for the usage stats API. Each replica counts its own calls in
+// memory and adds them to the database on every flush.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type key struct {
+	hour   time.Time
+	method string
+	caller string
+}
+
+type count struct {
+	requests      int64
+	errors        int64
+	requestBytes  int64
+	responseBytes int64
+}
+
+// Recorder counts RPCs until they are flushed. A nil Recorder counts
+// nothing.
+type Recorder struct {
+	mu     sync.Mutex
+	counts map[key]*count
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{counts: make(map[key]*count)}
+}
+
+// Record counts a finished RPC and the encoded size of the messages it
+// received and sent
+func (r *Recorder) Record(method, caller string, failed bool, requestBytes, responseBytes int64) {
+	if r == nil {
+		return
+	}
+	k := key{hour: time.Now().UTC().Truncate(time.Hour), method: method, caller: caller}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counts[k]
+	if !ok {
+		c = &count{}
+		r.counts[k] = c
+	}
+	c.requests++
+	if failed {
+		c.errors++
+	}
+	c.requestBytes += requestBytes
+	c.responseBytes += responseBytes
+}
+
+// Flush adds the counts since the last flush to the database. Counts that
+// fail to save are kept for the next flush.
+func (r *Recorder) Flush(db *gorm.DB) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	counts := r.counts
+	r.counts = make(map[key]*count)
+	r.mu.Unlock()
+
+	var firstErr error
+	for k, c := range counts {
+		err := db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "hour"}, {Name: "method"}, {Name: "caller"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"requests":       gorm.Expr("request_usages.requests + ?", c.requests),
+				"errors":         gorm.Expr("request_usages.errors + ?", c.errors),
+				"request_bytes":  gorm.Expr("request_usages.request_bytes + ?", c.requestBytes),
+				"response_bytes": gorm.Expr("request_usages.response_bytes + ?", c.responseBytes),
+				"updated_at":     time.Now(),
+			}),
+		}).Create(&models.RequestUsage{
+			Hour:          k.hour,
+			Method:        k.method,
+			Caller:        k.caller,
+			Requests:      c.requests,
+			Errors:        c.errors,
+			RequestBytes:  c.requestBytes,
+			ResponseBytes: c.responseBytes,
+		}).Error
+		if err != nil {
+			r.restore(k, c)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to save usage of %s: %w", k.method, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+func (r *Recorder) restore(k key, c *count) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if current, ok := r.counts[k]; ok {
+		current.requests += c.requests
+		current.errors += c.errors
+		current.requestBytes += c.requestBytes
+		current.responseBytes += c.responseBytes
+		return
+	}
+	r.counts[k] = c
+}
+
+// Run flushes every interval until ctx is done. Calls counted since the last
+// flush are left for a final Flush once the servers stop.
+func (r *Recorder) Run(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	if r == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(db.WithContext(ctx)); err != nil {
+				log.Printf("Failed to save request usage: %v", err)
+			}
+		}
+	}
+}
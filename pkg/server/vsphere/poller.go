@@ -0,0 +1,246 @@
+package vsphere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	temperaturev1 "github.com/nickheyer/jacuzzi/pkg/gen/go/jacuzzi/v1/temperature/v1"
+	"github.com/nickheyer/jacuzzi/pkg/server/activity"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// SubmitFunc stores readings through the normal ingest path
+type SubmitFunc func(ctx context.Context, req *temperaturev1.SubmitTemperatureRequest) (*temperaturev1.SubmitTemperatureResponse, error)
+
+// nameTypes maps words in temperature sensor names, such as "Processor 1
+// Temp" or "System Board 1 Inlet Temp", to sensor types, checked in order;
+// others are OTHER
+var nameTypes = []struct{ words, sensorType string }{
+	{"processor", "CPU"},
+	{"cpu", "CPU"},
+	{"gpu", "GPU"},
+	{"memory", "MEMORY"},
+	{"dimm", "MEMORY"},
+	{"disk", "DISK"},
+	{"drive", "DISK"},
+	{"nvme", "DISK"},
+	{"ambient", "AMBIENT"},
+	{"inlet", "AMBIENT"},
+	{"intake", "AMBIENT"},
+	{"system board", "MOTHERBOARD"},
+	{"chipset", "MOTHERBOARD"},
+	{"nic", "NETWORK"},
+	{"battery", "BATTERY"},
+}
+
+// Client metadata of a host's client
+const (
+	// Followed by a fan's name, e.g. vsphere.fan.Fan 1: "5400 RPM"
+	MetadataFanPrefix = "vsphere.fan."
+	// The vCenter Server or ESXi host the host is read through
+	MetadataEndpoint = "vsphere.url"
+)
+
+// Poller reads the hardware sensors of the hosts of vCenter Servers and
+// standalone ESXi hosts and records each host's temperatures as readings of
+// a client representing it, and its fan speeds in the client's metadata, so
+// alert rules, dashboards and offline detection cover hypervisors where no
+// agent can be installed
+type Poller struct {
+	db        *gorm.DB
+	endpoints []*Endpoint
+	submit    SubmitFunc
+}
+
+func NewPoller(db *gorm.DB, endpoints []*Endpoint, submit SubmitFunc) *Poller {
+	return &Poller{db: db, endpoints: endpoints, submit: submit}
+}
+
+// ClientID is the ID of the client representing a host
+func ClientID(host string) string {
+	return "vsphere-" + host
+}
+
+// RunOnce polls every endpoint at once and records the hosts of those that
+// answered
+func (p *Poller) RunOnce(ctx context.Context) error {
+	results := make([][]Host, len(p.endpoints))
+	ok := make([]bool, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// One unreachable vCenter should not stop the others
+			hosts, err := endpoint.Hosts(ctx)
+			if err != nil {
+				log.Printf("vSphere poll: %s: %v", endpoint.Name(), err)
+				return
+			}
+			results[i], ok[i] = hosts, true
+		}()
+	}
+	wg.Wait()
+
+	polled, answered := 0, 0
+	for i, hosts := range results {
+		if !ok[i] {
+			continue
+		}
+		answered++
+		endpoint := p.endpoints[i]
+		for j := range hosts {
+			host := &hosts[j]
+			client, err := p.ensureClient(ctx, endpoint, host)
+			if err != nil {
+				return fmt.Errorf("failed to register client %s: %w", ClientID(host.Name), err)
+			}
+			if err := p.updateFans(ctx, client, host.Sensors); err != nil {
+				return err
+			}
+			if readings := p.readings(host); len(readings) > 0 {
+				if _, err := p.submit(ctx, &temperaturev1.SubmitTemperatureRequest{Readings: readings}); err != nil {
+					return fmt.Errorf("failed to record readings of %s: %w", host.Name, err)
+				}
+			}
+			polled++
+		}
+	}
+	log.Printf("vSphere poll: %d hosts read, %d of %d endpoints answered", polled, answered, len(p.endpoints))
+	return nil
+}
+
+// readings converts a host's temperature sensors to readings of its client
+func (p *Poller) readings(host *Host) []*temperaturev1.TemperatureReading {
+	now := timestamppb.Now()
+	var readings []*temperaturev1.TemperatureReading
+	for _, s := range host.Sensors {
+		if s.Type != "temperature" {
+			continue
+		}
+		celsius := s.Reading
+		switch s.Units {
+		case "Degrees C":
+		case "Degrees F":
+			celsius = (s.Reading - 32) * 5 / 9
+		default:
+			continue
+		}
+		readings = append(readings, &temperaturev1.TemperatureReading{
+			SensorId:           s.ID,
+			ClientId:           ClientID(host.Name),
+			TemperatureCelsius: celsius,
+			Timestamp:          now,
+			SensorType:         sensorType(s.Name),
+			SensorName:         s.Name,
+		})
+	}
+	return readings
+}
+
+// sensorType guesses the type of a temperature sensor from its name
+func sensorType(name string) string {
+	// Numbers are dropped from words, so CPU1 matches cpu
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-' || r == '.'
+	})
+	for i, field := range fields {
+		fields[i] = strings.TrimRight(field, "0123456789")
+	}
+	words := " " + strings.Join(fields, " ") + " "
+	for _, t := range nameTypes {
+		if strings.Contains(words, " "+t.words+" ") {
+			return t.sensorType
+		}
+	}
+	return "OTHER"
+}
+
+// updateFans replaces the fan speeds in a client's metadata
+func (p *Poller) updateFans(ctx context.Context, client *models.Client, sensors []Sensor) error {
+	metadata := make(map[string]string)
+	if client.Metadata != "" {
+		json.Unmarshal([]byte(client.Metadata), &metadata)
+	}
+	updated := maps.Clone(metadata)
+	maps.DeleteFunc(updated, func(key, _ string) bool { return strings.HasPrefix(key, MetadataFanPrefix) })
+	for _, s := range sensors {
+		if s.Type != "fan" {
+			continue
+		}
+		updated[MetadataFanPrefix+s.Name] = strconv.FormatFloat(s.Reading, 'f', -1, 64) + " " + s.Units
+	}
+	if maps.Equal(metadata, updated) {
+		return nil
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	err = p.db.WithContext(ctx).Model(&models.Client{}).Where("id = ?", client.ID).UpdateColumn("metadata", string(data)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update metadata of client %s: %w", client.ClientID, err)
+	}
+	client.Metadata = string(data)
+	return nil
+}
+
+// ensureClient returns a host's client, registering it the first time the
+// host is read, approved whatever the enrollment mode
+func (p *Poller) ensureClient(ctx context.Context, endpoint *Endpoint, host *Host) (*models.Client, error) {
+	db := p.db.WithContext(ctx)
+	var client models.Client
+	err := db.Where("client_id = ?", ClientID(host.Name)).First(&client).Error
+	if err == nil {
+		return &client, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	metadata := map[string]string{"role": "hypervisor", MetadataEndpoint: endpoint.URL()}
+	if host.Vendor != "" {
+		metadata["vsphere.vendor"] = host.Vendor
+	}
+	if host.Model != "" {
+		metadata["vsphere.model"] = host.Model
+	}
+	data, _ := json.Marshal(metadata)
+	now := time.Now()
+	client = models.Client{
+		ClientID:  ClientID(host.Name),
+		Hostname:  host.Name,
+		FirstSeen: now,
+		LastSeen:  now,
+		IsOnline:  true,
+		Metadata:  string(data),
+		Status:    models.ClientStatusApproved,
+	}
+	// Hosts are often added to vCenter by address
+	if ip := net.ParseIP(host.Name); ip != nil {
+		client.IPAddress = ip.String()
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&client).Error; err != nil {
+			return err
+		}
+		activity.Client(tx, activity.ClientRegistered, &client, "vSphere polling of host "+host.Name+" through "+endpoint.URL()+" started")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
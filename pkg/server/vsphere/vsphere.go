@@ -0,0 +1,346 @@
+// Package vsphere polls the hardware sensors of VMware ESXi hosts through the
+// vSphere API of a vCenter Server or a standalone host, reporting each host's
+// temperatures and fan speeds under a client representing it.
+package vsphere
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EndpointConfig is how to reach a vCenter Server or ESXi host
+type EndpointConfig struct {
+	URL string // e.g. https://vcenter.example.com
+	// Account the inventory is read with; the read-only role is enough
+	Username string
+	Password string
+	// CA bundle the API's certificate is checked against; empty uses the
+	// system roots
+	CAFile string
+	// Skip certificate verification, for the self-signed certificates vCenter
+	// and ESXi install by default
+	InsecureSkipVerify bool
+}
+
+// Endpoint reads hosts through the vSphere API, keeping its session between
+// polls
+type Endpoint struct {
+	cfg  EndpointConfig
+	sdk  string
+	http *http.Client
+
+	mu      sync.Mutex
+	content *serviceContent // Nil until logged in
+}
+
+// Sensor is a numeric hardware sensor of a host
+type Sensor struct {
+	ID      string
+	Name    string
+	Type    string  // temperature, fan, voltage...
+	Reading float64 // In Units, scaled
+	Units   string  // e.g. Degrees C, RPM
+	Health  string  // green, yellow, red or unknown
+}
+
+// Host is an ESXi host and its sensors
+type Host struct {
+	Name    string
+	Vendor  string
+	Model   string
+	Sensors []Sensor
+}
+
+func NewEndpoint(cfg EndpointConfig, timeout time.Duration) (*Endpoint, error) {
+	if cfg.URL == "" || cfg.Username == "" {
+		return nil, fmt.Errorf("vsphere url and username are required")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	// The session is a cookie
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &Endpoint{
+		cfg: cfg,
+		sdk: cfg.URL + "/sdk",
+		http: &http.Client{
+			Timeout:   timeout,
+			Jar:       jar,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+func (e *Endpoint) Name() string {
+	return "vsphere " + e.cfg.URL
+}
+
+func (e *Endpoint) URL() string {
+	return e.cfg.URL
+}
+
+// ref is a managed object reference
+type ref struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type serviceContent struct {
+	RootFolder        ref `xml:"rootFolder"`
+	PropertyCollector ref `xml:"propertyCollector"`
+	ViewManager       ref `xml:"viewManager"`
+	SessionManager    ref `xml:"sessionManager"`
+}
+
+// Host properties read; the sensors are those the host's CIM providers
+// report, the same shown under Monitor > Hardware Health
+var hostProperties = []string{
+	"name",
+	"runtime.connectionState",
+	"summary.hardware.vendor",
+	"summary.hardware.model",
+	"runtime.healthSystemRuntime.systemHealthInfo.numericSensorInfo",
+}
+
+// Hosts reads the sensors of every connected host, logging in first when
+// there is no session or it has expired
+func (e *Endpoint) Hosts(ctx context.Context) ([]Host, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.content == nil {
+		if err := e.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+	hosts, err := e.hosts(ctx)
+	var f *fault
+	if errors.As(err, &f) && f.notAuthenticated() {
+		// Sessions expire after 30 minutes idle, or when vCenter restarts
+		if err := e.login(ctx); err != nil {
+			return nil, err
+		}
+		hosts, err = e.hosts(ctx)
+	}
+	return hosts, err
+}
+
+func (e *Endpoint) login(ctx context.Context) error {
+	e.content = nil
+	var content struct {
+		Content serviceContent `xml:"returnval"`
+	}
+	err := e.call(ctx, `<RetrieveServiceContent xmlns="urn:vim25"><_this type="ServiceInstance">ServiceInstance</_this></RetrieveServiceContent>`, &content)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(`<Login xmlns="urn:vim25">%s<userName>%s</userName><password>%s</password></Login>`,
+		this(content.Content.SessionManager), escape(e.cfg.Username), escape(e.cfg.Password))
+	if err := e.call(ctx, body, nil); err != nil {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+	e.content = &content.Content
+	return nil
+}
+
+func (e *Endpoint) hosts(ctx context.Context) ([]Host, error) {
+	var view struct {
+		View ref `xml:"returnval"`
+	}
+	body := fmt.Sprintf(`<CreateContainerView xmlns="urn:vim25">%s<container type="%s">%s</container><type>HostSystem</type><recursive>true</recursive></CreateContainerView>`,
+		this(e.content.ViewManager), e.content.RootFolder.Type, escape(e.content.RootFolder.Value))
+	if err := e.call(ctx, body, &view); err != nil {
+		return nil, err
+	}
+	defer e.call(context.WithoutCancel(ctx), fmt.Sprintf(`<DestroyView xmlns="urn:vim25">%s</DestroyView>`, this(view.View)), nil)
+
+	var paths strings.Builder
+	for _, path := range hostProperties {
+		fmt.Fprintf(&paths, "<pathSet>%s</pathSet>", path)
+	}
+	body = fmt.Sprintf(`<RetrievePropertiesEx xmlns="urn:vim25" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">%s<specSet>`+
+		`<propSet><type>HostSystem</type>%s</propSet>`+
+		`<objectSet><obj type="ContainerView">%s</obj><skip>true</skip>`+
+		`<selectSet xsi:type="TraversalSpec"><name>view</name><type>ContainerView</type><path>view</path><skip>false</skip></selectSet>`+
+		`</objectSet></specSet><options></options></RetrievePropertiesEx>`,
+		this(e.content.PropertyCollector), paths.String(), escape(view.View.Value))
+
+	var hosts []Host
+	for {
+		var result struct {
+			Token   string `xml:"returnval>token"`
+			Objects []struct {
+				Properties []property `xml:"propSet"`
+			} `xml:"returnval>objects"`
+		}
+		if err := e.call(ctx, body, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Objects {
+			if host, ok := parseHost(object.Properties); ok {
+				hosts = append(hosts, host)
+			}
+		}
+		// Large inventories come back in pages
+		if result.Token == "" {
+			return hosts, nil
+		}
+		body = fmt.Sprintf(`<ContinueRetrievePropertiesEx xmlns="urn:vim25">%s<token>%s</token></ContinueRetrievePropertiesEx>`,
+			this(e.content.PropertyCollector), escape(result.Token))
+	}
+}
+
+type property struct {
+	Name string `xml:"name"`
+	Val  struct {
+		Text    string          `xml:",chardata"`
+		Sensors []numericSensor `xml:"HostNumericSensorInfo"`
+	} `xml:"val"`
+}
+
+type numericSensor struct {
+	ID           string `xml:"id"` // Since vSphere 8.0
+	Name         string `xml:"name"`
+	HealthState  string `xml:"healthState>key"`
+	Reading      int64  `xml:"currentReading"`
+	UnitModifier int    `xml:"unitModifier"` // Power of ten the reading is scaled by
+	BaseUnits    string `xml:"baseUnits"`
+	SensorType   string `xml:"sensorType"`
+}
+
+// parseHost reads a host's properties, reporting false for hosts that are
+// disconnected or not responding, whose sensors are stale
+func parseHost(properties []property) (Host, bool) {
+	var host Host
+	for _, p := range properties {
+		switch p.Name {
+		case "name":
+			host.Name = p.Val.Text
+		case "runtime.connectionState":
+			if p.Val.Text != "connected" {
+				return host, false
+			}
+		case "summary.hardware.vendor":
+			host.Vendor = strings.TrimSpace(p.Val.Text)
+		case "summary.hardware.model":
+			host.Model = strings.TrimSpace(p.Val.Text)
+		case "runtime.healthSystemRuntime.systemHealthInfo.numericSensorInfo":
+			for _, s := range p.Val.Sensors {
+				// Sensors the host cannot read report an unknown state
+				if s.HealthState == "unknown" {
+					continue
+				}
+				// Older hosts append the state to the name, e.g.
+				// "CPU1 Temp --- Normal"
+				name, _, _ := strings.Cut(s.Name, " --- ")
+				id := s.ID
+				if id == "" {
+					id = name
+				}
+				host.Sensors = append(host.Sensors, Sensor{
+					ID:      id,
+					Name:    name,
+					Type:    strings.ToLower(s.SensorType),
+					Reading: float64(s.Reading) * math.Pow10(s.UnitModifier),
+					Units:   s.BaseUnits,
+					Health:  s.HealthState,
+				})
+			}
+		}
+	}
+	return host, host.Name != ""
+}
+
+// fault is a SOAP fault returned by the API
+type fault struct {
+	String string `xml:"faultstring"`
+	Detail struct {
+		Inner []byte `xml:",innerxml"`
+	} `xml:"detail"`
+}
+
+func (f *fault) Error() string {
+	return f.String
+}
+
+func (f *fault) notAuthenticated() bool {
+	return bytes.Contains(f.Detail.Inner, []byte("NotAuthenticated"))
+}
+
+// call sends a request body to the API and decodes the response body into
+// out
+func (e *Endpoint) call(ctx context.Context, body string, out interface{}) error {
+	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body>` +
+		body + `</soapenv:Body></soapenv:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.sdk, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "urn:vim25/7.0")
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Body struct {
+			Fault *fault `xml:"Fault"`
+			Inner []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	// Faults come with a 500 status and an envelope
+	if err := xml.NewDecoder(resp.Body).Decode(&response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if response.Body.Fault != nil {
+		return response.Body.Fault
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	// Decode the response element, whatever its name
+	return xml.Unmarshal(response.Body.Inner, out)
+}
+
+// this is the reference to the object a method is called on
+func this(r ref) string {
+	return fmt.Sprintf(`<_this type="%s">%s</_this>`, r.Type, escape(r.Value))
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
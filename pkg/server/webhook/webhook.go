@@ -0,0 +1,352 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/jacuzzi/pkg/server/models"
+	"gorm.io/gorm"
+)
+
+// Event types webhooks can subscribe to
+const (
+	EventClientRegistered = "client.registered"
+	EventClientOffline    = "client.offline"
+	EventSensorRetired    = "sensor.retired"
+	EventSettingsChanged  = "settings.changed"
+
+	EventMigrationRequested = "hypervisor.migration_requested"
+)
+
+// EventTypes lists every event type
+var EventTypes = []string{
+	EventClientRegistered,
+	EventClientOffline,
+	EventSensorRetired,
+	EventSettingsChanged,
+	EventMigrationRequested,
+}
+
+// Request headers sent with every delivery
+const (
+	HeaderEvent     = "X-Jacuzzi-Event"
+	HeaderDelivery  = "X-Jacuzzi-Delivery"
+	HeaderSignature = "X-Jacuzzi-Signature" // sha256=<hex HMAC of the body>, when the webhook has a secret
+)
+
+// retryDelays are the waits before each retry of a failed delivery
+var retryDelays = []time.Duration{5 * time.Second, 30 * time.Second}
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Client describes the client an event is about
+type Client struct {
+	ClientID string    `json:"client_id"`
+	Hostname string    `json:"hostname"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+	Location *Location `json:"location,omitempty"` // Unset until assigned
+}
+
+// Location describes where a client is installed
+type Location struct {
+	Site        string   `json:"site,omitempty"`
+	Rack        string   `json:"rack,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Sensor describes the sensor an event is about
+type Sensor struct {
+	ClientID      string     `json:"client_id"`
+	SensorID      string     `json:"sensor_id"`
+	SensorType    string     `json:"sensor_type"`
+	SensorName    string     `json:"sensor_name"`
+	LastReadingAt *time.Time `json:"last_reading_at"`
+}
+
+// Settings lists the settings an event changed. Values are left out since
+// some, such as the SMTP password, are secret.
+type Settings struct {
+	Keys []string `json:"keys"`
+}
+
+// Alert describes the alert an event is about
+type Alert struct {
+	AlertID     string     `json:"alert_id"`
+	RuleID      string     `json:"rule_id"`
+	SensorID    string     `json:"sensor_id"`
+	Severity    string     `json:"severity"`
+	Value       float64    `json:"value"`
+	Message     string     `json:"message"`
+	TriggeredAt time.Time  `json:"triggered_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"` // Set once resolved
+}
+
+// Hypervisor describes the hypervisor host a client runs on
+type Hypervisor struct {
+	Provider   string `json:"provider"` // proxmox or libvirt
+	Host       string `json:"host"`
+	VMsRunning int    `json:"vms_running"`
+}
+
+// Migration asks for the VMs on a hypervisor host with a critical alert to be
+// moved elsewhere
+type Migration struct {
+	Client     Client     `json:"client"`
+	Hypervisor Hypervisor `json:"hypervisor"`
+	Alert      Alert      `json:"alert"`
+}
+
+// Dispatcher delivers events to the webhooks subscribed to them. Events are
+// delivered in the background, so emitting never blocks the caller. A nil
+// Dispatcher drops every event.
+type Dispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(db *gorm.DB, timeout time.Duration) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		db:     db,
+		client: &http.Client{Timeout: timeout},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Close abandons pending retries and waits for deliveries in flight
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) ClientRegistered(client *models.Client) {
+	d.Emit(EventClientRegistered, ClientData(client))
+}
+
+func (d *Dispatcher) ClientOffline(client *models.Client) {
+	d.Emit(EventClientOffline, ClientData(client))
+}
+
+func (d *Dispatcher) SensorRetired(sensor *models.Sensor) {
+	d.Emit(EventSensorRetired, Sensor{
+		ClientID:      sensor.ClientID,
+		SensorID:      sensor.SensorID,
+		SensorType:    sensor.SensorType,
+		SensorName:    sensor.SensorName,
+		LastReadingAt: sensor.LastReadingAt,
+	})
+}
+
+func (d *Dispatcher) SettingsChanged(keys []string) {
+	d.Emit(EventSettingsChanged, Settings{Keys: keys})
+}
+
+func (d *Dispatcher) MigrationRequested(client *models.Client, alert *models.Alert, host Hypervisor) {
+	d.Emit(EventMigrationRequested, Migration{
+		Client:     ClientData(client),
+		Hypervisor: host,
+		Alert: Alert{
+			AlertID:     alert.AlertID,
+			RuleID:      alert.RuleID,
+			SensorID:    alert.SensorID,
+			Severity:    alert.Severity,
+			Value:       alert.Value,
+			Message:     alert.Message,
+			TriggeredAt: alert.TriggeredAt,
+		},
+	})
+}
+
+// ClientData describes a client for an event
+func ClientData(client *models.Client) Client {
+	data := Client{
+		ClientID: client.ClientID,
+		Hostname: client.Hostname,
+		Status:   client.Status,
+		LastSeen: client.LastSeen,
+	}
+	if client.Site != "" || client.Rack != "" || client.Latitude != nil || client.LocationDescription != "" {
+		data.Location = &Location{
+			Site:        client.Site,
+			Rack:        client.Rack,
+			Latitude:    client.Latitude,
+			Longitude:   client.Longitude,
+			Description: client.LocationDescription,
+		}
+	}
+	return data
+}
+
+// Emit delivers an event to every enabled webhook subscribed to its type
+func (d *Dispatcher) Emit(eventType string, data interface{}) {
+	if d == nil || d.ctx.Err() != nil {
+		return
+	}
+	event := Event{
+		ID:   uuid.New().String(),
+		Type: eventType,
+		Time: time.Now().UTC(),
+		Data: data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", eventType, err)
+		return
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		webhooks, err := d.subscribers(eventType)
+		if err != nil {
+			log.Printf("Failed to load webhooks for %s event: %v", eventType, err)
+			return
+		}
+		for _, hook := range webhooks {
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				d.deliver(hook, event, body)
+			}()
+		}
+	}()
+}
+
+// subscribers returns the enabled webhooks subscribed to an event type
+func (d *Dispatcher) subscribers(eventType string) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	if err := d.db.WithContext(d.ctx).Where("enabled = ?", true).Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	var subscribed []models.Webhook
+	for _, hook := range webhooks {
+		types, err := DecodeEventTypes(hook.EventTypes)
+		if err != nil {
+			log.Printf("Skipping webhook %s with invalid event types: %v", hook.WebhookID, err)
+			continue
+		}
+		if len(types) == 0 || slices.Contains(types, eventType) {
+			subscribed = append(subscribed, hook)
+		}
+	}
+	return subscribed, nil
+}
+
+// deliver posts an event to a webhook, retrying failures, and records the
+// outcome of the last attempt on the webhook
+func (d *Dispatcher) deliver(hook models.Webhook, event Event, body []byte) {
+	var result string
+	for attempt := 0; ; attempt++ {
+		var err error
+		result, err = d.post(hook, event, body)
+		if err == nil || attempt == len(retryDelays) {
+			if err != nil {
+				log.Printf("Webhook %s failed to receive %s event %s: %v", hook.WebhookID, event.Type, event.ID, err)
+			}
+			break
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(retryDelays[attempt]):
+		}
+	}
+
+	now := time.Now()
+	err := d.db.Model(&models.Webhook{}).
+		Where("id = ?", hook.ID).
+		UpdateColumns(map[string]interface{}{"last_delivery_at": now, "last_status": result}).Error
+	if err != nil {
+		log.Printf("Failed to record delivery for webhook %s: %v", hook.WebhookID, err)
+	}
+}
+
+// post sends one delivery attempt and describes its result
+func (d *Dispatcher) post(hook models.Webhook, event Event, body []byte) (string, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err.Error(), err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jacuzzi-webhook")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.ID)
+	if hook.Secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(hook.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err.Error(), err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.Status, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Status, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of a delivery body, for receivers to
+// compare against the signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncodeEventTypes stores event types on a webhook, checking each is known
+func EncodeEventTypes(types []string) (string, error) {
+	if len(types) == 0 {
+		return "", nil
+	}
+	for _, t := range types {
+		if !slices.Contains(EventTypes, t) {
+			return "", fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	data, err := json.Marshal(types)
+	return string(data), err
+}
+
+// DecodeEventTypes reads the event types stored on a webhook
+func DecodeEventTypes(stored string) ([]string, error) {
+	if stored == "" {
+		return nil, nil
+	}
+	var types []string
+	err := json.Unmarshal([]byte(stored), &types)
+	return types, err
+}
@@ -0,0 +1,118 @@
+// Package systemlog writes structured entries to the local system log:
+// journald or syslog on Linux and macOS, and the Event Log on Windows.
+package systemlog
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSource is the syslog tag or Event Log source of entries that name
+// none
+const DefaultSource = "jacuzzi"
+
+// Level is the severity of an entry
+type Level int
+
+const (
+	Info Level = iota
+	Warning
+	Critical // An error in the Event Log, which has no critical level
+)
+
+// Entry is a message and the fields that describe it
+type Entry struct {
+	// The syslog tag and journald SYSLOG_IDENTIFIER, or the Event Log source
+	Source string
+	// syslog facility, such as daemon or local0; empty is daemon. The Event
+	// Log has none.
+	Facility string
+	Level    Level
+	// Event Log event ID, 1-1000; a field in other logs
+	EventID uint32
+	Message string
+	// Keys are lowercase letters, digits and underscores; journald fields are
+	// named JACUZZI_<KEY>
+	Fields map[string]string
+}
+
+// facilities numbers the syslog facilities entries may use
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var (
+	sourcePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	fieldPattern  = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+)
+
+// ValidSource reports whether a name can be a syslog tag and Event Log source
+func ValidSource(source string) bool {
+	return sourcePattern.MatchString(source)
+}
+
+// ValidFacility reports whether a syslog facility is known
+func ValidFacility(facility string) bool {
+	_, ok := facilities[facility]
+	return ok
+}
+
+// ValidField reports whether a name can be a field key
+func ValidField(key string) bool {
+	return fieldPattern.MatchString(key)
+}
+
+// Write adds an entry to the local system log
+func Write(entry Entry) error {
+	if entry.Source == "" {
+		entry.Source = DefaultSource
+	}
+	if !ValidSource(entry.Source) {
+		return fmt.Errorf("invalid source %q: must be 1-64 letters, digits, dots, underscores or hyphens", entry.Source)
+	}
+	if entry.Facility == "" {
+		entry.Facility = "daemon"
+	}
+	if !ValidFacility(entry.Facility) {
+		return fmt.Errorf("unknown syslog facility %q", entry.Facility)
+	}
+	if entry.EventID < 1 || entry.EventID > 1000 {
+		return fmt.Errorf("invalid event ID %d: must be between 1 and 1000", entry.EventID)
+	}
+	for key := range entry.Fields {
+		if !ValidField(key) {
+			return fmt.Errorf("invalid field %q: must be lowercase letters, digits and underscores", key)
+		}
+	}
+	return write(entry)
+}
+
+// text renders an entry as its message followed by its fields as sorted
+// key=value pairs, quoted where needed, for logs without fields of their own
+func (e Entry) text() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	fields := make(map[string]string, len(e.Fields)+1)
+	for key, value := range e.Fields {
+		fields[key] = value
+	}
+	fields["event_id"] = strconv.FormatUint(uint64(e.EventID), 10)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := fields[key]
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=\\") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
+}
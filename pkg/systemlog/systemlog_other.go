@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package systemlog
+
+import "fmt"
+
+func write(entry Entry) error {
+	return fmt.Errorf("system logs are not supported on this platform")
+}
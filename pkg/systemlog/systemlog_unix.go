@@ -0,0 +1,88 @@
+//go:build linux || darwin
+
+package systemlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// journalSocket accepts native journald entries where systemd runs
+const journalSocket = "/run/systemd/journal/socket"
+
+// priorities are the syslog severities of entry levels
+var priorities = map[Level]syslog.Priority{
+	Info:     syslog.LOG_INFO,
+	Warning:  syslog.LOG_WARNING,
+	Critical: syslog.LOG_CRIT,
+}
+
+// write sends an entry to journald, keeping its fields, or to syslog where
+// there is no journal
+func write(entry Entry) error {
+	if _, err := os.Stat(journalSocket); err == nil {
+		return writeJournal(entry)
+	}
+	return writeSyslog(entry)
+}
+
+func writeJournal(entry Entry) error {
+	var b bytes.Buffer
+	field := func(name, value string) {
+		// Values with newlines are sent with their length instead
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&b, "%s=%s\n", name, value)
+			return
+		}
+		b.WriteString(name + "\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+	}
+	field("MESSAGE", entry.Message)
+	field("PRIORITY", strconv.Itoa(int(priorities[entry.Level])))
+	field("SYSLOG_IDENTIFIER", entry.Source)
+	field("SYSLOG_FACILITY", strconv.Itoa(facilities[entry.Facility]))
+	field("JACUZZI_EVENT_ID", strconv.FormatUint(uint64(entry.EventID), 10))
+	for key, value := range entry.Fields {
+		field("JACUZZI_"+strings.ToUpper(key), value)
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("failed to write to journald: %w", err)
+	}
+	return nil
+}
+
+func writeSyslog(entry Entry) error {
+	// The facility sits above the severity bits
+	facility := syslog.Priority(facilities[entry.Facility] << 3)
+	w, err := syslog.Dial("", "", facility|priorities[entry.Level], entry.Source)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	defer w.Close()
+	text := entry.text()
+	switch entry.Level {
+	case Critical:
+		err = w.Crit(text)
+	case Warning:
+		err = w.Warning(text)
+	default:
+		err = w.Info(text)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write to syslog: %w", err)
+	}
+	return nil
+}
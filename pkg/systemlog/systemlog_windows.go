@@ -0,0 +1,41 @@
+//go:build windows
+
+package systemlog
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// installed holds the sources registered by this process
+var installed sync.Map
+
+// write adds an entry to the Application log
+func write(entry Entry) error {
+	// Registering the source lets Event Viewer show the message rather than a
+	// missing description. It needs administrator rights and fails once the
+	// source exists, so failures are ignored.
+	if _, ok := installed.LoadOrStore(entry.Source, true); !ok {
+		eventlog.InstallAsEventCreate(entry.Source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	}
+	l, err := eventlog.Open(entry.Source)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer l.Close()
+	text := entry.text()
+	switch entry.Level {
+	case Critical:
+		err = l.Error(entry.EventID, text)
+	case Warning:
+		err = l.Warning(entry.EventID, text)
+	default:
+		err = l.Info(entry.EventID, text)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write to event log: %w", err)
+	}
+	return nil
+}